@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image"
+	"io"
+	"io/ioutil"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/image/webp"
+
+	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// The standard library neither implements WebP nor zstd and brotli, so the
+// server registers the codecs of pure Go packages for them. There is no pure
+// Go codec for AVIF, which is registered by codecs_avif.go in servers that are
+// built with the avif tag.
+func init() {
+	handlers.RegisterImageCodec(mbtiles.WEBP, webp.Decode, encodeWebP)
+	mbtiles.RegisterDecoder(mbtiles.ZSTDENC, decodeZstd)
+	mbtiles.RegisterDecoder(mbtiles.BROTLIENC, decodeBrotli)
+}
+
+// encodeWebP encodes img as lossless WebP image, ignoring the quality.
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	return nativewebp.Encode(w, img, nil)
+}
+
+// decodeZstd returns a reader that decompresses the zstd stream of r.
+func decodeZstd(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// decodeBrotli returns a reader that decompresses the brotli stream of r.
+func decodeBrotli(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(brotli.NewReader(r)), nil
+}
@@ -0,0 +1,136 @@
+//go:build avif
+// +build avif
+
+package main
+
+/*
+#cgo pkg-config: libavif
+#include <stdlib.h>
+#include <avif/avif.h>
+
+// avif_decode decodes the first image of data into RGBA pixels with
+// unassociated alpha, which are allocated with malloc.
+static avifResult avif_decode(const uint8_t *data, size_t size, uint8_t **pixels, uint32_t *width, uint32_t *height) {
+	avifDecoder *dec = avifDecoderCreate();
+	if (!dec) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	avifResult res = avifDecoderSetIOMemory(dec, data, size);
+	if (res == AVIF_RESULT_OK) {
+		res = avifDecoderParse(dec);
+	}
+	if (res == AVIF_RESULT_OK) {
+		res = avifDecoderNextImage(dec);
+	}
+	if (res == AVIF_RESULT_OK) {
+		avifRGBImage rgb;
+		avifRGBImageSetDefaults(&rgb, dec->image);
+		rgb.format = AVIF_RGB_FORMAT_RGBA;
+		rgb.depth = 8;
+		rgb.rowBytes = rgb.width * 4;
+		rgb.pixels = malloc((size_t)rgb.rowBytes * rgb.height);
+		if (!rgb.pixels) {
+			res = AVIF_RESULT_OUT_OF_MEMORY;
+		} else if ((res = avifImageYUVToRGB(dec->image, &rgb)) != AVIF_RESULT_OK) {
+			free(rgb.pixels);
+		} else {
+			*pixels = rgb.pixels;
+			*width = rgb.width;
+			*height = rgb.height;
+		}
+	}
+	avifDecoderDestroy(dec);
+	return res;
+}
+
+// avif_encode encodes the RGBA pixels with unassociated alpha with the
+// quality from 0 to 100 into out, which must be freed with avifRWDataFree.
+static avifResult avif_encode(const uint8_t *pixels, uint32_t width, uint32_t height, uint32_t rowBytes, int quality, avifRWData *out) {
+	avifImage *image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV444);
+	if (!image) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = (uint8_t *)pixels;
+	rgb.rowBytes = rowBytes;
+	avifResult res = avifImageRGBToYUV(image, &rgb);
+	if (res == AVIF_RESULT_OK) {
+		avifEncoder *enc = avifEncoderCreate();
+		if (!enc) {
+			res = AVIF_RESULT_OUT_OF_MEMORY;
+		} else {
+			enc->quality = quality;
+			enc->qualityAlpha = quality;
+			res = avifEncoderWrite(enc, image, out);
+			avifEncoderDestroy(enc);
+		}
+	}
+	avifImageDestroy(image);
+	return res;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"unsafe"
+
+	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// Servers that are built with the avif tag decode and encode AVIF tiles with
+// libavif 1.0 or later, so that tiles can be converted to and from AVIF.
+func init() {
+	handlers.RegisterImageCodec(mbtiles.AVIF, decodeAVIF, encodeAVIF)
+}
+
+// avifError returns the error of the libavif result res.
+func avifError(res C.avifResult) error {
+	return fmt.Errorf("avif: %s", C.GoString(C.avifResultToString(res)))
+}
+
+// decodeAVIF decodes the first image of the AVIF data of r.
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("avif: empty image")
+	}
+	var pixels *C.uint8_t
+	var width, height C.uint32_t
+	if res := C.avif_decode((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &pixels, &width, &height); res != C.AVIF_RESULT_OK {
+		return nil, avifError(res)
+	}
+	defer C.free(unsafe.Pointer(pixels))
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, C.GoBytes(unsafe.Pointer(pixels), C.int(len(img.Pix))))
+	return img, nil
+}
+
+// encodeAVIF encodes img as AVIF image with the quality from 1 to 100.
+func encodeAVIF(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return errors.New("avif: empty image")
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
+	var out C.avifRWData
+	if res := C.avif_encode((*C.uint8_t)(unsafe.Pointer(&m.Pix[0])), C.uint32_t(b.Dx()), C.uint32_t(b.Dy()), C.uint32_t(m.Stride), C.int(quality), &out); res != C.AVIF_RESULT_OK {
+		return avifError(res)
+	}
+	defer C.avifRWDataFree(&out)
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(out.data), C.int(out.size)))
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build avif
+// +build avif
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAVIFTiles(t *testing.T) {
+	_, e := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		accept string
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.avif", ""},
+		{"/services/geography-class-jpg/tiles/1/0/0.avif", ""},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/avif,image/webp,image/*,*/*;q=0.8"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/avif" {
+			t.Errorf("%s (Accept: %s): expected content type image/avif, got %s", tc.path, tc.accept, ct)
+		}
+		img, err := decodeAVIF(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if size := img.Bounds().Dx(); size != 256 {
+			t.Errorf("%s: expected tile of 256 pixels, got %d", tc.path, size)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/image/webp"
+
+	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+func TestWebPTiles(t *testing.T) {
+	_, e := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		accept string
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.webp", ""},
+		{"/services/geography-class-jpg/tiles/1/0/0.webp", ""},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/webp,image/*,*/*;q=0.8"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/webp" {
+			t.Errorf("%s (Accept: %s): expected content type image/webp, got %s", tc.path, tc.accept, ct)
+		}
+		img, err := webp.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if size := img.Bounds().Dx(); size != 256 {
+			t.Errorf("%s: expected tile of 256 pixels, got %d", tc.path, size)
+		}
+	}
+}
+
+func TestCompressedTiles(t *testing.T) {
+	tile := []byte("\x1a\x0b\x0a\x05water\x28\x80\x20\x78\x02")
+
+	var zstdData bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(tile)
+	zw.Close()
+
+	var brotliData bytes.Buffer
+	bw := brotli.NewWriter(&brotliData)
+	bw.Write(tile)
+	bw.Close()
+
+	tests := []struct {
+		compression string
+		data        []byte
+	}{
+		{"zstd", zstdData.Bytes()},
+		{"br", brotliData.Bytes()},
+	}
+	for _, tc := range tests {
+		// served as gzip to clients that do not accept the stored encoding
+		filename := filepath.Join(t.TempDir(), tc.compression+".mbtiles")
+		w, err := mbtiles.CreateDB(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteMetadata("format", "pbf")
+		w.WriteMetadata("compression", tc.compression)
+		w.WriteTile(0, 0, 0, tc.data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		svcSet := handlers.New()
+		defer svcSet.Close()
+		if err := svcSet.AddDBOnPath(filename, tc.compression); err != nil {
+			t.Fatal(err)
+		}
+		e := newServer(svcSet, func(err error) { t.Error(err) })
+
+		req := httptest.NewRequest("GET", "/services/"+tc.compression+"/tiles/0/0/0.pbf", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.compression, http.StatusOK, rec.Code)
+			continue
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("%s: expected Content-Encoding gzip, got %q", tc.compression, ce)
+			continue
+		}
+		r, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, tile) {
+			t.Errorf("%s: expected %x, got %x", tc.compression, tile, data)
+		}
+	}
+}
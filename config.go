@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/consbio/mbtileserver/config"
+	"github.com/consbio/mbtileserver/handlers"
+)
+
+// applyConfig sets the flags that have not been set on the command line to
+// the options of the configuration c.
+func applyConfig(flags *pflag.FlagSet, c *config.Config) error {
+	for name, values := range c.Options {
+		f := flags.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown option %s in configuration file", name)
+		}
+		if f.Changed {
+			continue
+		}
+		for _, v := range values {
+			if err := flags.Set(name, v); err != nil {
+				return fmt.Errorf("invalid option %s in configuration file: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reloadConfig reads the configuration file filename again and replaces the
+// policies of the tilesets of svcSet. The options of the configuration loaded
+// on startup are kept.
+func reloadConfig(svcSet *handlers.ServiceSet, filename string, loaded *config.Config) {
+	c, err := config.Load(filename)
+	if err != nil {
+		log.Errorf("Could not reload configuration: %v", err)
+		return
+	}
+	svcSet.SetPolicies(c.Tilesets)
+	log.Infof("Reloaded configuration from %s with policies of %d tilesets", filename, len(c.Tilesets))
+	if !reflect.DeepEqual(c.Options, loaded.Options) {
+		log.Warnf("Changed options in %s take effect after a restart", filename)
+	}
+}
@@ -0,0 +1,163 @@
+// Package config reads the configuration files of mbtileserver, which set
+// the global options as well as the policies of individual tilesets. They
+// are written in TOML:
+//
+//	# global options, with the names and values of the command line flags
+//	port = 8080
+//	dir = ["/data/tiles", "/data/base=base"]
+//	cachesize = 250
+//
+//	# per-tileset settings, by the IDs of the tilesets
+//	[tilesets.world]
+//	name = "World"
+//	attribution = "© OpenStreetMap contributors"
+//	cache_ttl = "24h"
+//	public = true
+//	minzoom = 2
+//	maxzoom = 12
+//
+//	[tilesets."base/streets"]
+//	keys = ["partner-key"]
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/consbio/mbtileserver/handlers"
+)
+
+// Config is the content of a configuration file.
+type Config struct {
+	// Options are the values of the global options by the names of their
+	// command line flags. Arrays have one value per element.
+	Options map[string][]string
+	// Tilesets are the policies of the tilesets by their IDs.
+	Tilesets map[string]*handlers.TilesetPolicy
+}
+
+// Load reads the configuration file filename.
+func Load(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", filename, err)
+	}
+	return c, nil
+}
+
+// Parse parses the content data of a configuration file.
+func Parse(data []byte) (*Config, error) {
+	doc, err := parseTOML(data)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		Options:  make(map[string][]string),
+		Tilesets: make(map[string]*handlers.TilesetPolicy),
+	}
+	for k, v := range doc {
+		if k == "tilesets" {
+			tilesets, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("tilesets must be a table")
+			}
+			for id, v := range tilesets {
+				settings, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("tileset %s must be a table", id)
+				}
+				if c.Tilesets[id], err = parsePolicy(settings); err != nil {
+					return nil, fmt.Errorf("tileset %s: %v", id, err)
+				}
+			}
+			continue
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, v := range values {
+			s, err := optionValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("option %s: %v", k, err)
+			}
+			c.Options[k] = append(c.Options[k], s)
+		}
+	}
+	return c, nil
+}
+
+// optionValue returns the value v of an option as it is passed to its flag.
+func optionValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("expected string, number or boolean")
+}
+
+// parsePolicy returns the TilesetPolicy with the settings of a tileset.
+func parsePolicy(settings map[string]interface{}) (*handlers.TilesetPolicy, error) {
+	p := &handlers.TilesetPolicy{}
+	for k, v := range settings {
+		var ok bool
+		switch k {
+		case "name":
+			p.Name, ok = v.(string)
+		case "attribution":
+			p.Attribution, ok = v.(string)
+		case "cache_ttl":
+			// a duration like "1h" or a number of seconds
+			switch v := v.(type) {
+			case string:
+				d, err := time.ParseDuration(v)
+				p.CacheTTL, ok = d, err == nil && d >= 0
+			case int64:
+				p.CacheTTL, ok = time.Duration(v)*time.Second, v >= 0
+			}
+		case "public":
+			p.Public, ok = v.(bool)
+		case "keys":
+			var keys []interface{}
+			keys, ok = v.([]interface{})
+			for _, key := range keys {
+				s, isString := key.(string)
+				ok = ok && isString && s != ""
+				p.Keys = append(p.Keys, s)
+			}
+		case "minzoom", "maxzoom":
+			var z int64
+			if z, ok = v.(int64); ok && z >= 0 && z <= 30 {
+				zoom := int(z)
+				if k == "minzoom" {
+					p.MinZoom = &zoom
+				} else {
+					p.MaxZoom = &zoom
+				}
+			} else {
+				ok = false
+			}
+		default:
+			return nil, fmt.Errorf("unknown setting %s", k)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %v", k, v)
+		}
+	}
+	if p.MinZoom != nil && p.MaxZoom != nil && *p.MinZoom > *p.MaxZoom {
+		return nil, fmt.Errorf("minzoom %d exceeds maxzoom %d", *p.MinZoom, *p.MaxZoom)
+	}
+	return p, nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+# global options
+port = 8080
+dir = [
+	"/data/tiles",  # local tilesets
+	'/data/base=base',
+]
+verbose = true
+quality = 0.5e2
+
+[tilesets.world]
+name = "World \"Atlas\" é"
+attribution = '© OpenStreetMap contributors'
+cache_ttl = "24h"
+public = true
+minzoom = 2
+maxzoom = 1_2
+
+[tilesets."base/streets"]
+keys = ["a", "b"]
+cache_ttl = 60
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := map[string][]string{
+		"port":    {"8080"},
+		"dir":     {"/data/tiles", "/data/base=base"},
+		"verbose": {"true"},
+		"quality": {"50"},
+	}
+	if !reflect.DeepEqual(c.Options, options) {
+		t.Errorf("expected options %v, got %v", options, c.Options)
+	}
+	world := c.Tilesets["world"]
+	if world == nil || world.Name != `World "Atlas" é` || world.Attribution != "© OpenStreetMap contributors" ||
+		world.CacheTTL != 24*time.Hour || !world.Public || *world.MinZoom != 2 || *world.MaxZoom != 12 {
+		t.Errorf("unexpected policy of world: %+v", world)
+	}
+	streets := c.Tilesets["base/streets"]
+	if streets == nil || !reflect.DeepEqual(streets.Keys, []string{"a", "b"}) || streets.CacheTTL != time.Minute {
+		t.Errorf("unexpected policy of base/streets: %+v", streets)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		config, err string
+	}{
+		{"port = ", "expected value"},
+		{"port 8080", "expected ="},
+		{"port = 8080 8081", "end of line"},
+		{"port = 1\nport = 2", "line 2: duplicate key"},
+		{`name = "unterminated`, "unterminated string"},
+		{"dir = [\"a\"", "unterminated array"},
+		{"[[tilesets]]", "arrays of tables"},
+		{"[tilesets.world]\nzoom = 3", "unknown setting zoom"},
+		{"[tilesets.world]\nmaxzoom = 31", "invalid maxzoom"},
+		{"[tilesets.world]\nminzoom = 5\nmaxzoom = 4", "exceeds maxzoom"},
+		{"[tilesets.world]\ncache_ttl = \"soon\"", "invalid cache_ttl"},
+		{"tilesets = 1", "must be a table"},
+	} {
+		if _, err := Parse([]byte(tc.config)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.config, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlParser parses the subset of TOML that is used by configuration files:
+// tables, dotted and quoted keys, basic and literal strings, integers,
+// floats, booleans and arrays, which may span several lines. Multi-line
+// strings, dates, inline tables and arrays of tables are not supported.
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+// parseTOML parses the TOML document data into nested maps, whose values are
+// strings, int64, float64, bool, []interface{} or map[string]interface{}.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{s: string(data), line: 1}
+	root := make(map[string]interface{})
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.s) {
+			return root, nil
+		}
+		var err error
+		if p.s[p.pos] == '[' {
+			table, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(table)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+	}
+}
+
+// parseHeader parses a table header like [tilesets."base/world"] and returns
+// the table, which is created in root if it does not exist.
+func (p *tomlParser) parseHeader(root map[string]interface{}) (map[string]interface{}, error) {
+	p.pos++
+	if p.pos < len(p.s) && p.s[p.pos] == '[' {
+		return nil, fmt.Errorf("arrays of tables are not supported")
+	}
+	keys, err := p.parseKeys()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != ']' {
+		return nil, fmt.Errorf("expected ] after table name")
+	}
+	p.pos++
+	if err := p.endOfLine(); err != nil {
+		return nil, err
+	}
+	return subTable(root, keys)
+}
+
+// parseKeyValue parses a line like key = value into table.
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKeys()
+	if err != nil {
+		return err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+		return fmt.Errorf("expected = after key %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipSpace(false)
+	v, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	if err := p.endOfLine(); err != nil {
+		return err
+	}
+	t, err := subTable(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	k := keys[len(keys)-1]
+	if _, ok := t[k]; ok {
+		return fmt.Errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+	t[k] = v
+	return nil
+}
+
+// subTable returns the table at the path keys below table, creating the
+// tables that do not exist.
+func subTable(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for i, k := range keys {
+		switch v := table[k].(type) {
+		case nil:
+			t := make(map[string]interface{})
+			table[k] = t
+			table = t
+		case map[string]interface{}:
+			table = v
+		default:
+			return nil, fmt.Errorf("key %s is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// parseKeys parses a dotted key of bare and quoted keys.
+func (p *tomlParser) parseKeys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		var k string
+		var err error
+		switch {
+		case p.pos >= len(p.s):
+			return nil, fmt.Errorf("expected key")
+		case p.s[p.pos] == '"':
+			k, err = p.parseBasicString()
+		case p.s[p.pos] == '\'':
+			k, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+				p.pos++
+			}
+			if k = p.s[start:p.pos]; k == "" {
+				return nil, fmt.Errorf("invalid key at %q", p.rest())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		p.skipSpace(false)
+		if p.pos >= len(p.s) || p.s[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseValue parses a string, number, boolean or array.
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch c := p.s[p.pos]; {
+	case strings.HasPrefix(p.s[p.pos:], `"""`) || strings.HasPrefix(p.s[p.pos:], `'''`):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case c == '"':
+		return p.parseBasicString()
+	case c == '\'':
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (isBareKeyChar(p.s[p.pos]) || p.s[p.pos] == '.' || p.s[p.pos] == '+') {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.Replace(token, "_", "", -1)
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil && token != "" {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value at %q", p.s[start:start+len(token)]+p.rest())
+}
+
+// parseArray parses an array, whose elements may be on separate lines.
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.pos < len(p.s) && p.s[p.pos] == ']' {
+			p.pos++
+			return values, nil
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.skipSpace(true)
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] in array at %q", p.rest())
+		}
+	}
+}
+
+// parseBasicString parses a string in double quotes with escape sequences.
+func (p *tomlParser) parseBasicString() (string, error) {
+	var b bytes.Buffer
+	p.pos++
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("unterminated string")
+		case c != '\\':
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.s) {
+			break
+		}
+		e := p.s[p.pos+1]
+		p.pos += 2
+		switch e {
+		case '"', '\\':
+			b.WriteByte(e)
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'u', 'U':
+			n := 4
+			if e == 'U' {
+				n = 8
+			}
+			if p.pos+n > len(p.s) {
+				return "", fmt.Errorf("invalid escape sequence")
+			}
+			r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("invalid escape sequence \\%c%s", e, p.s[p.pos:p.pos+n])
+			}
+			b.WriteRune(rune(r))
+			p.pos += n
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c", e)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// parseLiteralString parses a string in single quotes without escapes.
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.s[p.pos:], "'\n")
+	if end < 0 || p.s[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// skipSpace skips spaces, tabs and comments, and also line breaks if
+// newlines is set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r':
+		case '\n':
+			if !newlines {
+				return
+			}
+			p.line++
+		case '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+// endOfLine skips the rest of the line, which must only contain spaces and a
+// comment.
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+	if p.pos < len(p.s) && p.s[p.pos] != '\n' {
+		return fmt.Errorf("unexpected %q at end of line", p.rest())
+	}
+	return nil
+}
+
+// rest returns the rest of the current line.
+func (p *tomlParser) rest() string {
+	rest := p.s[p.pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
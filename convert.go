@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <input> <output>",
+	Short: "Convert between mbtiles and PMTiles files",
+	Long: `Convert copies the tiles and metadata of an mbtiles or PMTiles file into a
+new file. The output is written as a PMTiles file if its name ends with
+.pmtiles and as an mbtiles file otherwise.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalln("An input and an output file are required")
+		}
+		if err := mbtiles.Convert(context.Background(), args[0], args[1]); err != nil {
+			log.Fatalf("Could not convert %s: %v", args[0], err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var (
+	diffPatch string
+	diffList  bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.mbtiles> <new.mbtiles>",
+	Short: "Compare the tiles of two mbtiles files",
+	Long: `Diff prints the number of tiles that were added, removed or changed in the
+new mbtiles file. With --patch, the differences are written to a patch mbtiles
+file, which the patch command applies to a copy of the old file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalln("Exactly two mbtiles files are required")
+		}
+		from, err := mbtiles.NewDB(args[0], mbtiles.ReadOnly())
+		if err != nil {
+			log.Fatalf("Could not open %s: %v", args[0], err)
+		}
+		defer from.Close()
+		to, err := mbtiles.NewDB(args[1], mbtiles.ReadOnly())
+		if err != nil {
+			log.Fatalf("Could not open %s: %v", args[1], err)
+		}
+		defer to.Close()
+
+		var s mbtiles.DiffStats
+		if diffPatch != "" {
+			s, err = mbtiles.WritePatch(context.Background(), from, to, diffPatch)
+		} else {
+			s, err = mbtiles.Diff(context.Background(), from, to, func(c mbtiles.TileChange) error {
+				if diffList {
+					fmt.Printf("%s %d/%d/%d\n", c.Kind, c.Z, c.X, c.Y)
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			log.Fatalf("Could not compare %s and %s: %v", args[0], args[1], err)
+		}
+		fmt.Printf("%d added, %d removed, %d changed tiles\n", s.Added, s.Removed, s.Changed)
+	},
+}
+
+var patchCmd = &cobra.Command{
+	Use:   "patch <file.mbtiles> <patch.mbtiles>",
+	Short: "Apply a patch written by diff to an mbtiles file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalln("An mbtiles file and a patch are required")
+		}
+		if err := mbtiles.ApplyPatch(context.Background(), args[0], args[1]); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffPatch, "patch", "", "Write the added and changed tiles and the removed tile coordinates to this new mbtiles file.")
+	diffCmd.Flags().BoolVar(&diffList, "list", false, "Print the coordinates of each added, removed or changed tile (in the xyz scheme).")
+	RootCmd.AddCommand(diffCmd, patchCmd)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of the environment variables that set the flags,
+// like MBTS_PORT for --port or MBTS_FONTS_DIR for --fonts-dir.
+const envPrefix = "MBTS_"
+
+// envName returns the name of the environment variable of the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyEnv sets the flags that have not been set on the command line to the
+// values of their environment variables, which take precedence over the
+// options of the configuration file. Lists are separated by commas.
+func applyEnv(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if serr := flags.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid %s: %v", envName(f.Name), serr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestEnvName(t *testing.T) {
+	for name, expected := range map[string]string{"port": "MBTS_PORT", "fonts-dir": "MBTS_FONTS_DIR", "tls-hostname": "MBTS_TLS_HOSTNAME"} {
+		if got := envName(name); got != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	newFlags := func() (*pflag.FlagSet, *int, *string, *bool, *[]string) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		port := flags.IntP("port", "p", 8000, "")
+		fontsDir := flags.String("fonts-dir", "", "")
+		verbose := flags.Bool("verbose", false, "")
+		urls := flags.StringSlice("url", nil, "")
+		flags.Bool("help", false, "")
+		return flags, port, fontsDir, verbose, urls
+	}
+
+	t.Setenv("MBTS_PORT", "9000")
+	t.Setenv("MBTS_FONTS_DIR", "/fonts")
+	t.Setenv("MBTS_VERBOSE", "true")
+	t.Setenv("MBTS_URL", "https://a/x.mbtiles,https://b/y.mbtiles")
+	t.Setenv("MBTS_HELP", "true")
+	flags, port, fontsDir, verbose, urls := newFlags()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(flags); err != nil {
+		t.Fatal(err)
+	}
+	help, _ := flags.GetBool("help")
+	if *port != 9000 || *fontsDir != "/fonts" || !*verbose || fmt.Sprint(*urls) != "[https://a/x.mbtiles https://b/y.mbtiles]" || help {
+		t.Errorf("unexpected flags from the environment: %d, %q, %v, %v, help %v", *port, *fontsDir, *verbose, *urls, help)
+	}
+
+	// the command line takes precedence
+	flags, port, _, _, _ = newFlags()
+	if err := flags.Parse([]string{"-p", "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(flags); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 8080 {
+		t.Errorf("expected port 8080 from the command line, got %d", *port)
+	}
+
+	t.Setenv("MBTS_PORT", "http")
+	flags, _, _, _, _ = newFlags()
+	if err := applyEnv(flags); err == nil || !strings.Contains(err.Error(), "MBTS_PORT") {
+		t.Errorf("expected error for invalid MBTS_PORT, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var (
+	exportOut     string
+	exportMinZoom int
+	exportMaxZoom int
+	exportBounds  string
+	exportScheme  string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <tileset> --out <dir>",
+	Short: "Export the tiles of a tileset to a z/x/y directory",
+	Long: `Export writes the tiles of an mbtiles, PMTiles or GeoPackage file to a
+z/x/y file tree like <dir>/3/4/2.png and its metadata to <dir>/metadata.json.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Exactly one tileset is required")
+		}
+		if exportOut == "" {
+			log.Fatalln("The output directory is required (--out)")
+		}
+		scheme, err := mbtiles.ParseTileScheme(exportScheme)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		f, err := parseTileFilter(exportMinZoom, exportMaxZoom, exportBounds)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		db, err := mbtiles.NewDB(args[0], mbtiles.ReadOnly(), mbtiles.Scheme(scheme))
+		if err != nil {
+			log.Fatalf("Could not open %s: %v", args[0], err)
+		}
+		defer db.Close()
+		n, err := db.Export(context.Background(), exportOut, f)
+		if err != nil {
+			log.Fatalf("Could not export %s: %v", args[0], err)
+		}
+		log.Infof("Exported %d tiles of %s to %s", n, args[0], exportOut)
+	},
+}
+
+// parseTileFilter returns the TileFilter of the zoom levels from minZoom to
+// maxZoom, either of which is unlimited if it is negative, and the
+// comma-separated bounds west,south,east,north, which may be empty.
+func parseTileFilter(minZoom, maxZoom int, bounds string) (mbtiles.TileFilter, error) {
+	var f mbtiles.TileFilter
+	if minZoom >= 0 || maxZoom >= 0 {
+		if minZoom < 0 {
+			minZoom = 0
+		}
+		if maxZoom < 0 || maxZoom > 30 {
+			maxZoom = 30
+		}
+		f.Zooms = mbtiles.ZoomRange(uint8(minZoom), uint8(maxZoom))
+	}
+	if bounds == "" {
+		return f, nil
+	}
+	for _, s := range strings.Split(bounds, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid bounds %q: %v", bounds, err)
+		}
+		f.Bounds = append(f.Bounds, v)
+	}
+	if len(f.Bounds) != 4 {
+		return f, fmt.Errorf("invalid bounds %q: expected west,south,east,north", bounds)
+	}
+	return f, nil
+}
+
+func init() {
+	flags := exportCmd.Flags()
+	flags.StringVar(&exportOut, "out", "", "Directory to which the tiles are written.")
+	flags.IntVar(&exportMinZoom, "minzoom", -1, "Lowest zoom level of the exported tiles (-1 for the lowest of the tileset).")
+	flags.IntVar(&exportMaxZoom, "maxzoom", -1, "Highest zoom level of the exported tiles (-1 for the highest of the tileset).")
+	flags.StringVar(&exportBounds, "bounds", "", "Bounding box west,south,east,north in degrees that the exported tiles must intersect.")
+	flags.StringVar(&exportScheme, "scheme", "xyz", "Numbering scheme of the rows of the exported tiles: xyz (counted from the north) or tms (from the south).")
+	RootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accessLogEntry describes a request to the handlers of a ServiceSet, which
+// is completed while the request is answered.
+type accessLogEntry struct {
+	// RequestID is taken from the "X-Request-ID" header of the request or
+	// generated if the header is missing. It is also set on the response.
+	RequestID string
+	Method    string
+	Path      string
+	// Tileset is the ID of the tileset of a tile request, empty otherwise.
+	Tileset string
+	// Z, X and Y are the requested tile coordinates of a tile request, empty
+	// otherwise. The order of X and Y is that of the XYZ tile URLs,
+	// regardless of the API that was used.
+	Z, X, Y string
+	// ClientIP is the address of the client, which is taken from the
+	// "X-Forwarded-For" header only for requests from trusted proxies.
+	ClientIP string
+}
+
+type accessLogKey struct{}
+
+// accessLogEntryFrom returns the accessLogEntry of the request r or nil if
+// requests are not logged.
+func accessLogEntryFrom(r *http.Request) *accessLogEntry {
+	e, _ := r.Context().Value(accessLogKey{}).(*accessLogEntry)
+	return e
+}
+
+// logTile records the tile coordinates of a tile request in its
+// accessLogEntry.
+func logTile(r *http.Request, z, x, y string) {
+	if e := accessLogEntryFrom(r); e != nil {
+		if i := strings.LastIndex(y, "."); i >= 0 {
+			y = y[:i]
+		}
+		e.Z, e.X, e.Y = z, x, y
+	}
+}
+
+// newRequestID returns a random request ID of 16 hex digits.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// clientIP returns the address of the client of the request r, which is
+// taken from the X-Forwarded-For header only for requests from
+// TrustedProxies, like for ACLs.
+func (s *ServiceSet) clientIP(r *http.Request) string {
+	if ip := s.forwardedClientIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// logged returns a http.Handler that logs every request to h to the
+// AccessLog of the ServiceSet, if any, at slog.LevelInfo with the message
+// "request" and the attributes request_id, method, path, status, bytes,
+// duration (in seconds) and client_ip, and for tile requests also tileset, z,
+// x and y.
+func (s *ServiceSet) logged(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AccessLog == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		e := &accessLogEntry{
+			RequestID: r.Header.Get("X-Request-ID"),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			ClientIP:  s.clientIP(r),
+		}
+		if e.RequestID == "" {
+			e.RequestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", e.RequestID)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, e)))
+		attrs := []slog.Attr{
+			slog.String("request_id", e.RequestID),
+			slog.String("method", e.Method),
+			slog.String("path", e.Path),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.n),
+			slog.Float64("duration", time.Since(start).Seconds()),
+			slog.String("client_ip", e.ClientIP),
+		}
+		if e.Tileset != "" {
+			attrs = append(attrs, slog.String("tileset", e.Tileset))
+		}
+		if e.Z != "" {
+			attrs = append(attrs, slog.String("z", e.Z), slog.String("x", e.X), slog.String("y", e.Y))
+		}
+		s.AccessLog.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// ACL restricts the access to the tilesets whose IDs match one of the glob
+// patterns in Tilesets, as understood by path.Match. A request must satisfy
+// each of the non-empty lists of an ACL, in addition to the credentials
+// required by the ServiceSet.
+type ACL struct {
+	Tilesets []string `json:"tilesets"`
+	// Keys are the API keys of which a request must carry one, like the
+	// APIKeys of the ServiceSet.
+	Keys []string `json:"keys"`
+	// Origins are the glob patterns of the origins, e.g.
+	// "https://*.example.com", of which the Origin or Referer header of a
+	// request must match one.
+	Origins []string `json:"origins"`
+	// CIDRs are the networks in CIDR notation, e.g. "10.0.0.0/8", of which
+	// one must contain the IP address of the client.
+	CIDRs []string `json:"cidrs"`
+}
+
+// LoadACLs reads ACLs from the JSON file filename, which contains an array of
+// objects with the fields "tilesets", "keys", "origins" and "cidrs".
+func LoadACLs(filename string) ([]ACL, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var acls []ACL
+	if err := json.Unmarshal(b, &acls); err != nil {
+		return nil, fmt.Errorf("cannot parse ACLs in %s: %v", filename, err)
+	}
+	for i := range acls {
+		if err := acls[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid ACL %d in %s: %v", i, filename, err)
+		}
+	}
+	return acls, nil
+}
+
+// validate checks the patterns and networks of the ACL.
+func (a *ACL) validate() error {
+	for _, pattern := range append(append([]string{}, a.Tilesets...), a.Origins...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	for _, cidr := range a.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applies reports whether the ACL restricts the access to the tileset id.
+func (a *ACL) applies(id string) bool {
+	for _, pattern := range a.Tilesets {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOrigin returns the origin of the request r from its Origin header
+// or, as browsers do not send it for images, from its Referer header.
+func requestOrigin(r *http.Request) string {
+	if o := r.Header.Get("Origin"); o != "" {
+		return o
+	}
+	u, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// check returns http.StatusOK if the request r from the client at ip
+// satisfies the ACL.
+func (a *ACL) check(r *http.Request, ip net.IP) int {
+	if len(a.Keys) > 0 {
+		key := requestAPIKey(r)
+		if key == "" {
+			return http.StatusUnauthorized
+		}
+		found := false
+		for _, k := range a.Keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				found = true
+			}
+		}
+		if !found {
+			return http.StatusForbidden
+		}
+	}
+	if len(a.Origins) > 0 {
+		origin := requestOrigin(r)
+		found := false
+		for _, pattern := range a.Origins {
+			if ok, _ := path.Match(pattern, origin); ok && origin != "" {
+				found = true
+			}
+		}
+		if !found {
+			return http.StatusForbidden
+		}
+	}
+	if len(a.CIDRs) > 0 {
+		found := false
+		for _, cidr := range a.CIDRs {
+			if _, n, err := net.ParseCIDR(cidr); err == nil && ip != nil && n.Contains(ip) {
+				found = true
+			}
+		}
+		if !found {
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusOK
+}
+
+// aclClientIP returns the IP address of the client of the request r for the
+// CIDRs of ACLs, which is taken from the X-Forwarded-For header only for
+// requests from TrustedProxies.
+func (s *ServiceSet) aclClientIP(r *http.Request) net.IP {
+	return s.forwardedClientIP(r)
+}
+
+// checkACLs checks that the request r satisfies all ACLs of the ServiceSet
+// that apply to the tileset id.
+func (s *ServiceSet) checkACLs(r *http.Request, id string) (int, error) {
+	for i := range s.ACLs {
+		a := &s.ACLs[i]
+		if !a.applies(id) {
+			continue
+		}
+		if status := a.check(r, s.aclClientIP(r)); status != http.StatusOK {
+			return status, fmt.Errorf("request from %s denied by ACL %d for tileset %s", s.clientIP(r), i, id)
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+type arcGISLOD struct {
+	Level      int     `json:"level"`
+	Resolution float64 `json:"resolution"`
+	Scale      float64 `json:"scale"`
+}
+
+type arcGISSpatialReference struct {
+	Wkid uint16 `json:"wkid"`
+}
+
+type arcGISExtent struct {
+	Xmin             float64                `json:"xmin"`
+	Ymin             float64                `json:"ymin"`
+	Xmax             float64                `json:"xmax"`
+	Ymax             float64                `json:"ymax"`
+	SpatialReference arcGISSpatialReference `json:"spatialReference"`
+}
+
+type arcGISLayerStub struct {
+	Id                uint8   `json:"id"`
+	Name              string  `json:"name"`
+	ParentLayerId     int16   `json:"parentLayerId"`
+	DefaultVisibility bool    `json:"defaultVisibility"`
+	SubLayerIds       []uint8 `json:"subLayerIds"`
+	MinScale          float64 `json:"minScale"`
+	MaxScale          float64 `json:"maxScale"`
+}
+
+type arcGISLayer struct {
+	Id                uint8             `json:"id"`
+	Name              string            `json:"name"`
+	Type              string            `json:"type"`
+	Description       string            `json:"description"`
+	GeometryType      string            `json:"geometryType"`
+	CopyrightText     string            `json:"copyrightText"`
+	ParentLayer       interface{}       `json:"parentLayer"`
+	SubLayers         []arcGISLayerStub `json:"subLayers"`
+	MinScale          float64           `json:"minScale"`
+	MaxScale          float64           `json:"maxScale"`
+	DefaultVisibility bool              `json:"defaultVisibility"`
+	Extent            arcGISExtent      `json:"extent"`
+	HasAttachments    bool              `json:"hasAttachments"`
+	HtmlPopupType     string            `json:"htmlPopupType"`
+	DrawingInfo       interface{}       `json:"drawingInfo"`
+	DisplayField      interface{}       `json:"displayField"`
+	Fields            []interface{}     `json:"fields"`
+	TypeIdField       interface{}       `json:"typeIdField"`
+	Types             interface{}       `json:"types"`
+	Relationships     []interface{}     `json:"relationships"`
+	Capabilities      string            `json:"capabilities"`
+	CurrentVersion    float32           `json:"currentVersion"`
+}
+
+var webMercatorSR = arcGISSpatialReference{Wkid: 3857}
+var geographicSR = arcGISSpatialReference{Wkid: 4326}
+
+// jsonpCallback matches the JavaScript function names, optionally qualified
+// by object names, that are accepted as JSONP callbacks.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$]*(\.[A-Za-z_$][0-9A-Za-z_$]*)*$`)
+
+// jsonp wraps the JSON b in a call to the function callback. An error is
+// returned if callback is not a plain function name.
+func jsonp(callback string, b []byte) ([]byte, error) {
+	if len(callback) > 128 || !jsonpCallback.MatchString(callback) {
+		return nil, fmt.Errorf("invalid JSONP callback %q", callback)
+	}
+	// the leading comment prevents the response from being interpreted as
+	// something else than JavaScript, e.g. as a Flash file
+	return []byte(fmt.Sprintf("/**/%s(%s);", callback, b)), nil
+}
+
+// wrapJSONP writes b to w. If the request contains a "callback" query
+// parameter, the JSON is wrapped in a call to that function.
+func wrapJSONP(w http.ResponseWriter, r *http.Request, b []byte) (int, error) {
+	var err error
+	callback := r.URL.Query().Get("callback")
+	if callback != "" {
+		if b, err = jsonp(callback, b); err != nil {
+			return http.StatusBadRequest, err
+		}
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_, err = w.Write(b)
+	return http.StatusOK, err
+}
+
+func (s *ServiceSet) arcgisCatalog(w http.ResponseWriter, r *http.Request) (int, error) {
+	services := []map[string]string{}
+	for id := range s.dbs() {
+		if status, _ := s.authorize(r, id); status != http.StatusOK {
+			continue
+		}
+		services = append(services, map[string]string{
+			"name": id,
+			"type": "MapServer",
+		})
+	}
+	bytes, err := json.Marshal(map[string]interface{}{
+		"currentVersion": 10.4,
+		"folders":        []string{},
+		"services":       services,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal ArcGIS catalog JSON: %v", err)
+	}
+	return wrapJSONP(w, r, bytes)
+}
+
+func (s *ServiceSet) arcgisService(id string, db Tileset) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		imgFormat := db.TileFormat().String()
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+		}
+		name := toString(metadata["name"])
+		description := toString(metadata["description"])
+		attribution := toString(metadata["attribution"])
+
+		minZoom, maxZoom, bounds := zoomsAndBounds(metadata)
+		dpi := 96 // TODO: extract dpi from the image instead
+		var lods []arcGISLOD
+		for i := minZoom; i <= maxZoom; i++ {
+			scale, resolution := calcScaleResolution(i, dpi)
+			lods = append(lods, arcGISLOD{
+				Level:      i,
+				Resolution: resolution,
+				Scale:      scale,
+			})
+		}
+
+		minScale := lods[0].Scale
+		maxScale := lods[len(lods)-1].Scale
+
+		extent := geoBoundsToWMExtent(bounds)
+
+		tileInfo := map[string]interface{}{
+			"rows": 256,
+			"cols": 256,
+			"dpi":  dpi,
+			"origin": map[string]float64{
+				"x": -20037508.342787,
+				"y": 20037508.342787,
+			},
+			"spatialReference": webMercatorSR,
+			"lods":             lods,
+		}
+
+		documentInfo := map[string]string{
+			"Title":    name,
+			"Author":   attribution,
+			"Comments": "",
+			"Subject":  "",
+			"Category": "",
+			"Keywords": toString(metadata["tags"]),
+			"Credits":  toString(metadata["credits"]),
+		}
+
+		out := map[string]interface{}{
+			"currentVersion":            "10.4",
+			"id":                        id,
+			"name":                      name,
+			"mapName":                   name,
+			"capabilities":              "Map,TilesOnly",
+			"description":               description,
+			"serviceDescription":        description,
+			"copyrightText":             attribution,
+			"singleFusedMapCache":       true,
+			"supportedImageFormatTypes": strings.ToUpper(imgFormat),
+			"units":                     "esriMeters",
+			"layers": []arcGISLayerStub{
+				{
+					Id:                0,
+					Name:              name,
+					ParentLayerId:     -1,
+					DefaultVisibility: true,
+					SubLayerIds:       nil,
+					MinScale:          minScale,
+					MaxScale:          maxScale,
+				},
+			},
+			"tables":              []string{},
+			"spatialReference":    webMercatorSR,
+			"minScale":            minScale,
+			"maxScale":            maxScale,
+			"tileInfo":            tileInfo,
+			"documentInfo":        documentInfo,
+			"initialExtent":       extent,
+			"fullExtent":          extent,
+			"exportTilesAllowed":  false,
+			"maxExportTilesCount": 0,
+			"resampling":          false,
+		}
+
+		bytes, err := json.Marshal(out)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot marshal ArcGIS service info JSON: %v", err)
+		}
+		return wrapJSONP(w, r, bytes)
+	}
+}
+
+func (s *ServiceSet) arcgisLayers(id string, db Tileset) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+		}
+
+		minZoom, maxZoom, bounds := zoomsAndBounds(metadata)
+		extent := geoBoundsToWMExtent(bounds)
+
+		minScale, _ := calcScaleResolution(minZoom, 96)
+		maxScale, _ := calcScaleResolution(maxZoom, 96)
+
+		// for now, just create a placeholder root layer
+		emptyArray := []interface{}{}
+		emptyLayerArray := []arcGISLayerStub{}
+
+		var layers [1]arcGISLayer
+		layers[0] = arcGISLayer{
+			Id:                0,
+			DefaultVisibility: true,
+			ParentLayer:       nil,
+			Name:              toString(metadata["name"]),
+			Description:       toString(metadata["description"]),
+			Extent:            extent,
+			MinScale:          minScale,
+			MaxScale:          maxScale,
+			CopyrightText:     toString(metadata["attribution"]),
+			HtmlPopupType:     "esriServerHTMLPopupTypeAsHTMLText",
+			Fields:            emptyArray,
+			Relationships:     emptyArray,
+			SubLayers:         emptyLayerArray,
+			CurrentVersion:    10.4,
+			Capabilities:      "Map",
+		}
+
+		out := map[string]interface{}{
+			"layers": layers,
+		}
+
+		bytes, err := json.Marshal(out)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot marshal ArcGIS layer info JSON: %v", err)
+		}
+		return wrapJSONP(w, r, bytes)
+	}
+}
+
+func (s *ServiceSet) arcgisLegend(id string, db Tileset) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+		}
+
+		// TODO: pull the legend from ArcGIS specific metadata tables
+		var elements [0]interface{}
+		var layers [1]map[string]interface{}
+
+		layers[0] = map[string]interface{}{
+			"layerId":   0,
+			"layerName": toString(metadata["name"]),
+			"layerType": "",
+			"minScale":  0,
+			"maxScale":  0,
+			"legend":    elements,
+		}
+
+		out := map[string]interface{}{
+			"layers": layers,
+		}
+
+		bytes, err := json.Marshal(out)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot marshal ArcGIS legend info JSON: %v", err)
+		}
+		return wrapJSONP(w, r, bytes)
+	}
+}
+
+func (s *ServiceSet) arcgisTiles(id string, db Tileset) handlerFunc {
+	p := s.policy(id)
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		// split path components to extract tile coordinates x, y and z
+		pcs := strings.Split(r.URL.Path[1:], "/")
+		// we are expecting at least "arcgis", "rest", "services", <id> , "MapServer", "tile", <z>, <y>, <x>
+		l := len(pcs)
+		if l < 9 || pcs[8] == "" {
+			return http.StatusBadRequest, fmt.Errorf("requested path is too short")
+		}
+		z, y, x := pcs[l-3], pcs[l-2], pcs[l-1]
+		logTile(r, z, x, y)
+		tc, _, err := tileCoordFromString(z, x, y)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if status := s.interceptRead(r, id, db, tc); status != 0 {
+			return status, nil
+		}
+		var data []byte
+		if p.allowsZoom(int(tc.z)) {
+			err = db.ReadTileContext(r.Context(), tc.z, tc.x, tc.y, &data)
+		}
+		if err != nil && err != mbtiles.ErrTileNotFound {
+			err = fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			return http.StatusInternalServerError, err
+		}
+
+		if len(data) <= 1 {
+			if db.TileFormat() == mbtiles.PBF {
+				// If pbf, return 404 w/ json, consistent w/ mapbox
+				return notFoundJSON(w, "Tile does not exist")
+			}
+			w.Header().Set("Content-Type", "image/png")
+			_, err = w.Write(BlankPNG())
+			return http.StatusOK, err
+		}
+
+		return s.writeTile(w, r, db, data, "")
+	}
+}
+
+// ArcGISHandler returns a http.Handler that serves the ArcGIS endpoints of the
+// ServiceSet. The function ef is called with any occuring error if it is
+// non-nil, so it can be used for e.g. logging with logging facitilies of the
+// caller.
+func (s *ServiceSet) ArcGISHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(s.rebuilt(func(tilesets map[string]Tileset) http.Handler {
+		m := http.NewServeMux()
+		root := "/arcgis/rest/services/"
+		m.Handle("/arcgis/rest/services", wrapGetWithErrors(ef, s.arcgisCatalog))
+		for id, db := range tilesets {
+			p := root + id + "/MapServer"
+			m.Handle(p, wrapGetWithErrors(ef, s.authorized(id, s.arcgisService(id, db))))
+			m.Handle(p+"/layers", wrapGetWithErrors(ef, s.authorized(id, s.arcgisLayers(id, db))))
+			m.Handle(p+"/legend", wrapGetWithErrors(ef, s.authorized(id, s.arcgisLegend(id, db))))
+			m.Handle(p+"/tile/", wrapGetWithErrors(ef, s.authorized(id, s.countRequests(id, db, s.arcgisTiles(id, db)))))
+		}
+		return m
+	})))
+}
+
+func geoBoundsToWMExtent(bounds []float64) arcGISExtent {
+	xmin, ymin := geoToMercator(bounds[0], bounds[1])
+	xmax, ymax := geoToMercator(bounds[2], bounds[3])
+	return arcGISExtent{
+		Xmin:             xmin,
+		Ymin:             ymin,
+		Xmax:             xmax,
+		Ymax:             ymax,
+		SpatialReference: webMercatorSR,
+	}
+}
+
+func calcScaleResolution(zoomLevel int, dpi int) (float64, float64) {
+	resolution := 156543.033928 / math.Pow(2, float64(zoomLevel))
+	scale := float64(dpi) * 39.37 * resolution // 39.37 in/m
+	return scale, resolution
+}
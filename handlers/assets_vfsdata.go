@@ -34,17 +34,17 @@ var Assets = func() http.FileSystem {
 		},
 		"/map.html": &vfsgen۰CompressedFileInfo{
 			name:             "map.html",
-			modTime:          mustUnmarshalTextTime("2017-11-03T23:30:04.529481546Z"),
-			uncompressedSize: 5966,
+			modTime:          mustUnmarshalTextTime("2026-10-16T12:14:22.199859000Z"),
+			uncompressedSize: 5755,
 
-			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x58\x6d\x6f\xdb\x38\x12\xfe\x9c\x02\xfd\x0f\x73\x5e\x5c\xe4\x2c\x14\xc9\x49\xda\xa0\x50\xe2\x02\x6e\x92\xa6\xd9\xcd\xdb\xc5\xce\xdd\xee\xfa\x8c\x82\x96\xc6\x16\x5b\x8a\x14\x48\xca\xb1\xeb\xfa\xbf\x1f\x48\xc9\x8e\x25\x2b\xd9\xf6\xee\xba\x5e\x6c\x23\x0d\x67\x86\x9c\xe7\x99\x17\x49\xf3\x39\x44\x38\xa2\x1c\xa1\x91\x90\xb4\x01\x8b\xc5\xcb\x17\xc7\x7f\x3b\xbd\x39\xe9\xfd\x7e\x7b\x06\xb1\x4e\xd8\xdb\x97\x2f\x8e\x57\x7f\x91\x44\xc0\x08\x1f\xb7\x1b\xc8\x1b\x6f\x5f\xbe\x00\x00\x38\x4e\x50\x13\x08\x63\x22\x15\xea\x76\xe3\xbe\xf7\x7e\xf7\xcd\x6a\x4d\x53\xcd\xf0\xed\x7c\xee\x5d\x9c\x2e\x16\x70\x2b\x71\x42\xf1\xe1\xd8\xcf\xc5\x85\x0e\xa3\xfc\x33\x48\x64\xed\x06\x0d\x05\x6f\x40\x2c\x71\xd4\x6e\xf8\x23\x32\x31\xf7\x5e\xca\xc7\x0d\x50\xf4\x0b\xaa\x76\xe3\x60\x7f\x7a\xb0\xdf\x00\x3d\x4b\xb1\xdd\xa0\x09\x19\xa3\x6f\x96\x97\x9e\x54\x28\x69\xaa\x41\xc9\xb0\xdd\xf0\x95\x26\x9a\x86\x7e\x28\x24\x7a\x09\xe5\xde\x27\xd5\x78\x7b\xec\xe7\x2a\xa5\xad\x8b\xfd\xaa\xfa\xa1\x52\x8d\xfc\x58\x4a\xcf\x18\xaa\x18\x51\x37\xc0\x5f\xed\x65\x84\xc5\x8d\xf9\x19\x8c\x60\xfe\x78\x6f\x65\x48\xc7\xb1\x0e\x60\xaf\xd5\xfa\xfb\xd1\xe3\xd2\xe2\xf1\x72\x28\xa2\x59\xd5\x2a\x21\x72\x4c\x79\x00\xad\xa3\xb2\x3c\x25\x51\x44\xf9\x78\x73\xe1\xcf\xb7\x89\x5f\x7d\xcb\x26\x6b\x06\x3f\x5d\x91\xb4\x6a\x92\x0a\x45\x35\x15\x3c\x00\x32\x54\x82\x65\x1a\x2b\xe7\xd0\x22\x0d\xaa\x67\x63\x38\xd2\x1b\xc2\xa1\xd0\x5a\x24\x1b\x62\x69\xc3\xd8\x54\x96\x11\xca\x5d\xe3\x1c\xf6\xd3\x29\x28\xc1\x68\x04\x3f\x75\x3a\x9d\xfa\xa3\x7b\x43\xa2\x30\x21\x29\xd0\x64\x5c\x0d\xe1\x81\x46\x3a\x0e\xe0\xe0\x30\x9d\x3e\x61\x4c\xf9\x48\x18\xab\xad\xad\xad\x15\xde\x87\xe9\x14\xde\x58\x8b\xad\xad\xad\x91\xe0\x06\xea\x57\xe9\xd4\xdf\x33\x0b\x1d\x49\x09\x73\xe1\x03\xb2\x09\x6a\x1a\x12\x17\x14\xe1\x6a\x57\xa1\xa4\xa3\xdc\x62\x48\xc2\xcf\x63\x29\x32\x1e\x05\xf0\x10\x53\x0b\x5b\x45\x2c\xc7\x43\xd2\xdc\x7f\xfd\xda\x5d\xfe\xdf\xf2\xde\xec\x14\x6a\x62\xba\xab\x62\x12\x89\x87\x00\x5a\xd0\x82\xbd\xd7\xe9\x34\xd7\x6f\xb9\xf6\x3f\x6f\x7f\xa5\x69\x81\x92\x24\xa2\x99\x0a\xe0\x75\x29\xc6\x6f\x22\xf0\xcb\x2e\xe5\x11\x4e\x03\xd8\x6f\xb5\xaa\x34\x58\xfc\xf7\x5a\x1b\x4e\xe5\x32\xf7\x0a\x80\x36\xc0\x8c\x0f\xbe\x31\xf7\x8a\xc2\xf2\x97\x95\x75\xec\x9b\x7e\x63\x2e\x4c\x95\x2c\xeb\x2e\xa2\x13\xa0\x51\xbb\x71\x45\x52\x53\xcf\x11\x9d\x94\xab\x7f\xad\x24\x27\x44\x42\x91\x0c\x0a\xda\xd0\x2f\x9f\xe2\xd2\xd3\x94\xe1\x25\x99\xa1\x6c\x3a\xbe\x3f\x57\x0b\x8f\xc8\x70\x4c\x95\xe0\x8c\x72\xf4\x42\x91\xf8\x1d\x19\x9e\x5f\x74\x7d\x89\x4a\xfb\x0a\xe5\x84\x86\xa8\xfc\x7f\x09\xc9\xa2\x8f\x3d\x91\x8a\x8f\x57\x24\xf5\xaf\x48\xda\x45\x39\x41\xe9\x1b\x7f\xfe\xfc\xcb\xc2\x9f\xcf\x16\xfe\x7c\xba\x70\xdc\x6a\xe4\xe6\x47\xb4\x96\x74\x98\xe5\x44\x38\x3d\xca\x50\xc1\x76\x28\xd2\xd9\x11\x9c\x29\x49\x61\x3b\x89\x88\x8a\xf3\x1b\x17\x4e\xf1\x52\xc8\x04\x5d\xb8\xee\xfc\xb3\x77\xf6\x0f\x17\x7a\x22\xe9\x89\xc4\x85\x0b\xae\x51\x26\x24\x75\x81\xde\x9e\xb8\x70\xdf\x3d\xef\xba\xf0\xbe\x73\xe3\xc2\xf5\x6d\xd7\x85\xeb\xbb\x93\xce\xb5\x0b\xe7\x28\xde\x11\x85\x2e\xfc\x4a\x22\xa2\x34\x4a\xb8\xbe\x74\xe1\x46\x46\x9c\xf0\x10\xa1\x9b\xc9\x09\xce\xdc\x7c\xe3\x5f\x48\x4a\xb8\x0b\x57\x67\xbd\x8b\x42\x72\x12\x53\x4e\xa0\xf9\x41\xf0\x31\xfc\x2a\xf8\x78\xc7\x05\xc2\x23\xd0\x31\xc2\xf9\x45\x17\xee\x15\x4a\x38\x11\x49\x92\x71\xaa\x67\x8e\xbb\x19\xab\xca\x86\x91\x48\x08\xe5\x2a\x80\xbe\xa3\x2c\x4c\x8e\x0b\xce\x12\x4b\x67\x50\x63\xc4\xc8\x10\x59\x00\xce\x59\xf7\xee\x02\x0c\xcc\x4e\x59\x67\xb1\xe3\x3e\xcb\x64\xbe\xcd\x77\x91\x79\x61\x46\x89\x9c\xfd\x30\x2e\xbb\x22\x93\x21\x06\x05\xa7\x74\x37\xcc\x86\x18\x19\xd2\x4e\x3b\x4b\xea\x3a\x67\xbf\x59\xba\xce\x66\x68\xfe\xea\x84\xa4\x29\xe5\x63\x17\x3a\x28\xc5\x58\xd2\xc8\x85\x8b\xf3\x6b\xf3\xcf\xad\x0b\xf7\xb7\x77\xbb\x67\xe6\xe2\xfb\xe8\x28\x21\x5b\xc4\xfc\x9d\xe0\x7e\x7b\x99\x9c\x10\x3e\x21\x4b\x80\x2f\x4d\x97\xf8\x78\x2e\xc9\xec\xa3\xc9\xc7\xbf\xb6\x68\xea\xa0\x48\xc8\xf4\x0f\x21\x92\x00\xf6\x0e\x7f\x48\xde\x9a\x50\xff\x1f\xd0\xe6\x82\x0a\xb4\x67\x0c\x27\xc4\x60\x51\xa0\xfb\x81\x32\x66\x66\xc4\xff\x8a\x6b\x8e\x5e\x9e\x8f\xd7\xe7\x1d\x83\x5f\xb7\xe3\xc2\xc9\xf9\x45\xe7\xce\x85\x6b\xb8\x13\x43\xca\x95\xe0\x2e\x5c\x9f\x9c\x75\x8c\xd2\x65\xd7\x85\x1b\x73\x71\xd5\xb1\xd9\x1b\x11\x4d\x94\x9e\x49\x64\x0a\xb9\x0b\x77\xf4\xd3\x67\xf5\x40\x34\x4a\xa5\x09\xd1\x2e\x9c\x77\x73\x3d\x46\x78\xe4\xc2\xfb\x33\x63\xb6\xec\x64\xa5\x64\xce\x4c\x32\x87\xcf\x25\xf3\x8a\xc1\xfd\x83\x1f\xc2\xe0\x0a\xe4\x0d\x1a\x1f\xef\x07\x47\xcb\xb1\x65\x7e\xfe\xcf\x66\xe2\x30\xc3\x27\xb4\xe1\xd2\x4b\x48\x3a\x14\xd3\x75\x92\xe7\x73\xef\xfe\xee\x72\xb1\x70\x76\xca\x3e\x3d\xc1\x9b\x8e\x44\x12\xcd\x1c\x17\x46\x19\x0f\xcd\xbe\xcd\x9d\x3a\xc6\xec\x50\x83\x36\xe8\x98\x2a\x6f\x8c\xda\xd4\xc2\x2f\xdd\x9b\xeb\xe6\x8e\x37\x34\x0f\x13\xea\x08\xc0\xf7\x21\x26\x13\x04\x2d\x40\xc5\xd9\x68\xc4\xd0\xe0\xaa\xac\x20\x21\x3a\x8c\x81\x21\x19\x31\xd4\x30\x12\x32\x21\xba\x0e\xdc\xd4\x1b\x51\xfd\xce\x7a\x6c\xf6\xfb\xc3\xfe\xde\xc0\x85\x61\xbf\x35\x18\xb8\xd0\x1f\xf6\x0f\xec\xdd\xfe\x60\x30\xd8\x39\xaa\xc2\xb3\x26\xf8\xd9\x2f\x4f\x63\xc3\x72\x81\x4c\xd3\xb9\x22\xa9\x49\x4a\x6b\x50\x56\x5b\x42\xc8\x33\xc6\xd6\xbc\x45\x07\xde\x27\x65\x90\xf2\xfc\x75\x98\x74\x81\xc0\x06\x5c\x2b\xa8\x0a\x85\x25\x40\xeb\xdb\x59\xde\x7c\xe8\xdd\x9c\xde\x04\x20\x52\x4d\x13\xfa\x05\x6d\x26\xa6\x12\x27\xc8\x35\x7c\xa2\x5a\xa3\xac\x3e\xc1\xfc\xb7\xf0\xd0\x11\xac\x0e\xec\x25\x64\xfa\x45\x88\x04\xb6\xb7\x61\x43\x76\x6c\x37\x19\xa3\x36\x49\xde\xdc\xd9\xa9\x49\x05\xa3\xa0\x0a\x85\xaa\xfd\x06\x2d\xd5\xa0\x1f\xd3\xf4\x31\x3f\x57\x4e\xcc\x85\xea\xb7\x06\xb5\x3d\x23\xa1\x3c\x2f\xbc\xc7\x3d\x29\xb7\x67\xfe\xfa\x15\x5a\xcf\x55\xea\x46\x90\x5f\xbf\xc2\xfe\x41\x4d\xfe\x6c\xa2\x4d\xa2\x28\x3f\xa3\x3d\xf7\xa6\x8e\x4d\x1b\x1c\x23\x8f\xcc\x06\xeb\xa4\xe7\xd2\x1a\x1a\xd6\xd4\xb7\xb7\xd7\x8c\x3d\x85\x44\x86\x71\xd3\xff\xf7\xdc\xdf\x81\x76\xbb\x0d\xad\xda\x4a\x2c\x6d\x67\x0d\x53\xf3\x0e\xbc\xe6\xb7\x4a\x02\xe4\xc9\x76\x45\x3e\x23\xa8\x4c\xa2\x2d\x62\xa0\xca\x76\xbd\xdc\xcc\x3a\x02\xa5\x65\x16\x6a\xa3\xf1\x80\x80\xd3\x14\xc3\x9a\x1a\x2d\xc7\xe0\x31\xe4\x63\x1d\x97\x43\xe9\xb7\x06\x1e\x32\x4c\x90\x6b\x55\x1b\xc3\x1a\xbc\x27\x82\x6b\x29\x58\xf3\xd2\x0b\xf3\x2b\xfb\x22\x75\xf8\x2a\xf7\xd6\x7c\xc2\x18\x4a\xef\x15\x8e\x16\xa9\x7d\x21\xa8\x6b\xd8\x65\xdc\x54\xb0\x76\xce\x67\xb4\x43\xc1\x18\x49\x15\x76\x69\x92\x32\x0c\x40\xcb\x0c\x9f\x51\x8f\x50\x63\xa8\xbb\x5a\xa2\x0e\x63\x8c\x72\xfd\x7a\xf5\xc5\x4e\x1d\x3f\x8b\x3f\xa9\x9b\x52\x01\x9b\xe7\x32\x55\x2a\x5f\x2b\x59\x72\xf1\xf6\x89\xcc\x31\xc9\x6a\x14\xef\xef\x2e\xd7\x33\xd5\xda\xf6\x5b\x83\x9a\x53\x19\x8b\x4c\x8f\x8c\x86\xad\xd9\x4c\x8f\xce\x25\x8d\x9a\x85\x97\xda\x3a\x35\x3f\x89\xf6\x45\xcf\x92\xf3\xea\x09\xd8\x52\x41\xcd\x04\x3e\xc9\xa4\x12\xf2\x59\x80\x13\x91\x29\xb4\xe3\x7a\x42\x58\x00\x87\x87\x36\x9d\x4f\x91\x91\x99\x99\x24\xf9\x7a\x22\x26\x08\xb6\x75\xaa\x1a\x74\xeb\x20\x2f\x02\x33\x49\xd8\x13\xcd\x84\xa4\x9b\xe5\xbd\xc4\xc0\xbc\x51\x9a\x44\x25\x94\x17\xdd\xeb\x54\x24\xf7\x9a\x32\x2f\x94\x48\x34\x36\x9d\x88\x4e\xcc\x9c\x37\x8a\x8e\xbb\xb6\x3e\x46\x9d\x8f\x9c\x5a\xd6\x8d\x6f\x8d\x53\x7d\x2d\x22\xac\x75\x1b\x1f\x18\xaf\x8e\x5b\x3e\x41\x9d\xab\xa5\x1b\x8f\x72\x8e\xf2\x43\xef\xca\x70\xec\xf4\x4c\xa5\x17\x0f\x1f\x10\x13\x05\xf6\x3b\x19\x18\x12\xd5\x11\x28\x44\x08\x05\x57\x82\xa1\x05\xd2\xf2\x6c\x9e\xa5\x3c\xc7\x42\xf1\x34\x66\x82\x37\x1b\x16\x76\x31\x41\xd9\x58\x9b\x8a\x58\x37\x31\xc0\x16\x94\xdd\xc7\x63\x62\xdc\x74\xee\x7b\xef\x1f\x37\x0b\x1c\x17\xd0\x33\x57\xb5\x85\x51\x33\x52\x4a\x6b\x8f\xb7\xa6\xa3\x98\xfe\x5e\xb4\x14\x33\xa4\x6e\x8b\x1e\xd1\x34\x2d\x82\xe1\x48\x3b\xeb\xee\x9e\x6c\x41\xc6\xcb\x3b\x31\x6d\xce\x13\x11\x99\x94\xb3\xc9\xf9\xd8\x70\x56\xce\xf2\x5a\x2e\x3f\x45\x28\x46\xa3\x22\x49\x96\xee\x24\xe1\x63\xac\xb6\xb2\x72\xfb\xb2\xee\x2a\xf9\x9f\xd8\xaf\x15\x55\x21\x99\x06\xb0\xe7\x56\xc7\x10\xcb\x70\x53\xac\x34\xa6\x01\xb4\xbc\x56\x75\x41\x48\x8a\x5c\x07\xe0\x4c\x50\x6a\x1a\x12\x56\xdd\x9a\x86\x82\x9f\x30\xa2\x54\x00\x4e\xf1\xd0\xb6\x6b\xa3\xd8\x35\x2b\xce\x93\x14\xe4\xc1\xdb\xe7\x4a\xca\xd3\x4c\x43\x18\x1b\x2b\xa7\x94\x21\xd5\xae\x61\x67\xab\x61\xeb\x26\x25\x21\xd5\xb3\x26\x7a\x36\xa0\x75\xaa\x6a\xa8\x5e\x63\x2e\xdf\xf6\x59\x95\xf2\x7c\x49\x48\xaa\x9e\x21\x24\xff\x52\x58\x3b\x52\x96\xd6\xc1\xea\xaa\xa2\x60\xfa\xea\x6f\x9b\xbc\x19\xf1\xef\xf5\xe2\x3f\x02\xd8\x5b\x8f\x74\x19\xf7\xda\x67\xe3\x63\xbf\xf8\x18\x75\xec\x17\x5f\xc5\xe7\x73\x30\x73\x7b\xb1\x78\xf9\xe2\x3f\x01\x00\x00\xff\xff\x0a\x04\xa7\x74\x4e\x17\x00\x00"),
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03\xbd\x58\x7b\x6f\xdb\xc8\x11\xff\x3b\x05\xfa\x1d\xb6\x3a\x34\x94\x50\x9a\x94\xed\xc4\x08\x64\x2b\x80\x22\x2b\x8e\xef\xfc\xaa\x25\xb7\x77\xe7\x1a\xc1\x8a\x1c\x89\x7b\x21\xb9\xc4\xee\x4a\x96\x22\xe8\xbb\x77\x86\x0f\x59\xa4\x68\x37\x41\x9b\xca\x96\xb8\x8f\x99\xd9\x9d\xdf\xcc\xce\x0c\x77\xb5\x62\x3e\x4c\x44\x0c\xac\x11\xf1\xa4\xc1\xd6\xeb\x3f\xff\xe9\xe4\x2f\xa7\xd7\xfd\xd1\x6f\x37\x03\x16\x98\x28\x7c\x8f\x03\x9b\x27\x70\x9f\x85\x3c\x9e\x76\x1b\x10\x37\x70\x84\xe1\xe7\x24\x02\xc3\x99\x17\x70\xa5\xc1\x74\x1b\x77\xa3\x8f\x7b\xef\x36\x73\x46\x98\x10\xde\xaf\x56\xce\xf9\xe9\x7a\xcd\x6e\x14\xcc\x05\x3c\x9e\xb8\xd9\x70\x4e\x13\x8a\xf8\x0b\x53\x10\x76\x1b\xc2\x93\x71\x83\x05\x0a\x26\xdd\x06\xf2\xdc\x4a\x69\xd6\x6b\x77\xc2\xe7\x34\xe1\x24\xf1\xb4\xc1\xb4\xf8\x0a\xba\xdb\x38\x3c\x58\x1c\x1e\x34\x98\x59\x26\x80\x6c\x11\x9f\x82\x4b\xd3\x85\x48\xed\x29\x91\x18\xa6\x95\xb7\x2d\x48\x1b\x6e\x84\xe7\x7a\x52\x81\x13\x89\xd8\xf9\x43\x37\xde\x9f\xb8\x19\x6d\x69\x33\xd5\x1d\x54\x19\x3d\xad\x1b\xd9\x8e\xb5\x59\x86\xa0\x03\x00\xd3\x60\xee\x66\x75\x1a\xcc\x3b\xf4\x21\xf8\xd8\xea\xa9\x9f\x8e\x81\x98\x06\xa6\xc3\xf6\xdb\xed\xbf\x1e\x3f\x4d\xad\x9f\x9a\x63\xe9\x2f\xab\x5c\x11\x57\x53\x11\x77\x58\xfb\xb8\x3c\x9e\x70\xdf\x17\xf1\x74\x77\xe2\x3f\x2f\x13\xbc\xf9\x96\x45\xb6\x18\x7e\xba\xe4\x49\x95\x25\x91\x5a\x18\x21\x91\x89\x8f\xb5\x0c\x67\x06\x2a\xfb\x30\x32\xe9\x54\xf7\x16\xc2\xc4\xec\x0c\x8e\xa5\x31\x32\xda\x19\x56\xa9\x1a\xbb\xc4\xca\x07\xb5\x47\xc2\xd9\x41\xb2\x60\xb8\xb4\xf0\xd9\x4f\xbd\x5e\xaf\x7e\xeb\xce\x98\x6b\x40\x3f\x67\x22\x9a\x56\x55\x78\x14\xbe\x09\x3a\xec\xf0\x28\x59\x3c\xc3\x2c\xe2\x89\x24\xae\x57\xaf\x5e\x6d\xf0\x46\x6a\xf6\x2e\xe5\xc0\xd1\x89\x8c\x09\xea\x37\xc9\xc2\xdd\xa7\x89\x9e\x12\x3c\xb4\xd9\x27\x08\xe7\x80\xfe\xc3\x6d\xa6\x79\xac\xf7\x34\x28\x31\xc9\x38\xc6\xdc\xfb\x32\x55\x72\x16\xfb\x1d\xf6\x18\x88\x14\xb6\xca\xb0\x9a\x8e\x79\xf3\xe0\xed\x5b\xbb\xf8\xb6\x9d\x77\xad\x9c\x4c\x2e\xf6\x74\xc0\x7d\xf9\x88\xc6\xc2\xbf\xfd\xb7\xb8\x68\x4a\xdf\xb6\xd3\x3f\xe7\x60\x43\x99\x02\xa5\xb8\x2f\x66\xba\xc3\xde\x96\x74\xfc\x26\x03\x7e\xdd\x13\xb1\x0f\x0b\xc4\xb9\xdd\x6e\xd7\x18\x17\x3d\x6c\x47\xa8\x2a\x7c\x2f\x07\x68\x07\xcc\xe0\xf0\x1b\x7d\x2f\x3f\x58\x6e\x71\xb2\x4e\x5c\x0a\x45\xd4\xa0\x53\x52\x9c\x3b\x5f\xcc\x99\xf0\xbb\x0d\x74\x50\x3a\xd8\xd8\x2d\xc7\x83\xad\x23\x39\xe7\x8a\xe5\xce\xa0\x59\x97\xdd\x97\x77\x71\xe1\x18\x11\xc2\x05\x5f\x82\x6a\x5a\xae\xbb\xd2\x6b\x87\x2b\x6f\x2a\xb4\x8c\x31\x3e\x80\xe3\xc9\xc8\xed\x29\xef\xec\x7c\xe8\x2a\xd0\xc6\x45\x8b\x62\x88\x02\xed\xfe\x53\xaa\xd0\xff\x3c\x92\x89\xfc\x8c\x9b\x70\xf1\x3b\xc4\x29\x50\x2e\xc9\x73\x57\x5f\xd7\xee\x6a\x89\xdf\xc5\xda\xb2\xab\x9a\xd3\x87\x1b\xa3\xc4\x78\x96\x19\xc2\x1a\x21\x8f\x66\xaf\x3d\x99\x2c\x8f\xd9\x40\x2b\xc1\x5e\x47\x3e\xd7\x41\xd6\xb1\xd9\x29\x5c\x48\x15\x81\xcd\xae\x7a\xff\x18\x0d\xfe\x6e\xb3\x91\x8c\xf0\xdf\x66\xe7\xb1\x01\x85\x9a\xd9\x4c\xdc\xf4\x6d\x76\x37\x3c\x1b\xda\xec\x63\xef\x1a\x29\x6f\xb0\x75\x75\xdb\xef\x5d\xd9\xec\x0c\xe4\x07\x44\xc0\x66\xbf\x70\x94\x8a\x1c\xec\xea\xc2\x66\xd7\xca\x8f\x79\xec\x01\x1b\xce\x70\xe3\x4b\x3b\x5b\xf8\x67\x9e\xf0\xd8\x66\x97\x83\xd1\x79\x3e\xd2\x0f\x44\xcc\x59\xf3\x93\x8c\xa7\xec\x17\xfc\x69\xd9\x8c\xc7\x3e\x33\x01\x30\x84\x85\xdd\x21\x24\xac\x2f\xa3\x68\x16\x0b\xb3\xb4\xec\x5d\x5d\xf5\x6c\xec\xcb\x88\x8b\x18\xfd\xf1\xde\xd2\x29\x4c\x88\x8a\x55\x60\x69\x3d\xd4\x30\x85\x7c\x0c\x21\x42\x33\x18\xde\x9e\x33\x82\xd9\x2a\xd3\xac\x5b\xf6\x8b\x96\xcc\x96\xf9\x2e\x63\x9e\x53\x72\x51\xcb\x1f\x66\xcb\xa1\x9c\x29\x0f\x3a\xb9\x4d\xc5\x9e\x37\x1b\x83\x4f\x46\x3b\xed\x15\xa6\xeb\x0d\x7e\x4d\xcd\x35\x58\x02\x3d\x0d\x9a\x36\xc1\xe8\x83\x13\xa0\xe4\x54\x09\x24\x3f\x3f\xbb\xa2\x9f\x1b\x64\xb9\xb9\xdd\x1b\x50\xe3\xfb\xcc\x51\x42\x36\xd7\xf9\x3b\xc1\xfd\xf6\x63\xd2\xe7\xf1\x9c\x17\x00\x5f\x50\x94\xf8\x7c\xa6\xf8\xf2\x33\xf9\xe3\xff\xf7\xd0\xd4\x41\x11\xf1\xc5\xef\x12\x73\x10\xdb\x3f\xfa\x21\x7e\x4b\xaa\xfe\x2f\xa0\xcd\x06\x2a\xd0\x0e\x42\x98\x73\xc2\x22\x47\xf7\x93\x08\x43\xca\x11\xff\x2d\xae\x19\x7a\x99\x3f\x5e\x9d\xf5\x08\xbf\x21\xfe\xf6\xcf\xce\x7b\xb7\xd8\x61\xb7\x72\x8c\x90\x48\x0c\x12\x57\xfd\x41\x8f\x88\x2e\xf0\xe7\x9a\x1a\x97\xbd\xd4\x7b\x7d\x6e\x30\xcc\x2c\xb1\x6c\xd2\x80\x64\xb7\xe2\x8f\x2f\xfa\x91\x63\xdc\xc1\xea\x8a\x1b\x24\x19\x66\x74\x58\x5f\xa2\x43\x7f\x1c\x10\x5b\x11\xc9\x4a\xce\x3c\x23\x67\xf6\x5e\x72\xe6\x8d\x05\x0f\x0e\x7f\x88\x05\x37\x20\xef\x98\xf1\xa9\xff\x70\x5c\xa4\xad\x22\xdf\x60\xe5\x48\xbb\xc2\x74\x83\x95\xe5\x65\xd6\x59\xaf\x8f\x2b\x44\xd9\xd6\x73\xa2\xac\xb3\x43\x34\xce\xa6\x3f\x50\x85\xa0\x71\x96\x31\xd7\x65\x01\x9f\x03\xa6\x62\xa6\x83\xd9\x64\x12\x02\xc1\xa5\xd3\x81\x88\x1b\x2f\xc0\x6a\x8b\xe3\xa8\x61\x13\x74\x7f\x6e\x76\xf6\x86\x18\x77\xd1\xeb\xf0\xd9\xb4\xd0\x53\xc8\x25\xd6\xad\xad\x65\x71\xc2\x99\x08\x93\x2d\xd9\xbc\xbf\x1f\xdf\xef\x3f\xd8\x6c\x7c\xdf\x7e\xc0\x07\xf6\x0e\xd3\xde\xc1\xc3\xc3\xc3\x36\x97\x98\xb0\x66\xa1\xd1\x49\x2a\x63\x0a\x86\x7a\xcd\x56\x6b\x27\xf3\x27\x8e\xce\x27\x73\x96\x56\x5d\x11\x50\xec\x38\xa4\xb3\x81\x7b\x8e\x67\x61\xb8\x45\xe7\x1f\x62\x59\x2f\xe3\xa6\xe5\xb8\x16\xfb\x1b\x0b\xa5\x97\x1a\x0a\x45\xe3\x71\x09\x6c\x36\x99\xc5\x1e\x0d\x34\xe9\x10\xfc\x3c\xbc\xbe\x6a\x55\x7d\xbf\x10\xbc\x7d\x04\x0b\xe2\x74\x48\xa3\xd2\xb5\x27\x26\x37\x70\xa7\x68\xbc\xe4\x99\x79\xa3\x86\xc4\x44\xe8\x97\x9b\x05\xb5\x17\x40\x04\xac\xdb\xed\x32\x0b\x67\x76\x1c\xae\xe4\x65\x05\x8e\x58\x9d\x66\xfb\x4e\x75\xd9\xa5\x49\xf1\x83\x29\xc4\x3e\xad\x81\xba\x6e\x96\xcb\x46\x2b\x75\x1c\x59\x71\x8b\xfc\xf5\xeb\x2d\xe6\x1c\xd8\xa6\xfb\xaf\x95\xdb\x4a\xb7\xd9\x6e\xd5\x61\x53\x5a\x2e\x65\x4c\xe8\x85\x71\x4b\x6e\xeb\x78\x97\x0b\xdd\xfa\x92\x7f\x01\x3c\xb2\x8a\x1c\x5a\x68\x86\xff\x14\x07\x32\xb6\x54\x10\xd3\x46\xcd\x3c\x43\x14\x8f\xc0\x60\x91\x80\x67\x76\x25\x95\x75\x40\x3d\xe3\xa9\x09\xca\xaa\xa0\x59\x1d\x08\x11\xed\xd8\xe8\x5a\x1d\xb6\xe0\xed\x63\xa9\xaf\x64\xd8\xbc\xc0\x48\x9c\xb6\xd2\x57\x8b\xa3\x37\x99\xb4\xe6\x33\xcc\xe5\x4a\xdb\xc2\xba\x39\x2d\x91\xeb\x42\x58\x19\x37\xf4\x88\xa7\x7d\xbe\x40\xed\xc9\x30\xc4\x8a\x16\x86\x22\x4a\x42\x2c\x2a\x10\x19\x78\x81\xdc\x07\x83\x60\x0d\x8d\x02\x8c\x10\xe0\x67\xf4\xf5\xe4\xeb\x56\x9d\x7d\xd6\x15\x77\xac\x3a\x1a\xa1\xbe\xf1\x2d\xaa\x54\x34\x41\x5e\x1e\x29\x6c\xf1\xfe\x19\xcf\x21\x67\x25\xc2\xbb\xdb\x8b\x6d\x4f\x4d\x79\xd1\x64\xc7\xf5\x1c\x33\x33\x21\x8a\xf4\x1c\x63\xfb\x0c\xdb\xcd\x5c\x8a\xfd\x9c\x6d\x31\x93\xd2\xab\x4f\x6a\x9c\x37\xcf\xc0\x96\x48\x41\x39\xa9\x3f\x53\x5a\xaa\x17\x01\x8e\x24\x26\xaa\x34\x81\xcd\x39\x66\x8e\xa3\xa3\xd4\x9d\x4f\x01\xcf\x24\x05\xe1\x6c\x3e\x92\x18\xb4\x61\x4e\x2e\x57\x83\x6e\x1d\xe4\xb9\x62\xe4\x84\x23\x89\x91\x32\xd9\x3d\xde\x05\x06\xf4\x8e\x45\x8e\x8a\xa9\x2e\x8f\x68\xa7\x32\xba\x43\x04\x1d\x4f\x01\x66\xde\xa6\x85\xaf\x48\x94\xf9\x88\x10\x9f\x4f\xf3\x18\xa5\xb3\x34\x50\x6b\x75\x92\x6d\x60\x61\xae\xa4\x0f\xb5\x62\x83\x43\x92\x8a\xdf\xd2\x0e\xea\x44\x15\x62\xf0\x7d\x10\x29\x3e\x8d\x2e\xc9\xc6\xd6\x88\x4e\x7a\x9e\x8e\x31\xaf\x69\x96\x5e\x2a\x31\x32\xa2\x3e\xc6\x09\x40\x47\xc7\x62\x03\x93\x1c\x01\x99\xda\x99\xaa\x0b\xc7\x4a\xa1\x78\x1e\x33\x0c\xfb\x8d\x14\x76\x44\x5d\x35\xb6\x92\x01\xb4\x9e\x71\x89\x7c\x1d\x27\x94\xd3\xa6\x85\xbb\x78\x5a\xac\x83\xea\x81\x43\xad\xda\x83\x51\x1d\xdc\x3a\x29\x95\xa0\x4d\x11\xe5\x2b\xa6\x82\x3c\xa4\x50\x12\xbc\xc9\x63\x44\x93\x42\x04\x5d\x93\x58\xd5\x54\x5c\x1b\x82\x48\xca\x07\xb9\x68\xae\x22\x2c\xb6\xc2\xdc\x39\x9f\x02\xce\x46\x58\x76\x96\xcb\xe9\x54\x87\xc2\xcf\x9d\xa4\x10\xa7\x78\x3c\x85\xe6\xb3\x37\x3d\x1b\x71\x15\xff\x8f\xd2\xf7\xf7\xea\x20\x5f\x60\x3d\x6d\x57\xd3\x50\x38\x83\xdd\x61\x7c\x17\x4d\x50\x82\xd3\xae\x4e\x48\x25\x80\x6e\x58\x2c\xb4\x1e\xdd\xa7\x84\xd5\xa5\xe9\x9a\xb0\x1f\x72\x8d\xe1\xd2\xca\xeb\x9d\xbd\x54\x8b\x3d\x9a\xb1\x9e\x35\x41\xa6\x3c\xb9\x07\x1e\x85\x64\x66\xe8\x36\x13\xb9\xac\x92\x87\xd4\xd6\x09\x64\xad\xeb\x84\x7b\x58\x88\x36\xc1\x49\x15\x2a\x55\x2d\xbb\xa6\xde\xb2\x5c\xb6\xec\x8b\x24\xe5\xfc\x42\xb7\x15\x2f\x18\x24\xbb\x3b\xab\x4d\x29\x05\x77\x67\xd3\xaa\x10\x50\x5c\xfd\x75\xd7\x6e\x34\xfc\x5b\xfd\xf0\xef\x68\xb9\x6d\x4d\x0b\xbd\xb7\x6e\x54\x4f\xdc\xfc\x7a\xe6\xc4\xcd\xaf\x90\x57\x2b\x46\x79\x9b\xae\x99\xff\x0d\x90\xc7\x32\x38\x7b\x16\x00\x00"),
 		},
 		"/map_gl.html": &vfsgen۰CompressedFileInfo{
 			name:             "map_gl.html",
-			modTime:          mustUnmarshalTextTime("2017-11-03T23:30:04.529481546Z"),
-			uncompressedSize: 3601,
+			modTime:          mustUnmarshalTextTime("2026-10-16T12:14:22.199859000Z"),
+			uncompressedSize: 3523,
 
-			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xdc\x57\x5f\x6f\xdb\xb6\x17\x7d\xf7\xa7\xb8\x3f\xfd\xb6\xd2\xc6\x6c\xc9\x75\xd1\xad\x90\xad\x0c\x6d\x53\x14\x1d\xd2\x24\x98\x53\x0c\x5b\x10\x04\xb4\x74\x2d\xb3\xa3\x48\x81\xa4\xdd\x38\x82\xbe\xfb\x40\xca\xb1\x6c\x4b\x76\x87\xf6\x6d\x7c\xb1\xc8\x7b\xee\x1f\x1e\x1d\x91\xd7\x45\x01\x09\xce\x99\x40\xf0\x32\x9a\xdf\xa7\xdc\x83\xb2\xec\x4c\xfe\x77\x7e\xf5\xf6\xe6\xcf\xeb\x77\xb0\x30\x19\x3f\xeb\x4c\x9e\x7e\x90\x26\x67\x1d\x00\x80\x49\x86\x86\x42\xbc\xa0\x4a\xa3\x89\xc8\xd2\xcc\x07\xaf\x08\x04\x1b\xa3\x61\x86\xe3\x59\x51\xf8\x1f\xce\xcb\x12\xae\x15\xae\x18\x7e\x99\x04\xd5\x72\x05\xe1\x4c\xfc\x0d\x0a\x79\xe4\xb1\x58\x0a\x0f\x16\x0a\xe7\x91\x17\xcc\xe9\xca\xce\xfd\x5c\xa4\x1e\x68\xf6\x88\x3a\xf2\x5e\x8c\x1e\x5e\x8c\x3c\x30\xeb\x1c\x23\x8f\x65\x34\xc5\xc0\x9a\x77\x0b\x11\x34\xc3\x88\xd8\x2c\xb9\x54\x86\x40\x2c\x85\x41\x61\x22\xc2\x04\x33\x8c\xf2\x81\x8e\x29\xc7\xe8\x79\x3f\xa3\x0f\x2c\x5b\x66\xdb\xf9\x52\xa3\x72\x13\x3a\xe3\x18\x09\x59\xef\x41\xc7\x8a\xe5\x06\xb4\x8a\x23\xb2\x30\x26\xd7\x61\x10\xd0\x9c\xf9\x86\x71\xd4\x7e\x46\xf3\x99\x7c\xf0\x63\x99\x05\xd5\xe3\x20\xe5\x83\xcf\x3a\x58\x0d\xfd\xd1\x0b\x7f\x58\x2f\xfa\x9f\x35\x39\x9b\x04\x55\xb4\xdd\xbd\xbb\x0d\x7f\x57\xe4\x58\x6b\xe2\x28\x24\xda\xac\x39\xea\x05\xa2\xd9\xa9\xdf\xae\x55\xcf\x76\xcc\x64\xb2\x86\x02\x32\xaa\x52\x26\xc2\xe1\x18\x72\x9a\x24\x4c\xa4\xf6\xb1\xdc\xa2\xfe\x9f\xd1\x1c\x0a\xc8\xa5\x66\x86\x49\x11\xd2\x99\x96\x7c\x69\x70\x0c\x46\xe6\x16\x3a\x93\xc6\xc8\xcc\x3e\x7d\x61\x89\x59\x84\xcf\x87\xc3\x1f\x9f\x02\x4c\x82\x4d\xce\x49\x50\x09\x65\x62\x93\x6e\xca\x49\xd8\x0a\x58\x12\x91\x8c\xe6\x96\x8f\x84\xad\xf6\x78\xae\x0b\x5d\x51\x05\x33\xaa\x31\xa3\xf9\x54\x2e\x55\x8c\x10\x41\xb1\xb5\xda\x61\x85\x10\x02\x51\x54\x1b\x54\xa4\xbf\x6f\xb3\x1c\x86\x70\xeb\x88\x0d\x83\x40\xa3\x5a\xa1\xf2\xa9\x8a\x53\xa6\xa5\xe0\x4c\xa0\xa3\xf6\xb5\x8a\xdf\x7f\x98\x06\x0a\xb5\x71\x18\x16\xa3\x0e\xfe\x90\x8a\x27\xf7\x37\xa8\x14\x65\xe2\xfe\x0d\xd5\x18\x7c\xa4\xf9\xd4\x85\x08\x6c\xe4\xa0\x78\x2c\x83\x62\x5d\x06\xc5\x43\x49\xee\x9a\x99\xa7\xec\x11\x43\x18\xbd\xfc\x79\xdf\x44\x8d\x51\x6c\xb6\x74\x8c\x02\xb9\xb1\x25\xc2\xb3\x58\xe6\xeb\x31\xbc\xd3\x8a\xc1\xb3\x2c\xa1\x7a\x31\x86\x6a\xbf\x21\x7c\x9a\xbe\x9f\xf6\x9d\xa9\x0f\x37\xaf\x2f\x5f\xf7\xe1\x1c\x2f\xa4\xca\xb0\x0f\x54\x24\x70\x79\x3d\x25\xdb\xf8\x65\x2b\x71\xf6\x3d\x34\x78\x63\x49\x08\x64\x83\x38\xa4\xed\x04\xa5\x7a\x53\xd5\x11\xd7\x8c\x89\x47\x29\xb3\x10\x86\x07\xeb\xf4\xa1\x5a\x7f\x3e\xda\x29\xb6\xb3\x57\x6e\x46\xf3\x71\xa7\x76\xb0\xc2\x4e\xb9\xbf\x34\x8c\xfb\x29\x9a\xdf\xa6\x57\x97\x5d\x52\x14\xfe\xa7\xdf\x2f\xca\x92\xf4\x61\xbe\x14\xb1\x25\xb1\x8b\x7d\x47\xf7\x67\x2d\x45\x6f\x7f\x8f\x7b\x13\x9b\x82\xd3\x35\x2a\x0d\x11\xdc\xde\x8d\x1b\xef\xcb\x06\xf0\x57\x18\x1b\xa9\xee\x2b\xa0\x3f\x97\xea\x1d\x8d\x17\xdd\x6d\x2e\xad\xe2\x8b\xb5\xea\x03\x3b\xc8\x64\xc7\xc6\x27\x5f\xea\x45\xb7\x69\xdd\x72\x2e\x57\xa8\x38\x5d\x0f\x72\xc9\xd7\x03\x02\x3f\x01\xeb\xb7\x82\xb7\x4c\x6f\x1c\x48\x3b\x8c\x54\xb8\x81\xcb\x4e\x42\xa8\x2a\xf4\x59\xd2\x0e\xf7\xe6\x8c\x1b\x54\x5e\x08\xb7\xad\x76\x87\x89\x22\xaf\xdd\xdb\x59\x7f\xb0\xfa\x38\x05\xb8\x96\x7c\x9d\x4a\xe1\xb5\x22\xee\xda\x1d\x37\x9a\x9b\x33\xce\x8f\x6c\x34\xa7\x4c\x98\x10\xda\x99\xb5\xc3\x39\x0f\x62\xc9\xa5\xe5\x81\x48\x45\x45\x8a\x47\x82\xd5\x78\x99\xd3\x98\x99\x35\x09\x61\xe8\xbf\xfc\x2a\x78\x69\xec\xa1\x51\x27\x51\x98\x1c\xc9\x50\x36\x56\xcb\xde\xb8\xf3\xbd\xa2\x71\xd9\xff\x93\xa2\xb9\x60\x02\xa7\x46\x31\x91\x7e\x8b\x6e\x2c\x2f\xdf\xac\x9b\x7f\xfb\x4a\x6b\xf0\xae\x68\x7e\x39\xa5\x1a\x87\x76\x97\x23\x09\x61\xf4\x3d\x42\x09\x82\x9b\xab\xf3\xab\x10\xf4\x3a\x9b\x49\xae\x3b\x0d\x87\x83\xf3\x36\x87\x08\x04\x7e\xa9\x0f\xd2\x8f\x34\x6f\x51\x98\xed\x8d\x28\x13\xa8\x42\x20\xcd\xe3\xdc\x0e\x77\x8d\x1f\xe3\x6f\x85\x4a\xbb\x8b\xec\xd5\x29\x3d\xea\x53\xf4\x6f\x2e\x92\x70\xff\x9e\x3f\xce\xe9\x46\xd9\xa7\x42\x42\x2d\x8c\xea\x3c\x3f\xf1\x42\xa1\xee\x13\xb6\x97\x80\x9b\x9f\x76\xd9\x5e\x74\x5b\xa7\xcd\xca\x57\xdc\x9e\xee\xc1\xda\xad\x5a\x39\xea\xd5\x94\x87\x5b\x6d\x4f\x53\x9d\x26\x21\xdc\xee\x5e\xfd\x77\x7e\x2c\x45\x4c\x4d\xb7\xb2\xf6\x9a\x7a\x6b\x06\xcb\xe8\xc3\x5f\xae\xce\x8f\xd4\x2c\xec\xd6\xba\x87\x05\xf7\xf7\xda\x8b\xa7\xd5\x5e\x33\x54\xb5\xdf\xda\x7f\x26\x97\x22\xd1\xbd\x5f\x61\xb8\xc3\x42\x8c\xc2\xa0\xba\x1d\xb5\x7c\xe5\x95\xa9\x81\xf5\x35\x67\x31\x76\x87\x7d\x18\xf5\x1a\x1f\xc3\xee\x7c\xbf\xeb\x99\x37\x2b\x69\xea\xc8\x35\x4f\xce\x68\x5b\x85\x03\xfc\x36\xf1\xa8\x57\x37\x1e\xfb\xb6\x51\xd3\xc0\x51\xa4\x66\xd1\x3b\x68\x3b\x2a\xaa\x73\x7f\xce\xcc\x1b\x87\xeb\x6e\x6a\xda\x87\x95\xc7\x37\x64\xbd\x69\x92\xbc\x95\xc2\x28\xc9\xbb\x7b\x5f\xfc\x25\x5d\xb1\x94\xba\xbe\xa5\xb7\x13\xf1\x89\xa0\xfa\x7f\xc8\x24\xa8\x7a\xf3\x49\x50\xfd\xb5\x2b\x0a\x40\x91\x40\x59\xfe\x13\x00\x00\xff\xff\xe7\xfd\x7c\x4e\x11\x0e\x00\x00"),
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03\xcd\x57\x61\x6f\xdb\x36\x10\xfd\x9e\x5f\x71\xf3\xb6\xd2\xc6\x64\xc9\x71\xd2\xad\x50\xec\x60\x69\x13\x14\x05\xd2\x2c\xa8\x53\x0c\x5b\x51\x04\xb4\x44\xcb\x5c\x25\x52\x20\x29\x27\x8e\xa1\xff\xbe\xa3\x24\xdb\xb2\xac\x34\x5d\x3e\x0c\x15\x60\x98\x14\xdf\xdd\x3d\xbe\xbb\xa3\xa4\xd5\x0a\x42\x36\xe3\x82\x41\x27\xa1\xe9\x6d\x14\x77\x20\xcf\x0f\x46\x3f\x9c\xff\xf1\xe6\xe6\xaf\xeb\x0b\x98\x9b\x24\x3e\x3d\x18\xad\xff\x18\x0d\x4f\x0f\x00\xaf\x51\xc2\x0c\x85\x60\x4e\x95\x66\x66\x4c\x32\x33\xeb\xbf\x22\xe0\x55\x8b\x86\x9b\x98\x9d\xae\x56\xee\xbb\xf3\x3c\x87\x6b\xc5\x16\x9c\xdd\x8d\xbc\xf2\x76\x09\x89\xb9\xf8\x02\x8a\xc5\xe3\x0e\x0f\xa4\xe8\xc0\x5c\xb1\xd9\xb8\x83\x26\x1f\xa4\x34\x79\xee\xcd\xe8\xc2\x2e\xb8\xa9\x88\x3a\xa0\xf9\x03\xd3\xe3\xce\xd1\xf0\xfe\x68\xd8\x01\xb3\x4c\x19\x9a\x25\x34\x62\x9e\x5d\xae\x33\x12\x34\x61\x63\x62\xc3\xa5\x52\x19\x02\xe8\xc2\x30\x81\x0c\xb9\xe0\x86\xd3\xb8\xaf\x03\x1a\xb3\xf1\xa1\x93\xd0\x7b\x9e\x64\xc9\x66\x9e\x69\xa6\x8a\x09\x9d\xe2\x5c\xc8\xed\x66\x74\xa0\x78\x6a\x40\xab\x60\x4c\xe6\xc6\xa4\xda\xf7\xbc\x4c\xa4\x5f\x22\x37\x90\x89\x87\xaa\xc5\x7c\xaa\x58\x3f\x8a\x7f\x1f\xba\xc7\xee\xc0\x0b\xb9\x36\xf5\xdb\xee\x3f\x9a\x9c\x8e\xbc\xd2\x4d\x7d\xf7\xc5\x96\x9f\xe7\x32\xd0\x9a\x14\xea\x11\x6d\x96\x31\xd3\x73\xc6\x4c\x8d\xb1\xbd\x57\x8e\xed\x35\x95\xe1\x12\x56\x90\x50\x15\x71\xe1\x0f\x4e\x20\xa5\x61\xc8\x45\x64\x87\xf9\x06\xf5\x23\xfa\x47\x54\x2a\x35\x0a\x25\x85\x4f\xa7\x5a\xc6\x99\x61\x27\x60\x64\x6a\xa1\x53\x69\x8c\x4c\xec\xe8\x8e\x87\x66\xee\x1f\x0e\x06\x3f\xaf\x1d\xe0\xf6\xca\x98\x23\xaf\xac\x91\x91\x0d\x5a\xd1\x09\xf9\x02\x78\x38\x26\x18\xc0\x0a\x81\xd3\x1d\x65\xb7\x44\x17\x54\x41\xc2\xc5\xdf\x52\x26\x30\x06\xac\x85\xf7\xe5\x24\xcf\x4f\x76\x31\xf4\xbe\x86\x29\x27\x4d\xcc\x54\x66\x22\xd4\x25\xe4\x75\x31\xb6\x88\x5d\x08\xd5\x0c\x39\x4d\x64\xa6\x02\x66\x91\x9b\x55\x7b\xd9\x2a\xf3\x81\x28\xaa\x0d\x53\xc4\xd9\x5d\xe3\xa8\xb9\x0f\x9f\x36\xc9\xc3\xe2\x59\x30\xe5\x52\x15\x44\x5c\x4b\x81\xe9\x65\x45\x2a\xcf\x54\xf0\xf6\xdd\xc4\x53\x0c\xf3\x67\x31\x3c\x60\xda\xfb\x53\xaa\x38\xbc\xbd\x61\x4a\x51\x2e\x6e\x5f\x23\x0b\xef\x3d\xd2\x28\x5c\x78\xd6\xb5\xb7\x7a\xc8\xbd\xd5\x12\x7f\xf7\x39\xf9\xbc\x1f\x7a\x82\xed\xe0\xc3\xf0\xe5\xaf\xbb\x4b\x28\xcb\x03\x2a\xe1\xc3\xe1\xd1\xee\x02\x35\x46\xf1\x69\x56\xa4\x15\xc8\x8d\x25\x0f\x2f\x02\x99\x2e\x4f\xe0\x42\x2b\x0e\x2f\x92\x90\xea\xf9\x09\x94\x4a\xf8\xf0\x71\xf2\x76\xe2\x14\x4b\x0e\xdc\x9c\x5d\x9d\x39\x70\xce\x2e\xa5\x4a\x98\x03\x54\x84\x70\x75\x3d\x21\x1b\xff\x79\xab\xa4\xb6\x18\xf6\x14\xe5\x21\x46\xaf\x10\x4d\x41\xbf\x22\xb6\xae\x58\x6d\x4c\x6b\xb1\x1b\x65\x91\x62\x4c\xc1\xee\x60\xdd\x2a\xd8\x29\xa8\x6c\x77\x97\x86\x3d\x12\x50\x78\xa6\xd0\xe5\x3e\x93\xa2\x8e\xfd\x06\xf3\x22\x02\x53\xba\x10\xf0\x95\xb3\xb7\x56\x52\xd4\x6d\x66\x45\x03\x96\xc4\xfd\xdd\x8a\xdb\x83\xe6\xfb\x8e\x63\xba\xc4\xb0\x58\x69\x75\x61\x3f\x1f\x7c\xc5\xaa\xac\x7b\x6b\x51\x0c\x5c\x1d\x63\xcd\x75\x07\x0e\x0c\x7b\x0e\xec\xdc\x1b\x3a\x70\xdc\x6b\x54\x57\xd5\x7d\xfe\x7a\xb0\x95\xba\xb7\x6d\x2f\xe4\xe1\xe2\x09\xf2\x06\x75\x54\x32\xee\x36\x04\xbf\xc2\x83\x3b\xa2\xb6\xd6\xd6\x80\x5e\xaf\xd6\x78\x33\x66\x82\x79\x97\x60\x57\x7e\xfc\x70\x99\xe7\xa4\xe7\x9a\x39\x13\xdd\x59\x26\x02\x6b\xd3\xc5\x56\x49\xa5\xd0\xac\xd7\xd0\x52\x31\x93\x29\x01\xeb\x65\x3c\x54\x11\x5c\x23\x95\x37\x1d\xd9\x46\xb1\xa0\xa6\xa3\x8a\x7d\x99\x83\x2e\x91\x98\x57\x54\x99\x38\x2d\xb9\xab\xaa\x72\xc1\x02\x23\x9b\x55\x59\x3b\x06\xd6\x91\xdc\x62\xde\x52\x1d\xc1\x9c\x25\xac\x86\x2b\x6f\xec\x03\x51\xf4\x87\xba\xfa\x2d\x88\x75\x8b\x57\x47\xe0\x6e\x25\xd4\x75\x5e\xf3\x2b\x02\x96\x3b\xb8\x2d\xab\xc9\x9d\x49\x75\x41\x31\x09\x1b\xa9\xf0\xd1\x76\xb9\x54\x0e\xf0\x5e\x8b\x08\x95\x5e\x97\xd6\xb6\xdb\x5e\xdf\x45\x63\x57\x42\xf6\x53\x19\x2f\xfb\x04\x7e\x01\xee\xb4\x82\x37\xed\xbc\x51\xbe\x15\x46\x4a\x5c\xbf\xe0\x4c\x7c\x28\x39\xba\x3c\x6c\x87\xcf\x78\x6c\x6c\x47\x7f\x22\xe3\x31\xe6\x92\xfc\x64\x73\x67\x07\xd7\x48\x27\x92\xa2\x79\x8c\x36\x52\x8c\xe6\xf1\x23\x44\x52\x3c\x2b\xcc\x63\x9d\x5d\x30\xb5\xc6\xfd\x40\xc6\xd2\xf2\x24\x52\x51\x11\xb1\x47\x9c\x6d\xf1\x32\xa5\x01\x37\x4b\xb4\x18\xb8\x2f\x9f\x04\x67\xc6\x3e\x52\xb6\x41\x14\x0b\x49\xab\x4d\xbe\x7f\xaa\x34\xab\xe2\x39\x49\x2d\xa2\x7f\x3f\x49\xbd\x44\x3a\x13\x7c\xa6\x89\xe8\x89\xbc\x5a\xde\xcf\xce\xeb\xbe\xe4\xce\x13\xe0\x7a\x52\x7f\x7b\xf9\x14\xba\x78\x8d\x42\xec\xf0\xff\xcc\x64\x2a\x71\xdb\xdf\x55\x7f\x22\x9f\x27\xb2\x18\x70\x15\xc4\xcf\xcf\x63\x69\xfe\xcd\x99\xac\xe0\xdf\x9c\xcb\x0a\xaf\x68\xc8\x33\x8d\xf0\xe3\xff\x90\xce\xc7\xe6\xeb\xf1\xf6\xb3\x61\xe4\x95\x6f\xd4\xf8\x82\x5d\x7c\x8b\xad\x56\xc0\xf0\x5d\x0c\x3f\xd6\xfe\x05\xce\xbe\x28\x2e\xc3\x0d\x00\x00"),
 		},
 		"/static": &vfsgen۰DirInfo{
 			name:    "static",
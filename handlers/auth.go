@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// APIKey grants access to the tilesets whose IDs match one of the glob
+// patterns in Tilesets, as understood by path.Match, until it expires. As for
+// path.Match, "*" does not match the "/" of tileset IDs in subdirectories.
+type APIKey struct {
+	Key      string   `json:"key"`
+	Tilesets []string `json:"tilesets"`
+	// Expires is the time after which the key is no longer valid. The key
+	// never expires if it is zero.
+	Expires time.Time `json:"expires"`
+}
+
+// expired reports whether the key is no longer valid at time now.
+func (k *APIKey) expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// allows reports whether the key grants access to the tileset id.
+func (k *APIKey) allows(id string) bool {
+	for _, pattern := range k.Tilesets {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAPIKeys reads API keys from the JSON file filename, which contains an
+// array of objects with the fields "key", "tilesets" and the optional
+// "expires" in RFC 3339 format.
+func LoadAPIKeys(filename string) ([]APIKey, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("cannot parse API keys in %s: %v", filename, err)
+	}
+	for i, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("API key %d in %s is empty", i, filename)
+		}
+		for _, pattern := range k.Tilesets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid tileset pattern %q for API key %d in %s", pattern, i, filename)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// requestAPIKey returns the API key or JSON Web Token of the request r, taken
+// from the "key" query parameter or a bearer token in the Authorization
+// header.
+func requestAPIKey(r *http.Request) string {
+	if key := r.URL.Query().Get("key"); key != "" {
+		return key
+	}
+	const prefix = "Bearer "
+	if a := r.Header.Get("Authorization"); len(a) > len(prefix) && strings.EqualFold(a[:len(prefix)], prefix) {
+		return strings.TrimSpace(a[len(prefix):])
+	}
+	return ""
+}
+
+// findAPIKey returns the APIKey of the ServiceSet that matches key or nil.
+func (s *ServiceSet) findAPIKey(key string) *APIKey {
+	var found *APIKey
+	for i := range s.APIKeys {
+		// compare every key in constant time to not leak valid keys
+		if subtle.ConstantTimeCompare([]byte(s.APIKeys[i].Key), []byte(key)) == 1 {
+			found = &s.APIKeys[i]
+		}
+	}
+	return found
+}
+
+// authorize checks that the request r may access the tileset id, with its
+// credentials as well as the TilesetPolicy and the ACLs of the ServiceSet. It
+// returns http.StatusOK if it may, otherwise http.StatusUnauthorized or
+// http.StatusForbidden together with an error.
+func (s *ServiceSet) authorize(r *http.Request, id string) (int, error) {
+	p := s.policy(id)
+	if p == nil || !p.Public {
+		if status, err := s.authenticate(r, id); status != http.StatusOK {
+			return status, err
+		}
+	}
+	if status, err := s.checkPolicy(r, id, p); status != http.StatusOK {
+		return status, err
+	}
+	return s.checkACLs(r, id)
+}
+
+// authenticate checks the credentials of the request r for the tileset id
+// like authorize, but without the ACLs. All requests are authenticated if the
+// ServiceSet has neither APIKeys, JWT nor URLSigningKey.
+func (s *ServiceSet) authenticate(r *http.Request, id string) (int, error) {
+	if len(s.APIKeys) == 0 && s.JWT == nil && len(s.URLSigningKey) == 0 {
+		return http.StatusOK, nil
+	}
+	if len(s.URLSigningKey) > 0 && r.URL.Query().Get("sig") != "" {
+		if err := verifySignedURL(s.URLSigningKey, r); err != nil {
+			return http.StatusUnauthorized, fmt.Errorf("%v for tileset %s", err, id)
+		}
+		return http.StatusOK, nil
+	}
+	key := requestAPIKey(r)
+	if key == "" {
+		return http.StatusUnauthorized, nil
+	}
+	if s.JWT != nil && isJWT(key) {
+		ok, err := s.JWT.allows(key, id)
+		if err != nil {
+			return http.StatusUnauthorized, fmt.Errorf("%v for tileset %s", err, id)
+		}
+		if !ok {
+			return http.StatusForbidden, nil
+		}
+		return http.StatusOK, nil
+	}
+	k := s.findAPIKey(key)
+	if k == nil {
+		return http.StatusUnauthorized, fmt.Errorf("unknown API key for tileset %s", id)
+	}
+	if k.expired(time.Now()) {
+		return http.StatusUnauthorized, fmt.Errorf("expired API key for tileset %s", id)
+	}
+	if !k.allows(id) {
+		return http.StatusForbidden, nil
+	}
+	return http.StatusOK, nil
+}
+
+// admit is like authorize, but also asks the client for credentials with the
+// WWW-Authenticate header of w if the request r is not authorized, and
+// limits the rate of authorized requests.
+func (s *ServiceSet) admit(w http.ResponseWriter, r *http.Request, id string) (int, error) {
+	status, err := s.authorize(r, id)
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mbtileserver"`)
+	}
+	if status != http.StatusOK {
+		return status, err
+	}
+	return s.limit(w, r), nil
+}
+
+// authorized returns a handlerFunc that serves requests to the tileset id
+// with hf, if they are authorized.
+func (s *ServiceSet) authorized(id string, hf handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if status, err := s.admit(w, r, id); status != http.StatusOK {
+			return status, err
+		}
+		return hf(w, r)
+	}
+}
+
+// withAPIKey appends the API key from the "key" query parameter of r, if
+// any, to the URL u, so that clients can follow it with the same key.
+func withAPIKey(r *http.Request, u string) string {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return u
+	}
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	return u + sep + "key=" + url.QueryEscape(key)
+}
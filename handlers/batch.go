@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// MaxBatchTiles is the maximum number of tiles of a batch request.
+const MaxBatchTiles = 1000
+
+// maxBatchBody limits the size of the body of batch requests.
+const maxBatchBody = 1 << 20
+
+// batchRequest is the body of a batch request, which lists the tiles as
+// "z/x/y" in the scheme of the tile URLs.
+type batchRequest struct {
+	Tiles []string `json:"tiles"`
+}
+
+// batch serves POST requests for several tiles of the tileset id at once,
+// like {"tiles": ["3/4/2", "3/4/3"]}. The tiles are streamed as parts of a
+// multipart/mixed response, whose Content-Location headers are their URL
+// paths. Clients that accept application/octet-stream receive them as a
+// sequence of the zoom level (1 byte), column, row and length of the tile
+// data (4 bytes each, big endian) followed by the data, with the
+// X-Tile-Content-Type and X-Tile-Content-Encoding headers of the tiles in the
+// response. Tiles that do not exist or that are vetoed by the TileInterceptors
+// are omitted.
+func (s *ServiceSet) batch(id string, db Tileset) handlerFunc {
+	p := s.policy(id)
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		var req batchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&req); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid batch request: %v", err)
+		}
+		if len(req.Tiles) > MaxBatchTiles {
+			return http.StatusBadRequest, fmt.Errorf("batch request for %d tiles exceeds the limit of %d", len(req.Tiles), MaxBatchTiles)
+		}
+		tcs := make([]tileCoord, len(req.Tiles))
+		for i, t := range req.Tiles {
+			pcs := strings.Split(t, "/")
+			if len(pcs) != 3 {
+				return http.StatusBadRequest, fmt.Errorf("invalid tile %q, expected z/x/y", t)
+			}
+			tc, _, err := tileCoordFromString(pcs[0], pcs[1], pcs[2])
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			tcs[i] = tc
+		}
+		enc, err := negotiateEncoding(r, db.TileEncoding())
+		if err != nil {
+			return http.StatusNotAcceptable, err
+		}
+
+		binaryStream := strings.Contains(r.Header.Get("Accept"), "application/octet-stream")
+		var mw *multipart.Writer
+		if binaryStream {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("X-Tile-Content-Type", db.TileFormat().ContentType())
+			if ce := enc.ContentEncoding(); ce != "" {
+				w.Header().Set("X-Tile-Content-Encoding", ce)
+			}
+		} else {
+			mw = multipart.NewWriter(w)
+			w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		}
+		w.Header().Add("Vary", "Accept")
+		// the path of the root URL, as the URLs are relative to its host
+		prefix := ""
+		if u, err := url.Parse(s.RootURL(r)); err == nil {
+			prefix = strings.TrimSuffix(u.Path, "/")
+		}
+		for i, tc := range tcs {
+			y := tc.y
+			if s.Scheme == mbtiles.TMS {
+				y = (1 << uint64(tc.z)) - 1 - y
+			}
+			t := TileRequest{Tileset: id, Z: tc.z, X: tc.x, Y: y, Ext: "." + db.TileFormat().String(), Scale: 1}
+			if !p.allowsZoom(int(tc.z)) || s.interceptRequest(r, t) != 0 {
+				continue
+			}
+			var data []byte
+			err := db.ReadTileContext(r.Context(), tc.z, tc.x, y, &data)
+			if err == mbtiles.ErrTileNotFound || (err == nil && len(data) <= 1) {
+				continue
+			}
+			if err == nil && enc != db.TileEncoding() {
+				data, err = transcodeToGzip(data, db.TileEncoding())
+			}
+			if err != nil {
+				// the status has been written with the first tile
+				return http.StatusOK, fmt.Errorf("cannot fetch tile %s of batch: %v", req.Tiles[i], err)
+			}
+			if binaryStream {
+				err = writeBatchTile(w, tc, data)
+			} else {
+				h := make(textproto.MIMEHeader)
+				h.Set("Content-Type", db.TileFormat().ContentType())
+				if ce := enc.ContentEncoding(); ce != "" {
+					h.Set("Content-Encoding", ce)
+				}
+				h.Set("Content-Location", fmt.Sprintf("%s/services/%s/tiles/%d/%d/%d.%s", prefix, id, tc.z, tc.x, tc.y, db.TileFormat().String()))
+				var part io.Writer
+				if part, err = mw.CreatePart(h); err == nil {
+					_, err = part.Write(data)
+				}
+			}
+			if err != nil {
+				return http.StatusOK, err
+			}
+		}
+		if mw != nil {
+			return http.StatusOK, mw.Close()
+		}
+		return http.StatusOK, nil
+	}
+}
+
+// writeBatchTile writes the tile data at tc to the binary stream of a batch
+// response.
+func writeBatchTile(w io.Writer, tc tileCoord, data []byte) error {
+	var h [13]byte
+	h[0] = tc.z
+	binary.BigEndian.PutUint32(h[1:], uint32(tc.x))
+	binary.BigEndian.PutUint32(h[5:], uint32(tc.y))
+	binary.BigEndian.PutUint32(h[9:], uint32(len(data)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
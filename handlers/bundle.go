@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// MaxBundleTiles is the maximum number of tiles that the region of a bundle
+// may cover.
+const MaxBundleTiles = 1 << 18
+
+// parseBundleFilter returns the filter of the tiles of a bundle of db from
+// the bbox, minzoom and maxzoom query parameters. The zoom levels default to
+// those of db and are restricted to those allowed by the TilesetPolicy p.
+func parseBundleFilter(r *http.Request, db Tileset, p *TilesetPolicy) (mbtiles.TileFilter, error) {
+	var f mbtiles.TileFilter
+	q := r.URL.Query()
+	minZoom, maxZoom := zoomRange(db)
+	for _, p := range []struct {
+		key string
+		z   *int
+	}{{"minzoom", &minZoom}, {"maxzoom", &maxZoom}} {
+		if v := q.Get(p.key); v != "" {
+			z, err := strconv.Atoi(v)
+			if err != nil || z < 0 || z > 30 {
+				return f, fmt.Errorf("invalid %s %q", p.key, v)
+			}
+			*p.z = z
+		}
+	}
+	if minZoom > maxZoom {
+		return f, fmt.Errorf("minzoom %d exceeds maxzoom %d", minZoom, maxZoom)
+	}
+	lo, hi := p.zooms(minZoom, maxZoom)
+	if lo > hi {
+		return f, fmt.Errorf("zoom levels %d to %d are not available", minZoom, maxZoom)
+	}
+	f.Zooms = mbtiles.ZoomRange(uint8(lo), uint8(hi))
+	if v := q.Get("bbox"); v != "" {
+		bbox, err := parseFloats(v)
+		if err != nil || len(bbox) != 4 || bbox[0] >= bbox[2] || bbox[1] >= bbox[3] {
+			return f, fmt.Errorf("invalid bbox %q, expected west,south,east,north", v)
+		}
+		f.Bounds = bbox
+	}
+	return f, nil
+}
+
+// bundle serves the tiles of the tileset id within the region given by the
+// bbox, minzoom and maxzoom query parameters for offline use. By default, the
+// response is a new mbtiles file with the tiles and the metadata of the
+// tileset. With format=zip, it is a zip archive of the tiles as z/x/y files in
+// the scheme of the tile URLs, which is streamed while the tiles are read.
+// The formats are available for Extractors and TileListers, respectively.
+func (s *ServiceSet) bundle(id string, db Tileset) handlerFunc {
+	p := s.policy(id)
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		f, err := parseBundleFilter(r, db, p)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if len(s.TileInterceptors) > 0 {
+			f.Select = func(z uint8, x, y uint64) bool {
+				return s.interceptRead(r, id, db, tileCoord{z, x, y}) == 0
+			}
+		}
+		if n := mbtiles.CountTiles(f); n > MaxBundleTiles {
+			return http.StatusBadRequest, fmt.Errorf("bundle covers %d tiles, which exceeds the limit of %d", n, MaxBundleTiles)
+		}
+		name := filepath.Base(id)
+		e, isExtractor := db.(Extractor)
+		l, isLister := db.(TileLister)
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "mbtiles":
+			if !isExtractor {
+				return http.StatusBadRequest, fmt.Errorf("tileset %q cannot be bundled as mbtiles file", id)
+			}
+			return writeMBTilesBundle(w, r, e, f, name)
+		case "zip":
+			if !isLister {
+				return http.StatusBadRequest, fmt.Errorf("tileset %q cannot be bundled as zip archive", id)
+			}
+			return s.writeZipBundle(w, r, db, l, f, name)
+		default:
+			return http.StatusBadRequest, fmt.Errorf("unknown bundle format %q", format)
+		}
+	}
+}
+
+// writeMBTilesBundle writes the tiles of e that match f as an mbtiles file
+// named name to w. SQLite cannot write to a stream, so the file is created in
+// a temporary directory first.
+func writeMBTilesBundle(w http.ResponseWriter, r *http.Request, e Extractor, f mbtiles.TileFilter, name string) (int, error) {
+	dir, err := ioutil.TempDir("", "mbtileserver-bundle-")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, name+".mbtiles")
+	if err := e.Extract(r.Context(), filename, f); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("could not create bundle: %v", err)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", "application/vnd.mbtiles")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".mbtiles"))
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	_, err = io.Copy(w, file)
+	return http.StatusOK, err
+}
+
+// writeZipBundle streams the tiles of db that match f, which are listed by l,
+// as a zip archive named name to w.
+func (s *ServiceSet) writeZipBundle(w http.ResponseWriter, r *http.Request, db Tileset, l TileLister, f mbtiles.TileFilter, name string) (int, error) {
+	it, err := l.Tiles(r.Context(), f)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer it.Close()
+	// tiles of image formats and gzip compressed tiles hardly shrink any
+	// further
+	method := zip.Store
+	if db.TileFormat() == mbtiles.PBF && db.TileEncoding() == mbtiles.IDENTITY {
+		method = zip.Deflate
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	zw := zip.NewWriter(w)
+	for it.Next() {
+		t := it.Tile()
+		y := t.Y
+		if s.Scheme != mbtiles.XYZ {
+			y = (1 << uint64(t.Z)) - 1 - y
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("%d/%d/%d.%s", t.Z, t.X, y, db.TileFormat().String()),
+			Method: method,
+		})
+		if err == nil {
+			_, err = fw.Write(t.Data)
+		}
+		if err != nil {
+			// the status has been written with the first tile
+			return http.StatusOK, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return http.StatusOK, fmt.Errorf("could not read tiles of bundle: %v", err)
+	}
+	return http.StatusOK, zw.Close()
+}
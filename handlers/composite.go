@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// compositeMaxTilesets is the maximum number of tilesets that are combined
+// into one composite tile.
+const compositeMaxTilesets = 16
+
+// compositeRequest is a parsed request for a composite tile.
+type compositeRequest struct {
+	ids     []string
+	dbs     []Tileset
+	opacity []float64
+	tc      tileCoord
+	ext     string
+}
+
+// parseComposite parses the path "<id>,<id>.../<z>/<x>/<y>[.<ext>]" below
+// "/composite" and the opacity of each tileset, which is given by the comma
+// separated "opacity" query parameter in the order of the tilesets and
+// defaults to 1. The status of the response is returned with any error.
+func (s *ServiceSet) parseComposite(r *http.Request, tilesets map[string]Tileset) (*compositeRequest, int, error) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/composite"), "/")
+	pcs := strings.Split(p, "/")
+	l := len(pcs)
+	if l < 4 {
+		return nil, http.StatusNotFound, nil
+	}
+	z, x, y := pcs[l-3], pcs[l-2], pcs[l-1]
+	logTile(r, z, x, y)
+	tc, ext, err := tileCoordFromString(z, x, y)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if s.Scheme == mbtiles.TMS {
+		tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+	}
+	c := &compositeRequest{tc: tc, ext: ext}
+	// the ids of the tilesets may contain slashes, but no commas
+	idlist := strings.Join(pcs[:l-3], "/")
+	if e := accessLogEntryFrom(r); e != nil {
+		e.Tileset = idlist
+	}
+	c.ids = strings.Split(idlist, ",")
+	if len(c.ids) > compositeMaxTilesets {
+		return nil, http.StatusBadRequest, fmt.Errorf("cannot combine more than %d tilesets", compositeMaxTilesets)
+	}
+	for _, id := range c.ids {
+		db, ok := tilesets[id]
+		if !ok {
+			return nil, http.StatusNotFound, nil
+		}
+		c.dbs = append(c.dbs, db)
+	}
+
+	c.opacity = make([]float64, len(c.ids))
+	for i := range c.opacity {
+		c.opacity[i] = 1
+	}
+	if v := r.URL.Query().Get("opacity"); v != "" {
+		values, err := parseFloats(v)
+		if err != nil || len(values) != len(c.ids) {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid opacity %q: expected one value per tileset", v)
+		}
+		for i, o := range values {
+			if o < 0 || o > 1 {
+				return nil, http.StatusBadRequest, fmt.Errorf("opacity %v is not between 0 and 1", o)
+			}
+			c.opacity[i] = o
+		}
+	}
+	return c, http.StatusOK, nil
+}
+
+// readCompositeTile reads the tile at tc from db of the tileset id for r,
+// decompressed if decompress is set. It returns nil if the tile does not
+// exist, is empty, beyond the zoom levels of the TilesetPolicy of id or vetoed
+// by a TileInterceptor.
+func (s *ServiceSet) readCompositeTile(r *http.Request, id string, db Tileset, tc tileCoord, decompress bool) ([]byte, error) {
+	if !s.policy(id).allowsZoom(int(tc.z)) || s.interceptRead(r, id, db, tc) != 0 {
+		return nil, nil
+	}
+	var data []byte
+	var err error
+	start := time.Now()
+	if decompress {
+		err = readTileDecompressed(r.Context(), db, tc.z, tc.x, tc.y, &data)
+	} else {
+		err = db.ReadTileContext(r.Context(), tc.z, tc.x, tc.y, &data)
+	}
+	s.metrics.observeQuery(db, time.Since(start))
+	if err == mbtiles.ErrTileNotFound || err == nil && len(data) <= 1 {
+		return nil, nil
+	}
+	return data, err
+}
+
+// compositeStatus returns the status of the response to a request for a
+// composite tile that failed with err while reading a tile.
+func compositeStatus(err error) int {
+	if err == mbtiles.ErrQueryTimeout {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// compositeRaster overlays the raster tiles of c, from the first tileset at
+// the bottom to the last one at the top, and encodes the result in the format
+// of the extension of the request or of the first tileset. If only one tile
+// exists, it is sent as stored, unless it has to be converted or faded.
+func (s *ServiceSet) compositeRaster(w http.ResponseWriter, r *http.Request, c *compositeRequest) (int, error) {
+	format := c.dbs[0].TileFormat()
+	if f, ok := formatFromExt(c.ext); ok {
+		format = f
+	}
+	if imageCodecs[format].encode == nil {
+		return http.StatusBadRequest, fmt.Errorf("cannot encode composite tiles of format %q", format)
+	}
+	var dst *image.RGBA
+	var single []byte
+	var n int
+	for i, db := range c.dbs {
+		if !canConvert(db.TileFormat(), format) {
+			return http.StatusBadRequest, fmt.Errorf("cannot combine %s tiles of tileset %s into %s tiles", db.TileFormat().String(), c.ids[i], format)
+		}
+		if c.opacity[i] == 0 {
+			continue
+		}
+		data, err := s.readCompositeTile(r, c.ids[i], db, c.tc, false)
+		if err != nil {
+			return compositeStatus(err), fmt.Errorf("cannot fetch tile of tileset %s for z=%d, x=%d, y=%d: %v", c.ids[i], c.tc.z, c.tc.x, c.tc.y, err)
+		}
+		if data == nil {
+			continue
+		}
+		if n++; n == 1 && db.TileFormat() == format && c.opacity[i] == 1 {
+			single = data
+		} else {
+			single = nil
+		}
+		img, err := decodeTile(data, db.TileFormat())
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		b := img.Bounds()
+		if dst == nil {
+			dst = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		}
+		if b.Dx() != dst.Bounds().Dx() || b.Dy() != dst.Bounds().Dy() {
+			img = resample(img, b, dst.Bounds().Dx(), dst.Bounds().Dy())
+			b = img.Bounds()
+		}
+		mask := image.NewUniform(color.Alpha{uint8(c.opacity[i]*0xff + 0.5)})
+		draw.DrawMask(dst, dst.Bounds(), img, b.Min, mask, image.ZP, draw.Over)
+	}
+	if dst == nil {
+		return tileNotFoundHandler(w, format)
+	}
+	data := single
+	if data == nil {
+		var err error
+		if data, err = encodeTile(dst, format, s.ImageQuality); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	return writeWithETag(w, r, data)
+}
+
+// compositeVector combines the layers of the vector tiles of c into one tile.
+// Layers of the same name are merged into one, if they have the same extent.
+// The tile is compressed with gzip if the client accepts it.
+func (s *ServiceSet) compositeVector(w http.ResponseWriter, r *http.Request, c *compositeRequest) (int, error) {
+	out := &mvt.Tile{}
+	for i, db := range c.dbs {
+		if db.TileFormat() != mbtiles.PBF {
+			return http.StatusBadRequest, fmt.Errorf("cannot combine %s tiles of tileset %s with vector tiles", db.TileFormat().String(), c.ids[i])
+		}
+		data, err := s.readCompositeTile(r, c.ids[i], db, c.tc, true)
+		if err != nil {
+			return compositeStatus(err), fmt.Errorf("cannot fetch tile of tileset %s for z=%d, x=%d, y=%d: %v", c.ids[i], c.tc.z, c.tc.x, c.tc.y, err)
+		}
+		if data == nil {
+			continue
+		}
+		tile, err := mvt.Decode(data)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot decode tile of tileset %s: %v", c.ids[i], err)
+		}
+		for _, l := range tile.Layers {
+			existing := out.Layer(l.Name)
+			if existing == nil {
+				out.Layers = append(out.Layers, l)
+				continue
+			}
+			if existing.Extent != l.Extent {
+				return http.StatusInternalServerError, fmt.Errorf("cannot merge layer %q of tileset %s with an extent of %d into one with an extent of %d", l.Name, c.ids[i], l.Extent, existing.Extent)
+			}
+			existing.Features = append(existing.Features, l.Features...)
+		}
+	}
+	if len(out.Layers) == 0 {
+		return tileNotFoundHandler(w, mbtiles.PBF)
+	}
+	data, err := mvt.Encode(out)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", mbtiles.PBF.ContentType())
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsEncoding(r, "gzip") {
+		if data, err = gzipBytes(data); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	return writeWithETag(w, r, data)
+}
+
+// CompositeHandler returns a http.Handler that serves tiles which combine the
+// tiles of several tilesets under "/composite/<id>,<id>.../<z>/<x>/<y>".
+// Raster tiles are overlaid in the order of the tilesets, each with the
+// opacity given by the comma separated "opacity" query parameter, and the
+// layers of vector tiles are combined into one tile. The request must be
+// authorized for all tilesets. The function ef is called with any occuring
+// error if it is non-nil, so it can be used for e.g. logging with logging
+// facitilies of the caller.
+func (s *ServiceSet) CompositeHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(s.rebuilt(func(tilesets map[string]Tileset) http.Handler {
+		return wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+			c, status, err := s.parseComposite(r, tilesets)
+			if c == nil {
+				return status, err
+			}
+			for _, id := range c.ids {
+				if status, err := s.authorize(r, id); status != http.StatusOK {
+					if status == http.StatusUnauthorized {
+						w.Header().Set("WWW-Authenticate", `Bearer realm="mbtileserver"`)
+					}
+					return status, err
+				}
+			}
+			if status := s.limit(w, r); status != http.StatusOK {
+				return status, nil
+			}
+			_, span := s.startSpan(r.Context(), "composite")
+			span.SetAttribute("composite.tilesets", len(c.ids))
+			defer span.End()
+			if c.dbs[0].TileFormat() == mbtiles.PBF {
+				return s.compositeVector(w, r, c)
+			}
+			return s.compositeRaster(w, r, c)
+		})
+	})))
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// etagCacheSize is the number of ETags per tileset that are remembered to
+// answer conditional requests without reading the tileset.
+const etagCacheSize = 10000
+
+// etagCache remembers the ETags of the responses to requests, by
+// representationKey. It is safe for concurrent use.
+type etagCache struct {
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{lru: lru.New(etagCacheSize)}
+}
+
+func (c *etagCache) get(k string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.lru.Get(k)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+func (c *etagCache) add(k, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Add(k, etag)
+}
+
+// representationKey identifies the representation that is sent in response
+// to r, which depends on the URL and the negotiated format and encoding.
+func representationKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.RawQuery + "\x00" + r.Header.Get("Accept") + "\x00" + r.Header.Get("Accept-Encoding")
+}
+
+// notModifiedSince reports whether the If-Modified-Since header of r is not
+// before modified.
+func notModifiedSince(r *http.Request, modified time.Time) bool {
+	t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.After(t)
+}
+
+// conditional returns a handlerFunc that answers conditional requests for the
+// responses of hf from the tileset db with 304 Not Modified, without calling
+// hf. Requests with an If-None-Match header are checked against the ETags of
+// earlier responses to the same representation, other requests with an
+// If-Modified-Since header against the time stamp of db, which is sent as
+// Last-Modified header. The ETags are remembered per tileset, so that they
+// are kept when the handlers are rebuilt.
+func (s *ServiceSet) conditional(db Tileset, hf handlerFunc) handlerFunc {
+	modified := db.TimeStamp()
+	etags := s.etagCache(db)
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		k := representationKey(r)
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			// If-Modified-Since is ignored in favor of If-None-Match
+			if etag, ok := etags.get(k); ok && etagMatches(inm, etag) {
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return http.StatusNotModified, nil
+			}
+		} else if notModifiedSince(r, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return http.StatusNotModified, nil
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		status, err := hf(sw, r)
+		written := sw.status == http.StatusOK || sw.status == http.StatusNotModified
+		if etag := w.Header().Get("ETag"); etag != "" && written && status < http.StatusBadRequest && err == nil {
+			etags.add(k, etag)
+		}
+		return status, err
+	}
+}
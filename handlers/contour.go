@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// contourExtent is the extent of the vector tiles with contour lines.
+const contourExtent = 4096
+
+// maxContourLevels limits the number of contour lines of a tile, as a too
+// small interval would make the rendering very expensive.
+const maxContourLevels = 1000
+
+// contourKey identifies a rendered contour tile in a convertedCache.
+type contourKey struct {
+	tc       tileCoord
+	interval float64
+	encoding demEncoding
+}
+
+// gridEdge identifies an edge between two neighboring pixels of a dem. The
+// edge starts at the pixel x, y and ends at the pixel to the right of it, or
+// below it if vertical is true.
+type gridEdge struct {
+	x, y     int
+	vertical bool
+}
+
+// contourLevels returns the multiples of interval between the lowest and the
+// highest elevation of d. It fails if there are more than maxContourLevels.
+func contourLevels(d *dem, interval float64) ([]float64, error) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range d.values {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	first, last := math.Ceil(lo/interval), math.Floor(hi/interval)
+	if n := last - first + 1; n > maxContourLevels {
+		return nil, fmt.Errorf("interval %v results in %v contour lines, at most %d are allowed", interval, n, maxContourLevels)
+	}
+	var levels []float64
+	for i := first; i <= last; i++ {
+		levels = append(levels, i*interval)
+	}
+	return levels, nil
+}
+
+// contourSegments returns the segments of the contour line at level through
+// the cells between the centers of the pixels of d with marching squares.
+// The grid extends one pixel beyond each side of the tile, so that the lines
+// reach the edges of the tile. Ambiguous cells are resolved with the average
+// of their corners.
+func contourSegments(d *dem, level float64) [][2]gridEdge {
+	var segments [][2]gridEdge
+	for y := -1; y < d.h; y++ {
+		for x := -1; x < d.w; x++ {
+			// corners clockwise from the upper left one
+			v := [4]float64{d.at(x, y), d.at(x+1, y), d.at(x+1, y+1), d.at(x, y+1)}
+			var above [4]bool
+			n := 0
+			for i := range v {
+				if above[i] = v[i] >= level; above[i] {
+					n++
+				}
+			}
+			if n == 0 || n == 4 {
+				continue
+			}
+			// edges clockwise from the upper one
+			edges := [4]gridEdge{{x, y, false}, {x + 1, y, true}, {x, y + 1, false}, {x, y, true}}
+			var crossed []gridEdge
+			for i, e := range edges {
+				if above[i] != above[(i+1)%4] {
+					crossed = append(crossed, e)
+				}
+			}
+			if len(crossed) == 2 {
+				segments = append(segments, [2]gridEdge{crossed[0], crossed[1]})
+				continue
+			}
+			// a saddle, whose corners that differ from the center are cut off
+			center := (v[0]+v[1]+v[2]+v[3])/4 >= level
+			if above[0] != center {
+				segments = append(segments, [2]gridEdge{edges[3], edges[0]}, [2]gridEdge{edges[1], edges[2]})
+			} else {
+				segments = append(segments, [2]gridEdge{edges[0], edges[1]}, [2]gridEdge{edges[2], edges[3]})
+			}
+		}
+	}
+	return segments
+}
+
+// joinSegments joins segments that share an edge into lines. Lines that end
+// where they start are closed.
+func joinSegments(segments [][2]gridEdge) [][]gridEdge {
+	byEdge := make(map[gridEdge][]int)
+	for i, s := range segments {
+		byEdge[s[0]] = append(byEdge[s[0]], i)
+		byEdge[s[1]] = append(byEdge[s[1]], i)
+	}
+	used := make([]bool, len(segments))
+	var lines [][]gridEdge
+	follow := func(start int, from gridEdge) {
+		line := []gridEdge{from}
+		for i := start; i >= 0; {
+			used[i] = true
+			s := segments[i]
+			next := s[0]
+			if next == from {
+				next = s[1]
+			}
+			line = append(line, next)
+			from, i = next, -1
+			for _, j := range byEdge[next] {
+				if !used[j] {
+					i = j
+					break
+				}
+			}
+		}
+		lines = append(lines, line)
+	}
+	// open lines start at the edges of a single segment, the remaining
+	// segments form closed lines
+	for i, s := range segments {
+		if !used[i] && len(byEdge[s[0]]) == 1 {
+			follow(i, s[0])
+		} else if !used[i] && len(byEdge[s[1]]) == 1 {
+			follow(i, s[1])
+		}
+	}
+	for i, s := range segments {
+		if !used[i] {
+			follow(i, s[0])
+		}
+	}
+	return lines
+}
+
+// renderContours returns a vector tile with a layer "contours" with a
+// LineString feature for each of the levels that the elevations of d cross,
+// whose property "elevation" is the level.
+func renderContours(d *dem, levels []float64) *mvt.Tile {
+	l := &mvt.Layer{Version: 2, Name: "contours", Extent: contourExtent}
+	sx, sy := float64(contourExtent)/float64(d.w), float64(contourExtent)/float64(d.h)
+	// position interpolates the crossing of the level along the edge e
+	position := func(e gridEdge, level float64) mvt.Coord {
+		x1, y1 := e.x+1, e.y
+		if e.vertical {
+			x1, y1 = e.x, e.y+1
+		}
+		v0, v1 := d.at(e.x, e.y), d.at(x1, y1)
+		t := (level - v0) / (v1 - v0)
+		x := float64(e.x) + t*float64(x1-e.x) + 0.5
+		y := float64(e.y) + t*float64(y1-e.y) + 0.5
+		return mvt.Coord{X: int64(math.Round(x * sx)), Y: int64(math.Round(y * sy))}
+	}
+	for _, level := range levels {
+		f := &mvt.Feature{
+			Type:       mvt.LineString,
+			Properties: map[string]interface{}{"elevation": level},
+		}
+		for _, line := range joinSegments(contourSegments(d, level)) {
+			part := make([]mvt.Coord, 0, len(line))
+			for _, e := range line {
+				c := position(e, level)
+				if n := len(part); n == 0 || part[n-1] != c {
+					part = append(part, c)
+				}
+			}
+			if len(part) > 1 {
+				f.Geometry = append(f.Geometry, part)
+			}
+		}
+		if len(f.Geometry) > 0 {
+			l.Features = append(l.Features, f)
+		}
+	}
+	return &mvt.Tile{Layers: []*mvt.Layer{l}}
+}
+
+// contours serves vector tiles with contour lines that are derived from the
+// tiles of a terrain-RGB tileset at "/services/<id>/contours/<z>/<x>/<y>.pbf".
+// The elevations of the lines are multiples of the "interval" query
+// parameter in meters, which defaults to 10. Rendered tiles are cached.
+func (s *ServiceSet) contours(id string, db Tileset) handlerFunc {
+	p := s.policy(id)
+	enc := demEncodingOf(db)
+	rendered := s.convertedCache(db, "contours")
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		pcs := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"+id+"/contours/"), "/")
+		if len(pcs) != 3 {
+			return http.StatusNotFound, fmt.Errorf("unknown contour resource %q", r.URL.Path)
+		}
+		logTile(r, pcs[0], pcs[1], pcs[2])
+		tc, ext, err := tileCoordFromString(pcs[0], pcs[1], pcs[2])
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if ext != "" && ext != ".pbf" {
+			return http.StatusBadRequest, fmt.Errorf("cannot render contour tiles of format %q", ext)
+		}
+		if s.Scheme == mbtiles.TMS {
+			tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+		}
+		interval, err := queryFloat(r, "interval", 10)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if interval <= 0 {
+			return http.StatusBadRequest, fmt.Errorf("interval %v is not positive", interval)
+		}
+		e, err := requestDEMEncoding(r, enc)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if !p.allowsZoom(int(tc.z)) {
+			return tileNotFoundHandler(w, mbtiles.PBF)
+		}
+		if status := s.interceptRead(r, id, db, tc); status != 0 {
+			return status, nil
+		}
+		k := contourKey{tc: tc, interval: interval, encoding: e}
+		data, ok := rendered.get(k)
+		if !ok {
+			d, err := readDEM(r.Context(), db, tc, e)
+			switch {
+			case err == mbtiles.ErrTileNotFound:
+				return tileNotFoundHandler(w, mbtiles.PBF)
+			case err == mbtiles.ErrQueryTimeout:
+				return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			case err != nil:
+				return http.StatusInternalServerError, fmt.Errorf("cannot read terrain tile for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			}
+			levels, err := contourLevels(d, interval)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			_, span := s.startSpan(r.Context(), "contours")
+			data, err = mvt.Encode(renderContours(d, levels))
+			span.End()
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			rendered.add(k, data)
+		}
+		w.Header().Set("Content-Type", mbtiles.PBF.ContentType())
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsEncoding(r, "gzip") {
+			if data, err = gzipBytes(data); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		return writeWithETag(w, r, data)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/golang/groupcache/lru"
+)
+
+// convertedCacheSize is the number of converted tiles that are cached per
+// tileset.
+const convertedCacheSize = 1024
+
+// formatFromExt returns the raster tile format that is requested by the
+// filename extension ext, e.g. ".webp".
+func formatFromExt(ext string) (mbtiles.TileFormat, bool) {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return mbtiles.PNG, true
+	case ".jpg", ".jpeg":
+		return mbtiles.JPG, true
+	case ".webp":
+		return mbtiles.WEBP, true
+	case ".avif":
+		return mbtiles.AVIF, true
+	}
+	return mbtiles.UNKNOWN, false
+}
+
+type convertedKey struct {
+	tc     tileCoord
+	format mbtiles.TileFormat
+}
+
+// convertedCache is a LRU cache of tiles that were converted to a different
+// format or rendered from other tiles, by a comparable key like convertedKey.
+// It is safe for concurrent use.
+type convertedCache struct {
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+func newConvertedCache() *convertedCache {
+	return &convertedCache{lru: lru.New(convertedCacheSize)}
+}
+
+func (c *convertedCache) get(k lru.Key) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.lru.Get(k)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+func (c *convertedCache) add(k lru.Key, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Add(k, data)
+}
+
+// convertTile converts the raster tile data of format from to format to.
+func (s *ServiceSet) convertTile(data []byte, from, to mbtiles.TileFormat) ([]byte, error) {
+	img, err := decodeTile(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTile(img, to, s.ImageQuality)
+}
+
+// writeConverted converts the raster tile data of format from to the format of
+// k, writes it to w and adds it to the cache c.
+func (s *ServiceSet) writeConverted(w http.ResponseWriter, r *http.Request, c *convertedCache, data []byte, from mbtiles.TileFormat, k convertedKey) (int, error) {
+	_, span := s.startSpan(r.Context(), "convert")
+	span.SetAttribute("tile.format", k.format.String())
+	data, err := s.convertTile(data, from, k.format)
+	span.End()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	c.add(k, data)
+	w.Header().Set("Content-Type", k.format.ContentType())
+	return writeWithETag(w, r, data)
+}
+
+// negotiableFormats are the raster tile formats, in order of preference, to
+// which tiles are converted if the client explicitly accepts them.
+var negotiableFormats = []mbtiles.TileFormat{mbtiles.AVIF, mbtiles.WEBP}
+
+// negotiateFormat returns the format in which a raster tile of format f is
+// sent in response to r, based on its Accept header. The returned bool reports
+// whether the format depends on the Accept header at all, i.e. whether tiles
+// of format f can be converted to any of the negotiable formats.
+func negotiateFormat(r *http.Request, f mbtiles.TileFormat) (mbtiles.TileFormat, bool) {
+	var vary bool
+	for _, nf := range negotiableFormats {
+		if nf == f {
+			// the stored format is preferred over less preferred formats
+			return f, vary
+		}
+		if !canConvert(f, nf) {
+			continue
+		}
+		vary = true
+		if acceptsType(r, nf.ContentType()) {
+			return nf, true
+		}
+	}
+	return f, vary
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// Option configures a ServiceSet that is created by NewServiceSet.
+type Option func(*ServiceSet)
+
+// WithDomain sets the Domain of the ServiceSet, which is used as host name of
+// the URLs in the responses instead of the one of the requests.
+func WithDomain(domain string) Option {
+	return func(s *ServiceSet) {
+		s.Domain = domain
+	}
+}
+
+// WithPath sets the Path of the ServiceSet, which is the prefix of the URLs
+// in the responses without the leading "/", like "maps". It must match the
+// prefix below which the ServeMux of the ServiceSet is mounted.
+func WithPath(path string) Option {
+	return func(s *ServiceSet) {
+		s.Path = path
+	}
+}
+
+// WithTileScheme sets the numbering scheme of the tile rows in the URLs below
+// "/services".
+func WithTileScheme(scheme mbtiles.TileScheme) Option {
+	return func(s *ServiceSet) {
+		s.Scheme = scheme
+	}
+}
+
+// WithResponseCache caches the responses for tiles, grids and TileJSON in c.
+func WithResponseCache(c *ResponseCache) Option {
+	return func(s *ServiceSet) {
+		s.ResponseCache = c
+	}
+}
+
+// WithAccessLog logs every answered request to l.
+func WithAccessLog(l *slog.Logger) Option {
+	return func(s *ServiceSet) {
+		s.AccessLog = l
+	}
+}
+
+// WithTracer traces the requests to the handlers with t.
+func WithTracer(t Tracer) Option {
+	return func(s *ServiceSet) {
+		s.Tracer = t
+	}
+}
+
+// WithTileInterceptor adds ti to the TileInterceptors of the ServiceSet.
+func WithTileInterceptor(ti TileInterceptor) Option {
+	return func(s *ServiceSet) {
+		s.TileInterceptors = append(s.TileInterceptors, ti)
+	}
+}
+
+// NewServiceSet returns a new ServiceSet that is configured by opts. Further
+// fields of the ServiceSet can be set before its handlers are created.
+// Tilesets are added with AddDBOnPath.
+//
+// The ServiceSet can be mounted in the http.ServeMux of an application with
+// its own middleware:
+//
+//	s := handlers.NewServiceSet(handlers.WithPath("maps"))
+//	if err := s.AddDBOnPath("world.mbtiles", "world"); err != nil { ... }
+//	mux.Handle("/maps/", http.StripPrefix("/maps", s.ServeMux(logError, true)))
+func NewServiceSet(opts ...Option) *ServiceSet {
+	s := New()
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ServeMux returns a http.ServeMux with all endpoints of the ServiceSet, as
+// they are served by mbtileserver: the services, ArcGIS, OGC API, composite,
+// static assets, health and metrics endpoints, the styles, fonts and sprites
+// endpoints if their directories are set, the admin endpoints if the AdminKey
+// is set and the endpoint of the peers of the ResponseCache. The function ef
+// and publish are passed on to Handler and the other handlers. Responses are
+// neither compressed nor subject to CORS, which is up to the middleware of
+// the application.
+func (s *ServiceSet) ServeMux(ef func(error), publish bool) *http.ServeMux {
+	m := http.NewServeMux()
+	h := s.Handler(ef, publish)
+	m.Handle("/services", h)
+	m.Handle("/services/", h)
+	a := s.ArcGISHandler(ef)
+	m.Handle("/arcgis/rest/services", a)
+	m.Handle("/arcgis/rest/services/", a)
+	o := s.OGCHandler(ef)
+	m.Handle("/ogc", o)
+	m.Handle("/ogc/", o)
+	m.Handle("/composite/", s.CompositeHandler(ef))
+	m.Handle("/static/", http.StripPrefix("/static", Static()))
+	for _, d := range []struct {
+		dir, path string
+		handler   func(func(error)) http.Handler
+	}{
+		{s.StylesDir, "/styles", s.StylesHandler},
+		{s.FontsDir, "/fonts", s.FontsHandler},
+		{s.SpritesDir, "/sprites", s.SpritesHandler},
+	} {
+		if d.dir != "" {
+			dh := d.handler(ef)
+			m.Handle(d.path, dh)
+			m.Handle(d.path+"/", dh)
+		}
+	}
+	hc := s.HealthHandler(ef)
+	m.Handle("/health", hc)
+	m.Handle("/ready", hc)
+	m.Handle("/metrics", s.MetricsHandler(ef))
+	if c := s.ResponseCache; c != nil && c.Peers != nil {
+		m.Handle(PeerCacheBasePath, c.Peers)
+	}
+	if s.AdminKey != "" {
+		m.Handle("/admin/", s.AdminHandler(ef))
+	}
+	return m
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/consbio/mbtileserver/internal/pbf"
+)
+
+// glyphRangePattern matches the names of the glyph ranges of 256 code points.
+var glyphRangePattern = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+// errInvalidGlyphs is returned for glyph PBFs that cannot be parsed.
+var errInvalidGlyphs = errors.New("invalid glyph PBF")
+
+// parseGlyphRange checks that the name of a glyph range like "0-255" is
+// aligned to 256 code points of the Basic Multilingual Plane.
+func parseGlyphRange(name string) error {
+	m := glyphRangePattern.FindStringSubmatch(name)
+	if m == nil {
+		return fmt.Errorf("invalid glyph range %q", name)
+	}
+	start, err1 := strconv.Atoi(m[1])
+	end, err2 := strconv.Atoi(m[2])
+	if err1 != nil || err2 != nil || start%256 != 0 || end != start+255 || end > 65535 {
+		return fmt.Errorf("invalid glyph range %q", name)
+	}
+	return nil
+}
+
+// listFonts returns the names of the fonts in s.FontsDir in sorted order,
+// which are the subdirectories with the glyph ranges of each font.
+func (s *ServiceSet) listFonts() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.FontsDir)
+	if err != nil {
+		return nil, err
+	}
+	fonts := []string{}
+	for _, e := range entries {
+		if e.IsDir() && validName(e.Name()) {
+			fonts = append(fonts, e.Name())
+		}
+	}
+	sort.Strings(fonts)
+	return fonts, nil
+}
+
+// bytesField reads the next field of r. It returns the value of the
+// length-delimited field with the number field and true, or skips any other
+// field.
+func bytesField(r *pbf.Reader, field int) ([]byte, bool, error) {
+	f, wire, err := r.Key()
+	if err != nil {
+		return nil, false, err
+	}
+	if f != field || wire != pbf.WireBytes {
+		return nil, false, r.Skip(wire)
+	}
+	b, err := r.Bytes()
+	return b, err == nil, err
+}
+
+// glyphID returns the code point of the encoded glyph message, if it has one.
+func glyphID(glyph []byte) (uint64, bool, error) {
+	r := pbf.NewReader(glyph)
+	for !r.Done() {
+		field, wire, err := r.Key()
+		if err != nil {
+			return 0, false, err
+		}
+		if field == 1 && wire == pbf.WireVarint {
+			id, err := r.Varint()
+			return id, err == nil, err
+		}
+		if err := r.Skip(wire); err != nil {
+			return 0, false, err
+		}
+	}
+	return 0, false, nil
+}
+
+// readGlyphs returns the encoded glyph messages of the glyph PBF data by
+// their code point.
+func readGlyphs(data []byte) (map[uint64][]byte, error) {
+	glyphs := make(map[uint64][]byte)
+	r := pbf.NewReader(data)
+	for !r.Done() {
+		stack, ok, err := bytesField(r, 1)
+		if err != nil {
+			return nil, errInvalidGlyphs
+		}
+		if !ok {
+			continue
+		}
+		sr := pbf.NewReader(stack)
+		for !sr.Done() {
+			glyph, ok, err := bytesField(sr, 3)
+			if err != nil {
+				return nil, errInvalidGlyphs
+			}
+			if !ok {
+				continue
+			}
+			id, ok, err := glyphID(glyph)
+			if err != nil {
+				return nil, errInvalidGlyphs
+			}
+			if ok {
+				glyphs[id] = glyph
+			}
+		}
+	}
+	return glyphs, nil
+}
+
+// composeGlyphs combines the glyph PBFs of the fonts of a fontstack into one,
+// with each glyph taken from the first font that contains it.
+func composeGlyphs(name, glyphRange string, fonts [][]byte) ([]byte, error) {
+	composed := make(map[uint64][]byte)
+	for _, data := range fonts {
+		glyphs, err := readGlyphs(data)
+		if err != nil {
+			return nil, err
+		}
+		for id, g := range glyphs {
+			if _, ok := composed[id]; !ok {
+				composed[id] = g
+			}
+		}
+	}
+	ids := make([]uint64, 0, len(composed))
+	for id := range composed {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	stack := &pbf.Writer{}
+	stack.Bytes(1, []byte(name))
+	stack.Bytes(2, []byte(glyphRange))
+	for _, id := range ids {
+		stack.Bytes(3, composed[id])
+	}
+	w := &pbf.Writer{}
+	w.Bytes(1, stack.Data())
+	return w.Data(), nil
+}
+
+// FontsHandler returns a http.Handler that serves the glyphs of the fonts in
+// the FontsDir of the ServiceSet, which contains a directory per font with
+// the SDF glyph PBFs of each range of 256 code points as "<start>-<end>.pbf".
+// "/fonts" lists the fonts and "/fonts/<fontstack>/<start>-<end>.pbf" serves
+// the glyphs of a comma separated list of fonts, where each glyph is taken
+// from the first font that contains it. Fonts that do not exist are skipped.
+// The function ef is called with any occuring error if it is non-nil, so it
+// can be used for e.g. logging with logging facitilies of the caller.
+func (s *ServiceSet) FontsHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/fonts"), "/")
+		if p == "" {
+			fonts, err := s.listFonts()
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("cannot list fonts: %v", err)
+			}
+			return writeJSON(w, fonts)
+		}
+		pcs := strings.Split(p, "/")
+		if len(pcs) != 2 || !strings.HasSuffix(pcs[1], ".pbf") {
+			return http.StatusNotFound, fmt.Errorf("unknown font resource %q", r.URL.Path)
+		}
+		glyphRange := strings.TrimSuffix(pcs[1], ".pbf")
+		if err := parseGlyphRange(glyphRange); err != nil {
+			return http.StatusBadRequest, err
+		}
+		var names []string
+		var fonts [][]byte
+		for _, name := range strings.Split(pcs[0], ",") {
+			name = strings.TrimSpace(name)
+			if !validName(name) {
+				return http.StatusBadRequest, fmt.Errorf("invalid font name %q", name)
+			}
+			dir := filepath.Join(s.FontsDir, name)
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			names = append(names, name)
+			data, err := ioutil.ReadFile(filepath.Join(dir, glyphRange+".pbf"))
+			switch {
+			case os.IsNotExist(err):
+				// the font has no glyphs in this range
+				continue
+			case err != nil:
+				return http.StatusInternalServerError, err
+			}
+			fonts = append(fonts, data)
+		}
+		if len(names) == 0 {
+			return notFoundJSON(w, "Font does not exist")
+		}
+		if len(names) == 1 && len(fonts) == 1 {
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			return writeWithETag(w, r, fonts[0])
+		}
+		data, err := composeGlyphs(strings.Join(names, ","), glyphRange, fonts)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot compose glyphs of %s: %v", pcs[0], err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		return writeWithETag(w, r, data)
+	})))
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// geoJSONPrecision is the number of decimal places of WGS84 coordinates in
+// GeoJSON, which is about 1 cm at the equator.
+const geoJSONPrecision = 1e7
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         *uint64                `json:"id,omitempty"`
+	Layer      string                 `json:"layer"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// tileProjection converts a position within a vector tile with the given
+// extent to GeoJSON coordinates.
+type tileProjection func(c mvt.Coord, extent uint32) []float64
+
+// tileUnits is the tileProjection to the tile units of the vector tile.
+func tileUnits(c mvt.Coord, extent uint32) []float64 {
+	return []float64{float64(c.X), float64(c.Y)}
+}
+
+// tileToWGS84 returns the tileProjection to longitude and latitude for the
+// tile at tc, whose row is counted from the north.
+func tileToWGS84(tc tileCoord) tileProjection {
+	n := math.Exp2(float64(tc.z))
+	round := func(v float64) float64 { return math.Round(v*geoJSONPrecision) / geoJSONPrecision }
+	return func(c mvt.Coord, extent uint32) []float64 {
+		x := (float64(tc.x) + float64(c.X)/float64(extent)) / n
+		y := (float64(tc.y) + float64(c.Y)/float64(extent)) / n
+		lat := math.Atan(math.Sinh(math.Pi*(1-2*y))) * 180 / math.Pi
+		return []float64{round(x*360 - 180), round(lat)}
+	}
+}
+
+// toGeoJSON converts the features of all layers of t to a GeoJSON feature
+// collection, with the name of the layer of each feature as foreign member
+// "layer". Features of unknown geometry type are skipped.
+func toGeoJSON(t *mvt.Tile, project tileProjection) geoJSONFeatureCollection {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, l := range t.Layers {
+		for _, f := range l.Features {
+			g := toGeoJSONGeometry(f, l.Extent, project)
+			if g == nil {
+				continue
+			}
+			gf := geoJSONFeature{Type: "Feature", Layer: l.Name, Geometry: g, Properties: f.Properties}
+			if f.HasID {
+				id := f.ID
+				gf.ID = &id
+			}
+			if gf.Properties == nil {
+				gf.Properties = map[string]interface{}{}
+			}
+			fc.Features = append(fc.Features, gf)
+		}
+	}
+	return fc
+}
+
+// toGeoJSONGeometry converts the geometry of f to GeoJSON. Polygons are split
+// into their exterior rings, which have a positive area in tile units, each
+// followed by its interior rings. It returns nil for geometries of unknown
+// type or without coordinates.
+func toGeoJSONGeometry(f *mvt.Feature, extent uint32, project tileProjection) *geoJSONGeometry {
+	line := func(part []mvt.Coord) [][]float64 {
+		out := make([][]float64, len(part))
+		for i, c := range part {
+			out[i] = project(c, extent)
+		}
+		return out
+	}
+	if len(f.Geometry) == 0 {
+		return nil
+	}
+	switch f.Type {
+	case mvt.Point:
+		points := line(f.Geometry[0])
+		if len(points) == 1 {
+			return &geoJSONGeometry{"Point", points[0]}
+		}
+		return &geoJSONGeometry{"MultiPoint", points}
+	case mvt.LineString:
+		lines := make([][][]float64, len(f.Geometry))
+		for i, part := range f.Geometry {
+			lines[i] = line(part)
+		}
+		if len(lines) == 1 {
+			return &geoJSONGeometry{"LineString", lines[0]}
+		}
+		return &geoJSONGeometry{"MultiLineString", lines}
+	case mvt.Polygon:
+		var polygons [][][][]float64
+		for _, ring := range f.Geometry {
+			if len(ring) < 3 {
+				continue
+			}
+			// GeoJSON rings are closed
+			closed := line(append(ring[:len(ring):len(ring)], ring[0]))
+			if ringArea(ring) > 0 || len(polygons) == 0 {
+				polygons = append(polygons, [][][]float64{closed})
+				continue
+			}
+			last := len(polygons) - 1
+			polygons[last] = append(polygons[last], closed)
+		}
+		switch len(polygons) {
+		case 0:
+			return nil
+		case 1:
+			return &geoJSONGeometry{"Polygon", polygons[0]}
+		}
+		return &geoJSONGeometry{"MultiPolygon", polygons}
+	}
+	return nil
+}
+
+// ringArea returns twice the signed area of the ring, which is positive for
+// exterior rings of vector tiles, as they are clockwise with the y axis
+// pointing down.
+func ringArea(ring []mvt.Coord) int64 {
+	var a int64
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		a += p.X*q.Y - q.X*p.Y
+	}
+	return a
+}
+
+// writeGeoJSON writes the vector tile data at tc, which is compressed with
+// enc, as GeoJSON after applying the filter f, if it is not nil. The
+// coordinates are longitude and latitude, or tile units if the "coords" query
+// parameter is "tile".
+func (s *ServiceSet) writeGeoJSON(w http.ResponseWriter, r *http.Request, data []byte, enc mbtiles.TileEncoding, tc tileCoord, f *vectorFilter) (int, error) {
+	var project tileProjection
+	switch c := r.URL.Query().Get("coords"); c {
+	case "", "wgs84":
+		project = tileToWGS84(tc)
+	case "tile":
+		project = tileUnits
+	default:
+		return http.StatusBadRequest, fmt.Errorf("unknown coordinates %q, expected wgs84 or tile", c)
+	}
+	raw, err := enc.Decode(data)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tile, err := mvt.Decode(raw)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot decode vector tile: %v", err)
+	}
+	if f != nil {
+		f.apply(tile)
+	}
+	if data, err = json.Marshal(toGeoJSON(tile, project)); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal GeoJSON: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	return writeWithETag(w, r, data)
+}
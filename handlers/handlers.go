@@ -4,15 +4,23 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/consbio/mbtileserver/mbtiles"
 )
@@ -57,9 +65,21 @@ func Scheme(r *http.Request) string {
 
 type handlerFunc func(http.ResponseWriter, *http.Request) (int, error)
 
+// wrapGetWithErrors returns a http.Handler for GET and HEAD requests. The
+// response body of HEAD requests is discarded by the http.Server.
 func wrapGetWithErrors(ef func(error), hf handlerFunc) http.Handler {
+	return wrapWithErrors(ef, hf, "GET", "HEAD")
+}
+
+// wrapWithErrors returns a http.Handler for requests with one of the methods.
+func wrapWithErrors(ef func(error), hf handlerFunc, methods ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
+		allowed := false
+		for _, m := range methods {
+			allowed = allowed || r.Method == m
+		}
+		if !allowed {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
 			status := http.StatusMethodNotAllowed
 			http.Error(w, http.StatusText(status), status)
 			return
@@ -79,22 +99,155 @@ func wrapGetWithErrors(ef func(error), hf handlerFunc) http.Handler {
 type ServiceInfo struct {
 	ImageType string `json:"imageType"`
 	URL       string `json:"url"`
+	// Name is the name of the tileset from its TilesetPolicy, if any.
+	Name string `json:"name,omitempty"`
+	// Metadata summarizes the metadata of the tileset if it has been
+	// requested.
+	Metadata *ServiceMetadata `json:"metadata,omitempty"`
 }
 
 // ServiceSet is the base type for the HTTP handlers which combines multiple
-// mbtiles.DB tilesets.
+// Tilesets.
 type ServiceSet struct {
-	tilesets  map[string]*mbtiles.DB
+	// tilesets is replaced rather than modified when tilesets are added or
+	// removed, so that it can be iterated without holding mu.
+	tilesets map[string]Tileset
+	// policies is replaced by SetPolicies and guarded by mu.
+	policies  map[string]*TilesetPolicy
+	mu        sync.RWMutex
+	gen       uint64
+	inflight  map[uint64]int
+	retired   []retiredDB
 	templates *template.Template
+	metrics   *metrics
+	caches    handlerCaches
 	Domain    string
 	Path      string
+	// ImageQuality is the quality from 1 to 100 of lossy raster tiles that
+	// are encoded by the server. DefaultImageQuality is used if it is zero.
+	ImageQuality int
+	// Overzoom is the number of zoom levels beyond the maximum zoom level of
+	// PNG, JPG and PBF tilesets, for which tiles are created by scaling up the
+	// corresponding part of their ancestor tile. Zero disables overzooming.
+	Overzoom int
+	// TileSize is the width in pixels of the raster tiles that are served,
+	// like 512 for GL clients. Tiles of other sizes are assembled from the
+	// tiles at the next or cropped from the tile at the previous zoom level.
+	// Zero serves the tiles in their stored size.
+	TileSize int
+	// PlainGrids serves UTF grids decompressed, with gzip as transport
+	// compression for clients that accept it, instead of in their stored
+	// compression. Grids are always served decompressed to clients that do
+	// not accept their stored compression.
+	PlainGrids bool
+	// Tracer, if not nil, traces the requests to the handlers, with child
+	// spans for reading, converting and compressing tiles and grids.
+	Tracer Tracer
+	// Scheme is the numbering scheme of the tile rows in the URLs below
+	// "/services", which defaults to mbtiles.XYZ. The WMTS, WMS, ArcGIS and
+	// OGC API endpoints always use their own row order.
+	Scheme mbtiles.TileScheme
+	// AccessLog, if not nil, logs every request to the handlers after it has
+	// been answered, see logged for its attributes. Its slog.Handler
+	// determines the format and the destination of the entries.
+	AccessLog *slog.Logger
+	// APIKeys, if not empty, restricts the access to the tilesets to requests
+	// that carry one of the keys in the "key" query parameter or as bearer
+	// token in the Authorization header. Service listings only contain the
+	// tilesets that the key of the request grants access to.
+	APIKeys []APIKey
+	// JWT, if not nil, also grants access to the tilesets to requests that
+	// carry a valid JSON Web Token in place of an API key.
+	JWT *JWTAuth
+	// URLSigningKey, if not empty, grants access to requests for URLs that
+	// have been signed with it by SignURL, until they expire.
+	URLSigningKey []byte
+	// ACLs further restrict the access to the tilesets they apply to.
+	ACLs []ACL
+	// AdminKey, if not empty, enables the admin endpoints for requests that
+	// carry it like an API key.
+	AdminKey string
+	// UploadDir is the directory in which tilesets uploaded to the admin
+	// endpoints are stored.
+	UploadDir string
+	// MaxUploadSize is the maximum size in bytes of uploaded tilesets. Zero
+	// does not limit the size.
+	MaxUploadSize int64
+	// UploadOptions are the options with which uploaded tilesets are opened.
+	UploadOptions []mbtiles.Option
+	// Uploaded, if not nil, is called with the ID and the filename of every
+	// tileset that has been uploaded and added to the ServiceSet.
+	Uploaded func(id, filename string)
+	// UploadLock, if not nil, is held while an uploaded tileset is linked to
+	// its filename, added to the ServiceSet and passed to Uploaded, e.g. to
+	// keep a watcher of the UploadDir from opening it as well.
+	UploadLock sync.Locker
+	// TrustedProxies are the networks of the reverse proxies in front of the
+	// server. If it is not empty, the Forwarded, X-Forwarded-Proto,
+	// X-Forwarded-Host and X-Forwarded-Prefix headers of their requests are
+	// used for the URLs in responses, and the X-Forwarded-For header for the
+	// client IP address in ACLs, rate limits and the access log, while these
+	// headers are ignored for other requests. Domain and Path take precedence
+	// over the forwarded host and prefix. Networks of all addresses trust
+	// every request, whose client IP address is then the last one in the
+	// X-Forwarded-For header; they must only be used if all requests pass
+	// through proxies that append to this header.
+	TrustedProxies []*net.IPNet
+	// BaseURL, if not empty, is the absolute URL of the root of the server as
+	// seen by clients without a trailing "/", like
+	// "https://example.com/tiles", which is used for the URLs in responses
+	// instead of the one that is determined from the requests.
+	BaseURL string
+	// RateLimiter, if not nil, limits the rate of requests to the tilesets
+	// per API key or client IP address. Requests beyond the limit are
+	// answered with 429 Too Many Requests.
+	RateLimiter *RateLimiter
+	// StylesDir is the directory of the Mapbox GL styles that are served by
+	// StylesHandler.
+	StylesDir string
+	// FontsDir is the directory of the fonts whose glyphs are served by
+	// FontsHandler.
+	FontsDir string
+	// SpritesDir is the directory of the sprites that are served by
+	// SpritesHandler.
+	SpritesDir string
+	// TileCache, if not nil, is the tile cache that is shared by the tilesets,
+	// whose total statistics are served by CacheHandler.
+	TileCache *mbtiles.TileCache
+	// ResponseCache, if not nil, caches the responses to requests for tiles,
+	// grids and TileJSON, in memory and optionally in a SharedCache, and
+	// coalesces concurrent requests for the same response.
+	ResponseCache *ResponseCache
+	// Purgers purge the responses for tilesets from CDNs by their surrogate
+	// keys, on requests to the admin endpoints and when tilesets are
+	// replaced.
+	Purgers []Purger
+	// PurgeError, if not nil, is called with the errors of the Purgers for
+	// replaced tilesets, which are purged in the background.
+	PurgeError func(error)
+	// MissingTile, if not nil, replaces the default response to requests for
+	// tiles that do not exist, which is a transparent PNG for raster tilesets,
+	// 204 No Content for vector tilesets and 404 Not Found for others.
+	MissingTile *MissingTile
+	// MissingTiles overrides MissingTile for the tilesets with the IDs of its
+	// keys.
+	MissingTiles map[string]*MissingTile
+	// TileInterceptors intercept the requests for tiles and their responses,
+	// in their order.
+	TileInterceptors []TileInterceptor
 }
 
 // New returns a new ServiceSet. Use AddDBOnPath to add a mbtiles file.
 func New() *ServiceSet {
+	// html/template does not allow to parse further templates once one of
+	// them has been executed, so all are parsed up front. Templates that
+	// fail to parse here are reported on their first use.
+	templates, _ := TemplatesFromAssets()
 	s := &ServiceSet{
-		tilesets:  make(map[string]*mbtiles.DB),
-		templates: template.New("_base_"),
+		tilesets:  make(map[string]Tileset),
+		inflight:  make(map[uint64]int),
+		templates: templates,
+		metrics:   newMetrics(),
 	}
 	return s
 
@@ -103,30 +256,101 @@ func New() *ServiceSet {
 // AddDBOnPath interprets filename as mbtiles file which is opened and which will be
 // served under "/services/<urlPath>" by Handler(). The parameter urlPath may not be
 // nil, otherwise an error is returned. In case the DB cannot be opened the returned
-// error is non-nil.
-func (s *ServiceSet) AddDBOnPath(filename string, urlPath string) error {
+// error is non-nil. The options opts are passed on to mbtiles.NewDB, except
+// for mbtiles.Scheme, as the handlers address the tiles in the XYZ scheme.
+// A tileset that is already served under urlPath is replaced, e.g. to reload
+// a modified file, and closed once all requests that may use it have been
+// answered.
+func (s *ServiceSet) AddDBOnPath(filename string, urlPath string, opts ...mbtiles.Option) error {
 	var err error
 	if urlPath == "" {
 		return fmt.Errorf("path parameter may not be empty")
 	}
-	ts, err := mbtiles.NewDB(filename)
+	opts = append(opts[:len(opts):len(opts)], mbtiles.Scheme(mbtiles.XYZ))
+	ts, err := mbtiles.NewDB(filename, opts...)
 	if err != nil {
 		return fmt.Errorf("could not open mbtiles file %q: %v", filename, err)
 	}
-	s.tilesets[urlPath] = ts
+	s.addTileset(ts, urlPath)
+	return nil
+}
+
+// AddStoreOnPath serves the tiles of the TileStore store, which is not backed
+// by a file, like a database server, under "/services/<urlPath>" like those
+// of an mbtiles file. The options opts are passed on to mbtiles.NewStoreDB,
+// except for mbtiles.Scheme. A tileset that is already served under urlPath
+// is replaced.
+func (s *ServiceSet) AddStoreOnPath(store mbtiles.TileStore, urlPath string, opts ...mbtiles.Option) error {
+	if urlPath == "" {
+		return fmt.Errorf("path parameter may not be empty")
+	}
+	opts = append(opts[:len(opts):len(opts)], mbtiles.Scheme(mbtiles.XYZ))
+	ts, err := mbtiles.NewStoreDB(urlPath, store, opts...)
+	if err != nil {
+		return fmt.Errorf("could not open tile store %q: %v", urlPath, err)
+	}
+	s.addTileset(ts, urlPath)
+	return nil
+}
+
+// AddTilesetOnPath serves the Tileset ts of any backend under
+// "/services/<urlPath>" like an mbtiles file. A tileset that is already
+// served under urlPath is replaced and closed once all requests that may use
+// it have been answered.
+func (s *ServiceSet) AddTilesetOnPath(ts Tileset, urlPath string) error {
+	if urlPath == "" {
+		return fmt.Errorf("path parameter may not be empty")
+	}
+	s.addTileset(ts, urlPath)
+	return nil
+}
+
+// addTileset serves ts under "/services/<urlPath>" and purges the responses
+// for a replaced tileset from the CDNs.
+func (s *ServiceSet) addTileset(ts Tileset, urlPath string) {
+	replaced := false
+	s.update(func(tilesets map[string]Tileset) {
+		_, replaced = tilesets[urlPath]
+		tilesets[urlPath] = ts
+	})
+	if replaced && len(s.Purgers) > 0 {
+		go func() {
+			if err := s.purgeCDNs(context.Background(), []string{surrogateKey(urlPath, -1)}); err != nil && s.PurgeError != nil {
+				s.PurgeError(err)
+			}
+		}()
+	}
+}
+
+// RemoveDB stops serving the tileset at "/services/<urlPath>". It is an
+// error if there is no such tileset. The tileset is closed once all requests
+// that may use it have been answered.
+func (s *ServiceSet) RemoveDB(urlPath string) error {
+	removed := false
+	s.update(func(tilesets map[string]Tileset) {
+		_, removed = tilesets[urlPath]
+		delete(tilesets, urlPath)
+	})
+	if !removed {
+		return fmt.Errorf("no tileset at path %q", urlPath)
+	}
 	return nil
 }
 
-// NewFromBaseDir returns a ServiceSet that combines all .mbtiles files under
+// NewFromBaseDir returns a ServiceSet that combines all tilesets under
 // the directory at baseDir. The DBs will all be served under their relative paths
-// to baseDir.
-func NewFromBaseDir(baseDir string) (*ServiceSet, error) {
+// to baseDir. The options opts are passed on to mbtiles.NewDB.
+func NewFromBaseDir(baseDir string, opts ...mbtiles.Option) (*ServiceSet, error) {
 	var filenames []string
 	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if ext := filepath.Ext(p); ext == ".mbtiles" {
+		if info.IsDir() && p != baseDir && mbtiles.IsTileDir(p) {
+			filenames = append(filenames, p)
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && mbtiles.IsTileset(p) {
 			filenames = append(filenames, p)
 		}
 		return nil
@@ -142,14 +366,11 @@ func NewFromBaseDir(baseDir string) (*ServiceSet, error) {
 	s := New()
 
 	for _, filename := range filenames {
-		subpath, err := filepath.Rel(baseDir, filename)
+		id, err := IDFromPath(baseDir, filename)
 		if err != nil {
 			return nil, fmt.Errorf("unable to extract URL path for %q: %v", filename, err)
 		}
-		e := filepath.Ext(filename)
-		p := filepath.ToSlash(subpath)
-		id := strings.ToLower(p[:len(p)-len(e)])
-		err = s.AddDBOnPath(filename, id)
+		err = s.AddDBOnPath(filename, id, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -159,25 +380,115 @@ func NewFromBaseDir(baseDir string) (*ServiceSet, error) {
 
 // Len returns the number of tilesets in this ServiceSet
 func (s *ServiceSet) Size() int {
-	return len(s.tilesets)
+	return len(s.dbs())
+}
+
+// Close closes all tilesets of the ServiceSet, including the ones that are no
+// longer served but still in use. It returns the first error that occurred,
+// if any.
+func (s *ServiceSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, db := range s.tilesets {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, r := range s.retired {
+		if cerr := r.db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.retired = nil
+	return err
 }
 
 // RootURL returns the root URL of the service. If s.Domain is non-empty, it
 // will be used as the hostname. If s.Path is non-empty, it will be used as a
-// prefix.
+// prefix. BaseURL replaces the root URL, and the scheme, host and prefix of
+// requests from TrustedProxies are taken from the headers they forward.
 func (s *ServiceSet) RootURL(r *http.Request) string {
-	return RootURL(r, s.Domain, s.Path)
+	if root, ok := r.Context().Value(rootURLKey{}).(string); ok {
+		return root
+	}
+	if s.BaseURL != "" {
+		return s.BaseURL
+	}
+	if len(s.TrustedProxies) == 0 {
+		return RootURL(r, s.Domain, s.Path)
+	}
+	scheme, host, path := "http", r.Host, s.Path
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s.fromTrustedProxy(r) {
+		proto, fhost, prefix := forwarded(r)
+		if proto != "" {
+			scheme = proto
+		}
+		if fhost != "" {
+			host = fhost
+		}
+		if path == "" {
+			path = strings.Trim(prefix, "/")
+		}
+	}
+	if len(s.Domain) > 0 {
+		host = s.Domain
+	}
+	root := fmt.Sprintf("%s://%s", scheme, host)
+	if len(path) > 0 {
+		root = fmt.Sprintf("%s/%s", root, path)
+	}
+	return root
 }
 
+// listServices lists the services of the tilesets, sorted by their IDs and
+// filtered and paged by the query parameters of parseServiceFilter.
 func (s *ServiceSet) listServices(w http.ResponseWriter, r *http.Request) (int, error) {
-	rootURL := fmt.Sprintf("%s%s", s.RootURL(r), r.URL)
+	query := r.URL.Query()
+	f, err := parseServiceFilter(query)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	listURL := s.RootURL(r) + r.URL.Path
+	dbs := s.dbs()
+	ids := make([]string, 0, len(dbs))
+	for id := range dbs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
 	services := []ServiceInfo{}
-	for id, tileset := range s.tilesets {
-		services = append(services, ServiceInfo{
-			ImageType: tileset.TileFormatString(),
-			URL:       fmt.Sprintf("%s/%s", rootURL, id),
-		})
+	for _, id := range ids {
+		if status, _ := s.authorize(r, id); status != http.StatusOK {
+			continue
+		}
+		tileset := dbs[id]
+		p := s.policy(id)
+		var metadata map[string]interface{}
+		if f.needsMetadata() {
+			if metadata, err = tileset.ReadMetadata(); err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+			}
+			p.apply(metadata)
+		}
+		info := ServiceInfo{
+			ImageType: tileset.TileFormat().String(),
+			URL:       fmt.Sprintf("%s/%s", listURL, id),
+		}
+		if p != nil {
+			info.Name = p.Name
+		}
+		if !f.match(id, info.Name, tileset, metadata) {
+			continue
+		}
+		if f.metadata {
+			info.Metadata = serviceMetadata(tileset, metadata)
+		}
+		services = append(services, info)
 	}
+	services = f.page(w, listURL, query, services)
 	bytes, err := json.Marshal(services)
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("cannot marshal services JSON: %v", err)
@@ -187,45 +498,28 @@ func (s *ServiceSet) listServices(w http.ResponseWriter, r *http.Request) (int,
 	return http.StatusOK, err
 }
 
-func (s *ServiceSet) tileJSON(id string, db *mbtiles.DB, mapURL bool) handlerFunc {
+func (s *ServiceSet) tileJSON(id string, db Tileset, mapURL bool) handlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) (int, error) {
 		svcURL := fmt.Sprintf("%s%s", s.RootURL(r), r.URL.Path)
-		imgFormat := db.TileFormatString()
-		out := map[string]interface{}{
-			"tilejson": "2.1.0",
-			"id":       id,
-			"scheme":   "xyz",
-			"format":   imgFormat,
-			"tiles":    []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.%s", svcURL, imgFormat)},
-		}
-		if mapURL {
-			out["map"] = fmt.Sprintf("%s/map", svcURL)
-		}
 		metadata, err := db.ReadMetadata()
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
-		for k, v := range metadata {
-			switch k {
-			// strip out values above
-			case "tilejson", "id", "scheme", "format", "tiles", "map":
-				continue
-
-			// strip out values that are not supported or are overridden below
-			case "grids", "interactivity", "modTime":
-				continue
-
-			// strip out values that come from TileMill but aren't useful here
-			case "metatile", "scale", "autoscale", "_updated", "Layer", "Stylesheet":
-				continue
-
-			default:
-				out[k] = v
+		out := mbtiles.NewTileJSON(metadata, db.TileFormat(), s.Scheme, db.HasUTFGrid(), svcURL)
+		s.policy(id).apply(out)
+		out["id"] = id
+		if canProcessRaster(db.TileFormat()) {
+			out["scales"] = tileScales
+		}
+		for _, k := range []string{"tiles", "grids"} {
+			if urls, ok := out[k].([]string); ok {
+				for i, u := range urls {
+					urls[i] = withAPIKey(r, u)
+				}
 			}
 		}
-
-		if db.HasUTFGrid() {
-			out["grids"] = []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.json", svcURL)}
+		if mapURL {
+			out["map"] = withAPIKey(r, fmt.Sprintf("%s/map", svcURL))
 		}
 		bytes, err := json.Marshal(out)
 		if err != nil {
@@ -237,6 +531,22 @@ func (s *ServiceSet) tileJSON(id string, db *mbtiles.DB, mapURL bool) handlerFun
 	}
 }
 
+func (s *ServiceSet) stats(db StatsTileset) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		stats, err := db.StatsContext(r.Context())
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		bytes, err := json.Marshal(stats)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot marshal stats JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(bytes)
+		return http.StatusOK, err
+	}
+}
+
 // executeTemplates first tries to find the template with the given name for
 // the ServiceSet. If that fails, it tries to instantiate it from the assets.
 // If a valid template is obtained it is used to render a response, otherwise
@@ -260,14 +570,49 @@ func (s *ServiceSet) executeTemplate(w http.ResponseWriter, name string, data in
 	return http.StatusOK, err
 }
 
-func (s *ServiceSet) serviceHTML(id string, db *mbtiles.DB) handlerFunc {
+// zoomsAndBounds returns the zoom range and the bounds of a tileset from
+// its metadata, using the whole world as default for missing values.
+func zoomsAndBounds(metadata map[string]interface{}) (minZoom, maxZoom int, bounds []float64) {
+	minZoom, ok := metadata["minzoom"].(int)
+	if !ok {
+		minZoom = 0
+	}
+	maxZoom, ok = metadata["maxzoom"].(int)
+	if !ok || maxZoom < minZoom {
+		maxZoom = minZoom
+	}
+	bounds, ok = metadata["bounds"].([]float64)
+	if !ok || len(bounds) != 4 {
+		bounds = []float64{-180, -85.05112878, 180, 85.05112878}
+	}
+	return minZoom, maxZoom, bounds
+}
+
+// serviceHTML serves a preview map of the tileset, with its bounds and zoom
+// range filled in from the metadata.
+func (s *ServiceSet) serviceHTML(id string, db Tileset) handlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+		}
+		s.policy(id).apply(metadata)
+		minZoom, maxZoom, bounds := zoomsAndBounds(metadata)
+		root := s.RootURL(r)
 		p := struct {
-			URL string
-			ID  string
+			URL     string
+			Root    string
+			ID      string
+			MinZoom int
+			MaxZoom int
+			Bounds  []float64
 		}{
-			fmt.Sprintf("%s%s", s.RootURL(r), strings.TrimSuffix(r.URL.Path, "/map")),
+			withAPIKey(r, fmt.Sprintf("%s%s", root, strings.TrimSuffix(r.URL.Path, "/map"))),
+			root,
 			id,
+			minZoom,
+			maxZoom,
+			bounds,
 		}
 
 		switch db.TileFormat() {
@@ -328,11 +673,20 @@ func tileCoordFromString(z, x, y string) (tc tileCoord, ext string, err error) {
 	return
 }
 
+// notFoundJSON writes a 404 response with a JSON message, consistent with
+// mapbox.
+func notFoundJSON(w http.ResponseWriter, msg string) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	_, err := fmt.Fprintf(w, `{"message": %q}`, msg)
+	return http.StatusOK, err // http.StatusOK doesn't matter, code was written by w.WriteHeader already
+}
+
 // tileNotFoundHandler writes the default response for a non-existing tile of type f to w
 func tileNotFoundHandler(w http.ResponseWriter, f mbtiles.TileFormat) (int, error) {
 	var err error
 	switch f {
-	case mbtiles.PNG, mbtiles.JPG, mbtiles.WEBP:
+	case mbtiles.PNG, mbtiles.JPG, mbtiles.WEBP, mbtiles.AVIF:
 		// Return blank PNG for all image types
 		w.Header().Set("Content-Type", "image/png")
 		w.WriteHeader(http.StatusOK)
@@ -341,14 +695,31 @@ func tileNotFoundHandler(w http.ResponseWriter, f mbtiles.TileFormat) (int, erro
 		// Return 204
 		w.WriteHeader(http.StatusNoContent)
 	default:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, `{"message": "Tile does not exist"}`)
+		return notFoundJSON(w, "Tile does not exist")
 	}
 	return http.StatusOK, err // http.StatusOK doesn't matter, code was written by w.WriteHeader already
 }
 
-func (s *ServiceSet) tiles(db *mbtiles.DB) handlerFunc {
+// tiles returns a handlerFunc that serves the tiles and grids of the tileset
+// id in db.
+func (s *ServiceSet) tiles(id string, db Tileset) handlerFunc {
+	missing := s.missingTile(id)
+	p := s.policy(id)
+	maxZoom := -1 // overzooming is disabled for negative values
+	if s.Overzoom > 0 && canOverzoom(db.TileFormat()) {
+		if metadata, err := db.ReadMetadata(); err == nil {
+			if z, ok := metadata["maxzoom"].(int); ok && p.allowsZoom(z) {
+				maxZoom = z
+			}
+		}
+	}
+	converted := s.convertedCache(db, "converted")
+	var sc *scaler
+	if canProcessRaster(db.TileFormat()) {
+		sc = newScaler(db)
+		// tiles of other sizes must not be made of tiles the policy denies
+		sc.minZoom, sc.maxZoom = p.zooms(sc.minZoom, sc.maxZoom)
+	}
 	return func(w http.ResponseWriter, r *http.Request) (int, error) {
 		// split path components to extract tile coordinates x, y and z
 		pcs := strings.Split(r.URL.Path[1:], "/")
@@ -357,71 +728,480 @@ func (s *ServiceSet) tiles(db *mbtiles.DB) handlerFunc {
 		if l < 6 || pcs[5] == "" {
 			return http.StatusBadRequest, fmt.Errorf("requested path is too short")
 		}
+		if pcs[l-1] == "info" && l >= 7 {
+			logTile(r, pcs[l-4], pcs[l-3], pcs[l-2])
+			return s.tileInfo(w, r, id, db, pcs[l-4], pcs[l-3], pcs[l-2])
+		}
 		z, x, y := pcs[l-3], pcs[l-2], pcs[l-1]
+		logTile(r, z, x, y)
+		y, scale, err := splitScale(y)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
 		tc, ext, err := tileCoordFromString(z, x, y)
 		if err != nil {
 			return http.StatusBadRequest, err
 		}
 		var data []byte
-		// flip y to match the spec
-		tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+		if s.Scheme == mbtiles.TMS {
+			tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+		}
+		if scale > 1 && (sc == nil || ext == ".json") {
+			return http.StatusBadRequest, fmt.Errorf("cannot scale %s tiles", db.TileFormat().String())
+		}
+		// raster tiles in other sizes than the stored ones are created
+		// from the stored tiles
+		if sc != nil && ext != ".json" && (scale > 1 || s.TileSize > 0) {
+			if size := s.tileSize(scale); size != sc.nativeSize() {
+				return s.writeScaled(w, r, sc, tc, size, missing)
+			}
+		}
 		isGrid := ext == ".json"
+		isGeoJSON := ext == ".geojson"
+		if isGeoJSON && db.TileFormat() != mbtiles.PBF {
+			return http.StatusBadRequest, fmt.Errorf("cannot decode %s tiles to GeoJSON", db.TileFormat().String())
+		}
+		// vector tiles are decoded to strip the parts the client did not ask for
+		var vf *vectorFilter
+		if !isGrid && db.TileFormat() == mbtiles.PBF {
+			if vf, err = parseVectorFilter(r.URL.Query()); err != nil {
+				return http.StatusBadRequest, err
+			}
+		}
+		// serve raster tiles in the format requested by the extension, if
+		// they can be converted to it
+		format, ok := formatFromExt(ext)
+		convert := ok && format != db.TileFormat() && canConvert(db.TileFormat(), format)
+		if !convert && !isGrid && (!ok || format == db.TileFormat()) {
+			// otherwise, in the format preferred by the client
+			var vary bool
+			format, vary = negotiateFormat(r, db.TileFormat())
+			if vary {
+				w.Header().Add("Vary", "Accept")
+			}
+			convert = format != db.TileFormat()
+		}
+		if convert {
+			if data, ok := converted.get(convertedKey{tc, format}); ok {
+				w.Header().Set("Content-Type", format.ContentType())
+				return writeWithETag(w, r, data)
+			}
+		}
+		if !isGrid && maxZoom >= 0 && int(tc.z) > maxZoom && int(tc.z)-maxZoom <= s.Overzoom {
+			ctx, span := s.startSpan(r.Context(), "overzoom")
+			data, enc, err := s.overzoomTile(ctx, db, tc, uint8(maxZoom))
+			span.End()
+			switch {
+			case err == mbtiles.ErrTileNotFound:
+				return missing.write(w, r, db.TileFormat())
+			case err != nil:
+				return http.StatusInternalServerError, fmt.Errorf("cannot overzoom tile for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			}
+			if convert {
+				return s.writeConverted(w, r, converted, data, db.TileFormat(), convertedKey{tc, format})
+			}
+			if isGeoJSON {
+				return s.writeGeoJSON(w, r, data, enc, tc, vf)
+			}
+			if vf != nil {
+				return s.writeFilteredVector(w, r, db, data, enc, vf)
+			}
+			setTileHeaders(w, db, enc)
+			return writeWithETag(w, r, data)
+		}
+		if r.Method == "HEAD" && !isGrid && !convert {
+			return s.tileHead(w, r, db, tc, missing)
+		}
+		// the tile IDs of deduplicated files let unchanged tiles be
+		// revalidated without reading their data
+		var etag string
+		start := time.Now()
+		if ids, ok := tileIDs(db); ok && !isGrid && !convert && !isGeoJSON && vf == nil {
+			id, err := ids.ReadTileIDContext(r.Context(), tc.z, tc.x, tc.y)
+			if err != nil {
+				s.metrics.observeQuery(db, time.Since(start))
+			}
+			switch {
+			case err == mbtiles.ErrTileNotFound:
+				return missing.write(w, r, db.TileFormat())
+			case err == mbtiles.ErrQueryTimeout:
+				return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile ID from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			case err != nil:
+				return http.StatusInternalServerError, fmt.Errorf("cannot fetch tile ID from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+			}
+			enc, err := negotiateEncoding(r, db.TileEncoding())
+			if err != nil {
+				return http.StatusNotAcceptable, err
+			}
+			etag = tileIDETag(id, enc)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				s.metrics.observeQuery(db, time.Since(start))
+				setTileHeaders(w, db, enc)
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return http.StatusNotModified, nil
+			}
+		}
+		_, span := s.startSpan(r.Context(), "mbtiles.Read")
+		span.SetAttribute("tile.z", tc.z)
+		span.SetAttribute("tile.x", tc.x)
+		span.SetAttribute("tile.y", tc.y)
+		span.SetAttribute("tile.grid", isGrid)
 		switch {
 		case !isGrid:
-			err = db.ReadTile(tc.z, tc.x, tc.y, &data)
+			err = db.ReadTileContext(r.Context(), tc.z, tc.x, tc.y, &data)
+		case isGrid && db.HasUTFGrid() && s.plainGrid(r, db):
+			err = readGridDecompressed(r.Context(), db, tc.z, tc.x, tc.y, &data)
 		case isGrid && db.HasUTFGrid():
-			err = db.ReadGrid(tc.z, tc.x, tc.y, &data)
+			err = db.ReadGridContext(r.Context(), tc.z, tc.x, tc.y, &data)
 		default:
 			err = fmt.Errorf("no grid supplied by tile database")
 		}
-		if err != nil {
+		s.metrics.observeQuery(db, time.Since(start))
+		span.End()
+		switch {
+		case err == mbtiles.ErrTileNotFound:
+			return missing.write(w, r, db.TileFormat())
+		case err == mbtiles.ErrGridNotFound:
+			return notFoundJSON(w, "Grid does not exist")
+		case err != nil:
 			// augment error info
 			t := "tile"
 			if isGrid {
 				t = "grid"
 			}
+			status := http.StatusInternalServerError
+			if err == mbtiles.ErrQueryTimeout {
+				status = http.StatusServiceUnavailable
+			}
 			err = fmt.Errorf("cannot fetch %s from DB for z=%d, x=%d, y=%d: %v", t, tc.z, tc.x, tc.y, err)
+			return status, err
+		}
+		// the tile exists, but is empty
+		if len(data) <= 1 {
+			return missing.write(w, r, db.TileFormat())
+		}
+
+		if convert {
+			return s.writeConverted(w, r, converted, data, db.TileFormat(), convertedKey{tc, format})
+		}
+		if isGeoJSON {
+			return s.writeGeoJSON(w, r, data, db.TileEncoding(), tc, vf)
+		}
+		if vf != nil {
+			return s.writeFilteredVector(w, r, db, data, db.TileEncoding(), vf)
+		}
+		if !isGrid {
+			return s.writeTile(w, r, db, data, etag)
+		}
+		return s.writeGrid(w, r, db, data)
+	}
+}
+
+// plainGrid reports whether the UTF grids of db are served decompressed to
+// the client of r, which is required for JSONP.
+func (s *ServiceSet) plainGrid(r *http.Request, db Tileset) bool {
+	return s.PlainGrids || r.URL.Query().Get("callback") != "" ||
+		!acceptsEncoding(r, contentEncoding(db.UTFGridCompression()))
+}
+
+// writeGrid writes the UTF grid data of db, which is decompressed if
+// plainGrid is true for r. Decompressed grids are wrapped in a call to the
+// function given by the "callback" query parameter, if any, and compressed
+// with gzip if the client accepts it.
+func (s *ServiceSet) writeGrid(w http.ResponseWriter, r *http.Request, db Tileset, data []byte) (int, error) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if callback := r.URL.Query().Get("callback"); callback != "" {
+		var err error
+		if data, err = jsonp(callback, data); err != nil {
+			return http.StatusBadRequest, err
+		}
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	switch {
+	case !s.plainGrid(r, db):
+		w.Header().Set("Content-Encoding", contentEncoding(db.UTFGridCompression()))
+	case acceptsEncoding(r, "gzip"):
+		_, span := s.startSpan(r.Context(), "compress")
+		var err error
+		data, err = gzipBytes(data)
+		span.End()
+		if err != nil {
 			return http.StatusInternalServerError, err
 		}
-		if data == nil || len(data) <= 1 {
-			return tileNotFoundHandler(w, db.TileFormat())
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	return writeWithETag(w, r, data)
+}
+
+// tileHead answers a HEAD request for a tile without reading the tile data.
+// Missing tiles are answered with missing.
+func (s *ServiceSet) tileHead(w http.ResponseWriter, r *http.Request, db Tileset, tc tileCoord, missing *MissingTile) (int, error) {
+	exists, err := hasTile(r.Context(), db, tc.z, tc.x, tc.y)
+	switch {
+	case err == mbtiles.ErrQueryTimeout:
+		return http.StatusServiceUnavailable, fmt.Errorf("cannot check for tile in DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+	case err != nil:
+		return http.StatusInternalServerError, fmt.Errorf("cannot check for tile in DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+	case !exists:
+		return missing.write(w, r, db.TileFormat())
+	}
+	enc, err := negotiateEncoding(r, db.TileEncoding())
+	if err != nil {
+		return http.StatusNotAcceptable, err
+	}
+	setTileHeaders(w, db, enc)
+	if ids, ok := tileIDs(db); ok {
+		id, err := ids.ReadTileIDContext(r.Context(), tc.z, tc.x, tc.y)
+		switch {
+		case err == mbtiles.ErrQueryTimeout:
+			return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile ID from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		case err != nil:
+			return http.StatusInternalServerError, fmt.Errorf("cannot fetch tile ID from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
 		}
+		w.Header().Set("ETag", tileIDETag(id, enc))
+	}
+	return http.StatusOK, nil
+}
+
+// contentEncoding returns the value of the Content-Encoding header for data
+// that is compressed with c, which is either GZIP or ZLIB. For any other
+// value the returned string is empty.
+func contentEncoding(c mbtiles.TileFormat) string {
+	switch c {
+	case mbtiles.GZIP:
+		return "gzip"
+	case mbtiles.ZLIB:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows the
+// content coding enc.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	return accepts(r.Header.Get("Accept-Encoding"), enc, "*")
+}
 
-		if isGrid {
-			w.Header().Set("Content-Type", "application/json")
-			if db.UTFGridCompression() == mbtiles.ZLIB {
-				w.Header().Set("Content-Encoding", "deflate")
-			} else {
-				w.Header().Set("Content-Encoding", "gzip")
+// acceptsType reports whether the Accept header of r explicitly lists the
+// media type t. Wildcards like image/* are not taken into account.
+func acceptsType(r *http.Request, t string) bool {
+	return accepts(r.Header.Get("Accept"), t, "")
+}
+
+// accepts reports whether the value v is allowed by the comma separated list
+// of a HTTP Accept-* header, either explicitly or by the wildcard, if it is
+// not empty. Values with a quality of zero are not allowed. An explicit entry
+// for v takes precedence over the wildcard, wherever it is listed.
+func accepts(header string, v string, wildcard string) bool {
+	allowed := false
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		value := strings.TrimSpace(params[0])
+		explicit := strings.EqualFold(value, v)
+		if !explicit && (wildcard == "" || value != wildcard) {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
 			}
-		} else {
-			w.Header().Set("Content-Type", db.ContentType())
-			if db.TileFormat() == mbtiles.PBF {
-				w.Header().Set("Content-Encoding", "gzip")
+			if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+				ok = false
 			}
 		}
-		_, err = w.Write(data)
-		return http.StatusOK, err
+		if explicit {
+			return ok
+		}
+		allowed = ok
+	}
+	return allowed
+}
+
+// errNotAcceptable is returned by negotiateEncoding if a tile can neither be
+// sent as stored nor be transcoded.
+var errNotAcceptable = fmt.Errorf("client does not accept tile encoding")
+
+// negotiateEncoding returns the encoding in which a tile with encoding e is
+// sent in response to r. Uncompressed, gzip and deflate tiles are always sent
+// as stored, as all clients are expected to support them. Tiles with other
+// encodings are sent as stored if the client accepts them and are transcoded
+// to gzip otherwise. If that is not possible because there is no decoder for
+// e, errNotAcceptable is returned.
+func negotiateEncoding(r *http.Request, e mbtiles.TileEncoding) (mbtiles.TileEncoding, error) {
+	switch e {
+	case mbtiles.IDENTITY, mbtiles.GZIPENC, mbtiles.ZLIBENC:
+		return e, nil
+	}
+	if acceptsEncoding(r, e.ContentEncoding()) {
+		return e, nil
+	}
+	if e.CanDecode() {
+		return mbtiles.GZIPENC, nil
+	}
+	return e, errNotAcceptable
+}
+
+// setTileHeaders sets the Content-Type and, if the tile is compressed with
+// enc, the Content-Encoding headers for a tile of db.
+func setTileHeaders(w http.ResponseWriter, db Tileset, enc mbtiles.TileEncoding) {
+	w.Header().Set("Content-Type", db.TileFormat().ContentType())
+	if ce := enc.ContentEncoding(); ce != "" {
+		w.Header().Set("Content-Encoding", ce)
+	}
+	switch db.TileEncoding() {
+	case mbtiles.ZSTDENC, mbtiles.BROTLIENC:
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+}
+
+// writeTile writes the tile data of db to w, transcoding it to gzip if the
+// client does not accept its stored encoding.
+func (s *ServiceSet) writeTile(w http.ResponseWriter, r *http.Request, db Tileset, data []byte, etag string) (int, error) {
+	enc, err := negotiateEncoding(r, db.TileEncoding())
+	if err != nil {
+		return http.StatusNotAcceptable, err
+	}
+	if enc != db.TileEncoding() {
+		_, span := s.startSpan(r.Context(), "transcode")
+		data, err = transcodeToGzip(data, db.TileEncoding())
+		span.End()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	setTileHeaders(w, db, enc)
+	if etag == "" {
+		etag = tileETag(data)
+	}
+	return writeTagged(w, r, data, etag)
+}
+
+// transcodeToGzip decompresses data with encoding e and compresses it with
+// gzip.
+func transcodeToGzip(data []byte, e mbtiles.TileEncoding) ([]byte, error) {
+	raw, err := e.Decode(data)
+	if err != nil {
+		return nil, err
 	}
+	return gzipBytes(raw)
+}
+
+// gzipBytes compresses raw with gzip.
+func gzipBytes(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // Handler returns a http.Handler that serves the endpoints of the ServiceSet.
 // The function ef is called with any occuring error if it is non-nil, so it
 // can be used for e.g. logging with logging facitilies of the caller.
-// When the publish parameter is true, a listing of all available services and
-// an endpoint with a HTML slippy map for each service are served by the Handler.
+// When the publish parameter is true, a listing of all available services, an
+// endpoint with a HTML slippy map and an endpoint with tile statistics for each
+// service are served by the Handler.
 func (s *ServiceSet) Handler(ef func(error), publish bool) http.Handler {
+	return s.logged(s.traced(s.rebuilt(func(tilesets map[string]Tileset) http.Handler {
+		return s.servicesMux(ef, publish, tilesets)
+	})))
+}
+
+// servicesMux returns a http.ServeMux with the handlers of the tilesets below
+// "/services".
+func (s *ServiceSet) servicesMux(ef func(error), publish bool, tilesets map[string]Tileset) *http.ServeMux {
 	m := http.NewServeMux()
 	if publish {
 		m.Handle("/services", wrapGetWithErrors(ef, s.listServices))
 	}
-	for id, db := range s.tilesets {
+	for id, db := range tilesets {
 		p := "/services/" + id
-		m.Handle(p, wrapGetWithErrors(ef, s.tileJSON(id, db, publish)))
-		m.Handle(p+"/tiles/", wrapGetWithErrors(ef, s.tiles(db)))
+		id := id
+		handle := func(pattern string, hf handlerFunc) {
+			m.Handle(pattern, wrapGetWithErrors(ef, s.authorized(id, hf)))
+		}
+		handle(p, s.surrogate(id, s.policed(id, db, s.conditional(db, s.cached(id, "tilejson", db, s.tileJSON(id, db, publish))))))
+		tiles := s.intercepted(id, s.surrogate(id, s.countRequests(id, db, s.policed(id, db, s.conditional(db, s.cached(id, "tiles", db, s.tiles(id, db)))))))
+		handle(p+"/tiles/", tiles)
+		m.Handle(p+"/tiles/batch", wrapWithErrors(ef, s.authorized(id, s.batch(id, db)), "POST"))
+		switch db.(type) {
+		case Extractor, TileLister:
+			handle(p+"/bundle", s.bundle(id, db))
+		}
+		handle(p+"/wmts", s.wmtsKVP(id, db, tiles))
+		handle(p+"/wmts/", s.wmtsREST(id, db, tiles))
+		if imageCodecs[db.TileFormat()].decode != nil {
+			handle(p+"/wms", s.wms(id, db))
+			handle(p+"/static/", s.staticMap(id, db))
+			handle(p+"/elevation", s.elevation(id, db))
+			handle(p+"/hillshade/", s.hillshade(id, db))
+			handle(p+"/contours/", s.contours(id, db))
+		}
+		if db.TileFormat() == mbtiles.PBF {
+			handle(p+"/query", s.pointQuery(id, db))
+		}
 		if publish {
-			m.Handle(p+"/map", wrapGetWithErrors(ef, s.serviceHTML(id, db)))
+			handle(p+"/map", s.serviceHTML(id, db))
+		}
+		if st, ok := db.(StatsTileset); ok && publish {
+			handle(p+"/stats", s.stats(st))
 		}
 	}
 	return m
 }
+
+// tileETag returns a strong entity tag for the tile data.
+func tileETag(data []byte) string {
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// tileIDETag returns a strong entity tag for the tile with the ID id of a
+// deduplicated DB that is sent with the encoding enc.
+func tileIDETag(id string, enc mbtiles.TileEncoding) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s", id, enc)
+	return fmt.Sprintf(`"id-%016x"`, h.Sum64())
+}
+
+// etagMatches reports whether the If-None-Match header value matches etag.
+// As required for If-None-Match, the weak comparison is used.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeWithETag writes the tile data to w together with its ETag. If the
+// If-None-Match header of r matches the ETag, only the status 304 Not Modified
+// is written.
+func writeWithETag(w http.ResponseWriter, r *http.Request, data []byte) (int, error) {
+	return writeTagged(w, r, data, tileETag(data))
+}
+
+// writeTagged is like writeWithETag, but with the given ETag.
+func writeTagged(w http.ResponseWriter, r *http.Request, data []byte, etag string) (int, error) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified, nil
+	}
+	_, err := w.Write(data)
+	return http.StatusOK, err
+}
@@ -0,0 +1,3428 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/consbio/mbtileserver/internal/pbf"
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const testBaseDir = "../mbtiles/testdata"
+
+func newTestServiceSet(t *testing.T) *ServiceSet {
+	s, err := NewFromBaseDir(testBaseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestListServices(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(func(err error) { t.Error(err) }, true)
+
+	req := httptest.NewRequest("GET", "/services", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var services []ServiceInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != s.Size() {
+		t.Errorf("expected %d services, got %d", s.Size(), len(services))
+	}
+
+	tests := []struct {
+		query string
+		ids   []string
+		total string
+		link  string
+	}{
+		{"q=CLASS", []string{"geography-class-jpg", "geography-class-png"}, "2", ""},
+		{"format=webp,jpg", []string{"geography-class-jpg", "openstreetmap/open-streets-dc"}, "2", ""},
+		{"bbox=-77.1,38.8,-77,38.9&format=webp", []string{"openstreetmap/open-streets-dc"}, "1", ""},
+		{"bbox=0,-10,10,-5&format=webp", nil, "0", ""},
+		{"limit=2", []string{"geography-class-jpg", "geography-class-png"}, "3", `<http://example.com/services?limit=2&offset=2>; rel="next"`},
+		{"limit=2&offset=2", []string{"openstreetmap/open-streets-dc"}, "3", `<http://example.com/services?limit=2&offset=0>; rel="prev"`},
+		{"offset=5", nil, "3", ""},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/services?"+tc.query, nil))
+		services = nil
+		if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		var ids []string
+		for _, svc := range services {
+			ids = append(ids, strings.TrimPrefix(svc.URL, "http://example.com/services/"))
+		}
+		if !reflect.DeepEqual(ids, tc.ids) {
+			t.Errorf("%s: expected services %v, got %v", tc.query, tc.ids, ids)
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != tc.total {
+			t.Errorf("%s: expected X-Total-Count %s, got %s", tc.query, tc.total, total)
+		}
+		if link := rec.Header().Get("Link"); link != tc.link {
+			t.Errorf("%s: expected Link %s, got %s", tc.query, tc.link, link)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services?q=png&metadata=true", nil))
+	services = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Metadata == nil || services[0].Metadata.Format != "png" ||
+		len(services[0].Metadata.Bounds) != 4 || services[0].Metadata.MaxZoom < services[0].Metadata.MinZoom {
+		t.Errorf("expected metadata of geography-class-png, got %+v", services)
+	}
+
+	h = s.Handler(nil, true)
+	for _, query := range []string{"bbox=1,2,3", "limit=0", "limit=1001", "offset=-1", "metadata=maybe"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/services?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	s := NewServiceSet(WithPath("maps"), WithTileScheme(mbtiles.TMS))
+	if err := s.AddDBOnPath(filepath.Join(testBaseDir, "geography-class-png.mbtiles"), "world"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/maps/", http.StripPrefix("/maps", s.ServeMux(func(err error) { t.Error(err) }, true)))
+
+	for _, path := range []string{"/maps/services", "/maps/services/world/tiles/1/0/1.png", "/maps/arcgis/rest/services", "/maps/health", "/maps/metrics"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/maps/services/world", nil))
+	var tilejson struct {
+		Tiles  []string `json:"tiles"`
+		Scheme string   `json:"scheme"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tilejson); err != nil {
+		t.Fatal(err)
+	}
+	if len(tilejson.Tiles) != 1 || !strings.HasPrefix(tilejson.Tiles[0], "http://example.com/maps/services/world/tiles/") || tilejson.Scheme != "tms" {
+		t.Errorf("unexpected TileJSON %+v", tilejson)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/maps/admin/purge", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected admin endpoints without AdminKey to be missing, got status %d", rec.Code)
+	}
+}
+
+// dbStore is a TileStore that reads the tiles of a DB in the TMS scheme
+// without listing them.
+type dbStore struct {
+	db *mbtiles.DB
+}
+
+func (s dbStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	return s.db.ReadTileContext(ctx, z, x, y, data)
+}
+
+func (s dbStore) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	return s.db.ReadMetadataContext(ctx)
+}
+
+func (s dbStore) Tiles(ctx context.Context, zooms []uint8) mbtiles.TileSource { return nil }
+
+func (s dbStore) TileFormat() (mbtiles.TileFormat, mbtiles.TileEncoding) {
+	return s.db.TileFormat(), s.db.TileEncoding()
+}
+
+func (s dbStore) Close() error { return s.db.Close() }
+
+// timeoutStore is a dbStore whose queries always time out.
+type timeoutStore struct {
+	dbStore
+}
+
+func (s timeoutStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	return mbtiles.ErrQueryTimeout
+}
+
+func TestAddStoreOnPath(t *testing.T) {
+	filename := filepath.Join(testBaseDir, "geography-class-png.mbtiles")
+	db, err := mbtiles.NewDB(filename, mbtiles.Scheme(mbtiles.TMS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	if err := s.AddStoreOnPath(dbStore{db}, "store"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.AddDBOnPath(filename, "file"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(func(err error) { t.Error(err) }, true)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	a, b := get("/services/store/tiles/1/0/0.png"), get("/services/file/tiles/1/0/0.png")
+	if a.Code != http.StatusOK || !bytes.Equal(a.Body.Bytes(), b.Body.Bytes()) {
+		t.Errorf("expected the tile of the file from the store, got status %d", a.Code)
+	}
+	if rec := get("/services/store"); rec.Code != http.StatusOK {
+		t.Errorf("expected TileJSON of store, got status %d", rec.Code)
+	}
+}
+
+// plainTileset is a Tileset that has none of the optional methods of the
+// Tileset it embeds.
+type plainTileset struct {
+	Tileset
+}
+
+func TestAddTilesetOnPath(t *testing.T) {
+	filename := filepath.Join(testBaseDir, "geography-class-png.mbtiles")
+	db, err := mbtiles.NewDB(filename, mbtiles.Scheme(mbtiles.XYZ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	if err := s.AddTilesetOnPath(&plainTileset{db}, ""); err == nil {
+		t.Error("expected error for empty path")
+	}
+	if err := s.AddTilesetOnPath(&plainTileset{db}, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.AddDBOnPath(filename, "file"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	a, b := serve("GET", "/services/plain/tiles/1/0/0.png"), serve("GET", "/services/file/tiles/1/0/0.png")
+	if a.Code != http.StatusOK || !bytes.Equal(a.Body.Bytes(), b.Body.Bytes()) {
+		t.Errorf("expected the tile of the file from the tileset, got status %d", a.Code)
+	}
+	for _, path := range []string{"/services/plain/tiles/1/0/0.png", "/services/plain/tiles/9/0/0.png"} {
+		if a, b := serve("HEAD", path), serve("HEAD", strings.Replace(path, "plain", "file", 1)); a.Code != b.Code {
+			t.Errorf("HEAD %s: expected status %d of the file, got %d", path, b.Code, a.Code)
+		}
+	}
+	for path, status := range map[string]int{
+		"/services/plain":        http.StatusOK,
+		"/services/plain/map":    http.StatusOK,
+		"/services/plain/stats":  http.StatusNotFound,
+		"/services/plain/bundle": http.StatusNotFound,
+		"/services/file/stats":   http.StatusOK,
+	} {
+		if rec := serve("GET", path); rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.HealthHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected tileset that cannot be pinged to be ready, got status %d", rec.Code)
+	}
+}
+
+func TestTiles(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/services/geography-class-png/tiles/0/0/0.png", http.StatusOK, "image/png"},
+		{"/services/geography-class-jpg/tiles/1/1/1.jpg", http.StatusOK, "image/jpeg"},
+		{"/services/geography-class-png/tiles/1/2/0.png", http.StatusBadRequest, ""},
+		{"/services/geography-class-png/tiles/0/0", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); tc.contentType != "" && ct != tc.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tc.path, tc.contentType, ct)
+		}
+	}
+}
+
+func TestTileScheme(t *testing.T) {
+	xyz := newTestServiceSet(t).Handler(nil, true)
+	s := newTestServiceSet(t)
+	s.Scheme = mbtiles.TMS
+	tms := s.Handler(nil, true)
+
+	get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+		return rec
+	}
+	a := get(xyz, "/services/geography-class-png/tiles/1/0/0.png").Body.String()
+	b := get(tms, "/services/geography-class-png/tiles/1/0/1.png").Body.String()
+	if a != b {
+		t.Error("expected the same tile for the XYZ row 0 and the TMS row 1")
+	}
+	var tilejson map[string]interface{}
+	if err := json.Unmarshal(get(tms, "/services/geography-class-png").Body.Bytes(), &tilejson); err != nil {
+		t.Fatal(err)
+	}
+	if tilejson["scheme"] != "tms" {
+		t.Errorf("expected TileJSON scheme tms, got %v", tilejson["scheme"])
+	}
+	// WMTS rows are always counted from the north
+	c := get(tms, "/services/geography-class-png/wmts/tile/1.0.0/geography-class-png/default/GoogleMapsCompatible/1/0/0.png").Body.String()
+	if a != c {
+		t.Error("expected the same tile for the XYZ row 0 and the WMTS row 0")
+	}
+}
+
+func TestServiceHTML(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	req := httptest.NewRequest("GET", "/services/openstreetmap/open-streets-dc/map", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "[-77.1408,38.779,-76.893,39.0088]") || !strings.Contains(body, "L.tileLayer") {
+		t.Errorf("expected Leaflet map with bounds filled in, got %s", body)
+	}
+
+	// there is no vector tileset in the test data
+	rec = httptest.NewRecorder()
+	p := struct {
+		URL, Root, ID    string
+		MinZoom, MaxZoom int
+		Bounds           []float64
+	}{"http://localhost/services/vector", "http://localhost", "vector", 0, 14, []float64{-180, -85, 180, 85}}
+	if status, err := s.executeTemplate(rec, "map_gl", p); status != http.StatusOK || err != nil {
+		t.Fatalf("expected status %d, got %d: %v", http.StatusOK, status, err)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "[-180,-85,180,85]") || !strings.Contains(body, "maplibregl.Map") {
+		t.Errorf("expected MapLibre map with bounds filled in, got %s", body)
+	}
+}
+
+func TestGrid(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	const path = "/services/geography-class-png/tiles/0/0/0.json"
+
+	tests := []struct {
+		plain          bool
+		acceptEncoding string
+		encoding       string
+	}{
+		{false, "gzip, deflate", "deflate"},
+		{false, "gzip", "gzip"},
+		{false, "", ""},
+		{true, "gzip, deflate", "gzip"},
+	}
+	for _, tc := range tests {
+		s.PlainGrids = tc.plain
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%+v: expected status %d, got %d", tc, http.StatusOK, rec.Code)
+			continue
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != tc.encoding {
+			t.Errorf("%+v: expected Content-Encoding %q, got %q", tc, tc.encoding, ce)
+		}
+		var grid interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &grid); tc.encoding == "" && err != nil {
+			t.Errorf("%+v: expected plain JSON grid: %v", tc, err)
+		}
+	}
+
+	s.PlainGrids = false
+	req := httptest.NewRequest("GET", path+"?callback=grid", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "" || !strings.HasPrefix(rec.Body.String(), "/**/grid({") {
+		t.Errorf("expected uncompressed JSONP grid, got status %d: %.20q", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest("GET", path+"?callback=alert(1)", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid callback, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestTileHead(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	req := httptest.NewRequest("HEAD", "/services/geography-class-png/tiles/0/0/0.png", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected Content-Type image/png, got %q", ct)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, err := mbtiles.NewDB(filepath.Join(testBaseDir, "geography-class-png.mbtiles"), mbtiles.Scheme(mbtiles.TMS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	defer s.Close()
+	if err := s.AddStoreOnPath(timeoutStore{dbStore{db}}, "slow"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	for _, method := range []string{"GET", "HEAD"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/services/slow/tiles/0/0/0.png", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %d, got %d", method, http.StatusServiceUnavailable, rec.Code)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		stored         mbtiles.TileEncoding
+		sent           mbtiles.TileEncoding
+		err            error
+	}{
+		{"", mbtiles.GZIPENC, mbtiles.GZIPENC, nil},
+		{"", mbtiles.IDENTITY, mbtiles.IDENTITY, nil},
+		{"gzip, deflate, br", mbtiles.BROTLIENC, mbtiles.BROTLIENC, nil},
+		{"gzip, br;q=0", mbtiles.BROTLIENC, mbtiles.BROTLIENC, errNotAcceptable},
+		{"gzip, zstd;q=0.5", mbtiles.ZSTDENC, mbtiles.ZSTDENC, nil},
+		{"*", mbtiles.ZSTDENC, mbtiles.ZSTDENC, nil},
+		{"*, br;q=0", mbtiles.BROTLIENC, mbtiles.BROTLIENC, errNotAcceptable},
+		{"br;q=0, *", mbtiles.BROTLIENC, mbtiles.BROTLIENC, errNotAcceptable},
+		{"*;q=0, zstd", mbtiles.ZSTDENC, mbtiles.ZSTDENC, nil},
+		{"gzip", mbtiles.ZSTDENC, mbtiles.ZSTDENC, errNotAcceptable},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		enc, err := negotiateEncoding(r, tc.stored)
+		if enc != tc.sent || err != tc.err {
+			t.Errorf("%q, %v: expected %v, %v, got %v, %v", tc.acceptEncoding, tc.stored, tc.sent, tc.err, enc, err)
+		}
+	}
+}
+
+func TestOverzoom(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.Overzoom = 2
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path   string
+		status int
+		size   int
+	}{
+		{"/services/geography-class-png/tiles/3/5/2.png", http.StatusOK, 256},
+		{"/services/geography-class-jpg/tiles/2/3/1.jpg", http.StatusOK, 256},
+		{"/services/geography-class-png/tiles/4/5/2.png", http.StatusOK, 256}, // blank tile beyond overzoom
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != tc.size || b.Dy() != tc.size {
+			t.Errorf("%s: expected tile of %d pixels, got %v", tc.path, tc.size, b)
+		}
+	}
+}
+
+func TestScaledTiles(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path   string
+		status int
+		size   int
+	}{
+		{"/services/geography-class-png/tiles/0/0/0@2x.png", http.StatusOK, 512}, // assembled
+		{"/services/geography-class-jpg/tiles/1/1/0@2x.jpg", http.StatusOK, 512}, // scaled up
+		{"/services/geography-class-png/tiles/1/1/0@1x.png", http.StatusOK, 256},
+		{"/services/geography-class-png/tiles/1/1/0@3x.png", http.StatusBadRequest, 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != tc.size || b.Dy() != tc.size {
+			t.Errorf("%s: expected tile of %d pixels, got %v", tc.path, tc.size, b)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/services/geography-class-png", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var tilejson struct {
+		Scales []int `json:"scales"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tilejson); err != nil || len(tilejson.Scales) != 2 {
+		t.Errorf("expected scales in TileJSON, got %s (%v)", rec.Body, err)
+	}
+}
+
+func TestTileSize(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.TileSize = 512
+	h := s.Handler(nil, true)
+
+	for _, path := range []string{
+		"/services/geography-class-png/tiles/0/0/0.png",
+		"/services/geography-class-png/tiles/1/1/1.png",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+			continue
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != 512 || b.Dy() != 512 {
+			t.Errorf("%s: expected tile of 512 pixels, got %v", path, b)
+		}
+	}
+
+	// tiles of 512 pixels are cropped to 256 pixels
+	sc := &scaler{db: s.dbs()["geography-class-png"], maxZoom: 1, native: 512}
+	sc.once.Do(func() {})
+	data, err := sc.tile(context.Background(), 0, tileCoord{1, 1, 0}, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, _, err := image.Decode(bytes.NewReader(data)); err != nil || img.Bounds().Dx() != 128 {
+		t.Errorf("expected cropped tile of 128 pixels, got %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	body := `{"tiles": ["0/0/0", "1/1/0", "5/0/0"]}`
+
+	req := httptest.NewRequest("POST", "/services/geography-class-png/tiles/batch", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	var locations []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := png.Decode(part); err != nil {
+			t.Error(err)
+		}
+		locations = append(locations, part.Header.Get("Content-Location"))
+	}
+	if len(locations) != 2 || locations[1] != "/services/geography-class-png/tiles/1/1/0.png" {
+		t.Errorf("expected the two existing tiles, got %v", locations)
+	}
+
+	req = httptest.NewRequest("POST", "/services/geography-class-png/tiles/batch", strings.NewReader(body))
+	req.Header.Set("Accept", "application/octet-stream")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	data := rec.Body.Bytes()
+	n := 0
+	for len(data) >= 13 {
+		size := binary.BigEndian.Uint32(data[9:])
+		if data[0] != uint8(n) || binary.BigEndian.Uint32(data[1:]) != uint32(n) {
+			t.Errorf("unexpected tile coordinates %v", data[:9])
+		}
+		data = data[13+size:]
+		n++
+	}
+	if n != 2 || len(data) != 0 || rec.Header().Get("X-Tile-Content-Type") != "image/png" {
+		t.Errorf("expected a stream of 2 tiles, got %d and %d bytes", n, len(data))
+	}
+
+	for _, body := range []string{`{"tiles": ["0/0"]}`, `{"tiles": ["1/2/0"]}`, `[`} {
+		req = httptest.NewRequest("POST", "/services/geography-class-png/tiles/batch", strings.NewReader(body))
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+// testInterceptor vetoes requests for tiles beyond zoom level 0 and replaces
+// the tiles by their size.
+type testInterceptor struct {
+	requests int32
+}
+
+func (ti *testInterceptor) InterceptRequest(r *http.Request, t TileRequest) error {
+	atomic.AddInt32(&ti.requests, 1)
+	if t.Z > 0 {
+		return &StatusError{Status: http.StatusPaymentRequired, Err: fmt.Errorf("zoom level %d", t.Z)}
+	}
+	return nil
+}
+
+func (ti *testInterceptor) InterceptResponse(r *http.Request, t TileRequest, status int, header http.Header, body []byte) ([]byte, error) {
+	header.Set("Content-Type", "text/plain")
+	return []byte(fmt.Sprintf("%s %d/%d/%d: %d bytes", t.Tileset, t.Z, t.X, t.Y, len(body))), nil
+}
+
+func TestTileInterceptor(t *testing.T) {
+	ti := &testInterceptor{}
+	s := newTestServiceSet(t)
+	s.TileInterceptors = []TileInterceptor{ti}
+	h := s.Handler(nil, true)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/services/geography-class-png/tiles/0/0/0.png")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/plain" || !strings.HasPrefix(rec.Body.String(), "geography-class-png 0/0/0: ") {
+		t.Errorf("expected intercepted response, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("/services/geography-class-png/tiles/1/0/0.png"); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected vetoed request, got status %d", rec.Code)
+	}
+	rec = get("/services/geography-class-png/wmts/tile/1.0.0/geography-class-png/default/GoogleMapsCompatible/0/0/0.png")
+	if !strings.HasPrefix(rec.Body.String(), "geography-class-png 0/0/0: ") {
+		t.Errorf("expected intercepted WMTS response, got %q", rec.Body.String())
+	}
+	if rec := get("/services/geography-class-png"); rec.Code != http.StatusOK || atomic.LoadInt32(&ti.requests) != 3 {
+		t.Errorf("expected TileJSON not to be intercepted, got status %d after %d requests", rec.Code, ti.requests)
+	}
+
+	// vetoed tiles are left out of bundles
+	rec = get("/services/geography-class-png/bundle?bbox=-170,10,-10,80&format=zip")
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "0/0/0.png" {
+		t.Errorf("expected the tile at zoom level 0 only in bundle, got %d tiles", len(zr.File))
+	}
+	// and the tiles derived from a vetoed tile are answered with its status
+	for _, path := range []string{
+		"/services/geography-class-png/tiles/1/0/0/info",
+		"/services/geography-class-png/hillshade/1/0/0.png",
+	} {
+		if rec := get(path); rec.Code != http.StatusPaymentRequired {
+			t.Errorf("%s: expected vetoed request, got status %d", path, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	s.OGCHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/ogc/collections/geography-class-png/tiles/WebMercatorQuad/1/0/0", nil))
+	if rec.Code != http.StatusPaymentRequired {
+		t.Errorf("expected vetoed OGC API request, got status %d", rec.Code)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png/bundle?"+query, nil))
+		return rec
+	}
+
+	rec := get("bbox=-170,10,-10,80&format=zip")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"0/0/0.png", "1/0/0.png"}) {
+		t.Errorf("unexpected tiles in zip bundle: %v", names)
+	}
+
+	rec = get("bbox=-170,10,-10,80&minzoom=1&maxzoom=1")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.mbtiles" {
+		t.Fatalf("expected mbtiles bundle, got status %d", rec.Code)
+	}
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "bundle.mbtiles")
+	if err := ioutil.WriteFile(filename, rec.Body.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := mbtiles.NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for tile, expected := range map[[3]uint64]bool{{0, 0, 0}: false, {1, 0, 0}: true, {1, 1, 0}: false} {
+		if ok, err := db.HasTile(uint8(tile[0]), tile[1], tile[2]); ok != expected || err != nil {
+			t.Errorf("expected tile %v in mbtiles bundle: %v, got %v (%v)", tile, expected, ok, err)
+		}
+	}
+
+	for _, query := range []string{"bbox=1,2", "minzoom=2&maxzoom=1", "format=tar", "maxzoom=30"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestOverzoomVector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tile := &mvt.Tile{Layers: []*mvt.Layer{{
+		Name:   "water",
+		Extent: 4096,
+		Features: []*mvt.Feature{{
+			Type:     mvt.Polygon,
+			Geometry: [][]mvt.Coord{{{X: 0, Y: 0}, {X: 1024, Y: 0}, {X: 1024, Y: 1024}, {X: 0, Y: 1024}}},
+		}},
+	}}}
+	raw, err := mvt.Encode(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := gzipBytes(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "vector.mbtiles")
+	w, err := mbtiles.CreateDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteMetadata("format", "pbf")
+	w.WriteTile(0, 0, 0, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New()
+	s.Overzoom = 1
+	if err := s.AddDBOnPath(filename, "vector"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path     string
+		features int
+	}{
+		{"/services/vector/tiles/1/0/0.pbf", 1},
+		{"/services/vector/tiles/1/1/1.pbf", 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("%s: expected gzip encoding, got %q", tc.path, ce)
+		}
+		raw, err := mbtiles.GZIPENC.Decode(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := mvt.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for _, l := range child.Layers {
+			n += len(l.Features)
+		}
+		if n != tc.features {
+			t.Errorf("%s: expected %d features, got %d", tc.path, tc.features, n)
+		}
+	}
+}
+
+func TestConvertTile(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.jpg", "image/jpeg"},
+		{"/services/geography-class-png/tiles/1/0/0.jpg", "image/jpeg"}, // from the cache
+		{"/services/geography-class-jpg/tiles/1/0/0.png", "image/png"},
+		{"/services/geography-class-png/tiles/1/0/0.webp", "image/png"}, // no encoder
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tc.path, tc.contentType, ct)
+		}
+		if _, _, err := image.Decode(rec.Body); err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	// pretend that WebP tiles can be encoded
+	RegisterImageCodec(mbtiles.WEBP, nil, func(w io.Writer, img image.Image, quality int) error {
+		_, err := w.Write([]byte("RIFF\x00\x00\x00\x00WEBP"))
+		return err
+	})
+	defer delete(imageCodecs, mbtiles.WEBP)
+	// and AVIF tiles, as with the avif build tag of the server
+	RegisterImageCodec(mbtiles.AVIF, nil, func(w io.Writer, img image.Image, quality int) error {
+		_, err := w.Write([]byte("\x00\x00\x00\x1cftypavif"))
+		return err
+	})
+	defer delete(imageCodecs, mbtiles.AVIF)
+
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path        string
+		accept      string
+		contentType string
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/avif,image/webp,image/*,*/*;q=0.8", "image/avif"},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/avif;q=0,image/webp", "image/webp"},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/webp,image/*,*/*;q=0.8", "image/webp"},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/*,*/*;q=0.8", "image/png"},
+		{"/services/geography-class-png/tiles/1/0/0.png", "image/webp;q=0", "image/png"},
+		{"/services/geography-class-jpg/tiles/1/0/0", "image/webp", "image/webp"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s (Accept: %s): expected content type %s, got %s", tc.path, tc.accept, tc.contentType, ct)
+		}
+		if v := rec.Header().Get("Vary"); v != "Accept" {
+			t.Errorf("%s: expected Vary: Accept, got %q", tc.path, v)
+		}
+	}
+}
+
+func TestTileETag(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	path := "/services/geography-class-png/tiles/1/0/0.png"
+	req := httptest.NewRequest("GET", path, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected status 200 with ETag, got %d and %q", rec.Code, etag)
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{etag, http.StatusNotModified},
+		{`"foo", W/` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"foo"`, http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("If-None-Match %s: expected status %d, got %d", tc.ifNoneMatch, tc.status, rec.Code)
+		}
+		if tc.status == http.StatusNotModified && rec.Body.Len() > 0 {
+			t.Errorf("If-None-Match %s: expected empty body", tc.ifNoneMatch)
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	db := s.dbs()["geography-class-png"]
+	modified := db.TimeStamp().UTC()
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tile := "/services/geography-class-png/tiles/1/0/0.png"
+	rec := get(tile, nil)
+	etag := rec.Header().Get("ETag")
+	if lm := rec.Header().Get("Last-Modified"); lm != modified.Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified %s, got %q", modified.Format(http.TimeFormat), lm)
+	}
+
+	tests := []struct {
+		path   string
+		header http.Header
+		status int
+	}{
+		{tile, http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{tile, http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK},
+		{"/services/geography-class-png", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"/services/geography-class-png/tiles/1/0/0.json", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		// If-None-Match takes precedence
+		{tile, http.Header{"If-None-Match": {`"foo"`}, "If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusOK},
+		{tile, http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+	}
+	for _, tc := range tests {
+		if rec := get(tc.path, tc.header); rec.Code != tc.status {
+			t.Errorf("%s with %v: expected status %d, got %d", tc.path, tc.header, tc.status, rec.Code)
+		}
+	}
+
+	// the ETag of the tile is known without reading it again
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(tile, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != etag {
+		t.Errorf("expected status %d with ETag %s from closed tileset, got %d and %q", http.StatusNotModified, etag, rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// the ETags are kept when the handlers are rebuilt for another tileset
+	if err := s.AddDBOnPath(filepath.Join(testBaseDir, "geography-class-jpg.mbtiles"), "added"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(tile, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("expected status %d from closed tileset after adding another one, got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.ResponseCache = NewResponseCache(1 << 20)
+	h := s.Handler(nil, true)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, path := range []string{"/services/geography-class-png/tiles/1/0/0.png", "/services/geography-class-png"} {
+		first, second := get(path), get(path)
+		if first.Code != http.StatusOK || second.Code != http.StatusOK || first.Body.String() != second.Body.String() {
+			t.Errorf("%s: expected the same response twice, got status %d and %d", path, first.Code, second.Code)
+		}
+		if first.Header().Get("Content-Type") != second.Header().Get("Content-Type") {
+			t.Errorf("%s: expected the same headers twice, got %v and %v", path, first.Header(), second.Header())
+		}
+	}
+	if stats := s.ResponseCache.Stats(); stats.Hits != 2 || stats.Misses != 2 || stats.Items != 2 {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+
+	// concurrent requests are answered with a single call of the handler
+	const n = 20
+	c := NewResponseCache(1 << 20)
+	s = &ServiceSet{ResponseCache: c}
+	var calls int32
+	release := make(chan struct{})
+	hf := s.cached("tileset", "tiles", nil, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("ETag", `"tile"`)
+		_, err := w.Write([]byte("tile"))
+		return http.StatusOK, err
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/services/tileset/tiles/0/0/0.png", nil)
+			if i%2 == 0 {
+				req.Header.Set("If-None-Match", `"tile"`)
+			}
+			rec := httptest.NewRecorder()
+			hf(rec, req)
+			if i%2 == 0 && rec.Code != http.StatusNotModified || i%2 == 1 && rec.Body.String() != "tile" {
+				t.Errorf("request %d: unexpected response with status %d: %q", i, rec.Code, rec.Body.String())
+			}
+		}(i)
+	}
+	for c.Stats().Misses < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected a single call of the handler, got %d", calls)
+	}
+	if stats := c.Stats(); stats.Coalesced != n-1 {
+		t.Errorf("expected %d coalesced requests, got %d", n-1, stats.Coalesced)
+	}
+
+	// the response is read for the waiting requests even if the client of
+	// the first request goes away
+	c = NewResponseCache(1 << 20)
+	s = &ServiceSet{ResponseCache: c}
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	hf = s.cached("tileset", "tiles", nil, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		once.Do(func() { close(started) })
+		<-release
+		if err := r.Context().Err(); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		_, err := w.Write([]byte("tile"))
+		return http.StatusOK, err
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go hf(httptest.NewRecorder(), httptest.NewRequest("GET", "/services/tileset/tiles/0/0/0.png", nil).WithContext(ctx))
+	<-started
+	follower := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		status, err := hf(rec, httptest.NewRequest("GET", "/services/tileset/tiles/0/0/0.png", nil))
+		if err != nil || status >= http.StatusBadRequest {
+			rec.Code = status
+		}
+		follower <- rec
+	}()
+	for c.Stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	close(release)
+	if rec := <-follower; rec.Code != http.StatusOK || rec.Body.String() != "tile" {
+		t.Errorf("expected the response for the waiting request after the first was cancelled, got status %d: %q", rec.Code, rec.Body.String())
+	}
+	if stats := c.Stats(); stats.Coalesced != 1 {
+		t.Errorf("expected 1 coalesced request, got %d", stats.Coalesced)
+	}
+}
+
+// fakeRedis serves the GET, SET, AUTH and SELECT commands of Redis from a
+// map, for the connections accepted from l.
+func fakeRedis(l net.Listener, password string) {
+	var mu sync.Mutex
+	values := make(map[string]string)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				var n int
+				if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+					return
+				}
+				args := make([]string, n)
+				for i := range args {
+					var size int
+					if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+						return
+					}
+					b := make([]byte, size+2)
+					if _, err := io.ReadFull(r, b); err != nil {
+						return
+					}
+					args[i] = string(b[:size])
+				}
+				mu.Lock()
+				switch strings.ToUpper(args[0]) {
+				case "AUTH":
+					if args[len(args)-1] == password {
+						io.WriteString(conn, "+OK\r\n")
+					} else {
+						io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+					}
+				case "SELECT":
+					io.WriteString(conn, "+OK\r\n")
+				case "GET":
+					if v, ok := values[args[1]]; ok {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+					} else {
+						io.WriteString(conn, "$-1\r\n")
+					}
+				case "SET":
+					values[args[1]] = args[2]
+					io.WriteString(conn, "+OK\r\n")
+				default:
+					io.WriteString(conn, "-ERR unknown command\r\n")
+				}
+				mu.Unlock()
+			}
+		}(conn)
+	}
+}
+
+func TestRedisReplyLimit(t *testing.T) {
+	tests := []struct {
+		reply string
+		value interface{}
+		err   bool
+	}{
+		{"$3\r\nfoo\r\n", []byte("foo"), false},
+		{"$-1\r\n", nil, false},
+		{fmt.Sprintf("$%d\r\n", maxSharedSize+1), nil, true},
+		{"$99999999999999\r\n", nil, true},
+	}
+	for _, tc := range tests {
+		rc := &redisConn{r: bufio.NewReader(strings.NewReader(tc.reply))}
+		v, err := rc.reply()
+		if (err != nil) != tc.err || !reflect.DeepEqual(v, tc.value) {
+			t.Errorf("%q: expected %v (error %v), got %v (%v)", tc.reply, tc.value, tc.err, v, err)
+		}
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fakeRedis(l, "secret")
+
+	if _, err := NewRedisCache("http://"+l.Addr().String(), 0); err == nil {
+		t.Error("expected error for invalid scheme")
+	}
+	wrong, err := NewRedisCache("redis://:wrong@"+l.Addr().String()+"/1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := wrong.Get(context.Background(), "key"); err == nil {
+		t.Error("expected error for wrong password")
+	}
+
+	// a second server is answered from the cache filled by the first one
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		redis, err := NewRedisCache("redis://:secret@"+l.Addr().String()+"/1", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer redis.Close()
+		s := newTestServiceSet(t)
+		s.ResponseCache = NewResponseCache(0)
+		s.ResponseCache.Shared = redis
+		req := httptest.NewRequest("GET", "/services/geography-class-png/tiles/1/0/0.png", nil)
+		rec := httptest.NewRecorder()
+		s.Handler(nil, true).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("expected PNG tile, got status %d and %v", rec.Code, rec.Header())
+		}
+		bodies = append(bodies, rec.Body.String())
+		stats := s.ResponseCache.Stats()
+		if stats.SharedHits != int64(i) || stats.SharedErrors != 0 || stats.Items != 0 {
+			t.Errorf("server %d: unexpected statistics %+v", i, stats)
+		}
+	}
+	if bodies[0] != bodies[1] {
+		t.Error("expected the same tile from the shared cache")
+	}
+}
+
+func TestPeerCache(t *testing.T) {
+	// two servers in one process need caches with different names
+	var sets [2]*ServiceSet
+	var caches [2]*PeerCache
+	var urls []string
+	for i, name := range []string{"test-peer-a", "test-peer-b"} {
+		sets[i] = newTestServiceSet(t)
+		sets[i].ResponseCache = NewResponseCache(0)
+		srv := httptest.NewServer(nil)
+		defer srv.Close()
+		var err error
+		if caches[i], err = NewPeerCache(name, srv.URL, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+		caches[i].Secret = "peer-secret"
+		srv.Config.Handler = caches[i]
+		sets[i].ResponseCache.Peers = caches[i]
+		urls = append(urls, srv.URL)
+	}
+	if _, err := NewPeerCache("test-peer-a", urls[0], 1<<20); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	for _, c := range caches {
+		if err := c.SetResolved(context.Background(), urls); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(s *ServiceSet, path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		s.Handler(nil, true).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", path, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	// the handlers of the tilesets are registered when they are first used
+	get(sets[1], "/services")
+	paths := []string{"/services/geography-class-png"}
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			paths = append(paths, fmt.Sprintf("/services/geography-class-png/tiles/1/%d/%d.png", x, y))
+		}
+	}
+	for _, path := range paths {
+		if get(sets[0], path) != get(sets[1], path) {
+			t.Errorf("%s: expected the same response from both servers", path)
+		}
+	}
+	a, b := caches[0].Stats(), caches[1].Stats()
+	if a.PeerErrors != 0 || b.PeerErrors != 0 || a.PeerLoads+a.LocalLoads != int64(len(paths)) {
+		t.Errorf("unexpected statistics %+v and %+v", a, b)
+	}
+	// each response is read once by its owner and fetched by the other
+	if a.PeerLoads != b.LocalLoads || b.PeerLoads != a.LocalLoads || a.PeerLoads != b.PeerRequests {
+		t.Errorf("expected each response to be read once, got statistics %+v and %+v", a, b)
+	}
+
+	// the peer endpoint must not bypass the credentials of private tilesets
+	sets[0].APIKeys = []APIKey{{Key: "secret-key", Tilesets: []string{"*"}}}
+	get(sets[0], "/services?key=secret-key")
+	key, err := json.Marshal(peerKey{ID: "geography-class-jpg", Kind: "tiles", Stamp: sets[0].dbs()["geography-class-jpg"].TimeStamp().Unix(),
+		Root: "http://localhost", Path: "/services/geography-class-jpg/tiles/0/0/0.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := PeerCacheBasePath + "test-peer-a/" + url.PathEscape(string(key))
+	for _, tc := range []struct {
+		remote, auth, secret string
+		status               int
+	}{
+		{"127.0.0.1:1234", "", "peer-secret", http.StatusForbidden},
+		{"127.0.0.1:1234", "Bearer wrong", "peer-secret", http.StatusForbidden},
+		{"127.0.0.1:1234", "Bearer peer-secret", "peer-secret", http.StatusOK},
+		{"192.0.2.1:1234", "", "", http.StatusForbidden},
+		{"127.0.0.1:1234", "", "", http.StatusOK},
+	} {
+		caches[0].Secret = tc.secret
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = tc.remote
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		caches[0].ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("peer request from %s with %q: expected status %d, got %d", tc.remote, tc.auth, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestWMTS(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	for _, path := range []string{
+		"/services/geography-class-png/wmts/1.0.0/WMTSCapabilities.xml",
+		"/services/geography-class-png/wmts?service=WMTS&request=GetCapabilities",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+		var caps struct {
+			Layer struct {
+				Identifier  string `xml:"Identifier"`
+				ResourceURL struct {
+					Template string `xml:"template,attr"`
+				} `xml:"ResourceURL"`
+			} `xml:"Contents>Layer"`
+			TileMatrix []struct {
+				Identifier string `xml:"Identifier"`
+			} `xml:"Contents>TileMatrixSet>TileMatrix"`
+		}
+		if err := xml.Unmarshal(rec.Body.Bytes(), &caps); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if caps.Layer.Identifier != "geography-class-png" || len(caps.TileMatrix) != 2 {
+			t.Errorf("%s: unexpected capabilities %+v", path, caps)
+		}
+		expected := "http://example.com/services/geography-class-png/wmts/tile/1.0.0/geography-class-png/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png"
+		if caps.Layer.ResourceURL.Template != expected {
+			t.Errorf("%s: expected template %s, got %s", path, expected, caps.Layer.ResourceURL.Template)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/services/geography-class-png/wmts/tile/1.0.0/geography-class-png/default/GoogleMapsCompatible/1/0/1.png", http.StatusOK},
+		{"/services/geography-class-png/wmts?SERVICE=WMTS&REQUEST=GetTile&LAYER=geography-class-png&TILEMATRIXSET=GoogleMapsCompatible&TILEMATRIX=1&TILEROW=0&TILECOL=1", http.StatusOK},
+		{"/services/geography-class-png/wmts/tile/1.0.0/other/default/GoogleMapsCompatible/1/0/1.png", http.StatusNotFound},
+		{"/services/geography-class-png/wmts?SERVICE=WMTS&REQUEST=GetFeatureInfo", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status == http.StatusOK && rec.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("%s: expected PNG tile, got %s", tc.path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestWMS(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		query  string
+		status int
+		width  int
+	}{
+		{"SERVICE=WMS&REQUEST=GetMap&VERSION=1.3.0&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=-20037508,-20037508,20037508,20037508&WIDTH=300&HEIGHT=200&FORMAT=image/png", http.StatusOK, 300},
+		{"service=WMS&request=GetMap&version=1.3.0&layers=geography-class-png&crs=EPSG:4326&bbox=-60,-120,60,120&width=400&height=200&format=image/jpeg", http.StatusOK, 400},
+		{"SERVICE=WMS&REQUEST=GetMap&VERSION=1.1.1&LAYERS=geography-class-png&SRS=EPSG:4326&BBOX=-120,-60,120,60&WIDTH=100&HEIGHT=50&FORMAT=image/png&TRANSPARENT=TRUE", http.StatusOK, 100},
+		{"SERVICE=WMS&REQUEST=GetMap&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=0,0,1,1&WIDTH=100000&HEIGHT=1&FORMAT=image/png", http.StatusBadRequest, 0},
+		{"SERVICE=WMS&REQUEST=GetMap&LAYERS=other&CRS=EPSG:3857&BBOX=0,0,1,1&WIDTH=1&HEIGHT=1&FORMAT=image/png", http.StatusBadRequest, 0},
+		{"SERVICE=WMS&REQUEST=GetCapabilities", http.StatusOK, 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/services/geography-class-png/wms?"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.query, tc.status, rec.Code)
+			continue
+		}
+		if tc.width == 0 {
+			continue
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if img.Bounds().Dx() != tc.width {
+			t.Errorf("%s: expected width %d, got %d", tc.query, tc.width, img.Bounds().Dx())
+		}
+	}
+}
+
+func TestStaticMap(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path          string
+		status        int
+		width, height int
+	}{
+		{"/services/geography-class-png/static/0,0,1/300x200.png", http.StatusOK, 300, 200},
+		{"/services/geography-class-png/static/-120,-60,120,60/150x100.jpg?marker=0,0&marker=10,10,00ff00", http.StatusOK, 150, 100},
+		{"/services/geography-class-png/static/0,0,1/300x200.png?marker=0", http.StatusBadRequest, 0, 0},
+		{"/services/geography-class-png/static/0,0/300x200.png", http.StatusBadRequest, 0, 0},
+		{"/services/geography-class-png/static/0,0,1/10000x200.png", http.StatusBadRequest, 0, 0},
+		{"/services/geography-class-png/static/0,0,1/300x200", http.StatusBadRequest, 0, 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if img.Bounds().Dx() != tc.width || img.Bounds().Dy() != tc.height {
+			t.Errorf("%s: expected size %dx%d, got %v", tc.path, tc.width, tc.height, img.Bounds().Size())
+		}
+	}
+}
+
+func TestOGCAPITiles(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.OGCHandler(nil)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/ogc", http.StatusOK, "application/json"},
+		{"/ogc/conformance", http.StatusOK, "application/json"},
+		{"/ogc/tileMatrixSets", http.StatusOK, "application/json"},
+		{"/ogc/tileMatrixSets/WebMercatorQuad", http.StatusOK, "application/json"},
+		{"/ogc/collections", http.StatusOK, "application/json"},
+		{"/ogc/collections/geography-class-png", http.StatusOK, "application/json"},
+		{"/ogc/collections/geography-class-png/tiles", http.StatusOK, "application/json"},
+		{"/ogc/collections/geography-class-png/tiles/WebMercatorQuad", http.StatusOK, "application/json"},
+		{"/ogc/collections/geography-class-png/tiles/WebMercatorQuad/1/0/1", http.StatusOK, "image/png"},
+		{"/ogc/collections/openstreetmap/open-streets-dc/tiles/WebMercatorQuad", http.StatusOK, "application/json"},
+		{"/ogc/collections/geography-class-png/tiles/WorldCRS84Quad/1/0/1", http.StatusNotFound, ""},
+		{"/ogc/collections/unknown", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); tc.contentType != "" && ct != tc.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tc.path, tc.contentType, ct)
+		}
+	}
+}
+
+// createVectorDB creates the mbtiles file <name>.mbtiles in dir with tile as
+// its only, gzip compressed, tile at 0/0/0 and returns its filename.
+func createVectorDB(t *testing.T, dir, name string, tile *mvt.Tile) string {
+	raw, err := mvt.Encode(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := gzipBytes(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, name+".mbtiles")
+	w, err := mbtiles.CreateDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteMetadata("format", "pbf")
+	w.WriteTile(0, 0, 0, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestVectorFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	point := [][]mvt.Coord{{{X: 1, Y: 1}}}
+	filename := createVectorDB(t, dir, "vector", &mvt.Tile{Layers: []*mvt.Layer{
+		{Name: "roads", Extent: 4096, Features: []*mvt.Feature{
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"class": "motorway", "name": "A1", "lanes": int64(4)}},
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"class": "residential", "name": "Elm", "lanes": int64(1)}},
+		}},
+		{Name: "water", Extent: 4096, Features: []*mvt.Feature{{Type: mvt.Point, Geometry: point}}},
+		{Name: "places", Extent: 4096, Features: []*mvt.Feature{{Type: mvt.Point, Geometry: point}}},
+	}})
+	s := New()
+	if err := s.AddDBOnPath(filename, "vector"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		query    string
+		status   int
+		layers   []string
+		features int
+		fields   int
+	}{
+		{"", http.StatusOK, []string{"roads", "water", "places"}, 4, 3},
+		{"?layers=roads,water", http.StatusOK, []string{"roads", "water"}, 3, 3},
+		{"?layers=unknown", http.StatusOK, nil, 0, 0},
+		{"?layers=", http.StatusBadRequest, nil, 0, 0},
+		{"?filter=class=motorway", http.StatusOK, []string{"roads"}, 1, 3},
+		{"?filter=lanes>=2&fields=name", http.StatusOK, []string{"roads"}, 1, 1},
+		{"?filter=class!=motorway&layers=roads", http.StatusOK, []string{"roads"}, 1, 3},
+		{"?fields=name,class", http.StatusOK, []string{"roads", "water", "places"}, 4, 2},
+		{"?filter=class", http.StatusBadRequest, nil, 0, 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/services/vector/tiles/0/0/0.pbf"+tc.query, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%q: expected status %d, got %d", tc.query, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		raw, err := mbtiles.GZIPENC.Decode(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := mvt.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		var features, fields int
+		for _, l := range tile.Layers {
+			names = append(names, l.Name)
+			features += len(l.Features)
+			for _, f := range l.Features {
+				if len(f.Properties) > fields {
+					fields = len(f.Properties)
+				}
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(tc.layers, ",") {
+			t.Errorf("%q: expected layers %v, got %v", tc.query, tc.layers, names)
+		}
+		if features != tc.features || fields != tc.fields {
+			t.Errorf("%q: expected %d features with up to %d properties, got %d with up to %d", tc.query, tc.features, tc.fields, features, fields)
+		}
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := createVectorDB(t, dir, "vector", &mvt.Tile{Layers: []*mvt.Layer{{
+		Name:   "places",
+		Extent: 4096,
+		Features: []*mvt.Feature{
+			{ID: 7, HasID: true, Type: mvt.Point, Geometry: [][]mvt.Coord{{{X: 2048, Y: 2048}}}, Properties: map[string]interface{}{"name": "center"}},
+			{Type: mvt.Polygon, Geometry: [][]mvt.Coord{
+				{{X: 0, Y: 0}, {X: 4096, Y: 0}, {X: 4096, Y: 4096}, {X: 0, Y: 4096}},
+				{{X: 1024, Y: 1024}, {X: 1024, Y: 3072}, {X: 3072, Y: 3072}, {X: 3072, Y: 1024}},
+			}},
+		},
+	}}})
+	s := New()
+	if err := s.AddDBOnPath(filename, "vector"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	type feature struct {
+		ID       *uint64 `json:"id"`
+		Layer    string  `json:"layer"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	}
+	tests := []struct {
+		query  string
+		point  string
+		corner string
+	}{
+		{"", "[0,0]", "[-180,85.0511288]"},
+		{"?coords=tile", "[2048,2048]", "[0,0]"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/services/vector/tiles/0/0/0.geojson"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: expected status %d, got %d", tc.query, http.StatusOK, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/geo+json" {
+			t.Errorf("%q: expected content type application/geo+json, got %s", tc.query, ct)
+		}
+		var fc struct {
+			Type     string    `json:"type"`
+			Features []feature `json:"features"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		if fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+			t.Fatalf("%q: expected a FeatureCollection with 2 features, got %s with %d", tc.query, fc.Type, len(fc.Features))
+		}
+		point, polygon := fc.Features[0], fc.Features[1]
+		if point.ID == nil || *point.ID != 7 || point.Layer != "places" || point.Geometry.Type != "Point" || string(point.Geometry.Coordinates) != tc.point {
+			t.Errorf("%q: unexpected point %+v with coordinates %s", tc.query, point, point.Geometry.Coordinates)
+		}
+		var rings [][][]float64
+		if err := json.Unmarshal(polygon.Geometry.Coordinates, &rings); err != nil {
+			t.Fatal(err)
+		}
+		if polygon.Geometry.Type != "Polygon" || len(rings) != 2 || len(rings[0]) != 5 {
+			t.Fatalf("%q: expected polygon with a hole, got %s with %d rings", tc.query, polygon.Geometry.Type, len(rings))
+		}
+		if corner, _ := json.Marshal(rings[0][0]); string(corner) != tc.corner {
+			t.Errorf("%q: expected first corner %s, got %s", tc.query, tc.corner, corner)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/services/geography-class-png/tiles/0/0/0.geojson", nil)
+	rec := httptest.NewRecorder()
+	newTestServiceSet(t).Handler(nil, true).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for raster tiles, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestTileInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	point := [][]mvt.Coord{{{X: 1, Y: 1}}}
+	filename := createVectorDB(t, dir, "vector", &mvt.Tile{Layers: []*mvt.Layer{
+		{Name: "roads", Version: 2, Extent: 4096, Features: []*mvt.Feature{
+			{Type: mvt.LineString, Geometry: [][]mvt.Coord{{{X: 0, Y: 0}, {X: 10, Y: 10}}}, Properties: map[string]interface{}{"name": "A1"}},
+			{Type: mvt.Point, Geometry: point, Properties: map[string]interface{}{"class": "exit", "name": "1"}},
+		}},
+		{Name: "water", Version: 2, Extent: 4096, Features: []*mvt.Feature{{Type: mvt.Point, Geometry: point}}},
+	}})
+	s := New()
+	if err := s.AddDBOnPath(filename, "vector"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	req := httptest.NewRequest("GET", "/services/vector/tiles/0/0/0/info", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var info tileInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "pbf" || info.Encoding != "gzip" || info.Size == 0 || info.DecodedSize == 0 {
+		t.Errorf("unexpected tile info %+v", info)
+	}
+	if len(info.Layers) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(info.Layers))
+	}
+	roads := info.Layers[0]
+	if roads.Name != "roads" || roads.Features != 2 || roads.GeometryTypes["Point"] != 1 || roads.GeometryTypes["LineString"] != 1 {
+		t.Errorf("unexpected layer info %+v", roads)
+	}
+	if strings.Join(roads.Keys, ",") != "class,name" {
+		t.Errorf("expected keys class,name, got %v", roads.Keys)
+	}
+	if roads.Size+info.Layers[1].Size != info.DecodedSize {
+		t.Errorf("expected layer sizes to add up to %d, got %d and %d", info.DecodedSize, roads.Size, info.Layers[1].Size)
+	}
+
+	req = httptest.NewRequest("GET", "/services/vector/tiles/1/0/0/info", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing tile, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestPointQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a square around the center of the world, with a hole, a line along the
+	// equator and a point at 0, 0
+	filename := createVectorDB(t, dir, "vector", &mvt.Tile{Layers: []*mvt.Layer{{
+		Name:   "features",
+		Extent: 4096,
+		Features: []*mvt.Feature{
+			{Type: mvt.Polygon, Properties: map[string]interface{}{"name": "square"}, Geometry: [][]mvt.Coord{
+				{{X: 1024, Y: 1024}, {X: 3072, Y: 1024}, {X: 3072, Y: 3072}, {X: 1024, Y: 3072}},
+				{{X: 1536, Y: 1536}, {X: 1536, Y: 2560}, {X: 2560, Y: 2560}, {X: 2560, Y: 1536}},
+			}},
+			{Type: mvt.LineString, Properties: map[string]interface{}{"name": "equator"}, Geometry: [][]mvt.Coord{{{X: 0, Y: 2048}, {X: 4096, Y: 2048}}}},
+			{Type: mvt.Point, Properties: map[string]interface{}{"name": "null island"}, Geometry: [][]mvt.Coord{{{X: 2048, Y: 2048}}}},
+		},
+	}}})
+	s := New()
+	if err := s.AddDBOnPath(filename, "vector"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		query  string
+		status int
+		names  string
+	}{
+		{"lon=0&lat=0", http.StatusOK, "equator,null island"},
+		{"lon=-80&lat=-40", http.StatusOK, "square"},
+		{"lon=-80&lat=-40&filter=name!=square", http.StatusOK, ""},
+		{"lon=-40&lat=20", http.StatusOK, ""},
+		{"lon=170&lat=10", http.StatusOK, ""},
+		{"lon=170&lat=0.5&tolerance=10", http.StatusOK, "equator"},
+		{"lon=0", http.StatusBadRequest, ""},
+		{"lon=200&lat=0", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/services/vector/query?"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.query, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var fc struct {
+			Features []struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"features"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range fc.Features {
+			names = append(names, f.Properties["name"].(string))
+		}
+		if strings.Join(names, ",") != tc.names {
+			t.Errorf("%s: expected features %q, got %q", tc.query, tc.names, strings.Join(names, ","))
+		}
+	}
+}
+
+// createDEMDB creates the terrain-RGB mbtiles file <name>.mbtiles in dir with
+// a single PNG tile at 0/0/0, whose elevation in meters is that of the
+// function elevation of the pixel, encoded as given by the metadata item
+// "encoding".
+func createDEMDB(t *testing.T, dir, name, encoding string, elevation func(x, y int) float64) string {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			var v uint32
+			if encoding == "terrarium" {
+				v = uint32((elevation(x, y) + 32768) * 256)
+			} else {
+				v = uint32((elevation(x, y) + 10000) * 10)
+			}
+			img.Set(x, y, color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff})
+		}
+	}
+	data, err := encodeTile(img, mbtiles.PNG, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, name+".mbtiles")
+	w, err := mbtiles.CreateDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteMetadata("format", "png")
+	w.WriteMetadata("encoding", encoding)
+	w.WriteTile(0, 0, 0, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestElevation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New()
+	for _, encoding := range []string{"mapbox", "terrarium"} {
+		filename := createDEMDB(t, dir, encoding, encoding, func(x, y int) float64 { return float64(x) })
+		if err := s.AddDBOnPath(filename, encoding); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path      string
+		status    int
+		elevation float64
+	}{
+		// the center of the tile lies between the pixel columns 127 and 128
+		{"/services/mapbox/elevation?lon=0&lat=0", http.StatusOK, 127.5},
+		{"/services/terrarium/elevation?lon=0&lat=0", http.StatusOK, 127.5},
+		{"/services/mapbox/elevation?lon=-180&lat=0", http.StatusOK, 0},
+		{"/services/terrarium/elevation?lon=90&lat=10", http.StatusOK, 191.5},
+		{"/services/mapbox/elevation?lon=0", http.StatusBadRequest, 0},
+		{"/services/mapbox/elevation?lon=0&lat=0&encoding=unknown", http.StatusBadRequest, 0},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var result struct {
+			Elevation float64 `json:"elevation"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Elevation != tc.elevation {
+			t.Errorf("%s: expected elevation %v, got %v", tc.path, tc.elevation, result.Elevation)
+		}
+	}
+}
+
+func TestHillshade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a ridge in the middle of the tile running from north to south, which
+	// needs to be exaggerated to cast shadows on tiles of zoom level 0
+	filename := createDEMDB(t, dir, "terrain", "terrarium", func(x, y int) float64 {
+		return 30000 - 200*math.Abs(float64(x)-127.5)
+	})
+	s := New()
+	if err := s.AddDBOnPath(filename, "terrain"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		query  string
+		status int
+		// whether the western slope is lit more than the eastern one
+		west bool
+	}{
+		{"?exaggeration=500", http.StatusOK, true},
+		{"?azimuth=90&altitude=30&exaggeration=500", http.StatusOK, false},
+		{"?azimuth=400", http.StatusBadRequest, false},
+		{"?exaggeration=0", http.StatusBadRequest, false},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 2; i++ {
+			// the second request is answered from the cache
+			req := httptest.NewRequest("GET", "/services/terrain/hillshade/0/0/0.png"+tc.query, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Errorf("%q: expected status %d, got %d", tc.query, tc.status, rec.Code)
+				break
+			}
+			if tc.status != http.StatusOK {
+				break
+			}
+			img, _, err := image.Decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			west, _, _, _ := img.At(64, 128).RGBA()
+			east, _, _, _ := img.At(192, 128).RGBA()
+			if (west > east) != tc.west {
+				t.Errorf("%q: unexpected shades %d in the west and %d in the east", tc.query, west, east)
+			}
+		}
+	}
+}
+
+func TestContours(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a cone with its summit of 2000 m in the middle of the tile
+	filename := createDEMDB(t, dir, "terrain", "terrarium", func(x, y int) float64 {
+		return 2000 - 10*math.Hypot(float64(x)-127.5, float64(y)-127.5)
+	})
+	s := New()
+	if err := s.AddDBOnPath(filename, "terrain"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		query  string
+		status int
+		levels []float64
+	}{
+		{"?interval=500", http.StatusOK, []float64{500, 1000, 1500}},
+		{"?interval=600", http.StatusOK, []float64{600, 1200, 1800}},
+		{"?interval=0", http.StatusBadRequest, nil},
+		{"?interval=0.1", http.StatusBadRequest, nil},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/services/terrain/contours/0/0/0.pbf"+tc.query, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%q: expected status %d, got %d", tc.query, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("%q: expected gzip encoding, got %q", tc.query, ce)
+		}
+		raw, err := mbtiles.GZIPENC.Decode(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := mvt.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := tile.Layer("contours")
+		if l == nil || len(l.Features) != len(tc.levels) {
+			t.Fatalf("%q: unexpected contour layer %+v", tc.query, l)
+		}
+		for i, f := range l.Features {
+			if f.Properties["elevation"] != tc.levels[i] {
+				t.Errorf("%q: expected elevation %v, got %v", tc.query, tc.levels[i], f.Properties["elevation"])
+			}
+			// the lines within the tile are closed circles around the summit
+			radius := (2000 - tc.levels[i]) / 10 * 16
+			if radius > 2000 {
+				continue
+			}
+			if len(f.Geometry) != 1 || f.Geometry[0][0] != f.Geometry[0][len(f.Geometry[0])-1] {
+				t.Errorf("%q: expected a closed line for elevation %v, got %v", tc.query, tc.levels[i], f.Geometry)
+				continue
+			}
+			for _, c := range f.Geometry[0] {
+				if r := math.Hypot(float64(c.X)-2048, float64(c.Y)-2048); math.Abs(r-radius) > 16 {
+					t.Errorf("%q: position %v of elevation %v is off the circle of radius %v", tc.query, c, tc.levels[i], radius)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestComposite(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.CompositeHandler(nil)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/composite/geography-class-png,geography-class-jpg/1/0/0.png?opacity=1,0.5", http.StatusOK, "image/png"},
+		{"/composite/geography-class-jpg,geography-class-png/1/0/0", http.StatusOK, "image/jpeg"},
+		{"/composite/geography-class-png/1/0/0.png", http.StatusOK, "image/png"},
+		{"/composite/geography-class-png,geography-class-jpg/1/0/0.png?opacity=1", http.StatusBadRequest, ""},
+		{"/composite/geography-class-png,openstreetmap/open-streets-dc/1/0/0.png", http.StatusBadRequest, ""},
+		{"/composite/geography-class-png,unknown/1/0/0.png", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); tc.contentType != "" && ct != tc.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tc.path, tc.contentType, ct)
+		}
+		if tc.status == http.StatusOK {
+			if _, _, err := image.Decode(rec.Body); err != nil {
+				t.Errorf("%s: %v", tc.path, err)
+			}
+		}
+	}
+}
+
+func TestCompositeVector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New()
+	for _, name := range []string{"water", "roads"} {
+		filename := createVectorDB(t, dir, name, &mvt.Tile{Layers: []*mvt.Layer{{
+			Name:     name,
+			Extent:   4096,
+			Features: []*mvt.Feature{{Type: mvt.Point, Geometry: [][]mvt.Coord{{{X: 1, Y: 1}}}}},
+		}}})
+		if err := s.AddDBOnPath(filename, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.CompositeHandler(nil)
+
+	req := httptest.NewRequest("GET", "/composite/water,roads/0/0/0.pbf", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	tile, err := mvt.Decode(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tile.Layers) != 2 || tile.Layer("water") == nil || tile.Layer("roads") == nil {
+		t.Errorf("expected layers water and roads, got %d layers", len(tile.Layers))
+	}
+
+	req = httptest.NewRequest("GET", "/composite/water,roads/1/0/0.pbf", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d for missing tiles, got %d", http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestStyles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"basic.json":           `{"version": 8, "name": "Basic", "glyphs": "mbtiles://fonts/{fontstack}/{range}.pbf", "sprite": "mbtiles://sprites/city", "sources": {"world": {"type": "raster", "url": "mbtiles://{geography-class-png}"}}, "layers": []}`,
+		"local/style.json":     `{"version": 8, "sources": {"points": {"type": "geojson", "data": "points.geojson"}, "remote": {"type": "geojson", "data": "https://example.org/points.geojson"}}, "layers": []}`,
+		"local/points.geojson": `{"type": "FeatureCollection", "features": []}`,
+		"notes.txt":            "not a style",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New()
+	s.StylesDir = dir
+	h := s.StylesHandler(nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var styles []styleInfo
+	if err := json.Unmarshal(get("/styles").Body.Bytes(), &styles); err != nil {
+		t.Fatal(err)
+	}
+	expected := []styleInfo{
+		{ID: "basic", Name: "Basic", URL: "http://example.com/styles/basic/style.json"},
+		{ID: "local", URL: "http://example.com/styles/local/style.json"},
+	}
+	if !reflect.DeepEqual(styles, expected) {
+		t.Errorf("expected styles %+v, got %+v", expected, styles)
+	}
+
+	tests := []struct {
+		path    string
+		source  string
+		key     string
+		rewrite string
+	}{
+		{"/styles/basic/style.json", "world", "url", "http://example.com/services/geography-class-png"},
+		{"/styles/basic/style.json?key=secret", "world", "url", "http://example.com/services/geography-class-png?key=secret"},
+		{"/styles/local/style.json", "points", "data", "http://example.com/styles/local/points.geojson"},
+		{"/styles/local/style.json", "remote", "data", "https://example.org/points.geojson"},
+	}
+	for _, tc := range tests {
+		rec := get(tc.path)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		var style struct {
+			Glyphs  string
+			Sprite  string
+			Sources map[string]map[string]interface{}
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &style); err != nil {
+			t.Fatal(err)
+		}
+		if tc.source == "world" && (style.Glyphs != "http://example.com/fonts/{fontstack}/{range}.pbf" || style.Sprite != "http://example.com/sprites/city") {
+			t.Errorf("%s: unexpected glyphs %q or sprite %q", tc.path, style.Glyphs, style.Sprite)
+		}
+		if v := style.Sources[tc.source][tc.key]; v != tc.rewrite {
+			t.Errorf("%s: expected %s of source %s to be %q, got %q", tc.path, tc.key, tc.source, tc.rewrite, v)
+		}
+	}
+
+	if rec := get("/styles/local/points.geojson"); rec.Code != http.StatusOK || rec.Body.String() != files["local/points.geojson"] {
+		t.Errorf("unexpected local file with status %d: %q", rec.Code, rec.Body.String())
+	}
+	for _, path := range []string{"/styles/missing/style.json", "/styles/basic/points.geojson", "/styles/local/../basic.json", "/styles/notes"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusNotFound, rec.Code)
+		}
+	}
+}
+
+func TestFonts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// glyphs encodes a glyph PBF of the font with a glyph for each code point
+	// whose advance is the given one
+	glyphs := func(font string, advance uint8, ids ...uint8) []byte {
+		stack := &pbf.Writer{}
+		stack.Bytes(1, []byte(font))
+		stack.Bytes(2, []byte("0-255"))
+		for _, id := range ids {
+			stack.Bytes(3, []byte{1<<3 | 0, id, 7<<3 | 0, advance})
+		}
+		w := &pbf.Writer{}
+		w.Bytes(1, stack.Data())
+		return w.Data()
+	}
+	fonts := map[string][]byte{
+		"Sans Regular": glyphs("Sans Regular", 1, 65, 66),
+		"Unicode":      glyphs("Unicode", 2, 66, 67),
+	}
+	for name, data := range fonts {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "0-255.pbf"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New()
+	s.FontsDir = dir
+	h := s.FontsHandler(nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var names []string
+	if err := json.Unmarshal(get("/fonts").Body.Bytes(), &names); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"Sans Regular", "Unicode"}) {
+		t.Errorf("unexpected fonts %v", names)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		// advances of the glyphs by code point
+		advances map[uint64]byte
+	}{
+		{"/fonts/Sans%20Regular/0-255.pbf", http.StatusOK, map[uint64]byte{65: 1, 66: 1}},
+		{"/fonts/Sans%20Regular,Unicode/0-255.pbf", http.StatusOK, map[uint64]byte{65: 1, 66: 1, 67: 2}},
+		{"/fonts/Unicode,Sans%20Regular/0-255.pbf", http.StatusOK, map[uint64]byte{65: 1, 66: 2, 67: 2}},
+		{"/fonts/Missing,Unicode/0-255.pbf", http.StatusOK, map[uint64]byte{66: 2, 67: 2}},
+		{"/fonts/Unicode/256-511.pbf", http.StatusOK, map[uint64]byte{}},
+		{"/fonts/Missing/0-255.pbf", http.StatusNotFound, nil},
+		{"/fonts/Unicode/0-100.pbf", http.StatusBadRequest, nil},
+		{"/fonts/../0-255.pbf", http.StatusBadRequest, nil},
+	}
+	for _, tc := range tests {
+		rec := get(tc.path)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		composed, err := readGlyphs(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		advances := make(map[uint64]byte)
+		for id, g := range composed {
+			advances[id] = g[len(g)-1]
+		}
+		if !reflect.DeepEqual(advances, tc.advances) {
+			t.Errorf("%s: expected glyphs %v, got %v", tc.path, tc.advances, advances)
+		}
+	}
+}
+
+func TestSprites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"city.json":    `{"park": {"x": 0, "y": 0, "width": 16, "height": 16, "pixelRatio": 1}}`,
+		"city.png":     string(BlankPNG()),
+		"city@2x.json": `{"park": {"x": 0, "y": 0, "width": 32, "height": 32, "pixelRatio": 2}}`,
+		"lonely.json":  `{}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New()
+	s.SpritesDir = dir
+	h := s.SpritesHandler(nil)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/sprites", http.StatusOK, "application/json", `["city"]`},
+		{"/sprites/city.json", http.StatusOK, "application/json", files["city.json"]},
+		{"/sprites/city@2x.json", http.StatusOK, "application/json", files["city@2x.json"]},
+		{"/sprites/city.png", http.StatusOK, "image/png", files["city.png"]},
+		{"/sprites/city@2x.png", http.StatusNotFound, "", ""},
+		{"/sprites/city.txt", http.StatusNotFound, "", ""},
+		{"/sprites/../city.json", http.StatusNotFound, "", ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("%s: expected content type %s, got %s", tc.path, tc.contentType, ct)
+		}
+		if rec.Body.String() != tc.body {
+			t.Errorf("%s: unexpected body %q", tc.path, rec.Body.String())
+		}
+	}
+}
+
+func TestArcGIS(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.ArcGISHandler(nil)
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/arcgis/rest/services", "application/json"},
+		{"/arcgis/rest/services?callback=cb", "application/javascript"},
+		{"/arcgis/rest/services/geography-class-png/MapServer", "application/json"},
+		{"/arcgis/rest/services/geography-class-png/MapServer/layers", "application/json"},
+		{"/arcgis/rest/services/geography-class-png/MapServer/legend", "application/json"},
+		{"/arcgis/rest/services/geography-class-png/MapServer/tile/1/0/1", "image/png"},
+		{"/arcgis/rest/services/geography-class-png/MapServer/tile/1/1/1", "image/png"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tc.path, tc.contentType, ct)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.HealthHandler(nil)
+
+	for _, path := range []string{"/health", "/ready"} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+	}
+
+	// a tileset whose file has disappeared is not ready
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "gone.mbtiles")
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDir, "geography-class-png.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDBOnPath(filename, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filename)
+	req := httptest.NewRequest("GET", "/ready", nil)
+	rec := httptest.NewRecorder()
+	s.HealthHandler(nil).ServeHTTP(rec, req)
+	var report struct {
+		Status   string
+		Tilesets map[string]string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Tilesets["gone"] == "ok" || report.Tilesets["geography-class-png"] != "ok" {
+		t.Errorf("unexpected readiness report with status %d: %+v", rec.Code, report)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	for _, path := range []string{
+		"/services/geography-class-png/tiles/1/0/0.png",
+		"/services/geography-class-png/tiles/1/0/0.png",
+		"/services/geography-class-png/tiles/2/0/0.png",
+		"/services/geography-class-png/tiles/2/0/0.unknown-format",
+		"/services/geography-class-png/tiles/1/0/0.json",
+		"/services/geography-class-png/tiles/9/0/0.json",
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`mbtileserver_tile_requests_total{tileset="geography-class-png",zoom="1",format="png",status="200"} 2`,
+		`mbtileserver_tile_requests_total{tileset="geography-class-png",zoom="2",format="png",status="200"} 2`,
+		`mbtileserver_tile_requests_total{tileset="geography-class-png",zoom="1",format="json",status="200"} 1`,
+		`mbtileserver_tile_requests_total{tileset="geography-class-png",zoom="9",format="json",status="404"} 1`,
+		`mbtileserver_sqlite_query_duration_seconds_count{tileset="geography-class-png"} 6`,
+		`mbtileserver_open_tilesets 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %s, got\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "unknown-format") {
+		t.Errorf("expected formats of the tileset only, got\n%s", body)
+	}
+
+	if v := label("a\\b\"c\nd"); v != `"a\\b\"c\nd"` {
+		t.Errorf("expected escaped label value, got %s", v)
+	}
+}
+
+type testSpan struct {
+	name, parent string
+	attrs        map[string]interface{}
+	ended        bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) End()                                       { s.ended = true }
+
+type testSpanKey struct{}
+
+// testTracer records all spans, together with the name of their parent span.
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartRequest(r *http.Request, name string) (context.Context, Span) {
+	return t.Start(r.Context(), name)
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]interface{})}
+	if p, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		s.parent = p.name
+	}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func TestTracer(t *testing.T) {
+	s := newTestServiceSet(t)
+	tracer := &testTracer{}
+	s.Tracer = tracer
+	h := s.Handler(nil, true)
+
+	const path = "/services/geography-class-png/tiles/1/0/0.jpg"
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	if len(tracer.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(tracer.spans))
+	}
+	for i, name := range []string{"GET " + path, "mbtiles.Read", "convert"} {
+		span := tracer.spans[i]
+		if span.name != name || !span.ended {
+			t.Errorf("expected ended span %q, got %+v", name, span)
+		}
+		if i > 0 && span.parent != tracer.spans[0].name {
+			t.Errorf("expected span %q to be a child of the request span, got parent %q", name, span.parent)
+		}
+	}
+	if status := tracer.spans[0].attrs["http.status_code"]; status != http.StatusOK {
+		t.Errorf("expected status code attribute %d, got %v", http.StatusOK, status)
+	}
+}
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := &statusWriter{ResponseWriter: rec}
+	var w http.ResponseWriter = sw
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected statusWriter to implement http.Flusher")
+	}
+	f.Flush()
+	if !rec.Flushed {
+		t.Error("expected the response to be flushed")
+	}
+	if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != rec {
+		t.Error("expected statusWriter to unwrap to the ResponseRecorder for http.ResponseController")
+	}
+	if _, _, err := w.(http.Hijacker).Hijack(); err != http.ErrNotSupported {
+		t.Errorf("expected %v for a ResponseWriter that cannot be hijacked, got %v", http.ErrNotSupported, err)
+	}
+	if _, err := io.Copy(w, strings.NewReader("tile")); err != nil {
+		t.Fatal(err)
+	}
+	if sw.n != 4 || rec.Body.String() != "tile" {
+		t.Errorf("expected 4 bytes written, got %d, %q", sw.n, rec.Body)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	s := newTestServiceSet(t)
+	var buf bytes.Buffer
+	s.AccessLog = slog.New(slog.NewJSONHandler(&buf, nil))
+	s.TrustedProxies, _ = ParseTrustedProxies([]string{"192.0.2.1", "10.0.0.0/8"})
+	h := s.Handler(nil, true)
+
+	// entry decodes the next entry of the access log
+	dec := json.NewDecoder(&buf)
+	entry := func() map[string]interface{} {
+		t.Helper()
+		var e map[string]interface{}
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("expected access log entry: %v", err)
+		}
+		return e
+	}
+
+	req := httptest.NewRequest("GET", "/services/geography-class-png/tiles/1/0/1.png", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("X-Forwarded-For", "192.0.2.6, 192.0.2.7, 10.0.0.1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	e := entry()
+	if e["msg"] != "request" || e["level"] != "INFO" || e["method"] != "GET" || e["path"] != "/services/geography-class-png/tiles/1/0/1.png" {
+		t.Errorf("unexpected message, level, method or path in %v", e)
+	}
+	if e["request_id"] != "abc" || rec.Header().Get("X-Request-ID") != "abc" {
+		t.Errorf("expected request ID %q in entry and response, got %v and %q", "abc", e["request_id"], rec.Header().Get("X-Request-ID"))
+	}
+	if e["tileset"] != "geography-class-png" || e["z"] != "1" || e["x"] != "0" || e["y"] != "1" {
+		t.Errorf("unexpected tileset and tile coordinates in %v", e)
+	}
+	if e["status"] != float64(http.StatusOK) || e["bytes"] != float64(rec.Body.Len()) || e["client_ip"] != "192.0.2.7" {
+		t.Errorf("unexpected status, bytes or client IP in %v", e)
+	}
+	if _, ok := e["duration"].(float64); !ok {
+		t.Errorf("expected duration in %v", e)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/services", nil))
+	e = entry()
+	if _, ok := e["tileset"]; e["request_id"] == "" || ok || e["z"] != nil {
+		t.Errorf("expected generated request ID and no tile in %v", e)
+	}
+
+	// the header is ignored for requests from other addresses
+	req = httptest.NewRequest("GET", "/services", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.7")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if e = entry(); e["client_ip"] != "198.51.100.1" {
+		t.Errorf("expected client IP 198.51.100.1, got %v", e["client_ip"])
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.APIKeys = []APIKey{
+		{Key: "png", Tilesets: []string{"*-png"}},
+		{Key: "expired", Tilesets: []string{"*"}, Expires: time.Now().Add(-time.Hour)},
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path, auth string
+		status     int
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.png", "", http.StatusUnauthorized},
+		{"/services/geography-class-png/tiles/1/0/0.png?key=png", "", http.StatusOK},
+		{"/services/geography-class-png/tiles/1/0/0.png", "Bearer png", http.StatusOK},
+		{"/services/geography-class-png/tiles/1/0/0.png?key=wrong", "", http.StatusUnauthorized},
+		{"/services/geography-class-jpg/tiles/1/0/0.jpg?key=png", "", http.StatusForbidden},
+		{"/services/geography-class-jpg?key=expired", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+		}
+	}
+
+	// the services only list the tilesets of the key, whose URLs carry it
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services?key=png", nil))
+	var services []ServiceInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(services))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png?key=png", nil))
+	var tileJSON struct {
+		Tiles []string `json:"tiles"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tileJSON); err != nil {
+		t.Fatal(err)
+	}
+	if len(tileJSON.Tiles) != 1 || !strings.HasSuffix(tileJSON.Tiles[0], "?key=png") {
+		t.Errorf("expected tile URL with key, got %v", tileJSON.Tiles)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	s := newTestServiceSet(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	h := s.Handler(nil, true)
+
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	const path = "/services/geography-class-png/tiles/1/0/0.png"
+	tests := []struct {
+		key    interface{}
+		token  string
+		status int
+	}{
+		{secret, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"services": "*-png", "exp": exp}), http.StatusOK},
+		{secret, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"services": []string{"*-jpg"}, "exp": exp}), http.StatusForbidden},
+		{secret, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"services": "*", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized},
+		{secret, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"services": "*"}), http.StatusUnauthorized},
+		{secret, sign(jwt.SigningMethodHS256, []byte("other"), jwt.MapClaims{"services": "*", "exp": exp}), http.StatusUnauthorized},
+		{&ecKey.PublicKey, sign(jwt.SigningMethodES256, ecKey, jwt.MapClaims{"services": []string{"*"}, "exp": exp}), http.StatusOK},
+		{secret, sign(jwt.SigningMethodES256, ecKey, jwt.MapClaims{"services": "*", "exp": exp}), http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		s.JWT = &JWTAuth{Key: tt.key}
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("token %d: expected status %d, got %d", i, tt.status, rec.Code)
+		}
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.URLSigningKey = []byte("secret")
+	h := s.Handler(nil, true)
+
+	const path = "/services/geography-class-png/tiles/1/0/0.png"
+	valid, err := SignURL(s.URLSigningKey, path, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := SignURL(s.URLSigningKey, path, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := SignURL([]byte("other"), path, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{valid, http.StatusOK},
+		{path, http.StatusUnauthorized},
+		{expired, http.StatusUnauthorized},
+		{other, http.StatusUnauthorized},
+		{strings.Replace(valid, "/0.png", "/1.png", 1), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, rec.Code)
+		}
+	}
+}
+
+func TestACLs(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.ACLs = []ACL{
+		{Tilesets: []string{"*-png"}, Keys: []string{"k"}},
+		{Tilesets: []string{"*-jpg"}, Origins: []string{"https://*.example.com"}, CIDRs: []string{"192.0.2.0/24"}},
+	}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		path, referer, remote string
+		status                int
+	}{
+		{"/services/geography-class-png", "", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"/services/geography-class-png?key=other", "", "192.0.2.1:1234", http.StatusForbidden},
+		{"/services/geography-class-png?key=k", "", "192.0.2.1:1234", http.StatusOK},
+		{"/services/geography-class-jpg", "https://maps.example.com/page", "192.0.2.1:1234", http.StatusOK},
+		{"/services/geography-class-jpg", "https://example.org/", "192.0.2.1:1234", http.StatusForbidden},
+		{"/services/geography-class-jpg", "https://maps.example.com/page", "198.51.100.1:1234", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remote
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s from %s via %q: expected status %d, got %d", tt.path, tt.remote, tt.referer, tt.status, rec.Code)
+		}
+	}
+
+	// behind a proxy that appends to the X-Forwarded-For header, the
+	// addresses before the last one may have been made up by the client
+	s.TrustedProxies, _ = ParseTrustedProxies([]string{"*"})
+	for forwardedFor, status := range map[string]int{
+		"192.0.2.7":               http.StatusOK,
+		"192.0.2.7, 198.51.100.1": http.StatusForbidden,
+		"198.51.100.1, 192.0.2.7": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/services/geography-class-jpg", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Referer", "https://maps.example.com/page")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("X-Forwarded-For %s: expected status %d, got %d", forwardedFor, status, rec.Code)
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.APIKeys = []APIKey{{Key: "k", Tilesets: []string{"*"}}}
+	s.MissingTile, _ = ParseMissingTile("404")
+	h := s.Handler(nil, true)
+	one := 1
+	s.SetPolicies(map[string]*TilesetPolicy{
+		"geography-class-png":           {Name: "Geography", Attribution: "Example", CacheTTL: time.Hour, MinZoom: &one},
+		"geography-class-jpg":           {Public: true},
+		"openstreetmap/open-streets-dc": {Keys: []string{"other"}},
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/services/geography-class-png/tiles/1/0/0.png?key=k", http.StatusOK},
+		{"/services/geography-class-png/tiles/0/0/0.png?key=k", http.StatusNotFound},
+		{"/services/geography-class-png/tiles/0/0/0/info?key=k", http.StatusNotFound},
+		{"/services/geography-class-png/bundle?maxzoom=0&key=k", http.StatusBadRequest},
+		{"/services/geography-class-png/tiles/1/0/0.png", http.StatusUnauthorized},
+		{"/services/geography-class-jpg/tiles/1/0/0.jpg", http.StatusOK},
+		{"/services/openstreetmap/open-streets-dc?key=k", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+		}
+	}
+
+	// the zoom levels also apply to batches and bundles
+	req := httptest.NewRequest("POST", "/services/geography-class-png/tiles/batch?key=k", strings.NewReader(`{"tiles": ["0/0/0", "1/0/0"]}`))
+	req.Header.Set("Accept", "application/octet-stream")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if data := rec.Body.Bytes(); len(data) < 13 || data[0] != 1 || len(data) != 13+int(binary.BigEndian.Uint32(data[9:])) {
+		t.Errorf("expected the tile at zoom level 1 only, got %d bytes", len(data))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png/bundle?bbox=-170,10,-10,80&format=zip&key=k", nil))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "1/0/0.png" {
+		t.Errorf("expected the tile at zoom level 1 only in bundle, got %d tiles", len(zr.File))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png?key=k", nil))
+	var out map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["name"] != "Geography" || out["attribution"] != "Example" || out["minzoom"] != 1.0 {
+		t.Errorf("expected TileJSON with name, attribution and minzoom of the policy, got %v", out)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("expected Cache-Control of the policy, got %q", cc)
+	}
+
+	// the policies are no longer applied once they are replaced
+	s.SetPolicies(nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png/tiles/0/0/0.png?key=k", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected tile without policy, got %d with Cache-Control %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	s := newTestServiceSet(t)
+	var err error
+	if s.TrustedProxies, err = ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies([]string{"proxy"}); err == nil {
+		t.Error("expected error for invalid trusted proxy")
+	}
+	s.ACLs = []ACL{{Tilesets: []string{"geography-class-jpg"}, CIDRs: []string{"198.51.100.0/24"}}}
+	h := s.Handler(nil, true)
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		tiles   string
+	}{
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com", "X-Forwarded-Prefix": "/tiles/"}, "https://example.com/tiles/services/geography-class-png/tiles/{z}/{x}/{y}.png"},
+		{"192.0.2.1:1234", map[string]string{"Forwarded": `for=198.51.100.1;proto=https;host="example.org"`}, "https://example.org/services/geography-class-png/tiles/{z}/{x}/{y}.png"},
+		{"203.0.113.1:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com"}, "http://localhost/services/geography-class-png/tiles/{z}/{x}/{y}.png"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "evil.com/x"}, "http://localhost/services/geography-class-png/tiles/{z}/{x}/{y}.png"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "http://localhost/services/geography-class-png", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var out struct {
+			Tiles []string `json:"tiles"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Tiles) != 1 || out.Tiles[0] != tc.tiles {
+			t.Errorf("%s with %v: expected tile URL %s, got %v", tc.remote, tc.headers, tc.tiles, out.Tiles)
+		}
+	}
+
+	// the client IP address for ACLs is the last one that is not a proxy
+	for forwardedFor, status := range map[string]int{
+		"198.51.100.1":                        http.StatusOK,
+		"198.51.100.1, 10.0.0.2":              http.StatusOK,
+		"198.51.100.1, 203.0.113.1":           http.StatusForbidden,
+		"203.0.113.1, 198.51.100.1, 10.0.0.2": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/services/geography-class-jpg", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("X-Forwarded-For %s: expected status %d, got %d", forwardedFor, status, rec.Code)
+		}
+	}
+
+	// with all sources trusted, only the last address has been appended by a
+	// proxy, also for clients without an IP address
+	s.TrustedProxies, _ = ParseTrustedProxies([]string{"*", "10.0.0.0/8"})
+	for _, tc := range []struct {
+		remote, forwardedFor string
+		status               int
+	}{
+		{"203.0.113.1:1234", "198.51.100.1", http.StatusOK},
+		{"203.0.113.1:1234", "198.51.100.1, 203.0.113.1", http.StatusForbidden},
+		{"203.0.113.1:1234", "198.51.100.1, 10.0.0.2", http.StatusOK},
+		{"@", "198.51.100.1", http.StatusOK},
+		{"@", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/services/geography-class-jpg", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s with X-Forwarded-For %s: expected status %d, got %d", tc.remote, tc.forwardedFor, tc.status, rec.Code)
+		}
+	}
+
+	s.BaseURL = "https://maps.example.com/base"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png/map", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "https://maps.example.com/base/static/core.min.js") {
+		t.Errorf("expected preview with static assets below BaseURL, got %s", body)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	s := newTestServiceSet(t)
+	s.RateLimiter = NewRateLimiter(1, 2)
+	h := s.Handler(nil, true)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	const path = "/services/geography-class-png/tiles/1/0/0.png"
+	for i := 0; i < 2; i++ {
+		if rec := get(path); rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for request %d, got %d", http.StatusOK, i, rec.Code)
+		}
+	}
+	rec := get(path)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After 1, got %q", rec.Header().Get("Retry-After"))
+	}
+	// made up keys do not get their own bucket
+	if rec := get(path + "?key=k"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d with unknown key, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	// clients without IP address, like those of unix domain sockets, do not
+	// share a bucket
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "@"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d without IP address, got %d", http.StatusOK, rec.Code)
+		}
+	}
+	// requests with a valid API key have their own bucket
+	s.APIKeys = []APIKey{{Key: "k", Tilesets: []string{"*"}}}
+	if rec := get(path + "?key=k"); rec.Code != http.StatusOK {
+		t.Errorf("expected status %d with key, got %d", http.StatusOK, rec.Code)
+	}
+
+	s.RateLimiter.allow("client", time.Unix(0, 0))
+	s.RateLimiter.allow("client", time.Unix(0, 0))
+	ok, retry := s.RateLimiter.allow("client", time.Unix(0, 0))
+	if ok || retry != time.Second {
+		t.Errorf("expected to wait 1s, got %v, %v", ok, retry)
+	}
+	if ok, _ = s.RateLimiter.allow("client", time.Unix(1, 0)); !ok {
+		t.Error("expected a new token after 1s")
+	}
+
+	// the number of buckets is limited
+	l := NewRateLimiter(1, 1)
+	for i := 0; i < maxRateLimitClients+10; i++ {
+		l.allow(fmt.Sprint(i), time.Unix(0, 0))
+	}
+	if n := len(l.buckets); n > maxRateLimitClients {
+		t.Errorf("expected at most %d buckets, got %d", maxRateLimitClients, n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := newTestServiceSet(t)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err := s.tilesets["geography-class-png"].ReadTileContext(context.Background(), 0, 0, 0, &data); err == nil {
+		t.Error("expected error reading from closed tileset")
+	}
+}
+
+func TestAddRemoveDB(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+	if status := get("/services/geography-class-png"); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if err := s.RemoveDB("geography-class-png"); err != nil {
+		t.Fatal(err)
+	}
+	if status := get("/services/geography-class-png"); status != http.StatusNotFound {
+		t.Errorf("expected status %d after removing the tileset, got %d", http.StatusNotFound, status)
+	}
+	if err := s.RemoveDB("geography-class-png"); err == nil {
+		t.Error("expected error removing a missing tileset")
+	}
+	if err := s.AddDBOnPath(filepath.Join(testBaseDir, "geography-class-png.mbtiles"), "added"); err != nil {
+		t.Fatal(err)
+	}
+	if status := get("/services/added/tiles/0/0/0.png"); status != http.StatusOK {
+		t.Errorf("expected status %d for added tileset, got %d", http.StatusOK, status)
+	}
+}
+
+func TestReplaceDB(t *testing.T) {
+	s := newTestServiceSet(t)
+	h := s.Handler(nil, true)
+	old := s.dbs()["geography-class-png"]
+
+	// a request in progress keeps using the replaced DB
+	_, gen := s.acquire()
+	err := s.AddDBOnPath(filepath.Join(testBaseDir, "geography-class-jpg.mbtiles"), "geography-class-png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err := old.ReadTileContext(context.Background(), 0, 0, 0, &data); err != nil {
+		t.Fatalf("expected replaced DB to be open while in use: %v", err)
+	}
+	s.release(gen)
+	if err := old.ReadTileContext(context.Background(), 0, 0, 0, &data); err == nil {
+		t.Error("expected replaced DB to be closed after use")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png", nil))
+	var tileJSON struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tileJSON); err != nil {
+		t.Fatal(err)
+	}
+	if tileJSON.Format != "jpg" {
+		t.Errorf("expected format of replacement jpg, got %q", tileJSON.Format)
+	}
+}
+
+func TestIDStrategies(t *testing.T) {
+	dir := filepath.FromSlash("/data/tiles")
+	filename := filepath.Join(dir, "Europe", "osm.2024.mbtiles")
+	for name, expected := range map[string]string{
+		"path":     "europe/osm.2024",
+		"basename": "osm.2024",
+	} {
+		id, err := IDStrategies[name](dir, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expected {
+			t.Errorf("%s: expected ID %q, got %q", name, expected, id)
+		}
+	}
+
+	id, err := IDFromHash(dir, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 16 || strings.Contains(id, "osm") {
+		t.Errorf("expected 16 hex digits, got %q", id)
+	}
+
+	id, err = IDFromName(testBaseDir, filepath.Join(testBaseDir, "geography-class-png.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "geography-class" {
+		t.Errorf("expected ID from metadata name, got %q", id)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDir, "geography-class-png.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServiceSet(t)
+	s.AdminKey = "secret"
+	s.UploadDir = dir
+	s.MaxUploadSize = int64(len(data))
+	var uploaded string
+	s.Uploaded = func(id, filename string) { uploaded = id }
+	h := s.AdminHandler(nil)
+	post := func(path, key string, body []byte) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(string(body)))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		path   string
+		key    string
+		body   []byte
+		status int
+	}{
+		{"/admin/tilesets?id=new", "", data, http.StatusUnauthorized},
+		{"/admin/tilesets?id=new", "wrong", data, http.StatusUnauthorized},
+		{"/admin/tilesets?id=../new", "secret", data, http.StatusBadRequest},
+		{"/admin/tilesets?id=geography-class-png", "secret", data, http.StatusConflict},
+		{"/admin/tilesets?id=new", "secret", []byte("not an mbtiles file"), http.StatusUnprocessableEntity},
+		{"/admin/tilesets?id=new", "secret", append(data, 0), http.StatusRequestEntityTooLarge},
+		{"/admin/tilesets?id=uploads/new", "secret", data, http.StatusCreated},
+		{"/admin/tilesets?id=uploads/new", "secret", data, http.StatusConflict},
+	} {
+		if status := post(tc.path, tc.key, tc.body); status != tc.status {
+			t.Errorf("%s with key %q: expected status %d, got %d", tc.path, tc.key, tc.status, status)
+		}
+	}
+	if uploaded != "uploads/new" {
+		t.Errorf("expected upload of uploads/new to be reported, got %q", uploaded)
+	}
+	if _, ok := s.dbs()["uploads/new"]; !ok {
+		t.Error("expected uploaded tileset to be served")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "uploads", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "new.mbtiles" {
+		t.Errorf("expected only the uploaded file, got %v", files)
+	}
+}
+
+func TestConcurrentUpload(t *testing.T) {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDir, "geography-class-png.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServiceSet(t)
+	s.AdminKey = "secret"
+	s.UploadDir = dir
+	h := s.AdminHandler(nil)
+	var bodies [2]*io.PipeWriter
+	statuses := make(chan int, len(bodies))
+	for i := range bodies {
+		r, w := io.Pipe()
+		bodies[i] = w
+		go func() {
+			req := httptest.NewRequest("POST", "/admin/tilesets?id=new", r)
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			statuses <- rec.Code
+		}()
+	}
+	// both uploads have passed the checks for existing tilesets once they
+	// read their bodies
+	for _, w := range bodies {
+		w.Write(data[:1])
+	}
+	for _, w := range bodies {
+		w.Write(data[1:])
+		w.Close()
+	}
+
+	codes := map[int]int{}
+	for range bodies {
+		codes[<-statuses]++
+	}
+	if codes[http.StatusCreated] != 1 || codes[http.StatusConflict] != 1 {
+		t.Errorf("expected one created and one conflicting upload, got %v", codes)
+	}
+	if _, ok := s.dbs()["new"]; !ok {
+		t.Error("expected uploaded tileset to be served")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "new.mbtiles" {
+		t.Errorf("expected only the uploaded file, got %v", files)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	var mu sync.Mutex
+	var purged []string
+	fail := false
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/service/svc/purge":
+			purged = append(purged, "fastly "+r.Header.Get("Fastly-Key")+" "+r.Header.Get("Surrogate-Key"))
+		case "/zones/zone/purge_cache":
+			var body struct{ Tags []string }
+			json.NewDecoder(r.Body).Decode(&body)
+			purged = append(purged, "cloudflare "+r.Header.Get("Authorization")+" "+strings.Join(body.Tags, ","))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cdn.Close()
+
+	s := newTestServiceSet(t)
+	s.AdminKey = "secret"
+	s.ResponseCache = NewResponseCache(1 << 20)
+	s.Purgers = []Purger{
+		&FastlyPurger{ServiceID: "svc", Token: "fastly-token", Endpoint: cdn.URL},
+		&CloudflarePurger{ZoneID: "zone", Token: "cf-token", Endpoint: cdn.URL},
+	}
+	h := s.Handler(nil, true)
+	admin := s.AdminHandler(nil)
+
+	tile := "/services/geography-class-png/tiles/1/0/0.png"
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tile, nil))
+		return rec
+	}
+	rec := get()
+	if key := rec.Header().Get("Surrogate-Key"); key != "tileset:geography-class-png tileset:geography-class-png:z1" {
+		t.Errorf("unexpected Surrogate-Key %q", key)
+	}
+	if tag := rec.Header().Get("Cache-Tag"); tag != "tileset:geography-class-png,tileset:geography-class-png:z1" {
+		t.Errorf("unexpected Cache-Tag %q", tag)
+	}
+	get()
+
+	post := func(path, key string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, tc := range []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{"/admin/purge?tileset=geography-class-png", "", http.StatusUnauthorized},
+		{"/admin/purge?tileset=missing", "secret", http.StatusNotFound},
+		{"/admin/purge?tileset=geography-class-png&z=x", "secret", http.StatusBadRequest},
+		{"/admin/purge?tileset=geography-class-png&z=1", "secret", http.StatusOK},
+	} {
+		if status := post(tc.path, tc.key); status != tc.status {
+			t.Errorf("%s with key %q: expected status %d, got %d", tc.path, tc.key, tc.status, status)
+		}
+	}
+	expected := []string{
+		"fastly fastly-token tileset:geography-class-png:z1",
+		"cloudflare Bearer cf-token tileset:geography-class-png:z1",
+	}
+	if !reflect.DeepEqual(purged, expected) {
+		t.Errorf("expected purges %q, got %q", expected, purged)
+	}
+
+	// the response cache is invalidated
+	get()
+	if stats := s.ResponseCache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("expected the tile to be read again after purging, got statistics %+v", stats)
+	}
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	if status := post("/admin/purge?tileset=geography-class-png", "secret"); status != http.StatusBadGateway {
+		t.Errorf("expected status %d for failing CDN, got %d", http.StatusBadGateway, status)
+	}
+}
+
+func TestMissingTile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vector := createVectorDB(t, dir, "vector", &mvt.Tile{})
+	watermark := filepath.Join(dir, "missing.png")
+	if err := ioutil.WriteFile(watermark, BlankPNG(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMissingTile(vector); err == nil {
+		t.Error("expected error for missing tile that is not an image")
+	}
+	custom, err := ParseMissingTile(watermark)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		missing  string
+		path     string
+		status   int
+		ctype    string
+		encoding string
+	}{
+		{"default", "/services/geography-class-png/tiles/3/0/0.png", http.StatusOK, "image/png", ""},
+		{"default", "/services/vector/tiles/3/0/0.pbf", http.StatusNoContent, "", ""},
+		{"404", "/services/geography-class-png/tiles/3/0/0.png", http.StatusNotFound, "application/json", ""},
+		{"204", "/services/geography-class-png/tiles/3/0/0.png", http.StatusNoContent, "", ""},
+		{"blank", "/services/geography-class-png/tiles/3/0/0.png", http.StatusOK, "image/png", ""},
+		{"blank", "/services/vector/tiles/3/0/0.pbf", http.StatusOK, "application/x-protobuf", "gzip"},
+	}
+	for _, tc := range tests {
+		s := newTestServiceSet(t)
+		if err := s.AddDBOnPath(vector, "vector"); err != nil {
+			t.Fatal(err)
+		}
+		if s.MissingTile, err = ParseMissingTile(tc.missing); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		s.Handler(nil, true).ServeHTTP(rec, req)
+		if rec.Code != tc.status || rec.Header().Get("Content-Type") != tc.ctype || rec.Header().Get("Content-Encoding") != tc.encoding {
+			t.Errorf("%s with %s: expected status %d, type %q and encoding %q, got %d, %q and %q", tc.path, tc.missing, tc.status, tc.ctype, tc.encoding, rec.Code, rec.Header().Get("Content-Type"), rec.Header().Get("Content-Encoding"))
+		}
+		if tc.encoding == "gzip" {
+			if raw, err := mbtiles.GZIPENC.Decode(rec.Body.Bytes()); err != nil || len(raw) != 0 {
+				t.Errorf("%s with %s: expected empty gzipped tile, got %v, %v", tc.path, tc.missing, raw, err)
+			}
+		}
+	}
+
+	// custom images per tileset
+	s := newTestServiceSet(t)
+	s.MissingTiles = map[string]*MissingTile{"geography-class-png": custom}
+	s.MissingTile, _ = ParseMissingTile("404")
+	for path, status := range map[string]int{
+		"/services/geography-class-png/tiles/3/0/0.png": http.StatusOK,
+		"/services/geography-class-jpg/tiles/3/0/0.jpg": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler(nil, true).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, rec.Code)
+		}
+		if status == http.StatusOK && (rec.Header().Get("Content-Type") != "image/png" || rec.Body.String() != string(BlankPNG())) {
+			t.Errorf("%s: expected custom image, got %q", path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
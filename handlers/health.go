@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// HealthHandler returns a http.Handler that serves the liveness endpoint
+// "/health", which succeeds as long as the server is running, and the
+// readiness endpoint "/ready", which pings every tileset of the ServiceSet and
+// reports their status. The readiness endpoint answers with status 503 if any
+// tileset is unavailable.
+func (s *ServiceSet) HealthHandler(ef func(error)) http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/health", wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return writeJSON(w, map[string]string{"status": "ok"})
+	}))
+	m.Handle("/ready", s.ready(ef))
+	return m
+}
+
+// ready serves the readiness endpoint. Unlike a handlerFunc, it writes the
+// JSON report for unavailable tilesets, too.
+func (s *ServiceSet) ready(ef func(error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		dbs, gen := s.acquire()
+		defer s.release(gen)
+		tilesets := make(map[string]string)
+		for id, db := range dbs {
+			// tilesets that cannot be pinged are always ready
+			if p, ok := db.(mbtiles.Pinger); ok {
+				if err := p.Ping(r.Context()); err != nil {
+					status = http.StatusServiceUnavailable
+					tilesets[id] = err.Error()
+					if ef != nil {
+						ef(fmt.Errorf("tileset %v is not ready: %v", id, err))
+					}
+					continue
+				}
+			}
+			tilesets[id] = "ok"
+		}
+		out := map[string]interface{}{
+			"status":   "ok",
+			"tilesets": tilesets,
+		}
+		if status != http.StatusOK {
+			out["status"] = "unavailable"
+		}
+		bytes, err := json.Marshal(out)
+		if err != nil {
+			if ef != nil {
+				ef(fmt.Errorf("cannot marshal readiness JSON: %v", err))
+			}
+			status = http.StatusInternalServerError
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		w.Write(bytes)
+	})
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// earthCircumference is the circumference of the earth at the equator in
+// meters, as used by web mercator.
+const earthCircumference = 2 * math.Pi * 6378137
+
+// hillshadeParams are the parameters of the illumination of a hillshade.
+type hillshadeParams struct {
+	// azimuth is the direction of the light source in degrees clockwise
+	// from the north
+	azimuth float64
+	// altitude is the angle of the light source above the horizon in
+	// degrees
+	altitude float64
+	// exaggeration scales the elevations
+	exaggeration float64
+}
+
+// hillshadeKey identifies a rendered hillshade tile in a convertedCache.
+type hillshadeKey struct {
+	tc       tileCoord
+	params   hillshadeParams
+	encoding demEncoding
+	format   mbtiles.TileFormat
+}
+
+// parseHillshadeParams parses the "azimuth", "altitude" and "exaggeration"
+// query parameters of r, which default to a light source in the northwest at
+// 45 degrees and no exaggeration.
+func parseHillshadeParams(r *http.Request) (hillshadeParams, error) {
+	var p hillshadeParams
+	var err error
+	if p.azimuth, err = queryFloat(r, "azimuth", 315); err != nil {
+		return p, err
+	}
+	if p.altitude, err = queryFloat(r, "altitude", 45); err != nil {
+		return p, err
+	}
+	if p.exaggeration, err = queryFloat(r, "exaggeration", 1); err != nil {
+		return p, err
+	}
+	switch {
+	case p.azimuth < 0 || p.azimuth > 360:
+		return p, fmt.Errorf("azimuth %v is not between 0 and 360", p.azimuth)
+	case p.altitude < 0 || p.altitude > 90:
+		return p, fmt.Errorf("altitude %v is not between 0 and 90", p.altitude)
+	case p.exaggeration <= 0:
+		return p, fmt.Errorf("exaggeration %v is not positive", p.exaggeration)
+	}
+	return p, nil
+}
+
+// renderHillshade renders the hillshade of the terrain d of the tile at tc in
+// shades of gray with Horn's method. The pixels at the edges of the tile
+// take the elevations beyond the tile to be those at the edge.
+func renderHillshade(d *dem, tc tileCoord, p hillshadeParams) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, d.w, d.h))
+	zenith := (90 - p.altitude) * math.Pi / 180
+	azimuth := math.Mod(450-p.azimuth, 360) * math.Pi / 180
+	n := math.Exp2(float64(tc.z))
+	for y := 0; y < d.h; y++ {
+		// the size of the pixels on the ground shrinks with the latitude
+		my := (float64(tc.y) + (float64(y)+0.5)/float64(d.h)) / n
+		lat := math.Atan(math.Sinh(math.Pi * (1 - 2*my)))
+		size := earthCircumference / (n * float64(d.w)) * math.Cos(lat)
+		scale := p.exaggeration / (8 * size)
+		for x := 0; x < d.w; x++ {
+			a, b, c := d.at(x-1, y-1), d.at(x, y-1), d.at(x+1, y-1)
+			dl, f := d.at(x-1, y), d.at(x+1, y)
+			g, h, i := d.at(x-1, y+1), d.at(x, y+1), d.at(x+1, y+1)
+			dzdx := ((c + 2*f + i) - (a + 2*dl + g)) * scale
+			dzdy := ((g + 2*h + i) - (a + 2*b + c)) * scale
+			slope := math.Atan(math.Hypot(dzdx, dzdy))
+			aspect := math.Atan2(dzdy, -dzdx)
+			shade := math.Cos(zenith)*math.Cos(slope) + math.Sin(zenith)*math.Sin(slope)*math.Cos(azimuth-aspect)
+			img.Pix[y*img.Stride+x] = uint8(math.Max(0, math.Min(1, shade))*0xff + 0.5)
+		}
+	}
+	return img
+}
+
+// hillshade serves hillshade tiles that are rendered from the tiles of a
+// terrain-RGB tileset at "/services/<id>/hillshade/<z>/<x>/<y>.<ext>", in
+// PNG format unless the extension asks for another one. The illumination is
+// given by the query parameters of parseHillshadeParams. Rendered tiles are
+// cached.
+func (s *ServiceSet) hillshade(id string, db Tileset) handlerFunc {
+	policy := s.policy(id)
+	enc := demEncodingOf(db)
+	rendered := s.convertedCache(db, "hillshade")
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		pcs := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"+id+"/hillshade/"), "/")
+		if len(pcs) != 3 {
+			return http.StatusNotFound, fmt.Errorf("unknown hillshade resource %q", r.URL.Path)
+		}
+		logTile(r, pcs[0], pcs[1], pcs[2])
+		tc, ext, err := tileCoordFromString(pcs[0], pcs[1], pcs[2])
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if s.Scheme == mbtiles.TMS {
+			tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+		}
+		format := mbtiles.PNG
+		if ext != "" {
+			f, ok := formatFromExt(ext)
+			if !ok || imageCodecs[f].encode == nil {
+				return http.StatusBadRequest, fmt.Errorf("cannot render hillshade tiles of format %q", ext)
+			}
+			format = f
+		}
+		p, err := parseHillshadeParams(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		e, err := requestDEMEncoding(r, enc)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if !policy.allowsZoom(int(tc.z)) {
+			return tileNotFoundHandler(w, format)
+		}
+		if status := s.interceptRead(r, id, db, tc); status != 0 {
+			return status, nil
+		}
+		k := hillshadeKey{tc: tc, params: p, encoding: e, format: format}
+		if data, ok := rendered.get(k); ok {
+			w.Header().Set("Content-Type", format.ContentType())
+			return writeWithETag(w, r, data)
+		}
+
+		d, err := readDEM(r.Context(), db, tc, e)
+		switch {
+		case err == mbtiles.ErrTileNotFound:
+			return tileNotFoundHandler(w, format)
+		case err == mbtiles.ErrQueryTimeout:
+			return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		case err != nil:
+			return http.StatusInternalServerError, fmt.Errorf("cannot read terrain tile for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		}
+		_, span := s.startSpan(r.Context(), "hillshade")
+		data, err := encodeTile(renderHillshade(d, tc, p), format, s.ImageQuality)
+		span.End()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		rendered.add(k, data)
+		w.Header().Set("Content-Type", format.ContentType())
+		return writeWithETag(w, r, data)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// IDStrategy derives the ID of the tileset in the mbtiles file filename,
+// which has been found below the directory dir.
+type IDStrategy func(dir, filename string) (string, error)
+
+// IDStrategies are the available IDStrategy functions by name.
+var IDStrategies = map[string]IDStrategy{
+	"path":     IDFromPath,
+	"basename": IDFromBasename,
+	"hash":     IDFromHash,
+	"name":     IDFromName,
+}
+
+// trimExt returns p without its filename extension.
+func trimExt(p string) string {
+	return p[:len(p)-len(filepath.Ext(p))]
+}
+
+// IDFromPath returns the lower-case path of filename relative to dir without
+// its extension, e.g. "foo/bar/baz" for "foo/bar/baz.mbtiles".
+func IDFromPath(dir, filename string) (string, error) {
+	subpath, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(trimExt(filepath.ToSlash(subpath))), nil
+}
+
+// IDFromBasename returns the lower-case name of filename without its
+// extension, e.g. "osm.2024" for "foo/osm.2024.mbtiles".
+func IDFromBasename(dir, filename string) (string, error) {
+	return strings.ToLower(trimExt(filepath.Base(filename))), nil
+}
+
+// IDFromHash returns the first 16 hex digits of the SHA-256 hash of the path
+// of filename relative to dir, which does not disclose the filename.
+func IDFromHash(dir, filename string) (string, error) {
+	subpath, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(filepath.ToSlash(subpath)))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// IDFromName returns the "name" metadata item of the tileset in lower case,
+// with all characters other than letters, digits, ".", "-" and "_" replaced
+// by "-", e.g. "geography-class" for "Geography Class". IDFromPath is used
+// if the tileset has no name.
+func IDFromName(dir, filename string) (string, error) {
+	db, err := mbtiles.NewDB(filename)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return "", err
+	}
+	name, _ := metadata["name"].(string)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return IDFromPath(dir, filename)
+	}
+	return name, nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// TileRequest is a request for a tile or UTF grid that is passed to the
+// TileInterceptors.
+type TileRequest struct {
+	// Tileset is the ID of the tileset.
+	Tileset string
+	// Z, X and Y are the coordinates of the tile, with the row Y in the XYZ
+	// scheme regardless of the Scheme of the ServiceSet.
+	Z    uint8
+	X, Y uint64
+	// Ext is the extension of the requested file, like ".png", or ".json"
+	// for UTF grids.
+	Ext string
+	// Scale is the scale factor of the tile, like 2 for "@2x" tiles.
+	Scale int
+}
+
+// TileInterceptor intercepts the requests for tiles and UTF grids and their
+// responses, e.g. to veto requests, to record them in a billing system, to
+// rewrite headers or to watermark tiles. The requests to the WMTS and OGC API
+// endpoints are intercepted as well. The tiles that are read for batches,
+// bundles, composites, WMS and static maps, hillshades, contours, elevations,
+// point queries, tile info and ArcGIS tiles are only passed to
+// InterceptRequest. Vetoed tiles are left out of the responses that are made
+// of several tiles, and the others are answered with the status of the veto.
+type TileInterceptor interface {
+	// InterceptRequest is called for the request r for the tile t before it
+	// is read. If it returns an error, the request is answered with the
+	// Status of a *StatusError, or with 403 Forbidden for other errors.
+	InterceptRequest(r *http.Request, t TileRequest) error
+	// InterceptResponse is called with the status, header and body of the
+	// response to r before they are written, and returns the body that is
+	// written instead. The header can be modified in place. The responses
+	// that are cached by the ResponseCache have not been intercepted yet.
+	InterceptResponse(r *http.Request, t TileRequest, status int, header http.Header, body []byte) ([]byte, error)
+}
+
+// StatusError is an error of a TileInterceptor that is answered with Status.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.Status, http.StatusText(e.Status), e.Err)
+}
+
+// tileRequestFrom returns the TileRequest of a request for the path
+// "/services/<id>/tiles/<z>/<x>/<y>.<ext>", whose row is in the scheme s,
+// and whether the path is one of a tile.
+func tileRequestFrom(id, path string, s mbtiles.TileScheme) (TileRequest, bool) {
+	pcs := strings.Split(strings.TrimPrefix(path, "/services/"+id+"/tiles/"), "/")
+	if len(pcs) != 3 {
+		return TileRequest{}, false
+	}
+	y, scale, err := splitScale(pcs[2])
+	if err != nil {
+		return TileRequest{}, false
+	}
+	tc, ext, err := tileCoordFromString(pcs[0], pcs[1], y)
+	if err != nil {
+		return TileRequest{}, false
+	}
+	if s == mbtiles.TMS {
+		tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+	}
+	return TileRequest{Tileset: id, Z: tc.z, X: tc.x, Y: tc.y, Ext: ext, Scale: scale}, true
+}
+
+// interceptRequest calls InterceptRequest of the TileInterceptors and returns
+// the status of the response to a vetoed request, or zero.
+func (s *ServiceSet) interceptRequest(r *http.Request, t TileRequest) int {
+	for _, ti := range s.TileInterceptors {
+		if err := ti.InterceptRequest(r, t); err != nil {
+			if se, ok := err.(*StatusError); ok {
+				return se.Status
+			}
+			return http.StatusForbidden
+		}
+	}
+	return 0
+}
+
+// interceptRead calls InterceptRequest of the TileInterceptors for the tile at
+// tc of the tileset id in db, which is read for another response to r, like a
+// bundle or a map image. It returns the status of the response to a vetoed
+// request, or zero.
+func (s *ServiceSet) interceptRead(r *http.Request, id string, db Tileset, tc tileCoord) int {
+	if len(s.TileInterceptors) == 0 {
+		return 0
+	}
+	t := TileRequest{Tileset: id, Z: tc.z, X: tc.x, Y: tc.y, Ext: "." + db.TileFormat().String(), Scale: 1}
+	return s.interceptRequest(r, t)
+}
+
+// intercepted returns a handlerFunc that passes the requests for the tiles of
+// the tileset id to hf and their responses through the TileInterceptors.
+func (s *ServiceSet) intercepted(id string, hf handlerFunc) handlerFunc {
+	if len(s.TileInterceptors) == 0 {
+		return hf
+	}
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		t, ok := tileRequestFrom(id, r.URL.Path, s.Scheme)
+		if !ok {
+			return hf(w, r)
+		}
+		if status := s.interceptRequest(r, t); status != 0 {
+			return status, nil
+		}
+		rec := &responseRecorder{header: make(http.Header)}
+		status, err := hf(rec, r)
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		// errors are written by the caller
+		if rec.status == 0 {
+			return status, err
+		}
+		body := rec.body.Bytes()
+		n := len(body)
+		for _, ti := range s.TileInterceptors {
+			var ierr error
+			if body, ierr = ti.InterceptResponse(r, t, rec.status, w.Header(), body); ierr != nil {
+				return http.StatusInternalServerError, fmt.Errorf("cannot intercept response for tile z=%d, x=%d, y=%d: %v", t.Z, t.X, t.Y, ierr)
+			}
+		}
+		if len(body) != n && r.Method != "HEAD" {
+			w.Header().Del("Content-Length")
+		}
+		w.WriteHeader(rec.status)
+		if _, werr := w.Write(body); err == nil {
+			err = werr
+		}
+		return status, err
+	}
+}
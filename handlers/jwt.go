@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultJWTClaim is the claim of JSON Web Tokens with the tilesets that
+// they grant access to, unless JWTAuth.Claim is set.
+const DefaultJWTClaim = "services"
+
+// JWTAuth validates signed JSON Web Tokens (JWT) that grant access to the
+// tilesets whose IDs match one of the glob patterns in their claim Claim, as
+// understood by path.Match. The claim is either a string or an array of
+// strings. Tokens must have an expiry ("exp") and are rejected before their
+// "nbf" time.
+type JWTAuth struct {
+	// Key is the secret of HMAC-signed tokens as []byte, or the public key of
+	// RSA- or ECDSA-signed tokens as *rsa.PublicKey or *ecdsa.PublicKey.
+	// Tokens signed with any other algorithm are rejected.
+	Key interface{}
+	// Claim is the name of the claim with the tileset patterns. If it is
+	// empty, DefaultJWTClaim is used.
+	Claim string
+}
+
+// JWTPublicKeyFromPEM parses a PEM-encoded RSA or ECDSA public key for use as
+// JWTAuth.Key.
+func JWTPublicKeyFromPEM(b []byte) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no RSA or ECDSA public key found in PEM data")
+}
+
+// isJWT reports whether the token looks like a JSON Web Token in compact
+// serialization.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// keyFor returns the key to verify the token t, after making sure that its
+// signing method matches the type of the key.
+func (a *JWTAuth) keyFor(t *jwt.Token) (interface{}, error) {
+	ok := false
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok = a.Key.([]byte)
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok = a.Key.(*rsa.PublicKey)
+	case *jwt.SigningMethodECDSA:
+		_, ok = a.Key.(*ecdsa.PublicKey)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return a.Key, nil
+}
+
+// patterns returns the tileset patterns of a token that has been parsed from
+// the string token, if it is valid.
+func (a *JWTAuth) patterns(token string) ([]string, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, a.keyFor); err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("invalid token: missing expiry")
+	}
+	name := a.Claim
+	if name == "" {
+		name = DefaultJWTClaim
+	}
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		patterns := make([]string, 0, len(v))
+		for _, p := range v {
+			if s, ok := p.(string); ok {
+				patterns = append(patterns, s)
+			}
+		}
+		return patterns, nil
+	}
+	return nil, nil
+}
+
+// allows reports whether the valid token grants access to the tileset id.
+// The returned error is non-nil if the token is invalid.
+func (a *JWTAuth) allows(token, id string) (bool, error) {
+	patterns, err := a.patterns(token)
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// queryDurationBuckets are the upper bounds in seconds of the buckets of the
+// SQLite query duration histograms.
+var queryDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type requestKey struct {
+	tileset, zoom, format string
+	status                int
+}
+
+// histogram is a cumulative histogram of the queryDurationBuckets.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(queryDurationBuckets))
+	}
+	for i, b := range queryDurationBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metrics collects the request and query statistics of a ServiceSet.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	bytes    map[string]uint64
+	queries  map[Tileset]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestKey]uint64),
+		bytes:    make(map[string]uint64),
+		queries:  make(map[Tileset]*histogram),
+	}
+}
+
+// observeQuery records the duration d of a tile or grid query of db.
+func (m *metrics) observeQuery(db Tileset, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.queries[db]
+	if !ok {
+		h = &histogram{}
+		m.queries[db] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// forget drops the query durations of a tileset that has been closed.
+func (m *metrics) forget(db Tileset) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.queries, db)
+}
+
+// labelEscaper escapes label values in the Prometheus text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label returns v as a quoted Prometheus label value.
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// metricsFormat returns the format label of a tile request for the filename
+// ext of the tileset db. Only the formats that can be served are used as
+// labels, so that clients cannot create arbitrary label values.
+func metricsFormat(db Tileset, ext string) string {
+	if f, ok := formatFromExt(ext); ok {
+		return f.String()
+	}
+	if strings.ToLower(ext) == ".json" {
+		return "json"
+	}
+	return db.TileFormat().String()
+}
+
+// countRequests counts the tile requests that are answered by hf, which
+// serves the tiles of the tileset with the given id. The zoom level and the
+// format are taken from the request path ending in "<z>/<x>/<y>[.<ext>]",
+// the status from the response that is actually written.
+func (s *ServiceSet) countRequests(id string, db Tileset, hf handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if e := accessLogEntryFrom(r); e != nil {
+			e.Tileset = id
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		status, err := hf(sw, r)
+		// errors are written by the caller with the returned status
+		if sw.status == 0 && sw.n > 0 {
+			sw.status = http.StatusOK
+		}
+		if sw.status == 0 {
+			sw.status = status
+		}
+		k := requestKey{tileset: id, format: db.TileFormat().String(), status: sw.status}
+		pcs := strings.Split(r.URL.Path, "/")
+		if l := len(pcs); l >= 3 {
+			if _, perr := strconv.ParseUint(pcs[l-3], 10, 8); perr == nil {
+				k.zoom = pcs[l-3]
+			}
+			k.format = metricsFormat(db, path.Ext(pcs[l-1]))
+		}
+		s.metrics.mu.Lock()
+		s.metrics.requests[k]++
+		s.metrics.bytes[id] += uint64(sw.n)
+		s.metrics.mu.Unlock()
+		return status, err
+	}
+}
+
+// MetricsHandler returns a http.Handler that serves the metrics of the
+// ServiceSet in the Prometheus text exposition format.
+func (s *ServiceSet) MetricsHandler(ef func(error)) http.Handler {
+	return wrapGetWithErrors(ef, s.writeMetrics)
+}
+
+// CacheHandler returns a http.Handler that serves the statistics of the
+// TileCache and the tile caches of the tilesets, the ResponseCache and its
+// PeerCache as JSON. It
+// requires the AdminKey if that is set.
+func (s *ServiceSet) CacheHandler(ef func(error)) http.Handler {
+	hf := s.writeCacheStats
+	if s.AdminKey != "" {
+		hf = s.admin(hf)
+	}
+	return wrapGetWithErrors(ef, hf)
+}
+
+func (s *ServiceSet) writeCacheStats(w http.ResponseWriter, r *http.Request) (int, error) {
+	out := struct {
+		Tiles     *mbtiles.CacheStats           `json:"tiles,omitempty"`
+		Tilesets  map[string]mbtiles.CacheStats `json:"tilesets"`
+		Responses *ResponseCacheStats           `json:"responses,omitempty"`
+		Peers     *PeerCacheStats               `json:"peers,omitempty"`
+	}{Tilesets: make(map[string]mbtiles.CacheStats)}
+	if s.TileCache != nil {
+		stats := s.TileCache.Stats()
+		out.Tiles = &stats
+	}
+	for id, db := range s.dbs() {
+		out.Tilesets[id] = cacheStats(db)
+	}
+	if c := s.ResponseCache; c != nil {
+		stats := c.Stats()
+		out.Responses = &stats
+		if c.Peers != nil {
+			stats := c.Peers.Stats()
+			out.Peers = &stats
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal cache statistics: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return http.StatusOK, err
+}
+
+func (s *ServiceSet) writeMetrics(w http.ResponseWriter, r *http.Request) (int, error) {
+	var buf bytes.Buffer
+	tilesets, gen := s.acquire()
+	defer s.release(gen)
+	ids := make([]string, 0, len(tilesets))
+	for id := range tilesets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	s.metrics.mu.Lock()
+	keys := make([]requestKey, 0, len(s.metrics.requests))
+	for k := range s.metrics.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.tileset != b.tileset {
+			return a.tileset < b.tileset
+		}
+		if a.zoom != b.zoom {
+			return a.zoom < b.zoom
+		}
+		if a.format != b.format {
+			return a.format < b.format
+		}
+		return a.status < b.status
+	})
+	buf.WriteString("# HELP mbtileserver_tile_requests_total Number of tile requests.\n")
+	buf.WriteString("# TYPE mbtileserver_tile_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "mbtileserver_tile_requests_total{tileset=%s,zoom=%s,format=%s,status=\"%d\"} %d\n",
+			label(k.tileset), label(k.zoom), label(k.format), k.status, s.metrics.requests[k])
+	}
+	buf.WriteString("# HELP mbtileserver_tile_response_bytes_total Number of bytes of tile responses.\n")
+	buf.WriteString("# TYPE mbtileserver_tile_response_bytes_total counter\n")
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "mbtileserver_tile_response_bytes_total{tileset=%s} %d\n", label(id), s.metrics.bytes[id])
+	}
+	buf.WriteString("# HELP mbtileserver_sqlite_query_duration_seconds Duration of tile and grid queries.\n")
+	buf.WriteString("# TYPE mbtileserver_sqlite_query_duration_seconds histogram\n")
+	for _, id := range ids {
+		h, ok := s.metrics.queries[tilesets[id]]
+		if !ok {
+			continue
+		}
+		for i, b := range queryDurationBuckets {
+			fmt.Fprintf(&buf, "mbtileserver_sqlite_query_duration_seconds_bucket{tileset=%s,le=\"%v\"} %d\n", label(id), b, h.counts[i])
+		}
+		fmt.Fprintf(&buf, "mbtileserver_sqlite_query_duration_seconds_bucket{tileset=%s,le=\"+Inf\"} %d\n", label(id), h.count)
+		fmt.Fprintf(&buf, "mbtileserver_sqlite_query_duration_seconds_sum{tileset=%s} %v\n", label(id), h.sum)
+		fmt.Fprintf(&buf, "mbtileserver_sqlite_query_duration_seconds_count{tileset=%s} %d\n", label(id), h.count)
+	}
+	s.metrics.mu.Unlock()
+
+	buf.WriteString("# HELP mbtileserver_tile_cache_hits_total Number of tile cache hits.\n")
+	buf.WriteString("# TYPE mbtileserver_tile_cache_hits_total counter\n")
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "mbtileserver_tile_cache_hits_total{tileset=%s} %d\n", label(id), cacheStats(tilesets[id]).Hits)
+	}
+	buf.WriteString("# HELP mbtileserver_tile_cache_misses_total Number of tile cache misses.\n")
+	buf.WriteString("# TYPE mbtileserver_tile_cache_misses_total counter\n")
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "mbtileserver_tile_cache_misses_total{tileset=%s} %d\n", label(id), cacheStats(tilesets[id]).Misses)
+	}
+	if c := s.ResponseCache; c != nil {
+		stats := c.Stats()
+		buf.WriteString("# HELP mbtileserver_response_cache_hits_total Number of response cache hits.\n")
+		buf.WriteString("# TYPE mbtileserver_response_cache_hits_total counter\n")
+		fmt.Fprintf(&buf, "mbtileserver_response_cache_hits_total %d\n", stats.Hits)
+		buf.WriteString("# HELP mbtileserver_response_cache_misses_total Number of response cache misses.\n")
+		buf.WriteString("# TYPE mbtileserver_response_cache_misses_total counter\n")
+		fmt.Fprintf(&buf, "mbtileserver_response_cache_misses_total %d\n", stats.Misses)
+		buf.WriteString("# HELP mbtileserver_response_cache_coalesced_total Number of requests answered with the response to a concurrent request.\n")
+		buf.WriteString("# TYPE mbtileserver_response_cache_coalesced_total counter\n")
+		fmt.Fprintf(&buf, "mbtileserver_response_cache_coalesced_total %d\n", stats.Coalesced)
+		buf.WriteString("# HELP mbtileserver_response_cache_bytes Number of bytes of cached responses.\n")
+		buf.WriteString("# TYPE mbtileserver_response_cache_bytes gauge\n")
+		fmt.Fprintf(&buf, "mbtileserver_response_cache_bytes %d\n", stats.Bytes)
+		if c.Shared != nil {
+			buf.WriteString("# HELP mbtileserver_shared_cache_hits_total Number of responses found in the shared cache.\n")
+			buf.WriteString("# TYPE mbtileserver_shared_cache_hits_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_shared_cache_hits_total %d\n", stats.SharedHits)
+			buf.WriteString("# HELP mbtileserver_shared_cache_errors_total Number of errors of the shared cache.\n")
+			buf.WriteString("# TYPE mbtileserver_shared_cache_errors_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_shared_cache_errors_total %d\n", stats.SharedErrors)
+		}
+		if c.Peers != nil {
+			stats := c.Peers.Stats()
+			buf.WriteString("# HELP mbtileserver_peer_cache_hits_total Number of responses found in the peer cache of this server.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_hits_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_hits_total %d\n", stats.Hits)
+			buf.WriteString("# HELP mbtileserver_peer_cache_peer_loads_total Number of responses fetched from peers.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_peer_loads_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_peer_loads_total %d\n", stats.PeerLoads)
+			buf.WriteString("# HELP mbtileserver_peer_cache_peer_errors_total Number of errors fetching responses from peers.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_peer_errors_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_peer_errors_total %d\n", stats.PeerErrors)
+			buf.WriteString("# HELP mbtileserver_peer_cache_local_loads_total Number of responses read from the local tilesets.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_local_loads_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_local_loads_total %d\n", stats.LocalLoads)
+			buf.WriteString("# HELP mbtileserver_peer_cache_requests_total Number of requests of peers.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_requests_total counter\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_requests_total %d\n", stats.PeerRequests)
+			buf.WriteString("# HELP mbtileserver_peer_cache_bytes Number of bytes of responses in the peer cache of this server.\n")
+			buf.WriteString("# TYPE mbtileserver_peer_cache_bytes gauge\n")
+			fmt.Fprintf(&buf, "mbtileserver_peer_cache_bytes %d\n", stats.Bytes)
+		}
+	}
+	buf.WriteString("# HELP mbtileserver_open_tilesets Number of open tilesets.\n")
+	buf.WriteString("# TYPE mbtileserver_open_tilesets gauge\n")
+	fmt.Fprintf(&buf, "mbtileserver_open_tilesets %d\n", len(ids))
+	buf.WriteString("# HELP mbtileserver_sqlite_open_connections Number of open SQLite connections.\n")
+	buf.WriteString("# TYPE mbtileserver_sqlite_open_connections gauge\n")
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "mbtileserver_sqlite_open_connections{tileset=%s} %d\n", label(id), openConnections(tilesets[id]))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := w.Write(buf.Bytes())
+	return http.StatusOK, err
+}
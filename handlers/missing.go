@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// MissingTile is the response to requests for tiles that do not exist in a
+// tileset. The nil MissingTile is the default response of tileNotFoundHandler.
+type MissingTile struct {
+	// status is the status of the response, or zero for a blank tile in the
+	// format of the tileset.
+	status      int
+	contentType string
+	data        []byte
+}
+
+// ParseMissingTile returns the MissingTile described by v, which is "404" for
+// 404 Not Found, "204" for 204 No Content, "blank" for a transparent PNG or an
+// empty vector tile depending on the format of the tileset, or the filename
+// of an image that is returned instead, e.g. of a watermark. "default" and ""
+// return nil.
+func ParseMissingTile(v string) (*MissingTile, error) {
+	switch v {
+	case "", "default":
+		return nil, nil
+	case "404":
+		return &MissingTile{status: http.StatusNotFound}, nil
+	case "204":
+		return &MissingTile{status: http.StatusNoContent}, nil
+	case "blank":
+		return &MissingTile{}, nil
+	}
+	data, err := ioutil.ReadFile(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid missing tile %q: expected 404, 204, blank or an image file", v)
+	}
+	ct := http.DetectContentType(data)
+	if !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("missing tile %s is not an image, but %s", v, ct)
+	}
+	return &MissingTile{status: http.StatusOK, contentType: ct, data: data}, nil
+}
+
+// write writes the response for a missing tile of format f to w.
+func (m *MissingTile) write(w http.ResponseWriter, r *http.Request, f mbtiles.TileFormat) (int, error) {
+	switch {
+	case m == nil:
+		return tileNotFoundHandler(w, f)
+	case m.status == http.StatusNotFound:
+		return notFoundJSON(w, "Tile does not exist")
+	case m.status == http.StatusNoContent:
+		w.WriteHeader(http.StatusNoContent)
+		return http.StatusOK, nil
+	case m.status == http.StatusOK:
+		w.Header().Set("Content-Type", m.contentType)
+		return writeWithETag(w, r, m.data)
+	case f == mbtiles.PBF:
+		// an empty vector tile has no layers and thus no bytes
+		w.Header().Set("Content-Type", f.ContentType())
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "gzip") {
+			return writeWithETag(w, r, nil)
+		}
+		data, err := gzipBytes(nil)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		return writeWithETag(w, r, data)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	return writeWithETag(w, r, BlankPNG())
+}
+
+// missingTile returns the MissingTile of the tileset id.
+func (s *ServiceSet) missingTile(id string) *MissingTile {
+	if m, ok := s.MissingTiles[id]; ok {
+		return m
+	}
+	return s.MissingTile
+}
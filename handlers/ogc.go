@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// ogcTileMatrixSet is the identifier of the only tile matrix set that is
+// offered by the OGC API - Tiles endpoints.
+const ogcTileMatrixSet = "WebMercatorQuad"
+
+// ogcMaxZoom is the highest zoom level of the WebMercatorQuad tile matrix set.
+const ogcMaxZoom = 24
+
+var ogcConformance = []string{
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/json",
+	"http://www.opengis.net/spec/ogcapi-common-2/1.0/conf/collections",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tileset",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tilesets-list",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/geodata-tilesets",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/png",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/jpeg",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/mvt",
+	"http://www.opengis.net/spec/tms/2.0/conf/tilematrixset",
+	"http://www.opengis.net/spec/tms/2.0/conf/json-tilematrixset",
+}
+
+type ogcLink struct {
+	Href      string `json:"href"`
+	Rel       string `json:"rel"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+type ogcTileMatrix struct {
+	ID               string     `json:"id"`
+	ScaleDenominator float64    `json:"scaleDenominator"`
+	CellSize         float64    `json:"cellSize"`
+	CornerOfOrigin   string     `json:"cornerOfOrigin"`
+	PointOfOrigin    [2]float64 `json:"pointOfOrigin"`
+	TileWidth        int        `json:"tileWidth"`
+	TileHeight       int        `json:"tileHeight"`
+	MatrixWidth      uint64     `json:"matrixWidth"`
+	MatrixHeight     uint64     `json:"matrixHeight"`
+}
+
+type ogcTileMatrixLimits struct {
+	TileMatrix string `json:"tileMatrix"`
+	MinTileRow uint64 `json:"minTileRow"`
+	MaxTileRow uint64 `json:"maxTileRow"`
+	MinTileCol uint64 `json:"minTileCol"`
+	MaxTileCol uint64 `json:"maxTileCol"`
+}
+
+// webMercatorQuad returns the definition of the WebMercatorQuad tile matrix
+// set according to the OGC Two Dimensional Tile Matrix Set standard.
+func webMercatorQuad(self string) map[string]interface{} {
+	var matrices []ogcTileMatrix
+	for z := 0; z <= ogcMaxZoom; z++ {
+		matrices = append(matrices, ogcTileMatrix{
+			ID:               strconv.Itoa(z),
+			ScaleDenominator: wmtsScaleDenominator / math.Pow(2, float64(z)),
+			CellSize:         2 * mercatorOrigin / 256 / math.Pow(2, float64(z)),
+			CornerOfOrigin:   "topLeft",
+			PointOfOrigin:    [2]float64{-mercatorOrigin, mercatorOrigin},
+			TileWidth:        256,
+			TileHeight:       256,
+			MatrixWidth:      1 << uint(z),
+			MatrixHeight:     1 << uint(z),
+		})
+	}
+	return map[string]interface{}{
+		"id":                ogcTileMatrixSet,
+		"title":             "Google Maps Compatible for the World",
+		"uri":               "http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad",
+		"crs":               "http://www.opengis.net/def/crs/EPSG/0/3857",
+		"orderedAxes":       []string{"X", "Y"},
+		"wellKnownScaleSet": "http://www.opengis.net/def/wkss/OGC/1.0/GoogleMapsCompatible",
+		"tileMatrices":      matrices,
+		"links":             []ogcLink{{Href: self, Rel: "self", Type: "application/json"}},
+	}
+}
+
+// writeJSON marshals v and writes it to w.
+func writeJSON(w http.ResponseWriter, v interface{}) (int, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal JSON: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(bytes)
+	return http.StatusOK, err
+}
+
+// ogcDataType returns the OGC API - Tiles data type of the tiles of db.
+func ogcDataType(db Tileset) string {
+	if db.TileFormat() == mbtiles.PBF {
+		return "vector"
+	}
+	return "map"
+}
+
+// ogcCollection returns the description of the tileset db as collection.
+func ogcCollection(id string, db Tileset, root string) (map[string]interface{}, error) {
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+	}
+	title := toString(metadata["name"])
+	if title == "" {
+		title = id
+	}
+	collURL := root + "/collections/" + id
+	c := map[string]interface{}{
+		"id":          id,
+		"title":       title,
+		"description": toString(metadata["description"]),
+		"dataType":    ogcDataType(db),
+		"links": []ogcLink{
+			{Href: collURL, Rel: "self", Type: "application/json"},
+			{Href: collURL + "/tiles", Rel: "http://www.opengis.net/def/rel/ogc/1.0/tilesets-" + ogcDataType(db), Type: "application/json", Title: "Tilesets of " + title},
+		},
+	}
+	if bounds, ok := metadata["bounds"].([]float64); ok && len(bounds) == 4 {
+		c["extent"] = map[string]interface{}{
+			"spatial": map[string]interface{}{
+				"bbox": [][]float64{bounds},
+				"crs":  "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
+			},
+		}
+	}
+	return c, nil
+}
+
+// ogcTileset returns the description of the tileset db in the WebMercatorQuad
+// tile matrix set.
+func ogcTileset(id string, db Tileset, root string) (map[string]interface{}, error) {
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+	}
+	tsURL := root + "/collections/" + id + "/tiles/" + ogcTileMatrixSet
+	ts := map[string]interface{}{
+		"title":            toString(metadata["name"]),
+		"dataType":         ogcDataType(db),
+		"crs":              "http://www.opengis.net/def/crs/EPSG/0/3857",
+		"tileMatrixSetURI": "http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad",
+		"links": []ogcLink{
+			{Href: tsURL, Rel: "self", Type: "application/json"},
+			{Href: root + "/tileMatrixSets/" + ogcTileMatrixSet, Rel: "http://www.opengis.net/def/rel/ogc/1.0/tiling-scheme", Type: "application/json"},
+			{Href: tsURL + "/{tileMatrix}/{tileRow}/{tileCol}", Rel: "item", Type: db.TileFormat().ContentType(), Templated: true},
+		},
+	}
+	minZoom, okMin := metadata["minzoom"].(int)
+	maxZoom, okMax := metadata["maxzoom"].(int)
+	bounds, okBounds := metadata["bounds"].([]float64)
+	if okMin && okMax && okBounds && len(bounds) == 4 {
+		var limits []ogcTileMatrixLimits
+		for z := minZoom; z <= maxZoom && z <= ogcMaxZoom; z++ {
+			minX, minY := lonLatToMercator(bounds[0], bounds[1])
+			maxX, maxY := lonLatToMercator(bounds[2], bounds[3])
+			n := float64(uint64(1) << uint(z))
+			index := func(v float64) uint64 {
+				return uint64(math.Max(0, math.Min(math.Floor(v/(2*mercatorOrigin)*n), n-1)))
+			}
+			limits = append(limits, ogcTileMatrixLimits{
+				TileMatrix: strconv.Itoa(z),
+				MinTileRow: index(mercatorOrigin - maxY),
+				MaxTileRow: index(mercatorOrigin - minY),
+				MinTileCol: index(minX + mercatorOrigin),
+				MaxTileCol: index(maxX + mercatorOrigin),
+			})
+		}
+		ts["tileMatrixSetLimits"] = limits
+	}
+	return ts, nil
+}
+
+// OGCHandler returns a http.Handler that serves the tilesets of the ServiceSet
+// according to the OGC API - Tiles standard under "/ogc". The function ef is
+// called with any occuring error if it is non-nil, so it can be used for e.g.
+// logging with logging facitilies of the caller.
+func (s *ServiceSet) OGCHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(s.rebuilt(func(tilesets map[string]Tileset) http.Handler {
+		tiles := make(map[string]handlerFunc)
+		for id, db := range tilesets {
+			tiles[id] = s.intercepted(id, s.countRequests(id, db, s.policed(id, db, s.tiles(id, db))))
+		}
+		return wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+			root := s.RootURL(r) + "/ogc"
+			p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ogc"), "/")
+			switch p {
+			case "":
+				return writeJSON(w, map[string]interface{}{
+					"title":       "mbtileserver",
+					"description": "Tilesets served according to OGC API - Tiles",
+					"links": []ogcLink{
+						{Href: root, Rel: "self", Type: "application/json"},
+						{Href: root + "/conformance", Rel: "http://www.opengis.net/def/rel/ogc/1.0/conformance", Type: "application/json"},
+						{Href: root + "/collections", Rel: "http://www.opengis.net/def/rel/ogc/1.0/data", Type: "application/json"},
+						{Href: root + "/tileMatrixSets", Rel: "http://www.opengis.net/def/rel/ogc/1.0/tiling-schemes", Type: "application/json"},
+					},
+				})
+			case "conformance":
+				return writeJSON(w, map[string]interface{}{"conformsTo": ogcConformance})
+			case "tileMatrixSets":
+				return writeJSON(w, map[string]interface{}{
+					"tileMatrixSets": []map[string]interface{}{{
+						"id":    ogcTileMatrixSet,
+						"title": "Google Maps Compatible for the World",
+						"uri":   "http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad",
+						"links": []ogcLink{{Href: root + "/tileMatrixSets/" + ogcTileMatrixSet, Rel: "self", Type: "application/json"}},
+					}},
+				})
+			case "tileMatrixSets/" + ogcTileMatrixSet:
+				return writeJSON(w, webMercatorQuad(root+"/"+p))
+			case "collections":
+				collections := []map[string]interface{}{}
+				for id, db := range tilesets {
+					if status, _ := s.authorize(r, id); status != http.StatusOK {
+						continue
+					}
+					c, err := ogcCollection(id, db, root)
+					if err != nil {
+						return http.StatusInternalServerError, err
+					}
+					collections = append(collections, c)
+				}
+				return writeJSON(w, map[string]interface{}{
+					"collections": collections,
+					"links":       []ogcLink{{Href: root + "/collections", Rel: "self", Type: "application/json"}},
+				})
+			}
+
+			// we are expecting "collections", <id>, and optionally "tiles", <tms>, <z>, <y>, <x>
+			pcs := strings.Split(p, "/")
+			if len(pcs) < 2 || pcs[0] != "collections" {
+				return http.StatusNotFound, nil
+			}
+			// the id of a tileset may contain slashes
+			var id string
+			var db Tileset
+			rest := pcs[1:]
+			for i := len(rest); i > 0; i-- {
+				if t, ok := tilesets[strings.Join(rest[:i], "/")]; ok {
+					id, db, rest = strings.Join(rest[:i], "/"), t, rest[i:]
+					break
+				}
+			}
+			if db == nil {
+				return http.StatusNotFound, nil
+			}
+			if status, err := s.admit(w, r, id); status != http.StatusOK {
+				return status, err
+			}
+			switch {
+			case len(rest) == 0:
+				c, err := ogcCollection(id, db, root)
+				if err != nil {
+					return http.StatusInternalServerError, err
+				}
+				return writeJSON(w, c)
+			case len(rest) == 1 && rest[0] == "tiles":
+				ts, err := ogcTileset(id, db, root)
+				if err != nil {
+					return http.StatusInternalServerError, err
+				}
+				return writeJSON(w, map[string]interface{}{
+					"tilesets": []map[string]interface{}{ts},
+					"links":    []ogcLink{{Href: root + "/" + p, Rel: "self", Type: "application/json"}},
+				})
+			case len(rest) < 2 || rest[0] != "tiles" || rest[1] != ogcTileMatrixSet:
+				return http.StatusNotFound, nil
+			case len(rest) == 2:
+				ts, err := ogcTileset(id, db, root)
+				if err != nil {
+					return http.StatusInternalServerError, err
+				}
+				return writeJSON(w, ts)
+			case len(rest) == 5:
+				return s.serveTileAt(id, tiles[id], rest[2], rest[3], rest[4], w, r)
+			}
+			return http.StatusNotFound, nil
+		})
+	})))
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// vectorBuffer is the number of tile units by which the geometries of
+// overzoomed vector tiles extend beyond the tile extent, so that lines and
+// polygon outlines are rendered seamlessly across tile boundaries.
+const vectorBuffer = 64
+
+// canOverzoom returns whether tiles of format f can be overzoomed.
+func canOverzoom(f mbtiles.TileFormat) bool {
+	return f == mbtiles.PBF || canProcessRaster(f)
+}
+
+// overzoomTile creates the tile at tc, which lies beyond the maximum zoom
+// level maxZoom of db, from its ancestor tile at maxZoom. The tile data is returned together with its encoding.
+// mbtiles.ErrTileNotFound is returned if the ancestor tile does not exist.
+func (s *ServiceSet) overzoomTile(ctx context.Context, db Tileset, tc tileCoord, maxZoom uint8) ([]byte, mbtiles.TileEncoding, error) {
+	if db.TileFormat() == mbtiles.PBF {
+		return overzoomVector(ctx, db, tc, maxZoom)
+	}
+	data, err := s.overzoomRaster(ctx, db, tc, maxZoom)
+	return data, mbtiles.IDENTITY, err
+}
+
+// ancestor returns the column and the row of the ancestor of tc at zoom level
+// z, together with the column and the row of tc relative to the upper left
+// descendant of the ancestor at the zoom level of tc.
+func ancestor(tc tileCoord, z uint8) (x, y, dx, dy uint64) {
+	dz := tc.z - z
+	x, y = tc.x>>dz, tc.y>>dz
+	return x, y, tc.x - x<<dz, tc.y - y<<dz
+}
+
+// overzoomVector creates the vector tile at tc by scaling up and clipping the
+// geometries of its ancestor tile at maxZoom. The tile is compressed with gzip,
+// unless the tiles of db are uncompressed.
+func overzoomVector(ctx context.Context, db Tileset, tc tileCoord, maxZoom uint8) ([]byte, mbtiles.TileEncoding, error) {
+	x, y, dx, dy := ancestor(tc, maxZoom)
+	var data []byte
+	err := readTileDecompressed(ctx, db, maxZoom, x, y, &data)
+	if err != nil {
+		return nil, mbtiles.IDENTITY, err
+	}
+	if len(data) == 0 {
+		return nil, mbtiles.IDENTITY, mbtiles.ErrTileNotFound
+	}
+	parent, err := mvt.Decode(data)
+	if err != nil {
+		return nil, mbtiles.IDENTITY, err
+	}
+	data, err = mvt.Encode(parent.Overzoom(tc.z-maxZoom, dx, dy, vectorBuffer))
+	if err != nil || db.TileEncoding() == mbtiles.IDENTITY {
+		return data, mbtiles.IDENTITY, err
+	}
+	data, err = gzipBytes(data)
+	return data, mbtiles.GZIPENC, err
+}
+
+// overzoomRaster creates the raster tile at tc, which lies beyond the maximum
+// zoom level maxZoom of db, by cropping the corresponding part of its
+// ancestor tile at maxZoom and scaling it up to the size of the ancestor.
+// mbtiles.ErrTileNotFound is returned if the
+// ancestor tile does not exist.
+func (s *ServiceSet) overzoomRaster(ctx context.Context, db Tileset, tc tileCoord, maxZoom uint8) ([]byte, error) {
+	dz := tc.z - maxZoom
+	x, y, dx, dy := ancestor(tc, maxZoom)
+	var data []byte
+	err := db.ReadTileContext(ctx, maxZoom, x, y, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= 1 {
+		return nil, mbtiles.ErrTileNotFound
+	}
+	parent, err := decodeTile(data, db.TileFormat())
+	if err != nil {
+		return nil, err
+	}
+	b := parent.Bounds()
+	size := b.Dx() >> dz
+	if size < 1 {
+		return nil, fmt.Errorf("cannot overzoom %d levels beyond tile of %d pixels", dz, b.Dx())
+	}
+	ox := int(dx) * size
+	oy := int(dy) * size
+	r := image.Rect(ox, oy, ox+size, oy+size).Add(b.Min)
+	return encodeTile(resample(parent, r, b.Dx(), b.Dy()), db.TileFormat(), s.ImageQuality)
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache"
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+)
+
+// PeerCacheBasePath is the path below which a PeerCache answers the requests
+// of its peers.
+const PeerCacheBasePath = "/_groupcache/"
+
+// peerReplicas is the number of points of each peer on the consistent hash.
+const peerReplicas = 50
+
+// peerCaches maps the names of groupcache groups to the PeerCaches that own
+// them, as groupcache allows to register only a single PeerPicker per process.
+var (
+	peerCachesMu   sync.Mutex
+	peerCaches     = make(map[string]*PeerCache)
+	peerPickerOnce sync.Once
+)
+
+// errNotCacheable is returned by the groupcache getter of a PeerCache for
+// responses that are not cached, like errors and missing tiles.
+var errNotCacheable = errors.New("response is not cacheable")
+
+// PeerCacheStats contains the statistics of a PeerCache.
+type PeerCacheStats struct {
+	Gets int64 `json:"gets"`
+	Hits int64 `json:"hits"`
+	// PeerLoads and PeerErrors count the responses that were fetched from
+	// the peer owning them and the errors doing so.
+	PeerLoads  int64 `json:"peerLoads"`
+	PeerErrors int64 `json:"peerErrors"`
+	// LocalLoads counts the responses that were read from the local tilesets.
+	LocalLoads int64 `json:"localLoads"`
+	// PeerRequests counts the requests of peers.
+	PeerRequests int64 `json:"peerRequests"`
+	Items        int64 `json:"items"`
+	Bytes        int64 `json:"bytes"`
+}
+
+// PeerCache is a cache of responses that is sharded across the memory of a
+// set of peers, which serve the same tilesets. Each response is owned by a
+// single peer, selected by consistent hashing, which reads it from its
+// tileset and keeps it in memory, while the other peers fetch it from the
+// owner. The peers answer each other below PeerCacheBasePath, but only
+// requests that carry the Secret or, without a Secret, come from the
+// addresses of the peers. It is safe for concurrent use.
+type PeerCache struct {
+	// Secret, if not empty, is shared by the peers and sent with their
+	// requests. It must be set before the cache is used.
+	Secret string
+
+	name   string
+	self   string
+	group  *groupcache.Group
+	client *http.Client
+
+	mu      sync.Mutex
+	peers   *consistenthash.Map
+	getters map[string]*peerGetter
+	// hosts are the host names and IP addresses of the peers
+	hosts map[string]bool
+	// tilesets maps the tileset IDs and the kinds of endpoints to the
+	// functions reading their responses
+	tilesets map[[2]string]peerTileset
+}
+
+// peerTileset is a tileset of a ServiceSet that is served by a PeerCache.
+type peerTileset struct {
+	s    *ServiceSet
+	db   Tileset
+	load func(*http.Request) cacheResult
+}
+
+// peerKey is the request for a response that is sent to its owner, which is
+// JSON encoded as groupcache key.
+type peerKey struct {
+	ID       string `json:"id"`
+	Kind     string `json:"k"`
+	Stamp    int64  `json:"t"`
+	Root     string `json:"r"`
+	Path     string `json:"p"`
+	Query    string `json:"q,omitempty"`
+	Accept   string `json:"a,omitempty"`
+	Encoding string `json:"e,omitempty"`
+}
+
+// peerLoad is the groupcache context of the requests of a PeerCache, which
+// receives the responses that are read locally, including those that are not
+// cached.
+type peerLoad struct {
+	res  cacheResult
+	done bool
+}
+
+// NewPeerCache returns a PeerCache that holds up to maxBytes bytes of the
+// responses it owns. self is the base URL of this server, like
+// "http://10.0.0.1:8000", under which its peers reach it. name identifies
+// the cache among the peers and must be the same for all of them and unique
+// within the process.
+func NewPeerCache(name, self string, maxBytes int64) (*PeerCache, error) {
+	if _, err := url.Parse(self); err != nil || self == "" {
+		return nil, fmt.Errorf("invalid peer URL %q", self)
+	}
+	c := &PeerCache{
+		name:     name,
+		self:     strings.TrimSuffix(self, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		peers:    consistenthash.New(peerReplicas, nil),
+		getters:  make(map[string]*peerGetter),
+		tilesets: make(map[[2]string]peerTileset),
+	}
+	peerCachesMu.Lock()
+	defer peerCachesMu.Unlock()
+	if _, ok := peerCaches[name]; ok || groupcache.GetGroup(name) != nil {
+		return nil, fmt.Errorf("peer cache %s already exists", name)
+	}
+	peerPickerOnce.Do(func() {
+		groupcache.RegisterPerGroupPeerPicker(func(name string) groupcache.PeerPicker {
+			peerCachesMu.Lock()
+			defer peerCachesMu.Unlock()
+			if c, ok := peerCaches[name]; ok {
+				return c
+			}
+			return groupcache.NoPeers{}
+		})
+	})
+	peerCaches[name] = c
+	c.group = groupcache.NewGroup(name, maxBytes, groupcache.GetterFunc(c.get))
+	return c, nil
+}
+
+// Set replaces the peers with the servers at the base URLs peers, which may
+// include the URL of this server.
+func (c *PeerCache) Set(peers ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers = consistenthash.New(peerReplicas, nil)
+	c.getters = make(map[string]*peerGetter, len(peers))
+	c.hosts = make(map[string]bool, len(peers))
+	for _, p := range peers {
+		p = strings.TrimSuffix(p, "/")
+		c.peers.Add(p)
+		c.getters[p] = &peerGetter{cache: c, baseURL: p + PeerCacheBasePath}
+		if u, err := url.Parse(p); err == nil {
+			c.hosts[u.Hostname()] = true
+		}
+	}
+}
+
+// SetResolved replaces the peers with the servers at the base URLs peers,
+// like Set. The hosts of URLs with the scheme "dns+http" or "dns+https" are
+// looked up and replaced by all their addresses, e.g.
+// "dns+http://tiles.internal:8000" may result in the peers
+// "http://10.0.0.1:8000" and "http://10.0.0.2:8000".
+func (c *PeerCache) SetResolved(ctx context.Context, peers []string) error {
+	var resolved []string
+	for _, p := range peers {
+		u, err := url.Parse(p)
+		if err != nil {
+			return fmt.Errorf("invalid peer URL %q", p)
+		}
+		if !strings.HasPrefix(u.Scheme, "dns+") {
+			resolved = append(resolved, p)
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+		if err != nil {
+			return fmt.Errorf("cannot resolve peers %s: %v", p, err)
+		}
+		for _, addr := range addrs {
+			host := addr
+			if u.Port() != "" {
+				host = net.JoinHostPort(addr, u.Port())
+			} else if strings.Contains(addr, ":") {
+				host = "[" + addr + "]"
+			}
+			resolved = append(resolved, strings.TrimPrefix(u.Scheme, "dns+")+"://"+host)
+		}
+	}
+	c.Set(resolved...)
+	return nil
+}
+
+// PickPeer returns the peer that owns key, unless it is this server. It
+// implements groupcache.PeerPicker.
+func (c *PeerCache) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peers.IsEmpty() {
+		return nil, false
+	}
+	if p := c.peers.Get(key); p != c.self {
+		return c.getters[p], true
+	}
+	return nil, false
+}
+
+// Stats returns the statistics of the cache.
+func (c *PeerCache) Stats() PeerCacheStats {
+	main := c.group.CacheStats(groupcache.MainCache)
+	hot := c.group.CacheStats(groupcache.HotCache)
+	return PeerCacheStats{
+		Gets:         c.group.Stats.Gets.Get(),
+		Hits:         c.group.Stats.CacheHits.Get(),
+		PeerLoads:    c.group.Stats.PeerLoads.Get(),
+		PeerErrors:   c.group.Stats.PeerErrors.Get(),
+		LocalLoads:   c.group.Stats.LocalLoads.Get(),
+		PeerRequests: c.group.Stats.ServerRequests.Get(),
+		Items:        main.Items + hot.Items,
+		Bytes:        main.Bytes + hot.Bytes,
+	}
+}
+
+// ServeHTTP answers the requests of the peers for the responses owned by this
+// server at "<PeerCacheBasePath><name>/<key>". The name is not checked, as the
+// handler serves a single cache. The responses are read without the checks of
+// the credentials of the ServiceSet, so all other requests are forbidden.
+func (c *PeerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.fromPeer(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, PeerCacheBasePath), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	c.group.Stats.ServerRequests.Add(1)
+	var value []byte
+	if err := c.group.Get(nil, parts[1], groupcache.AllocatingByteSliceSink(&value)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := proto.Marshal(&pb.GetResponse{Value: value})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(body)
+}
+
+// fromPeer reports whether the request r has been sent by a peer: it carries
+// the Secret or, without a Secret, comes from the address of a peer. Host names
+// of peers are looked up.
+func (c *PeerCache) fromPeer(r *http.Request) bool {
+	if c.Secret != "" {
+		auth := r.Header.Get("Authorization")
+		return strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(c.Secret)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	c.mu.Lock()
+	hosts := c.hosts
+	c.mu.Unlock()
+	for h := range hosts {
+		if peerIP := net.ParseIP(h); peerIP != nil {
+			if peerIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(r.Context(), h)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// register sets the function that reads the responses of the endpoint kind
+// for the tileset id of s from db.
+func (c *PeerCache) register(s *ServiceSet, id, kind string, db Tileset, load func(*http.Request) cacheResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tilesets[[2]string{id, kind}] = peerTileset{s: s, db: db, load: load}
+}
+
+// load returns the response to r with the root URL root of the endpoint kind
+// for the tileset id in db from the peer that owns it, or reads it with load.
+func (c *PeerCache) load(r *http.Request, root, id, kind string, db Tileset, load func(*http.Request) cacheResult) cacheResult {
+	key, err := json.Marshal(peerKey{
+		ID:       id,
+		Kind:     kind,
+		Stamp:    db.TimeStamp().Unix(),
+		Root:     root,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		Accept:   r.Header.Get("Accept"),
+		Encoding: r.Header.Get("Accept-Encoding"),
+	})
+	if err != nil {
+		return load(r)
+	}
+	l := &peerLoad{}
+	var data []byte
+	err = c.group.Get(l, string(key), groupcache.AllocatingByteSliceSink(&data))
+	if err == nil {
+		if resp, err := unmarshalResponse(data); err == nil {
+			return cacheResult{resp, http.StatusOK, nil}
+		}
+	}
+	if l.done {
+		return l.res
+	}
+	return load(r)
+}
+
+// get reads the response for the groupcache key from the local tileset. It
+// implements groupcache.Getter.
+func (c *PeerCache) get(ctx groupcache.Context, key string, dest groupcache.Sink) error {
+	var k peerKey
+	if err := json.Unmarshal([]byte(key), &k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	t, ok := c.tilesets[[2]string{k.ID, k.Kind}]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("tileset %s is not served", k.ID)
+	}
+	// the DB must not be closed while it is read
+	tilesets, gen := t.s.acquire()
+	defer t.s.release(gen)
+	if tilesets[k.ID] != t.db || t.db.TimeStamp().Unix() != k.Stamp {
+		return fmt.Errorf("tileset %s is not served in this version", k.ID)
+	}
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return err
+	}
+	r.URL.Path, r.URL.RawQuery = k.Path, k.Query
+	if u, err := url.Parse(k.Root); err == nil {
+		r.Host = u.Host
+	}
+	r = withRootURL(r, k.Root)
+	if k.Accept != "" {
+		r.Header.Set("Accept", k.Accept)
+	}
+	if k.Encoding != "" {
+		r.Header.Set("Accept-Encoding", k.Encoding)
+	}
+	res := t.load(r)
+	if l, ok := ctx.(*peerLoad); ok {
+		l.res, l.done = res, true
+	}
+	if !res.cacheable() {
+		return errNotCacheable
+	}
+	data, err := marshalResponse(res.resp)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(data)
+}
+
+// peerGetter requests responses from a peer. It implements
+// groupcache.ProtoGetter.
+type peerGetter struct {
+	cache   *PeerCache
+	baseURL string
+}
+
+func (g *peerGetter) Get(ctx groupcache.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	u := g.baseURL + url.PathEscape(in.GetGroup()) + "/" + url.PathEscape(in.GetKey())
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if g.cache.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+g.cache.Secret)
+	}
+	resp, err := g.cache.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, resp.Body); err != nil {
+		return fmt.Errorf("cannot read peer response: %v", err)
+	}
+	return proto.Unmarshal(b.Bytes(), out)
+}
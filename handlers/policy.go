@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TilesetPolicy overrides the settings of the ServiceSet for a tileset.
+type TilesetPolicy struct {
+	// Name, if not empty, replaces the name of the tileset in TileJSON and
+	// the service listing.
+	Name string
+	// Attribution, if not empty, replaces the attribution of the tileset in
+	// TileJSON.
+	Attribution string
+	// CacheTTL, if not zero, is the max-age of the Cache-Control header of
+	// the responses for the tiles, grids and TileJSON of the tileset.
+	CacheTTL time.Duration
+	// Public serves the tileset without the credentials that are required
+	// by the ServiceSet. The ACLs still apply.
+	Public bool
+	// Keys, if not empty, are the API keys of which requests for the
+	// tileset must carry one, like the Keys of an ACL.
+	Keys []string
+	// MinZoom and MaxZoom, if not nil, restrict the zoom levels of the
+	// tileset. Tiles at other zoom levels are answered like missing tiles.
+	MinZoom, MaxZoom *int
+}
+
+// SetPolicies replaces the TilesetPolicies of the ServiceSet by policies,
+// whose keys are the IDs of the tilesets they apply to. The handlers are
+// created again for the new policies, so that responses that have been
+// cached by the ResponseCache in memory are no longer used.
+func (s *ServiceSet) SetPolicies(policies map[string]*TilesetPolicy) {
+	s.mu.Lock()
+	s.policies = policies
+	s.mu.Unlock()
+	s.update(func(map[string]Tileset) {})
+}
+
+// policy returns the TilesetPolicy of the tileset id or nil.
+func (s *ServiceSet) policy(id string) *TilesetPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policies[id]
+}
+
+// zooms returns the zoom range from minZoom to maxZoom restricted by the
+// policy p.
+func (p *TilesetPolicy) zooms(minZoom, maxZoom int) (int, int) {
+	if p == nil {
+		return minZoom, maxZoom
+	}
+	if p.MinZoom != nil && *p.MinZoom > minZoom {
+		minZoom = *p.MinZoom
+	}
+	if p.MaxZoom != nil && *p.MaxZoom < maxZoom {
+		maxZoom = *p.MaxZoom
+	}
+	return minZoom, maxZoom
+}
+
+// allowsZoom reports whether the policy p allows the tiles at zoom level z.
+func (p *TilesetPolicy) allowsZoom(z int) bool {
+	minZoom, maxZoom := p.zooms(0, 255)
+	return z >= minZoom && z <= maxZoom
+}
+
+// apply replaces the name, attribution and zoom levels of the TileJSON
+// or metadata out as required by the policy p.
+func (p *TilesetPolicy) apply(out map[string]interface{}) {
+	if p == nil {
+		return
+	}
+	if p.Name != "" {
+		out["name"] = p.Name
+	}
+	if p.Attribution != "" {
+		out["attribution"] = p.Attribution
+	}
+	if z, ok := out["minzoom"].(int); p.MinZoom != nil && (!ok || z < *p.MinZoom) {
+		out["minzoom"] = *p.MinZoom
+	}
+	if z, ok := out["maxzoom"].(int); p.MaxZoom != nil && (!ok || z > *p.MaxZoom) {
+		out["maxzoom"] = *p.MaxZoom
+	}
+}
+
+// checkPolicy checks the request r for the tileset id against the Keys of its
+// TilesetPolicy p like checkACLs.
+func (s *ServiceSet) checkPolicy(r *http.Request, id string, p *TilesetPolicy) (int, error) {
+	if p == nil || len(p.Keys) == 0 {
+		return http.StatusOK, nil
+	}
+	a := ACL{Keys: p.Keys}
+	if status := a.check(r, nil); status != http.StatusOK {
+		return status, fmt.Errorf("request from %s denied by policy for tileset %s", s.clientIP(r), id)
+	}
+	return http.StatusOK, nil
+}
+
+// policed returns a handlerFunc that serves the requests for the tileset id
+// with hf, as restricted by its TilesetPolicy: requests for tiles beyond its
+// zoom levels are answered like missing tiles, and the responses get its
+// CacheTTL. The other handlers that read tiles check the zoom levels of the
+// policy themselves.
+func (s *ServiceSet) policed(id string, db Tileset, hf handlerFunc) handlerFunc {
+	p := s.policy(id)
+	if p == nil {
+		return hf
+	}
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if t, ok := tileRequestFrom(id, r.URL.Path, s.Scheme); ok {
+			if !p.allowsZoom(int(t.Z)) {
+				if t.Ext == ".json" {
+					return notFoundJSON(w, "Grid does not exist")
+				}
+				return s.missingTile(id).write(w, r, db.TileFormat())
+			}
+		}
+		if p.CacheTTL > 0 {
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(p.CacheTTL/time.Second)))
+		}
+		return hf(w, r)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rootURLKey is the context key of the root URL of requests that are answered
+// on behalf of another request, like those of the peers of a PeerCache.
+type rootURLKey struct{}
+
+// withRootURL returns a copy of r whose root URL is root.
+func withRootURL(r *http.Request, root string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), rootURLKey{}, root))
+}
+
+// ParseTrustedProxies parses the IP addresses, like "10.0.0.1", and networks
+// in CIDR notation, like "10.0.0.0/8", of trusted reverse proxies. "*" trusts
+// all sources, like the networks 0.0.0.0/0 and ::/0.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if p == "*" {
+			nets = append(nets,
+				&net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8*net.IPv4len)},
+				&net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)},
+			)
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustsProxy reports whether ip, the address of the sender of a request, is
+// one of the TrustedProxies. Networks of all addresses, like 0.0.0.0/0, also
+// trust senders without an IP address, like the clients of unix domain
+// sockets.
+func (s *ServiceSet) trustsProxy(ip net.IP) bool {
+	for _, n := range s.TrustedProxies {
+		if ip == nil && allAddresses(n) || ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsForwarder reports whether ip, an address in the X-Forwarded-For
+// header of a request, is one of the TrustedProxies. Networks of all
+// addresses are ignored, as they only trust the sender of the request, not
+// the addresses it forwards.
+func (s *ServiceSet) trustsForwarder(ip net.IP) bool {
+	for _, n := range s.TrustedProxies {
+		if ip != nil && !allAddresses(n) && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allAddresses reports whether the network n contains all addresses.
+func allAddresses(n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	return ones == 0
+}
+
+// fromTrustedProxy reports whether the request r has been sent by one of the
+// TrustedProxies.
+func (s *ServiceSet) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return s.trustsProxy(net.ParseIP(host))
+}
+
+// forwardedClientIP returns the address of the client of the request r. For
+// requests from TrustedProxies, it is the last address in the X-Forwarded-For
+// header that is not the one of a trusted proxy: proxies append the address
+// they received the request from, while the addresses before it may have been
+// made up by the client.
+func (s *ServiceSet) forwardedClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !s.trustsProxy(ip) {
+		return ip
+	}
+	if f := strings.Join(r.Header["X-Forwarded-For"], ","); f != "" {
+		addrs := strings.Split(f, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip = net.ParseIP(strings.TrimSpace(addrs[i]))
+			if !s.trustsForwarder(ip) {
+				break
+			}
+		}
+	}
+	return ip
+}
+
+// forwarded returns the scheme, host and path prefix of the original request
+// that has been forwarded as r by a reverse proxy, from the Forwarded header
+// or the X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix headers.
+// Values that have not been forwarded are empty.
+func forwarded(r *http.Request) (proto, host, prefix string) {
+	if f := r.Header.Get("Forwarded"); f != "" {
+		// only the first element, which has been added by the first proxy
+		for _, pair := range strings.Split(strings.SplitN(f, ",", 2)[0], ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v := strings.Trim(kv[1], `"`)
+			switch strings.ToLower(kv[0]) {
+			case "proto":
+				proto = v
+			case "host":
+				host = v
+			}
+		}
+	}
+	first := func(name string) string {
+		return strings.TrimSpace(strings.SplitN(r.Header.Get(name), ",", 2)[0])
+	}
+	if proto == "" {
+		proto = first("X-Forwarded-Proto")
+	}
+	if host == "" {
+		host = first("X-Forwarded-Host")
+	}
+	prefix = first("X-Forwarded-Prefix")
+	proto = strings.ToLower(proto)
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if strings.ContainsAny(host, "/\\ \"'<>@") {
+		host = ""
+	}
+	if u, err := url.Parse(prefix); err != nil || u.Path != prefix {
+		prefix = ""
+	}
+	return proto, host, prefix
+}
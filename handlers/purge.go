@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Default endpoints of the CDN purge APIs.
+const (
+	FastlyAPI     = "https://api.fastly.com"
+	CloudflareAPI = "https://api.cloudflare.com/client/v4"
+)
+
+// surrogateKey returns the surrogate key of the responses for the tileset
+// id, or only for its zoom level z if z is not negative.
+func surrogateKey(id string, z int) string {
+	if z >= 0 {
+		return fmt.Sprintf("tileset:%s:z%d", id, z)
+	}
+	return "tileset:" + id
+}
+
+// surrogate returns a handlerFunc that tags the responses of hf for the
+// tileset id with their surrogate keys in the Surrogate-Key header of Fastly
+// and the Cache-Tag header of Cloudflare, so that they can be purged from
+// CDNs by tileset and zoom level.
+func (s *ServiceSet) surrogate(id string, hf handlerFunc) handlerFunc {
+	prefix := "/services/" + id + "/tiles/"
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		z := -1
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			p := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)[0]
+			if v, err := strconv.ParseUint(p, 10, 8); err == nil {
+				z = int(v)
+			}
+		}
+		keys := []string{surrogateKey(id, -1)}
+		if z >= 0 {
+			keys = append(keys, surrogateKey(id, z))
+		}
+		w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+		w.Header().Set("Cache-Tag", strings.Join(keys, ","))
+		return hf(w, r)
+	}
+}
+
+// Purger purges the responses tagged with surrogate keys from a CDN.
+type Purger interface {
+	Purge(ctx context.Context, keys []string) error
+}
+
+// FastlyPurger purges responses from the Fastly service ServiceID by their
+// Surrogate-Key headers.
+type FastlyPurger struct {
+	ServiceID string
+	// Token is the Fastly API token with the purge_select scope.
+	Token string
+	// Endpoint is the URL of the Fastly API, FastlyAPI if it is empty.
+	Endpoint string
+	// Client is used for the requests to the API, http.DefaultClient if it
+	// is nil.
+	Client *http.Client
+}
+
+// Purge purges the responses with any of the keys.
+func (p *FastlyPurger) Purge(ctx context.Context, keys []string) error {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = FastlyAPI
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/service/%s/purge", endpoint, p.ServiceID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", p.Token)
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	return doPurge(ctx, p.Client, req, "Fastly")
+}
+
+// CloudflarePurger purges responses from the Cloudflare zone ZoneID by their
+// Cache-Tag headers.
+type CloudflarePurger struct {
+	ZoneID string
+	// Token is a Cloudflare API token with the permission to purge the zone.
+	Token string
+	// Endpoint is the URL of the Cloudflare API, CloudflareAPI if it is
+	// empty.
+	Endpoint string
+	// Client is used for the requests to the API, http.DefaultClient if it
+	// is nil.
+	Client *http.Client
+}
+
+// Purge purges the responses with any of the keys.
+func (p *CloudflarePurger) Purge(ctx context.Context, keys []string) error {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = CloudflareAPI
+	}
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/zones/%s/purge_cache", endpoint, p.ZoneID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	req.Header.Set("Content-Type", "application/json")
+	return doPurge(ctx, p.Client, req, "Cloudflare")
+}
+
+// doPurge sends the purge request req to the API of the CDN.
+func doPurge(ctx context.Context, client *http.Client, req *http.Request, cdn string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("cannot purge %s: %v", cdn, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cannot purge %s: %s: %s", cdn, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Purge invalidates the cached responses for the tileset id, or only those
+// for its zoom level z if z is not negative, and purges them from the CDNs
+// of the Purgers. Apart from the tile cache of the tileset, the internal caches
+// are invalidated as a whole. Responses in a SharedCache or PeerCache are not
+// invalidated, as they are only shared for the same version of the tileset.
+func (s *ServiceSet) Purge(ctx context.Context, id string, z int) error {
+	db, ok := s.dbs()[id]
+	if !ok {
+		return fmt.Errorf("no tileset at path %q", id)
+	}
+	if c, ok := db.(cachingTileset); ok {
+		c.PurgeCache(z)
+	}
+	// the handlers are created again with empty caches
+	s.update(func(map[string]Tileset) {})
+	return s.purgeCDNs(ctx, []string{surrogateKey(id, z)})
+}
+
+// purgeCDNs purges the responses with any of the keys from the CDNs of the
+// Purgers.
+func (s *ServiceSet) purgeCDNs(ctx context.Context, keys []string) error {
+	for _, p := range s.Purgers {
+		if err := p.Purge(ctx, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purge serves the purge requests for the tileset in the "tileset" query
+// parameter, and optionally only its zoom level in the "z" query parameter.
+func (s *ServiceSet) purge(w http.ResponseWriter, r *http.Request) (int, error) {
+	q := r.URL.Query()
+	id := q.Get("tileset")
+	if _, ok := s.dbs()[id]; !ok {
+		return notFoundJSON(w, "Tileset does not exist")
+	}
+	z := -1
+	if v := q.Get("z"); v != "" {
+		zoom, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid zoom level %q", v)
+		}
+		z = int(zoom)
+	}
+	if err := s.Purge(r.Context(), id, z); err != nil {
+		return http.StatusBadGateway, err
+	}
+	return writeJSON(w, map[string]string{"tileset": id, "key": surrogateKey(id, z)})
+}
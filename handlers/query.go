@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// queryTolerance is the default distance in pixels of a 256 pixel tile within
+// which features are found by a point query.
+const queryTolerance = 3
+
+// maxMercatorLat is the latitude of the northern edge of the web mercator
+// tile grid.
+const maxMercatorLat = 85.0511287798066
+
+// queryFloat parses the query parameter key of r as floating point number,
+// which defaults to def if it is not given.
+func queryFloat(r *http.Request, key string, def float64) (float64, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return f, nil
+}
+
+// queryLonLat parses the required "lon" and "lat" query parameters of r.
+func queryLonLat(r *http.Request) (lon, lat float64, err error) {
+	q := r.URL.Query()
+	if q.Get("lon") == "" || q.Get("lat") == "" {
+		return 0, 0, fmt.Errorf("missing lon or lat")
+	}
+	if lon, err = queryFloat(r, "lon", 0); err != nil {
+		return 0, 0, err
+	}
+	if lat, err = queryFloat(r, "lat", 0); err != nil {
+		return 0, 0, err
+	}
+	if lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("position %v, %v is out of bounds", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// tileAt returns the coordinates of the tile at zoom level z that contains the
+// position lon, lat, and the position within the tile as fractions of its
+// width and height.
+func tileAt(lon, lat float64, z uint8) (tc tileCoord, fx, fy float64) {
+	n := math.Exp2(float64(z))
+	lat = math.Max(-maxMercatorLat, math.Min(maxMercatorLat, lat))
+	x := (lon + 180) / 360 * n
+	sin := math.Sin(lat * math.Pi / 180)
+	y := (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * n
+	x = math.Max(0, math.Min(n-1e-9, x))
+	y = math.Max(0, math.Min(n-1e-9, y))
+	tc = tileCoord{z: z, x: uint64(x), y: uint64(y)}
+	return tc, x - math.Floor(x), y - math.Floor(y)
+}
+
+// pointQuery serves the features of a vector tileset at
+// "/services/<id>/query?lon=<lon>&lat=<lat>" as GeoJSON. The features are
+// taken from the tile at the zoom level given by the "zoom" parameter, which
+// defaults to the maximum zoom level of the tileset, and must be within the
+// "tolerance" in pixels of the position. The features can be filtered like
+// the vector tiles.
+func (s *ServiceSet) pointQuery(id string, db Tileset) handlerFunc {
+	minZoom, maxZoom := s.policy(id).zooms(zoomRange(db))
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		lon, lat, err := queryLonLat(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		zoom, err := queryFloat(r, "zoom", float64(maxZoom))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		tolerance, err := queryFloat(r, "tolerance", queryTolerance)
+		if err != nil || tolerance < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid tolerance %q", r.URL.Query().Get("tolerance"))
+		}
+		vf, err := parseVectorFilter(r.URL.Query())
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		// the tiles beyond the zoom range of the tileset do not exist
+		z := int(math.Max(float64(minZoom), math.Min(float64(maxZoom), math.Floor(zoom))))
+		tc, fx, fy := tileAt(lon, lat, uint8(z))
+
+		fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+		if minZoom > maxZoom {
+			// the TilesetPolicy allows none of the zoom levels of the tileset
+			return writeJSON(w, fc)
+		}
+		if status := s.interceptRead(r, id, db, tc); status != 0 {
+			return status, nil
+		}
+		var data []byte
+		err = readTileDecompressed(r.Context(), db, tc.z, tc.x, tc.y, &data)
+		switch {
+		case err == mbtiles.ErrTileNotFound || err == nil && len(data) == 0:
+			return writeJSON(w, fc)
+		case err == mbtiles.ErrQueryTimeout:
+			return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		case err != nil:
+			return http.StatusInternalServerError, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		}
+		tile, err := mvt.Decode(data)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot decode vector tile: %v", err)
+		}
+		for _, l := range tile.Layers {
+			extent := float64(l.Extent)
+			p := [2]float64{fx * extent, fy * extent}
+			tol := tolerance * extent / 256
+			features := l.Features[:0]
+			for _, f := range l.Features {
+				if featureNear(f, p, tol) {
+					features = append(features, f)
+				}
+			}
+			l.Features = features
+		}
+		if vf != nil {
+			vf.apply(tile)
+		}
+		return writeJSON(w, toGeoJSON(tile, tileToWGS84(tc)))
+	}
+}
+
+// featureNear reports whether the geometry of f contains the position p in
+// tile units or lies within the distance tol of it.
+func featureNear(f *mvt.Feature, p [2]float64, tol float64) bool {
+	switch f.Type {
+	case mvt.Point:
+		for _, part := range f.Geometry {
+			for _, c := range part {
+				if math.Hypot(float64(c.X)-p[0], float64(c.Y)-p[1]) <= tol {
+					return true
+				}
+			}
+		}
+	case mvt.LineString, mvt.Polygon:
+		closed := f.Type == mvt.Polygon
+		inside := false
+		for _, part := range f.Geometry {
+			n := len(part)
+			for i := 0; i < n; i++ {
+				if i == n-1 && !closed {
+					break
+				}
+				a, b := part[i], part[(i+1)%n]
+				if segmentDistance(a, b, p) <= tol {
+					return true
+				}
+				// even-odd rule, so that holes are excluded
+				ay, by := float64(a.Y), float64(b.Y)
+				if closed && (ay > p[1]) != (by > p[1]) {
+					x := float64(a.X) + (p[1]-ay)/(by-ay)*float64(b.X-a.X)
+					if p[0] < x {
+						inside = !inside
+					}
+				}
+			}
+			if n == 1 && math.Hypot(float64(part[0].X)-p[0], float64(part[0].Y)-p[1]) <= tol {
+				return true
+			}
+		}
+		return inside
+	}
+	return false
+}
+
+// segmentDistance returns the distance between the position p and the line
+// segment from a to b.
+func segmentDistance(a, b mvt.Coord, p [2]float64) float64 {
+	ax, ay := float64(a.X), float64(a.Y)
+	dx, dy := float64(b.X)-ax, float64(b.Y)-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((p[0]-ax)*dx+(p[1]-ay)*dy)/l))
+	}
+	return math.Hypot(ax+t*dx-p[0], ay+t*dy-p[1])
+}
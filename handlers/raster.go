@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// DefaultImageQuality is the quality of lossy raster tiles that are encoded by
+// the server, if the ServiceSet does not specify one.
+const DefaultImageQuality = 90
+
+// ImageDecoder decodes a raster tile.
+type ImageDecoder func(r io.Reader) (image.Image, error)
+
+// ImageEncoder encodes img as raster tile. The quality ranges from 1 to 100
+// and may be ignored by lossless formats.
+type ImageEncoder func(w io.Writer, img image.Image, quality int) error
+
+type imageCodec struct {
+	decode ImageDecoder
+	encode ImageEncoder
+}
+
+var imageCodecs = map[mbtiles.TileFormat]imageCodec{
+	mbtiles.PNG: {
+		decode: png.Decode,
+		encode: func(w io.Writer, img image.Image, quality int) error {
+			return png.Encode(w, img)
+		},
+	},
+	mbtiles.JPG: {
+		decode: jpeg.Decode,
+		encode: func(w io.Writer, img image.Image, quality int) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+		},
+	},
+}
+
+// RegisterImageCodec registers a decoder and an encoder for raster tiles of
+// format f, so that the server can process and convert them, e.g. for WebP
+// tiles. Either of dec and enc may be nil, in which case the existing one is
+// kept. RegisterImageCodec is not safe for concurrent use and should be called
+// before any ServiceSet is created.
+func RegisterImageCodec(f mbtiles.TileFormat, dec ImageDecoder, enc ImageEncoder) {
+	c := imageCodecs[f]
+	if dec != nil {
+		c.decode = dec
+	}
+	if enc != nil {
+		c.encode = enc
+	}
+	imageCodecs[f] = c
+}
+
+// canProcessRaster reports whether tiles of format f can be decoded and
+// encoded by the server, which is required for processing them.
+func canProcessRaster(f mbtiles.TileFormat) bool {
+	return canConvert(f, f)
+}
+
+// canConvert reports whether raster tiles of format from can be converted to
+// format to.
+func canConvert(from, to mbtiles.TileFormat) bool {
+	return imageCodecs[from].decode != nil && imageCodecs[to].encode != nil
+}
+
+// decodeTile decodes the raster tile data of format f.
+func decodeTile(data []byte, f mbtiles.TileFormat) (image.Image, error) {
+	dec := imageCodecs[f].decode
+	if dec == nil {
+		return nil, fmt.Errorf("cannot decode tiles of format %q", f)
+	}
+	img, err := dec(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s tile: %v", f, err)
+	}
+	return img, nil
+}
+
+// encodeTile encodes img as raster tile of format f with the given quality.
+func encodeTile(img image.Image, f mbtiles.TileFormat, quality int) ([]byte, error) {
+	enc := imageCodecs[f].encode
+	if enc == nil {
+		return nil, fmt.Errorf("cannot encode tiles of format %q", f)
+	}
+	if quality <= 0 || quality > 100 {
+		quality = DefaultImageQuality
+	}
+	var buf bytes.Buffer
+	if err := enc(&buf, img, quality); err != nil {
+		return nil, fmt.Errorf("cannot encode %s tile: %v", f, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// toRGBA returns img as *image.RGBA, converting it if necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// resample scales the part r of src to an image of width w and height h
+// using bilinear interpolation.
+func resample(src image.Image, r image.Rectangle, w, h int) *image.RGBA {
+	in := toRGBA(src)
+	// toRGBA moves the origin of the image to (0, 0)
+	r = r.Sub(src.Bounds().Min)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sx := float64(r.Dx()) / float64(w)
+	sy := float64(r.Dy()) / float64(h)
+	for y := 0; y < h; y++ {
+		fy := float64(r.Min.Y) + (float64(y)+0.5)*sy - 0.5
+		for x := 0; x < w; x++ {
+			fx := float64(r.Min.X) + (float64(x)+0.5)*sx - 0.5
+			o := dst.PixOffset(x, y)
+			bilinear(in, r, fx, fy, dst.Pix[o:o+4])
+		}
+	}
+	return dst
+}
+
+// bilinear interpolates the color of in at the position fx, fy in pixel
+// coordinates, where the centers of pixels have integer coordinates, and
+// stores it in px. Only the pixels within r are used.
+func bilinear(in *image.RGBA, r image.Rectangle, fx, fy float64, px []uint8) {
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	wx, wy := fx-float64(x0), fy-float64(y0)
+	maxX, maxY := r.Max.X-1, r.Max.Y-1
+	x0, x1 := clamp(x0, r.Min.X, maxX), clamp(x0+1, r.Min.X, maxX)
+	y0, y1 := clamp(y0, r.Min.Y, maxY), clamp(y0+1, r.Min.Y, maxY)
+	i00, i10 := in.PixOffset(x0, y0), in.PixOffset(x1, y0)
+	i01, i11 := in.PixOffset(x0, y1), in.PixOffset(x1, y1)
+	for c := 0; c < 4; c++ {
+		top := float64(in.Pix[i00+c])*(1-wx) + float64(in.Pix[i10+c])*wx
+		bottom := float64(in.Pix[i01+c])*(1-wx) + float64(in.Pix[i11+c])*wx
+		px[c] = uint8(top*(1-wy) + bottom*wy + 0.5)
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitClients is the maximum number of clients whose buckets are
+// kept by a RateLimiter.
+const maxRateLimitClients = 100000
+
+// RateLimiter limits the rate of requests per client with token buckets. A
+// client is identified by the API key of the ServiceSet or the JSON Web Token
+// of its requests, if they are valid, or otherwise by its IP address.
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second
+// and bursts of up to burst requests per client.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the bucket of the client at time now. If the
+// bucket is empty, it returns false and the time after which the next token
+// is available.
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.calls%1024 == 0 {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitClients {
+			l.sweep(now)
+		}
+		// evict an arbitrary bucket if all clients are still limited
+		for c := range l.buckets {
+			if len(l.buckets) < maxRateLimitClients {
+				break
+			}
+			delete(l.buckets, c)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep removes the buckets that have been refilled completely by now, as
+// they are equivalent to new ones.
+func (l *RateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// limit checks the rate of requests of the client of r, if the ServiceSet has
+// a RateLimiter. It returns http.StatusTooManyRequests and sets the
+// Retry-After header of w if the client exceeded its rate.
+func (s *ServiceSet) limit(w http.ResponseWriter, r *http.Request) int {
+	if s.RateLimiter == nil {
+		return http.StatusOK
+	}
+	client := s.rateLimitClient(r)
+	if client == "" {
+		return http.StatusOK
+	}
+	ok, retry := s.RateLimiter.allow(client, time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		return http.StatusTooManyRequests
+	}
+	return http.StatusOK
+}
+
+// rateLimitClient identifies the client of r for the RateLimiter by its
+// credentials, but only if they are valid, as clients could otherwise get a
+// new bucket for each request by making up keys. It returns an empty string
+// for clients without valid credentials and IP address, e.g. those connected
+// to a unix domain socket, which are not limited, as they would otherwise all
+// share one bucket.
+func (s *ServiceSet) rateLimitClient(r *http.Request) string {
+	if key := requestAPIKey(r); key != "" {
+		if s.JWT != nil && isJWT(key) {
+			if _, err := s.JWT.patterns(key); err == nil {
+				return "token:" + key
+			}
+		} else if k := s.findAPIKey(key); k != nil && !k.expired(time.Now()) {
+			return "key:" + k.Key
+		}
+	}
+	if ip := s.aclClientIP(r); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SharedCache is a cache of responses that is shared by several servers, like
+// RedisCache. Errors of the cache are not fatal for the requests that use it.
+type SharedCache interface {
+	// Get returns the value of key and whether it exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set sets the value of key.
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// redisIdleConns is the number of idle connections that a RedisCache keeps
+// open.
+const redisIdleConns = 16
+
+// redisTimeout is the default timeout of connecting to Redis and of the
+// commands of a RedisCache.
+const redisTimeout = time.Second
+
+// RedisCache is a SharedCache whose values are stored in Redis and expire
+// after a time to live. It is safe for concurrent use.
+type RedisCache struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	ttl      time.Duration
+	// Timeout limits the time of connecting to Redis and of each command.
+	Timeout time.Duration
+	idle    chan *redisConn
+}
+
+// redisConn is a connection to Redis.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisCache returns a RedisCache that connects to the Redis server at the
+// URL "redis://[[user]:password@]host[:port][/db]", or "rediss://..." for TLS,
+// whose values expire after ttl. A ttl of zero keeps the values until Redis
+// evicts them.
+func NewRedisCache(rawurl string, ttl time.Duration) (*RedisCache, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	c := &RedisCache{ttl: ttl, Timeout: redisTimeout, idle: make(chan *redisConn, redisIdleConns)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid Redis URL scheme %q, expected redis or rediss", u.Scheme)
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if c.db, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", p)
+		}
+	}
+	return c, nil
+}
+
+// Get returns the value of key and whether it exists.
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, nil
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply %v to GET", v)
+	}
+	return b, true, nil
+}
+
+// Set sets the value of key, which expires after the time to live of the
+// cache.
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte) error {
+	args := []interface{}{"SET", key, value}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(c.ttl/time.Millisecond), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+// Close closes the idle connections of the cache.
+func (c *RedisCache) Close() error {
+	for {
+		select {
+		case rc := <-c.idle:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends a command to Redis and returns its reply, which is nil, a string,
+// an int64 or a []byte. Error replies are returned as redisError.
+func (c *RedisCache) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	rc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.conn.SetDeadline(deadline)
+	v, err := rc.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// the state of the connection is unknown
+		rc.conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return v, err
+}
+
+// conn returns an idle connection or opens a new one.
+func (c *RedisCache) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	default:
+	}
+	d := net.Dialer{Timeout: c.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		conn = tls.Client(conn, c.tls)
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	if c.password != "" {
+		args := []interface{}{"AUTH", c.password}
+		if c.username != "" {
+			args = []interface{}{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command with the arguments, which are strings or []byte, and
+// reads its reply.
+func (rc *redisConn) do(args ...interface{}) (interface{}, error) {
+	fmt.Fprintf(rc.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var b []byte
+		switch a := a.(type) {
+		case string:
+			b = []byte(a)
+		case []byte:
+			b = a
+		}
+		fmt.Fprintf(rc.w, "$%d\r\n", len(b))
+		rc.w.Write(b)
+		rc.w.WriteString("\r\n")
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+	return rc.reply()
+}
+
+// reply reads a reply that is not an array. Bulk strings are limited to
+// maxSharedSize bytes.
+func (rc *redisConn) reply() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: invalid reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.New("redis: invalid bulk string length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxSharedSize {
+			return nil, fmt.Errorf("redis: bulk string of %d bytes exceeds the limit of %d bytes", n, maxSharedSize)
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply %q", line)
+}
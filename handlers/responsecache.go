@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/golang/groupcache/singleflight"
+)
+
+// ResponseCacheStats contains the statistics of a ResponseCache.
+type ResponseCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Coalesced is the number of requests that were answered with the
+	// response to a concurrent request for the same representation.
+	Coalesced int64 `json:"coalesced"`
+	Items     int   `json:"items"`
+	Bytes     int64 `json:"bytes"`
+	// SharedHits and SharedErrors count the responses that were found in
+	// and the errors of the SharedCache.
+	SharedHits   int64 `json:"sharedHits"`
+	SharedErrors int64 `json:"sharedErrors"`
+}
+
+// ResponseCache is a LRU cache of the responses to requests for tiles, grids
+// and TileJSON of all tilesets of a ServiceSet, which is limited by the total
+// size of the cached responses. Concurrent requests for a response that is
+// not cached are coalesced, so that only one of them reads the tileset. It is
+// safe for concurrent use.
+type ResponseCache struct {
+	mu       sync.Mutex
+	lru      *lru.Cache
+	maxBytes int64
+	stats    ResponseCacheStats
+	group    singleflight.Group
+	// seq numbers the handlers that use the cache, so that responses of
+	// replaced tilesets are not served
+	seq uint64
+	// Shared, if not nil, is a second level cache that is shared with other
+	// servers. Responses that are not in memory are read from it, and new
+	// responses are written to it.
+	Shared SharedCache
+	// Peers, if not nil, shards the responses across the memory of a set of
+	// servers. Responses that are not in memory are fetched from the peer
+	// that owns them.
+	Peers *PeerCache
+}
+
+// cachedResponse is a response that has been written by a handlerFunc.
+type cachedResponse struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+// NewResponseCache returns a ResponseCache that holds up to maxBytes bytes of
+// responses in memory. Zero keeps no responses in memory, which is useful
+// together with a SharedCache.
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	c := &ResponseCache{
+		lru:      lru.New(0),
+		maxBytes: maxBytes,
+	}
+	c.lru.OnEvicted = func(key lru.Key, value interface{}) {
+		c.stats.Bytes -= int64(len(value.(*cachedResponse).body))
+	}
+	return c
+}
+
+// Stats returns the statistics of the cache.
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Items = c.lru.Len()
+	return s
+}
+
+func (c *ResponseCache) get(k string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.lru.Get(k)
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	return v.(*cachedResponse), true
+}
+
+func (c *ResponseCache) add(k string, resp *cachedResponse) {
+	size := int64(len(resp.body))
+	if c.maxBytes == 0 || size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.lru.Get(k); ok {
+		c.stats.Bytes -= int64(len(v.(*cachedResponse).body))
+	}
+	c.lru.Add(k, resp)
+	c.stats.Bytes += size
+	for c.stats.Bytes > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+// responseRecorder is a http.ResponseWriter that records a response in
+// memory.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// errInvalidResponse is returned by unmarshalResponse for invalid data.
+var errInvalidResponse = errors.New("invalid cached response")
+
+// maxSharedSize is the maximum size in bytes of an encoded response in a
+// SharedCache. Larger responses are not shared, and larger values that are
+// read from Redis are rejected before they are allocated.
+const maxSharedSize = 32 << 20
+
+// sharedLoadTimeout limits the reads of responses that are shared by
+// concurrent requests, which are not cancelled with the first of them.
+const sharedLoadTimeout = time.Minute
+
+// marshalResponse encodes resp for a SharedCache as the length of the JSON
+// encoded status and headers, followed by them and the body.
+func marshalResponse(resp *cachedResponse) ([]byte, error) {
+	meta, err := json.Marshal(struct {
+		Status int
+		Header http.Header
+	}{resp.status, resp.header})
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 4, 4+len(meta)+len(resp.body))
+	binary.BigEndian.PutUint32(data, uint32(len(meta)))
+	data = append(data, meta...)
+	return append(data, resp.body...), nil
+}
+
+// unmarshalResponse decodes a response encoded by marshalResponse.
+func unmarshalResponse(data []byte) (*cachedResponse, error) {
+	if len(data) < 4 || uint64(len(data)-4) < uint64(binary.BigEndian.Uint32(data)) {
+		return nil, errInvalidResponse
+	}
+	n := 4 + int(binary.BigEndian.Uint32(data))
+	var meta struct {
+		Status int
+		Header http.Header
+	}
+	if err := json.Unmarshal(data[4:n], &meta); err != nil || meta.Status == 0 {
+		return nil, errInvalidResponse
+	}
+	return &cachedResponse{header: meta.Header, status: meta.Status, body: data[n:]}, nil
+}
+
+// sharedKey returns the key of the response to r with the root URL root for
+// the tileset id in a SharedCache, which is the same for servers with the same
+// tileset.
+func sharedKey(r *http.Request, root, id string, db Tileset) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s", id, db.TimeStamp().Unix(), root, representationKey(r))
+	return "mbtileserver:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// write writes the response to w. If the If-None-Match header of r matches
+// its ETag, only the status 304 Not Modified is written.
+func (resp *cachedResponse) write(w http.ResponseWriter, r *http.Request) (int, error) {
+	for k, v := range resp.header {
+		// the values are copied, as handlers may add to them
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	if etag := resp.header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified, nil
+	}
+	w.WriteHeader(resp.status)
+	_, err := w.Write(resp.body)
+	return http.StatusOK, err
+}
+
+// cacheResult is the result of a handlerFunc whose response was recorded.
+type cacheResult struct {
+	resp   *cachedResponse
+	status int
+	err    error
+}
+
+// cacheable reports whether the response can be cached.
+func (res cacheResult) cacheable() bool {
+	return res.err == nil && res.status < http.StatusBadRequest && (res.resp.status == http.StatusOK || res.resp.status == http.StatusNoContent)
+}
+
+// cached returns a handlerFunc that answers GET requests with the responses
+// of hf for the tileset id from the ResponseCache of the ServiceSet, if it has
+// one. kind names the endpoint of hf, like "tiles". hf is called without the
+// conditional headers of the request, so that its response can be shared.
+func (s *ServiceSet) cached(id, kind string, db Tileset, hf handlerFunc) handlerFunc {
+	c := s.ResponseCache
+	if c == nil {
+		return hf
+	}
+	seq := atomic.AddUint64(&c.seq, 1)
+	// load reads the response to r from the SharedCache or from hf
+	load := func(r *http.Request) cacheResult {
+		var sk string
+		if c.Shared != nil {
+			sk = sharedKey(r, s.RootURL(r), id, db)
+			if resp, ok := c.getShared(r, sk); ok {
+				return cacheResult{resp, http.StatusOK, nil}
+			}
+		}
+		rec := &responseRecorder{header: make(http.Header)}
+		status, err := hf(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		res := cacheResult{&cachedResponse{header: rec.header, status: rec.status, body: rec.body.Bytes()}, status, err}
+		if c.Shared != nil && res.cacheable() {
+			c.setShared(r, sk, res.resp)
+		}
+		return res
+	}
+	if c.Peers != nil {
+		c.Peers.register(s, id, kind, db, load)
+	}
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if r.Method != "GET" {
+			return hf(w, r)
+		}
+		// the URLs in TileJSON depend on the root URL
+		k := fmt.Sprintf("%d\x00%s\x00%s", seq, s.RootURL(r), representationKey(r))
+		if resp, ok := c.get(k); ok {
+			return resp.write(w, r)
+		}
+		leader := false
+		v, _ := c.group.Do(k, func() (interface{}, error) {
+			leader = true
+			// the other requests wait for the response even if the client
+			// of this one goes away
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), sharedLoadTimeout)
+			defer cancel()
+			r := r.Clone(ctx)
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+			var res cacheResult
+			if c.Peers != nil {
+				res = c.Peers.load(r, s.RootURL(r), id, kind, db, load)
+			} else {
+				res = load(r)
+			}
+			if res.cacheable() {
+				c.add(k, res.resp)
+			}
+			return res, nil
+		})
+		if !leader {
+			c.mu.Lock()
+			c.stats.Coalesced++
+			c.mu.Unlock()
+		}
+		res := v.(cacheResult)
+		if res.err != nil || res.status >= http.StatusBadRequest {
+			return res.status, res.err
+		}
+		return res.resp.write(w, r)
+	}
+}
+
+// getShared returns the response with the key k from the SharedCache.
+func (c *ResponseCache) getShared(r *http.Request, k string) (*cachedResponse, bool) {
+	data, ok, err := c.Shared.Get(r.Context(), k)
+	if err == nil && ok {
+		var resp *cachedResponse
+		if resp, err = unmarshalResponse(data); err == nil {
+			c.mu.Lock()
+			c.stats.SharedHits++
+			c.mu.Unlock()
+			return resp, true
+		}
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.stats.SharedErrors++
+		c.mu.Unlock()
+	}
+	return nil, false
+}
+
+// setShared writes the response resp with the key k to the SharedCache.
+func (c *ResponseCache) setShared(r *http.Request, k string, resp *cachedResponse) {
+	data, err := marshalResponse(resp)
+	if err == nil && len(data) > maxSharedSize {
+		return
+	}
+	if err == nil {
+		err = c.Shared.Set(r.Context(), k, data)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.stats.SharedErrors++
+		c.mu.Unlock()
+	}
+}
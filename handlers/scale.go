@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// tileScales are the scale factors of raster tiles that are advertised in
+// TileJSON. Tiles at scale 2 are requested as "{z}/{x}/{y}@2x.png".
+var tileScales = []int{1, 2}
+
+// splitScale splits the scale factor suffix "@<n>x" off the last path
+// component y of a tile URL, e.g. "3@2x.png" into "3.png" and 2. The scale is
+// 1 if there is no suffix.
+func splitScale(y string) (string, int, error) {
+	i := strings.LastIndex(y, "@")
+	if i < 0 {
+		return y, 1, nil
+	}
+	ext := ""
+	s := y[i+1:]
+	if l := strings.LastIndex(s, "."); l >= 0 {
+		s, ext = s[:l], s[l:]
+	}
+	scale, err := strconv.Atoi(strings.TrimSuffix(s, "x"))
+	if err != nil || !strings.HasSuffix(s, "x") || (scale != 1 && scale != 2) {
+		return "", 0, fmt.Errorf("invalid scale %q, expected @1x or @2x", y[i:len(y)-len(ext)])
+	}
+	return y[:i] + ext, scale, nil
+}
+
+// nativeTileSize returns the width in pixels of the raster tiles of db, which
+// is read from one of its tiles, or 256 if it cannot be determined, e.g. as
+// db is not a TileLister.
+func nativeTileSize(ctx context.Context, db Tileset) int {
+	l, ok := db.(TileLister)
+	if !ok {
+		return 256
+	}
+	it, err := l.Tiles(ctx, mbtiles.TileFilter{})
+	if err != nil {
+		return 256
+	}
+	defer it.Close()
+	for it.Next() {
+		if data := it.Tile().Data; len(data) > 1 {
+			if c, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				return c.Width
+			}
+			break
+		}
+	}
+	return 256
+}
+
+// scaler creates the raster tiles of a DB in other sizes than the stored
+// ones.
+type scaler struct {
+	db      Tileset
+	minZoom int
+	maxZoom int
+	once    sync.Once
+	native  int // the width of the tiles of db in pixels
+}
+
+// newScaler returns a scaler for the raster tiles of db.
+func newScaler(db Tileset) *scaler {
+	sc := &scaler{db: db, maxZoom: -1}
+	if metadata, err := db.ReadMetadata(); err == nil {
+		if z, ok := metadata["maxzoom"].(int); ok {
+			sc.maxZoom = z
+		}
+	}
+	return sc
+}
+
+// nativeSize returns the width of the stored tiles in pixels.
+func (sc *scaler) nativeSize() int {
+	sc.once.Do(func() { sc.native = nativeTileSize(context.Background(), sc.db) })
+	return sc.native
+}
+
+// readImage reads and decodes the tile at z, x, y. mbtiles.ErrTileNotFound is
+// returned if the tile does not exist or is empty.
+func (sc *scaler) readImage(ctx context.Context, z uint8, x, y uint64) (image.Image, error) {
+	var data []byte
+	err := sc.db.ReadTileContext(ctx, z, x, y, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= 1 {
+		return nil, mbtiles.ErrTileNotFound
+	}
+	return decodeTile(data, sc.db.TileFormat())
+}
+
+// tile returns the tile at tc with a width of size pixels, which differs from
+// the native size of the tiles. Larger tiles are assembled from the four
+// tiles at the next zoom level, if it exists, or scaled up. Smaller tiles are
+// cropped from the tile at the previous zoom level, if it exists, or scaled
+// down. mbtiles.ErrTileNotFound is returned if the tile does not exist.
+func (sc *scaler) tile(ctx context.Context, quality int, tc tileCoord, size int) ([]byte, error) {
+	native := sc.nativeSize()
+	f := sc.db.TileFormat()
+	switch {
+	case size > native && int(tc.z) < sc.maxZoom:
+		mosaic := image.NewRGBA(image.Rect(0, 0, 2*native, 2*native))
+		found := false
+		for i := uint64(0); i < 4; i++ {
+			dx, dy := i%2, i/2
+			img, err := sc.readImage(ctx, tc.z+1, 2*tc.x+dx, 2*tc.y+dy)
+			if err == mbtiles.ErrTileNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			p := image.Pt(int(dx)*native, int(dy)*native)
+			draw.Draw(mosaic, image.Rectangle{p, p.Add(image.Pt(native, native))}, img, img.Bounds().Min, draw.Src)
+			found = true
+		}
+		if found {
+			if 2*native == size {
+				return encodeTile(mosaic, f, quality)
+			}
+			return encodeTile(resample(mosaic, mosaic.Bounds(), size, size), f, quality)
+		}
+	case size < native && int(tc.z) > sc.minZoom:
+		parent, err := sc.readImage(ctx, tc.z-1, tc.x/2, tc.y/2)
+		if err != nil && err != mbtiles.ErrTileNotFound {
+			return nil, err
+		}
+		if err == nil {
+			b := parent.Bounds()
+			half := b.Dx() / 2
+			p := b.Min.Add(image.Pt(int(tc.x%2)*half, int(tc.y%2)*half))
+			r := image.Rectangle{p, p.Add(image.Pt(half, half))}
+			if half == size {
+				// a copy of the quadrant is encoded without resampling
+				img := image.NewRGBA(image.Rect(0, 0, size, size))
+				draw.Draw(img, img.Bounds(), parent, r.Min, draw.Src)
+				return encodeTile(img, f, quality)
+			}
+			return encodeTile(resample(parent, r, size, size), f, quality)
+		}
+	}
+	img, err := sc.readImage(ctx, tc.z, tc.x, tc.y)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTile(resample(img, img.Bounds(), size, size), f, quality)
+}
+
+// tileSize returns the width in pixels of the raster tiles at the given
+// scale.
+func (s *ServiceSet) tileSize(scale int) int {
+	if s.TileSize > 0 {
+		return s.TileSize * scale
+	}
+	return 256 * scale
+}
+
+// writeScaled writes the raster tile at tc of the scaler with a width of size
+// pixels.
+func (s *ServiceSet) writeScaled(w http.ResponseWriter, r *http.Request, sc *scaler, tc tileCoord, size int, missing *MissingTile) (int, error) {
+	ctx, span := s.startSpan(r.Context(), "scale")
+	data, err := sc.tile(ctx, s.ImageQuality, tc, size)
+	span.End()
+	switch {
+	case err == mbtiles.ErrTileNotFound:
+		return missing.write(w, r, sc.db.TileFormat())
+	case err != nil:
+		return http.StatusInternalServerError, fmt.Errorf("cannot scale tile for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+	}
+	setTileHeaders(w, sc.db, mbtiles.IDENTITY)
+	return writeWithETag(w, r, data)
+}
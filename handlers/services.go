@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxServicesLimit is the maximum number of services on a page of the
+// service listing.
+const MaxServicesLimit = 1000
+
+// ServiceMetadata summarizes the metadata of a tileset in the service listing.
+type ServiceMetadata struct {
+	Bounds  []float64 `json:"bounds"`
+	Center  []float64 `json:"center,omitempty"`
+	MinZoom int       `json:"minzoom"`
+	MaxZoom int       `json:"maxzoom"`
+	Format  string    `json:"format"`
+}
+
+// serviceFilter selects and pages the services of the service listing by the
+// query parameters of its request.
+type serviceFilter struct {
+	q        string
+	formats  []string
+	bbox     []float64
+	offset   int
+	limit    int
+	metadata bool
+}
+
+// parseServiceFilter parses the query parameters of a request for the service
+// listing:
+//
+//	q         part of the ID or name of the tilesets, ignoring case
+//	format    comma separated tile formats, like png,jpg
+//	bbox      west,south,east,north that the bounds of the tilesets intersect
+//	offset    number of services to skip
+//	limit     maximum number of services, up to MaxServicesLimit
+//	metadata  true to include a ServiceMetadata for each service
+func parseServiceFilter(query url.Values) (*serviceFilter, error) {
+	f := &serviceFilter{q: strings.ToLower(query.Get("q"))}
+	if v := query.Get("format"); v != "" {
+		for _, format := range strings.Split(v, ",") {
+			f.formats = append(f.formats, strings.ToLower(strings.TrimSpace(format)))
+		}
+	}
+	if v := query.Get("bbox"); v != "" {
+		bbox, err := parseFloats(v)
+		if err != nil || len(bbox) != 4 || bbox[0] > bbox[2] || bbox[1] > bbox[3] {
+			return nil, fmt.Errorf("invalid bbox %q, expected west,south,east,north", v)
+		}
+		f.bbox = bbox
+	}
+	var err error
+	if v := query.Get("offset"); v != "" {
+		if f.offset, err = strconv.Atoi(v); err != nil || f.offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if f.limit, err = strconv.Atoi(v); err != nil || f.limit < 1 || f.limit > MaxServicesLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxServicesLimit)
+		}
+	}
+	if v := query.Get("metadata"); v != "" {
+		if f.metadata, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid metadata %q", v)
+		}
+	}
+	return f, nil
+}
+
+// needsMetadata reports whether the metadata of the tilesets must be read to
+// filter them or to list them.
+func (f *serviceFilter) needsMetadata() bool {
+	return f.q != "" || f.bbox != nil || f.metadata
+}
+
+// match reports whether the tileset id in db with the name and metadata,
+// which is nil unless needsMetadata, passes the filter.
+func (f *serviceFilter) match(id, name string, db Tileset, metadata map[string]interface{}) bool {
+	if f.q != "" {
+		if name == "" {
+			name, _ = metadata["name"].(string)
+		}
+		if !strings.Contains(strings.ToLower(id), f.q) && !strings.Contains(strings.ToLower(name), f.q) {
+			return false
+		}
+	}
+	if f.formats != nil {
+		found := false
+		for _, format := range f.formats {
+			found = found || format == db.TileFormat().String()
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.bbox != nil {
+		_, _, b := zoomsAndBounds(metadata)
+		if b[0] > f.bbox[2] || b[2] < f.bbox[0] || b[1] > f.bbox[3] || b[3] < f.bbox[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// page returns the services on the page selected by the filter and sets the
+// X-Total-Count header of w to the number of all services, and its Link
+// header to the URLs of the next and previous pages.
+func (f *serviceFilter) page(w http.ResponseWriter, listURL string, query url.Values, services []ServiceInfo) []ServiceInfo {
+	total := len(services)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if f.offset >= total {
+		services = services[:0]
+	} else {
+		services = services[f.offset:]
+	}
+	if f.limit == 0 || len(services) <= f.limit && f.offset == 0 {
+		return services
+	}
+	link := func(offset int, rel string) string {
+		query.Set("offset", strconv.Itoa(offset))
+		return fmt.Sprintf("<%s?%s>; rel=%q", listURL, query.Encode(), rel)
+	}
+	var links []string
+	if len(services) > f.limit {
+		services = services[:f.limit]
+		links = append(links, link(f.offset+f.limit, "next"))
+	}
+	if f.offset > 0 {
+		prev := f.offset - f.limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link(prev, "prev"))
+	}
+	if links != nil {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return services
+}
+
+// serviceMetadata returns the ServiceMetadata of db from its metadata.
+func serviceMetadata(db Tileset, metadata map[string]interface{}) *ServiceMetadata {
+	minZoom, maxZoom, bounds := zoomsAndBounds(metadata)
+	m := &ServiceMetadata{
+		Bounds:  bounds,
+		MinZoom: minZoom,
+		MaxZoom: maxZoom,
+		Format:  db.TileFormat().String(),
+	}
+	if center, ok := metadata["center"].([]float64); ok && len(center) >= 2 {
+		m.Center = center
+	}
+	return m
+}
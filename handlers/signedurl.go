@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// urlSignature returns the signature of the path p that expires at the unix
+// time exp.
+func urlSignature(key []byte, p string, exp int64) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", p, exp)
+	return mac.Sum(nil)
+}
+
+// SignURL returns rawurl with the query parameters "exp" and "sig", which
+// grant access to exactly the path of rawurl until expires, if the
+// URLSigningKey of the ServiceSet is key. The path has to be the one that the
+// handlers receive, i.e. without the Path of the ServiceSet.
+func SignURL(key []byte, rawurl string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	exp := expires.Unix()
+	q := u.Query()
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(urlSignature(key, u.Path, exp)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// verifySignedURL checks the signature of a request r with the query
+// parameter "sig" with key.
+func verifySignedURL(key []byte, r *http.Request) error {
+	q := r.URL.Query()
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry of signed URL: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil {
+		return fmt.Errorf("invalid signature of signed URL: %v", err)
+	}
+	if !hmac.Equal(sig, urlSignature(key, r.URL.Path, exp)) {
+		return fmt.Errorf("invalid signature of signed URL")
+	}
+	if time.Now().Unix() > exp {
+		return fmt.Errorf("signed URL has expired")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// spriteName splits the name of a sprite file like "<id>@2x.png" into the
+// ID of the sprite, its pixel ratio suffix and its extension. ok is false
+// for names that are not sprite files.
+func spriteName(name string) (id, ratio, ext string, ok bool) {
+	ext = filepath.Ext(name)
+	if ext != ".json" && ext != ".png" {
+		return "", "", "", false
+	}
+	id = strings.TrimSuffix(name, ext)
+	if strings.HasSuffix(id, "@2x") {
+		id, ratio = strings.TrimSuffix(id, "@2x"), "@2x"
+	}
+	if !validName(id) {
+		return "", "", "", false
+	}
+	return id, ratio, ext, true
+}
+
+// listSprites returns the IDs of the sprites in s.SpritesDir in sorted order,
+// which are those with both an index and an image.
+func (s *ServiceSet) listSprites() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.SpritesDir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		id, ratio, ext, ok := spriteName(e.Name())
+		if e.IsDir() || !ok || ratio != "" || ext != ".json" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.SpritesDir, id+".png")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// SpritesHandler returns a http.Handler that serves the sprites in the
+// SpritesDir of the ServiceSet, each of which consists of the index
+// "<id>.json" and the image "<id>.png", and optionally of the variants
+// "<id>@2x.json" and "<id>@2x.png" for high resolution displays. "/sprites"
+// lists the sprites and "/sprites/<id>[@2x].{json,png}" serves their files.
+// The function ef is called with any occuring error if it is non-nil, so it
+// can be used for e.g. logging with logging facitilies of the caller.
+func (s *ServiceSet) SpritesHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sprites"), "/")
+		if p == "" {
+			ids, err := s.listSprites()
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("cannot list sprites: %v", err)
+			}
+			return writeJSON(w, ids)
+		}
+		if _, _, _, ok := spriteName(p); !ok {
+			return http.StatusNotFound, fmt.Errorf("unknown sprite resource %q", r.URL.Path)
+		}
+		f, err := os.Open(filepath.Join(s.SpritesDir, p))
+		switch {
+		case os.IsNotExist(err):
+			return notFoundJSON(w, "Sprite does not exist")
+		case err != nil:
+			return http.StatusInternalServerError, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if fi.IsDir() {
+			return notFoundJSON(w, "Sprite does not exist")
+		}
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return http.StatusOK, nil
+	})))
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// staticMaxSize is the maximum width and height of a static map image.
+const staticMaxSize = 2048
+
+// markerRadius is the radius in pixels of the markers on static map images,
+// which get an additional white outline of markerOutline pixels.
+const (
+	markerRadius  = 6
+	markerOutline = 2
+)
+
+// marker is a marker on a static map image.
+type marker struct {
+	lon, lat float64
+	color    color.RGBA
+}
+
+// parseFloats parses the comma separated floating point numbers of s.
+func parseFloats(s string) ([]float64, error) {
+	var out []float64
+	for _, p := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// parseMarker parses a marker given as "lon,lat" or "lon,lat,rrggbb". Markers
+// are red by default.
+func parseMarker(s string) (marker, error) {
+	m := marker{color: color.RGBA{0xff, 0, 0, 0xff}}
+	pcs := strings.Split(s, ",")
+	if len(pcs) == 3 {
+		v, err := strconv.ParseUint(strings.TrimPrefix(pcs[2], "#"), 16, 32)
+		if err != nil {
+			return m, fmt.Errorf("invalid marker color %q", pcs[2])
+		}
+		m.color = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+		pcs = pcs[:2]
+	}
+	v, err := parseFloats(strings.Join(pcs, ","))
+	if err != nil || len(v) != 2 {
+		return m, fmt.Errorf("invalid marker %q", s)
+	}
+	m.lon, m.lat = v[0], v[1]
+	return m, nil
+}
+
+// staticRequest parses the map extent, the size and the format of a static
+// map from the last two path components of the request. The extent is either
+// "lon,lat,zoom" or "west,south,east,north" in WGS84 degrees, the size is
+// "<width>x<height>.<ext>". The returned GetMap request covers the extent in
+// web mercator without distortion, so a bounding box is enlarged to the
+// aspect ratio of the image.
+func staticRequest(extent, size string) (*wmsRequest, float64, error) {
+	req := &wmsRequest{
+		crs:     "EPSG:3857",
+		bgcolor: color.RGBA{0xff, 0xff, 0xff, 0xff},
+	}
+	i := strings.LastIndex(size, ".")
+	if i < 0 {
+		return nil, 0, fmt.Errorf("missing image format in %q", size)
+	}
+	var ok bool
+	if req.format, ok = formatFromExt(size[i:]); !ok {
+		return nil, 0, fmt.Errorf("unsupported image format %q", size[i:])
+	}
+	dims := strings.Split(size[:i], "x")
+	if len(dims) != 2 {
+		return nil, 0, fmt.Errorf("invalid image size %q", size[:i])
+	}
+	var err error
+	if req.width, err = strconv.Atoi(dims[0]); err != nil || req.width <= 0 || req.width > staticMaxSize {
+		return nil, 0, fmt.Errorf("width must be between 1 and %d", staticMaxSize)
+	}
+	if req.height, err = strconv.Atoi(dims[1]); err != nil || req.height <= 0 || req.height > staticMaxSize {
+		return nil, 0, fmt.Errorf("height must be between 1 and %d", staticMaxSize)
+	}
+
+	v, err := parseFloats(extent)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid map extent %q", extent)
+	}
+	var cx, cy, res float64
+	switch len(v) {
+	case 3:
+		if v[2] < 0 || v[2] > 30 {
+			return nil, 0, fmt.Errorf("zoom level must be between 0 and 30")
+		}
+		cx, cy = lonLatToMercator(v[0], v[1])
+		res = 2 * mercatorOrigin / (256 * math.Pow(2, v[2]))
+	case 4:
+		if v[0] >= v[2] || v[1] >= v[3] {
+			return nil, 0, fmt.Errorf("invalid bounding box %q", extent)
+		}
+		minX, minY := lonLatToMercator(v[0], v[1])
+		maxX, maxY := lonLatToMercator(v[2], v[3])
+		cx, cy = (minX+maxX)/2, (minY+maxY)/2
+		res = math.Max((maxX-minX)/float64(req.width), (maxY-minY)/float64(req.height))
+	default:
+		return nil, 0, fmt.Errorf("invalid map extent %q", extent)
+	}
+	w, h := float64(req.width)*res/2, float64(req.height)*res/2
+	req.bbox = [4]float64{cx - w, cy - h, cx + w, cy + h}
+	return req, res, nil
+}
+
+// drawMarker draws the marker m onto the map image img that covers the web
+// mercator bounding box bbox with the resolution res.
+func drawMarker(img *image.RGBA, bbox [4]float64, res float64, m marker) {
+	mx, my := lonLatToMercator(m.lon, m.lat)
+	cx := (mx - bbox[0]) / res
+	cy := (bbox[3] - my) / res
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	r := float64(markerRadius + markerOutline)
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			switch {
+			case d <= markerRadius:
+				img.SetRGBA(x, y, m.color)
+			case d <= r:
+				img.SetRGBA(x, y, white)
+			}
+		}
+	}
+}
+
+// staticMap serves static map images of a raster tileset at
+// "/services/<id>/static/<extent>/<width>x<height>.<ext>", see staticRequest,
+// with optional markers given by "marker" query parameters.
+func (s *ServiceSet) staticMap(id string, db Tileset) handlerFunc {
+	minZoom, maxZoom := s.policy(id).zooms(zoomRange(db))
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		pcs := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"+id+"/static/"), "/")
+		if len(pcs) != 2 {
+			return http.StatusNotFound, fmt.Errorf("unknown static map resource %q", r.URL.Path)
+		}
+		req, res, err := staticRequest(pcs[0], pcs[1])
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if !canConvert(db.TileFormat(), req.format) {
+			return http.StatusBadRequest, fmt.Errorf("cannot render tiles of format %s as %s", db.TileFormat(), req.format.ContentType())
+		}
+		var markers []marker
+		for _, v := range r.URL.Query()["marker"] {
+			m, err := parseMarker(v)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			markers = append(markers, m)
+		}
+		img, err := getMap(r.Context(), db, req, minZoom, maxZoom, func(tc tileCoord) bool {
+			return s.interceptRead(r, id, db, tc) == 0
+		})
+		if err == errTooManyTiles {
+			return http.StatusBadRequest, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot render static map for tileset %v: %v", id, err)
+		}
+		for _, m := range markers {
+			drawMarker(img, req.bbox, res, m)
+		}
+		data, err := encodeTile(img, req.format, s.ImageQuality)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("Content-Type", req.format.ContentType())
+		return writeWithETag(w, r, data)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// styleInfo describes a style in the listing of StylesHandler.
+type styleInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+}
+
+// stylePath returns the filename of the style id in s.StylesDir and the
+// directory of its local files, which is empty for styles that consist of a
+// single file. It returns an error satisfying os.IsNotExist if there is no
+// such style.
+func (s *ServiceSet) stylePath(id string) (filename, dir string, err error) {
+	if !validName(id) {
+		return "", "", os.ErrNotExist
+	}
+	dir = filepath.Join(s.StylesDir, id)
+	filename = filepath.Join(dir, "style.json")
+	if _, err = os.Stat(filename); err == nil {
+		return filename, dir, nil
+	}
+	filename = filepath.Join(s.StylesDir, id+".json")
+	if _, err = os.Stat(filename); err != nil {
+		return "", "", err
+	}
+	return filename, "", nil
+}
+
+// listStyles returns the IDs of the styles in s.StylesDir in sorted order.
+func (s *ServiceSet) listStyles() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.StylesDir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		id := e.Name()
+		switch {
+		case e.IsDir():
+			if _, err := os.Stat(filepath.Join(s.StylesDir, id, "style.json")); err != nil {
+				continue
+			}
+		case filepath.Ext(id) == ".json":
+			id = strings.TrimSuffix(id, ".json")
+		default:
+			continue
+		}
+		if validName(id) && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// readStyle reads and decodes the style id.
+func (s *ServiceSet) readStyle(id string) (style map[string]interface{}, dir string, err error) {
+	filename, dir, err := s.stylePath(id)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.Unmarshal(data, &style); err != nil {
+		return nil, "", fmt.Errorf("cannot parse style %s: %v", id, err)
+	}
+	return style, dir, nil
+}
+
+// rewriteStyle rewrites the sources of the style id, so that clients can
+// follow them from the root URL of the server. The URLs "mbtiles://<id>" and
+// "mbtiles://{<id>}" of sources are replaced by the TileJSON endpoints of the
+// tilesets and relative URLs of GeoJSON data refer to the local files of the
+// style, if it has any. The API key of r, if any, is appended to the URLs of
+// the tilesets. Glyphs at "mbtiles://fonts/" and sprites at
+// "mbtiles://sprites/" refer to the fonts and sprites of the server.
+func rewriteStyle(r *http.Request, style map[string]interface{}, root, id string, local bool) {
+	for k, prefix := range map[string]string{"glyphs": "fonts/", "sprite": "sprites/"} {
+		if u, ok := style[k].(string); ok && strings.HasPrefix(u, "mbtiles://"+prefix) {
+			style[k] = root + "/" + strings.TrimPrefix(u, "mbtiles://")
+		}
+	}
+	sources, _ := style["sources"].(map[string]interface{})
+	for _, v := range sources {
+		src, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if u, ok := src["url"].(string); ok && strings.HasPrefix(u, "mbtiles://") {
+			tileset := strings.TrimPrefix(u, "mbtiles://")
+			tileset = strings.TrimSuffix(strings.TrimPrefix(tileset, "{"), "}")
+			src["url"] = withAPIKey(r, fmt.Sprintf("%s/services/%s", root, tileset))
+		}
+		if d, ok := src["data"].(string); ok && local {
+			if u, err := url.Parse(d); err == nil && !u.IsAbs() && !strings.HasPrefix(d, "/") {
+				src["data"] = fmt.Sprintf("%s/styles/%s/%s", root, id, d)
+			}
+		}
+	}
+}
+
+// StylesHandler returns a http.Handler that serves the Mapbox GL styles in
+// the StylesDir of the ServiceSet. "/styles" lists the styles and
+// "/styles/<id>/style.json" serves the style id with the sources rewritten to
+// the endpoints of the server. The other files in the directory of a style
+// are served below "/styles/<id>/". The function ef is called with any
+// occuring error if it is non-nil, so it can be used for e.g. logging with
+// logging facitilies of the caller.
+func (s *ServiceSet) StylesHandler(ef func(error)) http.Handler {
+	return s.logged(s.traced(wrapGetWithErrors(ef, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		root := s.RootURL(r)
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/styles"), "/")
+		if p == "" {
+			ids, err := s.listStyles()
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("cannot list styles: %v", err)
+			}
+			styles := []styleInfo{}
+			for _, id := range ids {
+				info := styleInfo{ID: id, URL: withAPIKey(r, fmt.Sprintf("%s/styles/%s/style.json", root, id))}
+				if style, _, err := s.readStyle(id); err == nil {
+					info.Name, _ = style["name"].(string)
+				}
+				styles = append(styles, info)
+			}
+			return writeJSON(w, styles)
+		}
+		pcs := strings.SplitN(p, "/", 2)
+		if len(pcs) != 2 {
+			return http.StatusNotFound, fmt.Errorf("unknown style resource %q", r.URL.Path)
+		}
+		id, name := pcs[0], pcs[1]
+		if name == "style.json" {
+			style, dir, err := s.readStyle(id)
+			switch {
+			case os.IsNotExist(err):
+				return notFoundJSON(w, "Style does not exist")
+			case err != nil:
+				return http.StatusInternalServerError, err
+			}
+			rewriteStyle(r, style, root, id, dir != "")
+			data, err := json.Marshal(style)
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("cannot marshal style JSON: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			return writeWithETag(w, r, data)
+		}
+		_, dir, err := s.stylePath(id)
+		if err != nil || dir == "" {
+			return http.StatusNotFound, fmt.Errorf("style %s has no local file %s", id, name)
+		}
+		// http.Dir does not allow to leave the directory of the style
+		f, err := http.Dir(dir).Open(path.Clean("/" + name))
+		if err != nil {
+			return http.StatusNotFound, fmt.Errorf("style %s has no local file %s", id, name)
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			return http.StatusNotFound, fmt.Errorf("style %s has no local file %s", id, name)
+		}
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return http.StatusOK, nil
+	})))
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// demEncoding is the encoding of elevations in the colors of terrain-RGB
+// tiles.
+type demEncoding uint8
+
+const (
+	// demMapbox encodes elevations in steps of 0.1 m from -10000 m as
+	// R * 256 * 256 + G * 256 + B.
+	demMapbox demEncoding = iota
+	// demTerrarium encodes elevations as R * 256 + G + B / 256 - 32768 m.
+	demTerrarium
+)
+
+// parseDEMEncoding returns the demEncoding of the name "mapbox" or
+// "terrarium".
+func parseDEMEncoding(name string) (demEncoding, error) {
+	switch name {
+	case "mapbox":
+		return demMapbox, nil
+	case "terrarium":
+		return demTerrarium, nil
+	}
+	return demMapbox, fmt.Errorf("unknown terrain encoding %q, expected mapbox or terrarium", name)
+}
+
+// demEncodingOf returns the demEncoding of the tiles of db given by the
+// "encoding" item of its metadata, as in the TileJSON of raster-dem sources,
+// which defaults to demMapbox.
+func demEncodingOf(db Tileset) demEncoding {
+	if metadata, err := db.ReadMetadata(); err == nil {
+		name, _ := metadata["encoding"].(string)
+		if e, err := parseDEMEncoding(name); err == nil {
+			return e
+		}
+	}
+	return demMapbox
+}
+
+// requestDEMEncoding returns the demEncoding given by the "encoding" query
+// parameter of r, or def if there is none.
+func requestDEMEncoding(r *http.Request, def demEncoding) (demEncoding, error) {
+	if v := r.URL.Query().Get("encoding"); v != "" {
+		return parseDEMEncoding(v)
+	}
+	return def, nil
+}
+
+// elevation returns the elevation in meters of the color r, g, b.
+func (e demEncoding) elevation(r, g, b uint8) float64 {
+	if e == demTerrarium {
+		return float64(r)*256 + float64(g) + float64(b)/256 - 32768
+	}
+	return -10000 + float64(uint32(r)<<16|uint32(g)<<8|uint32(b))*0.1
+}
+
+// dem is a decoded terrain-RGB tile.
+type dem struct {
+	w, h int
+	// elevations in meters by row
+	values []float64
+}
+
+// readDEM reads and decodes the terrain-RGB tile of db at tc, whose
+// elevations are encoded with e. mbtiles.ErrTileNotFound is returned if the
+// tile does not exist.
+func readDEM(ctx context.Context, db Tileset, tc tileCoord, e demEncoding) (*dem, error) {
+	var data []byte
+	if err := db.ReadTileContext(ctx, tc.z, tc.x, tc.y, &data); err != nil {
+		return nil, err
+	}
+	if len(data) <= 1 {
+		return nil, mbtiles.ErrTileNotFound
+	}
+	img, err := decodeTile(data, db.TileFormat())
+	if err != nil {
+		return nil, err
+	}
+	rgba := toRGBA(img)
+	b := rgba.Bounds()
+	d := &dem{w: b.Dx(), h: b.Dy(), values: make([]float64, b.Dx()*b.Dy())}
+	for y := 0; y < d.h; y++ {
+		for x := 0; x < d.w; x++ {
+			o := rgba.PixOffset(x, y)
+			d.values[y*d.w+x] = e.elevation(rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2])
+		}
+	}
+	return d, nil
+}
+
+// at returns the elevation at the pixel x, y, clamped to the tile.
+func (d *dem) at(x, y int) float64 {
+	return d.values[clamp(y, 0, d.h-1)*d.w+clamp(x, 0, d.w-1)]
+}
+
+// interpolate returns the elevation at the position fx, fy, given as fractions
+// of the width and height of the tile, bilinearly interpolated between the
+// centers of the surrounding pixels.
+func (d *dem) interpolate(fx, fy float64) float64 {
+	px, py := fx*float64(d.w)-0.5, fy*float64(d.h)-0.5
+	x0, y0 := int(math.Floor(px)), int(math.Floor(py))
+	wx, wy := px-float64(x0), py-float64(y0)
+	top := d.at(x0, y0)*(1-wx) + d.at(x0+1, y0)*wx
+	bottom := d.at(x0, y0+1)*(1-wx) + d.at(x0+1, y0+1)*wx
+	return top*(1-wy) + bottom*wy
+}
+
+// elevation serves the elevation in meters of a terrain-RGB tileset at
+// "/services/<id>/elevation?lon=<lon>&lat=<lat>" as JSON. It is taken from
+// the tile at the zoom level given by the "zoom" parameter, which defaults to
+// the maximum zoom level of the tileset. The "encoding" parameter overrides
+// the demEncoding of the tileset.
+func (s *ServiceSet) elevation(id string, db Tileset) handlerFunc {
+	minZoom, maxZoom := s.policy(id).zooms(zoomRange(db))
+	enc := demEncodingOf(db)
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		lon, lat, err := queryLonLat(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		zoom, err := queryFloat(r, "zoom", float64(maxZoom))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		e, err := requestDEMEncoding(r, enc)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if minZoom > maxZoom {
+			// the TilesetPolicy allows none of the zoom levels of the tileset
+			return notFoundJSON(w, "No elevation at this position")
+		}
+		z := int(math.Max(float64(minZoom), math.Min(float64(maxZoom), math.Floor(zoom))))
+		tc, fx, fy := tileAt(lon, lat, uint8(z))
+		if status := s.interceptRead(r, id, db, tc); status != 0 {
+			return status, nil
+		}
+		d, err := readDEM(r.Context(), db, tc, e)
+		switch {
+		case err == mbtiles.ErrTileNotFound:
+			return notFoundJSON(w, "No elevation at this position")
+		case err == mbtiles.ErrQueryTimeout:
+			return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		case err != nil:
+			return http.StatusInternalServerError, fmt.Errorf("cannot read elevation from tile for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+		}
+		return writeJSON(w, map[string]interface{}{
+			"lon":       lon,
+			"lat":       lat,
+			"zoom":      z,
+			"elevation": math.Round(d.interpolate(fx, fy)*100) / 100,
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// layerInfo describes a layer of a vector tile.
+type layerInfo struct {
+	Name     string `json:"name"`
+	Version  uint32 `json:"version"`
+	Extent   uint32 `json:"extent"`
+	Features int    `json:"features"`
+	// GeometryTypes counts the features per geometry type.
+	GeometryTypes map[string]int `json:"geometryTypes"`
+	// Keys are the sorted names of the properties of the features.
+	Keys []string `json:"keys"`
+	// Size is the number of bytes of the uncompressed layer.
+	Size int `json:"size"`
+}
+
+// tileInfo describes a stored tile.
+type tileInfo struct {
+	Z        uint8  `json:"z"`
+	X        uint64 `json:"x"`
+	Y        uint64 `json:"y"`
+	Format   string `json:"format"`
+	Encoding string `json:"encoding"`
+	// Size is the number of bytes of the tile as stored.
+	Size int `json:"size"`
+	// DecodedSize is the number of bytes of the uncompressed tile.
+	DecodedSize int         `json:"decodedSize"`
+	Layers      []layerInfo `json:"layers,omitempty"`
+}
+
+// vectorLayerInfo returns the description of the layer l.
+func vectorLayerInfo(l *mvt.Layer) (layerInfo, error) {
+	info := layerInfo{
+		Name:          l.Name,
+		Version:       l.Version,
+		Extent:        l.Extent,
+		Features:      len(l.Features),
+		GeometryTypes: make(map[string]int),
+		Keys:          []string{},
+	}
+	keys := make(map[string]bool)
+	for _, f := range l.Features {
+		info.GeometryTypes[f.Type.String()]++
+		for k := range f.Properties {
+			if !keys[k] {
+				keys[k] = true
+				info.Keys = append(info.Keys, k)
+			}
+		}
+	}
+	sort.Strings(info.Keys)
+	data, err := mvt.Encode(&mvt.Tile{Layers: []*mvt.Layer{l}})
+	if err != nil {
+		return info, err
+	}
+	info.Size = len(data)
+	return info, nil
+}
+
+// tileInfo serves the description of the tile of db at the coordinates z, x
+// and y of the tileset id as JSON, including its layers if it is a vector tile.
+// Tiles beyond the zoom levels of its TilesetPolicy do not exist.
+func (s *ServiceSet) tileInfo(w http.ResponseWriter, r *http.Request, id string, db Tileset, z, x, y string) (int, error) {
+	tc, _, err := tileCoordFromString(z, x, y)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if !s.policy(id).allowsZoom(int(tc.z)) {
+		return notFoundJSON(w, "Tile does not exist")
+	}
+	info := tileInfo{Z: tc.z, X: tc.x, Y: tc.y, Format: db.TileFormat().String(), Encoding: db.TileEncoding().String()}
+	if s.Scheme == mbtiles.TMS {
+		tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+	}
+	if status := s.interceptRead(r, id, db, tc); status != 0 {
+		return status, nil
+	}
+	var data []byte
+	err = db.ReadTileContext(r.Context(), tc.z, tc.x, tc.y, &data)
+	switch {
+	case err == mbtiles.ErrTileNotFound:
+		return notFoundJSON(w, "Tile does not exist")
+	case err == mbtiles.ErrQueryTimeout:
+		return http.StatusServiceUnavailable, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+	case err != nil:
+		return http.StatusInternalServerError, fmt.Errorf("cannot fetch tile from DB for z=%d, x=%d, y=%d: %v", tc.z, tc.x, tc.y, err)
+	}
+	info.Size = len(data)
+	raw, err := db.TileEncoding().Decode(data)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	info.DecodedSize = len(raw)
+	if db.TileFormat() == mbtiles.PBF && len(raw) > 0 {
+		tile, err := mvt.Decode(raw)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot decode vector tile: %v", err)
+		}
+		info.Layers = []layerInfo{}
+		for _, l := range tile.Layers {
+			li, err := vectorLayerInfo(l)
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			info.Layers = append(info.Layers, li)
+		}
+	}
+	return writeJSON(w, info)
+}
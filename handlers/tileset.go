@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// Tileset is a tileset that is served by a ServiceSet. The handlers depend
+// only on Tileset and the optional interfaces below, so that tilesets of any
+// backend can be served with AddTilesetOnPath. A *mbtiles.DB opened in the
+// XYZ scheme is a Tileset, which is how the tilesets of AddDBOnPath and
+// AddStoreOnPath are served. The rows of the tiles are in the XYZ scheme.
+// Tilesets are compared with ==, e.g. to tell whether a tileset has been
+// replaced, so their dynamic types must be comparable, like pointers.
+type Tileset interface {
+	// ReadTileContext reads the tile at z, x, y into data. It returns
+	// mbtiles.ErrTileNotFound if there is no such tile.
+	ReadTileContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) error
+	// ReadGridContext reads the compressed UTF grid at z, x, y into data. It
+	// returns mbtiles.ErrGridNotFound if there is no such grid.
+	ReadGridContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) error
+	// ReadMetadata returns the metadata of the tileset as returned by
+	// mbtiles.DB.ReadMetadata.
+	ReadMetadata() (map[string]interface{}, error)
+	// TileFormat returns the format of the tiles.
+	TileFormat() mbtiles.TileFormat
+	// TileEncoding returns the compression of the tiles.
+	TileEncoding() mbtiles.TileEncoding
+	// HasUTFGrid reports whether the tileset has UTF grids.
+	HasUTFGrid() bool
+	// UTFGridCompression returns the compression of the UTF grids, which is
+	// either mbtiles.GZIP or mbtiles.ZLIB.
+	UTFGridCompression() mbtiles.TileFormat
+	// TimeStamp returns when the tileset has last been modified.
+	TimeStamp() time.Time
+	// Close releases the resources of the tileset once it is no longer
+	// served.
+	Close() error
+}
+
+// StatsTileset is implemented by Tilesets that can count their tiles, which
+// are served at "/services/<id>/stats".
+type StatsTileset interface {
+	StatsContext(ctx context.Context) (mbtiles.Stats, error)
+}
+
+// DeduplicatedTileset is implemented by Tilesets whose tiles have IDs, like
+// deduplicated mbtiles files, which let unchanged tiles be revalidated
+// without reading them.
+type DeduplicatedTileset interface {
+	// IsDeduplicated reports whether the tiles have IDs.
+	IsDeduplicated() bool
+	// ReadTileIDContext returns the ID of the tile at z, x, y or
+	// mbtiles.ErrTileNotFound.
+	ReadTileIDContext(ctx context.Context, z uint8, x uint64, y uint64) (string, error)
+}
+
+// TileChecker is implemented by Tilesets that can tell whether a tile exists
+// without reading it, which is used to answer HEAD requests.
+type TileChecker interface {
+	HasTileContext(ctx context.Context, z uint8, x uint64, y uint64) (bool, error)
+}
+
+// TileLister is implemented by Tilesets that can list their tiles, which can
+// then be downloaded as zip bundles.
+type TileLister interface {
+	// Tiles returns the tiles that match f with their rows in the XYZ
+	// scheme.
+	Tiles(ctx context.Context, f mbtiles.TileFilter) (*mbtiles.TileIterator, error)
+}
+
+// Extractor is implemented by Tilesets that can copy their tiles to a new
+// mbtiles file, which can then be downloaded as mbtiles bundle.
+type Extractor interface {
+	Extract(ctx context.Context, dst string, f mbtiles.TileFilter, opts ...mbtiles.Option) error
+}
+
+// cachingTileset is implemented by Tilesets with a tile cache, like a
+// mbtiles.DB.
+type cachingTileset interface {
+	CacheStats() mbtiles.CacheStats
+	PurgeCache(z int)
+}
+
+// connectedTileset is implemented by Tilesets with a pool of database
+// connections, like a mbtiles.DB.
+type connectedTileset interface {
+	OpenConnections() int
+}
+
+// tileIDs returns the DeduplicatedTileset of ts if its tiles have IDs.
+func tileIDs(ts Tileset) (DeduplicatedTileset, bool) {
+	d, ok := ts.(DeduplicatedTileset)
+	return d, ok && d.IsDeduplicated()
+}
+
+// hasTile reports whether ts has a tile at z, x, y, which is read if ts is not
+// a TileChecker.
+func hasTile(ctx context.Context, ts Tileset, z uint8, x, y uint64) (bool, error) {
+	if c, ok := ts.(TileChecker); ok {
+		return c.HasTileContext(ctx, z, x, y)
+	}
+	var data []byte
+	err := ts.ReadTileContext(ctx, z, x, y, &data)
+	if err == mbtiles.ErrTileNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// cacheStats returns the statistics of the tile cache of ts, which are zero
+// if ts has none.
+func cacheStats(ts Tileset) mbtiles.CacheStats {
+	if c, ok := ts.(cachingTileset); ok {
+		return c.CacheStats()
+	}
+	return mbtiles.CacheStats{}
+}
+
+// openConnections returns the number of database connections of ts.
+func openConnections(ts Tileset) int {
+	if c, ok := ts.(connectedTileset); ok {
+		return c.OpenConnections()
+	}
+	return 0
+}
+
+// readTileDecompressed is like Tileset.ReadTileContext, but removes the
+// TileEncoding from the tile data.
+func readTileDecompressed(ctx context.Context, ts Tileset, z uint8, x, y uint64, data *[]byte) error {
+	err := ts.ReadTileContext(ctx, z, x, y, data)
+	if err != nil {
+		return err
+	}
+	*data, err = ts.TileEncoding().Decode(*data)
+	return err
+}
+
+// readGridDecompressed is like Tileset.ReadGridContext, but returns the plain
+// JSON of the grid.
+func readGridDecompressed(ctx context.Context, ts Tileset, z uint8, x, y uint64, data *[]byte) error {
+	err := ts.ReadGridContext(ctx, z, x, y, data)
+	if err != nil {
+		return err
+	}
+	*data, err = mbtiles.DecompressGrid(*data, ts.UTFGridCompression())
+	return err
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+)
+
+// The tilesets of a ServiceSet are replaced by a modified copy whenever a
+// tileset is added, replaced or removed, which starts a new generation of
+// tilesets. Requests hold on to the generation they started in, so a DB
+// that is no longer served is only closed after all requests of the
+// generations in which it was served have been answered.
+
+// retiredDB is a DB that is no longer served since generation gen.
+type retiredDB struct {
+	db  Tileset
+	gen uint64
+}
+
+// update replaces the tilesets by a copy that has been modified by f and
+// retires the DBs that are no longer served.
+func (s *ServiceSet) update(f func(map[string]Tileset)) {
+	s.mu.Lock()
+	old := s.tilesets
+	tilesets := make(map[string]Tileset, len(old)+1)
+	for id, db := range old {
+		tilesets[id] = db
+	}
+	f(tilesets)
+	s.tilesets = tilesets
+	s.gen++
+	for id, db := range old {
+		if tilesets[id] != db {
+			s.retired = append(s.retired, retiredDB{db, s.gen})
+		}
+	}
+	closable := s.closable()
+	s.mu.Unlock()
+	s.closeRetired(closable)
+}
+
+// dbs returns the current tilesets of the ServiceSet, which must not be
+// modified.
+func (s *ServiceSet) dbs() map[string]Tileset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tilesets
+}
+
+// acquire returns the current tilesets and their generation, which must be
+// passed to release once they are no longer used.
+func (s *ServiceSet) acquire() (map[string]Tileset, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[s.gen]++
+	return s.tilesets, s.gen
+}
+
+// release releases the tilesets of generation gen and closes the retired
+// DBs that are no longer used.
+func (s *ServiceSet) release(gen uint64) {
+	s.mu.Lock()
+	if s.inflight[gen]--; s.inflight[gen] == 0 {
+		delete(s.inflight, gen)
+	}
+	closable := s.closable()
+	s.mu.Unlock()
+	s.closeRetired(closable)
+}
+
+// closable removes the retired DBs that are no longer used by requests from
+// the retired DBs and returns them. It must be called with s.mu held.
+func (s *ServiceSet) closable() []Tileset {
+	var dbs []Tileset
+	retired := s.retired[:0]
+	for _, r := range s.retired {
+		used := false
+		for gen := range s.inflight {
+			if gen < r.gen {
+				used = true
+				break
+			}
+		}
+		if used {
+			retired = append(retired, r)
+		} else {
+			dbs = append(dbs, r.db)
+		}
+	}
+	s.retired = retired
+	return dbs
+}
+
+// closeRetired closes the retired DBs dbs.
+func (s *ServiceSet) closeRetired(dbs []Tileset) {
+	for _, db := range dbs {
+		s.metrics.forget(db)
+		s.caches.forget(db)
+		db.Close()
+	}
+}
+
+// builtHandler is a http.Handler that has been built for the tilesets of
+// generation gen.
+type builtHandler struct {
+	http.Handler
+	gen uint64
+}
+
+// rebuilt returns a http.Handler that serves requests with the http.Handler
+// created by build for the current tilesets, which is created again after
+// tilesets have been added, replaced or removed. The handlers are built
+// without holding a lock, so requests are not blocked while the metadata of
+// the tilesets is read.
+func (s *ServiceSet) rebuilt(build func(map[string]Tileset) http.Handler) http.Handler {
+	var mu sync.Mutex
+	var h *builtHandler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tilesets, cur := s.acquire()
+		defer s.release(cur)
+		mu.Lock()
+		current := h
+		mu.Unlock()
+		// a handler of a newer generation may be used as well, as its DBs
+		// are not closed before the ones of the current generation
+		if current == nil || current.gen < cur {
+			built := &builtHandler{build(tilesets), cur}
+			mu.Lock()
+			if h == nil || h.gen < built.gen {
+				h = built
+			}
+			current = h
+			mu.Unlock()
+		}
+		current.ServeHTTP(w, r)
+	})
+}
+
+// cacheKey identifies a cache of the handlers of a tileset by its name.
+type cacheKey struct {
+	db   Tileset
+	name string
+}
+
+// handlerCaches holds the caches of the handlers of the tilesets by the
+// Tileset they belong to, so that they survive the rebuilds of the handlers
+// after other tilesets have been added, replaced or removed. The zero value
+// is ready to use.
+type handlerCaches struct {
+	mu     sync.Mutex
+	caches map[cacheKey]interface{}
+}
+
+// get returns the cache name of db, which is created by create if there is
+// none yet.
+func (c *handlerCaches) get(db Tileset, name string, create func() interface{}) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := cacheKey{db, name}
+	v, ok := c.caches[k]
+	if !ok {
+		if c.caches == nil {
+			c.caches = make(map[cacheKey]interface{})
+		}
+		v = create()
+		c.caches[k] = v
+	}
+	return v
+}
+
+// forget removes the caches of db, which is no longer served.
+func (c *handlerCaches) forget(db Tileset) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.caches {
+		if k.db == db {
+			delete(c.caches, k)
+		}
+	}
+}
+
+// etagCache returns the etagCache of the responses from db.
+func (s *ServiceSet) etagCache(db Tileset) *etagCache {
+	return s.caches.get(db, "etags", func() interface{} { return newETagCache() }).(*etagCache)
+}
+
+// convertedCache returns the convertedCache name of db.
+func (s *ServiceSet) convertedCache(db Tileset, name string) *convertedCache {
+	return s.caches.get(db, name, func() interface{} { return newConvertedCache() }).(*convertedCache)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Tracer creates the spans that trace the requests to the handlers of a
+// ServiceSet. It is implemented by the otlp package, which exports the spans
+// to an OpenTelemetry collector, and can be implemented with other tracing
+// libraries as well.
+type Tracer interface {
+	// StartRequest starts the span of the request r, which should continue
+	// the trace propagated by the headers of r, e.g. in "traceparent".
+	StartRequest(r *http.Request, name string) (context.Context, Span)
+	// Start starts a span as child of the span of ctx.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End()                                       {}
+
+// startSpan starts a child span of the span of ctx with the Tracer of the
+// ServiceSet, if any.
+func (s *ServiceSet) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if s.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return s.Tracer.Start(ctx, name)
+}
+
+// statusWriter records the status code and the number of body bytes of a
+// response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// The optional interfaces of the underlying ResponseWriter are passed through,
+// so that wrapping it does not disable streaming, sendfile or hijacking.
+
+// Flush sends the buffered data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom copies the data of r to the underlying ResponseWriter, which uses
+// sendfile for files if it can.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.n += n
+	return n, err
+}
+
+// Hijack takes over the connection of the underlying ResponseWriter.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Push initiates an HTTP/2 server push with the underlying ResponseWriter.
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// traced returns a http.Handler that traces every request to h in a span,
+// if the ServiceSet has a Tracer.
+func (s *ServiceSet) traced(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Tracer == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := s.Tracer.StartRequest(r, r.Method+" "+r.URL.Path)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", sw.status)
+	})
+}
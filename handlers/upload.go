@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// validUploadID matches the IDs of uploaded tilesets, which consist of one or
+// more "/"-separated segments of lower-case letters, digits, ".", "-" and "_"
+// that do not start with ".".
+var validUploadID = regexp.MustCompile(`^[a-z0-9_-][a-z0-9._-]*(/[a-z0-9_-][a-z0-9._-]*)*$`)
+
+// AdminHandler returns a http.Handler that serves the admin endpoints of the
+// ServiceSet, which require the AdminKey. POST requests to "/admin/tilesets"
+// upload the mbtiles file in the request body as the tileset with the ID in
+// the "id" query parameter.
+// The upload is written to a temporary file in the UploadDir, which is opened
+// and checked for integrity before it is linked to "<id>.mbtiles" and added
+// to the ServiceSet. Existing tilesets cannot be replaced by uploads, also not
+// by concurrent ones.
+// POST requests to "/admin/purge?tileset=<id>[&z=<z>]" purge the cached
+// responses for the tileset, or only for its zoom level z, from the internal
+// caches and the CDNs of the Purgers.
+func (s *ServiceSet) AdminHandler(ef func(error)) http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/admin/tilesets", wrapWithErrors(ef, s.admin(s.upload), "POST"))
+	m.Handle("/admin/purge", wrapWithErrors(ef, s.admin(s.purge), "POST"))
+	return m
+}
+
+// admin returns a handlerFunc that serves requests that carry the AdminKey
+// with hf.
+func (s *ServiceSet) admin(hf handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if s.AdminKey == "" {
+			return http.StatusNotFound, nil
+		}
+		key := requestAPIKey(r)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.AdminKey)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mbtileserver"`)
+			if key == "" {
+				return http.StatusUnauthorized, nil
+			}
+			return http.StatusUnauthorized, fmt.Errorf("invalid admin key")
+		}
+		return hf(w, r)
+	}
+}
+
+// upload serves the uploads of tilesets.
+func (s *ServiceSet) upload(w http.ResponseWriter, r *http.Request) (int, error) {
+	id := r.URL.Query().Get("id")
+	if !validUploadID.MatchString(id) {
+		return http.StatusBadRequest, fmt.Errorf("invalid ID %q of uploaded tileset", id)
+	}
+	filename := filepath.Join(s.UploadDir, filepath.FromSlash(id)+".mbtiles")
+	if _, ok := s.dbs()[id]; ok {
+		return http.StatusConflict, nil
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return http.StatusConflict, nil
+	}
+	if s.MaxUploadSize > 0 && r.ContentLength > s.MaxUploadSize {
+		return http.StatusRequestEntityTooLarge, nil
+	}
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer os.Remove(tmp.Name())
+	status, err := receiveUpload(tmp, r.Body, s.MaxUploadSize)
+	if err != nil {
+		return status, err
+	}
+
+	db, err := mbtiles.NewDB(tmp.Name(), mbtiles.CheckIntegrity())
+	if err != nil {
+		return http.StatusUnprocessableEntity, fmt.Errorf("invalid upload of tileset %s: %v", id, err)
+	}
+	db.Close()
+
+	if status, err := s.publish(tmp.Name(), filename, id); status != http.StatusOK {
+		return status, err
+	}
+
+	u := fmt.Sprintf("%s/services/%s", s.RootURL(r), id)
+	bytes, err := json.Marshal(map[string]string{"id": id, "url": u})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal JSON: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", u)
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(bytes)
+	return http.StatusCreated, err
+}
+
+// publish links the uploaded file tmp to filename, unless it exists, and adds
+// it to the ServiceSet as the tileset id.
+func (s *ServiceSet) publish(tmp, filename, id string) (int, error) {
+	if s.UploadLock != nil {
+		s.UploadLock.Lock()
+		defer s.UploadLock.Unlock()
+	}
+	if _, ok := s.dbs()[id]; ok {
+		return http.StatusConflict, nil
+	}
+	// unlike a rename, the link fails if another upload has been published
+	// since the checks before receiving this one
+	if err := os.Link(tmp, filename); err != nil {
+		if os.IsExist(err) {
+			return http.StatusConflict, nil
+		}
+		return http.StatusInternalServerError, err
+	}
+	if err := s.AddDBOnPath(filename, id, s.UploadOptions...); err != nil {
+		os.Remove(filename)
+		return http.StatusInternalServerError, err
+	}
+	if s.Uploaded != nil {
+		s.Uploaded(id, filename)
+	}
+	return http.StatusOK, nil
+}
+
+// receiveUpload copies the request body to the file f, up to maxSize bytes
+// if it is not zero, and syncs and closes f.
+func receiveUpload(f *os.File, body io.Reader, maxSize int64) (int, error) {
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+	n, err := io.Copy(f, body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot receive upload: %v", err)
+	}
+	if maxSize > 0 && n > maxSize {
+		return http.StatusRequestEntityTooLarge, nil
+	}
+	return http.StatusOK, nil
+}
@@ -1,7 +1,8 @@
-package main
+package handlers
 
 import (
 	"math"
+	"strings"
 )
 
 // Cast interface to a string if not nil, otherwise empty string
@@ -12,6 +13,12 @@ func toString(s interface{}) string {
 	return ""
 }
 
+// validName reports whether name can be the name of a style, font or sprite,
+// which must not refer to anything outside of their directory.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
 // Convert a latitude and longitude to mercator coordinates, bounded to world domain.
 func geoToMercator(longitude, latitude float64) (float64, float64) {
 	// bound to world coordinates
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+	"github.com/consbio/mbtileserver/mvt"
+)
+
+// vectorFilter selects the parts of vector tiles that are sent to a client.
+type vectorFilter struct {
+	// layers are the names of the layers that are kept, all layers are kept
+	// if it is nil
+	layers map[string]bool
+	// fields are the names of the properties that are kept, all properties
+	// are kept if it is nil
+	fields map[string]bool
+	// conditions must all be met by the features that are kept
+	conditions []condition
+}
+
+// parseNames parses the comma separated names of the query parameter key of q
+// into a set, which is nil if the parameter is not given.
+func parseNames(q url.Values, key string) (map[string]bool, error) {
+	v, ok := q[key]
+	if !ok {
+		return nil, nil
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Split(strings.Join(v, ","), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no %s given", key)
+	}
+	return names, nil
+}
+
+// parseVectorFilter returns the vectorFilter given by the query parameters q,
+// or nil if there is none. The "layers" and "fields" parameters are comma
+// separated lists of the layers and the properties that are kept, and every
+// "filter" parameter is a condition like "class=motorway" that the features
+// that are kept must meet.
+func parseVectorFilter(q url.Values) (*vectorFilter, error) {
+	f := &vectorFilter{}
+	var err error
+	if f.layers, err = parseNames(q, "layers"); err != nil {
+		return nil, err
+	}
+	if f.fields, err = parseNames(q, "fields"); err != nil {
+		return nil, err
+	}
+	for _, v := range q["filter"] {
+		c, err := parseCondition(v)
+		if err != nil {
+			return nil, err
+		}
+		f.conditions = append(f.conditions, c)
+	}
+	if f.layers == nil && f.fields == nil && f.conditions == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// apply removes the parts of t that are not selected by f. Layers without
+// any remaining features are removed as well.
+func (f *vectorFilter) apply(t *mvt.Tile) {
+	layers := t.Layers[:0]
+	for _, l := range t.Layers {
+		if f.layers != nil && !f.layers[l.Name] {
+			continue
+		}
+		features := l.Features[:0]
+		for _, ft := range l.Features {
+			if !f.matches(ft) {
+				continue
+			}
+			if f.fields != nil {
+				for k := range ft.Properties {
+					if !f.fields[k] {
+						delete(ft.Properties, k)
+					}
+				}
+			}
+			features = append(features, ft)
+		}
+		if l.Features = features; len(features) > 0 {
+			layers = append(layers, l)
+		}
+	}
+	t.Layers = layers
+}
+
+// matches reports whether the feature ft meets all conditions of f.
+func (f *vectorFilter) matches(ft *mvt.Feature) bool {
+	for _, c := range f.conditions {
+		if !c.matches(ft.Properties) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionOps are the comparison operators of conditions, the longer ones
+// first.
+var conditionOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// condition compares a property of features with a value.
+type condition struct {
+	key   string
+	op    string
+	value string
+}
+
+// parseCondition parses a condition like "class=motorway" or "lanes>=2" with
+// one of the conditionOps.
+func parseCondition(s string) (condition, error) {
+	i := strings.IndexAny(s, "!=<>")
+	if i > 0 {
+		for _, op := range conditionOps {
+			if strings.HasPrefix(s[i:], op) {
+				return condition{key: strings.TrimSpace(s[:i]), op: op, value: strings.TrimSpace(s[i+len(op):])}, nil
+			}
+		}
+	}
+	return condition{}, fmt.Errorf("invalid filter %q: expected <property><operator><value> with one of the operators %s", s, strings.Join(conditionOps, " "))
+}
+
+// matches reports whether the properties meet the condition c. Numbers are
+// compared numerically, other values by their string representation, which
+// only supports "=" and "!=". A missing property only meets "!=".
+func (c condition) matches(properties map[string]interface{}) bool {
+	v, ok := properties[c.key]
+	if !ok {
+		return c.op == "!="
+	}
+	cmp, ok := compareNumber(v, c.value)
+	if !ok {
+		equal := fmt.Sprint(v) == c.value
+		switch c.op {
+		case "=":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// compareNumber compares the property value v with the number s and returns
+// -1, 0 or 1 if v is less than, equal to or greater than it. It returns false
+// if v or s is not a number.
+func compareNumber(v interface{}, s string) (int, bool) {
+	var a float64
+	switch v := v.(type) {
+	case int64:
+		a = float64(v)
+	case uint64:
+		a = float64(v)
+	case float32:
+		a = float64(v)
+	case float64:
+		a = v
+	default:
+		return 0, false
+	}
+	b, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	}
+	return 0, true
+}
+
+// writeFilteredVector writes the vector tile data of db, which is compressed
+// with enc, after applying the filter f. The filtered tile is compressed with
+// gzip if the client accepts it.
+func (s *ServiceSet) writeFilteredVector(w http.ResponseWriter, r *http.Request, db Tileset, data []byte, enc mbtiles.TileEncoding, f *vectorFilter) (int, error) {
+	_, span := s.startSpan(r.Context(), "filter")
+	defer span.End()
+	raw, err := enc.Decode(data)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	tile, err := mvt.Decode(raw)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot decode vector tile: %v", err)
+	}
+	f.apply(tile)
+	if data, err = mvt.Encode(tile); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", db.TileFormat().ContentType())
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsEncoding(r, "gzip") {
+		if data, err = gzipBytes(data); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	return writeWithETag(w, r, data)
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// limits of GetMap requests, which protect the server from excessive memory
+// and CPU usage
+const (
+	wmsMaxSize  = 4096
+	wmsMaxTiles = 256
+)
+
+// mercatorOrigin is half of the circumference of the earth in web mercator
+// meters.
+const mercatorOrigin = 20037508.342789244
+
+// wmsFormats are the image formats that GetMap may answer with, provided that
+// there is an encoder for them.
+var wmsFormats = []mbtiles.TileFormat{mbtiles.PNG, mbtiles.JPG, mbtiles.WEBP, mbtiles.AVIF}
+
+type wmsCapabilities struct {
+	XMLName    xml.Name `xml:"WMS_Capabilities"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsXlink string   `xml:"xmlns:xlink,attr"`
+	Version    string   `xml:"version,attr"`
+	Service    struct {
+		Name     string `xml:"Name"`
+		Title    string `xml:"Title"`
+		Abstract string `xml:"Abstract,omitempty"`
+	} `xml:"Service"`
+	Capability struct {
+		GetCapabilities wmsOperation `xml:"Request>GetCapabilities"`
+		GetMap          wmsOperation `xml:"Request>GetMap"`
+		Exception       []string     `xml:"Exception>Format"`
+		Layer           wmsLayer     `xml:"Layer"`
+	} `xml:"Capability"`
+}
+
+type wmsOperation struct {
+	Format         []string `xml:"Format"`
+	OnlineResource wmsLink  `xml:"DCPType>HTTP>Get>OnlineResource"`
+}
+
+type wmsLink struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+type wmsLayer struct {
+	Queryable   int              `xml:"queryable,attr"`
+	Name        string           `xml:"Name"`
+	Title       string           `xml:"Title"`
+	Abstract    string           `xml:"Abstract,omitempty"`
+	CRS         []string         `xml:"CRS"`
+	WestBound   float64          `xml:"EX_GeographicBoundingBox>westBoundLongitude"`
+	EastBound   float64          `xml:"EX_GeographicBoundingBox>eastBoundLongitude"`
+	SouthBound  float64          `xml:"EX_GeographicBoundingBox>southBoundLatitude"`
+	NorthBound  float64          `xml:"EX_GeographicBoundingBox>northBoundLatitude"`
+	BoundingBox []wmsBoundingBox `xml:"BoundingBox"`
+}
+
+type wmsBoundingBox struct {
+	CRS  string  `xml:"CRS,attr"`
+	MinX float64 `xml:"minx,attr"`
+	MinY float64 `xml:"miny,attr"`
+	MaxX float64 `xml:"maxx,attr"`
+	MaxY float64 `xml:"maxy,attr"`
+}
+
+func (s *ServiceSet) wmsCapabilities(id string, db Tileset, w http.ResponseWriter, r *http.Request) (int, error) {
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+	}
+	name := toString(metadata["name"])
+	if name == "" {
+		name = id
+	}
+	bounds, ok := metadata["bounds"].([]float64)
+	if !ok || len(bounds) != 4 {
+		bounds = []float64{-180, -85.05112878, 180, 85.05112878}
+	}
+	var formats []string
+	for _, f := range wmsFormats {
+		if canConvert(db.TileFormat(), f) {
+			formats = append(formats, f.ContentType())
+		}
+	}
+	href := fmt.Sprintf("%s/services/%s/wms?", s.RootURL(r), id)
+	minX, minY := lonLatToMercator(bounds[0], bounds[1])
+	maxX, maxY := lonLatToMercator(bounds[2], bounds[3])
+
+	caps := &wmsCapabilities{
+		Xmlns:      "http://www.opengis.net/wms",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    "1.3.0",
+	}
+	caps.Service.Name = "WMS"
+	caps.Service.Title = name
+	caps.Service.Abstract = toString(metadata["description"])
+	caps.Capability.GetCapabilities = wmsOperation{Format: []string{"text/xml"}, OnlineResource: wmsLink{href}}
+	caps.Capability.GetMap = wmsOperation{Format: formats, OnlineResource: wmsLink{href}}
+	caps.Capability.Exception = []string{"XML"}
+	caps.Capability.Layer = wmsLayer{
+		Name:       id,
+		Title:      name,
+		Abstract:   toString(metadata["description"]),
+		CRS:        []string{"EPSG:3857", "EPSG:4326", "CRS:84"},
+		WestBound:  bounds[0],
+		EastBound:  bounds[2],
+		SouthBound: bounds[1],
+		NorthBound: bounds[3],
+		BoundingBox: []wmsBoundingBox{
+			{CRS: "EPSG:3857", MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY},
+			// EPSG:4326 has latitude first in WMS 1.3.0
+			{CRS: "EPSG:4326", MinX: bounds[1], MinY: bounds[0], MaxX: bounds[3], MaxY: bounds[2]},
+			{CRS: "CRS:84", MinX: bounds[0], MinY: bounds[1], MaxX: bounds[2], MaxY: bounds[3]},
+		},
+	}
+	bytes, err := xml.MarshalIndent(caps, "", "  ")
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal WMS capabilities XML: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	if _, err = w.Write([]byte(xml.Header)); err != nil {
+		return http.StatusOK, err
+	}
+	_, err = w.Write(bytes)
+	return http.StatusOK, err
+}
+
+// lonLatToMercator converts geographic coordinates to web mercator meters.
+func lonLatToMercator(lon, lat float64) (float64, float64) {
+	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	x := lon * mercatorOrigin / 180
+	y := math.Log(math.Tan((90+lat)*math.Pi/360)) * mercatorOrigin / math.Pi
+	return x, y
+}
+
+// wmsRequest contains the parsed parameters of a GetMap request.
+type wmsRequest struct {
+	layer         string
+	crs           string
+	bbox          [4]float64
+	width, height int
+	format        mbtiles.TileFormat
+	transparent   bool
+	bgcolor       color.RGBA
+}
+
+// toMercator returns a function that converts the coordinates of a position
+// in the CRS of the request, given in the axis order of the BBOX parameter, to
+// web mercator meters.
+func (req *wmsRequest) toMercator() func(a, b float64) (float64, float64) {
+	switch req.crs {
+	case "EPSG:3857", "EPSG:900913":
+		return func(x, y float64) (float64, float64) { return x, y }
+	case "EPSG:4326":
+		return func(lat, lon float64) (float64, float64) { return lonLatToMercator(lon, lat) }
+	default: // CRS:84
+		return lonLatToMercator
+	}
+}
+
+// parseGetMap parses the (upper case) parameters of a GetMap request.
+func parseGetMap(params map[string]string) (*wmsRequest, error) {
+	req := &wmsRequest{
+		layer:   params["LAYERS"],
+		crs:     strings.ToUpper(params["CRS"]),
+		bgcolor: color.RGBA{0xff, 0xff, 0xff, 0xff},
+	}
+	if req.crs == "" {
+		// WMS 1.1.1
+		req.crs = strings.ToUpper(params["SRS"])
+		if req.crs == "EPSG:4326" {
+			req.crs = "CRS:84" // longitude first
+		}
+	}
+	switch req.crs {
+	case "EPSG:3857", "EPSG:900913", "EPSG:4326", "CRS:84":
+	default:
+		return nil, fmt.Errorf("unsupported CRS %q", req.crs)
+	}
+	pcs := strings.Split(params["BBOX"], ",")
+	if len(pcs) != 4 {
+		return nil, fmt.Errorf("invalid BBOX %q", params["BBOX"])
+	}
+	for i, p := range pcs {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BBOX %q", params["BBOX"])
+		}
+		req.bbox[i] = v
+	}
+	if req.bbox[0] >= req.bbox[2] || req.bbox[1] >= req.bbox[3] {
+		return nil, fmt.Errorf("invalid BBOX %q", params["BBOX"])
+	}
+	var err error
+	if req.width, err = strconv.Atoi(params["WIDTH"]); err != nil || req.width <= 0 || req.width > wmsMaxSize {
+		return nil, fmt.Errorf("WIDTH must be between 1 and %d", wmsMaxSize)
+	}
+	if req.height, err = strconv.Atoi(params["HEIGHT"]); err != nil || req.height <= 0 || req.height > wmsMaxSize {
+		return nil, fmt.Errorf("HEIGHT must be between 1 and %d", wmsMaxSize)
+	}
+	req.format = mbtiles.UNKNOWN
+	for _, f := range wmsFormats {
+		if strings.EqualFold(params["FORMAT"], f.ContentType()) {
+			req.format = f
+		}
+	}
+	if req.format == mbtiles.UNKNOWN {
+		return nil, fmt.Errorf("unsupported FORMAT %q", params["FORMAT"])
+	}
+	req.transparent = strings.EqualFold(params["TRANSPARENT"], "TRUE")
+	if bg := params["BGCOLOR"]; bg != "" {
+		v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(bg), "0x"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BGCOLOR %q", bg)
+		}
+		req.bgcolor = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+	}
+	return req, nil
+}
+
+// getMap renders the map image of req from the tiles of db at the zoom level
+// whose resolution best matches the requested one. No tiles are used if
+// minZoom exceeds maxZoom, and only those that are allowed by allow.
+func getMap(ctx context.Context, db Tileset, req *wmsRequest, minZoom, maxZoom int, allow func(tileCoord) bool) (*image.RGBA, error) {
+	toMercator := req.toMercator()
+	minX, minY := toMercator(req.bbox[0], req.bbox[1])
+	maxX, maxY := toMercator(req.bbox[2], req.bbox[3])
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+	// choose the first zoom level that has at least the requested resolution
+	res := (maxX - minX) / float64(req.width)
+	// allow for rounding errors if the resolution is that of a zoom level
+	z := int(math.Ceil(math.Log2(2*mercatorOrigin/(256*res)) - 1e-9))
+	if z < minZoom {
+		z = minZoom
+	}
+	if z > maxZoom {
+		z = maxZoom
+	}
+	n := uint64(1) << uint(z)
+	tileIndex := func(v float64) uint64 {
+		i := math.Floor(v / (2 * mercatorOrigin) * float64(n))
+		return uint64(math.Max(0, math.Min(i, float64(n-1))))
+	}
+	x0, x1 := tileIndex(minX+mercatorOrigin), tileIndex(maxX+mercatorOrigin)
+	y0, y1 := tileIndex(mercatorOrigin-maxY), tileIndex(mercatorOrigin-minY)
+	if (x1-x0+1)*(y1-y0+1) > wmsMaxTiles {
+		return nil, errTooManyTiles
+	}
+
+	// stitch the tiles into a mosaic
+	var mosaic *image.RGBA
+	tileSize := 256
+	for y := y0; y <= y1 && minZoom <= maxZoom; y++ {
+		for x := x0; x <= x1; x++ {
+			if !allow(tileCoord{uint8(z), x, y}) {
+				continue
+			}
+			var data []byte
+			err := db.ReadTileContext(ctx, uint8(z), x, y, &data)
+			if err == mbtiles.ErrTileNotFound || (err == nil && len(data) <= 1) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			img, err := decodeTile(data, db.TileFormat())
+			if err != nil {
+				return nil, err
+			}
+			if mosaic == nil {
+				tileSize = img.Bounds().Dx()
+				mosaic = image.NewRGBA(image.Rect(0, 0, int(x1-x0+1)*tileSize, int(y1-y0+1)*tileSize))
+			}
+			p := image.Pt(int(x-x0)*tileSize, int(y-y0)*tileSize)
+			draw.Draw(mosaic, image.Rectangle{p, p.Add(image.Pt(tileSize, tileSize))}, img, img.Bounds().Min, draw.Src)
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, req.width, req.height))
+	if !req.transparent {
+		draw.Draw(out, out.Bounds(), image.NewUniform(req.bgcolor), image.ZP, draw.Src)
+	}
+	if mosaic == nil {
+		return out, nil
+	}
+	// sample the mosaic at the center of each output pixel
+	layer := image.NewRGBA(out.Bounds())
+	scale := float64(n) * float64(tileSize) / (2 * mercatorOrigin)
+	ox, oy := float64(x0)*float64(tileSize), float64(y0)*float64(tileSize)
+	mb := mosaic.Bounds()
+	dx := (req.bbox[2] - req.bbox[0]) / float64(req.width)
+	dy := (req.bbox[3] - req.bbox[1]) / float64(req.height)
+	for py := 0; py < req.height; py++ {
+		for px := 0; px < req.width; px++ {
+			a := req.bbox[0] + (float64(px)+0.5)*dx
+			b := req.bbox[3] - (float64(py)+0.5)*dy
+			if req.crs == "EPSG:4326" {
+				// latitude first: the rows of the image run along the first axis
+				a = req.bbox[2] - (float64(py)+0.5)*(req.bbox[2]-req.bbox[0])/float64(req.height)
+				b = req.bbox[1] + (float64(px)+0.5)*(req.bbox[3]-req.bbox[1])/float64(req.width)
+			}
+			mx, my := toMercator(a, b)
+			fx := (mx+mercatorOrigin)*scale - ox - 0.5
+			fy := (mercatorOrigin-my)*scale - oy - 0.5
+			if fx < -0.5 || fy < -0.5 || fx > float64(mb.Dx())-0.5 || fy > float64(mb.Dy())-0.5 {
+				continue
+			}
+			o := layer.PixOffset(px, py)
+			bilinear(mosaic, mb, fx, fy, layer.Pix[o:o+4])
+		}
+	}
+	draw.Draw(out, out.Bounds(), layer, image.ZP, draw.Over)
+	return out, nil
+}
+
+// zoomRange returns the minimum and maximum zoom level of db according to its
+// metadata, or 0 and 22 if they are not known.
+func zoomRange(db Tileset) (int, int) {
+	minZoom, maxZoom := 0, 22
+	if metadata, err := db.ReadMetadata(); err == nil {
+		if z, ok := metadata["minzoom"].(int); ok {
+			minZoom = z
+		}
+		if z, ok := metadata["maxzoom"].(int); ok {
+			maxZoom = z
+		}
+	}
+	return minZoom, maxZoom
+}
+
+// errTooManyTiles is returned by getMap if the requested map would require too
+// many tiles.
+var errTooManyTiles = fmt.Errorf("requested map covers too many tiles")
+
+// wms serves the WMS 1.3.0 operations GetCapabilities and GetMap of a raster
+// tileset.
+func (s *ServiceSet) wms(id string, db Tileset) handlerFunc {
+	minZoom, maxZoom := s.policy(id).zooms(zoomRange(db))
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		// WMS parameter names are case insensitive
+		params := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			if len(v) > 0 {
+				params[strings.ToUpper(k)] = v[0]
+			}
+		}
+		if !strings.EqualFold(params["SERVICE"], "WMS") {
+			return http.StatusBadRequest, fmt.Errorf("missing or invalid WMS service parameter")
+		}
+		switch params["REQUEST"] {
+		case "GetCapabilities":
+			return s.wmsCapabilities(id, db, w, r)
+		case "GetMap":
+		default:
+			return http.StatusBadRequest, fmt.Errorf("unsupported WMS request %q", params["REQUEST"])
+		}
+		req, err := parseGetMap(params)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if req.layer != id {
+			return http.StatusBadRequest, fmt.Errorf("unknown WMS layer %q", req.layer)
+		}
+		if !canConvert(db.TileFormat(), req.format) {
+			return http.StatusBadRequest, fmt.Errorf("cannot render tiles of format %s as %s", db.TileFormat(), req.format.ContentType())
+		}
+		img, err := getMap(r.Context(), db, req, minZoom, maxZoom, func(tc tileCoord) bool {
+			return s.interceptRead(r, id, db, tc) == 0
+		})
+		if err == errTooManyTiles {
+			return http.StatusBadRequest, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("cannot render WMS map for tileset %v: %v", id, err)
+		}
+		data, err := encodeTile(img, req.format, s.ImageQuality)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("Content-Type", req.format.ContentType())
+		_, err = w.Write(data)
+		return http.StatusOK, err
+	}
+}
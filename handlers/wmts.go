@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// wmtsTileMatrixSet is the identifier of the only tile matrix set that is
+// offered by the WMTS endpoints, the well-known scale set of web mercator
+// tiles.
+const wmtsTileMatrixSet = "GoogleMapsCompatible"
+
+// scale denominator and top left corner of zoom level 0 of the
+// GoogleMapsCompatible tile matrix set
+const (
+	wmtsScaleDenominator = 559082264.0287178
+	wmtsOrigin           = 20037508.3427892
+)
+
+type wmtsCapabilities struct {
+	XMLName               xml.Name                  `xml:"Capabilities"`
+	Xmlns                 string                    `xml:"xmlns,attr"`
+	XmlnsOws              string                    `xml:"xmlns:ows,attr"`
+	XmlnsXlink            string                    `xml:"xmlns:xlink,attr"`
+	Version               string                    `xml:"version,attr"`
+	ServiceIdentification wmtsServiceIdentification `xml:"ows:ServiceIdentification"`
+	OperationsMetadata    []wmtsOperation           `xml:"ows:OperationsMetadata>ows:Operation"`
+	Layer                 wmtsLayer                 `xml:"Contents>Layer"`
+	TileMatrixSet         wmtsTileMatrixSetDef      `xml:"Contents>TileMatrixSet"`
+	ServiceMetadataURL    wmtsLink                  `xml:"ServiceMetadataURL"`
+}
+
+type wmtsServiceIdentification struct {
+	Title              string `xml:"ows:Title"`
+	Abstract           string `xml:"ows:Abstract,omitempty"`
+	ServiceType        string `xml:"ows:ServiceType"`
+	ServiceTypeVersion string `xml:"ows:ServiceTypeVersion"`
+}
+
+type wmtsOperation struct {
+	Name string    `xml:"name,attr"`
+	Get  []wmtsGet `xml:"ows:DCP>ows:HTTP>ows:Get"`
+}
+
+type wmtsGet struct {
+	Href       string         `xml:"xlink:href,attr"`
+	Constraint wmtsConstraint `xml:"ows:Constraint"`
+}
+
+type wmtsConstraint struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"ows:AllowedValues>ows:Value"`
+}
+
+type wmtsLink struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+type wmtsLayer struct {
+	Title         string          `xml:"ows:Title"`
+	Abstract      string          `xml:"ows:Abstract,omitempty"`
+	BoundingBox   wmtsBoundingBox `xml:"ows:WGS84BoundingBox"`
+	Identifier    string          `xml:"ows:Identifier"`
+	Style         wmtsStyle       `xml:"Style"`
+	Format        string          `xml:"Format"`
+	TileMatrixSet string          `xml:"TileMatrixSetLink>TileMatrixSet"`
+	ResourceURL   wmtsResourceURL `xml:"ResourceURL"`
+}
+
+type wmtsBoundingBox struct {
+	LowerCorner string `xml:"ows:LowerCorner"`
+	UpperCorner string `xml:"ows:UpperCorner"`
+}
+
+type wmtsStyle struct {
+	IsDefault  bool   `xml:"isDefault,attr"`
+	Identifier string `xml:"ows:Identifier"`
+}
+
+type wmtsResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+type wmtsTileMatrixSetDef struct {
+	Identifier        string           `xml:"ows:Identifier"`
+	SupportedCRS      string           `xml:"ows:SupportedCRS"`
+	WellKnownScaleSet string           `xml:"WellKnownScaleSet"`
+	TileMatrix        []wmtsTileMatrix `xml:"TileMatrix"`
+}
+
+type wmtsTileMatrix struct {
+	Identifier       string  `xml:"ows:Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    string  `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      uint64  `xml:"MatrixWidth"`
+	MatrixHeight     uint64  `xml:"MatrixHeight"`
+}
+
+// wmtsCapabilitiesDoc returns the WMTS capabilities of the tileset db with
+// the given id, which is served at svcURL.
+func wmtsCapabilitiesDoc(id string, db Tileset, svcURL string) (*wmtsCapabilities, error) {
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata for tileset %v: %v", id, err)
+	}
+	name := toString(metadata["name"])
+	if name == "" {
+		name = id
+	}
+	description := toString(metadata["description"])
+	bounds, ok := metadata["bounds"].([]float64)
+	if !ok || len(bounds) != 4 {
+		bounds = []float64{-180, -85.05112878, 180, 85.05112878}
+	}
+	maxZoom, ok := metadata["maxzoom"].(int)
+	if !ok {
+		maxZoom = 22
+	}
+
+	wmtsURL := svcURL + "/wmts"
+	capsURL := wmtsURL + "/1.0.0/WMTSCapabilities.xml"
+	format := db.TileFormat().ContentType()
+	caps := &wmtsCapabilities{
+		Xmlns:      "http://www.opengis.net/wmts/1.0",
+		XmlnsOws:   "http://www.opengis.net/ows/1.1",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    "1.0.0",
+		ServiceIdentification: wmtsServiceIdentification{
+			Title:              name,
+			Abstract:           description,
+			ServiceType:        "OGC WMTS",
+			ServiceTypeVersion: "1.0.0",
+		},
+		OperationsMetadata: []wmtsOperation{
+			{Name: "GetCapabilities", Get: []wmtsGet{
+				{Href: capsURL, Constraint: wmtsConstraint{"GetEncoding", "RESTful"}},
+				{Href: wmtsURL + "?", Constraint: wmtsConstraint{"GetEncoding", "KVP"}},
+			}},
+			{Name: "GetTile", Get: []wmtsGet{
+				{Href: wmtsURL + "/tile/", Constraint: wmtsConstraint{"GetEncoding", "RESTful"}},
+				{Href: wmtsURL + "?", Constraint: wmtsConstraint{"GetEncoding", "KVP"}},
+			}},
+		},
+		Layer: wmtsLayer{
+			Title:    name,
+			Abstract: description,
+			BoundingBox: wmtsBoundingBox{
+				LowerCorner: fmt.Sprintf("%v %v", bounds[0], bounds[1]),
+				UpperCorner: fmt.Sprintf("%v %v", bounds[2], bounds[3]),
+			},
+			Identifier:    id,
+			Style:         wmtsStyle{IsDefault: true, Identifier: "default"},
+			Format:        format,
+			TileMatrixSet: wmtsTileMatrixSet,
+			ResourceURL: wmtsResourceURL{
+				Format:       format,
+				ResourceType: "tile",
+				Template:     fmt.Sprintf("%s/tile/1.0.0/%s/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.%s", wmtsURL, id, db.TileFormat().String()),
+			},
+		},
+		TileMatrixSet: wmtsTileMatrixSetDef{
+			Identifier:        wmtsTileMatrixSet,
+			SupportedCRS:      "urn:ogc:def:crs:EPSG::3857",
+			WellKnownScaleSet: "urn:ogc:def:wkss:OGC:1.0:" + wmtsTileMatrixSet,
+		},
+		ServiceMetadataURL: wmtsLink{Href: capsURL},
+	}
+	for z := 0; z <= maxZoom; z++ {
+		caps.TileMatrixSet.TileMatrix = append(caps.TileMatrixSet.TileMatrix, wmtsTileMatrix{
+			Identifier:       strconv.Itoa(z),
+			ScaleDenominator: wmtsScaleDenominator / math.Pow(2, float64(z)),
+			TopLeftCorner:    fmt.Sprintf("%v %v", -wmtsOrigin, wmtsOrigin),
+			TileWidth:        256,
+			TileHeight:       256,
+			MatrixWidth:      1 << uint(z),
+			MatrixHeight:     1 << uint(z),
+		})
+	}
+	return caps, nil
+}
+
+func (s *ServiceSet) wmtsCapabilities(id string, db Tileset, w http.ResponseWriter, r *http.Request) (int, error) {
+	svcURL := fmt.Sprintf("%s/services/%s", s.RootURL(r), id)
+	caps, err := wmtsCapabilitiesDoc(id, db, svcURL)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	bytes, err := xml.MarshalIndent(caps, "", "  ")
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot marshal WMTS capabilities XML: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	if _, err = w.Write([]byte(xml.Header)); err != nil {
+		return http.StatusOK, err
+	}
+	_, err = w.Write(bytes)
+	return http.StatusOK, err
+}
+
+// serveTileAt serves the tile at zoom level z, row y and column x with the
+// tiles handlerFunc of the tileset by rewriting the request path. The row is
+// counted from the north and the column may carry a filename extension.
+func (s *ServiceSet) serveTileAt(id string, tiles handlerFunc, z, y, x string, w http.ResponseWriter, r *http.Request) (int, error) {
+	r2 := new(http.Request)
+	*r2 = *r
+	var ext string
+	if i := strings.LastIndex(x, "."); i >= 0 {
+		x, ext = x[:i], x[i:]
+	}
+	if s.Scheme == mbtiles.TMS {
+		tc, _, err := tileCoordFromString(z, x, y)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		y = strconv.FormatUint((1<<uint64(tc.z))-1-tc.y, 10)
+	}
+	u := *r.URL
+	u.Path = fmt.Sprintf("/services/%s/tiles/%s/%s/%s%s", id, z, x, y, ext)
+	r2.URL = &u
+	return tiles(w, r2)
+}
+
+// wmtsKVP serves the WMTS operations in the key-value-pair encoding.
+func (s *ServiceSet) wmtsKVP(id string, db Tileset, tiles handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		// WMTS parameter names are case insensitive
+		params := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			if len(v) > 0 {
+				params[strings.ToUpper(k)] = v[0]
+			}
+		}
+		if params["SERVICE"] != "WMTS" {
+			return http.StatusBadRequest, fmt.Errorf("missing or invalid WMTS service parameter")
+		}
+		switch params["REQUEST"] {
+		case "GetCapabilities":
+			return s.wmtsCapabilities(id, db, w, r)
+		case "GetTile":
+			if params["LAYER"] != id {
+				return http.StatusBadRequest, fmt.Errorf("unknown WMTS layer %q", params["LAYER"])
+			}
+			if tms := params["TILEMATRIXSET"]; tms != "" && tms != wmtsTileMatrixSet {
+				return http.StatusBadRequest, fmt.Errorf("unknown WMTS tile matrix set %q", tms)
+			}
+			return s.serveTileAt(id, tiles, params["TILEMATRIX"], params["TILEROW"], params["TILECOL"], w, r)
+		}
+		return http.StatusBadRequest, fmt.Errorf("unsupported WMTS request %q", params["REQUEST"])
+	}
+}
+
+// wmtsREST serves the WMTS operations in the RESTful encoding.
+func (s *ServiceSet) wmtsREST(id string, db Tileset, tiles handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		p := strings.TrimPrefix(r.URL.Path, "/services/"+id+"/wmts/")
+		if p == "1.0.0/WMTSCapabilities.xml" {
+			return s.wmtsCapabilities(id, db, w, r)
+		}
+		// we are expecting "tile", "1.0.0", <layer>, <style>, <tile matrix set>, <z>, <y>, <x plus .ext>
+		pcs := strings.Split(p, "/")
+		if len(pcs) != 8 || pcs[0] != "tile" || pcs[1] != "1.0.0" {
+			return http.StatusNotFound, fmt.Errorf("unknown WMTS resource %q", r.URL.Path)
+		}
+		if pcs[2] != id || pcs[4] != wmtsTileMatrixSet {
+			return http.StatusNotFound, fmt.Errorf("unknown WMTS layer %q or tile matrix set %q", pcs[2], pcs[4])
+		}
+		return s.serveTileAt(id, tiles, pcs[5], pcs[6], pcs[7], w, r)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3AltSvc returns the Alt-Svc header value that advertises HTTP/3 on
+// the UDP port port for a day.
+func http3AltSvc(port int) string {
+	return fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+}
+
+// http3TLSConfig returns the TLS configuration of the HTTP/3 server, with the
+// certificate of the --cert and --key flags or the certificates of the
+// Let's Encrypt manager of e.
+func http3TLSConfig(e *echo.Echo) (*tls.Config, error) {
+	if len(certificate) > 0 {
+		cert, err := tls.LoadX509KeyPair(certificate, privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load certificate for HTTP/3: %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+	return &tls.Config{GetCertificate: e.AutoTLSManager.GetCertificate}, nil
+}
+
+// newHTTP3Server returns a server of h for HTTP/3 over QUIC at the UDP
+// address addr with the certificates of tlsConfig.
+func newHTTP3Server(addr string, h http.Handler, tlsConfig *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+}
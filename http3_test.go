@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key to dir and returns the certificate.
+func writeTestCertificate(t *testing.T, dir string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate = filepath.Join(dir, "cert.pem")
+	privateKey = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(privateKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestHTTP3(t *testing.T) {
+	_, e := newTestServer(t)
+	defer func(c, k string) { certificate, privateKey = c, k }(certificate, privateKey)
+	cert := writeTestCertificate(t, t.TempDir())
+
+	tlsConfig, err := http3TLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	srv := newHTTP3Server("", e, tlsConfig)
+	defer srv.Close()
+	go srv.Serve(conn)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	tr := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer tr.Close()
+	resp, err := (&http.Client{Transport: tr, Timeout: 10 * time.Second}).Get("https://" + conn.LocalAddr().String() + "/services")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Errorf("expected status %d over HTTP/3, got %d over %s", http.StatusOK, resp.StatusCode, resp.Proto)
+	}
+
+	if v := http3AltSvc(443); v != `h3=":443"; ma=86400` {
+		t.Errorf("unexpected Alt-Svc header %s", v)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <dir> <file.mbtiles>",
+	Short: "Import a z/x/y directory of tiles into an mbtiles file",
+	Long: `Import writes the tiles of a z/x/y file tree like <dir>/3/4/2.png to a new
+mbtiles file, storing identical tiles only once. The format of the tiles is
+taken from their file extension, the metadata from <dir>/metadata.json if it
+exists.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalln("A directory and an mbtiles file are required")
+		}
+		if !mbtiles.IsTileDir(args[0]) {
+			log.Fatalf("%s is not a directory of tiles", args[0])
+		}
+		if err := mbtiles.Convert(context.Background(), args[0], args[1], mbtiles.Deduplicate()); err != nil {
+			log.Fatalf("Could not import %s: %v", args[0], err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,185 @@
+// Package pbf reads and writes the protocol buffer wire format. Only the
+// subset that is used by vector tiles and glyph PBFs is supported.
+package pbf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// protobuf wire types
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+// Reader decodes the fields of a protobuf message.
+type Reader struct {
+	buf []byte
+	pos int
+}
+
+// NewReader returns a Reader for the message data.
+func NewReader(data []byte) *Reader {
+	return &Reader{buf: data}
+}
+
+// Done returns whether all fields of the message have been read.
+func (r *Reader) Done() bool {
+	return r.pos >= len(r.buf)
+}
+
+// Varint reads a varint value.
+func (r *Reader) Varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.pos += n
+	return v, nil
+}
+
+// Key reads the key of the next field, which is followed by its value.
+func (r *Reader) Key() (field int, wire int, err error) {
+	v, err := r.Varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 0x7), nil
+}
+
+// Bytes reads a length-delimited value, which shares the memory of the
+// message.
+func (r *Reader) Bytes() ([]byte, error) {
+	n, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// Fixed32 reads a 32 bit value.
+func (r *Reader) Fixed32() (uint32, error) {
+	if len(r.buf)-r.pos < 4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := binary.LittleEndian.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+// Fixed64 reads a 64 bit value.
+func (r *Reader) Fixed64() (uint64, error) {
+	if len(r.buf)-r.pos < 8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+// Packed reads a packed repeated field of uint32 values.
+func (r *Reader) Packed() ([]uint32, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var vs []uint32
+	pr := NewReader(b)
+	for !pr.Done() {
+		v, err := pr.Varint()
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, uint32(v))
+	}
+	return vs, nil
+}
+
+// Skip skips over the value of an unknown field of the given wire type.
+func (r *Reader) Skip(wire int) error {
+	var err error
+	switch wire {
+	case WireVarint:
+		_, err = r.Varint()
+	case WireFixed64:
+		_, err = r.Fixed64()
+	case WireBytes:
+		_, err = r.Bytes()
+	case WireFixed32:
+		_, err = r.Fixed32()
+	default:
+		err = fmt.Errorf("unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// Writer encodes the fields of a protobuf message.
+type Writer struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+// Data returns the encoded message.
+func (w *Writer) Data() []byte {
+	return w.buf
+}
+
+func (w *Writer) uvarint(v uint64) {
+	n := binary.PutUvarint(w.tmp[:], v)
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+func (w *Writer) key(field int, wire int) {
+	w.uvarint(uint64(field)<<3 | uint64(wire))
+}
+
+// Varint writes the varint field with the value v.
+func (w *Writer) Varint(field int, v uint64) {
+	w.key(field, WireVarint)
+	w.uvarint(v)
+}
+
+// Bytes writes the length-delimited field with the value b.
+func (w *Writer) Bytes(field int, b []byte) {
+	w.key(field, WireBytes)
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// Fixed32 writes the 32 bit field with the value v.
+func (w *Writer) Fixed32(field int, v uint32) {
+	w.key(field, WireFixed32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// Fixed64 writes the 64 bit field with the value v.
+func (w *Writer) Fixed64(field int, v uint64) {
+	w.key(field, WireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// Packed writes a packed repeated field of uint32 values. Nothing is written
+// for an empty slice.
+func (w *Writer) Packed(field int, vs []uint32) {
+	if len(vs) == 0 {
+		return
+	}
+	pw := &Writer{}
+	for _, v := range vs {
+		pw.uvarint(uint64(v))
+	}
+	w.Bytes(field, pw.buf)
+}
@@ -0,0 +1,288 @@
+// Package lambda serves a http.Handler in an AWS Lambda function with a custom
+// runtime, like provided.al2, whose invocations are requests of Amazon API
+// Gateway or of a function URL. It talks to the Lambda runtime API directly,
+// so it does not depend on the AWS SDK.
+//
+// mbtileserver serves the invocations instead of listening on a port when the
+// runtime API is set in the environment. The function is deployed with the
+// binary as its bootstrap, e.g. by this AWS SAM template, which reads the
+// tilesets from an EFS access point:
+//
+//	Resources:
+//	  Tiles:
+//	    Type: AWS::Serverless::Function
+//	    Properties:
+//	      Runtime: provided.al2
+//	      Handler: bootstrap
+//	      CodeUri: dist/    # mbtileserver, built for linux and renamed to bootstrap
+//	      MemorySize: 512
+//	      Timeout: 30
+//	      Environment:
+//	        Variables:
+//	          MBTILESERVER_ARGS: --dir /mnt/tiles
+//	      FileSystemConfigs:
+//	        - Arn: !GetAtt TilesAccessPoint.Arn
+//	          LocalMountPath: /mnt/tiles
+//	      VpcConfig: { ... }
+//	      Events:
+//	        Api:
+//	          Type: HttpApi
+//
+// with a bootstrap shell script in place of the binary to pass the arguments:
+//
+//	#!/bin/sh
+//	exec ./mbtileserver $MBTILESERVER_ARGS
+//
+// Without EFS, the tilesets are synchronized from object storage into /tmp,
+// which is the only writable directory of the function, on a cold start:
+//
+//	exec ./mbtileserver --dir s3://bucket/tiles --remotecache /tmp/tiles
+//
+// Binary responses like tiles are base64 encoded, which requires a REST API
+// to list their media types, or "*/*", as binaryMediaTypes. The size of the
+// responses is limited to 6 MB by Lambda.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtimeAPIEnv is the environment variable with the host and port of the
+// Lambda runtime API.
+const runtimeAPIEnv = "AWS_LAMBDA_RUNTIME_API"
+
+// runtimeClient requests the invocations from the runtime API, which holds the
+// requests for the next invocation until there is one.
+var runtimeClient = &http.Client{}
+
+// IsLambda reports whether the program runs in a Lambda function with a custom
+// runtime.
+func IsLambda() bool {
+	return os.Getenv(runtimeAPIEnv) != ""
+}
+
+// Serve answers the invocations of the Lambda function with h. The events of
+// the invocations are requests of API Gateway REST APIs (payload format 1.0),
+// of HTTP APIs (payload format 2.0) or of function URLs. Serve only returns
+// if the runtime API cannot be reached.
+func Serve(h http.Handler) error {
+	return serve(os.Getenv(runtimeAPIEnv), h)
+}
+
+// serve answers the invocations of the runtime API at the address api with h.
+func serve(api string, h http.Handler) error {
+	base := "http://" + api + "/2018-06-01/runtime/invocation/"
+	for {
+		resp, err := runtimeClient.Get(base + "next")
+		if err != nil {
+			return fmt.Errorf("cannot get next invocation: %v", err)
+		}
+		payload, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read next invocation: %v", err)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		ctx, cancel := context.Background(), func() {}
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			ctx, cancel = context.WithDeadline(ctx, time.Unix(0, ms*int64(time.Millisecond)))
+		}
+		out, err := invoke(ctx, h, payload)
+		cancel()
+		path := base + id + "/response"
+		if err != nil {
+			path = base + id + "/error"
+			out, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"})
+		}
+		resp, err = runtimeClient.Post(path, "application/json", bytes.NewReader(out))
+		if err != nil {
+			return fmt.Errorf("cannot post response of invocation %s: %v", id, err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// event is a request of API Gateway or of a function URL, in the payload
+// format version 1.0 or 2.0.
+type event struct {
+	Version string `json:"version"`
+	// HTTPMethod, Path and the query parameters are those of version 1.0.
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	// RawPath, RawQueryString and Cookies are those of version 2.0.
+	RawPath        string            `json:"rawPath"`
+	RawQueryString string            `json:"rawQueryString"`
+	Cookies        []string          `json:"cookies"`
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+	} `json:"requestContext"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// response is the response to an event. MultiValueHeaders are used for version
+// 1.0, Headers and Cookies for version 2.0.
+type response struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// invoke answers the event in payload with h and returns the response.
+func invoke(ctx context.Context, h http.Handler, payload []byte) ([]byte, error) {
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+	r, err := e.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{header: make(http.Header)}
+	h.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return json.Marshal(e.response(rec))
+}
+
+// request returns the HTTP request of the event.
+func (e *event) request(ctx context.Context) (*http.Request, error) {
+	v2 := e.Version == "2.0"
+	method, path, query, ip := e.HTTPMethod, e.Path, e.RawQueryString, e.RequestContext.Identity.SourceIP
+	if v2 {
+		method, path, ip = e.RequestContext.HTTP.Method, e.RawPath, e.RequestContext.HTTP.SourceIP
+	} else {
+		q := make(url.Values)
+		for k, v := range e.QueryStringParameters {
+			q.Set(k, v)
+		}
+		for k, vs := range e.MultiValueQueryStringParameters {
+			q[k] = vs
+		}
+		query = q.Encode()
+	}
+	if method == "" || path == "" {
+		return nil, fmt.Errorf("event is not an HTTP request")
+	}
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, fmt.Errorf("invalid body: %v", err)
+		}
+	}
+	u := &url.URL{Path: path, RawQuery: query}
+	r, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if v2 {
+		for k, v := range e.Headers {
+			r.Header.Set(k, v)
+		}
+		if len(e.Cookies) > 0 {
+			r.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+		}
+	} else {
+		for k, v := range e.Headers {
+			r.Header.Set(k, v)
+		}
+		for k, vs := range e.MultiValueHeaders {
+			r.Header.Del(k)
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+	}
+	r.Host = r.Header.Get("Host")
+	if ip != "" {
+		r.RemoteAddr = net.JoinHostPort(ip, "0")
+	}
+	return r.WithContext(ctx), nil
+}
+
+// response returns the response to the event that has been recorded by rec.
+// Bodies other than uncompressed text are base64 encoded.
+func (e *event) response(rec *recorder) *response {
+	out := &response{StatusCode: rec.status, Body: rec.body.String()}
+	if !isText(rec.header) {
+		out.Body = base64.StdEncoding.EncodeToString(rec.body.Bytes())
+		out.IsBase64Encoded = true
+	}
+	if e.Version != "2.0" {
+		out.MultiValueHeaders = rec.header
+		return out
+	}
+	out.Headers = make(map[string]string, len(rec.header))
+	for k, vs := range rec.header {
+		if k == "Set-Cookie" {
+			out.Cookies = vs
+			continue
+		}
+		out.Headers[k] = strings.Join(vs, ", ")
+	}
+	return out
+}
+
+// isText reports whether the body of a response with the header h is
+// uncompressed text.
+func isText(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	return strings.HasPrefix(ct, "text/") || strings.Contains(ct, "json") ||
+		strings.Contains(ct, "xml") || strings.Contains(ct, "javascript")
+}
+
+// recorder records the response of a http.Handler.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
@@ -0,0 +1,122 @@
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoHandler answers with the method, path, query, header X-Test and body of
+// the request, as PNG if the query has png=1.
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Query().Get("png") == "1" {
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Add("Set-Cookie", "b=2")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + " " + r.Header.Get("X-Test") + " " + string(body)))
+})
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		body    string
+		binary  bool
+		cookies bool
+	}{
+		{
+			name:  "REST API",
+			event: `{"httpMethod": "POST", "path": "/services/a b", "multiValueHeaders": {"X-Test": ["x"]}, "multiValueQueryStringParameters": {"f": ["json"]}, "body": "eyJ9", "isBase64Encoded": true}`,
+			body:  "POST /services/a b?f=json x {\"}",
+		},
+		{
+			name:  "REST API with single values",
+			event: `{"httpMethod": "GET", "path": "/services", "headers": {"X-Test": "y"}, "queryStringParameters": {"f": "json"}}`,
+			body:  "GET /services?f=json y ",
+		},
+		{
+			name:    "HTTP API",
+			event:   `{"version": "2.0", "rawPath": "/services/a/tiles/0/0/0.png", "rawQueryString": "png=1", "headers": {"x-test": "z"}, "requestContext": {"http": {"method": "GET", "sourceIp": "10.0.0.1"}}}`,
+			body:    "GET /services/a/tiles/0/0/0.png?png=1 z ",
+			binary:  true,
+			cookies: true,
+		},
+	}
+	for _, tc := range tests {
+		out, err := invoke(context.Background(), echoHandler, []byte(tc.event))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var resp response
+		if err := json.Unmarshal(out, &resp); err != nil {
+			t.Fatal(err)
+		}
+		body := resp.Body
+		if tc.binary {
+			b, _ := base64.StdEncoding.DecodeString(resp.Body)
+			body = string(b)
+		}
+		if resp.StatusCode != http.StatusCreated || body != tc.body || resp.IsBase64Encoded != tc.binary {
+			t.Errorf("%s: expected %q, got %d %q (base64: %v)", tc.name, tc.body, resp.StatusCode, body, resp.IsBase64Encoded)
+		}
+		if tc.cookies && (len(resp.Cookies) != 2 || resp.Headers["Content-Type"] != "image/png") {
+			t.Errorf("%s: expected cookies and headers of version 2.0, got %v %v", tc.name, resp.Cookies, resp.Headers)
+		}
+		if !tc.cookies && len(resp.MultiValueHeaders["Set-Cookie"]) != 2 {
+			t.Errorf("%s: expected multi-value headers of version 1.0, got %v", tc.name, resp.MultiValueHeaders)
+		}
+	}
+
+	if _, err := invoke(context.Background(), echoHandler, []byte(`{"Records": []}`)); err == nil {
+		t.Error("expected error for event that is no HTTP request")
+	}
+}
+
+func TestServe(t *testing.T) {
+	var next int
+	responses := make(map[string]string)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			next++
+			switch next {
+			case 1:
+				w.Header().Set("Lambda-Runtime-Aws-Request-Id", "1")
+				w.Write([]byte(`{"httpMethod": "GET", "path": "/health"}`))
+			case 2:
+				w.Header().Set("Lambda-Runtime-Aws-Request-Id", "2")
+				w.Write([]byte(`{}`))
+			default:
+				// make serve return by closing the connection
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			}
+		default:
+			b, _ := ioutil.ReadAll(r.Body)
+			responses[strings.TrimPrefix(r.URL.Path, "/2018-06-01/runtime/invocation/")] = string(b)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer api.Close()
+
+	if err := serve(strings.TrimPrefix(api.URL, "http://"), echoHandler); err == nil {
+		t.Error("expected error after runtime API closed the connection")
+	}
+	if !strings.Contains(responses["1/response"], `"statusCode":201`) {
+		t.Errorf("expected response to invocation 1, got %v", responses)
+	}
+	if !strings.Contains(responses["2/error"], "errorMessage") {
+		t.Errorf("expected error of invocation 2, got %v", responses)
+	}
+}
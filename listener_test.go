@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbtileserver.sock")
+	l, err := unixListener(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stale socket, which is not removed on close, is replaced
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if l, err = unixListener(path); err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	l.Close()
+
+	// other files are not removed
+	filename := filepath.Join(t.TempDir(), "tiles.mbtiles")
+	if err := ioutil.WriteFile(filename, []byte("tiles"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unixListener(filename); err == nil {
+		t.Error("expected error for a file that is not a socket")
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Errorf("expected file to be kept, got %v", err)
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		listenPID, listenFDs string
+		err                  bool
+	}{
+		{"", "", false},
+		{strconv.Itoa(os.Getpid() + 1), "1", false}, // for another process
+		{pid, "0", false},
+		{pid, "2", true},
+	}
+	for _, tc := range tests {
+		t.Setenv("LISTEN_PID", tc.listenPID)
+		t.Setenv("LISTEN_FDS", tc.listenFDs)
+		l, err := systemdListener()
+		if l != nil || (err != nil) != tc.err {
+			t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s: expected no listener and error %v, got %v, %v", tc.listenPID, tc.listenFDs, tc.err, l, err)
+		}
+	}
+
+	// pass the socket of a listener like systemd, at another descriptor
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// systemdListener closes the descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(fd uintptr) { listenFDsStart = fd }(listenFDsStart)
+	listenFDsStart = uintptr(fd)
+	t.Setenv("LISTEN_PID", pid)
+	t.Setenv("LISTEN_FDS", "1")
+	l, err := systemdListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != tcp.Addr().String() {
+		t.Errorf("expected listener at %s, got %s", tcp.Addr(), l.Addr())
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("expected LISTEN_FDS to be removed from the environment")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// logFile is the file of the log messages, which is reopened after it has
+// been moved away by log rotation.
+type logFile struct {
+	name string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// openLogFile opens the log file name for appending.
+func openLogFile(name string) (*logFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &logFile{name: name, f: f}, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// reopen closes the log file and opens the file of its name again, which is
+// created if it has been moved away.
+func (l *logFile) reopen() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	return old.Close()
+}
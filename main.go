@@ -2,68 +2,58 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"path"
-
-	"golang.org/x/crypto/acme/autocert"
-
-	"html/template"
 	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/golang/groupcache"
+	"golang.org/x/crypto/acme/autocert"
+
 	"github.com/labstack/echo"
 
 	"github.com/evalphobia/logrus_sentry"
+	"github.com/fsnotify/fsnotify"
 	"github.com/labstack/echo/middleware"
+	"github.com/quic-go/quic-go/http3"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/consbio/mbtileserver/config"
 	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/lambda"
 	"github.com/consbio/mbtileserver/mbtiles"
-)
-
-type ServiceInfo struct {
-	ImageType string `json:"imageType"`
-	URL       string `json:"url"`
-}
-
-type Template struct {
-	templates *template.Template
-}
-
-func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, data)
-}
-
-type TemplateParams struct {
-	URL string
-	ID  string
-}
-
-var (
-	cache       *groupcache.Group
-	tilesets    map[string]mbtiles.DB
-	startuptime = time.Now()
+	"github.com/consbio/mbtileserver/otlp"
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "mbtileserver",
 	Short: "Serve tiles from mbtiles files",
+	Long: `Serve tiles from mbtiles files.
+
+Every flag can also be set with an environment variable named like the flag
+with the prefix MBTS_, in upper case and with underscores instead of dashes,
+e.g. MBTS_PORT for --port or MBTS_FONTS_DIR for --fonts-dir. Flags on the
+command line take precedence over environment variables, which take
+precedence over the options of the --config file.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return applyEnv(cmd.Flags())
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		serve()
+		serve(cmd)
 	},
 }
 
 var (
 	port        int
-	tilePath    string
-	cacheSize   int64
+	tilePaths   []string
 	certificate string
 	privateKey  string
 	pathPrefix  string
@@ -72,21 +62,184 @@ var (
 	verbose     bool
 	autotls     bool
 	redirect    bool
+	readOnly    bool
+	cacheSize   int64
+	overzoom    int
+	tileSize    int
+	quality     int
+	scheme      string
+	missingTile string
+	missingFor  []string
+	plainGrids  bool
+	quickCheck  bool
+	logFormat   string
+	keysFile    string
+	jwtSecret   string
+	jwtKey      string
+	jwtClaim    string
+	signingKey  string
+	signExpires time.Duration
+	aclFile     string
+	trustProxy  bool
+	rateLimit   float64
+	burst       int
+	tlsHostname string
+	certCache   string
+	altSvc      string
+	serveHTTP3  bool
+	socket      string
+	shutdown    time.Duration
+	watch       time.Duration
+	noWatch     bool
+	otlpURL     string
+	maxDepth    int
+	collisions  string
+	idStrategy  string
+	adminKey    string
+	uploadDir   string
+	maxUpload   int64
+	fastlySvc   string
+	fastlyToken string
+	cfZone      string
+	cfToken     string
+	remoteCache string
+	tileURLs    []string
+	pageCache   int64
+	stylesDir   string
+	fontsDir    string
+	spritesDir  string
+	respCache   int64
+	redisURL    string
+	redisTTL    time.Duration
+	peers       []string
+	peerSelf    string
+	peerSecret  string
+	peerCache   int64
+	peerRefresh time.Duration
+
+	sqliteCache  int64
+	mmapSize     int64
+	busyTimeout  time.Duration
+	tempStore    string
+	maxConns     int
+	maxIdleConns int
+	connLifetime time.Duration
+	queryTimeout time.Duration
+	slowQuery    time.Duration
+
+	configFile  string
+	logFilename string
+	urlPrefix   string
+	rootURL     string
+	proxies     []string
 )
 
 func init() {
 	flags := RootCmd.Flags()
+	flags.StringVar(&configFile, "config", "", "TOML file with options, named like the flags, and policies of individual tilesets, which are reloaded on SIGHUP. Flags take precedence over the options in the file.")
 	flags.IntVarP(&port, "port", "p", 8000, "Server port.")
-	flags.StringVarP(&tilePath, "dir", "d", "./tilesets", "Directory containing mbtiles files.")
+	flags.StringSliceVarP(&tilePaths, "dir", "d", []string{"./tilesets"}, "Directories containing mbtiles files, each optionally followed by =<namespace> to serve its tilesets below /services/<namespace>.")
 	flags.StringVarP(&certificate, "cert", "c", "", "X.509 TLS certificate filename.  If present, will be used to enable SSL on the server.")
 	flags.StringVarP(&privateKey, "key", "k", "", "TLS private key")
-	flags.Int64Var(&cacheSize, "cachesize", 250, "Size of cache in MB.")
 	flags.StringVar(&pathPrefix, "path", "", "URL root path of this server (if behind a proxy)")
+	flags.MarkDeprecated("path", "use --url-prefix instead")
+	flags.StringVar(&urlPrefix, "url-prefix", "", "Path prefix below which a reverse proxy forwards requests to this server, e.g. /tiles, which is used for the URLs in responses. Requests that still carry the prefix are served as well.")
+	flags.StringVar(&rootURL, "root-url", "", "Absolute URL of the root of this server as seen by clients behind a reverse proxy, e.g. https://example.com/tiles, which is used for all URLs in responses instead of the one determined from the requests.")
+	flags.StringSliceVar(&proxies, "trusted-proxies", nil, "IP addresses or CIDR networks of reverse proxies whose Forwarded and X-Forwarded-Proto, -Host, -Prefix and -For headers are used for the URLs in responses and the client IP address, while they are ignored for other requests. * trusts all requests, whose client IP address is then the last one in X-Forwarded-For.")
 	flags.StringVar(&domain, "domain", "", "Domain name of this server")
 	flags.StringVar(&sentry_DSN, "dsn", "", "Sentry DSN")
-	flags.BoolVarP(&verbose, "verbose", "v", false, "Verbose logging")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "Verbose logging, including access logs of all requests")
+	flags.StringVar(&logFormat, "logformat", "text", "Format of log messages: text or json.")
+	flags.StringVar(&logFilename, "logfile", "", "File to which log messages are appended instead of stderr, which is reopened on SIGHUP after log rotation.")
 	flags.BoolVarP(&autotls, "tls", "t", false, "Auto TLS via Let's Encrypt")
 	flags.BoolVarP(&redirect, "redirect", "r", false, "Redirect HTTP to HTTPS")
+	flags.StringVar(&tlsHostname, "tls-hostname", "", "Host name for which a certificate is provisioned and renewed via Let's Encrypt. Implies --tls, --redirect and --domain, and port 443 unless --port is set.")
+	flags.DurationVar(&shutdown, "shutdowntimeout", 30*time.Second, "Time to finish in-flight requests on SIGINT or SIGTERM before the server exits.")
+	flags.IntVar(&maxDepth, "maxdepth", -1, "Number of levels of subdirectories of the tileset directory that are scanned for mbtiles files (-1 for all).")
+	flags.StringSliceVar(&tileURLs, "url", nil, "URLs of mbtiles files on HTTP servers or in object storage, which are read with range requests instead of being downloaded, each optionally preceded by <id>=.")
+	flags.Int64Var(&pageCache, "pagecache", mbtiles.DefaultPageCacheSize, "Size of the page cache per mbtiles file from --url in MB.")
+	flags.StringVar(&remoteCache, "remotecache", ".remote", "Directory in which mbtiles files from object storage (s3://, gs:// and az:// tileset directories) are cached.")
+	flags.StringVar(&idStrategy, "ids", "path", "Strategy for the IDs of tilesets: path (relative path without extension), basename (file name without extension), hash (of the relative path) or name (from the metadata).")
+	flags.StringVar(&collisions, "collisions", collisionSkip, "Handling of mbtiles files whose IDs are already used: skip, error or suffix (append -2, -3, ...).")
+	flags.BoolVar(&noWatch, "nowatch", false, "Do not watch the tileset directory for added, removed and modified mbtiles files.")
+	flags.DurationVar(&watch, "watch", 0, "Interval in which the tileset directory is polled for changes and object storage is synced, for file systems without change notifications (0 disables polling).")
+	flags.StringVar(&socket, "socket", "", "Path of a unix domain socket to listen on instead of the port.")
+	flags.StringVar(&altSvc, "altsvc", "", "Alt-Svc header of all responses, e.g. 'h3=\":443\"; ma=86400' to advertise HTTP/3 of a proxy in front of the server.")
+	flags.BoolVar(&serveHTTP3, "http3", false, "Serve HTTP/3 over QUIC on the UDP port of the HTTPS server in addition, which is advertised with an Alt-Svc header unless --altsvc is set. Requires --cert or --tls.")
+	flags.StringVar(&certCache, "certcache", ".certs", "Directory in which the certificates from Let's Encrypt are cached.")
+	flags.BoolVar(&readOnly, "readonly", false, "Open mbtiles files in read-only, immutable mode")
+	flags.IntVar(&overzoom, "overzoom", 0, "Number of zoom levels beyond the maximum zoom level of PNG, JPG and PBF tilesets that are created from ancestor tiles.")
+	flags.IntVar(&tileSize, "tilesize", 0, "Size in pixels of the served raster tiles, 256 or 512, which are created from the stored tiles if their size differs (0 serves them as stored).")
+	flags.IntVar(&quality, "quality", handlers.DefaultImageQuality, "Quality (1-100) of lossy raster tiles that are converted or created by the server.")
+	flags.StringVar(&scheme, "scheme", "xyz", "Tile row scheme of the tile URLs: xyz or tms.")
+	flags.StringVar(&missingTile, "missingtile", "default", "Response to requests for missing tiles: default (blank PNG for raster, 204 for vector tilesets), 404, 204, blank (blank PNG or empty gzipped vector tile) or the filename of an image.")
+	flags.StringSliceVar(&missingFor, "missingtilefor", nil, "Response to requests for missing tiles of a tileset as <id>=<response>, with the responses of --missingtile.")
+	flags.BoolVar(&quickCheck, "quickcheck", false, "Check the integrity of mbtiles files on startup and skip those that fail.")
+	flags.BoolVar(&plainGrids, "plaingrids", false, "Serve UTF grids as plain JSON instead of in their stored compression.")
+	flags.StringVar(&keysFile, "keys", "", "JSON file with API keys that are required to access the tilesets.")
+	flags.StringVar(&jwtSecret, "jwtsecret", "", "File with the secret of HMAC-signed JSON Web Tokens that grant access to the tilesets.")
+	flags.StringVar(&jwtKey, "jwtkey", "", "PEM file with the RSA or ECDSA public key of signed JSON Web Tokens that grant access to the tilesets.")
+	flags.StringVar(&jwtClaim, "jwtclaim", handlers.DefaultJWTClaim, "Claim of JSON Web Tokens with the patterns of the tilesets they grant access to.")
+	flags.StringVar(&signingKey, "signingkey", "", "File with the secret key of signed tileset URLs.")
+	flags.StringVar(&adminKey, "adminkey", "", "File with the secret key of the admin endpoints, which are only served if it is set.")
+	flags.StringVar(&uploadDir, "uploaddir", "", "Directory in which uploaded mbtiles files are stored (default: the first local tileset directory).")
+	flags.Int64Var(&maxUpload, "maxupload", 1024, "Maximum size of uploaded mbtiles files in MB (0 for no limit).")
+	flags.StringVar(&fastlySvc, "fastlyservice", "", "ID of the Fastly service from which replaced and purged tilesets are purged.")
+	flags.StringVar(&fastlyToken, "fastlytoken", "", "File with the Fastly API token for --fastlyservice.")
+	flags.StringVar(&cfZone, "cloudflarezone", "", "ID of the Cloudflare zone from which replaced and purged tilesets are purged.")
+	flags.StringVar(&cfToken, "cloudflaretoken", "", "File with the Cloudflare API token for --cloudflarezone.")
+	flags.StringVar(&stylesDir, "styles", "", "Directory with Mapbox GL styles that are served below /styles, either as <id>.json or as <id>/style.json with their local files.")
+	flags.StringVar(&fontsDir, "fonts-dir", "", "Directory with a subdirectory of SDF glyph PBFs per font that are served below /fonts.")
+	flags.StringVar(&spritesDir, "sprites-dir", "", "Directory with sprites (<id>.json and <id>.png, optionally with @2x variants) that are served below /sprites.")
+	flags.StringVar(&otlpURL, "otlp", "", "Base URL of an OpenTelemetry collector, e.g. http://localhost:4318, to which the traces of the requests are exported with OTLP over HTTP.")
+	flags.StringVar(&aclFile, "acl", "", "JSON file with access control lists of tilesets.")
+	flags.BoolVar(&trustProxy, "trustproxy", false, "Trust the forwarded headers of all requests, like --trusted-proxies '*'.")
+	flags.MarkDeprecated("trustproxy", "use --trusted-proxies '*' instead")
+	flags.Float64Var(&rateLimit, "ratelimit", 0, "Requests per second to the tilesets that are allowed per API key or client IP address (0 disables rate limiting).")
+	flags.IntVar(&burst, "burst", 10, "Number of requests per API key or client IP address that are allowed in a burst beyond the rate limit.")
+	flags.Int64Var(&cacheSize, "cachesize", 250, "Size of the tile cache shared by all tilesets in MB (0 disables the cache).")
+	flags.StringVar(&redisURL, "redis", "", "URL of a Redis server (redis://[[user]:password@]host[:port][/db] or rediss:// for TLS) in which tile, grid and TileJSON responses are cached and shared with other servers.")
+	flags.DurationVar(&redisTTL, "redisttl", time.Hour, "Time after which responses cached in Redis expire (0 to keep them until Redis evicts them).")
+	flags.StringSliceVar(&peers, "peers", nil, "Base URLs of the servers (including this one) across which tile, grid and TileJSON responses are cached, e.g. http://10.0.0.1:8000. The hosts of dns+http:// and dns+https:// URLs are resolved to all their addresses.")
+	flags.StringVar(&peerSelf, "peerself", "", "Base URL of this server in --peers.")
+	flags.StringVar(&peerSecret, "peersecret", "", "File with a secret shared by the --peers, which must be sent with their requests. Otherwise only requests from the addresses of the peers are answered.")
+	flags.Int64Var(&peerCache, "peercache", 64, "Size of the cache of the responses that this server owns among --peers in MB.")
+	flags.DurationVar(&peerRefresh, "peerrefresh", 30*time.Second, "Interval in which dns+http:// and dns+https:// peers are resolved again.")
+	flags.Int64Var(&respCache, "responsecache", 0, "Size of the cache of tile, grid and TileJSON responses of all tilesets in MB, which also coalesces concurrent requests for the same response (0 disables the cache).")
+	flags.Int64Var(&sqliteCache, "sqlitecache", 0, "Size of the SQLite page cache per connection to an mbtiles file in MB (0 for the SQLite default of 2 MB).")
+	flags.Int64Var(&mmapSize, "mmap", 0, "Number of MB of each mbtiles file that are read through memory-mapped I/O (0 disables memory mapping).")
+	flags.DurationVar(&busyTimeout, "busytimeout", 0, "Time that SQLite waits for locks held by other processes before a query fails.")
+	flags.StringVar(&tempStore, "tempstore", "default", "Storage of temporary SQLite tables and indices: default, file or memory.")
+	flags.IntVar(&maxConns, "maxconns", 0, "Maximum number of open connections per mbtiles file (0 for no limit).")
+	flags.IntVar(&maxIdleConns, "maxidleconns", 0, "Number of idle connections per mbtiles file that are kept open (0 for the default of 2).")
+	flags.DurationVar(&connLifetime, "connlifetime", 0, "Time after which connections to mbtiles files are reopened (0 to reuse them forever).")
+	flags.DurationVar(&queryTimeout, "querytimeout", 0, "Time after which reads of tiles and metadata are cancelled (0 for no limit).")
+	flags.DurationVar(&slowQuery, "slowquery", 0, "Log reads of tiles and metadata that take at least this long (0 to disable).")
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign URL...",
+	Short: "Sign URLs for time-limited access to tilesets",
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := loadSigningKey()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, arg := range args {
+			u, err := handlers.SignURL(key, arg, time.Now().Add(signExpires))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fmt.Println(u)
+		}
+	},
+}
+
+func init() {
+	flags := signCmd.Flags()
+	flags.StringVar(&signingKey, "signingkey", "", "File with the secret key of signed tileset URLs.")
+	flags.DurationVar(&signExpires, "expires", 24*time.Hour, "Duration for which the signed URLs are valid.")
+	RootCmd.AddCommand(signCmd)
 }
 
 func main() {
@@ -95,11 +248,39 @@ func main() {
 	}
 }
 
-func serve() {
+func serve(cmd *cobra.Command) {
+	var cfg *config.Config
+	if len(configFile) > 0 {
+		var err error
+		if cfg, err = config.Load(configFile); err != nil {
+			log.Fatalln(err)
+		}
+		if err := applyConfig(cmd.Flags(), cfg); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("Unknown log format: %s", logFormat)
+	}
+
 	if verbose {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	var logOutput *logFile
+	if len(logFilename) > 0 {
+		var err error
+		if logOutput, err = openLogFile(logFilename); err != nil {
+			log.Fatalln(err)
+		}
+		log.SetOutput(logOutput)
+	}
+
 	if len(sentry_DSN) > 0 {
 		hook, err := logrus_sentry.NewSentryHook(sentry_DSN, []log.Level{
 			log.PanicLevel,
@@ -115,6 +296,10 @@ func serve() {
 		log.Debugln("Added logging hook for Sentry")
 	}
 
+	if err := useTLSHostname(cmd.Flags()); err != nil {
+		log.Fatalln(err)
+	}
+
 	certExists := len(certificate) > 0
 	keyExists := len(privateKey) > 0
 	domainExists := len(domain) > 0
@@ -126,6 +311,14 @@ func serve() {
 	if len(pathPrefix) > 0 && !domainExists {
 		log.Fatalln("Domain is required if path is provided")
 	}
+	if len(pathPrefix) > 0 && len(urlPrefix) > 0 {
+		log.Fatalln("Only one of path and URL prefix can be used")
+	}
+	prefix, err := parseURLPrefix(pathPrefix+urlPrefix, rootURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pathPrefix = prefix
 
 	if autotls && !domainExists {
 		log.Fatalln("Domain is required to use auto TLS")
@@ -139,108 +332,355 @@ func serve() {
 		log.Fatalln("Certificate or tls options are required to use redirect")
 	}
 
-	var filenames []string
-	err := filepath.Walk(tilePath, func(path string, info os.FileInfo, err error) error {
+	if serveHTTP3 && !(certExists || autotls) {
+		log.Fatalln("Certificate or tls options are required to serve HTTP/3")
+	}
+	if serveHTTP3 && len(altSvc) == 0 {
+		altSvc = http3AltSvc(port)
+	}
+
+	listener, err := systemdListener()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if listener == nil && len(socket) > 0 {
+		listener, err = unixListener(socket)
 		if err != nil {
-			return err
-		}
-		if strings.HasSuffix(strings.ToLower(path), ".mbtiles") {
-			filenames = append(filenames, path)
+			log.Fatalln(err)
 		}
-		return nil
-	})
-	if err != nil {
-		log.Fatalf("Unable to scan tileset directory for mbtiles files\n%v", err)
+	}
+	if listener != nil && (certExists || autotls) {
+		log.Fatalln("TLS cannot be used with a unix domain socket or a socket from systemd")
 	}
 
-	if len(filenames) == 0 {
-		log.Fatal("No tilesets found in tileset directory")
+	if len(jwtSecret) > 0 && len(jwtKey) > 0 {
+		log.Fatalln("Only one of JWT secret and JWT key can be used")
 	}
 
-	log.Infof("Found %v mbtiles files in %s", len(filenames), tilePath)
+	tileScheme, err := mbtiles.ParseTileScheme(scheme)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	tilesets = make(map[string]mbtiles.DB)
-	for _, filename := range filenames {
-		subpath, err := filepath.Rel(tilePath, filename)
-		if err != nil {
-			log.Errorf("Unable to extract ID for file: %s\n%v", filename, err)
-			continue
-		}
-		e := filepath.Ext(filename)
-		p := filepath.ToSlash(subpath)
-		id := strings.ToLower(p[:len(p)-len(e)])
+	ids, err := newIDAssigner(idStrategy, collisions)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-		tileset, err := mbtiles.NewDB(filename)
+	roots := make([]tileRoot, len(tilePaths))
+	filenames := make([][]string, len(tilePaths))
+	found := 0
+	var remotes []*remoteRoot
+	for i, p := range tilePaths {
+		roots[i] = parseTileRoot(p)
+		if isRemote(roots[i].dir) {
+			remote, err := newRemoteRoot(roots[i].dir, remoteCache)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			// serve the cached files if the object storage is unavailable
+			if err := remote.sync(); err != nil {
+				log.Errorf("%v", err)
+			}
+			roots[i].dir = remote.dir
+			remotes = append(remotes, remote)
+		}
+		filenames[i], err = findTilesets(roots[i].dir, maxDepth)
 		if err != nil {
-			log.Errorf("could not open mbtiles file: %s\n%v", filename, err)
-			continue
+			log.Fatalf("Unable to scan tileset directory for mbtiles files\n%v", err)
 		}
-		log.Infof("providing tiles from %q as %q", filename, id)
-		tilesets[id] = *tileset
+		log.Infof("Found %v mbtiles files in %s", len(filenames[i]), roots[i].dir)
+		found += len(filenames[i])
 	}
 
-	log.Debugf("Cache size: %v MB\n", cacheSize)
-
-	cache = groupcache.NewGroup("TileCache", cacheSize*1048576, groupcache.GetterFunc(cacheGetter))
+	if found == 0 && len(tileURLs) == 0 {
+		log.Fatal("No tilesets found in tileset directory")
+	}
 
-	e := echo.New()
-	e.HideBanner = true
-	e.Pre(middleware.RemoveTrailingSlash())
+	var dbOpts []mbtiles.Option
+	if readOnly {
+		dbOpts = append(dbOpts, mbtiles.ReadOnly())
+	}
+	if quickCheck {
+		dbOpts = append(dbOpts, mbtiles.CheckIntegrity())
+	}
+	var tileCache *mbtiles.TileCache
+	if cacheSize > 0 {
+		log.Debugf("Cache size: %v MB\n", cacheSize)
+		tileCache = mbtiles.NewTileCache(cacheSize)
+		dbOpts = append(dbOpts, mbtiles.Cache(tileCache))
+	}
+	store, err := mbtiles.ParseTempStore(tempStore)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	dbOpts = append(dbOpts,
+		mbtiles.SQLiteCacheSize(sqliteCache),
+		mbtiles.MmapSize(mmapSize),
+		mbtiles.BusyTimeout(busyTimeout),
+		mbtiles.TempStoreMode(store),
+		mbtiles.MaxOpenConns(maxConns),
+		mbtiles.MaxIdleConns(maxIdleConns),
+		mbtiles.ConnMaxLifetime(connLifetime),
+		mbtiles.QueryTimeout(queryTimeout),
+	)
+	if slowQuery > 0 {
+		dbOpts = append(dbOpts, mbtiles.SlowQueryLog(slowQuery, logSlowQuery))
+	}
+
+	svcSet := handlers.New()
+	svcSet.Domain = domain
+	svcSet.Path = pathPrefix
+	svcSet.BaseURL = strings.TrimSuffix(rootURL, "/")
+	if trustProxy {
+		// trusting all sources includes the networks of --trusted-proxies
+		if len(proxies) > 0 {
+			log.Warnln("--trustproxy trusts all requests, regardless of --trusted-proxies")
+		}
+		proxies = []string{"*"}
+	}
+	if svcSet.TrustedProxies, err = handlers.ParseTrustedProxies(proxies); err != nil {
+		log.Fatalln(err)
+	}
+	svcSet.TileCache = tileCache
+	svcSet.Overzoom = overzoom
+	svcSet.ImageQuality = quality
+	switch tileSize {
+	case 0, 256, 512:
+		svcSet.TileSize = tileSize
+	default:
+		log.Fatalf("Invalid --tilesize %d, expected 256 or 512", tileSize)
+	}
+	svcSet.Scheme = tileScheme
+	if svcSet.MissingTile, err = handlers.ParseMissingTile(missingTile); err != nil {
+		log.Fatalln(err)
+	}
+	for _, v := range missingFor {
+		pcs := strings.SplitN(v, "=", 2)
+		if len(pcs) != 2 {
+			log.Fatalf("Invalid --missingtilefor %q, expected <id>=<response>", v)
+		}
+		m, err := handlers.ParseMissingTile(pcs[1])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if svcSet.MissingTiles == nil {
+			svcSet.MissingTiles = make(map[string]*handlers.MissingTile)
+		}
+		svcSet.MissingTiles[pcs[0]] = m
+	}
+	svcSet.PlainGrids = plainGrids
+	if keysFile != "" {
+		svcSet.APIKeys, err = handlers.LoadAPIKeys(keysFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Infof("Loaded %v API keys from %s", len(svcSet.APIKeys), keysFile)
+	}
+	if len(aclFile) > 0 {
+		svcSet.ACLs, err = handlers.LoadACLs(aclFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Infof("Loaded %v access control lists from %s", len(svcSet.ACLs), aclFile)
+	}
+	svcSet.StylesDir = stylesDir
+	svcSet.FontsDir = fontsDir
+	svcSet.SpritesDir = spritesDir
+	if respCache > 0 || len(redisURL) > 0 || len(peers) > 0 {
+		svcSet.ResponseCache = handlers.NewResponseCache(respCache << 20)
+	}
+	if len(redisURL) > 0 {
+		redis, err := handlers.NewRedisCache(redisURL, redisTTL)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer redis.Close()
+		svcSet.ResponseCache.Shared = redis
+	}
+	if len(peers) > 0 {
+		if len(peerSelf) == 0 {
+			log.Fatalln("--peerself is required with --peers")
+		}
+		pc, err := handlers.NewPeerCache("mbtileserver", peerSelf, peerCache<<20)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(peerSecret) > 0 {
+			secret, err := readSecret(peerSecret, "peer secret")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			pc.Secret = string(secret)
+		}
+		if err := pc.SetResolved(context.Background(), peers); err != nil {
+			log.Fatalln(err)
+		}
+		go func() {
+			for range time.Tick(peerRefresh) {
+				if err := pc.SetResolved(context.Background(), peers); err != nil {
+					log.Warnf("Cannot refresh peers: %v", err)
+				}
+			}
+		}()
+		svcSet.ResponseCache.Peers = pc
+	}
+	if rateLimit > 0 {
+		svcSet.RateLimiter = handlers.NewRateLimiter(rateLimit, burst)
+	}
+	if len(signingKey) > 0 {
+		svcSet.URLSigningKey, err = loadSigningKey()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(jwtSecret) > 0 || len(jwtKey) > 0 {
+		svcSet.JWT, err = loadJWTAuth()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 	if verbose {
-		e.Use(middleware.Logger())
+		svcSet.AccessLog = newAccessLog(log.StandardLogger().Out)
 	}
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
-
-	t := &Template{
-		templates: template.Must(handlers.TemplatesFromAssets()),
+	var tracer *otlp.Tracer
+	if len(otlpURL) > 0 {
+		tracer = otlp.NewTracer(otlpURL, "mbtileserver")
+		tracer.ErrorHandler = func(err error) {
+			log.Errorf("Could not export traces: %v", err)
+		}
+		svcSet.Tracer = tracer
+		// continue the traces in upstream tile servers
+		mbtiles.ProxyClient.Transport = otlp.Transport(mbtiles.ProxyClient.Transport)
 	}
-	e.Renderer = t
-
-	gzip := middleware.Gzip()
-
-	// Setup routing
-	e.File("/favicon.ico", "favicon.ico")
-	e.File("/favicon.png", "favicon.png")
-
-	// TODO: can use more caching here
-	staticPrefix := "/static"
-	if pathPrefix != "" {
-		staticPrefix = "/" + pathPrefix + staticPrefix
+	if len(adminKey) > 0 {
+		key, err := readSecret(adminKey, "admin key")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		svcSet.AdminKey = string(key)
+		svcSet.UploadDir = uploadDir
+		for i := 0; len(svcSet.UploadDir) == 0 && i < len(roots); i++ {
+			// uploads to the cache of object storage would be removed
+			if !isRemote(tilePaths[i]) {
+				svcSet.UploadDir = roots[i].dir
+			}
+		}
+		if len(svcSet.UploadDir) == 0 {
+			log.Fatalln("Upload directory is required if all tileset directories are in object storage")
+		}
+		svcSet.MaxUploadSize = maxUpload << 20
+		svcSet.UploadOptions = dbOpts
 	}
-	staticHandler := http.StripPrefix(staticPrefix, handlers.Static())
-	e.GET(staticPrefix+"*", echo.WrapHandler(staticHandler), gzip)
-
-	e.GET("/services", ListServices, NotModifiedMiddleware, gzip)
+	if len(fastlySvc) > 0 {
+		token, err := readSecret(fastlyToken, "Fastly API token")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		svcSet.Purgers = append(svcSet.Purgers, &handlers.FastlyPurger{ServiceID: fastlySvc, Token: string(token)})
+	}
+	if len(cfZone) > 0 {
+		token, err := readSecret(cfToken, "Cloudflare API token")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		svcSet.Purgers = append(svcSet.Purgers, &handlers.CloudflarePurger{ZoneID: cfZone, Token: string(token)})
+	}
+	svcSet.PurgeError = func(err error) {
+		log.Errorf("%v", err)
+	}
+	var served []string
+	for i, root := range roots {
+		for _, filename := range filenames[i] {
+			if _, ok := ids.byFile[filename]; ok {
+				// the file is also below a previous tile root
+				continue
+			}
+			id, err := ids.assign(root, filename)
+			if err != nil {
+				if collisions == collisionError {
+					log.Fatalln(err)
+				}
+				log.Errorf("%v", err)
+				continue
+			}
 
-	services := e.Group("/services/")
-	arcgis := e.Group("/arcgis/rest/services/")
+			err = svcSet.AddDBOnPath(filename, id, dbOpts...)
+			if err != nil {
+				ids.release(filename)
+				log.Errorf("%v", err)
+				continue
+			}
+			log.Infof("providing tiles from %q as %q", filename, id)
+			served = append(served, filename)
+		}
+	}
 
-	var g, ag *echo.Group
-	for id := range tilesets {
-		dir, _ := path.Split(id)
-		if len(dir) == 0 {
-			g = services
-			ag = arcgis
-			// TODO: e.GET("/arcgis/rest/services", GetArcGISServices, NotModifiedMiddleware, gzip)
+	for _, u := range tileURLs {
+		// the ID precedes the URL, as the query of URLs may contain "="
+		var id string
+		if i := strings.Index(u, "="); i >= 0 && i < strings.Index(u, "://") {
+			u, id = u[i+1:], strings.ToLower(strings.Trim(u[:i], "/"))
 		} else {
-			g = services.Group(dir)
-			ag = arcgis.Group(dir)
-			// TODO: services listing for tiles in this dir
-			// TODO: services listing for ArcGIS in this dir
+			id, _ = handlers.IDFromBasename("", strings.SplitN(u, "?", 2)[0])
 		}
+		httpURL, client, err := remoteTileset(u)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		id, err = ids.claim(u, id)
+		if err != nil {
+			if collisions == collisionError {
+				log.Fatalln(err)
+			}
+			log.Errorf("%v", err)
+			continue
+		}
+		opts := append(dbOpts[:len(dbOpts):len(dbOpts)], mbtiles.HTTPClient(client), mbtiles.PageCacheSize(pageCache))
+		if err := svcSet.AddDBOnPath(httpURL, id, opts...); err != nil {
+			ids.release(u)
+			log.Errorf("%v", err)
+			continue
+		}
+		log.Infof("providing tiles from %q as %q", u, id)
+	}
+
+	if cfg != nil {
+		svcSet.SetPolicies(cfg.Tilesets)
+	}
 
-		g.GET(":id", GetServiceInfo, NotModifiedMiddleware, gzip)
-		g.GET(":id/map", GetServiceHTML, NotModifiedMiddleware, gzip)
-		g.GET(":id/tiles/:z/:x/:filename", GetTile, NotModifiedMiddleware)
+	// on SIGHUP, reopen the log file, reload the configuration file and
+	// rescan the tileset directories, without interrupting requests
+	rescan := make(chan struct{}, 1)
+	watcher := newTilesetWatcher(svcSet, roots, remotes, maxDepth, ids, served, dbOpts)
+	svcSet.UploadLock = &watcher.mu
+	svcSet.Uploaded = watcher.uploaded
+	var fw *fsnotify.Watcher
+	if !noWatch {
+		if fw, err = watcher.notify(); err != nil {
+			log.Errorf("Cannot watch tileset directories for changes: %v", err)
+		}
+	}
+	go watcher.watch(watch, fw, rescan)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			hangup(svcSet, logOutput, configFile, cfg, rescan)
+		}
+	}()
 
-		ag.GET(":id/MapServer", GetArcGISService, NotModifiedMiddleware, gzip)
-		ag.GET(":id/MapServer/layers", GetArcGISServiceLayers, NotModifiedMiddleware, gzip)
-		ag.GET(":id/MapServer/legend", GetArcGISServiceLegend, NotModifiedMiddleware, gzip)
-		ag.GET(":id/MapServer/tile/:z/:y/:x", GetArcGISTile, NotModifiedMiddleware)
+	ef := func(err error) {
+		log.Errorf("%v", err)
 	}
+	e := newServer(svcSet, ef)
 
-	e.GET("/admin/cache", CacheInfo, gzip)
+	// In an AWS Lambda function, answer the API Gateway events instead of
+	// listening on a port
+	if lambda.IsLambda() {
+		log.Info("Serving the invocations of the Lambda function")
+		log.Fatal(lambda.Serve(e))
+	}
 
 	// Start the server
 	fmt.Println("\n--------------------------------------")
@@ -252,12 +692,15 @@ func serve() {
 		e.Pre(middleware.HTTPSRedirect())
 		if port == 443 {
 			go func(c *echo.Echo) {
-				fmt.Println("HTTP server with redirect started on port 80\n")
-				log.Fatal(e.Start(":80"))
+				fmt.Print("HTTP server with redirect started on port 80\n\n")
+				if err := e.Start(":80"); err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
 			}(e)
 		}
 	}
 
+	var start func() error
 	switch {
 	case certExists:
 		{
@@ -270,268 +713,278 @@ func serve() {
 			}
 
 			fmt.Printf("HTTPS server started on port %v\n", port)
-			log.Fatal(e.StartTLS(fmt.Sprintf(":%v", port), certificate, privateKey))
+			start = func() error {
+				return e.StartTLS(fmt.Sprintf(":%v", port), certificate, privateKey)
+			}
 		}
 	case autotls:
 		{
 			log.Debug("Starting HTTPS using Let's Encrypt")
-			e.AutoTLSManager.Cache = autocert.DirCache(".certs")
-			e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(domain)
+			configureAutoTLS(&e.AutoTLSManager)
 			fmt.Printf("HTTPS server started on port %v\n", port)
-			log.Fatal(e.StartAutoTLS(fmt.Sprintf(":%v", port)))
+			start = func() error {
+				return e.StartAutoTLS(fmt.Sprintf(":%v", port))
+			}
+		}
+	case listener != nil:
+		{
+			fmt.Printf("HTTP server started on %v\n", listener.Addr())
+			e.Listener = listener
+			start = func() error {
+				return e.Start("")
+			}
 		}
 	default:
 		{
 			fmt.Printf("HTTP server started on port %v\n", port)
-			log.Fatal(e.Start(fmt.Sprintf(":%v", port)))
+			start = func() error {
+				return e.Start(fmt.Sprintf(":%v", port))
+			}
 		}
 	}
 
-}
-
-func cacheGetter(ctx groupcache.Context, key string, dest groupcache.Sink) error {
-	pathParams := strings.Split(key, "|")
-	id := pathParams[0]
-	tileType := pathParams[1]
-	z64, _ := strconv.ParseUint(pathParams[2], 0, 8)
-	z := uint8(z64)
-	x, _ := strconv.ParseUint(pathParams[3], 0, 64)
-	y, _ := strconv.ParseUint(pathParams[4], 0, 64)
-	//flip y to match the spec
-	y = (1 << z) - 1 - y
-
-	// TODO: if y is a very large number, e.g., 18446744073709551615, then it is an overflow and not a valid y value
-
-	var data []byte
-	tileset := tilesets[id]
+	go func() {
+		if err := start(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	if tileType == "tile" {
-		err := tileset.ReadTile(z, x, y, &data)
+	var h3 *http3.Server
+	if serveHTTP3 {
+		tlsConfig, err := http3TLSConfig(e)
 		if err != nil {
-			log.Errorf("Error encountered reading tile for z=%v, x=%v, y=%v, \n%v", z, x, y, err)
-			return err
+			log.Fatalln(err)
 		}
-	} else if tileType == "grid" && tileset.HasUTFGrid() {
-		err := tileset.ReadGrid(z, x, y, &data)
-		if err != nil {
-			log.Errorf("Error encountered reading grid for z=%v, x=%v, y=%v, \n%v", z, x, y, err)
-			return err
+		h3 = newHTTP3Server(fmt.Sprintf(":%v", port), e, tlsConfig)
+		fmt.Printf("HTTP/3 server started on UDP port %v\n", port)
+		go func() {
+			if err := h3.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// stop accepting connections on SIGINT or SIGTERM, give in-flight
+	// requests some time to finish, and close the tilesets
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Infof("Shutting down, waiting up to %v for in-flight requests", shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Errorf("Could not finish all requests: %v", err)
+	}
+	if h3 != nil {
+		if err := h3.Shutdown(ctx); err != nil {
+			log.Errorf("Could not finish all HTTP/3 requests: %v", err)
 		}
 	}
-
-	dest.SetBytes(data)
-	return nil
-}
-
-// Verifies that service exists and return 404 otherwise
-func getServiceOr404(c echo.Context) (string, error) {
-	requestPath := strings.ToLower(c.Request().URL.Path)
-	idPos := strings.Index(c.Path(), ":id")
-	if idPos != -1 {
-		// remove trailing part of path after :id
-		requestPath = fmt.Sprintf("%s/%s", requestPath[:idPos-1], strings.ToLower(c.Param("id")))
+	if err := svcSet.Close(); err != nil {
+		log.Errorf("Could not close all tilesets: %v", err)
 	}
-	id := strings.Split(requestPath, "/services/")[1]
-	if _, exists := tilesets[id]; !exists {
-		log.Warnf("Service not found: %s\n", id)
-		return "", echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Service not found: %s", id))
+	if tracer != nil {
+		tracer.Close()
 	}
-	return id, nil
-}
-
-// getRootURL is a convenience function to determine the root URL from the
-// echo.Context.
-func getRootURL(c echo.Context) string {
-	return handlers.RootURL(c.Request(), domain, pathPrefix)
-}
 
-func ListServices(c echo.Context) error {
-	// TODO: need to paginate the responses
-	rootURL := fmt.Sprintf("%s%s", getRootURL(c), c.Request().URL)
-	services := make([]ServiceInfo, len(tilesets))
-	i := 0
-	for id, tileset := range tilesets {
-		services[i] = ServiceInfo{
-			ImageType: tileset.TileFormatString(),
-			URL:       fmt.Sprintf("%s/%s", rootURL, id),
-		}
-		i++
-	}
-	return c.JSON(http.StatusOK, services)
 }
 
-//TODO: separate out tileJSON render into a separate function
-//then it can be directly injected into template HTML instead of URL, and bypass one request
-func GetServiceInfo(c echo.Context) error {
-	id, err := getServiceOr404(c)
-	if err != nil {
-		return err
+// newServer returns the server of the tilesets of svcSet, whose errors are
+// handled by ef.
+func newServer(svcSet *handlers.ServiceSet, ef func(error)) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.Pre(middleware.RemoveTrailingSlash())
+	if len(pathPrefix) > 0 {
+		e.Pre(stripURLPrefix(pathPrefix))
 	}
-
-	svcURL := fmt.Sprintf("%s%s", getRootURL(c), c.Request().URL)
-
-	tileset := tilesets[id]
-	imgFormat := tileset.TileFormatString()
-
-	out := map[string]interface{}{
-		"tilejson": "2.1.0",
-		"id":       id,
-		"scheme":   "xyz",
-		"format":   imgFormat,
-		"tiles":    []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.%s", svcURL, imgFormat)},
-		"map":      fmt.Sprintf("%s/map", svcURL),
+	e.Use(middleware.Recover())
+	cors := middleware.DefaultCORSConfig
+	// for the pages of the service listing
+	cors.ExposeHeaders = []string{"Link", "X-Total-Count"}
+	e.Use(middleware.CORSWithConfig(cors))
+	if len(altSvc) > 0 {
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				c.Response().Header().Set("Alt-Svc", altSvc)
+				return next(c)
+			}
+		})
 	}
 
-	metadata, err := tileset.ReadMetadata()
-	if err != nil {
-		log.Errorf("Could not read metadata for tileset %v", id)
-		return err
-	}
+	// tiles are either stored with their final encoding or are already
+	// compressed, so do not compress them again
+	gzip := middleware.GzipWithConfig(middleware.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			p := c.Request().URL.Path
+			return strings.Contains(p, "/tiles/") || strings.Contains(p, "/tile/")
+		},
+	})
 
-	for k, v := range metadata {
-		switch k {
-		// strip out values above
-		case "tilejson", "id", "scheme", "format", "tiles", "map":
-			continue
+	// Setup routing
+	e.File("/favicon.ico", "favicon.ico")
+	e.File("/favicon.png", "favicon.png")
 
-		// strip out values that are not supported or are overridden below
-		case "grids", "interactivity", "modTime":
-			continue
+	// TODO: can use more caching here
+	staticPrefix := "/static"
+	staticHandler := http.StripPrefix(staticPrefix, handlers.Static())
+	e.GET(staticPrefix+"*", echo.WrapHandler(staticHandler), gzip)
 
-		// strip out values that come from TileMill but aren't useful here
-		case "metatile", "scale", "autoscale", "_updated", "Layer", "Stylesheet":
-			continue
+	h := echo.WrapHandler(svcSet.Handler(ef, true))
+	e.GET("/services", h, gzip)
+	e.GET("/services/*", h, gzip)
+	e.HEAD("/services/*", h)
+	e.POST("/services/*", h)
+	a := echo.WrapHandler(svcSet.ArcGISHandler(ef))
+	e.GET("/arcgis/rest/services", a, gzip)
+	e.GET("/arcgis/rest/services/*", a, gzip)
+	o := echo.WrapHandler(svcSet.OGCHandler(ef))
+	e.GET("/ogc", o, gzip)
+	e.GET("/ogc/*", o, gzip)
+	c := echo.WrapHandler(svcSet.CompositeHandler(ef))
+	e.GET("/composite/*", c, gzip)
+	if len(svcSet.StylesDir) > 0 {
+		// the styles can change independently of the tilesets
+		st := echo.WrapHandler(svcSet.StylesHandler(ef))
+		e.GET("/styles", st, gzip)
+		e.GET("/styles/*", st, gzip)
+	}
+	if len(svcSet.FontsDir) > 0 {
+		f := echo.WrapHandler(svcSet.FontsHandler(ef))
+		e.GET("/fonts", f, gzip)
+		e.GET("/fonts/*", f, gzip)
+	}
+	if len(svcSet.SpritesDir) > 0 {
+		sp := echo.WrapHandler(svcSet.SpritesHandler(ef))
+		e.GET("/sprites", sp, gzip)
+		e.GET("/sprites/*", sp, gzip)
+	}
+	hc := echo.WrapHandler(svcSet.HealthHandler(ef))
+	e.GET("/health", hc)
+	e.GET("/ready", hc)
+	e.GET("/metrics", echo.WrapHandler(svcSet.MetricsHandler(ef)))
+	e.GET("/admin/cache", echo.WrapHandler(svcSet.CacheHandler(ef)), gzip)
+	if c := svcSet.ResponseCache; c != nil && c.Peers != nil {
+		e.GET(handlers.PeerCacheBasePath+"*", echo.WrapHandler(c.Peers))
+	}
+	if len(svcSet.AdminKey) > 0 {
+		ah := echo.WrapHandler(svcSet.AdminHandler(ef))
+		e.POST("/admin/tilesets", ah)
+		e.POST("/admin/purge", ah)
+	}
+	return e
+}
 
-		default:
-			out[k] = v
+// hangup handles a SIGHUP: it reopens the log file logOutput, unless it is
+// nil, reloads the configuration file, unless cfg is nil, and requests a
+// rescan of the tileset directories from rescan.
+func hangup(svcSet *handlers.ServiceSet, logOutput *logFile, configFile string, cfg *config.Config, rescan chan<- struct{}) {
+	if logOutput != nil {
+		if err := logOutput.reopen(); err != nil {
+			log.Errorf("Could not reopen log file: %v", err)
 		}
 	}
-
-	if tileset.HasUTFGrid() {
-		out["grids"] = []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.json", svcURL)}
+	if cfg != nil {
+		reloadConfig(svcSet, configFile, cfg)
+	}
+	// a pending rescan covers this signal as well
+	select {
+	case rescan <- struct{}{}:
+	default:
 	}
-
-	return c.JSON(http.StatusOK, out)
 }
 
-func GetServiceHTML(c echo.Context) error {
-	id, err := getServiceOr404(c)
-	if err != nil {
-		return err
+// useTLSHostname sets the domain and enables Let's Encrypt and the redirect to
+// HTTPS for --tls-hostname, as well as port 443 unless --port is set in flags.
+func useTLSHostname(flags *pflag.FlagSet) error {
+	if len(tlsHostname) == 0 {
+		return nil
 	}
-
-	p := TemplateParams{
-		URL: fmt.Sprintf("%s%s", getRootURL(c), strings.TrimSuffix(c.Request().URL.Path, "/map")),
-		ID:  id,
+	if len(domain) > 0 && domain != tlsHostname {
+		return fmt.Errorf("domain must match the TLS host name")
 	}
-
-	if tilesets[id].TileFormat() == mbtiles.PBF {
-		return c.Render(http.StatusOK, "map_gl", p)
+	domain = tlsHostname
+	autotls = true
+	redirect = true
+	if !flags.Changed("port") {
+		port = 443
 	}
-
-	return c.Render(http.StatusOK, "map", p)
+	return nil
 }
 
-func GetTile(c echo.Context) error {
-	var (
-		data        []byte
-		contentType string
-	)
-	//TODO: validate x, y, z
+// configureAutoTLS sets up m to obtain the certificate of the domain from
+// Let's Encrypt and to cache it in --certcache.
+func configureAutoTLS(m *autocert.Manager) {
+	m.Cache = autocert.DirCache(certCache)
+	m.HostPolicy = autocert.HostWhitelist(domain)
+}
 
-	id, err := getServiceOr404(c)
-	if err != nil {
-		return err
+// loadJWTAuth returns the validation of JSON Web Tokens with the secret or
+// public key from the files of the flags.
+func loadJWTAuth() (*handlers.JWTAuth, error) {
+	a := &handlers.JWTAuth{Claim: jwtClaim}
+	if len(jwtSecret) > 0 {
+		secret, err := ioutil.ReadFile(jwtSecret)
+		if err != nil {
+			return nil, err
+		}
+		a.Key = bytes.TrimSpace(secret)
+		return a, nil
 	}
-
-	filename := c.Param("filename")
-	y := strings.Split(filename, ".")[0]
-	tileType := "tile"
-	if strings.HasSuffix(filename, ".json") {
-		tileType = "grid"
+	b, err := ioutil.ReadFile(jwtKey)
+	if err != nil {
+		return nil, err
 	}
-	key := strings.Join([]string{id, tileType, c.Param("z"), c.Param("x"), y}, "|")
-
-	err = cache.Get(nil, key, groupcache.AllocatingByteSliceSink(&data))
+	a.Key, err = handlers.JWTPublicKeyFromPEM(b)
 	if err != nil {
-		log.Errorf("Error fetching key from cache: %s", key)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Error retrieving tile")
+		return nil, fmt.Errorf("cannot load JWT key from %s: %v", jwtKey, err)
 	}
-	tileset := tilesets[id]
-	res := c.Response()
-
-	if data == nil || len(data) <= 1 {
-		switch tileset.TileFormat() {
-		case mbtiles.PNG, mbtiles.JPG, mbtiles.WEBP:
-			// Return blank PNG for all image types
-			res.Header().Add("Content-Type", "image/png")
-			res.WriteHeader(http.StatusOK)
-			_, err = res.Write(handlers.BlankPNG())
-			return err
-
-		case mbtiles.PBF:
-			// Return 204
-			res.WriteHeader(http.StatusNoContent) // this must be after setting other headers
-			return nil
+	return a, nil
+}
 
-		default:
-			// If  utfgrid, return 404 w/ json, consistent w/ mapbox
-			return c.JSON(http.StatusNotFound, struct {
-				Message string `json:"message"`
-			}{"Tile does not exist"})
-		}
+// loadSigningKey returns the key of signed URLs from the file of the flag.
+func loadSigningKey() ([]byte, error) {
+	if len(signingKey) == 0 {
+		return nil, fmt.Errorf("signing key is required")
 	}
+	return readSecret(signingKey, "signing key")
+}
 
-	if tileType == "grid" {
-		contentType = "application/json"
-
-		if tileset.UTFGridCompression() == mbtiles.ZLIB {
-			res.Header().Add("Content-Encoding", "deflate")
-		} else {
-			res.Header().Add("Content-Encoding", "gzip")
-		}
-	} else {
-		contentType = tileset.ContentType()
-
-		if tileset.TileFormat() == mbtiles.PBF {
-			res.Header().Add("Content-Encoding", "gzip")
-		}
+// readSecret returns the secret in the file filename without surrounding
+// white space. It is an error if the file is empty.
+func readSecret(filename, what string) ([]byte, error) {
+	key, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
 	}
-	res.Header().Add("Content-Type", contentType)
-
-	res.WriteHeader(http.StatusOK) // this must be after setting other headers
-	_, err = io.Copy(res, bytes.NewReader(data))
-	return err
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s in %s is empty", what, filename)
+	}
+	return key, nil
 }
 
-func CacheInfo(c echo.Context) error {
-	hotStats := cache.CacheStats(groupcache.HotCache)
-	mainStats := cache.CacheStats(groupcache.MainCache)
-
-	out := map[string]interface{}{
-		"hot":  hotStats,
-		"main": mainStats,
+// newAccessLog returns the logger of the requests to the tile services, which
+// writes them to w as text or JSON depending on --logformat.
+func newAccessLog(w io.Writer) *slog.Logger {
+	if logFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, nil))
 	}
-	return c.JSON(http.StatusOK, out)
+	return slog.New(slog.NewTextHandler(w, nil))
 }
 
-func NotModifiedMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		var lastModified time.Time
-		id := c.Param("id")
-		if _, exists := tilesets[id]; exists {
-			lastModified = tilesets[id].TimeStamp()
-		} else {
-			lastModified = startuptime // startup time of server
-		}
-
-		if t, err := time.Parse(http.TimeFormat, c.Request().Header.Get(echo.HeaderIfModifiedSince)); err == nil && lastModified.Before(t.Add(1*time.Second)) {
-			c.Response().Header().Del(echo.HeaderContentType)
-			c.Response().Header().Del(echo.HeaderContentLength)
-			return c.NoContent(http.StatusNotModified)
-		}
-
-		c.Response().Header().Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
-		return next(c)
+// logSlowQuery logs a slow read of a tileset with its details as fields.
+func logSlowQuery(q mbtiles.SlowQuery) {
+	fields := log.Fields{
+		"tileset":  q.Filename,
+		"query":    q.Query,
+		"duration": q.Duration.Seconds(),
+	}
+	if q.Query != "metadata" {
+		fields["z"], fields["x"], fields["y"] = q.Z, q.X, q.Y
+	}
+	if q.Err != nil {
+		fields["error"] = q.Err
 	}
+	log.WithFields(fields).Warn("slow query")
 }
@@ -1,69 +1,219 @@
 package main
 
 import (
-	"testing"
-	//
-	"github.com/labstack/echo"
-	//"github.com/labstack/echo/test"
-	//"github.com/stretchr/testify/assert"
-	//	"net/http/httptest"
-	//"strings"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/consbio/mbtileserver/config"
+	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/mbtiles"
 )
 
-//
-//func request(method, path string, e *echo.Echo) (int, string) {
-//	req := test.NewRequest(method, path, nil)
-//	rec := test.NewResponseRecorder()
-//	e.ServeHTTP(req, rec)
-//	return rec.Status(), rec.Body.String()
-//}
+const testBaseDir = "mbtiles/testdata"
+
+func newTestServer(t *testing.T) (*handlers.ServiceSet, http.Handler) {
+	svcSet, err := handlers.NewFromBaseDir(testBaseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svcSet.Close() })
+	return svcSet, newServer(svcSet, func(err error) { t.Error(err) })
+}
 
 func TestGetServices(t *testing.T) {
-	//assert := assert.New(t)
+	svcSet, e := newTestServer(t)
 
-	e := echo.New()
 	req := httptest.NewRequest("GET", "/services", nil)
 	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var services []handlers.ServiceInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != svcSet.Size() {
+		t.Errorf("expected %d services, got %d", svcSet.Size(), len(services))
+	}
+}
+
+func TestIfModifiedSinceUnauthorized(t *testing.T) {
+	svcSet, e := newTestServer(t)
+	svcSet.APIKeys = []handlers.APIKey{{Key: "k", Tilesets: []string{"*"}}}
+
+	since := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	for _, path := range []string{
+		"/services/geography-class-png/tiles/0/0/0.png",
+		"/arcgis/rest/services/geography-class-png/MapServer",
+		"/services/missing/tiles/0/0/0.png",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-Modified-Since", since)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code == http.StatusNotModified {
+			t.Errorf("%s: expected conditional request without API key to be rejected, got status %d", path, rec.Code)
+		}
+	}
+}
 
-	//c := e.NewContext(standard.NewRequest(req), standard.NewResponse(rec))
+func TestCacheInfo(t *testing.T) {
+	svcSet, e := newTestServer(t)
+	svcSet.TileCache = mbtiles.NewTileCache(1)
 
-	if err := ListServices(c); err != nil {
-		t.Error(err)
+	req := httptest.NewRequest("GET", "/admin/cache", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	var stats struct {
+		Tiles    *mbtiles.CacheStats    `json:"tiles"`
+		Tilesets map[string]interface{} `json:"tilesets"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
 	}
+	if stats.Tiles == nil {
+		t.Errorf("expected statistics of the shared tile cache, got %s", rec.Body)
+	}
+	if _, ok := stats.Tilesets["geography-class-png"]; !ok {
+		t.Errorf("expected cache statistics of geography-class-png, got %s", rec.Body)
+	}
+}
 
-	//assert.NotEqual(1, 0, "should match")
+func TestTLSHostname(t *testing.T) {
+	defer func(d string, a, r bool, p int, h, c string) {
+		domain, autotls, redirect, port, tlsHostname, certCache = d, a, r, p, h, c
+	}(domain, autotls, redirect, port, tlsHostname, certCache)
 
-	// Setup
-	//e := echo.New()
+	tests := []struct {
+		args   []string
+		domain string
+		port   int
+		err    bool
+	}{
+		{[]string{"--tls-hostname", "tiles.example.com"}, "tiles.example.com", 443, false},
+		{[]string{"--tls-hostname", "tiles.example.com", "--port", "8443"}, "tiles.example.com", 8443, false},
+		{[]string{"--tls-hostname", "tiles.example.com", "--domain", "tiles.example.com"}, "tiles.example.com", 443, false},
+		{[]string{"--tls-hostname", "tiles.example.com", "--domain", "example.com"}, "", 0, true},
+		{nil, "", 8000, false},
+	}
+	for _, tc := range tests {
+		domain, autotls, redirect, port, tlsHostname = "", false, false, 8000, ""
+		flags := RootCmd.Flags()
+		flags.VisitAll(func(f *pflag.Flag) { f.Changed = false })
+		if err := flags.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		err := useTLSHostname(flags)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected error", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		enabled := tlsHostname != ""
+		if domain != tc.domain || port != tc.port || autotls != enabled || redirect != enabled {
+			t.Errorf("%v: unexpected domain %q, port %d, TLS %v and redirect %v", tc.args, domain, port, autotls, redirect)
+		}
+	}
 
-	//req, err := http.NewRequest(echo.GET, "/services")
-	//assert.NoError(err)
-	//assert
-	//
-	//
+	domain, certCache = "tiles.example.com", t.TempDir()
+	var m autocert.Manager
+	configureAutoTLS(&m)
+	if err := m.HostPolicy(context.Background(), "tiles.example.com"); err != nil {
+		t.Errorf("expected certificate for the TLS host name, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "example.com"); err == nil {
+		t.Error("expected no certificate for other host names")
+	}
+	if cache, ok := m.Cache.(autocert.DirCache); !ok || string(cache) != certCache {
+		t.Errorf("expected certificates to be cached in %s, got %v", certCache, m.Cache)
+	}
+}
 
-	//r := new(http.Request)
-	//rec := httptest.NewRecorder()
-	//c := e.NewContext(standard.NewRequest(r, e.Logger()), standard.NewResponse(rec, e.Logger()))
+func TestHangup(t *testing.T) {
+	svcSet, e := newTestServer(t)
+	dir := t.TempDir()
 
-	//c, b := request(echo.GET, "/images/walle.png", e)
-	//assert.Equal(t, http.StatusOK, c)
-	//assert.NotEmpty(t, b)
-	//
-	//assert.Equal(e.Routes(), "foo", "should match")
+	logFilename := filepath.Join(dir, "mbtileserver.log")
+	logOutput, err := openLogFile(logFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logOutput.f.Close()
+	configFile := filepath.Join(dir, "config.toml")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("port = 8080\n[tilesets.geography-class-png]\nname = \"World\"\n")
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svcSet.SetPolicies(cfg.Tilesets)
+	// expectName checks the name of geography-class-png in the services
+	expectName := func(expected string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", "/services", nil))
+		var services []handlers.ServiceInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &services); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range services {
+			if strings.HasSuffix(s.URL, "/geography-class-png") {
+				if s.Name != expected {
+					t.Errorf("expected name %q, got %q", expected, s.Name)
+				}
+				return
+			}
+		}
+		t.Errorf("expected geography-class-png in %s", rec.Body)
+	}
+	expectName("World")
 
-	//c.SetPath("/services")
-	//h := ListServices(c)
-	//assert.Equal(h, http.StatusOK, "Should match")
+	// log rotation
+	logOutput.Write([]byte("before\n"))
+	if err := os.Rename(logFilename, logFilename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig("port = 8080\n[tilesets.geography-class-png]\nname = \"Geography\"\n")
+	rescan := make(chan struct{}, 1)
+	hangup(svcSet, logOutput, configFile, cfg, rescan)
+	logOutput.Write([]byte("after\n"))
+	for name, expected := range map[string]string{logFilename + ".1": "before\n", logFilename: "after\n"} {
+		if data, err := ioutil.ReadFile(name); err != nil || string(data) != expected {
+			t.Errorf("expected %q in %s, got %q, %v", expected, name, data, err)
+		}
+	}
+	expectName("Geography")
+	if len(rescan) != 1 {
+		t.Error("expected rescan of the tileset directories")
+	}
 
-	//c.SetParamValues("jon@labstack.com")
-	//h := &handler{mockDB}
-	//
-	//// Assertions
-	//if assert.NoError(t, h.getUser(c)) {
-	//	assert.Equal(t, http.StatusOK, rec.Code)
-	//	assert.Equal(t, "foo:bar", rec.Body.String())
-	//}
+	// an invalid configuration keeps the policies, and a pending rescan
+	// covers another signal
+	writeConfig("[tilesets.geography-class-png]\nname = 1\n")
+	hangup(svcSet, nil, configFile, cfg, rescan)
+	expectName("Geography")
+	if len(rescan) != 1 {
+		t.Error("expected one pending rescan")
+	}
 }
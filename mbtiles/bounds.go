@@ -0,0 +1,47 @@
+package mbtiles
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ComputeBounds derives the geographic bounding box of the tileset as west,
+// south, east, north in WGS84 degrees from the extent of the tiles at the
+// highest zoom level. It can be used if the bounds metadata item is missing
+// or wrong.
+func (tileset *DB) ComputeBounds() ([]float64, error) {
+	if tileset.store != nil {
+		return tileset.storeBounds()
+	}
+	var (
+		z                      sql.NullInt64
+		minX, maxX, minY, maxY uint64
+	)
+	err := tileset.db.QueryRow("select max(zoom_level) from tiles").Scan(&z)
+	if err != nil {
+		return nil, fmt.Errorf("could not query maximum zoom level: %v", err)
+	}
+	if !z.Valid {
+		return nil, fmt.Errorf("cannot compute bounds of empty tileset")
+	}
+	err = tileset.db.QueryRow("select min(tile_column), max(tile_column), min(tile_row), max(tile_row) from tiles where zoom_level = ?", z.Int64).Scan(&minX, &maxX, &minY, &maxY)
+	if err != nil {
+		return nil, fmt.Errorf("could not query tile extent: %v", err)
+	}
+	return tmsBounds(uint8(z.Int64), minX, maxX, minY, maxY), nil
+}
+
+// tmsBounds returns the bounds as west, south, east, north in WGS84 degrees
+// of the tiles from column minX to maxX and from TMS row minY to maxY at zoom
+// level zoom.
+func tmsBounds(zoom uint8, minX, maxX, minY, maxY uint64) []float64 {
+	n := uint64(1) << zoom
+	// flip the TMS rows, which are counted from the south
+	north, south := n-1-maxY, n-1-minY
+	return []float64{
+		tileLon(zoom, minX),
+		tileLat(zoom, south+1),
+		tileLon(zoom, maxX+1),
+		tileLat(zoom, north),
+	}
+}
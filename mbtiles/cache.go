@@ -0,0 +1,164 @@
+package mbtiles
+
+import (
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// CacheStats contains the statistics of the tile cache of a DB.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Items  int   `json:"items"`
+	Bytes  int64 `json:"bytes"`
+}
+
+type tileKey struct {
+	db   uint64
+	z    uint8
+	x, y uint64
+}
+
+// TileCache is a LRU cache of tile data that is limited by the total size of
+// the cached tiles. It can be shared by DBs with the Cache option, which then
+// compete for its size. It is safe for concurrent use.
+type TileCache struct {
+	mu       sync.Mutex
+	lru      *lru.Cache
+	maxBytes int64
+	bytes    int64
+	// stats are the statistics of the DBs by the IDs in their tile keys.
+	stats  map[uint64]*CacheStats
+	nextID uint64
+}
+
+// NewTileCache returns a TileCache which holds up to size megabytes of tile
+// data.
+func NewTileCache(size int64) *TileCache {
+	c := &TileCache{
+		lru:      lru.New(0),
+		maxBytes: size * 1048576,
+		stats:    make(map[uint64]*CacheStats),
+	}
+	c.lru.OnEvicted = func(key lru.Key, value interface{}) {
+		size := int64(len(value.([]byte)))
+		c.bytes -= size
+		if s, ok := c.stats[key.(tileKey).db]; ok {
+			s.Items--
+			s.Bytes -= size
+		}
+	}
+	return c
+}
+
+// Stats returns the statistics of all DBs that use the cache.
+func (c *TileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out CacheStats
+	for _, s := range c.stats {
+		out.Hits += s.Hits
+		out.Misses += s.Misses
+	}
+	out.Items = c.lru.Len()
+	out.Bytes = c.bytes
+	return out
+}
+
+// register returns the ID of the tile keys of a DB that uses the cache.
+func (c *TileCache) register() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	c.stats[c.nextID] = &CacheStats{}
+	return c.nextID
+}
+
+// unregister removes the tiles and the statistics of the DB with the ID db.
+func (c *TileCache) unregister(db uint64) {
+	c.purge(db, -1)
+	c.mu.Lock()
+	delete(c.stats, db)
+	c.mu.Unlock()
+}
+
+func (c *TileCache) get(k tileKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[k.db]
+	if !ok {
+		// the DB has been closed
+		return nil, false
+	}
+	v, ok := c.lru.Get(k)
+	if !ok {
+		s.Misses++
+		return nil, false
+	}
+	s.Hits++
+	return v.([]byte), true
+}
+
+func (c *TileCache) add(k tileKey, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[k.db]
+	if !ok {
+		return
+	}
+	if _, ok := c.lru.Get(k); ok {
+		c.lru.Remove(k)
+	}
+	c.lru.Add(k, data)
+	c.bytes += size
+	s.Items++
+	s.Bytes += size
+	for c.bytes > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+// purge removes the tiles of zoom level z of the DB with the ID db from the
+// cache, or all its tiles if z is negative.
+func (c *TileCache) purge(db uint64, z int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the LRU cache cannot be iterated, so the tiles are removed from the
+	// least recently used one and those of other DBs and zoom levels added
+	// again
+	var keep []interface{}
+	evicted := c.lru.OnEvicted
+	c.lru.OnEvicted = func(key lru.Key, value interface{}) {
+		evicted(key, value)
+		if k := key.(tileKey); k.db != db || (z >= 0 && int(k.z) != z) {
+			keep = append(keep, key, value)
+		}
+	}
+	for c.lru.Len() > 0 {
+		c.lru.RemoveOldest()
+	}
+	c.lru.OnEvicted = evicted
+	for i := 0; i < len(keep); i += 2 {
+		k, data := keep[i].(tileKey), keep[i+1].([]byte)
+		c.lru.Add(k, data)
+		c.bytes += int64(len(data))
+		if s, ok := c.stats[k.db]; ok {
+			s.Items++
+			s.Bytes += int64(len(data))
+		}
+	}
+}
+
+func (c *TileCache) cacheStats(db uint64) CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.stats[db]; ok {
+		return *s
+	}
+	return CacheStats{}
+}
@@ -0,0 +1,138 @@
+package mbtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tileWriter is implemented by Writer and PMTilesWriter.
+type tileWriter interface {
+	WriteTile(z uint8, x uint64, y uint64, data []byte) error
+	Close() error
+}
+
+// Convert copies the tiles and metadata of the tileset at src, which may be
+// any file NewDB can open, to a new file at dst, which is written as a
+// PMTiles file if its extension is ".pmtiles" and as an mbtiles file
+// otherwise. The tiles are copied without being decoded, one at a time.
+// The options opts are passed on to CreateDB, e.g. Deduplicate, except for
+// Scheme. If the conversion fails, dst is removed.
+func Convert(ctx context.Context, src, dst string, opts ...Option) error {
+	db, err := NewDB(src, ReadOnly(), Scheme(TMS))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return fmt.Errorf("could not read metadata of %s: %v", src, err)
+	}
+	if _, ok := metadata["format"]; !ok {
+		metadata["format"] = db.TileFormatString()
+	}
+	if e := db.TileEncoding(); e != IDENTITY {
+		metadata["compression"] = e.String()
+	}
+
+	var w tileWriter
+	if tilesetExt(dst) == ".pmtiles" {
+		pw, err := CreatePMTiles(dst, Scheme(TMS))
+		if err != nil {
+			return err
+		}
+		for k, v := range metadata {
+			pw.WriteMetadata(k, v)
+		}
+		w = pw
+	} else {
+		mw, err := CreateDB(dst, append(opts, Scheme(TMS))...)
+		if err != nil {
+			return err
+		}
+		if err := writeMetadataItems(mw, metadata); err != nil {
+			mw.Close()
+			os.Remove(dst)
+			return err
+		}
+		w = mw
+	}
+
+	err = copyTiles(ctx, db, w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// copyTiles writes all tiles of db to w.
+func copyTiles(ctx context.Context, db *DB, w tileWriter) error {
+	it, err := db.Tiles(ctx, TileFilter{})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		t := it.Tile()
+		if err := w.WriteTile(t.Z, t.X, t.Y, t.Data); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// writeMetadataItems writes the metadata as returned by ReadMetadata to the
+// metadata table of w.
+func writeMetadataItems(w *Writer, metadata map[string]interface{}) error {
+	items, err := metadataItems(metadata)
+	if err != nil {
+		return err
+	}
+	for k, v := range items {
+		if err := w.WriteMetadata(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataItems returns the metadata as returned by ReadMetadata as items of
+// the metadata table. Items that are neither strings nor numbers, like
+// "vector_layers", are combined into the "json" item.
+func metadataItems(metadata map[string]interface{}) (map[string]string, error) {
+	items := make(map[string]string)
+	other := make(map[string]interface{})
+	for k, v := range metadata {
+		switch v := v.(type) {
+		case string:
+			items[k] = v
+		case int:
+			items[k] = strconv.Itoa(v)
+		case float64:
+			items[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []float64:
+			values := make([]string, len(v))
+			for i, f := range v {
+				values[i] = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			items[k] = strings.Join(values, ",")
+		default:
+			other[k] = v
+		}
+	}
+	if len(other) > 0 {
+		data, err := json.Marshal(other)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode metadata: %v", err)
+		}
+		items["json"] = string(data)
+	}
+	return items, nil
+}
@@ -0,0 +1,84 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "testdata/geography-class-png.mbtiles"
+	pmtiles := filepath.Join(dir, "test.pmtiles")
+	roundtrip := filepath.Join(dir, "test.mbtiles")
+	if err := Convert(context.Background(), src, pmtiles); err != nil {
+		t.Fatal(err)
+	}
+	if err := Convert(context.Background(), pmtiles, roundtrip); err != nil {
+		t.Fatal(err)
+	}
+	if err := Convert(context.Background(), src, pmtiles); err == nil {
+		t.Error("expected error for existing output file")
+	}
+
+	expected, err := NewDB(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expected.Close()
+	expectedMetadata, err := expected.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{pmtiles, roundtrip} {
+		db, err := NewDB(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if db.TileFormat() != PNG {
+			t.Errorf("%s: expected PNG tiles, got %s", filename, db.TileFormat())
+		}
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"name", "description", "minzoom", "maxzoom"} {
+			if metadata[k] != expectedMetadata[k] {
+				t.Errorf("%s: expected metadata %s %v, got %v", filename, k, expectedMetadata[k], metadata[k])
+			}
+		}
+
+		it, err := expected.Tiles(context.Background(), TileFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		var data []byte
+		for it.Next() {
+			tile := it.Tile()
+			if err := db.ReadTile(tile.Z, tile.X, tile.Y, &data); err != nil {
+				t.Fatalf("%s: %d/%d/%d: %v", filename, tile.Z, tile.X, tile.Y, err)
+			}
+			if !bytes.Equal(data, tile.Data) {
+				t.Errorf("%s: %d/%d/%d: tile data differs", filename, tile.Z, tile.X, tile.Y)
+			}
+			count++
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+		}
+		it.Close()
+		if count != 5 {
+			t.Errorf("expected 5 tiles, got %d", count)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package mbtiles
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// dedupLayout describes a schema in which the tiles table is a view that
+// joins the coordinates of the tiles with their data by an ID, so that the
+// data of identical tiles is stored only once.
+type dedupLayout struct {
+	coords string // table with zoom_level, tile_column, tile_row and id
+	data   string // table with id and tile_data
+	id     string // column that links both tables
+}
+
+// dedupLayouts are the deduplicated schemas that are detected by NewDB.
+var dedupLayouts = []dedupLayout{
+	// mbutil, tilelive and Writer with the Deduplicate option
+	{coords: "map", data: "images", id: "tile_id"},
+	// tippecanoe
+	{coords: "tiles_shallow", data: "tiles_data", id: "tile_data_id"},
+}
+
+// detectDedupLayout returns the deduplicated layout of the tiles view of db,
+// or nil if tiles is a table or the view joins other tables.
+func detectDedupLayout(db *sql.DB) (*dedupLayout, error) {
+	var views int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'view' AND name = 'tiles'").Scan(&views)
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema: %v", err)
+	}
+	if views == 0 {
+		return nil, nil
+	}
+	for i := range dedupLayouts {
+		l := &dedupLayouts[i]
+		var count int
+		err := db.QueryRow(`SELECT (SELECT count(*) FROM pragma_table_info(?) WHERE name IN ('zoom_level', 'tile_column', 'tile_row', ?))
+			+ (SELECT count(*) FROM pragma_table_info(?) WHERE name IN (?, 'tile_data'))`, l.coords, l.id, l.data, l.id).Scan(&count)
+		if err != nil {
+			return nil, fmt.Errorf("could not read schema: %v", err)
+		}
+		if count == 6 {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+// tileQuery returns the query of the data of a tile by its coordinates.
+func (l *dedupLayout) tileQuery() string {
+	return fmt.Sprintf("select d.tile_data from %s c join %s d on d.%s = c.%[3]s where c.zoom_level = ? and c.tile_column = ? and c.tile_row = ?", l.coords, l.data, l.id)
+}
+
+// hasTileQuery returns the query whether a tile exists, which does not read
+// the table of the tile data.
+func (l *dedupLayout) hasTileQuery() string {
+	return fmt.Sprintf("select 1 from %s where zoom_level = ? and tile_column = ? and tile_row = ? limit 1", l.coords)
+}
+
+// tileIDQuery returns the query of the ID of the data of a tile.
+func (l *dedupLayout) tileIDQuery() string {
+	return fmt.Sprintf("select %s from %s where zoom_level = ? and tile_column = ? and tile_row = ?", l.id, l.coords)
+}
@@ -0,0 +1,58 @@
+package mbtiles
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTippecanoeLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tippecanoe.mbtiles")
+	db, err := sql.Open(sqliteDriver, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE TABLE tiles_shallow (zoom_level integer, tile_column integer, tile_row integer, tile_data_id integer, primary key(zoom_level, tile_column, tile_row)) without rowid",
+		"CREATE TABLE tiles_data (tile_data_id integer primary key, tile_data blob)",
+		`CREATE VIEW tiles AS SELECT tiles_shallow.zoom_level AS zoom_level, tiles_shallow.tile_column AS tile_column,
+			tiles_shallow.tile_row AS tile_row, tiles_data.tile_data AS tile_data
+			FROM tiles_shallow JOIN tiles_data ON tiles_shallow.tile_data_id = tiles_data.tile_data_id`,
+		"INSERT INTO tiles_data VALUES (1, x'89504e470d0a1a0a666f6f')",
+		"INSERT INTO tiles_shallow VALUES (0, 0, 0, 1), (1, 0, 0, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	tileset, err := NewDB(filename, Scheme(TMS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tileset.Close()
+	if !tileset.IsDeduplicated() {
+		t.Fatal("expected the tippecanoe schema to be detected")
+	}
+	var data []byte
+	if err := tileset.ReadTile(1, 0, 0, &data); err != nil || !bytes.Equal(data, pngTile) {
+		t.Errorf("expected %q, got %q (%v)", pngTile, data, err)
+	}
+	if id, err := tileset.ReadTileID(0, 0, 0); err != nil || id != "1" {
+		t.Errorf("expected ID 1, got %q (%v)", id, err)
+	}
+	if ok, err := tileset.HasTile(1, 1, 0); ok || err != nil {
+		t.Errorf("expected no tile at 1/1/0, got %v, %v", ok, err)
+	}
+}
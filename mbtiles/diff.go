@@ -0,0 +1,243 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// ChangeKind tells how a tile differs between two tilesets.
+type ChangeKind uint8
+
+const (
+	// TileAdded is a tile that only exists in the newer tileset.
+	TileAdded ChangeKind = iota
+	// TileRemoved is a tile that only exists in the older tileset.
+	TileRemoved
+	// TileChanged is a tile that exists in both tilesets with different data.
+	TileChanged
+)
+
+// String returns "added", "removed" or "changed".
+func (k ChangeKind) String() string {
+	switch k {
+	case TileAdded:
+		return "added"
+	case TileRemoved:
+		return "removed"
+	}
+	return "changed"
+}
+
+// TileChange is a tile that differs between two tilesets. The Data of the
+// Tile is that of the newer tileset, it is nil for removed tiles.
+type TileChange struct {
+	Tile
+	Kind ChangeKind
+}
+
+// DiffStats contains the number of tiles that differ between two tilesets.
+type DiffStats struct {
+	Added   int64 `json:"added"`
+	Removed int64 `json:"removed"`
+	Changed int64 `json:"changed"`
+}
+
+// removedTilesSchema contains the statements that create the table of the
+// removed tiles of a patch.
+var removedTilesSchema = []string{
+	"CREATE TABLE removed_tiles (zoom_level integer, tile_column integer, tile_row integer)",
+	"CREATE UNIQUE INDEX removed_tile_index ON removed_tiles (zoom_level, tile_column, tile_row)",
+}
+
+// Diff compares the tiles of the mbtiles files from and to by their data and
+// calls fn for each tile that was added, removed or changed in to, ordered by
+// zoom level, column and row. The row of the tiles is that of the TileScheme
+// of to. Both tilesets are read only once, side by side, so this works for
+// files of any size. If fn returns an error, Diff stops and returns it.
+func Diff(ctx context.Context, from, to *DB, fn func(TileChange) error) (DiffStats, error) {
+	var s DiffStats
+	if from.store != nil || to.store != nil {
+		return s, fmt.Errorf("can only compare mbtiles files")
+	}
+	a, err := from.Tiles(ctx, TileFilter{})
+	if err != nil {
+		return s, err
+	}
+	defer a.Close()
+	b, err := to.Tiles(ctx, TileFilter{})
+	if err != nil {
+		return s, err
+	}
+	defer b.Close()
+
+	// both iterators are ordered by the TMS rows of the tiles table
+	hasA, hasB := a.Next(), b.Next()
+	for hasA || hasB {
+		var c TileChange
+		var ta, tb Tile
+		if hasA {
+			ta = a.Tile()
+			ta.Y = from.tmsRow(ta.Z, ta.Y)
+		}
+		if hasB {
+			tb = b.Tile()
+			tb.Y = to.tmsRow(tb.Z, tb.Y)
+		}
+		switch cmp := compareTiles(ta, tb); {
+		case !hasB || hasA && cmp < 0:
+			c = TileChange{Tile: Tile{Z: ta.Z, X: ta.X, Y: ta.Y}, Kind: TileRemoved}
+			s.Removed++
+			hasA = a.Next()
+		case !hasA || cmp > 0:
+			c = TileChange{Tile: tb, Kind: TileAdded}
+			s.Added++
+			hasB = b.Next()
+		default:
+			hasA, hasB = a.Next(), b.Next()
+			if bytes.Equal(ta.Data, tb.Data) {
+				continue
+			}
+			c = TileChange{Tile: tb, Kind: TileChanged}
+			s.Changed++
+		}
+		if to.scheme != TMS {
+			c.Y = flipRow(c.Z, c.Y)
+		}
+		if err := fn(c); err != nil {
+			return s, err
+		}
+	}
+	if err := a.Err(); err != nil {
+		return s, err
+	}
+	return s, b.Err()
+}
+
+// compareTiles orders the tiles a and b by zoom level, column and row.
+func compareTiles(a, b Tile) int {
+	switch {
+	case a.Z != b.Z:
+		return int(a.Z) - int(b.Z)
+	case a.X < b.X:
+		return -1
+	case a.X > b.X:
+		return 1
+	case a.Y < b.Y:
+		return -1
+	case a.Y > b.Y:
+		return 1
+	}
+	return 0
+}
+
+// WritePatch creates a new mbtiles file at patch with the tiles that were
+// added to or changed in to compared to from, and with the metadata of to.
+// The coordinates of removed tiles are stored in the additional table
+// removed_tiles. The patch is a valid mbtiles file by itself, ApplyPatch
+// turns a copy of from into one with the tiles of to. The options opts are
+// passed on to CreateDB, except for Scheme. If writing the patch fails, the
+// file is removed.
+func WritePatch(ctx context.Context, from, to *DB, patch string, opts ...Option) (DiffStats, error) {
+	metadata, err := to.ReadMetadata()
+	if err != nil {
+		return DiffStats{}, fmt.Errorf("could not read metadata: %v", err)
+	}
+	if _, ok := metadata["format"]; !ok {
+		metadata["format"] = to.TileFormatString()
+	}
+	w, err := CreateDB(patch, append(opts, Scheme(TMS))...)
+	if err != nil {
+		return DiffStats{}, err
+	}
+	for _, stmt := range removedTilesSchema {
+		if _, err = w.db.Exec(stmt); err != nil {
+			err = fmt.Errorf("could not create patch schema: %v", err)
+			break
+		}
+	}
+	if err == nil {
+		err = writeMetadataItems(w, metadata)
+	}
+	var s DiffStats
+	if err == nil {
+		s, err = Diff(ctx, from, to, func(c TileChange) error {
+			y := to.tmsRow(c.Z, c.Y)
+			if c.Kind == TileRemoved {
+				return w.removeTile(c.Z, c.X, y)
+			}
+			return w.WriteTile(c.Z, c.X, y, c.Data)
+		})
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(patch)
+		return s, err
+	}
+	return s, nil
+}
+
+// removeTile records the tile at z, x and the TMS row y in the
+// removed_tiles table of a patch.
+func (w *Writer) removeTile(z uint8, x, y uint64) error {
+	if err := w.begin(); err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	_, err := w.tx.Exec("INSERT OR REPLACE INTO removed_tiles (zoom_level, tile_column, tile_row) VALUES (?, ?, ?)", z, x, y)
+	if err != nil {
+		return fmt.Errorf("could not write removed tile for z=%d, x=%d, y=%d: %v", z, x, y, err)
+	}
+	w.pending++
+	if w.BatchSize > 0 && w.pending >= w.BatchSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// ApplyPatch applies the patch written by WritePatch to the mbtiles file at
+// filename in place, within a single transaction: the tiles of the patch are
+// inserted, the removed tiles are deleted and the metadata is replaced by that
+// of the patch. The tiles table of the file must not be a view. The file must
+// not be opened with the ReadOnly option while it is being patched.
+func ApplyPatch(ctx context.Context, filename, patch string) error {
+	if _, err := os.Stat(patch); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+	db, err := sql.Open(sqliteDriver, filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// the attached database is only known to the connection that attached it
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "ATTACH DATABASE ? AS patch", patch); err != nil {
+		return fmt.Errorf("could not open patch %s: %v", patch, err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	for _, stmt := range []string{
+		`DELETE FROM tiles WHERE EXISTS (SELECT 1 FROM patch.removed_tiles r WHERE r.zoom_level = tiles.zoom_level
+			AND r.tile_column = tiles.tile_column AND r.tile_row = tiles.tile_row)`,
+		"INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) SELECT zoom_level, tile_column, tile_row, tile_data FROM patch.tiles",
+		"DELETE FROM metadata",
+		"INSERT INTO metadata (name, value) SELECT name, value FROM patch.metadata",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not apply patch %s: %v", patch, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit patch %s: %v", patch, err)
+	}
+	return nil
+}
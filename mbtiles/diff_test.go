@@ -0,0 +1,79 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{1, 0, 0}: pngTile,
+		{1, 1, 0}: pngTile,
+	}, map[string]string{"name": "from", "extra": "removed"})
+	to := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{1, 1, 0}: otherTile,
+		{1, 1, 1}: pngTile,
+	}, map[string]string{"name": "to"})
+
+	a, err := NewDB(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewDB(to, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	var changes []string
+	s, err := Diff(context.Background(), a, b, func(c TileChange) error {
+		changes = append(changes, fmt.Sprintf("%s %d/%d/%d", c.Kind, c.Z, c.X, c.Y))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tiles are ordered by their TMS rows
+	if expected := "[removed 1/0/0 added 1/1/1 changed 1/1/0]"; fmt.Sprint(changes) != expected {
+		t.Errorf("expected changes %s, got %v", expected, changes)
+	}
+	if s != (DiffStats{Added: 1, Removed: 1, Changed: 1}) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	patch := filepath.Join(filepath.Dir(to), "patch.mbtiles")
+	if _, err := WritePatch(context.Background(), a, b, patch); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if err := ApplyPatch(context.Background(), from, patch); err != nil {
+		t.Fatal(err)
+	}
+	a, err = NewDB(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	s, err = Diff(context.Background(), a, b, func(c TileChange) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != (DiffStats{}) {
+		t.Errorf("expected no differences after applying the patch, got %+v", s)
+	}
+	var data []byte
+	if err := a.ReadTile(1, 1, 0, &data); err != nil || !bytes.Equal(data, otherTile) {
+		t.Errorf("expected the changed tile, got %q (%v)", data, err)
+	}
+	metadata, err := a.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metadata["extra"]; ok || metadata["name"] != "to" {
+		t.Errorf("expected the metadata of the patch, got %v", metadata)
+	}
+}
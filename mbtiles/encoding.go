@@ -0,0 +1,145 @@
+package mbtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// TileEncoding is the compression that is applied to the tile data stored in
+// a DB. It is independent of the TileFormat of the tiles, although in
+// practice only PBF tiles are compressed.
+type TileEncoding uint8
+
+const (
+	IDENTITY  TileEncoding = iota // not compressed
+	GZIPENC                       // gzip
+	ZLIBENC                       // zlib, Content-Encoding: deflate
+	ZSTDENC                       // zstandard
+	BROTLIENC                     // brotli
+)
+
+// ErrUnsupportedEncoding is returned if tile data cannot be decompressed
+// because no decoder is registered for its TileEncoding.
+var ErrUnsupportedEncoding = errors.New("unsupported tile encoding")
+
+func (e TileEncoding) String() string {
+	switch e {
+	case IDENTITY:
+		return "identity"
+	case GZIPENC:
+		return "gzip"
+	case ZLIBENC:
+		return "zlib"
+	case ZSTDENC:
+		return "zstd"
+	case BROTLIENC:
+		return "brotli"
+	default:
+		return ""
+	}
+}
+
+// ContentEncoding returns the value of the HTTP Content-Encoding header for
+// tiles with this encoding. It is empty for IDENTITY.
+func (e TileEncoding) ContentEncoding() string {
+	switch e {
+	case GZIPENC:
+		return "gzip"
+	case ZLIBENC:
+		return "deflate"
+	case ZSTDENC:
+		return "zstd"
+	case BROTLIENC:
+		return "br"
+	default:
+		return ""
+	}
+}
+
+// Decoder returns a reader that decompresses the data read from r.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// decoders contains the Decoders for all encodings but IDENTITY. The
+// standard library does not implement zstd and brotli, so their decoders
+// must be provided with RegisterDecoder.
+var decoders = map[TileEncoding]Decoder{
+	GZIPENC: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	ZLIBENC: zlib.NewReader,
+}
+
+// RegisterDecoder registers the Decoder d for the encoding e, e.g. to enable
+// decompressing ZSTDENC or BROTLIENC tiles with a third party package.
+// RegisterDecoder is not safe for concurrent use and is supposed to be called
+// from an init function.
+func RegisterDecoder(e TileEncoding, d Decoder) {
+	decoders[e] = d
+}
+
+// CanDecode reports whether tiles with encoding e can be decompressed.
+func (e TileEncoding) CanDecode() bool {
+	_, ok := decoders[e]
+	return e == IDENTITY || ok
+}
+
+// Decode returns data decompressed according to the encoding e. For IDENTITY,
+// data is returned as is. ErrUnsupportedEncoding is returned if no Decoder is
+// registered for e.
+func (e TileEncoding) Decode(data []byte) ([]byte, error) {
+	if e == IDENTITY {
+		return data, nil
+	}
+	d, ok := decoders[e]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+	r, err := d(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s data: %v", e, err)
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s data: %v", e, err)
+	}
+	return out, nil
+}
+
+// detectTileEncoding inspects the first bytes of data to determine whether
+// it is compressed. Brotli streams do not have a signature, so they cannot be
+// detected this way.
+func detectTileEncoding(data []byte) TileEncoding {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		return GZIPENC
+	case bytes.HasPrefix(data, []byte("\x78\x9c")), bytes.HasPrefix(data, []byte("\x78\x01")), bytes.HasPrefix(data, []byte("\x78\xda")):
+		return ZLIBENC
+	case bytes.HasPrefix(data, []byte("\x28\xb5\x2f\xfd")):
+		return ZSTDENC
+	default:
+		return IDENTITY
+	}
+}
+
+// encodingFromName returns the TileEncoding for the value of a compression
+// metadata item.
+func encodingFromName(name string) (TileEncoding, bool) {
+	switch name {
+	case "gzip":
+		return GZIPENC, true
+	case "zlib", "deflate":
+		return ZLIBENC, true
+	case "zstd":
+		return ZSTDENC, true
+	case "br", "brotli":
+		return BROTLIENC, true
+	case "none", "identity":
+		return IDENTITY, true
+	default:
+		return IDENTITY, false
+	}
+}
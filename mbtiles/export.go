@@ -0,0 +1,85 @@
+package mbtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tileMapResource is written to directories of tiles in the TMS scheme, so
+// that they are read like those of gdal2tiles.
+const tileMapResource = `<?xml version="1.0" encoding="utf-8"?>
+<TileMap version="1.0.0" tilemapservice="http://tms.osgeo.org/1.0.0">
+  <SRS>EPSG:3857</SRS>
+</TileMap>
+`
+
+// tileExt returns the file extension of tiles of the format f.
+func tileExt(f TileFormat) string {
+	if name := f.String(); name != "" {
+		return "." + name
+	}
+	return ".bin"
+}
+
+// Export writes the tiles of the DB that match the filter f to the directory
+// dir as a z/x/y file tree like <dir>/3/4/2.png, with the rows in the
+// TileScheme of the DB, and returns the number of written tiles. Compressed
+// tiles are written as they are. The metadata is written to the file
+// metadata.json, which is read together with the tiles when dir is opened
+// with NewDB. Existing files in dir are replaced.
+func (tileset *DB) Export(ctx context.Context, dir string, f TileFilter) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	metadata, err := tileset.ReadMetadata()
+	if err != nil {
+		return 0, fmt.Errorf("could not read metadata: %v", err)
+	}
+	if _, ok := metadata["format"]; !ok {
+		metadata["format"] = tileset.TileFormatString()
+	}
+	items, err := metadataItems(metadata)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "metadata.json"), data, 0644); err != nil {
+		return 0, err
+	}
+	if tileset.scheme == TMS {
+		if err := ioutil.WriteFile(filepath.Join(dir, "tilemapresource.xml"), []byte(tileMapResource), 0644); err != nil {
+			return 0, err
+		}
+	}
+
+	it, err := tileset.Tiles(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	ext := tileExt(tileset.tileformat)
+	var column string // the last created column directory
+	n := 0
+	for it.Next() {
+		t := it.Tile()
+		c := filepath.Join(dir, fmt.Sprint(t.Z), fmt.Sprint(t.X))
+		if c != column {
+			if err := os.MkdirAll(c, 0755); err != nil {
+				return n, err
+			}
+			column = c
+		}
+		if err := ioutil.WriteFile(filepath.Join(c, fmt.Sprint(t.Y)+ext), t.Data, 0644); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
@@ -0,0 +1,53 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	n, err := db.Export(context.Background(), dir, TileFilter{Zooms: []uint8{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 exported tiles, got %d", n)
+	}
+
+	exported, err := NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exported.Close()
+	metadata, err := exported.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "Geography Class" || metadata["minzoom"] != 1 || metadata["maxzoom"] != 1 {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+	var expected, data []byte
+	if err := db.ReadTile(1, 0, 1, &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := exported.ReadTile(1, 0, 1, &data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Error("exported tile differs")
+	}
+}
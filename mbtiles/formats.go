@@ -0,0 +1,175 @@
+package mbtiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+type TileFormat uint8
+
+const (
+	UNKNOWN TileFormat = iota
+	GZIP               // encoding = gzip
+	ZLIB               // encoding = deflate
+	PNG
+	JPG
+	PBF
+	WEBP
+	AVIF
+)
+
+// numBuiltinFormats is the number of TileFormats defined by this package.
+const numBuiltinFormats = AVIF + 1
+
+func (t TileFormat) String() string {
+	switch t {
+	case PNG:
+		return "png"
+	case JPG:
+		return "jpg"
+	case PBF:
+		return "pbf"
+	case WEBP:
+		return "webp"
+	case AVIF:
+		return "avif"
+	default:
+		if f, ok := registeredFormats[t]; ok {
+			return f.name
+		}
+		return ""
+	}
+}
+
+// formatFromName returns the TileFormat whose String method returns name.
+func formatFromName(name string) (TileFormat, bool) {
+	for f := PNG; f < numBuiltinFormats+TileFormat(len(registeredFormats)); f++ {
+		if name != "" && f.String() == name {
+			return f, true
+		}
+	}
+	return UNKNOWN, false
+}
+
+func (t TileFormat) ContentType() string {
+	switch t {
+	case PNG:
+		return "image/png"
+	case JPG:
+		return "image/jpeg"
+	case PBF:
+		return "application/x-protobuf" // Content-Encoding header must match the tile compression
+	case WEBP:
+		return "image/webp"
+	case AVIF:
+		return "image/avif"
+	default:
+		if f, ok := registeredFormats[t]; ok {
+			return f.contentType
+		}
+		return ""
+	}
+}
+
+// signature detects a TileFormat from the first bytes of a tile.
+type signature struct {
+	format TileFormat
+	match  func(data []byte) bool
+}
+
+// prefix returns a function that reports whether data starts with p.
+func prefix(p string) func([]byte) bool {
+	return func(data []byte) bool {
+		return bytes.HasPrefix(data, []byte(p))
+	}
+}
+
+// builtinSignatures are tried in order after the registered signatures.
+var builtinSignatures = []signature{
+	{GZIP, prefix("\x1f\x8b")}, // this masks PBF format too
+	{ZLIB, prefix("\x78\x9c")},
+	{PNG, prefix("\x89\x50\x4E\x47\x0D\x0A\x1A\x0A")},
+	{JPG, prefix("\xFF\xD8\xFF")},
+	{WEBP, isWEBP},
+	{AVIF, isAVIF},
+	{PBF, isMVT}, // this is a heuristic, so it must come last
+}
+
+type registeredFormat struct {
+	name        string
+	contentType string
+}
+
+var (
+	registeredFormats    = make(map[TileFormat]registeredFormat)
+	registeredSignatures []signature
+)
+
+// RegisterTileFormat adds a new TileFormat with the given name and content
+// type, which are returned by its String and ContentType methods. If match is
+// non-nil, it is registered as signature for the new format like with
+// RegisterSignature.
+// RegisterTileFormat is not safe for concurrent use and is supposed to be
+// called from an init function.
+func RegisterTileFormat(name, contentType string, match func(data []byte) bool) TileFormat {
+	f := numBuiltinFormats + TileFormat(len(registeredFormats))
+	registeredFormats[f] = registeredFormat{name, contentType}
+	if match != nil {
+		RegisterSignature(f, match)
+	}
+	return f
+}
+
+// RegisterSignature registers the function match that reports whether the
+// tile data is of format f. Registered signatures are tried in the order of
+// their registration before the built-in signatures, so they may be used to
+// override the detection of built-in formats.
+// RegisterSignature is not safe for concurrent use and is supposed to be
+// called from an init function.
+func RegisterSignature(f TileFormat, match func(data []byte) bool) {
+	registeredSignatures = append(registeredSignatures, signature{f, match})
+}
+
+// isWEBP reports whether data starts with a RIFF header of a WebP image. The
+// four bytes between "RIFF" and "WEBP" contain the file size and are ignored.
+func isWEBP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// isAVIF reports whether data starts with an ISO BMFF file type box with an
+// AVIF image or image sequence brand.
+func isAVIF(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	brand := string(data[8:12])
+	return brand == "avif" || brand == "avis"
+}
+
+// isMVT reports whether data looks like an uncompressed Mapbox Vector Tile,
+// i.e. starts with a length-delimited layers field (field number 3) whose
+// length does not exceed the data.
+func isMVT(data []byte) bool {
+	if len(data) < 2 || data[0] != 0x1a {
+		return false
+	}
+	l, n := binary.Uvarint(data[1:])
+	return n > 0 && l > 0 && l <= uint64(len(data)-1-n)
+}
+
+// Inpsect first few bytes of byte array to determine tile format
+// PBF tile format does not have a distinct signature if compressed, it will
+// be returned as GZIP or ZLIB, and it is up to caller to determine that it is
+// a PBF format
+func detectTileFormat(data *[]byte) (TileFormat, error) {
+	for _, sigs := range [][]signature{registeredSignatures, builtinSignatures} {
+		for _, sig := range sigs {
+			if sig.match(*data) {
+				return sig.format, nil
+			}
+		}
+	}
+
+	return UNKNOWN, errors.New("Could not detect tile format")
+}
@@ -0,0 +1,254 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterTileStore(".gpkg", openGeoPackage)
+}
+
+// webMercatorExtent is half the width of the web mercator projection in
+// meters.
+const webMercatorExtent = 20037508.342789244
+
+// gpkgLevel is a zoom level of a GeoPackage tile matrix that is aligned to
+// the web mercator tile grid.
+type gpkgLevel struct {
+	level         int64  // zoom_level of the tile matrix
+	offsetX       uint64 // column of the first tile of the tile matrix
+	offsetY       uint64 // row of the first tile, counted from the north
+	width, height uint64 // size of the tile matrix in tiles
+}
+
+// geoPackage is the TileStore of the first tile pyramid of a GeoPackage file
+// in the web mercator projection (EPSG:3857). Zoom levels of the tile matrix
+// set that do not match the web mercator tile grid are ignored.
+type geoPackage struct {
+	db       *sql.DB
+	table    string // quoted name of the tile pyramid user data table
+	metadata map[string]interface{}
+	levels   map[uint8]gpkgLevel // by web mercator zoom level
+	tileStmt *sql.Stmt
+}
+
+// quoteIdentifier quotes the SQL identifier name.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// mercatorToLonLat returns the longitude and latitude of the web mercator
+// coordinates x, y.
+func mercatorToLonLat(x, y float64) (float64, float64) {
+	lon := x / webMercatorExtent * 180
+	lat := math.Atan(math.Sinh(y/webMercatorExtent*math.Pi)) * 180 / math.Pi
+	return lon, lat
+}
+
+// openGeoPackage opens the GeoPackage file filename.
+func openGeoPackage(filename string) (TileStore, error) {
+	db, err := sql.Open(sqliteDSN("file:"+uriEscaper.Replace(filename)+"?mode=ro", []string{"query_only = 1"}))
+	if err != nil {
+		return nil, err
+	}
+	g, err := newGeoPackage(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open %s: %v", filename, err)
+	}
+	return g, nil
+}
+
+func newGeoPackage(db *sql.DB) (*geoPackage, error) {
+	var (
+		table, organization     string
+		identifier, description sql.NullString
+		srsID                   int
+		minX, minY, maxX, maxY  float64
+	)
+	err := db.QueryRow(`SELECT c.table_name, c.identifier, c.description, s.organization, s.organization_coordsys_id,
+		m.min_x, m.min_y, m.max_x, m.max_y
+		FROM gpkg_contents c
+		JOIN gpkg_tile_matrix_set m ON m.table_name = c.table_name
+		JOIN gpkg_spatial_ref_sys s ON s.srs_id = m.srs_id
+		WHERE c.data_type = 'tiles' ORDER BY c.table_name LIMIT 1`).Scan(
+		&table, &identifier, &description, &organization, &srsID, &minX, &minY, &maxX, &maxY)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no tile pyramid found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(organization, "EPSG") || srsID != 3857 {
+		return nil, fmt.Errorf("unsupported spatial reference system %s:%d of %s, only EPSG:3857 is supported", organization, srsID, table)
+	}
+
+	g := &geoPackage{
+		db:     db,
+		table:  quoteIdentifier(table),
+		levels: make(map[uint8]gpkgLevel),
+	}
+	rows, err := db.Query("SELECT zoom_level, matrix_width, matrix_height FROM gpkg_tile_matrix WHERE table_name = ?", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var zooms []int
+	for rows.Next() {
+		var l gpkgLevel
+		if err := rows.Scan(&l.level, &l.width, &l.height); err != nil {
+			return nil, err
+		}
+		if l.width == 0 || l.height == 0 {
+			continue
+		}
+		// the size of the tiles must be that of a web mercator zoom level,
+		// and their corners must be on the web mercator tile grid
+		size := (maxX - minX) / float64(l.width)
+		z := math.Floor(math.Log2(2*webMercatorExtent/size) + 0.5)
+		if z < 0 || z > 30 || math.Abs((maxY-minY)/float64(l.height)-size) > size*1e-6 {
+			continue
+		}
+		size = 2 * webMercatorExtent / math.Exp2(z)
+		x := (minX + webMercatorExtent) / size
+		y := (webMercatorExtent - maxY) / size
+		if x < -1e-6 || y < -1e-6 || math.Abs(x-math.Floor(x+0.5)) > 1e-6 || math.Abs(y-math.Floor(y+0.5)) > 1e-6 {
+			continue
+		}
+		l.offsetX, l.offsetY = uint64(x+0.5), uint64(y+0.5)
+		g.levels[uint8(z)] = l
+		zooms = append(zooms, int(z))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(zooms) == 0 {
+		return nil, fmt.Errorf("tile matrix set of %s does not match the web mercator tile grid", table)
+	}
+	sort.Ints(zooms)
+
+	minLon, minLat := mercatorToLonLat(minX, minY)
+	maxLon, maxLat := mercatorToLonLat(maxX, maxY)
+	g.metadata = map[string]interface{}{
+		"name":    table,
+		"minzoom": zooms[0],
+		"maxzoom": zooms[len(zooms)-1],
+		"bounds":  []float64{minLon, minLat, maxLon, maxLat},
+	}
+	if identifier.Valid && identifier.String != "" {
+		g.metadata["name"] = identifier.String
+	}
+	if description.Valid && description.String != "" {
+		g.metadata["description"] = description.String
+	}
+
+	g.tileStmt, err = db.Prepare("SELECT tile_data FROM " + g.table + " WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?")
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *geoPackage) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	l, ok := g.levels[z]
+	if !ok {
+		return ErrTileNotFound
+	}
+	y = flipRow(z, y)
+	if x < l.offsetX || y < l.offsetY || x-l.offsetX >= l.width || y-l.offsetY >= l.height {
+		return ErrTileNotFound
+	}
+	err := g.tileStmt.QueryRowContext(ctx, l.level, x-l.offsetX, y-l.offsetY).Scan(data)
+	if err == sql.ErrNoRows {
+		return ErrTileNotFound
+	}
+	return err
+}
+
+func (g *geoPackage) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(g.metadata))
+	for k, v := range g.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+func (g *geoPackage) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	if len(zooms) == 0 {
+		for z := range g.levels {
+			zooms = append(zooms, z)
+		}
+	}
+	byLevel := make(map[int64]uint8)
+	var levels []string
+	for _, z := range zooms {
+		if l, ok := g.levels[z]; ok {
+			byLevel[l.level] = z
+			levels = append(levels, fmt.Sprint(l.level))
+		}
+	}
+	if len(levels) == 0 {
+		return &gpkgTiles{}
+	}
+	rows, err := g.db.QueryContext(ctx, "SELECT zoom_level, tile_column, tile_row, tile_data FROM "+g.table+
+		" WHERE zoom_level IN ("+strings.Join(levels, ",")+") ORDER BY zoom_level")
+	return &gpkgTiles{g: g, rows: rows, zooms: byLevel, err: err}
+}
+
+func (g *geoPackage) Close() error {
+	g.tileStmt.Close()
+	return g.db.Close()
+}
+
+// gpkgTiles is the TileSource of a geoPackage.
+type gpkgTiles struct {
+	g     *geoPackage
+	rows  *sql.Rows
+	zooms map[int64]uint8 // web mercator zoom levels by zoom_level
+	tile  Tile
+	err   error
+}
+
+func (it *gpkgTiles) Next() bool {
+	if it.err != nil || it.rows == nil {
+		return false
+	}
+	for it.rows.Next() {
+		var level int64
+		var x, y uint64
+		var data []byte
+		if err := it.rows.Scan(&level, &x, &y, &data); err != nil {
+			it.err = err
+			return false
+		}
+		z := it.zooms[level]
+		l := it.g.levels[z]
+		if x >= l.width || y >= l.height {
+			continue
+		}
+		it.tile = Tile{Z: z, X: x + l.offsetX, Y: flipRow(z, y+l.offsetY), Data: data}
+		return true
+	}
+	it.err = it.rows.Err()
+	return false
+}
+
+func (it *gpkgTiles) Tile() Tile {
+	return it.tile
+}
+
+func (it *gpkgTiles) Err() error {
+	return it.err
+}
+
+func (it *gpkgTiles) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Close()
+}
@@ -0,0 +1,44 @@
+package mbtiles
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// createTestGeoPackage creates a new GeoPackage file in a temporary directory
+// with a tile pyramid of the north eastern quadrant of the world, whose zoom
+// levels 0 and 1 correspond to the web mercator zoom levels 1 and 2, and
+// returns its path. The tiles are keyed by their web mercator zoom level,
+// column and row, which must be in the quadrant.
+func createTestGeoPackage(t *testing.T, tiles map[[3]uint64][]byte) string {
+	filename := testFilename(t, "test.gpkg")
+	db, err := sql.Open(sqliteDriver, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stmts := []string{
+		"CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT, srs_id INTEGER PRIMARY KEY, organization TEXT, organization_coordsys_id INTEGER, definition TEXT)",
+		"INSERT INTO gpkg_spatial_ref_sys VALUES ('WGS 84 / Pseudo-Mercator', 3857, 'EPSG', 3857, '')",
+		"CREATE TABLE gpkg_contents (table_name TEXT PRIMARY KEY, data_type TEXT, identifier TEXT, description TEXT, srs_id INTEGER)",
+		"INSERT INTO gpkg_contents VALUES ('quadrant', 'tiles', 'Quadrant', 'The north east', 3857)",
+		"CREATE TABLE gpkg_tile_matrix_set (table_name TEXT PRIMARY KEY, srs_id INTEGER, min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE)",
+		"INSERT INTO gpkg_tile_matrix_set VALUES ('quadrant', 3857, 0, 0, 20037508.342789244, 20037508.342789244)",
+		"CREATE TABLE gpkg_tile_matrix (table_name TEXT, zoom_level INTEGER, matrix_width INTEGER, matrix_height INTEGER, tile_width INTEGER, tile_height INTEGER, pixel_x_size DOUBLE, pixel_y_size DOUBLE)",
+		"INSERT INTO gpkg_tile_matrix VALUES ('quadrant', 0, 1, 1, 256, 256, 78271.517, 78271.517), ('quadrant', 1, 2, 2, 256, 256, 39135.758, 39135.758)",
+		"CREATE TABLE quadrant (id INTEGER PRIMARY KEY, zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)",
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for c, data := range tiles {
+		// the rows are counted from the north, like those of the GeoPackage
+		z, x, y := c[0]-1, c[1]-1<<(c[0]-1), c[2]
+		if _, err := db.Exec("INSERT INTO quadrant (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)", z, x, y, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filename
+}
@@ -0,0 +1,70 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ping verifies that the mbtiles file still exists and that its tiles can be
+// queried. The DB of a TileStore that implements Pinger is verified by it.
+func (tileset *DB) Ping(ctx context.Context) error {
+	if p, ok := tileset.store.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	if tileset.external {
+		return nil
+	}
+	if _, err := os.Stat(tileset.filename); err != nil && tileset.remote == nil {
+		return err
+	}
+	if tileset.store != nil {
+		return nil
+	}
+	var one int
+	err := tileset.db.QueryRowContext(ctx, "select 1 from tiles limit 1").Scan(&one)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// QuickCheck runs the quick_check pragma of SQLite, which verifies the
+// integrity of the database file except for the consistency of the indices.
+// Its duration is proportional to the size of the file.
+// TileStores are not checked.
+func (tileset *DB) QuickCheck(ctx context.Context) error {
+	if tileset.store != nil {
+		return nil
+	}
+	return quickCheck(ctx, tileset.db)
+}
+
+// quickCheck runs the quick_check pragma on db and returns an error that
+// lists the reported problems, if any.
+func quickCheck(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA quick_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
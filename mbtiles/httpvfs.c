@@ -0,0 +1,188 @@
+// +build !purego
+
+// The httpvfs SQLite VFS reads database files from HTTP servers with range
+// requests. The requests are made by the exported Go functions in
+// httpvfs.go. It only supports reading immutable main database files.
+
+#include <string.h>
+#include "sqlite3-binding.h"
+#include "_cgo_export.h"
+
+typedef struct httpFile {
+	sqlite3_file base;
+	GoUintptr handle;
+} httpFile;
+
+static int httpClose(sqlite3_file *f) {
+	goHTTPClose(((httpFile *)f)->handle);
+	return SQLITE_OK;
+}
+
+static int httpRead(sqlite3_file *f, void *buf, int n, sqlite3_int64 off) {
+	GoInt got = goHTTPRead(((httpFile *)f)->handle, buf, n, off);
+	if (got < 0) {
+		return SQLITE_IOERR_READ;
+	}
+	if (got < n) {
+		// SQLite requires the rest of the buffer to be zeroed
+		memset((char *)buf + got, 0, n - got);
+		return SQLITE_IOERR_SHORT_READ;
+	}
+	return SQLITE_OK;
+}
+
+static int httpWrite(sqlite3_file *f, const void *buf, int n, sqlite3_int64 off) {
+	return SQLITE_READONLY;
+}
+
+static int httpTruncate(sqlite3_file *f, sqlite3_int64 size) {
+	return SQLITE_READONLY;
+}
+
+static int httpSync(sqlite3_file *f, int flags) {
+	return SQLITE_OK;
+}
+
+static int httpFileSize(sqlite3_file *f, sqlite3_int64 *size) {
+	*size = goHTTPSize(((httpFile *)f)->handle);
+	return SQLITE_OK;
+}
+
+static int httpLock(sqlite3_file *f, int lock) {
+	return SQLITE_OK;
+}
+
+static int httpCheckReservedLock(sqlite3_file *f, int *out) {
+	*out = 0;
+	return SQLITE_OK;
+}
+
+static int httpFileControl(sqlite3_file *f, int op, void *arg) {
+	return SQLITE_NOTFOUND;
+}
+
+static int httpSectorSize(sqlite3_file *f) {
+	return 0;
+}
+
+static int httpDeviceCharacteristics(sqlite3_file *f) {
+	return SQLITE_IOCAP_IMMUTABLE;
+}
+
+static const sqlite3_io_methods httpIOMethods = {
+	1,
+	httpClose,
+	httpRead,
+	httpWrite,
+	httpTruncate,
+	httpSync,
+	httpFileSize,
+	httpLock,
+	httpLock,
+	httpCheckReservedLock,
+	httpFileControl,
+	httpSectorSize,
+	httpDeviceCharacteristics,
+};
+
+static int httpOpen(sqlite3_vfs *vfs, const char *name, sqlite3_file *f, int flags, int *outFlags) {
+	httpFile *p = (httpFile *)f;
+	p->base.pMethods = NULL;
+	// there are no journals, as the database is never written
+	if (name == NULL || !(flags & SQLITE_OPEN_MAIN_DB)) {
+		return SQLITE_CANTOPEN;
+	}
+	p->handle = goHTTPOpen((char *)name);
+	if (p->handle == 0) {
+		return SQLITE_CANTOPEN;
+	}
+	p->base.pMethods = &httpIOMethods;
+	if (outFlags) {
+		*outFlags = SQLITE_OPEN_READONLY | SQLITE_OPEN_MAIN_DB;
+	}
+	return SQLITE_OK;
+}
+
+static int httpDelete(sqlite3_vfs *vfs, const char *name, int syncDir) {
+	return SQLITE_READONLY;
+}
+
+static int httpAccess(sqlite3_vfs *vfs, const char *name, int flags, int *out) {
+	// only the main database exists
+	*out = 0;
+	return SQLITE_OK;
+}
+
+static int httpFullPathname(sqlite3_vfs *vfs, const char *name, int n, char *out) {
+	sqlite3_snprintf(n, out, "%s", name);
+	return SQLITE_OK;
+}
+
+// The other methods are those of the default VFS.
+
+static void *httpDlOpen(sqlite3_vfs *vfs, const char *name) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xDlOpen(d, name);
+}
+
+static void httpDlError(sqlite3_vfs *vfs, int n, char *msg) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	d->xDlError(d, n, msg);
+}
+
+static void (*httpDlSym(sqlite3_vfs *vfs, void *h, const char *sym))(void) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xDlSym(d, h, sym);
+}
+
+static void httpDlClose(sqlite3_vfs *vfs, void *h) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	d->xDlClose(d, h);
+}
+
+static int httpRandomness(sqlite3_vfs *vfs, int n, char *out) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xRandomness(d, n, out);
+}
+
+static int httpSleep(sqlite3_vfs *vfs, int micros) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xSleep(d, micros);
+}
+
+static int httpCurrentTime(sqlite3_vfs *vfs, double *out) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xCurrentTime(d, out);
+}
+
+static int httpGetLastError(sqlite3_vfs *vfs, int n, char *out) {
+	sqlite3_vfs *d = (sqlite3_vfs *)vfs->pAppData;
+	return d->xGetLastError(d, n, out);
+}
+
+static sqlite3_vfs httpVFS;
+
+int registerHTTPVFS(const char *name) {
+	sqlite3_vfs *d = sqlite3_vfs_find(NULL);
+	if (d == NULL) {
+		return SQLITE_ERROR;
+	}
+	httpVFS.iVersion = 1;
+	httpVFS.szOsFile = sizeof(httpFile);
+	httpVFS.mxPathname = d->mxPathname > 4096 ? d->mxPathname : 4096;
+	httpVFS.zName = name;
+	httpVFS.pAppData = d;
+	httpVFS.xOpen = httpOpen;
+	httpVFS.xDelete = httpDelete;
+	httpVFS.xAccess = httpAccess;
+	httpVFS.xFullPathname = httpFullPathname;
+	httpVFS.xDlOpen = httpDlOpen;
+	httpVFS.xDlError = httpDlError;
+	httpVFS.xDlSym = httpDlSym;
+	httpVFS.xDlClose = httpDlClose;
+	httpVFS.xRandomness = httpRandomness;
+	httpVFS.xSleep = httpSleep;
+	httpVFS.xCurrentTime = httpCurrentTime;
+	httpVFS.xGetLastError = httpGetLastError;
+	return sqlite3_vfs_register(&httpVFS, 0);
+}
@@ -0,0 +1,189 @@
+package mbtiles
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/golang/groupcache/singleflight"
+)
+
+// httpVFS is the name of the SQLite VFS that reads mbtiles files from HTTP
+// servers.
+const httpVFS = "mbtiles_http"
+
+// pageSize is the size of the blocks in which remote files are read and
+// cached. Reading more than one SQLite page at a time saves requests for
+// B-tree pages that are close to each other.
+const pageSize = 64 << 10
+
+// DefaultPageCacheSize is the size in megabytes of the page cache of a remote
+// mbtiles file, unless it is set with PageCacheSize.
+const DefaultPageCacheSize = 64
+
+// IsRemote reports whether filename is the URL of an mbtiles file on an HTTP
+// server, which NewDB reads with range requests.
+func IsRemote(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// remoteFile is an mbtiles file on an HTTP server, which is shared by all
+// connections of the DB that opened it. Its pages are read with HTTP range
+// requests into a LRU cache, concurrent reads of the same page are coalesced
+// into one request.
+type remoteFile struct {
+	url      string
+	client   *http.Client
+	size     int64
+	modified time.Time
+	etag     string
+	group    singleflight.Group
+
+	mu    sync.Mutex
+	pages *lru.Cache
+	refs  int
+}
+
+var (
+	remoteMu    sync.Mutex
+	remoteFiles = make(map[string]*remoteFile)
+	// remoteHandles are the remote files of the open sqlite3_files by
+	// handle, as C code must not hold Go pointers.
+	remoteHandles = make(map[uintptr]*remoteFile)
+	nextHandle    uintptr
+)
+
+// openRemote returns the remoteFile of url, which is requested with client
+// if it is not yet open, and takes a reference to it.
+func openRemote(url string, client *http.Client, cacheSize int64) (*remoteFile, error) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if f, ok := remoteFiles[url]; ok {
+		f.refs++
+		return f, nil
+	}
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot open %s: %s", url, resp.Status)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, fmt.Errorf("cannot open %s: server does not support range requests", url)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("cannot open %s: unknown size", url)
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Now()
+	}
+	maxPages := int(cacheSize << 20 / pageSize)
+	if maxPages < 1 {
+		maxPages = 1
+	}
+	f := &remoteFile{
+		url:      url,
+		client:   client,
+		size:     resp.ContentLength,
+		modified: modified,
+		etag:     resp.Header.Get("ETag"),
+		pages:    lru.New(maxPages),
+		refs:     1,
+	}
+	remoteFiles[url] = f
+	return f, nil
+}
+
+// release releases a reference to the remote file, which is forgotten once
+// it is no longer referenced.
+func (f *remoteFile) release() {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if f.refs--; f.refs == 0 {
+		delete(remoteFiles, f.url)
+	}
+}
+
+// page returns the page with index i.
+func (f *remoteFile) page(i int64) ([]byte, error) {
+	f.mu.Lock()
+	p, ok := f.pages.Get(i)
+	f.mu.Unlock()
+	if ok {
+		return p.([]byte), nil
+	}
+	v, err := f.group.Do(fmt.Sprint(i), func() (interface{}, error) {
+		data, err := f.fetch(i*pageSize, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.pages.Add(i, data)
+		f.mu.Unlock()
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// fetch reads up to n bytes at offset off with a range request.
+func (f *remoteFile) fetch(off, n int64) ([]byte, error) {
+	if off+n > f.size {
+		n = f.size - off
+	}
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if f.etag != "" {
+		// fail instead of mixing pages of different versions of the file
+		req.Header.Set("If-Match", f.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("cannot read %s: %s", f.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != n {
+		return nil, fmt.Errorf("cannot read %s: expected %d bytes, got %d", f.url, n, len(data))
+	}
+	return data, nil
+}
+
+// readAt reads into buf from offset off and returns the number of bytes
+// read, which is less than len(buf) at the end of the file.
+func (f *remoteFile) readAt(buf []byte, off int64) (int, error) {
+	n := 0
+	for n < len(buf) && off < f.size {
+		p, err := f.page(off / pageSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(buf[n:], p[off%pageSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
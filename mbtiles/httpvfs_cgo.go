@@ -0,0 +1,88 @@
+//go:build !purego
+// +build !purego
+
+package mbtiles
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../vendor/github.com/mattn/go-sqlite3
+#include "sqlite3-binding.h"
+
+int registerHTTPVFS(const char *name);
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var registerOnce sync.Once
+
+// registerHTTPVFS registers the httpVFS with SQLite, once.
+func registerHTTPVFS() error {
+	var rc C.int
+	registerOnce.Do(func() {
+		name := C.CString(httpVFS) // must remain valid while the VFS is registered
+		rc = C.registerHTTPVFS(name)
+	})
+	if rc != 0 {
+		return fmt.Errorf("cannot register SQLite VFS %s: error %d", httpVFS, rc)
+	}
+	return nil
+}
+
+// remoteHandle returns the remote file of the handle h.
+func remoteHandle(h uintptr) *remoteFile {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	return remoteHandles[h]
+}
+
+//export goHTTPOpen
+func goHTTPOpen(name *C.char) uintptr {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	// the file has been opened by NewDB, which holds a reference to it
+	f, ok := remoteFiles[C.GoString(name)]
+	if !ok {
+		return 0
+	}
+	f.refs++
+	nextHandle++
+	remoteHandles[nextHandle] = f
+	return nextHandle
+}
+
+//export goHTTPClose
+func goHTTPClose(h uintptr) {
+	f := remoteHandle(h)
+	remoteMu.Lock()
+	delete(remoteHandles, h)
+	remoteMu.Unlock()
+	if f != nil {
+		f.release()
+	}
+}
+
+//export goHTTPRead
+func goHTTPRead(h uintptr, buf unsafe.Pointer, n C.int, off C.sqlite3_int64) int {
+	f := remoteHandle(h)
+	if f == nil {
+		return -1
+	}
+	got, err := f.readAt((*[1 << 30]byte)(buf)[:n:n], int64(off))
+	if err != nil {
+		return -1
+	}
+	return got
+}
+
+//export goHTTPSize
+func goHTTPSize(h uintptr) C.sqlite3_int64 {
+	f := remoteHandle(h)
+	if f == nil {
+		return 0
+	}
+	return C.sqlite3_int64(f.size)
+}
@@ -0,0 +1,12 @@
+//go:build purego
+// +build purego
+
+package mbtiles
+
+import "fmt"
+
+// registerHTTPVFS fails, as the VFS is implemented in C for the SQLite of the
+// sqlite3 driver.
+func registerHTTPVFS() error {
+	return fmt.Errorf("mbtiles files on HTTP servers cannot be read by builds with the purego tag")
+}
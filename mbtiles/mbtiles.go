@@ -4,87 +4,121 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-type TileFormat uint8
-
-const (
-	UNKNOWN TileFormat = iota
-	GZIP               // encoding = gzip
-	ZLIB               // encoding = deflate
-	PNG
-	JPG
-	PBF
-	WEBP
+var (
+	// ErrTileNotFound is returned by ReadTile if the tileset does not
+	// contain a tile at the requested coordinates.
+	ErrTileNotFound = errors.New("tile not found")
+	// ErrGridNotFound is returned by ReadGrid if the tileset does not
+	// contain a UTF grid at the requested coordinates.
+	ErrGridNotFound = errors.New("grid not found")
 )
 
-func (t TileFormat) String() string {
-	switch t {
-	case PNG:
-		return "png"
-	case JPG:
-		return "jpg"
-	case PBF:
-		return "pbf"
-	case WEBP:
-		return "webp"
-	default:
-		return ""
-	}
-}
-
-func (t TileFormat) ContentType() string {
-	switch t {
-	case PNG:
-		return "image/png"
-	case JPG:
-		return "image/jpeg"
-	case PBF:
-		return "application/x-protobuf" // Content-Encoding header must be gzip
-	case WEBP:
-		return "image/webp"
-	default:
-		return ""
-	}
-}
-
 type DB struct {
 	filename           string
 	db                 *sql.DB
-	tileformat         TileFormat // tile format: PNG, JPG, PBF
-	timestamp          time.Time  // timestamp of file, for cache control headers
+	tileformat         TileFormat   // tile format: PNG, JPG, PBF
+	tileencoding       TileEncoding // compression of the tiles, only expected for PBF
+	timestamp          time.Time    // timestamp of file, for cache control headers
 	hasUTFGrid         bool
 	utfgridCompression TileFormat
 	hasUTFGridData     bool
+	tileStmt           *sql.Stmt // prepared statements for the hot paths
+	hasTileStmt        *sql.Stmt
+	tileIDStmt         *sql.Stmt // nil unless deduplicated
+	gridStmt           *sql.Stmt
+	gridDataStmt       *sql.Stmt
+	cache              *TileCache   // optional, nil if caching is disabled
+	cacheID            uint64       // of the tile keys of the DB in cache
+	scheme             TileScheme   // scheme of the rows passed to and returned by the DB
+	remote             *remoteFile  // nil unless the file is on an HTTP server
+	store              TileStore    // nil unless the file is not an mbtiles file
+	external           bool         // the store is not backed by the file filename
+	dedup              *dedupLayout // nil unless tiles is a view of deduplicated tables
+	limits             queryLimits
 }
 
 // Creates a new DB instance.
 // Connection is closed by runtime on application termination or by calling .Close() method.
-func NewDB(filename string) (*DB, error) {
-	_, id := filepath.Split(filename)
-	id = strings.Split(id, ".")[0]
+// The behavior can be adjusted by supplying Options.
+// If filename is a directory of tiles, see IsTileDir, its tiles are served
+// like those of an mbtiles file.
+// If filename is an http or https URL, the file is read with range requests
+// and is always opened as with ReadOnly.
+func NewDB(filename string, opts ...Option) (*DB, error) {
+	o := options{
+		client:    http.DefaultClient,
+		pageCache: DefaultPageCacheSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if open, ok := tileStores[tilesetExt(filename)]; ok {
+		return newStoreDB(filename, open, o)
+	}
+	if !IsRemote(filename) {
+		if info, err := os.Stat(filename); err == nil && info.IsDir() {
+			return newStoreDB(filename, openTileDir, o)
+		}
+	}
 
-	db, err := sql.Open("sqlite3", filename)
+	var remote *remoteFile
+	var modTime time.Time
+	if IsRemote(filename) {
+		if err := registerHTTPVFS(); err != nil {
+			return nil, err
+		}
+		var err error
+		remote, err = openRemote(filename, o.client, o.pageCache)
+		if err != nil {
+			return nil, err
+		}
+		modTime = remote.modified
+	} else {
+		var err error
+		modTime, err = fileTimestamp(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var db *sql.DB
+	opened := false
+	defer func() {
+		if opened {
+			return
+		}
+		// the database must be closed before the remote file it reads
+		if db != nil {
+			db.Close()
+		}
+		if remote != nil {
+			remote.release()
+		}
+	}()
+
+	db, err := sql.Open(o.driverAndDSN(filename))
 	if err != nil {
 		return nil, err
 	}
+	o.configurePool(db)
 
-	//Saves last modified mbtiles time for setting Last-Modified header
-	fileStat, err := os.Stat(filename)
-	if err != nil {
-		return nil, fmt.Errorf("could not read file stats for mbtiles file: %s\n", filename)
+	if o.check {
+		if err := quickCheck(context.Background(), db); err != nil {
+			return nil, err
+		}
 	}
 
 	//query a sample tile to determine format
@@ -93,17 +127,35 @@ func NewDB(filename string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	tileformat, err := detectTileFormat(&data)
+	// compression masks PBF, which is the only expected type for
+	// compressed tiles
+	tileformat := PBF
+	tileencoding, err := readEncodingMetadata(db)
 	if err != nil {
 		return nil, err
 	}
-	if tileformat == GZIP {
-		tileformat = PBF // GZIP masks PBF, which is only expected type for tiles in GZIP format
+	if tileencoding == IDENTITY {
+		tileencoding = detectTileEncoding(data)
+	}
+	if tileencoding == IDENTITY {
+		tileformat, err = detectTileFormat(&data)
+		if err != nil {
+			return nil, err
+		}
 	}
 	out := DB{
-		db:         db,
-		tileformat: tileformat,
-		timestamp:  fileStat.ModTime().Round(time.Second), // round to nearest second
+		filename:     filename,
+		db:           db,
+		tileformat:   tileformat,
+		tileencoding: tileencoding,
+		timestamp:    modTime,
+		scheme:       o.scheme,
+		remote:       remote,
+		limits:       o.limits(),
+	}
+	out.dedup, err = detectDedupLayout(db)
+	if err != nil {
+		return nil, err
 	}
 
 	// UTFGrids
@@ -140,36 +192,199 @@ func NewDB(filename string) (*DB, error) {
 		}
 	}
 
+	err = out.prepareStatements()
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare statements: %v", err)
+	}
+	if c := o.tileCache(); c != nil {
+		out.cache, out.cacheID = c, c.register()
+	}
+
+	opened = true
 	return &out, nil
 
 }
 
-// Reads a grid at z, x, y into provided *[]byte.
-func (tileset *DB) ReadTile(z uint8, x uint64, y uint64, data *[]byte) error {
-	err := tileset.db.QueryRow("select tile_data from tiles where zoom_level = ? and tile_column = ? and tile_row = ?", z, x, y).Scan(data)
+// fileTimestamp returns the last modification time of the file filename,
+// rounded to seconds, for the Last-Modified header.
+func fileTimestamp(filename string) (time.Time, error) {
+	fileStat, err := os.Stat(filename)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			*data = nil // not a problem, just return empty bytes
+		return time.Time{}, fmt.Errorf("could not read file stats for mbtiles file: %s\n", filename)
+	}
+	return fileStat.ModTime().Round(time.Second), nil // round to nearest second
+}
+
+// Scheme returns the TileScheme of the rows passed to and returned by the DB.
+func (tileset *DB) Scheme() TileScheme {
+	return tileset.scheme
+}
+
+// tmsRow returns the row y, given in the scheme of the DB, in the TMS scheme
+// of the tiles table.
+func (tileset *DB) tmsRow(z uint8, y uint64) uint64 {
+	if tileset.scheme == TMS {
+		return y
+	}
+	return flipRow(z, y)
+}
+
+// Reads a tile at z, x, y into provided *[]byte. The row y is in the
+// TileScheme of the DB, see the Scheme option.
+// ErrTileNotFound is returned if there is no such tile.
+func (tileset *DB) ReadTile(z uint8, x uint64, y uint64, data *[]byte) error {
+	return tileset.ReadTileContext(context.Background(), z, x, y, data)
+}
+
+// ReadTileContext is like ReadTile, but the query is cancelled as soon as ctx
+// is done.
+// If the DB was opened with a CacheSize or Cache, the returned data may be shared with
+// the cache and must not be modified.
+func (tileset *DB) ReadTileContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) (err error) {
+	ctx, done := tileset.startQuery(ctx, "tile", z, x, y)
+	defer func() { err = done(err) }()
+	y = tileset.tmsRow(z, y)
+	k := tileKey{tileset.cacheID, z, x, y}
+	if tileset.cache != nil {
+		if cached, ok := tileset.cache.get(k); ok {
+			*data = cached
 			return nil
 		}
+	}
+	if tileset.store != nil {
+		err = tileset.store.ReadTile(ctx, z, x, y, data)
+	} else {
+		err = tileset.tileStmt.QueryRowContext(ctx, z, x, y).Scan(data)
+	}
+	if err != nil {
+		*data = nil
+		if err == sql.ErrNoRows || err == ErrTileNotFound {
+			return ErrTileNotFound
+		}
 		return err
 	}
+	if tileset.cache != nil {
+		tileset.cache.add(k, *data)
+	}
 	return nil
 }
 
+// ReadTileDecompressed is like ReadTile, but removes the TileEncoding from
+// the tile data, e.g. to obtain the plain protocol buffer of a gzip compressed
+// vector tile. ErrUnsupportedEncoding is returned if the tiles are compressed
+// with an encoding for which no Decoder is registered.
+func (tileset *DB) ReadTileDecompressed(z uint8, x uint64, y uint64, data *[]byte) error {
+	return tileset.ReadTileDecompressedContext(context.Background(), z, x, y, data)
+}
+
+// ReadTileDecompressedContext is like ReadTileDecompressed, but the query is
+// cancelled as soon as ctx is done.
+func (tileset *DB) ReadTileDecompressedContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) error {
+	err := tileset.ReadTileContext(ctx, z, x, y, data)
+	if err != nil {
+		return err
+	}
+	*data, err = tileset.tileencoding.Decode(*data)
+	return err
+}
+
+// IsDeduplicated returns whether the tiles table of the DB is a view of
+// tables in which the data of identical tiles is stored only once, like the
+// map and images tables of files written by mbutil, tilelive or a Writer with
+// the Deduplicate option, or the tiles_shallow and tiles_data tables of files
+// written by tippecanoe. The tiles of such a DB have IDs, see ReadTileID.
+func (tileset *DB) IsDeduplicated() bool {
+	return tileset.dedup != nil
+}
+
+// ReadTileID returns the ID of the tile data at z, x, y without reading the
+// tile data. Tiles with the same ID have the same data, so the ID can be
+// used, e.g., as an entity tag. ErrTileNotFound is returned if there is no
+// such tile, and an error if the DB is not deduplicated.
+func (tileset *DB) ReadTileID(z uint8, x uint64, y uint64) (string, error) {
+	return tileset.ReadTileIDContext(context.Background(), z, x, y)
+}
+
+// ReadTileIDContext is like ReadTileID, but the query is cancelled as soon as
+// ctx is done.
+func (tileset *DB) ReadTileIDContext(ctx context.Context, z uint8, x uint64, y uint64) (id string, err error) {
+	if tileset.dedup == nil {
+		return "", fmt.Errorf("tiles of %s have no IDs", tileset.filename)
+	}
+	ctx, done := tileset.startQuery(ctx, "tileid", z, x, y)
+	defer func() { err = done(err) }()
+	err = tileset.tileIDStmt.QueryRowContext(ctx, z, x, tileset.tmsRow(z, y)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", ErrTileNotFound
+	}
+	return id, err
+}
+
+// HasTile returns whether the DB contains a tile at z, x, y without reading
+// the tile data.
+func (tileset *DB) HasTile(z uint8, x uint64, y uint64) (bool, error) {
+	return tileset.HasTileContext(context.Background(), z, x, y)
+}
+
+// HasTileContext is like HasTile, but the query is cancelled as soon as ctx
+// is done.
+func (tileset *DB) HasTileContext(ctx context.Context, z uint8, x uint64, y uint64) (ok bool, err error) {
+	ctx, done := tileset.startQuery(ctx, "hastile", z, x, y)
+	defer func() { err = done(err) }()
+	y = tileset.tmsRow(z, y)
+	if tileset.cache != nil {
+		if _, ok := tileset.cache.get(tileKey{tileset.cacheID, z, x, y}); ok {
+			return true, nil
+		}
+	}
+	if tileset.store != nil {
+		var data []byte
+		err := tileset.store.ReadTile(ctx, z, x, y, &data)
+		if err == ErrTileNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	var one int
+	err = tileset.hasTileStmt.QueryRowContext(ctx, z, x, y).Scan(&one)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // Reads a grid at z, x, y into provided *[]byte.
 // This merges in grid key data, if any exist
 // The data is returned in the original compression encoding (zlib or gzip)
+// ErrGridNotFound is returned if there is no such grid.
 func (tileset *DB) ReadGrid(z uint8, x uint64, y uint64, data *[]byte) error {
+	return tileset.ReadGridContext(context.Background(), z, x, y, data)
+}
+
+// ReadGridContext is like ReadGrid, but the queries are cancelled as soon as
+// ctx is done.
+func (tileset *DB) ReadGridContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) (err error) {
 	if !tileset.hasUTFGrid {
 		return errors.New("Tileset does not contain UTFgrids")
 	}
+	ctx, done := tileset.startQuery(ctx, "grid", z, x, y)
+	defer func() { err = done(err) }()
+	y = tileset.tmsRow(z, y)
 
-	err := tileset.db.QueryRow("select grid from grids where zoom_level = ? and tile_column = ? and tile_row = ?", z, x, y).Scan(data)
+	if g, ok := tileset.store.(GridStore); ok {
+		if err = g.ReadGrid(ctx, z, x, y, data); err != nil {
+			*data = nil
+		}
+		return err
+	}
+	err = tileset.gridStmt.QueryRowContext(ctx, z, x, y).Scan(data)
 	if err != nil {
+		*data = nil
 		if err == sql.ErrNoRows {
-			*data = nil // not a problem, just return empty bytes
-			return nil
+			return ErrGridNotFound
 		}
 		return err
 	}
@@ -181,7 +396,7 @@ func (tileset *DB) ReadGrid(z uint8, x uint64, y uint64, data *[]byte) error {
 			value []byte
 		)
 
-		rows, err := tileset.db.Query("select key_name, key_json FROM grid_data where zoom_level = ? and tile_column = ? and tile_row = ?", z, x, y)
+		rows, err := tileset.gridDataStmt.QueryContext(ctx, z, x, y)
 		if err != nil {
 			return fmt.Errorf("cannot fetch grid data: %v", err)
 		}
@@ -244,15 +459,63 @@ func (tileset *DB) ReadGrid(z uint8, x uint64, y uint64, data *[]byte) error {
 	return nil
 }
 
+// ReadGridDecompressed is like ReadGrid, but returns the plain JSON of the
+// grid instead of its compressed form.
+func (tileset *DB) ReadGridDecompressed(z uint8, x uint64, y uint64, data *[]byte) error {
+	return tileset.ReadGridDecompressedContext(context.Background(), z, x, y, data)
+}
+
+// ReadGridDecompressedContext is like ReadGridDecompressed, but the queries
+// are cancelled as soon as ctx is done.
+func (tileset *DB) ReadGridDecompressedContext(ctx context.Context, z uint8, x uint64, y uint64, data *[]byte) error {
+	err := tileset.ReadGridContext(ctx, z, x, y, data)
+	if err != nil {
+		return err
+	}
+	*data, err = DecompressGrid(*data, tileset.utfgridCompression)
+	return err
+}
+
+// DecompressGrid returns the plain JSON of the UTF grid data, which is
+// compressed with c, either GZIP or ZLIB.
+func DecompressGrid(data []byte, c TileFormat) ([]byte, error) {
+	var zreader io.ReadCloser
+	var err error
+	if c == ZLIB {
+		zreader, err = zlib.NewReader(bytes.NewReader(data))
+	} else {
+		zreader, err = gzip.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress grid: %v", err)
+	}
+	defer zreader.Close()
+	if data, err = ioutil.ReadAll(zreader); err != nil {
+		return nil, fmt.Errorf("cannot decompress grid: %v", err)
+	}
+	return data, nil
+}
+
 // Read the metadata table into a map, casting their values into the appropriate type
 func (tileset *DB) ReadMetadata() (map[string]interface{}, error) {
+	return tileset.ReadMetadataContext(context.Background())
+}
+
+// ReadMetadataContext is like ReadMetadata, but the queries are cancelled as
+// soon as ctx is done.
+func (tileset *DB) ReadMetadataContext(ctx context.Context) (_ map[string]interface{}, err error) {
 	var (
 		key   string
 		value string
 	)
+	ctx, done := tileset.startQuery(ctx, "metadata", 0, 0, 0)
+	defer func() { err = done(err) }()
+	if tileset.store != nil {
+		return tileset.store.Metadata(ctx)
+	}
 	metadata := make(map[string]interface{})
 
-	rows, err := tileset.db.Query("select * from metadata where value is not ''")
+	rows, err := tileset.db.QueryContext(ctx, "select * from metadata where value is not ''")
 	if err != nil {
 		return nil, err
 	}
@@ -260,25 +523,8 @@ func (tileset *DB) ReadMetadata() (map[string]interface{}, error) {
 
 	for rows.Next() {
 		rows.Scan(&key, &value)
-
-		switch key {
-		case "maxzoom", "minzoom":
-			metadata[key], err = strconv.Atoi(value)
-			if err != nil {
-				return nil, fmt.Errorf("cannot read metadata item %s: %v", key, err)
-			}
-		case "bounds", "center":
-			metadata[key], err = stringToFloats(value)
-			if err != nil {
-				return nil, fmt.Errorf("cannot read metadata item %s: %v", key, err)
-			}
-		case "json":
-			err = json.Unmarshal([]byte(value), &metadata)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse JSON metadata item: %v", err)
-			}
-		default:
-			metadata[key] = value
+		if err := parseMetadataItem(metadata, key, value); err != nil {
+			return nil, err
 		}
 	}
 
@@ -287,16 +533,47 @@ func (tileset *DB) ReadMetadata() (map[string]interface{}, error) {
 	_, hasMaxZoom := metadata["maxzoom"]
 	if !(hasMinZoom && hasMaxZoom) {
 		var minZoom, maxZoom int
-		err := tileset.db.QueryRow("select min(zoom_level), max(zoom_level) from tiles").Scan(&minZoom, &maxZoom)
+		err := tileset.db.QueryRowContext(ctx, "select min(zoom_level), max(zoom_level) from tiles").Scan(&minZoom, &maxZoom)
 		if err != nil {
 			return metadata, nil
 		}
 		metadata["minzoom"] = minZoom
 		metadata["maxzoom"] = maxZoom
 	}
+	if _, hasBounds := metadata["bounds"]; !hasBounds {
+		if bounds, err := tileset.ComputeBounds(); err == nil {
+			metadata["bounds"] = bounds
+		}
+	}
 	return metadata, nil
 }
 
+// parseMetadataItem adds the metadata item key of the metadata table with the
+// value to metadata, casting it into the appropriate type.
+func parseMetadataItem(metadata map[string]interface{}, key, value string) error {
+	var err error
+	switch key {
+	case "maxzoom", "minzoom":
+		metadata[key], err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("cannot read metadata item %s: %v", key, err)
+		}
+	case "bounds", "center":
+		metadata[key], err = stringToFloats(value)
+		if err != nil {
+			return fmt.Errorf("cannot read metadata item %s: %v", key, err)
+		}
+	case "json":
+		err = json.Unmarshal([]byte(value), &metadata)
+		if err != nil {
+			return fmt.Errorf("unable to parse JSON metadata item: %v", err)
+		}
+	default:
+		metadata[key] = value
+	}
+	return nil
+}
+
 // TileFormatreturns the TileFormat of the DB.
 func (d DB) TileFormat() TileFormat {
 	return d.tileformat
@@ -312,6 +589,12 @@ func (d DB) ContentType() string {
 	return d.tileformat.ContentType()
 }
 
+// TileEncoding returns the compression of the tiles in the DB. Only PBF tiles
+// are expected to be compressed.
+func (d DB) TileEncoding() TileEncoding {
+	return d.tileencoding
+}
+
 // HasUTFGrid returns whether the DB has a UTF grid.
 func (d DB) HasUTFGrid() bool {
 	return d.hasUTFGrid
@@ -328,33 +611,121 @@ func (d DB) UTFGridCompression() TileFormat {
 	return d.utfgridCompression
 }
 
+// CacheStats returns the statistics of the tile cache of the DB. All values
+// are zero if the DB was opened without a CacheSize or Cache.
+func (d *DB) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.cacheStats(d.cacheID)
+}
+
+// PurgeCache removes the tiles of zoom level z from the tile cache of the DB,
+// or all tiles if z is negative.
+func (d *DB) PurgeCache(z int) {
+	if d.cache != nil {
+		d.cache.purge(d.cacheID, z)
+	}
+}
+
+// OpenConnections returns the number of open connections to the mbtiles file.
+// It is zero for other tilesets.
+func (d *DB) OpenConnections() int {
+	if d.store != nil {
+		return 0
+	}
+	return d.db.Stats().OpenConnections
+}
+
 // TimeStamp returns the time stamp of the DB.
 func (d DB) TimeStamp() time.Time {
+	if m, ok := d.store.(LastModifier); ok {
+		if t := m.LastModified(); t.After(d.timestamp) {
+			return t
+		}
+	}
 	return d.timestamp
 }
 
-// Close closes the DB database connection
-func (tileset *DB) Close() error {
-	return tileset.db.Close()
+// readEncodingMetadata returns the tile encoding declared by the compression
+// metadata item, which is required for encodings that cannot be detected from
+// the tile data, like brotli. If there is no such item, IDENTITY is returned.
+func readEncodingMetadata(db *sql.DB) (TileEncoding, error) {
+	var name string
+	err := db.QueryRow("select value from metadata where name = 'compression'").Scan(&name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return IDENTITY, nil
+		}
+		return IDENTITY, fmt.Errorf("could not read compression metadata: %v", err)
+	}
+	e, ok := encodingFromName(strings.ToLower(strings.TrimSpace(name)))
+	if !ok {
+		return IDENTITY, fmt.Errorf("unknown compression in metadata: %q", name)
+	}
+	return e, nil
 }
 
-// Inpsect first few bytes of byte array to determine tile format
-// PBF tile format does not have a distinct signature, it will be returned
-// as GZIP, and it is up to caller to determine that it is a PBF format
-func detectTileFormat(data *[]byte) (TileFormat, error) {
-	patterns := map[TileFormat][]byte{
-		GZIP: []byte("\x1f\x8b"), // this masks PBF format too
-		ZLIB: []byte("\x78\x9c"),
-		PNG:  []byte("\x89\x50\x4E\x47\x0D\x0A\x1A\x0A"),
-		JPG:  []byte("\xFF\xD8\xFF"),
-		WEBP: []byte("\x52\x49\x46\x46\xc0\x00\x00\x00\x57\x45\x42\x50\x56\x50"),
+// prepareStatements prepares the statements that are used for reading tiles
+// and grids, so they do not need to be parsed and planned for every read.
+func (tileset *DB) prepareStatements() error {
+	var err error
+	if l := tileset.dedup; l != nil {
+		// query the tables of the tiles view directly, so that the table of
+		// the tile data is only read for the tile data
+		tileset.tileStmt, err = tileset.db.Prepare(l.tileQuery())
+		if err != nil {
+			return err
+		}
+		tileset.hasTileStmt, err = tileset.db.Prepare(l.hasTileQuery())
+		if err != nil {
+			return err
+		}
+		tileset.tileIDStmt, err = tileset.db.Prepare(l.tileIDQuery())
+		if err != nil {
+			return err
+		}
+	} else {
+		tileset.tileStmt, err = tileset.db.Prepare("select tile_data from tiles where zoom_level = ? and tile_column = ? and tile_row = ?")
+		if err != nil {
+			return err
+		}
+		tileset.hasTileStmt, err = tileset.db.Prepare("select 1 from tiles where zoom_level = ? and tile_column = ? and tile_row = ? limit 1")
+		if err != nil {
+			return err
+		}
 	}
-
-	for format, pattern := range patterns {
-		if bytes.HasPrefix(*data, pattern) {
-			return format, nil
+	if tileset.hasUTFGrid {
+		tileset.gridStmt, err = tileset.db.Prepare("select grid from grids where zoom_level = ? and tile_column = ? and tile_row = ?")
+		if err != nil {
+			return err
+		}
+	}
+	if tileset.hasUTFGridData {
+		tileset.gridDataStmt, err = tileset.db.Prepare("select key_name, key_json FROM grid_data where zoom_level = ? and tile_column = ? and tile_row = ?")
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return UNKNOWN, errors.New("Could not detect tile format")
+// Close closes the prepared statements and the DB database connection
+func (tileset *DB) Close() error {
+	if tileset.cache != nil {
+		tileset.cache.unregister(tileset.cacheID)
+	}
+	if tileset.store != nil {
+		return tileset.store.Close()
+	}
+	for _, stmt := range []*sql.Stmt{tileset.tileStmt, tileset.hasTileStmt, tileset.tileIDStmt, tileset.gridStmt, tileset.gridDataStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	err := tileset.db.Close()
+	if tileset.remote != nil {
+		tileset.remote.release()
+	}
+	return err
 }
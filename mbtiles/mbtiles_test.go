@@ -0,0 +1,516 @@
+package mbtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadContext(t *testing.T) {
+	db, err := NewDB("./testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	reads := map[string]func(context.Context, uint8, uint64, uint64, *[]byte) error{
+		"tile": db.ReadTileContext,
+		"grid": db.ReadGridContext,
+	}
+	for name, read := range reads {
+		var data []byte
+		if err := read(context.Background(), 0, 0, 0, &data); err != nil || len(data) == 0 {
+			t.Fatalf("%s: expected data, got %d bytes, %v", name, len(data), err)
+		}
+		for ctx, expected := range map[context.Context]error{cancelled: context.Canceled, expired: context.DeadlineExceeded} {
+			data = []byte("stale")
+			if err := read(ctx, 0, 0, 0, &data); err != expected {
+				t.Errorf("%s: expected %v, got %v", name, expected, err)
+			}
+			if data != nil {
+				t.Errorf("%s: expected no data with %v, got %d bytes", name, expected, len(data))
+			}
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: pngTile}, nil)
+
+	db, err := NewDB(filename, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadTile(0, 0, 0, &data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.db.Exec("DELETE FROM tiles"); err == nil {
+		t.Error("expected error when modifying a read-only DB")
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: pngTile}, nil)
+
+	db, err := NewDB(filename, ReadOnly(), SQLiteCacheSize(8), MmapSize(16), BusyTimeout(2*time.Second),
+		TempStoreMode(TempStoreMemory), MaxOpenConns(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for pragma, expected := range map[string]int64{
+		"query_only":   1,
+		"cache_size":   -8192,
+		"mmap_size":    16 << 20,
+		"busy_timeout": 2000,
+		"temp_store":   2,
+	} {
+		var v int64
+		if err := db.db.QueryRow("PRAGMA " + pragma).Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != expected {
+			t.Errorf("expected %s = %d, got %d", pragma, expected, v)
+		}
+	}
+	if n := db.db.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("expected at most 1 open connection, got %d", n)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{{1, 0, 1}: pngTile}, nil)
+
+	var queries []SlowQuery
+	db, err := NewDB(filename, ReadOnly(), SlowQueryLog(0, func(q SlowQuery) { queries = append(queries, q) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadTile(1, 0, 1, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("expected 1 slow query, got %d", len(queries))
+	}
+	q := queries[0]
+	if q.Filename != filename || q.Query != "tile" || q.Z != 1 || q.X != 0 || q.Y != 1 || q.Err != nil {
+		t.Errorf("unexpected slow query %+v", q)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: pngTile}, nil)
+
+	db, err := NewDB(filename, ReadOnly(), QueryTimeout(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadTile(0, 0, 0, &data); err != ErrQueryTimeout {
+		t.Errorf("expected ErrQueryTimeout, got %v", err)
+	}
+}
+
+func BenchmarkReadTile(b *testing.B) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.ReadTile(1, uint64(i%2), uint64(i/2%2), &data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadGrid(b *testing.B) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.ReadGrid(1, uint64(i%2), uint64(i/2%2), &data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRemote(t *testing.T) {
+	if err := registerHTTPVFS(); err != nil {
+		t.Skip(err)
+	}
+	var requests int64
+	fs := http.FileServer(http.Dir("testdata"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		fs.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	local, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	db, err := NewDB(ts.URL+"/geography-class-png.mbtiles", PageCacheSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.TileFormat() != PNG || !db.HasUTFGrid() {
+		t.Errorf("expected PNG tileset with UTF grids, got %v", db.TileFormat())
+	}
+
+	var want, got []byte
+	for i := 0; i < 2; i++ {
+		if err := local.ReadTile(1, 1, 1, &want); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ReadTile(1, 1, 1, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("expected same tile as from local file")
+		}
+		if i == 0 {
+			atomic.StoreInt64(&requests, 0)
+		}
+	}
+	if n := atomic.LoadInt64(&requests); n != 0 {
+		t.Errorf("expected tile to be read from the page cache, got %d requests", n)
+	}
+
+	if _, err := NewDB(ts.URL + "/missing.mbtiles"); err == nil {
+		t.Error("expected error opening missing remote file")
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles", CacheSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	for i := 0; i < 3; i++ {
+		if err := db.ReadTile(0, 0, 0, &data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := db.CacheStats()
+	if s.Hits != 2 || s.Misses != 1 || s.Items != 1 || s.Bytes != int64(len(data)) {
+		t.Errorf("unexpected cache stats: %+v", s)
+	}
+
+	var child []byte
+	if err := db.ReadTile(1, 0, 0, &child); err != nil {
+		t.Fatal(err)
+	}
+	db.PurgeCache(1)
+	if s := db.CacheStats(); s.Items != 1 || s.Bytes != int64(len(data)) {
+		t.Errorf("expected only the tile of zoom level 0 after purging zoom level 1, got %+v", s)
+	}
+	db.PurgeCache(-1)
+	if s := db.CacheStats(); s.Items != 0 || s.Bytes != 0 {
+		t.Errorf("expected empty cache after purging, got %+v", s)
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	c := NewTileCache(1)
+	var dbs []*DB
+	for _, name := range []string{"geography-class-png.mbtiles", "geography-class-jpg.mbtiles"} {
+		db, err := NewDB(filepath.Join("testdata", name), Cache(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs = append(dbs, db)
+	}
+	defer dbs[0].Close()
+	var sizes []int64
+	for _, db := range dbs {
+		var data []byte
+		for i := 0; i < 2; i++ {
+			if err := db.ReadTile(0, 0, 0, &data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if s := db.CacheStats(); s.Hits != 1 || s.Misses != 1 || s.Items != 1 || s.Bytes != int64(len(data)) {
+			t.Errorf("%s: unexpected cache stats: %+v", db.filename, s)
+		}
+		sizes = append(sizes, int64(len(data)))
+	}
+	if s := c.Stats(); s.Hits != 2 || s.Misses != 2 || s.Items != 2 || s.Bytes != sizes[0]+sizes[1] {
+		t.Errorf("unexpected stats of the shared cache: %+v", s)
+	}
+
+	// the tiles of a DB are purged and removed once it is closed
+	dbs[0].PurgeCache(-1)
+	if s := c.Stats(); s.Items != 1 || s.Bytes != sizes[1] {
+		t.Errorf("expected only the tile of the other DB after purging, got %+v", s)
+	}
+	dbs[1].Close()
+	if s := c.Stats(); s.Items != 0 || s.Bytes != 0 {
+		t.Errorf("expected empty cache after closing, got %+v", s)
+	}
+}
+
+func TestTiles(t *testing.T) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		filter TileFilter
+		count  int
+	}{
+		{TileFilter{}, 5},
+		{TileFilter{Zooms: ZoomRange(1, 1)}, 4},
+		{TileFilter{Bounds: []float64{10, 10, 20, 20}}, 2},
+		{TileFilter{Zooms: []uint8{1}, Bounds: []float64{-20, -20, 20, 20}}, 4},
+		{TileFilter{Zooms: []uint8{1}, Bounds: []float64{-20, 10, -10, 20}}, 1},
+		{TileFilter{Select: func(z uint8, x, y uint64) bool { return z == 1 && x == 0 }}, 2},
+	}
+	for _, tc := range tests {
+		it, err := db.Tiles(context.Background(), tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for it.Next() {
+			if len(it.Tile().Data) == 0 {
+				t.Errorf("%+v: empty tile data", tc.filter)
+			}
+			count++
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+		}
+		it.Close()
+		if count != tc.count {
+			t.Errorf("%+v: expected %d tiles, got %d", tc.filter, tc.count, count)
+		}
+	}
+}
+
+func TestTileRange(t *testing.T) {
+	f := TileFilter{Zooms: ZoomRange(0, 2), Bounds: []float64{-20, 10, -10, 20}}
+	if minX, maxX, minY, maxY := TileRange(f, 2, XYZ); minX != 1 || maxX != 1 || minY != 1 || maxY != 1 {
+		t.Errorf("unexpected XYZ range %d-%d, %d-%d", minX, maxX, minY, maxY)
+	}
+	if _, _, minY, maxY := TileRange(f, 2, TMS); minY != 2 || maxY != 2 {
+		t.Errorf("unexpected TMS rows %d-%d", minY, maxY)
+	}
+	if n := CountTiles(f); n != 3 {
+		t.Errorf("expected 3 tiles, got %d", n)
+	}
+	if n := CountTiles(TileFilter{Zooms: ZoomRange(0, 2)}); n != 21 {
+		t.Errorf("expected 21 tiles, got %d", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 5 || len(s.Zooms) != 2 || s.Zooms[1].Count != 4 {
+		t.Errorf("unexpected tile counts: %+v", s)
+	}
+	if s.MinSize <= 0 || s.MinSize > s.MaxSize || s.Bytes < s.Count*s.MinSize {
+		t.Errorf("inconsistent tile sizes: %+v", s)
+	}
+}
+
+func TestComputeBounds(t *testing.T) {
+	db, err := NewDB("testdata/openstreetmap/open-streets-dc.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	b, err := db.ComputeBounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tiles must cover the bounds from the metadata
+	if len(b) != 4 || b[0] > -77.1408 || b[1] > 38.779 || b[2] < -76.893 || b[3] < 39.0088 {
+		t.Errorf("computed bounds do not cover the tileset: %v", b)
+	}
+	if b[2]-b[0] > 1 || b[3]-b[1] > 1 {
+		t.Errorf("computed bounds are too large: %v", b)
+	}
+}
+
+func TestDetectTileFormat(t *testing.T) {
+	custom := RegisterTileFormat("foo", "application/x-foo", func(data []byte) bool {
+		return len(data) > 0 && data[0] == 'F'
+	})
+	if custom.String() != "foo" || custom.ContentType() != "application/x-foo" {
+		t.Errorf("unexpected name or content type of registered format: %q, %q", custom, custom.ContentType())
+	}
+
+	tests := []struct {
+		data   string
+		format TileFormat
+	}{
+		{"\x89\x50\x4E\x47\x0D\x0A\x1A\x0A", PNG},
+		{"\xFF\xD8\xFF\xE0", JPG},
+		{"\x1f\x8b\x08\x00", GZIP},
+		{"\x78\x9c\x01", ZLIB},
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", AVIF},
+		{"RIFF\xc0\x00\x00\x00WEBPVP8 ", WEBP},         // lossy
+		{"RIFF\x1a\x04\x00\x00WEBPVP8L", WEBP},         // lossless
+		{"RIFF\x8e\x3c\x01\x00WEBPVP8X\x0a\x00", WEBP}, // extended, e.g. animated
+		{"RIFF\x24\x00\x00\x00WAVEfmt ", UNKNOWN},
+		{"\x1a\x05\x78\x02\x0a\x01a", PBF},
+		{"\x1a\x50\x78\x02", UNKNOWN}, // layer length exceeds data
+		{"Foo", custom},
+	}
+	for _, tc := range tests {
+		data := []byte(tc.data)
+		f, err := detectTileFormat(&data)
+		if f != tc.format {
+			t.Errorf("%q: expected format %d, got %d (%v)", tc.data, tc.format, f, err)
+		}
+	}
+}
+
+func TestTileEncoding(t *testing.T) {
+	tests := []struct {
+		tile     string
+		metadata map[string]string
+		encoding TileEncoding
+	}{
+		{"\x1a\x05\x78\x02\x0a\x01a", nil, IDENTITY},
+		{"\x1f\x8b\x08\x00", nil, GZIPENC},
+		{"\x28\xb5\x2f\xfd\x00", nil, ZSTDENC},
+		{"\x0b\x02\x80", map[string]string{"compression": "br"}, BROTLIENC},
+	}
+	for _, tc := range tests {
+		filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: []byte(tc.tile)}, tc.metadata)
+		db, err := NewDB(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.TileFormat() != PBF || db.TileEncoding() != tc.encoding {
+			t.Errorf("%q: expected pbf with encoding %v, got %v with encoding %v", tc.tile, tc.encoding, db.TileFormatString(), db.TileEncoding())
+		}
+		db.Close()
+	}
+}
+
+func TestReadTileDecompressed(t *testing.T) {
+	mvt := []byte("\x1a\x05\x78\x02\x0a\x01a")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(mvt)
+	zw.Close()
+	filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: buf.Bytes()}, nil)
+
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadTileDecompressed(0, 0, 0, &data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, mvt) {
+		t.Errorf("expected decompressed tile %q, got %q", mvt, data)
+	}
+}
+
+func TestTileJSON(t *testing.T) {
+	metadata := map[string]string{
+		"name":     "test",
+		"format":   "pbf",
+		"fillzoom": "3",
+		"json":     `{"vector_layers": [{"id": "water", "fields": {}}]}`,
+	}
+	filename := createTestDB(t, map[[3]uint64][]byte{{0, 0, 0}: []byte("\x1a\x05\x78\x02\x0a\x01a")}, metadata)
+
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tj, err := db.TileJSON("http://localhost/services/test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj["tilejson"] != TileJSONVersion || tj["scheme"] != "xyz" || tj["name"] != "test" || tj["fillzoom"] != 3 {
+		t.Errorf("unexpected TileJSON: %v", tj)
+	}
+	tiles := tj["tiles"].([]string)
+	if len(tiles) != 1 || tiles[0] != "http://localhost/services/test/tiles/{z}/{x}/{y}.pbf" {
+		t.Errorf("unexpected tiles: %v", tiles)
+	}
+	if layers, ok := tj["vector_layers"].([]interface{}); !ok || len(layers) != 1 {
+		t.Errorf("unexpected vector_layers: %v", tj["vector_layers"])
+	}
+}
+
+func TestReadGridDecompressed(t *testing.T) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadGridDecompressed(0, 0, 0, &data); err != nil {
+		t.Fatal(err)
+	}
+	var grid struct {
+		Grid []string
+		Keys []string
+		Data map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &grid); err != nil {
+		t.Fatal(err)
+	}
+	if len(grid.Grid) == 0 || len(grid.Keys) == 0 || len(grid.Data) == 0 {
+		t.Errorf("expected grid with keys and data, got %s", data)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	db, err := NewDB("testdata/geography-class-png.mbtiles", CheckIntegrity())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
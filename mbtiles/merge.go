@@ -0,0 +1,253 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// ConflictPolicy determines which tile is kept if a merged tileset has a tile
+// at the same coordinates as the Writer.
+type ConflictPolicy uint8
+
+const (
+	// PreferNewer replaces the existing tile by the merged one. Merge merges
+	// the tilesets from the oldest to the newest, so the tile of the newest
+	// tileset is kept.
+	PreferNewer ConflictPolicy = iota
+	// PreferLarger keeps the larger of the tiles, which is typically the one
+	// with more detail. The existing tile is kept if both are of equal size.
+	PreferLarger
+	// ConflictError lets the merge fail unless both tiles are identical.
+	ConflictError
+)
+
+// ParseConflictPolicy returns the ConflictPolicy of the name "newer",
+// "larger" or "error".
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch name {
+	case "newer":
+		return PreferNewer, nil
+	case "larger":
+		return PreferLarger, nil
+	case "error":
+		return ConflictError, nil
+	}
+	return PreferNewer, fmt.Errorf("unknown conflict policy %q", name)
+}
+
+// MergeOptions configure MergeFrom and Merge.
+type MergeOptions struct {
+	// Conflict determines which tile is kept if there are tiles at the
+	// same coordinates.
+	Conflict ConflictPolicy
+	// Filter selects the merged tiles.
+	Filter TileFilter
+}
+
+// MergeFrom writes the tiles of src that match the filter of o to w, keeping
+// existing tiles according to the ConflictPolicy of o. Metadata is not
+// merged.
+func (w *Writer) MergeFrom(ctx context.Context, src *DB, o MergeOptions) error {
+	it, err := src.Tiles(ctx, o.Filter)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		t := it.Tile()
+		// convert the row from the scheme of src to that of w
+		y := src.tmsRow(t.Z, t.Y)
+		if w.scheme != TMS {
+			y = flipRow(t.Z, y)
+		}
+		if o.Conflict != PreferNewer {
+			keep, err := w.keepExisting(t.Z, t.X, y, t.Data, o.Conflict)
+			if err != nil {
+				return err
+			}
+			if keep {
+				continue
+			}
+		}
+		if err := w.WriteTile(t.Z, t.X, y, t.Data); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// keepExisting reports whether an existing tile at z, x, y in the scheme of
+// w is kept instead of being replaced by data according to the policy c.
+func (w *Writer) keepExisting(z uint8, x, y uint64, data []byte, c ConflictPolicy) (bool, error) {
+	if err := w.begin(); err != nil {
+		return false, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	row := y
+	if w.scheme != TMS {
+		row = flipRow(z, y)
+	}
+	var existing []byte
+	err := w.tx.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", z, x, row).Scan(&existing)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not read tile for z=%d, x=%d, y=%d: %v", z, x, y, err)
+	}
+	if c == ConflictError {
+		if string(existing) != string(data) {
+			return false, fmt.Errorf("conflicting tiles for z=%d, x=%d, y=%d", z, x, y)
+		}
+		return true, nil
+	}
+	return len(existing) >= len(data), nil
+}
+
+// Merge creates a new mbtiles file at dst with the tiles of the tilesets at
+// srcs, which are merged from the oldest to the newest by their TimeStamp
+// according to o. The metadata is taken from the newest tileset, except for
+// the bounds and zoom levels, which cover those of all tilesets. The tiles
+// of all tilesets must have the same format and encoding. The options opts
+// are passed on to CreateDB. If the merge fails, dst is removed.
+func Merge(ctx context.Context, dst string, srcs []string, o MergeOptions, opts ...Option) error {
+	var dbs []*DB
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+	for _, src := range srcs {
+		db, err := NewDB(src, ReadOnly())
+		if err != nil {
+			return err
+		}
+		dbs = append(dbs, db)
+		if db.TileFormat() != dbs[0].TileFormat() || db.TileEncoding() != dbs[0].TileEncoding() {
+			return fmt.Errorf("cannot merge %s tiles of %s with %s tiles of %s", db.TileFormatString(), src, dbs[0].TileFormatString(), srcs[0])
+		}
+	}
+	if len(dbs) == 0 {
+		return fmt.Errorf("no tilesets to merge")
+	}
+	sort.SliceStable(dbs, func(i, j int) bool { return dbs[i].TimeStamp().Before(dbs[j].TimeStamp()) })
+
+	metadata, err := mergeMetadata(dbs, o.Filter)
+	if err != nil {
+		return err
+	}
+	w, err := CreateDB(dst, opts...)
+	if err != nil {
+		return err
+	}
+	err = writeMetadataItems(w, metadata)
+	for _, db := range dbs {
+		if err != nil {
+			break
+		}
+		if err = w.MergeFrom(ctx, db, o); err != nil {
+			err = fmt.Errorf("could not merge %s: %v", db.filename, err)
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Extract creates a new mbtiles file at dst with the tiles of the DB that
+// match the filter f. The metadata is that of the DB, with the bounds and zoom
+// levels limited to those of f. The options opts are passed on to CreateDB.
+// If the extraction fails, dst is removed.
+func (tileset *DB) Extract(ctx context.Context, dst string, f TileFilter, opts ...Option) error {
+	metadata, err := mergeMetadata([]*DB{tileset}, f)
+	if err != nil {
+		return err
+	}
+	if _, ok := metadata["format"]; !ok {
+		metadata["format"] = tileset.TileFormatString()
+	}
+	w, err := CreateDB(dst, opts...)
+	if err != nil {
+		return err
+	}
+	err = writeMetadataItems(w, metadata)
+	if err == nil {
+		err = w.MergeFrom(ctx, tileset, MergeOptions{Filter: f})
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// mergeMetadata returns the metadata of the newest of dbs, which are ordered
+// by age, with the union of the bounds and zoom levels of all of them, as far
+// as they match the filter f.
+func mergeMetadata(dbs []*DB, f TileFilter) (map[string]interface{}, error) {
+	var metadata map[string]interface{}
+	var bounds []float64
+	minZoom, maxZoom := -1, -1
+	for _, db := range dbs {
+		m, err := db.ReadMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("could not read metadata of %s: %v", db.filename, err)
+		}
+		metadata = m
+		if b, ok := m["bounds"].([]float64); ok && len(b) == 4 {
+			if bounds == nil {
+				bounds = append([]float64{}, b...)
+			}
+			bounds[0], bounds[1] = math.Min(bounds[0], b[0]), math.Min(bounds[1], b[1])
+			bounds[2], bounds[3] = math.Max(bounds[2], b[2]), math.Max(bounds[3], b[3])
+		}
+		if z, ok := m["minzoom"].(int); ok && (minZoom < 0 || z < minZoom) {
+			minZoom = z
+		}
+		if z, ok := m["maxzoom"].(int); ok && z > maxZoom {
+			maxZoom = z
+		}
+	}
+	// the filter limits the merged tiles further
+	if len(f.Bounds) == 4 && bounds != nil {
+		bounds[0], bounds[1] = math.Max(bounds[0], f.Bounds[0]), math.Max(bounds[1], f.Bounds[1])
+		bounds[2], bounds[3] = math.Min(bounds[2], f.Bounds[2]), math.Min(bounds[3], f.Bounds[3])
+	}
+	if len(f.Zooms) > 0 {
+		zooms := append([]uint8{}, f.Zooms...)
+		sort.Slice(zooms, func(i, j int) bool { return zooms[i] < zooms[j] })
+		if z := int(zooms[0]); z > minZoom {
+			minZoom = z
+		}
+		if z := int(zooms[len(zooms)-1]); maxZoom < 0 || z < maxZoom {
+			maxZoom = z
+		}
+	}
+	if bounds != nil {
+		metadata["bounds"] = bounds
+	}
+	if minZoom >= 0 {
+		metadata["minzoom"] = minZoom
+	}
+	if maxZoom >= 0 {
+		metadata["maxzoom"] = maxZoom
+	}
+	if _, ok := metadata["format"]; !ok {
+		metadata["format"] = dbs[0].TileFormatString()
+	}
+	if e := dbs[0].TileEncoding(); e != IDENTITY {
+		metadata["compression"] = e.String()
+	}
+	return metadata, nil
+}
@@ -0,0 +1,107 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	older := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: otherTile,
+		{1, 0, 0}: pngTile,
+	}, map[string]string{"name": "older", "minzoom": "0", "maxzoom": "1", "bounds": "-180,0,0,85"})
+	newer := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{2, 3, 3}: pngTile,
+	}, map[string]string{"name": "newer", "minzoom": "0", "maxzoom": "2", "bounds": "0,-85,180,0"})
+	now := time.Now()
+	if err := os.Chtimes(older, now, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(newer)
+
+	for _, c := range []struct {
+		conflict ConflictPolicy
+		data     []byte
+	}{{PreferNewer, pngTile}, {PreferLarger, otherTile}} {
+		dst := filepath.Join(dir, "merged.mbtiles")
+		if err := Merge(context.Background(), dst, []string{newer, older}, MergeOptions{Conflict: c.conflict}); err != nil {
+			t.Fatal(err)
+		}
+		db, err := NewDB(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		if err := db.ReadTile(0, 0, 0, &data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, c.data) {
+			t.Errorf("conflict=%d: expected %q, got %q", c.conflict, c.data, data)
+		}
+		for _, tile := range [][3]uint64{{1, 0, 0}, {2, 3, 3}} {
+			if err := db.ReadTile(uint8(tile[0]), tile[1], tile[2], &data); err != nil {
+				t.Errorf("conflict=%d: could not read tile %v: %v", c.conflict, tile, err)
+			}
+		}
+		metadata, err := db.ReadMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds, _ := metadata["bounds"].([]float64)
+		if metadata["name"] != "newer" || metadata["maxzoom"] != 2 || len(bounds) != 4 || bounds[0] != -180 || bounds[3] != 85 {
+			t.Errorf("conflict=%d: unexpected metadata: %v", c.conflict, metadata)
+		}
+		db.Close()
+		os.Remove(dst)
+	}
+
+	dst := filepath.Join(dir, "conflict.mbtiles")
+	if err := Merge(context.Background(), dst, []string{older, newer}, MergeOptions{Conflict: ConflictError}); err == nil {
+		t.Error("expected conflicting tiles to fail the merge")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", dst)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{
+		{1, 0, 0}: pngTile,
+		{1, 1, 0}: pngTile,
+		{2, 0, 0}: pngTile,
+	}, map[string]string{"name": "src", "minzoom": "1", "maxzoom": "2", "bounds": "-180,-85,180,85"})
+	src, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst := filepath.Join(filepath.Dir(filename), "extract.mbtiles")
+	f := TileFilter{Zooms: ZoomRange(0, 1), Bounds: []float64{-180, 0, -1, 85}}
+	if err := src.Extract(context.Background(), dst, f); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for tile, expected := range map[[3]uint64]bool{{1, 0, 0}: true, {1, 1, 0}: false, {2, 0, 0}: false} {
+		if ok, err := db.HasTile(uint8(tile[0]), tile[1], tile[2]); ok != expected || err != nil {
+			t.Errorf("expected tile %v to be extracted: %v, got %v (%v)", tile, expected, ok, err)
+		}
+	}
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds, _ := metadata["bounds"].([]float64)
+	if metadata["name"] != "src" || metadata["maxzoom"] != 1 || len(bounds) != 4 || bounds[1] != 0 || bounds[2] != -1 {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+}
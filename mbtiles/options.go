@@ -0,0 +1,309 @@
+package mbtiles
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures how NewDB opens an mbtiles file.
+type Option func(*options)
+
+type options struct {
+	readOnly  bool
+	cacheSize int64
+	cache     *TileCache
+	scheme    TileScheme
+	check     bool
+	client    *http.Client
+	pageCache int64
+	dedup     bool
+
+	// SQLite pragmas, unset if zero
+	sqliteCache int64
+	mmapSize    int64
+	busyTimeout time.Duration
+	tempStore   TempStore
+
+	// connection pool of database/sql, unset if zero
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+
+	queryTimeout time.Duration
+	slowQuery    time.Duration
+	logSlowQuery func(SlowQuery)
+}
+
+// TileScheme is the numbering scheme of the tile rows.
+type TileScheme uint8
+
+const (
+	// XYZ counts the rows from the north, like most web maps do.
+	XYZ TileScheme = iota
+	// TMS counts the rows from the south, like the tiles table of an
+	// mbtiles file does.
+	TMS
+)
+
+// String returns the name of the scheme as used by TileJSON.
+func (s TileScheme) String() string {
+	if s == TMS {
+		return "tms"
+	}
+	return "xyz"
+}
+
+// ParseTileScheme returns the TileScheme with the name "xyz" or "tms".
+func ParseTileScheme(name string) (TileScheme, error) {
+	switch strings.ToLower(name) {
+	case "xyz":
+		return XYZ, nil
+	case "tms":
+		return TMS, nil
+	}
+	return XYZ, fmt.Errorf("unknown tile scheme %q", name)
+}
+
+// flipRow converts the row y at zoom level z from one TileScheme to the
+// other.
+func flipRow(z uint8, y uint64) uint64 {
+	return (uint64(1) << z) - 1 - y
+}
+
+// ReadOnly opens the mbtiles file in read-only and immutable mode and
+// disallows any changes to it. SQLite will then neither attempt to create a
+// journal nor acquire any locks, so this is suited for files on read-only
+// file systems or network mounts and for files that are shared by multiple
+// server instances. The file must not be modified while it is opened this way.
+func ReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// CacheSize enables an in-memory LRU cache in front of ReadTile which holds
+// up to size megabytes of tile data for this DB only. Use DB.CacheStats to obtain the hit and
+// miss counters of the cache. A size of zero disables the cache.
+func CacheSize(size int64) Option {
+	return func(o *options) {
+		o.cacheSize = size
+	}
+}
+
+// Cache lets the DB keep its tiles in the TileCache c, which can be shared by
+// many DBs to limit the memory of all of them. It takes precedence over
+// CacheSize.
+func Cache(c *TileCache) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// CheckIntegrity runs DB.QuickCheck when the mbtiles file is opened and lets
+// NewDB fail if it reports any problem. This takes time proportional to the
+// size of the file.
+func CheckIntegrity() Option {
+	return func(o *options) {
+		o.check = true
+	}
+}
+
+// Scheme sets the TileScheme of the rows that are passed to and returned by
+// the methods of the DB or the Writer. The default is XYZ, the rows are then
+// converted to and from the TMS rows of the mbtiles file internally.
+func Scheme(s TileScheme) Option {
+	return func(o *options) {
+		o.scheme = s
+	}
+}
+
+// Deduplicate lets a Writer store the data of identical tiles only once, in
+// the images table of the normalized schema that is used by tools like mbutil,
+// with a tiles view that joins it with the map table.
+func Deduplicate() Option {
+	return func(o *options) {
+		o.dedup = true
+	}
+}
+
+// HTTPClient sets the client with which mbtiles files on HTTP servers are
+// read. The default is http.DefaultClient.
+func HTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// PageCacheSize sets the size in megabytes of the in-memory cache of the
+// pages of an mbtiles file on an HTTP server. The default is
+// DefaultPageCacheSize.
+func PageCacheSize(size int64) Option {
+	return func(o *options) {
+		o.pageCache = size
+	}
+}
+
+// SQLiteCacheSize sets the size in megabytes of the page cache of each
+// SQLite connection (the cache_size pragma). The default of SQLite is 2 MB.
+func SQLiteCacheSize(size int64) Option {
+	return func(o *options) {
+		o.sqliteCache = size
+	}
+}
+
+// MmapSize sets the number of megabytes of the mbtiles file that SQLite reads
+// through memory-mapped I/O instead of read calls (the mmap_size pragma),
+// which saves copying pages for large files. It is ignored for files on HTTP
+// servers.
+func MmapSize(size int64) Option {
+	return func(o *options) {
+		o.mmapSize = size
+	}
+}
+
+// BusyTimeout sets how long SQLite waits for a lock that is held by another
+// connection or process before a query fails (the busy_timeout pragma).
+func BusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// TempStore is where SQLite stores temporary tables and indices.
+type TempStore uint8
+
+const (
+	// TempStoreDefault leaves the decision to the compile-time options of
+	// SQLite, which usually store them in files.
+	TempStoreDefault TempStore = iota
+	// TempStoreFile stores them in files.
+	TempStoreFile
+	// TempStoreMemory stores them in memory.
+	TempStoreMemory
+)
+
+// String returns the name of the TempStore as used by the temp_store pragma.
+func (t TempStore) String() string {
+	switch t {
+	case TempStoreFile:
+		return "file"
+	case TempStoreMemory:
+		return "memory"
+	}
+	return "default"
+}
+
+// ParseTempStore returns the TempStore with the name "default", "file" or
+// "memory".
+func ParseTempStore(name string) (TempStore, error) {
+	for _, t := range []TempStore{TempStoreDefault, TempStoreFile, TempStoreMemory} {
+		if strings.ToLower(name) == t.String() {
+			return t, nil
+		}
+	}
+	return TempStoreDefault, fmt.Errorf("unknown temp store %q", name)
+}
+
+// TempStoreMode sets where SQLite stores temporary tables and indices (the
+// temp_store pragma).
+func TempStoreMode(t TempStore) Option {
+	return func(o *options) {
+		o.tempStore = t
+	}
+}
+
+// MaxOpenConns limits the number of open connections to the mbtiles file, see
+// sql.DB.SetMaxOpenConns. By default, the number is not limited.
+func MaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpenConns = n
+	}
+}
+
+// MaxIdleConns sets the number of idle connections to the mbtiles file that
+// are kept open, see sql.DB.SetMaxIdleConns. The default of database/sql is
+// 2, which makes a server with many concurrent tile reads open and close
+// connections all the time.
+func MaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdleConns = n
+	}
+}
+
+// ConnMaxLifetime sets the time after which connections to the mbtiles file
+// are closed and reopened, see sql.DB.SetConnMaxLifetime. By default,
+// connections are reused forever.
+func ConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxLifetime = d
+	}
+}
+
+// pragmas returns the pragma statements that are executed on every new
+// connection.
+func (o options) pragmas(remote bool) []string {
+	var pragmas []string
+	if o.readOnly || remote {
+		pragmas = append(pragmas, "query_only = 1")
+	}
+	if o.sqliteCache > 0 {
+		// negative values are in KiB instead of pages
+		pragmas = append(pragmas, fmt.Sprintf("cache_size = -%d", o.sqliteCache*1024))
+	}
+	if o.mmapSize > 0 && !remote {
+		pragmas = append(pragmas, fmt.Sprintf("mmap_size = %d", o.mmapSize*1048576))
+	}
+	if o.busyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout = %d", o.busyTimeout/time.Millisecond))
+	}
+	if o.tempStore != TempStoreDefault {
+		pragmas = append(pragmas, "temp_store = "+o.tempStore.String())
+	}
+	return pragmas
+}
+
+// configurePool applies the connection pool options to db.
+func (o options) configurePool(db *sql.DB) {
+	if o.maxOpenConns > 0 {
+		db.SetMaxOpenConns(o.maxOpenConns)
+	}
+	if o.maxIdleConns > 0 {
+		db.SetMaxIdleConns(o.maxIdleConns)
+	}
+	if o.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(o.connMaxLifetime)
+	}
+}
+
+// uriEscaper escapes the characters that have a special meaning in SQLite
+// URI filenames.
+var uriEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// driverAndDSN returns the name of the sql driver and the data source name
+// that are used to open filename with the given options.
+func (o options) driverAndDSN(filename string) (string, string) {
+	remote := IsRemote(filename)
+	dsn := filename
+	switch {
+	case remote:
+		dsn = "file:" + uriEscaper.Replace(filename) + "?vfs=" + httpVFS + "&mode=ro&immutable=1"
+	case o.readOnly:
+		dsn = "file:" + uriEscaper.Replace(filename) + "?mode=ro&immutable=1"
+	}
+	return sqliteDSN(dsn, o.pragmas(remote))
+}
+
+// tileCache returns the TileCache of a DB that is opened with the options, or
+// nil if caching is disabled.
+func (o *options) tileCache() *TileCache {
+	if o.cache != nil {
+		return o.cache
+	}
+	if o.cacheSize > 0 {
+		return NewTileCache(o.cacheSize)
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+)
+
+func init() {
+	RegisterTileStore(".pmtiles", openPMTiles)
+}
+
+// pmtilesHeaderSize is the size of the header of PMTiles version 3 files.
+const pmtilesHeaderSize = 127
+
+// pmtilesHeader is the header of a PMTiles version 3 file. Offsets are
+// counted from the start of the file.
+type pmtilesHeader struct {
+	rootOffset, rootLength         uint64
+	metadataOffset, metadataLength uint64
+	leafOffset, leafLength         uint64
+	dataOffset, dataLength         uint64
+	addressedTiles                 uint64 // number of tiles
+	tileEntries                    uint64 // number of directory entries of tiles
+	tileContents                   uint64 // number of distinct tile data
+	clustered                      bool   // tile data is ordered by tile ID
+	internalCompression            uint8
+	tileCompression                uint8
+	tileType                       uint8
+	minZoom, maxZoom               uint8
+	minLon, minLat, maxLon, maxLat int32 // in units of 1e-7 degrees
+	centerZoom                     uint8
+	centerLon, centerLat           int32
+}
+
+// parsePMTilesHeader parses the header of a PMTiles version 3 file.
+func parsePMTilesHeader(b []byte) (pmtilesHeader, error) {
+	var h pmtilesHeader
+	if len(b) < pmtilesHeaderSize || string(b[:7]) != "PMTiles" {
+		return h, fmt.Errorf("not a PMTiles file")
+	}
+	if b[7] != 3 {
+		return h, fmt.Errorf("unsupported PMTiles version %d", b[7])
+	}
+	u64 := func(off int) uint64 { return binary.LittleEndian.Uint64(b[off:]) }
+	i32 := func(off int) int32 { return int32(binary.LittleEndian.Uint32(b[off:])) }
+	h.rootOffset, h.rootLength = u64(8), u64(16)
+	h.metadataOffset, h.metadataLength = u64(24), u64(32)
+	h.leafOffset, h.leafLength = u64(40), u64(48)
+	h.dataOffset, h.dataLength = u64(56), u64(64)
+	h.addressedTiles, h.tileEntries, h.tileContents = u64(72), u64(80), u64(88)
+	h.clustered = b[96] == 1
+	h.internalCompression = b[97]
+	h.tileCompression = b[98]
+	h.tileType = b[99]
+	h.minZoom, h.maxZoom = b[100], b[101]
+	h.minLon, h.minLat, h.maxLon, h.maxLat = i32(102), i32(106), i32(110), i32(114)
+	h.centerZoom = b[118]
+	h.centerLon, h.centerLat = i32(119), i32(123)
+	return h, nil
+}
+
+// bytes returns the serialized header.
+func (h pmtilesHeader) bytes() []byte {
+	b := make([]byte, pmtilesHeaderSize)
+	copy(b, "PMTiles")
+	b[7] = 3
+	u64 := func(off int, v uint64) { binary.LittleEndian.PutUint64(b[off:], v) }
+	i32 := func(off int, v int32) { binary.LittleEndian.PutUint32(b[off:], uint32(v)) }
+	u64(8, h.rootOffset)
+	u64(16, h.rootLength)
+	u64(24, h.metadataOffset)
+	u64(32, h.metadataLength)
+	u64(40, h.leafOffset)
+	u64(48, h.leafLength)
+	u64(56, h.dataOffset)
+	u64(64, h.dataLength)
+	u64(72, h.addressedTiles)
+	u64(80, h.tileEntries)
+	u64(88, h.tileContents)
+	if h.clustered {
+		b[96] = 1
+	}
+	b[97] = h.internalCompression
+	b[98] = h.tileCompression
+	b[99] = h.tileType
+	b[100], b[101] = h.minZoom, h.maxZoom
+	i32(102, h.minLon)
+	i32(106, h.minLat)
+	i32(110, h.maxLon)
+	i32(114, h.maxLat)
+	b[118] = h.centerZoom
+	i32(119, h.centerLon)
+	i32(123, h.centerLat)
+	return b
+}
+
+// pmtilesEncoding returns the TileEncoding of a PMTiles compression.
+func pmtilesEncoding(c uint8) (TileEncoding, error) {
+	switch c {
+	case 0, 1: // unknown, none
+		return IDENTITY, nil
+	case 2:
+		return GZIPENC, nil
+	case 3:
+		return BROTLIENC, nil
+	case 4:
+		return ZSTDENC, nil
+	}
+	return IDENTITY, fmt.Errorf("unknown PMTiles compression %d", c)
+}
+
+// pmtilesFormats are the TileFormats of the PMTiles tile types.
+var pmtilesFormats = map[uint8]TileFormat{1: PBF, 2: PNG, 3: JPG, 4: WEBP, 5: AVIF}
+
+// pmtilesEntry is an entry of a PMTiles directory. It either refers to
+// runLength tiles with the same data, or, if runLength is zero, to a leaf
+// directory.
+type pmtilesEntry struct {
+	tileID    uint64
+	offset    uint64
+	length    uint32
+	runLength uint32
+}
+
+// parsePMTilesDirectory parses an uncompressed PMTiles directory.
+func parsePMTilesDirectory(b []byte) ([]pmtilesEntry, error) {
+	r := bytes.NewReader(b)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PMTiles directory: %v", err)
+	}
+	if n > uint64(len(b)) {
+		return nil, fmt.Errorf("invalid PMTiles directory: %d entries in %d bytes", n, len(b))
+	}
+	entries := make([]pmtilesEntry, n)
+	read := func(set func(i int, v uint64)) error {
+		for i := range entries {
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("invalid PMTiles directory: %v", err)
+			}
+			set(i, v)
+		}
+		return nil
+	}
+	var id uint64
+	if err := read(func(i int, v uint64) { id += v; entries[i].tileID = id }); err != nil {
+		return nil, err
+	}
+	if err := read(func(i int, v uint64) { entries[i].runLength = uint32(v) }); err != nil {
+		return nil, err
+	}
+	if err := read(func(i int, v uint64) { entries[i].length = uint32(v) }); err != nil {
+		return nil, err
+	}
+	err = read(func(i int, v uint64) {
+		if v == 0 && i > 0 {
+			// the tile data directly follows that of the previous entry
+			entries[i].offset = entries[i-1].offset + uint64(entries[i-1].length)
+		} else {
+			entries[i].offset = v - 1
+		}
+	})
+	return entries, err
+}
+
+// serializePMTilesDirectory returns the uncompressed PMTiles directory of the
+// entries, which must be ordered by tile ID.
+func serializePMTilesDirectory(entries []pmtilesEntry) []byte {
+	var b bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(v uint64) { b.Write(buf[:binary.PutUvarint(buf, v)]) }
+	put(uint64(len(entries)))
+	var last uint64
+	for _, e := range entries {
+		put(e.tileID - last)
+		last = e.tileID
+	}
+	for _, e := range entries {
+		put(uint64(e.runLength))
+	}
+	for _, e := range entries {
+		put(uint64(e.length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.offset == entries[i-1].offset+uint64(entries[i-1].length) {
+			put(0)
+		} else {
+			put(e.offset + 1)
+		}
+	}
+	return b.Bytes()
+}
+
+// zoomStartID returns the ID of the first tile at zoom level z.
+func zoomStartID(z uint8) uint64 {
+	return ((uint64(1) << (2 * uint(z))) - 1) / 3
+}
+
+// hilbertRotate rotates the quadrant of size n for the Hilbert curve.
+func hilbertRotate(n, x, y, rx, ry uint64) (uint64, uint64) {
+	if ry == 0 {
+		if rx == 1 {
+			x, y = n-1-x, n-1-y
+		}
+		x, y = y, x
+	}
+	return x, y
+}
+
+// pmtilesID returns the PMTiles tile ID of the tile at z, x, y, with the row
+// y counted from the north.
+func pmtilesID(z uint8, x, y uint64) uint64 {
+	n := uint64(1) << z
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		x, y = hilbertRotate(n, x, y, rx, ry)
+	}
+	return zoomStartID(z) + d
+}
+
+// pmtilesTile returns the zoom level, column and row, counted from the north,
+// of the tile with the PMTiles tile ID id.
+func pmtilesTile(id uint64) (uint8, uint64, uint64) {
+	z := uint8(0)
+	for z < 31 && zoomStartID(z+1) <= id {
+		z++
+	}
+	t := id - zoomStartID(z)
+	var x, y uint64
+	for s := uint64(1); s < uint64(1)<<z; s *= 2 {
+		rx := 1 & (t / 2)
+		ry := 1 & (t ^ rx)
+		x, y = hilbertRotate(s, x, y, rx, ry)
+		x += s * rx
+		y += s * ry
+		t /= 4
+	}
+	return z, x, y
+}
+
+// pmtiles is the TileStore of a PMTiles version 3 file.
+type pmtiles struct {
+	f        *os.File
+	header   pmtilesHeader
+	internal TileEncoding // compression of the directories and metadata
+	root     []pmtilesEntry
+	metadata map[string]interface{}
+
+	mu     sync.Mutex
+	leaves *lru.Cache // parsed leaf directories by offset
+}
+
+// openPMTiles opens the local PMTiles file filename.
+func openPMTiles(filename string) (TileStore, error) {
+	if IsRemote(filename) {
+		return nil, fmt.Errorf("cannot open %s: remote PMTiles files are not supported", filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPMTiles(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot open %s: %v", filename, err)
+	}
+	return p, nil
+}
+
+func newPMTiles(f *os.File) (*pmtiles, error) {
+	b := make([]byte, pmtilesHeaderSize)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	h, err := parsePMTilesHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &pmtiles{
+		f:      f,
+		header: h,
+		leaves: lru.New(64),
+	}
+	p.internal, err = pmtilesEncoding(h.internalCompression)
+	if err != nil {
+		return nil, err
+	}
+	p.root, err = p.directory(h.rootOffset, h.rootLength)
+	if err != nil {
+		return nil, err
+	}
+	p.metadata, err = p.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readSection reads and decompresses the directory or metadata section of
+// length bytes at offset.
+func (p *pmtiles) readSection(offset, length uint64) ([]byte, error) {
+	b := make([]byte, length)
+	if _, err := p.f.ReadAt(b, int64(offset)); err != nil {
+		return nil, err
+	}
+	return p.internal.Decode(b)
+}
+
+// directory reads the directory of length bytes at offset.
+func (p *pmtiles) directory(offset, length uint64) ([]pmtilesEntry, error) {
+	b, err := p.readSection(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return parsePMTilesDirectory(b)
+}
+
+// leaf returns the leaf directory of the entry e.
+func (p *pmtiles) leaf(e pmtilesEntry) ([]pmtilesEntry, error) {
+	p.mu.Lock()
+	cached, ok := p.leaves.Get(e.offset)
+	p.mu.Unlock()
+	if ok {
+		return cached.([]pmtilesEntry), nil
+	}
+	entries, err := p.directory(p.header.leafOffset+e.offset, uint64(e.length))
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.leaves.Add(e.offset, entries)
+	p.mu.Unlock()
+	return entries, nil
+}
+
+// readMetadata returns the JSON metadata of the file together with the
+// metadata items from the header.
+func (p *pmtiles) readMetadata() (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	if p.header.metadataLength > 0 {
+		b, err := p.readSection(p.header.metadataOffset, p.header.metadataLength)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			return nil, fmt.Errorf("cannot parse metadata: %v", err)
+		}
+		if metadata == nil { // the metadata is null
+			metadata = make(map[string]interface{})
+		}
+	}
+	h := p.header
+	metadata["minzoom"] = int(h.minZoom)
+	metadata["maxzoom"] = int(h.maxZoom)
+	metadata["bounds"] = []float64{float64(h.minLon) / 1e7, float64(h.minLat) / 1e7, float64(h.maxLon) / 1e7, float64(h.maxLat) / 1e7}
+	metadata["center"] = []float64{float64(h.centerLon) / 1e7, float64(h.centerLat) / 1e7, float64(h.centerZoom)}
+	if f, ok := pmtilesFormats[h.tileType]; ok {
+		metadata["format"] = f.String()
+	}
+	e, err := pmtilesEncoding(h.tileCompression)
+	if err != nil {
+		return nil, err
+	}
+	if e != IDENTITY {
+		metadata["compression"] = e.String()
+	} else {
+		delete(metadata, "compression")
+	}
+	return metadata, nil
+}
+
+func (p *pmtiles) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	// return a copy, as callers may modify it
+	metadata := make(map[string]interface{}, len(p.metadata))
+	for k, v := range p.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+func (p *pmtiles) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	id := pmtilesID(z, x, flipRow(z, y))
+	entries := p.root
+	// the directories are at most 4 levels deep
+	for depth := 0; depth < 4; depth++ {
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].tileID > id }) - 1
+		if i < 0 {
+			break
+		}
+		e := entries[i]
+		if e.runLength > 0 {
+			if id >= e.tileID+uint64(e.runLength) {
+				break
+			}
+			return p.readTile(e, data)
+		}
+		var err error
+		entries, err = p.leaf(e)
+		if err != nil {
+			return err
+		}
+	}
+	*data = nil
+	return ErrTileNotFound
+}
+
+// readTile reads the tile data of the entry e.
+func (p *pmtiles) readTile(e pmtilesEntry, data *[]byte) error {
+	b := make([]byte, e.length)
+	if _, err := p.f.ReadAt(b, int64(p.header.dataOffset+e.offset)); err != nil {
+		return err
+	}
+	*data = b
+	return nil
+}
+
+func (p *pmtiles) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	it := &pmtilesIterator{p: p, ctx: ctx}
+	if len(zooms) > 0 {
+		it.zooms = make(map[uint8]bool)
+		for _, z := range zooms {
+			it.zooms[z] = true
+		}
+	}
+	it.stack = []pmtilesFrame{{entries: p.root}}
+	return it
+}
+
+func (p *pmtiles) Close() error {
+	return p.f.Close()
+}
+
+// pmtilesFrame is a directory that is being iterated by a pmtilesIterator.
+type pmtilesFrame struct {
+	entries []pmtilesEntry
+	i       int
+}
+
+// pmtilesIterator iterates over the tiles of a pmtiles in the order of their
+// tile IDs, which orders them by zoom level.
+type pmtilesIterator struct {
+	p     *pmtiles
+	ctx   context.Context
+	zooms map[uint8]bool
+	stack []pmtilesFrame
+	entry pmtilesEntry // the current run of tiles
+	run   uint32       // the index of the current tile in the run
+	data  []byte       // the data of the current run
+	tile  Tile
+	err   error
+}
+
+func (it *pmtilesIterator) Next() bool {
+	for it.err == nil {
+		if it.run+1 < it.entry.runLength {
+			it.run++
+			z, x, y := pmtilesTile(it.entry.tileID + uint64(it.run))
+			if it.zooms != nil && !it.zooms[z] {
+				continue
+			}
+			it.tile = Tile{Z: z, X: x, Y: flipRow(z, y), Data: it.data}
+			return true
+		}
+		if len(it.stack) == 0 {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		f := &it.stack[len(it.stack)-1]
+		if f.i == len(f.entries) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		e := f.entries[f.i]
+		f.i++
+		if e.runLength == 0 {
+			entries, err := it.p.leaf(e)
+			if err != nil {
+				it.err = err
+				return false
+			}
+			it.stack = append(it.stack, pmtilesFrame{entries: entries})
+			continue
+		}
+		if err := it.p.readTile(e, &it.data); err != nil {
+			it.err = err
+			return false
+		}
+		// start the run before its first tile
+		it.entry, it.run = e, 0
+		it.entry.tileID--
+		it.entry.runLength++
+	}
+	return false
+}
+
+func (it *pmtilesIterator) Tile() Tile {
+	return it.tile
+}
+
+func (it *pmtilesIterator) Err() error {
+	return it.err
+}
+
+func (it *pmtilesIterator) Close() error {
+	it.stack = nil
+	it.entry = pmtilesEntry{}
+	return nil
+}
@@ -0,0 +1,134 @@
+package mbtiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+// createTestPMTiles creates a new PMTiles file in a temporary directory with
+// the given tiles and metadata and returns its path. All tiles are stored in
+// a single leaf directory, consecutive tiles with the same data in one run.
+func createTestPMTiles(t *testing.T, tiles map[[3]uint64][]byte, metadata map[string]interface{}) string {
+	ids := make([]uint64, 0, len(tiles))
+	byID := make(map[uint64][]byte)
+	var maxZoom uint8
+	for c, data := range tiles {
+		id := pmtilesID(uint8(c[0]), c[1], c[2])
+		ids = append(ids, id)
+		byID[id] = data
+		if uint8(c[0]) > maxZoom {
+			maxZoom = uint8(c[0])
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var data bytes.Buffer
+	var entries []pmtilesEntry
+	for _, id := range ids {
+		if n := len(entries); n > 0 {
+			last := &entries[n-1]
+			if last.tileID+uint64(last.runLength) == id && bytes.Equal(byID[id], byID[last.tileID]) {
+				last.runLength++
+				continue
+			}
+		}
+		entries = append(entries, pmtilesEntry{tileID: id, offset: uint64(data.Len()), length: uint32(len(byID[id])), runLength: 1})
+		data.Write(byID[id])
+	}
+	leaf := serializePMTilesDirectory(entries)
+	root := serializePMTilesDirectory([]pmtilesEntry{{tileID: ids[0], length: uint32(len(leaf))}})
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := pmtilesHeader{
+		internalCompression: 1, // none
+		tileCompression:     1,
+		tileType:            2, // png
+		maxZoom:             maxZoom,
+		maxLon:              1800000000,
+		maxLat:              850000000,
+	}
+	offset := uint64(pmtilesHeaderSize)
+	for _, section := range []struct {
+		offset, length *uint64
+		data           []byte
+	}{
+		{&h.rootOffset, &h.rootLength, root},
+		{&h.metadataOffset, &h.metadataLength, meta},
+		{&h.leafOffset, &h.leafLength, leaf},
+		{&h.dataOffset, &h.dataLength, data.Bytes()},
+	} {
+		*section.offset, *section.length = offset, uint64(len(section.data))
+		offset += uint64(len(section.data))
+	}
+	header := h.bytes()
+
+	filename := testFilename(t, "test.pmtiles")
+	file := bytes.Join([][]byte{header, root, meta, leaf, data.Bytes()}, nil)
+	if err := ioutil.WriteFile(filename, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestPMTilesID(t *testing.T) {
+	tests := []struct {
+		z    uint8
+		x, y uint64
+		id   uint64
+	}{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 0, 1, 2},
+		{1, 1, 1, 3},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{3, 0, 0, 21},
+		{12, 3423, 1763, 19078479},
+	}
+	for _, tc := range tests {
+		if id := pmtilesID(tc.z, tc.x, tc.y); id != tc.id {
+			t.Errorf("%d/%d/%d: expected ID %d, got %d", tc.z, tc.x, tc.y, tc.id, id)
+		}
+		if z, x, y := pmtilesTile(tc.id); z != tc.z || x != tc.x || y != tc.y {
+			t.Errorf("ID %d: expected %d/%d/%d, got %d/%d/%d", tc.id, tc.z, tc.x, tc.y, z, x, y)
+		}
+	}
+}
+
+func TestPMTilesRuns(t *testing.T) {
+	// the tiles with the IDs 1 and 2 are stored as one run
+	filename := createTestPMTiles(t, map[[3]uint64][]byte{
+		{1, 0, 0}: pngTile,
+		{1, 0, 1}: pngTile,
+		{1, 1, 1}: otherTile,
+	}, map[string]interface{}{"name": "runs"})
+
+	db, err := NewDB(filename, Scheme(TMS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	// the rows are counted from the south
+	for c, expected := range map[[3]uint64][]byte{
+		{1, 0, 1}: pngTile,
+		{1, 0, 0}: pngTile,
+		{1, 1, 0}: otherTile,
+	} {
+		if err := db.ReadTile(uint8(c[0]), c[1], c[2], &data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%v: expected %q, got %q", c, expected, data)
+		}
+	}
+	if err := db.ReadTile(1, 1, 1, &data); err != ErrTileNotFound {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
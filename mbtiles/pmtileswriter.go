@@ -0,0 +1,307 @@
+package mbtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxPMTilesRootSize is the maximum size of the header and the root directory
+// of a PMTiles file, which clients read with the first request.
+const maxPMTilesRootSize = 16384
+
+// PMTilesWriter creates a new PMTiles version 3 file. The tile data is
+// written to a temporary file next to it and deduplicated, only the directory
+// entries are kept in memory until the file is written on Close.
+type PMTilesWriter struct {
+	filename string
+	tmp      *os.File
+	size     uint64 // size of the tile data in tmp
+	scheme   TileScheme
+	entries  []pmtilesEntry // in the order the tiles were written
+	contents map[[sha256.Size]byte]pmtilesEntry
+	metadata map[string]interface{}
+	format   TileFormat
+	encoding TileEncoding
+}
+
+// CreatePMTiles creates a new PMTiles file at filename and returns a
+// PMTilesWriter for it. It is an error if the file already exists. The caller
+// must call Close on the returned PMTilesWriter to write the file. Of the
+// options opts, only Scheme affects the PMTilesWriter.
+func CreatePMTiles(filename string, opts ...Option) (*PMTilesWriter, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("file already exists: %s", filename)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".pmtiles-")
+	if err != nil {
+		return nil, err
+	}
+	return &PMTilesWriter{
+		filename: filename,
+		tmp:      tmp,
+		scheme:   o.scheme,
+		contents: make(map[[sha256.Size]byte]pmtilesEntry),
+		metadata: make(map[string]interface{}),
+	}, nil
+}
+
+// WriteTile adds the tile data at z, x, y, replacing any tile written before
+// at these coordinates. Like for ReadTile, y is the row in the TileScheme of
+// the PMTilesWriter, see the Scheme option. The format and compression of the
+// tiles are determined from the first tile.
+func (w *PMTilesWriter) WriteTile(z uint8, x uint64, y uint64, data []byte) error {
+	if z > 31 {
+		return fmt.Errorf("could not write tile for z=%d, x=%d, y=%d: zoom level too large", z, x, y)
+	}
+	if len(w.entries) == 0 {
+		w.encoding = detectTileEncoding(data)
+		w.format = PBF
+		if w.encoding == IDENTITY {
+			w.format, _ = detectTileFormat(&data)
+		}
+	}
+	if w.scheme == TMS {
+		y = flipRow(z, y)
+	}
+	e := pmtilesEntry{tileID: pmtilesID(z, x, y), runLength: 1}
+	sum := sha256.Sum256(data)
+	if c, ok := w.contents[sum]; ok {
+		e.offset, e.length = c.offset, c.length
+	} else {
+		if _, err := w.tmp.Write(data); err != nil {
+			return fmt.Errorf("could not write tile for z=%d, x=%d, y=%d: %v", z, x, y, err)
+		}
+		e.offset, e.length = w.size, uint32(len(data))
+		w.size += uint64(len(data))
+		w.contents[sum] = e
+	}
+	w.entries = append(w.entries, e)
+	return nil
+}
+
+// WriteMetadata sets a single metadata item, replacing an existing item of
+// the same name. The items "bounds" and "center" are expected to be of type
+// []float64 like those returned by ReadMetadata; they are stored in the
+// header like the zoom levels of the tiles. The "compression" item declares
+// the TileEncoding of the tiles, which cannot be detected for brotli. All
+// other items are stored as JSON.
+func (w *PMTilesWriter) WriteMetadata(name string, value interface{}) error {
+	w.metadata[name] = value
+	return nil
+}
+
+// Close writes the PMTiles file and removes the temporary file.
+func (w *PMTilesWriter) Close() error {
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+	if len(w.entries) == 0 {
+		return fmt.Errorf("could not write %s: no tiles", w.filename)
+	}
+	if name, ok := w.metadata["compression"].(string); ok {
+		e, ok := encodingFromName(name)
+		if !ok {
+			return fmt.Errorf("unknown compression in metadata: %q", name)
+		}
+		w.encoding = e
+	}
+	h := pmtilesHeader{internalCompression: 2, clustered: true}
+	var err error
+	if h.tileCompression, err = pmtilesCompression(w.encoding); err != nil {
+		return err
+	}
+	for t, f := range pmtilesFormats {
+		if f == w.format {
+			h.tileType = t
+		}
+	}
+
+	// order the entries by tile ID, keeping the last of duplicate tiles
+	sort.SliceStable(w.entries, func(i, j int) bool { return w.entries[i].tileID < w.entries[j].tileID })
+	entries := w.entries[:0]
+	for i, e := range w.entries {
+		if i+1 < len(w.entries) && w.entries[i+1].tileID == e.tileID {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	h.addressedTiles = uint64(len(entries))
+	h.minZoom, _, _ = pmtilesTile(entries[0].tileID)
+	h.maxZoom, _, _ = pmtilesTile(entries[len(entries)-1].tileID)
+
+	// lay out the tile data in the order of the tile IDs and merge runs of
+	// tiles with the same data
+	offsets := make(map[uint64]uint64) // new offsets by offset in tmp
+	var contents []pmtilesEntry        // tile data in tmp in the new order
+	var runs []pmtilesEntry
+	var dataLength uint64
+	for _, e := range entries {
+		offset, ok := offsets[e.offset]
+		if !ok {
+			offset = dataLength
+			offsets[e.offset] = offset
+			contents = append(contents, e)
+			dataLength += uint64(e.length)
+		}
+		if n := len(runs); n > 0 && runs[n-1].offset == offset && runs[n-1].tileID+uint64(runs[n-1].runLength) == e.tileID {
+			runs[n-1].runLength++
+			continue
+		}
+		runs = append(runs, pmtilesEntry{tileID: e.tileID, offset: offset, length: e.length, runLength: 1})
+	}
+	h.tileEntries = uint64(len(runs))
+	h.tileContents = uint64(len(contents))
+
+	root, leaves, err := pmtilesDirectories(runs)
+	if err != nil {
+		return err
+	}
+	metadata, err := w.header(&h)
+	if err != nil {
+		return err
+	}
+	h.rootOffset, h.rootLength = pmtilesHeaderSize, uint64(len(root))
+	h.metadataOffset, h.metadataLength = h.rootOffset+h.rootLength, uint64(len(metadata))
+	h.leafOffset, h.leafLength = h.metadataOffset+h.metadataLength, uint64(len(leaves))
+	h.dataOffset, h.dataLength = h.leafOffset+h.leafLength, dataLength
+
+	f, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = w.write(f, h, [][]byte{root, metadata, leaves}, contents)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(w.filename)
+		return fmt.Errorf("could not write %s: %v", w.filename, err)
+	}
+	return nil
+}
+
+// header sets the bounds and center of h from the metadata and returns the
+// compressed JSON metadata.
+func (w *PMTilesWriter) header(h *pmtilesHeader) ([]byte, error) {
+	e7 := func(v float64) int32 { return int32(math.Floor(v*1e7 + 0.5)) }
+	bounds := []float64{-180, -maxLatitude, 180, maxLatitude}
+	if b, ok := w.metadata["bounds"].([]float64); ok && len(b) == 4 {
+		bounds = b
+	}
+	h.minLon, h.minLat, h.maxLon, h.maxLat = e7(bounds[0]), e7(bounds[1]), e7(bounds[2]), e7(bounds[3])
+	center := []float64{(bounds[0] + bounds[2]) / 2, (bounds[1] + bounds[3]) / 2, float64(h.minZoom)}
+	if c, ok := w.metadata["center"].([]float64); ok && len(c) == 3 {
+		center = c
+	}
+	h.centerLon, h.centerLat, h.centerZoom = e7(center[0]), e7(center[1]), uint8(center[2])
+
+	metadata := make(map[string]interface{})
+	for k, v := range w.metadata {
+		switch k {
+		case "bounds", "center", "minzoom", "maxzoom", "compression":
+		default:
+			metadata[k] = v
+		}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode metadata: %v", err)
+	}
+	return gzipData(data)
+}
+
+// write writes the header h, the sections and the tile data of contents,
+// which are read from the temporary file, to f.
+func (w *PMTilesWriter) write(f *os.File, h pmtilesHeader, sections [][]byte, contents []pmtilesEntry) error {
+	if _, err := f.Write(h.bytes()); err != nil {
+		return err
+	}
+	for _, s := range sections {
+		if _, err := f.Write(s); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	for _, c := range contents {
+		if cap(buf) < int(c.length) {
+			buf = make([]byte, c.length)
+		}
+		buf = buf[:c.length]
+		if _, err := w.tmp.ReadAt(buf, int64(c.offset)); err != nil {
+			return err
+		}
+		if _, err := f.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pmtilesCompression returns the PMTiles compression of the TileEncoding e.
+func pmtilesCompression(e TileEncoding) (uint8, error) {
+	for c := uint8(1); c <= 4; c++ {
+		if pe, _ := pmtilesEncoding(c); pe == e {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("PMTiles do not support %s compressed tiles", e)
+}
+
+// gzipData returns data compressed with gzip.
+func gzipData(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// pmtilesDirectories returns the gzip compressed root directory and leaf
+// directories of the entries. The entries are split into leaf directories of
+// increasing size until the root directory fits into the first
+// maxPMTilesRootSize bytes of the file.
+func pmtilesDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+	root, err := gzipData(serializePMTilesDirectory(entries))
+	if err != nil {
+		return nil, nil, err
+	}
+	for leafSize := 4096; len(root) > maxPMTilesRootSize-pmtilesHeaderSize; leafSize *= 2 {
+		var leaves bytes.Buffer
+		var rootEntries []pmtilesEntry
+		for i := 0; i < len(entries); i += leafSize {
+			end := i + leafSize
+			if end > len(entries) {
+				end = len(entries)
+			}
+			leaf, err := gzipData(serializePMTilesDirectory(entries[i:end]))
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmtilesEntry{tileID: entries[i].tileID, offset: uint64(leaves.Len()), length: uint32(len(leaf))})
+			leaves.Write(leaf)
+		}
+		root, err = gzipData(serializePMTilesDirectory(rootEntries))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(root) <= maxPMTilesRootSize-pmtilesHeaderSize {
+			return root, leaves.Bytes(), nil
+		}
+	}
+	return root, nil, nil
+}
@@ -0,0 +1,200 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterTileStore(".postgis", openPostGIS)
+}
+
+// validTableName matches the names of tables, optionally qualified by their
+// schema, that can be used in queries without quoting.
+var validTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// postgisConfig is the content of a .postgis file, which describes a tileset
+// whose tiles are read from a database server.
+type postgisConfig struct {
+	// Driver is the name of the database/sql driver, "postgres" by default.
+	Driver string `json:"driver"`
+	// DSN is the data source name of the database, in which environment
+	// variables like $PGPASSWORD are expanded.
+	DSN string `json:"dsn"`
+	// Table is a table with the columns of the tiles table of the mbtiles
+	// specification, with rows counted from the south.
+	Table string `json:"table"`
+	// Query returns the data of the tile at the zoom level $1, column $2
+	// and row $3 counted from the north, like a query of ST_AsMVT.
+	Query string `json:"query"`
+	// Format and Compression are the format and encoding of the tiles,
+	// which are detected from the first tile of Table if they are empty.
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	MinZoom     *int   `json:"minzoom"`
+	MaxZoom     *int   `json:"maxzoom"`
+	// Metadata are additional metadata items of the tileset, like "name",
+	// "attribution", "bounds" or "vector_layers".
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// postgisStore is the TileStore of a tileset whose tiles are read from a table
+// or created by a query of a database server, typically PostGIS.
+type postgisStore struct {
+	db       *sql.DB
+	table    string
+	query    string
+	format   TileFormat
+	encoding TileEncoding
+	minZoom  uint8
+	maxZoom  uint8
+	metadata map[string]interface{}
+}
+
+// openPostGIS opens the .postgis file filename, which is a JSON object like
+//
+//	{
+//		"dsn": "postgres://tiles@localhost/osm?sslmode=disable",
+//		"query": "SELECT ST_AsMVT(q, 'roads') FROM (SELECT name, ST_AsMVTGeom(geom, ST_TileEnvelope($1, $2, $3)) AS geom FROM roads WHERE geom && ST_TileEnvelope($1, $2, $3)) AS q",
+//		"format": "pbf",
+//		"maxzoom": 14,
+//		"metadata": {"name": "Roads", "vector_layers": [{"id": "roads", "fields": {"name": "String"}}]}
+//	}
+//
+// The tiles are either created by the query or read from the table. The
+// driver, "postgres" by default, must be registered with database/sql by
+// importing it into the program.
+func openPostGIS(filename string) (TileStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c postgisConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse PostGIS tileset %s: %v", filename, err)
+	}
+	if (c.Table == "") == (c.Query == "") {
+		return nil, fmt.Errorf("PostGIS tileset %s requires either a table or a query", filename)
+	}
+	if c.Table != "" && !validTableName.MatchString(c.Table) {
+		return nil, fmt.Errorf("invalid table %q of PostGIS tileset %s", c.Table, filename)
+	}
+	if c.Query != "" && c.Format == "" {
+		return nil, fmt.Errorf("PostGIS tileset %s with a query requires a format", filename)
+	}
+	if c.Driver == "" {
+		c.Driver = "postgres"
+	}
+	p := &postgisStore{table: c.Table, query: c.Query, maxZoom: 22, metadata: make(map[string]interface{})}
+	if c.MinZoom != nil {
+		p.minZoom = uint8(*c.MinZoom)
+	}
+	if c.MaxZoom != nil {
+		p.maxZoom = uint8(*c.MaxZoom)
+	}
+	if (c.MinZoom != nil && *c.MinZoom < 0) || (c.MaxZoom != nil && *c.MaxZoom > 30) || p.minZoom > p.maxZoom {
+		return nil, fmt.Errorf("invalid zoom levels of PostGIS tileset %s", filename)
+	}
+	p.metadata["name"] = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	p.metadata["bounds"] = []float64{-180, -maxLatitude, 180, maxLatitude}
+	if err := addConfigMetadata(p.metadata, c.Metadata); err != nil {
+		return nil, err
+	}
+	p.metadata["minzoom"] = int(p.minZoom)
+	p.metadata["maxzoom"] = int(p.maxZoom)
+
+	if p.db, err = sql.Open(c.Driver, os.ExpandEnv(c.DSN)); err != nil {
+		return nil, fmt.Errorf("cannot open database of PostGIS tileset %s: %v", filename, err)
+	}
+	if err := p.db.Ping(); err != nil {
+		p.db.Close()
+		return nil, fmt.Errorf("cannot connect to database of PostGIS tileset %s: %v", filename, err)
+	}
+	if c.Format == "" {
+		declared := make(map[string]interface{})
+		if c.Compression != "" {
+			declared["compression"] = c.Compression
+		}
+		p.format, p.encoding, err = detectStoreFormat(filename, p, declared)
+		if err != nil {
+			p.db.Close()
+			return nil, err
+		}
+		return p, nil
+	}
+	var ok bool
+	if p.format, ok = formatFromName(c.Format); !ok {
+		p.db.Close()
+		return nil, fmt.Errorf("unknown format %q of PostGIS tileset %s", c.Format, filename)
+	}
+	if c.Compression != "" {
+		if p.encoding, ok = encodingFromName(strings.ToLower(c.Compression)); !ok {
+			p.db.Close()
+			return nil, fmt.Errorf("unknown compression %q of PostGIS tileset %s", c.Compression, filename)
+		}
+	}
+	return p, nil
+}
+
+func (p *postgisStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	if z < p.minZoom || z > p.maxZoom {
+		return ErrTileNotFound
+	}
+	var err error
+	if p.query != "" {
+		err = p.db.QueryRowContext(ctx, p.query, int(z), int64(x), int64(flipRow(z, y))).Scan(data)
+	} else {
+		err = p.db.QueryRowContext(ctx, "SELECT tile_data FROM "+p.table+" WHERE zoom_level = $1 AND tile_column = $2 AND tile_row = $3", int(z), int64(x), int64(y)).Scan(data)
+	}
+	if err == sql.ErrNoRows || (err == nil && len(*data) == 0) {
+		// queries of ST_AsMVT return empty tiles without features
+		return ErrTileNotFound
+	}
+	return err
+}
+
+func (p *postgisStore) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(p.metadata))
+	for k, v := range p.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// Tiles returns the tiles of the table. The tiles created by a query cannot be
+// listed.
+func (p *postgisStore) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	if p.table == "" {
+		return nil
+	}
+	q := "SELECT zoom_level, tile_column, tile_row, tile_data FROM " + p.table
+	if len(zooms) > 0 {
+		var levels []string
+		for _, z := range zooms {
+			levels = append(levels, strconv.Itoa(int(z)))
+		}
+		q += " WHERE zoom_level IN (" + strings.Join(levels, ",") + ")"
+	}
+	rows, err := p.db.QueryContext(ctx, q+" ORDER BY zoom_level")
+	return &sqlTiles{rows: rows, err: err}
+}
+
+func (p *postgisStore) TileFormat() (TileFormat, TileEncoding) {
+	return p.format, p.encoding
+}
+
+func (p *postgisStore) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *postgisStore) Close() error {
+	return p.db.Close()
+}
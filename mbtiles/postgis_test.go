@@ -0,0 +1,55 @@
+package mbtiles
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPostGIS(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{3, 1, 2}: pngTile,
+	}, map[string]string{"name": "test"})
+	dir := filepath.Dir(filename)
+
+	// the SQLite driver stands in for a PostgreSQL driver
+	for name, source := range map[string]string{
+		"table": `"table": "tiles"`,
+		"query": `"query": "SELECT tile_data FROM tiles WHERE zoom_level = $1 AND tile_column = $2 AND tile_row = (1 << $1) - 1 - $3", "format": "png"`,
+	} {
+		config := fmt.Sprintf(`{"driver": %q, "dsn": %q, %s, "maxzoom": 5, "metadata": {"attribution": "test"}}`, sqliteDriver, filename, source)
+		pgis := filepath.Join(dir, name+".postgis")
+		if err := ioutil.WriteFile(pgis, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		db, err := NewDB(pgis)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if db.TileFormat() != PNG {
+			t.Errorf("%s: expected PNG tiles, got %s", name, db.TileFormatString())
+		}
+		var data []byte
+		if err := db.ReadTile(3, 1, 2, &data); err != nil || !bytes.Equal(data, pngTile) {
+			t.Errorf("%s: expected tile, got %q (%v)", name, data, err)
+		}
+		if err := db.ReadTile(3, 2, 2, &data); err != ErrTileNotFound {
+			t.Errorf("%s: expected ErrTileNotFound, got %v", name, err)
+		}
+		metadata, err := db.ReadMetadata()
+		if err != nil || metadata["name"] != name || metadata["attribution"] != "test" || metadata["maxzoom"] != 5 {
+			t.Errorf("%s: unexpected metadata %v (%v)", name, metadata, err)
+		}
+		db.Close()
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.postgis"), []byte(`{"table": "tiles; DROP TABLE tiles"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(filepath.Join(dir, "invalid.postgis")); err == nil {
+		t.Error("expected error for invalid table name")
+	}
+}
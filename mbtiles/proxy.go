@@ -0,0 +1,377 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterTileStore(".proxy", openProxy)
+}
+
+// ProxyClient is the HTTP client with which the tiles of proxy tilesets are
+// fetched from their upstream servers.
+var ProxyClient = &http.Client{Timeout: 30 * time.Second}
+
+// maxProxyTileSize limits the size of tiles fetched from upstream servers.
+const maxProxyTileSize = 16 << 20
+
+// proxySchema contains the statements that create the table with the fetch
+// times and ETags of the tiles of the cache of a proxy tileset, in addition to
+// the tables of the mbtiles specification.
+var proxySchema = []string{
+	"CREATE TABLE IF NOT EXISTS proxy_tiles (zoom_level integer, tile_column integer, tile_row integer, fetched integer, etag text)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS proxy_tile_index ON proxy_tiles (zoom_level, tile_column, tile_row)",
+}
+
+// proxyConfig is the content of a .proxy file, which describes a tileset that
+// is fetched from an upstream server.
+type proxyConfig struct {
+	// URL is the template of the upstream tile URLs, see proxyURL.
+	URL string `json:"url"`
+	// Cache is the mbtiles file in which the fetched tiles are stored.
+	Cache string `json:"cache"`
+	// TTL is the time after which cached tiles are revalidated, like "24h".
+	// Tiles are never revalidated if it is empty.
+	TTL     string `json:"ttl"`
+	MinZoom *int   `json:"minzoom"`
+	MaxZoom *int   `json:"maxzoom"`
+	// Metadata are additional metadata items of the tileset, like "name",
+	// "attribution" or "bounds".
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// proxyStore is the TileStore of a tileset whose tiles are fetched from an
+// upstream server and written back to a cache in an mbtiles file, from which
+// they are served until they expire.
+type proxyStore struct {
+	url      string
+	ttl      time.Duration
+	minZoom  uint8
+	maxZoom  uint8
+	metadata map[string]interface{}
+	db       *sql.DB
+}
+
+// openProxy opens the .proxy file filename, which is a JSON object like
+//
+//	{
+//		"url": "https://tiles.example.com/{z}/{x}/{y}.png",
+//		"cache": "example-cache.mbtiles",
+//		"ttl": "24h",
+//		"maxzoom": 18,
+//		"metadata": {"name": "Example", "attribution": "© Example"}
+//	}
+//
+// The cache is created if it does not exist. Its filename defaults to that of
+// the .proxy file with the extension ".cache" and is relative to the
+// directory of filename. If the cache is empty, the tile at the minimum zoom
+// level in the center of the bounds is fetched, so that the tile format can
+// be determined.
+func openProxy(filename string) (TileStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c proxyConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse proxy tileset %s: %v", filename, err)
+	}
+	if c.URL == "" {
+		return nil, fmt.Errorf("proxy tileset %s has no url", filename)
+	}
+	p := &proxyStore{url: c.URL, maxZoom: 22, metadata: make(map[string]interface{})}
+	if c.TTL != "" {
+		if p.ttl, err = time.ParseDuration(c.TTL); err != nil {
+			return nil, fmt.Errorf("invalid ttl of proxy tileset %s: %v", filename, err)
+		}
+	}
+	if c.MinZoom != nil {
+		p.minZoom = uint8(*c.MinZoom)
+	}
+	if c.MaxZoom != nil {
+		p.maxZoom = uint8(*c.MaxZoom)
+	}
+	if (c.MinZoom != nil && *c.MinZoom < 0) || (c.MaxZoom != nil && *c.MaxZoom > 30) || p.minZoom > p.maxZoom {
+		return nil, fmt.Errorf("invalid zoom levels of proxy tileset %s", filename)
+	}
+	p.metadata["name"] = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	p.metadata["bounds"] = []float64{-180, -maxLatitude, 180, maxLatitude}
+	if err := addConfigMetadata(p.metadata, c.Metadata); err != nil {
+		return nil, err
+	}
+	p.metadata["minzoom"] = int(p.minZoom)
+	p.metadata["maxzoom"] = int(p.maxZoom)
+
+	cache := c.Cache
+	if cache == "" {
+		cache = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".cache"
+	} else if !filepath.IsAbs(cache) {
+		cache = filepath.Join(filepath.Dir(filename), cache)
+	}
+	if p.db, err = openProxyCache(cache, p.metadata["name"].(string)); err != nil {
+		return nil, fmt.Errorf("cannot open cache of proxy tileset %s: %v", filename, err)
+	}
+	var n int
+	if err := p.db.QueryRow("SELECT count(*) FROM (SELECT 1 FROM tiles WHERE length(tile_data) > 0 LIMIT 1)").Scan(&n); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if n == 0 {
+		var data []byte
+		z := p.minZoom
+		b, _ := p.metadata["bounds"].([]float64)
+		x, y := uint64(0), uint64(0)
+		if len(b) == 4 {
+			x, y = lonLatToTile((b[0]+b[2])/2, (b[1]+b[3])/2, z)
+		}
+		if err := p.ReadTile(context.Background(), z, x, y, &data); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("cannot fetch first tile of proxy tileset %s: %v", filename, err)
+		}
+	}
+	return p, nil
+}
+
+// addConfigMetadata adds the metadata items of the JSON configuration of a
+// tileset to metadata. String values are parsed like the items of the
+// metadata table.
+func addConfigMetadata(metadata, items map[string]interface{}) error {
+	for k, v := range items {
+		if s, ok := v.(string); ok {
+			if err := parseMetadataItem(metadata, k, s); err != nil {
+				return err
+			}
+		} else if b, ok := v.([]interface{}); ok && k == "bounds" && len(b) == 4 {
+			var bounds []float64
+			for _, f := range b {
+				if f, ok := f.(float64); ok {
+					bounds = append(bounds, f)
+				}
+			}
+			metadata[k] = bounds
+		} else {
+			metadata[k] = v
+		}
+	}
+	return nil
+}
+
+// openProxyCache opens the mbtiles file filename as cache of a proxy tileset,
+// which is created with the metadata item name if it does not exist.
+func openProxyCache(filename, name string) (*sql.DB, error) {
+	_, err := os.Stat(filename)
+	create := os.IsNotExist(err)
+	db, err := sql.Open(sqliteDSN(filename, []string{"busy_timeout = 5000", "journal_mode = WAL"}))
+	if err != nil {
+		return nil, err
+	}
+	stmts := proxySchema
+	if create {
+		stmts = append(append([]string{}, schema...), proxySchema...)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if create {
+		if _, err := db.Exec("INSERT INTO metadata (name, value) VALUES ('name', ?)", name); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// proxyURL returns the upstream URL of the tile at z, x, y, whose row is in
+// the TMS scheme, by replacing the placeholders {z}, {x} and {y} (with rows
+// counted from the north) or {-y} (counted from the south) of the template
+// u. The placeholders {TileMatrix}, {TileCol} and {TileRow} of WMTS
+// templates are replaced as well.
+func proxyURL(u string, z uint8, x, y uint64) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(int(z)),
+		"{x}", strconv.FormatUint(x, 10),
+		"{y}", strconv.FormatUint(flipRow(z, y), 10),
+		"{-y}", strconv.FormatUint(y, 10),
+		"{TileMatrix}", strconv.Itoa(int(z)),
+		"{TileCol}", strconv.FormatUint(x, 10),
+		"{TileRow}", strconv.FormatUint(flipRow(z, y), 10),
+	).Replace(u)
+}
+
+// ReadTile reads the tile from the cache, or fetches it from the upstream
+// server if it is not cached or has expired. Expired tiles are served from the
+// cache if the upstream server cannot be reached. Tiles that do not exist
+// upstream are cached as empty tiles.
+func (p *proxyStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	if z < p.minZoom || z > p.maxZoom {
+		return ErrTileNotFound
+	}
+	var (
+		cached  []byte
+		fetched int64
+		etag    sql.NullString
+	)
+	err := p.db.QueryRowContext(ctx, `SELECT t.tile_data, coalesce(f.fetched, 0), f.etag FROM tiles t
+		LEFT JOIN proxy_tiles f ON f.zoom_level = t.zoom_level AND f.tile_column = t.tile_column AND f.tile_row = t.tile_row
+		WHERE t.zoom_level = ? AND t.tile_column = ? AND t.tile_row = ?`, z, x, y).Scan(&cached, &fetched, &etag)
+	found := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if found && (p.ttl == 0 || time.Since(time.Unix(fetched, 0)) < p.ttl) {
+		return cachedTile(cached, data)
+	}
+	fresh, notModified, newTag, err := p.fetch(ctx, z, x, y, etag.String)
+	if err != nil {
+		if found {
+			// the expired tile is better than none while offline
+			return cachedTile(cached, data)
+		}
+		return err
+	}
+	if notModified {
+		fresh = cached
+		if newTag == "" {
+			newTag = etag.String
+		}
+	}
+	if err := p.store(ctx, z, x, y, fresh, newTag); err != nil {
+		return err
+	}
+	return cachedTile(fresh, data)
+}
+
+// cachedTile sets data to the cached tile, which is empty for tiles that do
+// not exist upstream.
+func cachedTile(cached []byte, data *[]byte) error {
+	if len(cached) == 0 {
+		return ErrTileNotFound
+	}
+	*data = cached
+	return nil
+}
+
+// fetch fetches the tile at z, x, y from the upstream server. If etag is not
+// empty, the tile is revalidated and notModified reports whether it has not
+// changed. The returned data is empty for tiles that do not exist upstream.
+func (p *proxyStore) fetch(ctx context.Context, z uint8, x, y uint64, etag string) (data []byte, notModified bool, newTag string, err error) {
+	req, err := http.NewRequest("GET", proxyURL(p.url, z, x, y), nil)
+	if err != nil {
+		return nil, false, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := ProxyClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, false, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyTileSize))
+		return data, false, resp.Header.Get("ETag"), err
+	case http.StatusNotModified:
+		return nil, true, resp.Header.Get("ETag"), nil
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, false, "", nil
+	}
+	return nil, false, "", fmt.Errorf("cannot fetch %s: %s", req.URL, resp.Status)
+}
+
+// store writes the tile data at z, x, y to the cache.
+func (p *proxyStore) store(ctx context.Context, z uint8, x, y uint64, data []byte, etag string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)", z, x, y, data); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO proxy_tiles (zoom_level, tile_column, tile_row, fetched, etag) VALUES (?, ?, ?, ?, ?)", z, x, y, time.Now().Unix(), etag); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *proxyStore) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(p.metadata))
+	for k, v := range p.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// Tiles returns the cached tiles.
+func (p *proxyStore) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	q := "SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles WHERE length(tile_data) > 0"
+	if len(zooms) > 0 {
+		var levels []string
+		for _, z := range zooms {
+			levels = append(levels, strconv.Itoa(int(z)))
+		}
+		q += " AND zoom_level IN (" + strings.Join(levels, ",") + ")"
+	}
+	rows, err := p.db.QueryContext(ctx, q+" ORDER BY zoom_level")
+	return &sqlTiles{rows: rows, err: err}
+}
+
+func (p *proxyStore) Close() error {
+	return p.db.Close()
+}
+
+// sqlTiles is the TileSource of the rows of a query for the zoom level,
+// column, row and data of tiles.
+type sqlTiles struct {
+	rows *sql.Rows
+	tile Tile
+	err  error
+}
+
+func (it *sqlTiles) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	var t Tile
+	if it.err = it.rows.Scan(&t.Z, &t.X, &t.Y, &t.Data); it.err != nil {
+		return false
+	}
+	it.tile = t
+	return true
+}
+
+func (it *sqlTiles) Tile() Tile {
+	return it.tile
+}
+
+func (it *sqlTiles) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *sqlTiles) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Close()
+}
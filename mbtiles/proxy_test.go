@@ -0,0 +1,108 @@
+package mbtiles
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		offline  bool
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.URL.Path)
+		switch {
+		case offline:
+			http.Error(w, "offline", http.StatusServiceUnavailable)
+		case r.URL.Path == "/5/0/0.png":
+			http.NotFound(w, r)
+		case r.Header.Get("If-None-Match") == `"v1"`:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(pngTile)
+		}
+	}))
+	defer upstream.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+
+	dir, err := ioutil.TempDir("", "mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "upstream.proxy")
+	config := fmt.Sprintf(`{"url": "%s/{z}/{x}/{y}.png", "ttl": "1ns", "maxzoom": 10, "metadata": {"attribution": "upstream"}}`, upstream.URL)
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.TileFormat() != PNG || count() != 1 {
+		t.Fatalf("expected the first tile to be fetched to detect PNG, got %s after %d requests", db.TileFormatString(), count())
+	}
+
+	var data []byte
+	if err := db.ReadTile(3, 1, 2, &data); err != nil || !bytes.Equal(data, pngTile) {
+		t.Errorf("expected fetched tile, got %q (%v)", data, err)
+	}
+	if requests[len(requests)-1] != "/3/1/2.png" {
+		t.Errorf("unexpected upstream request %s", requests[len(requests)-1])
+	}
+	if err := db.ReadTile(5, 0, 0, &data); err != ErrTileNotFound {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+	if err := db.ReadTile(11, 0, 0, &data); err != ErrTileNotFound || count() != 3 {
+		t.Errorf("expected ErrTileNotFound without request beyond maxzoom, got %v", err)
+	}
+
+	// expired tiles are revalidated, and served while offline
+	if err := db.ReadTile(3, 1, 2, &data); err != nil || !bytes.Equal(data, pngTile) || count() != 4 {
+		t.Errorf("expected revalidated tile, got %q (%v)", data, err)
+	}
+	mu.Lock()
+	offline = true
+	mu.Unlock()
+	if err := db.ReadTile(3, 1, 2, &data); err != nil || !bytes.Equal(data, pngTile) {
+		t.Errorf("expected cached tile while offline, got %q (%v)", data, err)
+	}
+	if err := db.ReadTile(4, 0, 0, &data); err == nil || err == ErrTileNotFound {
+		t.Errorf("expected upstream error for uncached tile, got %v", err)
+	}
+
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "upstream" || metadata["attribution"] != "upstream" || metadata["maxzoom"] != 10 {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+
+	// the cache is an mbtiles file with the fetched tiles
+	cache, err := NewDB(filepath.Join(dir, "upstream.cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if ok, err := cache.HasTile(3, 1, 2); !ok || err != nil {
+		t.Errorf("expected tile in cache, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,86 @@
+package mbtiles
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrQueryTimeout is returned by the reads of a DB that take longer than its
+// QueryTimeout.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// SlowQuery describes a read of a DB that took at least the threshold of the
+// SlowQueryLog option.
+type SlowQuery struct {
+	Filename string
+	// Query is the kind of the read: "tile", "hastile", "tileid", "grid"
+	// or "metadata".
+	Query string
+	// Z, X and Y are the coordinates of the tile or grid, with the row in
+	// the TileScheme of the DB. They are zero for metadata.
+	Z        uint8
+	X, Y     uint64
+	Duration time.Duration
+	// Err is the error of the read, if any, e.g. ErrQueryTimeout.
+	Err error
+}
+
+// QueryTimeout cancels the reads of tiles, grids and metadata that take
+// longer than d, which then fail with ErrQueryTimeout, e.g. so that a
+// corrupted index does not let requests hang. Reads are not limited by
+// default.
+func QueryTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = d
+	}
+}
+
+// SlowQueryLog calls log with every read of a tile, grid or metadata that
+// takes threshold or longer, including those that time out. It must be safe
+// to call log concurrently.
+func SlowQueryLog(threshold time.Duration, log func(SlowQuery)) Option {
+	return func(o *options) {
+		o.slowQuery = threshold
+		o.logSlowQuery = log
+	}
+}
+
+// queryLimits are the limits of the reads of a DB set by the QueryTimeout and
+// SlowQueryLog options.
+type queryLimits struct {
+	timeout   time.Duration
+	threshold time.Duration
+	logSlow   func(SlowQuery)
+}
+
+// limits returns the queryLimits of the options.
+func (o options) limits() queryLimits {
+	return queryLimits{timeout: o.queryTimeout, threshold: o.slowQuery, logSlow: o.logSlowQuery}
+}
+
+// startQuery starts a read of the kind query at z, x, y and returns its
+// context, which is cancelled after the QueryTimeout, and the function that
+// must be called with the result of the read. It returns the error that is
+// returned by the read.
+func (tileset *DB) startQuery(ctx context.Context, query string, z uint8, x, y uint64) (context.Context, func(error) error) {
+	l := tileset.limits
+	if l.timeout <= 0 && l.logSlow == nil {
+		return ctx, func(err error) error { return err }
+	}
+	cancel := func() {}
+	if l.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+	}
+	start := time.Now()
+	return ctx, func(err error) error {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = ErrQueryTimeout
+		}
+		cancel()
+		if d := time.Since(start); l.logSlow != nil && d >= l.threshold {
+			l.logSlow(SlowQuery{Filename: tileset.filename, Query: query, Z: z, X: x, Y: y, Duration: d, Err: err})
+		}
+		return err
+	}
+}
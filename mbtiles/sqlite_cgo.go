@@ -0,0 +1,51 @@
+//go:build !purego
+// +build !purego
+
+package mbtiles
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is the name of the database/sql driver of SQLite.
+const sqliteDriver = "sqlite3"
+
+var (
+	pragmaDriversMu sync.Mutex
+	pragmaDrivers   = make(map[string]string) // driver names by pragmas
+)
+
+// sqliteDSN returns the name of the driver and the data source name with
+// which the SQLite database dsn, a filename or a file: URI, is opened so that
+// the pragmas are executed on every new connection. The sqlite3 driver runs
+// them in a connect hook, so a driver is registered for each set of pragmas
+// on first use.
+func sqliteDSN(dsn string, pragmas []string) (string, string) {
+	if len(pragmas) == 0 {
+		return sqliteDriver, dsn
+	}
+	key := strings.Join(pragmas, "; ")
+	pragmaDriversMu.Lock()
+	defer pragmaDriversMu.Unlock()
+	if name, ok := pragmaDrivers[key]; ok {
+		return name, dsn
+	}
+	name := fmt.Sprintf("sqlite3_pragmas_%d", len(pragmaDrivers))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, p := range pragmas {
+				if _, err := conn.Exec("PRAGMA "+p, nil); err != nil {
+					return fmt.Errorf("could not set pragma %s: %v", p, err)
+				}
+			}
+			return nil
+		},
+	})
+	pragmaDrivers[key] = name
+	return name, dsn
+}
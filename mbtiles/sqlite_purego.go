@@ -0,0 +1,41 @@
+//go:build purego
+// +build purego
+
+package mbtiles
+
+import (
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is the name of the database/sql driver of SQLite.
+const sqliteDriver = "sqlite"
+
+// sqliteDSN returns the name of the driver and the data source name with
+// which the SQLite database dsn, a filename or a file: URI, is opened so that
+// the pragmas are executed on every new connection. The pure Go driver takes
+// them as _pragma parameters of the URI.
+func sqliteDSN(dsn string, pragmas []string) (string, string) {
+	if len(pragmas) == 0 {
+		return sqliteDriver, dsn
+	}
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + uriEscaper.Replace(dsn)
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	for _, p := range pragmas {
+		// "name = value" becomes "name(value)"
+		parts := strings.SplitN(p, " = ", 2)
+		if len(parts) == 2 {
+			p = parts[0] + "(" + parts[1] + ")"
+		}
+		dsn += sep + "_pragma=" + url.QueryEscape(p)
+		sep = "&"
+	}
+	return sqliteDriver, dsn
+}
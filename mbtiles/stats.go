@@ -0,0 +1,62 @@
+package mbtiles
+
+import (
+	"context"
+	"fmt"
+)
+
+// ZoomStats contains the statistics of the tiles of a single zoom level.
+type ZoomStats struct {
+	Zoom    uint8 `json:"zoom"`
+	Count   int64 `json:"count"`
+	Bytes   int64 `json:"bytes"`
+	MinSize int64 `json:"minSize"`
+	MaxSize int64 `json:"maxSize"`
+}
+
+// Stats contains the statistics of all tiles of a DB, and of each zoom level.
+type Stats struct {
+	Count   int64       `json:"count"`
+	Bytes   int64       `json:"bytes"`
+	MinSize int64       `json:"minSize"`
+	MaxSize int64       `json:"maxSize"`
+	Zooms   []ZoomStats `json:"zooms"`
+}
+
+// Stats returns the tile count, the total size and the minimum and maximum
+// size of the tiles in the DB, both in total and per zoom level. This
+// requires a full scan of the tiles table, so it may take a while for large
+// files.
+func (tileset *DB) Stats() (Stats, error) {
+	return tileset.StatsContext(context.Background())
+}
+
+// StatsContext is like Stats, but the query is cancelled as soon as ctx is
+// done.
+func (tileset *DB) StatsContext(ctx context.Context) (Stats, error) {
+	if tileset.store != nil {
+		return tileset.storeStats(ctx)
+	}
+	var s Stats
+	rows, err := tileset.db.QueryContext(ctx, "select zoom_level, count(*), sum(length(tile_data)), min(length(tile_data)), max(length(tile_data)) from tiles group by zoom_level order by zoom_level")
+	if err != nil {
+		return s, fmt.Errorf("could not query tile statistics: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var z ZoomStats
+		if err := rows.Scan(&z.Zoom, &z.Count, &z.Bytes, &z.MinSize, &z.MaxSize); err != nil {
+			return s, fmt.Errorf("could not read tile statistics: %v", err)
+		}
+		if len(s.Zooms) == 0 || z.MinSize < s.MinSize {
+			s.MinSize = z.MinSize
+		}
+		if z.MaxSize > s.MaxSize {
+			s.MaxSize = z.MaxSize
+		}
+		s.Count += z.Count
+		s.Bytes += z.Bytes
+		s.Zooms = append(s.Zooms, z)
+	}
+	return s, rows.Err()
+}
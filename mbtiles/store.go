@@ -0,0 +1,319 @@
+package mbtiles
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TileStore is a source of tiles other than an mbtiles file, like a PMTiles
+// file, which NewDB opens for the file extensions it is registered for with
+// RegisterTileStore, or a database, which is served by NewStoreDB. A DB of a
+// TileStore serves its tiles and metadata like those of an mbtiles file.
+// TileStores can provide UTF grids, their tile format and their modification
+// time by implementing GridStore, FormatStore and LastModifier.
+// The rows passed to and returned by a TileStore are in the TMS scheme.
+type TileStore interface {
+	// ReadTile reads the tile at z, x, y into data. It returns
+	// ErrTileNotFound if there is no such tile.
+	ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error
+	// Metadata returns the metadata of the tileset as returned by
+	// DB.ReadMetadata, including the "minzoom" and "maxzoom" items. The
+	// "compression" item declares the TileEncoding of the tiles if they are
+	// compressed. The read is cancelled as soon as ctx is done.
+	Metadata(ctx context.Context) (map[string]interface{}, error)
+	// Tiles returns the tiles at the zoom levels zooms, or at all zoom
+	// levels if zooms is empty, ordered by zoom level. TileStores that
+	// cannot list their tiles return nil.
+	Tiles(ctx context.Context, zooms []uint8) TileSource
+	// Close releases the resources of the TileStore.
+	Close() error
+}
+
+// GridStore is implemented by TileStores that have UTF grids.
+type GridStore interface {
+	// ReadGrid reads the gzip compressed UTF grid at z, x, y, including
+	// its key data, into data. It returns ErrGridNotFound if there is no
+	// such grid.
+	ReadGrid(ctx context.Context, z uint8, x, y uint64, data *[]byte) error
+}
+
+// FormatStore is implemented by TileStores that declare the format and
+// encoding of their tiles, which are otherwise detected from their first
+// tile. TileStores that cannot list their tiles must implement it.
+type FormatStore interface {
+	TileFormat() (TileFormat, TileEncoding)
+}
+
+// LastModifier is implemented by TileStores that know when their tiles have
+// last changed, which is used as TimeStamp of their DB if it is later than
+// the modification time of their file.
+type LastModifier interface {
+	LastModified() time.Time
+}
+
+// Pinger is implemented by TileStores that can check whether their tiles are
+// available, which is reported by DB.Ping.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// TileSource is a sequence of tiles, which is used like a TileIterator. The
+// rows of the tiles are in the TMS scheme.
+type TileSource interface {
+	Next() bool
+	Tile() Tile
+	Err() error
+	Close() error
+}
+
+// noTiles is the empty TileSource.
+type noTiles struct{}
+
+func (noTiles) Next() bool   { return false }
+func (noTiles) Tile() Tile   { return Tile{} }
+func (noTiles) Err() error   { return nil }
+func (noTiles) Close() error { return nil }
+
+// storeTileSource returns the tiles of store at the zoom levels zooms, which
+// are none if store cannot list its tiles.
+func storeTileSource(ctx context.Context, store TileStore, zooms []uint8) TileSource {
+	if src := store.Tiles(ctx, zooms); src != nil {
+		return src
+	}
+	return noTiles{}
+}
+
+// tileStores are the functions that open the TileStores of files by their
+// lower-case extension.
+var tileStores = make(map[string]func(filename string) (TileStore, error))
+
+// RegisterTileStore registers the function open, which opens the TileStore
+// of a file with the extension ext, e.g. ".pmtiles". RegisterTileStore is not
+// safe for concurrent use and is supposed to be called from an init function.
+func RegisterTileStore(ext string, open func(filename string) (TileStore, error)) {
+	tileStores[strings.ToLower(ext)] = open
+}
+
+// tilesetExt returns the lower-case extension of filename, which may be an
+// URL.
+func tilesetExt(filename string) string {
+	if IsRemote(filename) {
+		return strings.ToLower(path.Ext(strings.SplitN(filename, "?", 2)[0]))
+	}
+	return strings.ToLower(filepath.Ext(filename))
+}
+
+// IsTileset reports whether NewDB can open the file filename by its
+// extension, which is either ".mbtiles" or registered with
+// RegisterTileStore.
+func IsTileset(filename string) bool {
+	ext := tilesetExt(filename)
+	_, ok := tileStores[ext]
+	return ext == ".mbtiles" || ok
+}
+
+// newStoreDB returns the DB of the TileStore of filename, which is opened
+// with open.
+func newStoreDB(filename string, open func(string) (TileStore, error), o options) (*DB, error) {
+	store, err := open(filename)
+	if err != nil {
+		return nil, err
+	}
+	out, err := initStoreDB(filename, store, o)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if !IsRemote(filename) {
+		out.timestamp, err = fileTimestamp(filename)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// NewStoreDB returns a DB that serves the tiles of store, which is not backed
+// by a file, like a database server. The name identifies the tileset in
+// errors. Of the options opts, those that concern
+// mbtiles files do not apply. Closing the DB closes store.
+func NewStoreDB(name string, store TileStore, opts ...Option) (*DB, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	out, err := initStoreDB(name, store, o)
+	if err != nil {
+		return nil, err
+	}
+	out.external = true
+	return out, nil
+}
+
+// initStoreDB determines the tile format and encoding of store and returns
+// its DB.
+func initStoreDB(filename string, store TileStore, o options) (*DB, error) {
+	metadata, err := store.Metadata(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var tileformat TileFormat
+	var tileencoding TileEncoding
+	if f, ok := store.(FormatStore); ok {
+		tileformat, tileencoding = f.TileFormat()
+	} else if tileformat, tileencoding, err = detectStoreFormat(filename, store, metadata); err != nil {
+		return nil, err
+	}
+	out := &DB{
+		filename:     filename,
+		store:        store,
+		tileformat:   tileformat,
+		tileencoding: tileencoding,
+		scheme:       o.scheme,
+		limits:       o.limits(),
+	}
+	if _, ok := store.(GridStore); ok {
+		out.hasUTFGrid = true
+		out.utfgridCompression = GZIP
+	}
+	if c := o.tileCache(); c != nil {
+		out.cache, out.cacheID = c, c.register()
+	}
+	return out, nil
+}
+
+// detectStoreFormat detects the format and encoding of the tiles of store
+// from its first tile.
+func detectStoreFormat(filename string, store TileStore, metadata map[string]interface{}) (TileFormat, TileEncoding, error) {
+	src := storeTileSource(context.Background(), store, nil)
+	defer src.Close()
+	if !src.Next() {
+		if err := src.Err(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, fmt.Errorf("tileset %s has no tiles", filename)
+	}
+	data := src.Tile().Data
+
+	tileformat := PBF
+	tileencoding := IDENTITY
+	if name, ok := metadata["compression"].(string); ok {
+		e, ok := encodingFromName(strings.ToLower(strings.TrimSpace(name)))
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown compression in metadata: %q", name)
+		}
+		tileencoding = e
+	}
+	if tileencoding == IDENTITY {
+		tileencoding = detectTileEncoding(data)
+	}
+	if tileencoding == IDENTITY {
+		var err error
+		tileformat, err = detectTileFormat(&data)
+		if err != nil {
+			// fall back to the format declared by the TileStore
+			name, _ := metadata["format"].(string)
+			var ok bool
+			if tileformat, ok = formatFromName(name); !ok {
+				return 0, 0, err
+			}
+		}
+	}
+	return tileformat, tileencoding, nil
+}
+
+// storeTiles returns an iterator over the tiles of the TileStore of the DB
+// that match the filter f.
+func (tileset *DB) storeTiles(ctx context.Context, f TileFilter) (*TileIterator, error) {
+	it := &TileIterator{sel: f.Select, scheme: tileset.scheme}
+	if len(f.Bounds) > 0 {
+		if len(f.Bounds) != 4 {
+			return nil, fmt.Errorf("bounds must consist of 4 values, got %d", len(f.Bounds))
+		}
+		it.within = func(t Tile) bool {
+			// rows are counted from the south in the TMS scheme
+			xmin, ymin := lonLatToTile(f.Bounds[0], f.Bounds[1], t.Z)
+			xmax, ymax := lonLatToTile(f.Bounds[2], f.Bounds[3], t.Z)
+			return xmin <= t.X && t.X <= xmax && ymin <= t.Y && t.Y <= ymax
+		}
+	}
+	it.src = storeTileSource(ctx, tileset.store, f.Zooms)
+	return it, nil
+}
+
+// storeStats returns the statistics of the tiles of the TileStore of the DB,
+// which requires reading all tiles.
+func (tileset *DB) storeStats(ctx context.Context) (Stats, error) {
+	var s Stats
+	src := storeTileSource(ctx, tileset.store, nil)
+	defer src.Close()
+	for src.Next() {
+		t := src.Tile()
+		size := int64(len(t.Data))
+		if n := len(s.Zooms); n == 0 || s.Zooms[n-1].Zoom != t.Z {
+			s.Zooms = append(s.Zooms, ZoomStats{Zoom: t.Z, MinSize: size})
+		}
+		z := &s.Zooms[len(s.Zooms)-1]
+		z.Count++
+		z.Bytes += size
+		if size < z.MinSize {
+			z.MinSize = size
+		}
+		if size > z.MaxSize {
+			z.MaxSize = size
+		}
+		if s.Count == 0 || size < s.MinSize {
+			s.MinSize = size
+		}
+		if size > s.MaxSize {
+			s.MaxSize = size
+		}
+		s.Count++
+		s.Bytes += size
+	}
+	return s, src.Err()
+}
+
+// storeBounds computes the bounds of the tileset of the TileStore of the DB
+// from the extent of the tiles at its maximum zoom level.
+func (tileset *DB) storeBounds() ([]float64, error) {
+	metadata, err := tileset.store.Metadata(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	maxZoom, ok := metadata["maxzoom"].(int)
+	if !ok {
+		return nil, fmt.Errorf("cannot compute bounds without maximum zoom level")
+	}
+	src := storeTileSource(context.Background(), tileset.store, []uint8{uint8(maxZoom)})
+	defer src.Close()
+	var minX, maxX, minY, maxY uint64
+	n := 0
+	for ; src.Next(); n++ {
+		t := src.Tile()
+		if n == 0 || t.X < minX {
+			minX = t.X
+		}
+		if n == 0 || t.X > maxX {
+			maxX = t.X
+		}
+		if n == 0 || t.Y < minY {
+			minY = t.Y
+		}
+		if n == 0 || t.Y > maxY {
+			maxY = t.Y
+		}
+	}
+	if err := src.Err(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("cannot compute bounds of empty tileset")
+	}
+	return tmsBounds(uint8(maxZoom), minX, maxX, minY, maxY), nil
+}
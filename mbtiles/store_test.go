@@ -0,0 +1,201 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// renderStore renders its tiles on request and cannot list them.
+type renderStore struct {
+	modified time.Time
+	down     bool
+	// hung makes the reads of the metadata wait until they are cancelled.
+	hung bool
+}
+
+func (s *renderStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	if z > 4 {
+		return ErrTileNotFound
+	}
+	*data = pngTile
+	return nil
+}
+
+func (s *renderStore) ReadGrid(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	return ErrGridNotFound
+}
+
+func (s *renderStore) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	if s.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return map[string]interface{}{"name": "render", "minzoom": 0, "maxzoom": 4}, nil
+}
+
+func (s *renderStore) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	return nil
+}
+
+func (s *renderStore) TileFormat() (TileFormat, TileEncoding) {
+	return PNG, IDENTITY
+}
+
+func (s *renderStore) LastModified() time.Time {
+	return s.modified
+}
+
+func (s *renderStore) Ping(ctx context.Context) error {
+	if s.down {
+		return errors.New("down")
+	}
+	return nil
+}
+
+func (s *renderStore) Close() error {
+	return nil
+}
+
+func TestNewStoreDB(t *testing.T) {
+	store := &renderStore{modified: time.Now()}
+	db, err := NewStoreDB("render", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.TileFormat() != PNG || !db.HasUTFGrid() {
+		t.Errorf("expected PNG tiles with UTF grids, got %s, %v", db.TileFormatString(), db.HasUTFGrid())
+	}
+	var data []byte
+	if err := db.ReadTile(3, 1, 2, &data); err != nil || string(data) != string(pngTile) {
+		t.Errorf("expected rendered tile, got %q (%v)", data, err)
+	}
+	if err := db.ReadGrid(3, 1, 2, &data); err != ErrGridNotFound {
+		t.Errorf("expected ErrGridNotFound, got %v", err)
+	}
+	if n, err := db.Stats(); err != nil || n.Count != 0 {
+		t.Errorf("expected no listed tiles, got %d (%v)", n.Count, err)
+	}
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("expected available store, got %v", err)
+	}
+	store.down = true
+	if err := db.Ping(context.Background()); err == nil {
+		t.Error("expected Ping to fail for unavailable store")
+	}
+	store.modified = store.modified.Add(time.Hour)
+	if !db.TimeStamp().Equal(store.modified) {
+		t.Errorf("expected TimeStamp %v, got %v", store.modified, db.TimeStamp())
+	}
+}
+
+func TestStoreQueryTimeout(t *testing.T) {
+	var queries []SlowQuery
+	store := &renderStore{}
+	db, err := NewStoreDB("render", store, QueryTimeout(10*time.Millisecond), SlowQueryLog(0, func(q SlowQuery) {
+		queries = append(queries, q)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store.hung = true
+	if _, err := db.ReadMetadata(); err != ErrQueryTimeout {
+		t.Errorf("expected ErrQueryTimeout, got %v", err)
+	}
+	if len(queries) != 1 || queries[0].Query != "metadata" || queries[0].Err != ErrQueryTimeout {
+		t.Errorf("expected one slow metadata query, got %+v", queries)
+	}
+}
+
+func TestStores(t *testing.T) {
+	// tiles of the north eastern quadrant, which the GeoPackage covers
+	tiles := map[[3]uint64][]byte{
+		{1, 1, 0}: pngTile,
+		{2, 2, 1}: pngTile,
+		{2, 3, 0}: otherTile,
+	}
+	tests := []struct {
+		name     string
+		create   func(t *testing.T) string
+		metadata map[string]interface{}
+	}{
+		{"mbtiles", func(t *testing.T) string {
+			return createTestDB(t, tiles, map[string]string{"name": "test"})
+		}, map[string]interface{}{"name": "test", "maxzoom": 2}},
+		{"pmtiles", func(t *testing.T) string {
+			return createTestPMTiles(t, tiles, map[string]interface{}{"name": "test"})
+		}, map[string]interface{}{"name": "test", "maxzoom": 2, "format": "png"}},
+		{"gpkg", func(t *testing.T) string {
+			return createTestGeoPackage(t, tiles)
+		}, map[string]interface{}{"name": "Quadrant", "minzoom": 1, "maxzoom": 2}},
+		{"tiledir", func(t *testing.T) string {
+			files := map[string][]byte{"metadata.json": []byte(`{"name": "test"}`)}
+			for c, data := range tiles {
+				files[fmt.Sprintf("%d/%d/%d.png", c[0], c[1], c[2])] = data
+			}
+			return createTestTileDir(t, files)
+		}, map[string]interface{}{"name": "test", "maxzoom": 2, "format": "png"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := NewDB(tc.create(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if db.TileFormat() != PNG {
+				t.Errorf("expected PNG tiles, got %s", db.TileFormat())
+			}
+
+			var data []byte
+			for c, expected := range tiles {
+				if err := db.ReadTile(uint8(c[0]), c[1], c[2], &data); err != nil {
+					t.Errorf("%v: %v", c, err)
+				} else if !bytes.Equal(data, expected) {
+					t.Errorf("%v: expected %q, got %q", c, expected, data)
+				}
+			}
+			for _, c := range [][3]uint64{{0, 0, 0}, {1, 0, 0}, {2, 2, 0}} {
+				if err := db.ReadTile(uint8(c[0]), c[1], c[2], &data); err != ErrTileNotFound {
+					t.Errorf("%v: expected ErrTileNotFound, got %v", c, err)
+				}
+			}
+
+			metadata, err := db.ReadMetadata()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tc.metadata {
+				if metadata[k] != v {
+					t.Errorf("expected metadata %s %v, got %v", k, v, metadata[k])
+				}
+			}
+
+			it, err := db.Tiles(context.Background(), TileFilter{Zooms: []uint8{2}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer it.Close()
+			count := 0
+			for it.Next() {
+				tile := it.Tile()
+				c := [3]uint64{uint64(tile.Z), tile.X, tile.Y}
+				if !bytes.Equal(tile.Data, tiles[c]) {
+					t.Errorf("%v: expected %q, got %q", c, tiles[c], tile.Data)
+				}
+				count++
+			}
+			if err := it.Err(); err != nil {
+				t.Error(err)
+			}
+			if count != 2 {
+				t.Errorf("expected 2 tiles, got %d", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package mbtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tileDirExts are the TileFormats of the extensions of tile files in a
+// directory of tiles.
+var tileDirExts = map[string]TileFormat{
+	".png":  PNG,
+	".jpg":  JPG,
+	".jpeg": JPG,
+	".webp": WEBP,
+	".avif": AVIF,
+	".pbf":  PBF,
+	".mvt":  PBF,
+}
+
+// numberedEntries returns the entries of the directory dir whose names are
+// non-negative integers, optionally followed by an extension, ordered by
+// their numbers. The extension is ignored for directories.
+func numberedEntries(dir string) ([]os.FileInfo, []uint64, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []os.FileInfo
+	var numbers []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		n, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, info)
+		numbers = append(numbers, n)
+	}
+	sort.Sort(byNumber{entries, numbers})
+	return entries, numbers, nil
+}
+
+type byNumber struct {
+	entries []os.FileInfo
+	numbers []uint64
+}
+
+func (b byNumber) Len() int           { return len(b.entries) }
+func (b byNumber) Less(i, j int) bool { return b.numbers[i] < b.numbers[j] }
+func (b byNumber) Swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.numbers[i], b.numbers[j] = b.numbers[j], b.numbers[i]
+}
+
+// firstTileFile returns the extension of the first tile file found in the
+// zoom level directories of dir, like <dir>/3/4/2.png.
+func firstTileFile(dir string) (string, bool) {
+	zooms, _, err := numberedEntries(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, z := range zooms {
+		if !z.IsDir() {
+			continue
+		}
+		columns, _, err := numberedEntries(filepath.Join(dir, z.Name()))
+		if err != nil {
+			continue
+		}
+		for _, x := range columns {
+			if !x.IsDir() {
+				continue
+			}
+			rows, _, err := numberedEntries(filepath.Join(dir, z.Name(), x.Name()))
+			if err != nil {
+				continue
+			}
+			for _, y := range rows {
+				ext := strings.ToLower(filepath.Ext(y.Name()))
+				if _, ok := tileDirExts[ext]; ok && !y.IsDir() {
+					return ext, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// IsTileDir reports whether dir is a directory of tiles in a z/x/y file tree,
+// like <dir>/3/4/2.png, which NewDB can open.
+func IsTileDir(dir string) bool {
+	_, ok := firstTileFile(dir)
+	return ok
+}
+
+// tileDir is the TileStore of a directory of tiles. Their rows are counted
+// from the north, unless the directory contains the tilemapresource.xml file
+// of gdal2tiles, whose rows are counted from the south.
+type tileDir struct {
+	dir      string
+	ext      string // extension of the tile files
+	tms      bool   // rows are counted from the south
+	zooms    []uint8
+	metadata map[string]interface{}
+}
+
+// openTileDir opens the directory of tiles dir. The metadata items can be
+// provided in the file metadata.json in dir, which are read like those of
+// the metadata table of an mbtiles file; otherwise the directory name is used
+// as name and the bounds are derived from the tiles at the lowest zoom level.
+func openTileDir(dir string) (TileStore, error) {
+	ext, ok := firstTileFile(dir)
+	if !ok {
+		return nil, fmt.Errorf("no tiles found in %s", dir)
+	}
+	d := &tileDir{dir: dir, ext: ext}
+	if _, err := os.Stat(filepath.Join(dir, "tilemapresource.xml")); err == nil {
+		d.tms = true
+	}
+	zooms, numbers, err := numberedEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, z := range zooms {
+		if z.IsDir() && numbers[i] <= 30 {
+			d.zooms = append(d.zooms, uint8(numbers[i]))
+		}
+	}
+	if len(d.zooms) == 0 {
+		return nil, fmt.Errorf("no zoom levels found in %s", dir)
+	}
+
+	d.metadata = map[string]interface{}{
+		"name":   filepath.Base(dir),
+		"format": tileDirExts[ext].String(),
+	}
+	if err := d.readMetadata(); err != nil {
+		return nil, err
+	}
+	// the zoom levels of the files take precedence over the metadata
+	d.metadata["minzoom"] = int(d.zooms[0])
+	d.metadata["maxzoom"] = int(d.zooms[len(d.zooms)-1])
+	if _, ok := d.metadata["bounds"]; !ok {
+		bounds, err := d.bounds(d.zooms[0])
+		if err != nil {
+			return nil, err
+		}
+		d.metadata["bounds"] = bounds
+	}
+	return d, nil
+}
+
+// readMetadata reads the metadata items in the file metadata.json, if it
+// exists, into the metadata of d.
+func (d *tileDir) readMetadata() error {
+	data, err := ioutil.ReadFile(filepath.Join(d.dir, "metadata.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var items map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("cannot parse metadata.json of %s: %v", d.dir, err)
+	}
+	for k, v := range items {
+		if s, ok := v.(string); ok {
+			if err := parseMetadataItem(d.metadata, k, s); err != nil {
+				return err
+			}
+		} else {
+			d.metadata[k] = v
+		}
+	}
+	return nil
+}
+
+// bounds returns the bounds of the tiles at zoom level z.
+func (d *tileDir) bounds(z uint8) ([]float64, error) {
+	src := d.Tiles(context.Background(), []uint8{z})
+	defer src.Close()
+	var minX, maxX, minY, maxY uint64
+	n := 0
+	for ; src.Next(); n++ {
+		t := src.Tile()
+		if n == 0 || t.X < minX {
+			minX = t.X
+		}
+		if n == 0 || t.X > maxX {
+			maxX = t.X
+		}
+		if n == 0 || t.Y < minY {
+			minY = t.Y
+		}
+		if n == 0 || t.Y > maxY {
+			maxY = t.Y
+		}
+	}
+	if err := src.Err(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no tiles found in %s", d.dir)
+	}
+	return tmsBounds(z, minX, maxX, minY, maxY), nil
+}
+
+// row returns the row in the file tree of the row y in the TMS scheme.
+func (d *tileDir) row(z uint8, y uint64) uint64 {
+	if d.tms {
+		return y
+	}
+	return flipRow(z, y)
+}
+
+func (d *tileDir) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	name := fmt.Sprintf("%d/%d/%d%s", z, x, d.row(z, y), d.ext)
+	b, err := ioutil.ReadFile(filepath.Join(d.dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return ErrTileNotFound
+	}
+	if err != nil {
+		return err
+	}
+	*data = b
+	return nil
+}
+
+func (d *tileDir) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(d.metadata))
+	for k, v := range d.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+func (d *tileDir) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	it := &tileDirTiles{d: d, ctx: ctx}
+	for _, z := range d.zooms {
+		if len(zooms) == 0 || containsZoom(zooms, z) {
+			it.zooms = append(it.zooms, z)
+		}
+	}
+	return it
+}
+
+func (d *tileDir) Close() error {
+	return nil
+}
+
+// containsZoom reports whether zooms contains z.
+func containsZoom(zooms []uint8, z uint8) bool {
+	for _, zoom := range zooms {
+		if zoom == z {
+			return true
+		}
+	}
+	return false
+}
+
+// tileDirTiles is the TileSource of a tileDir. It lists one directory of a
+// column of tiles at a time.
+type tileDirTiles struct {
+	d       *tileDir
+	ctx     context.Context
+	zooms   []uint8  // the remaining zoom levels
+	columns []uint64 // the remaining columns of the current zoom level
+	rows    []uint64 // the remaining rows of the current column
+	z       uint8
+	x       uint64
+	tile    Tile
+	err     error
+}
+
+func (it *tileDirTiles) Next() bool {
+	for it.err == nil {
+		if len(it.rows) > 0 {
+			y := it.rows[0]
+			it.rows = it.rows[1:]
+			var data []byte
+			// the row in the file tree is mapped to the TMS scheme the same
+			// way as the other way around
+			if err := it.d.ReadTile(it.ctx, it.z, it.x, it.d.row(it.z, y), &data); err != nil {
+				if err == ErrTileNotFound {
+					continue
+				}
+				it.err = err
+				return false
+			}
+			it.tile = Tile{Z: it.z, X: it.x, Y: it.d.row(it.z, y), Data: data}
+			return true
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		if len(it.columns) > 0 {
+			it.x = it.columns[0]
+			it.columns = it.columns[1:]
+			it.rows = it.list(fmt.Sprintf("%d/%d", it.z, it.x), false)
+			continue
+		}
+		if len(it.zooms) == 0 {
+			return false
+		}
+		it.z = it.zooms[0]
+		it.zooms = it.zooms[1:]
+		it.columns = it.list(fmt.Sprint(it.z), true)
+	}
+	return false
+}
+
+// list returns the numbers of the column directories or tile files in the
+// directory name of the tileDir.
+func (it *tileDirTiles) list(name string, dirs bool) []uint64 {
+	entries, numbers, err := numberedEntries(filepath.Join(it.d.dir, filepath.FromSlash(name)))
+	if err != nil {
+		it.err = err
+		return nil
+	}
+	var out []uint64
+	for i, e := range entries {
+		if e.IsDir() != dirs || (!dirs && strings.ToLower(filepath.Ext(e.Name())) != it.d.ext) {
+			continue
+		}
+		out = append(out, numbers[i])
+	}
+	return out
+}
+
+func (it *tileDirTiles) Tile() Tile {
+	return it.tile
+}
+
+func (it *tileDirTiles) Err() error {
+	return it.err
+}
+
+func (it *tileDirTiles) Close() error {
+	it.zooms, it.columns, it.rows = nil, nil, nil
+	return nil
+}
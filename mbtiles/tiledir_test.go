@@ -0,0 +1,80 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestTileDir creates a directory of tiles in a temporary directory
+// with the given files and returns its path. The tiles are keyed by their
+// paths, like "1/0/1.png".
+func createTestTileDir(t *testing.T, files map[string][]byte) string {
+	dir := testFilename(t, "tiles")
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTileDirTMS(t *testing.T) {
+	// the tile at the north west of zoom level 1, with other files
+	dir := createTestTileDir(t, map[string][]byte{
+		"1/0/1.png":           otherTile,
+		"1/0/notes.txt":       []byte("not a tile"),
+		"metadata.json":       []byte(`{"name": "test", "center": "0,0,1"}`),
+		"tilemapresource.xml": []byte("<TileMap/>"),
+	})
+	if !IsTileDir(dir) || IsTileDir(filepath.Dir(dir)) {
+		t.Error("IsTileDir detected the wrong directory")
+	}
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var data []byte
+	if err := db.ReadTile(1, 0, 0, &data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, otherTile) {
+		t.Errorf("expected %q, got %q", otherTile, data)
+	}
+
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	center, _ := metadata["center"].([]float64)
+	bounds, _ := metadata["bounds"].([]float64)
+	if len(center) != 3 || len(bounds) != 4 {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+
+	it, err := db.Tiles(context.Background(), TileFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var tiles []string
+	for it.Next() {
+		tile := it.Tile()
+		tiles = append(tiles, fmt.Sprintf("%d/%d/%d", tile.Z, tile.X, tile.Y))
+	}
+	if err := it.Err(); err != nil {
+		t.Error(err)
+	}
+	if fmt.Sprint(tiles) != "[1/0/0]" {
+		t.Errorf("unexpected tiles %v", tiles)
+	}
+}
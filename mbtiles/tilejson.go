@@ -0,0 +1,73 @@
+package mbtiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TileJSONVersion is the version of the TileJSON specification that is
+// implemented by DB.TileJSON.
+const TileJSONVersion = "3.0.0"
+
+// TileJSON returns a TileJSON document that describes the tileset, which is
+// served with tiles at "<baseURL>/tiles/{z}/{x}/{y}.<format>" in the
+// TileScheme of the DB. UTF grids are expected at "<baseURL>/tiles/{z}/{x}/{y}.json".
+// Metadata items that are not defined by the TileJSON specification are
+// included as well, except those that are only meaningful to TileMill.
+func (tileset *DB) TileJSON(baseURL string) (map[string]interface{}, error) {
+	metadata, err := tileset.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return NewTileJSON(metadata, tileset.tileformat, tileset.scheme, tileset.hasUTFGrid, baseURL), nil
+}
+
+// NewTileJSON returns the TileJSON document of a tileset with the metadata
+// and tiles of the format tf in the given scheme like DB.TileJSON, which
+// announces UTF grids if grids is true.
+func NewTileJSON(metadata map[string]interface{}, tf TileFormat, scheme TileScheme, grids bool, baseURL string) map[string]interface{} {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	format := tf.String()
+	out := map[string]interface{}{
+		"tilejson": TileJSONVersion,
+		"scheme":   scheme.String(),
+		"format":   format,
+		"tiles":    []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.%s", baseURL, format)},
+	}
+	for k, v := range metadata {
+		switch k {
+		// strip out values above
+		case "tilejson", "scheme", "format", "tiles":
+			continue
+
+		// strip out values that are not supported or are overridden below
+		case "grids", "interactivity", "modTime":
+			continue
+
+		// strip out values that come from TileMill but aren't useful here
+		case "metatile", "scale", "autoscale", "_updated", "Layer", "Stylesheet":
+			continue
+
+		case "fillzoom":
+			if s, ok := v.(string); ok {
+				if z, err := strconv.Atoi(s); err == nil {
+					out[k] = z
+				}
+				continue
+			}
+			out[k] = v
+
+		default:
+			out[k] = v
+		}
+	}
+	// vector_layers is required for vector tilesets
+	if _, ok := out["vector_layers"]; !ok && tf == PBF {
+		out["vector_layers"] = []interface{}{}
+	}
+	if grids {
+		out["grids"] = []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.json", baseURL)}
+	}
+	return out
+}
@@ -0,0 +1,244 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Tile is a single tile of a DB. Y is the row in the TileScheme of the DB.
+type Tile struct {
+	Z    uint8
+	X, Y uint64
+	Data []byte
+}
+
+// TileFilter restricts the tiles that are returned by DB.Tiles. The zero
+// value does not restrict the tiles at all.
+type TileFilter struct {
+	// Zooms are the zoom levels of the returned tiles. All zoom levels are
+	// returned if it is empty.
+	Zooms []uint8
+	// Bounds is the geographic bounding box as west, south, east, north in
+	// WGS84 degrees that the returned tiles must intersect. It is ignored
+	// if it is empty.
+	Bounds []float64
+	// Select, if not nil, is called with the coordinates of each tile that
+	// matches the filter otherwise, with the row in the XYZ scheme, and
+	// reports whether it is returned.
+	Select func(z uint8, x, y uint64) bool
+}
+
+// ZoomRange returns the zoom levels from min to max, inclusive.
+func ZoomRange(min, max uint8) []uint8 {
+	var zooms []uint8
+	for z := int(min); z <= int(max); z++ {
+		zooms = append(zooms, uint8(z))
+	}
+	return zooms
+}
+
+// TileRange returns the columns and rows in the TileScheme s of the tiles at
+// zoom level z that intersect the bounds of the filter f, or of all tiles at z
+// if f has no bounds.
+func TileRange(f TileFilter, z uint8, s TileScheme) (minX, maxX, minY, maxY uint64) {
+	if len(f.Bounds) != 4 {
+		return 0, (1 << z) - 1, 0, (1 << z) - 1
+	}
+	// rows are counted from the south in the TMS scheme
+	minX, minY = lonLatToTile(f.Bounds[0], f.Bounds[1], z)
+	maxX, maxY = lonLatToTile(f.Bounds[2], f.Bounds[3], z)
+	if s != TMS {
+		minY, maxY = flipRow(z, maxY), flipRow(z, minY)
+	}
+	return minX, maxX, minY, maxY
+}
+
+// CountTiles returns the number of tiles at the zoom levels of the filter f
+// that intersect its bounds. The zoom levels of f must not be empty.
+func CountTiles(f TileFilter) uint64 {
+	var n uint64
+	for _, z := range f.Zooms {
+		minX, maxX, minY, maxY := TileRange(f, z, TMS)
+		n += (maxX - minX + 1) * (maxY - minY + 1)
+	}
+	return n
+}
+
+// TileIterator iterates over the tiles of a DB. Its usage is similar to
+// that of sql.Rows:
+//
+//	it, err := db.Tiles(ctx, filter)
+//	if err != nil { ... }
+//	defer it.Close()
+//	for it.Next() {
+//		t := it.Tile()
+//		...
+//	}
+//	err = it.Err()
+type TileIterator struct {
+	rows   *sql.Rows
+	src    TileSource      // instead of rows for a TileStore
+	within func(Tile) bool // filter of the tiles of src, if not nil
+	sel    func(z uint8, x, y uint64) bool
+	tile   Tile
+	err    error
+	scheme TileScheme
+}
+
+// Next prepares the next tile for reading with Tile. It returns false if
+// there are no more tiles or an error occurred, use Err to tell them apart.
+func (it *TileIterator) Next() bool {
+	for it.next() {
+		t := it.tile
+		if it.sel == nil {
+			return true
+		}
+		y := t.Y
+		if it.scheme == TMS {
+			y = flipRow(t.Z, y)
+		}
+		if it.sel(t.Z, t.X, y) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *TileIterator) next() bool {
+	if it.err != nil {
+		return false
+	}
+	var t Tile
+	if it.src != nil {
+		for {
+			if !it.src.Next() {
+				return false
+			}
+			if t = it.src.Tile(); it.within == nil || it.within(t) {
+				break
+			}
+		}
+	} else {
+		if !it.rows.Next() {
+			return false
+		}
+		if err := it.rows.Scan(&t.Z, &t.X, &t.Y, &t.Data); err != nil {
+			it.err = fmt.Errorf("could not read tile: %v", err)
+			return false
+		}
+	}
+	if it.scheme != TMS {
+		t.Y = flipRow(t.Z, t.Y)
+	}
+	it.tile = t
+	return true
+}
+
+// Tile returns the current tile.
+func (it *TileIterator) Tile() Tile {
+	return it.tile
+}
+
+// Err returns the error, if any, that was encountered during iteration.
+func (it *TileIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.src != nil {
+		return it.src.Err()
+	}
+	return it.rows.Err()
+}
+
+// Close stops the iteration. It is safe to call Close multiple times.
+func (it *TileIterator) Close() error {
+	if it.src != nil {
+		return it.src.Close()
+	}
+	return it.rows.Close()
+}
+
+// Tiles returns an iterator over all tiles of the DB that match the filter
+// f, ordered by zoom level, column and row. The tiles of a TileStore are only
+// ordered by zoom level. The query is cancelled as soon as ctx is done.
+func (tileset *DB) Tiles(ctx context.Context, f TileFilter) (*TileIterator, error) {
+	if tileset.store != nil {
+		return tileset.storeTiles(ctx, f)
+	}
+	where, args, err := tileset.tileFilterClause(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	q := "select zoom_level, tile_column, tile_row, tile_data from tiles" + where + " order by zoom_level, tile_column, tile_row"
+	rows, err := tileset.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query tiles: %v", err)
+	}
+	return &TileIterator{rows: rows, sel: f.Select, scheme: tileset.scheme}, nil
+}
+
+// tileFilterClause returns the SQL WHERE clause and its arguments for the
+// tiles table that implements f.
+func (tileset *DB) tileFilterClause(ctx context.Context, f TileFilter) (string, []interface{}, error) {
+	if len(f.Bounds) == 0 {
+		if len(f.Zooms) == 0 {
+			return "", nil, nil
+		}
+		var (
+			placeholders []string
+			args         []interface{}
+		)
+		for _, z := range f.Zooms {
+			placeholders = append(placeholders, "?")
+			args = append(args, z)
+		}
+		return " where zoom_level in (" + strings.Join(placeholders, ", ") + ")", args, nil
+	}
+
+	if len(f.Bounds) != 4 {
+		return "", nil, fmt.Errorf("bounds must consist of 4 values, got %d", len(f.Bounds))
+	}
+	zooms := f.Zooms
+	if len(zooms) == 0 {
+		var err error
+		zooms, err = tileset.zoomLevels(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	var (
+		clauses []string
+		args    []interface{}
+	)
+	for _, z := range zooms {
+		// rows are counted from the south in the TMS scheme
+		xmin, ymin := lonLatToTile(f.Bounds[0], f.Bounds[1], z)
+		xmax, ymax := lonLatToTile(f.Bounds[2], f.Bounds[3], z)
+		clauses = append(clauses, "(zoom_level = ? and tile_column between ? and ? and tile_row between ? and ?)")
+		args = append(args, z, xmin, xmax, ymin, ymax)
+	}
+	if len(clauses) == 0 {
+		return " where 0", nil, nil
+	}
+	return " where " + strings.Join(clauses, " or "), args, nil
+}
+
+// zoomLevels returns the distinct zoom levels of the tiles in the DB.
+func (tileset *DB) zoomLevels(ctx context.Context) ([]uint8, error) {
+	rows, err := tileset.db.QueryContext(ctx, "select distinct zoom_level from tiles order by zoom_level")
+	if err != nil {
+		return nil, fmt.Errorf("could not query zoom levels: %v", err)
+	}
+	defer rows.Close()
+	var zooms []uint8
+	for rows.Next() {
+		var z uint8
+		if err := rows.Scan(&z); err != nil {
+			return nil, fmt.Errorf("could not read zoom level: %v", err)
+		}
+		zooms = append(zooms, z)
+	}
+	return zooms, rows.Err()
+}
@@ -2,6 +2,7 @@ package mbtiles
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -20,3 +21,35 @@ func stringToFloats(str string) ([]float64, error) {
 	}
 	return out, nil
 }
+
+// maxLatitude is the maximum latitude that can be represented in web mercator.
+const maxLatitude = 85.0511287798066
+
+// lonLatToTile returns the column and row of the tile at zoom level z in the
+// TMS scheme of the mbtiles specification which contains the point at
+// longitude lon and latitude lat.
+func lonLatToTile(lon, lat float64, z uint8) (uint64, uint64) {
+	lat = math.Max(-maxLatitude, math.Min(maxLatitude, lat))
+	lon = math.Max(-180, math.Min(180, lon))
+	n := math.Exp2(float64(z))
+	x := math.Floor((lon + 180) / 360 * n)
+	latRad := lat * math.Pi / 180
+	y := math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n)
+	// points on the east and south edges belong to the last tile
+	x = math.Min(x, n-1)
+	y = math.Min(y, n-1)
+	return uint64(x), uint64(n - 1 - y)
+}
+
+// tileLon returns the longitude of the western edge of the tile column x at
+// zoom level z.
+func tileLon(z uint8, x uint64) float64 {
+	return float64(x)/math.Exp2(float64(z))*360 - 180
+}
+
+// tileLat returns the latitude of the northern edge of the tile row y at zoom
+// level z. Unlike in the TMS scheme, rows are counted from the north.
+func tileLat(z uint8, y uint64) float64 {
+	n := math.Exp2(float64(z))
+	return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+}
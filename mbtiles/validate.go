@@ -0,0 +1,434 @@
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Severity tells whether a Violation breaks a requirement (MUST) or a
+// recommendation (SHOULD) of the mbtiles specification.
+type Severity uint8
+
+const (
+	// SeverityError is a violation of a requirement. Clients may fail to
+	// read the file.
+	SeverityError Severity = iota
+	// SeverityWarning is a violation of a recommendation.
+	SeverityWarning
+)
+
+// String returns "error" or "warning".
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText encodes the severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Violation is a single problem of an mbtiles file that is reported by
+// Validate.
+type Violation struct {
+	Severity Severity `json:"severity"`
+	// Check is the part of the file that was checked: "schema",
+	// "metadata", "format", "zoom", "bounds" or "tiles".
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// String returns the violation as "<severity>: <check>: <message>".
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Severity, v.Check, v.Message)
+}
+
+// validator collects the violations of an mbtiles file.
+type validator struct {
+	ctx        context.Context
+	db         *sql.DB
+	violations []Violation
+}
+
+func (v *validator) errorf(check, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{SeverityError, check, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(check, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{SeverityWarning, check, fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the mbtiles file at filename against version 1.3 of the
+// mbtiles specification and returns the violations it found, errors before
+// warnings. It checks the schema of the tables, the metadata items, whether
+// the tiles match the format item, whether the zoom levels are continuous and
+// whether the bounds and center are sane. Unlike NewDB, it also reads files
+// that are too broken to be served. The returned error is only non-nil if the
+// file could not be read at all.
+func Validate(ctx context.Context, filename string) ([]Violation, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(options{readOnly: true}.driverAndDSN(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	v := &validator{ctx: ctx, db: db}
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+	var sorted []Violation
+	for _, s := range []Severity{SeverityError, SeverityWarning} {
+		for _, violation := range v.violations {
+			if violation.Severity == s {
+				sorted = append(sorted, violation)
+			}
+		}
+	}
+	return sorted, nil
+}
+
+func (v *validator) validate() error {
+	ok, err := v.checkSchema()
+	if err != nil || !ok {
+		return err
+	}
+	metadata, err := v.checkMetadata()
+	if err != nil {
+		return err
+	}
+	zooms, err := v.checkTiles(metadata)
+	if err != nil {
+		return err
+	}
+	v.checkZooms(metadata, zooms)
+	v.checkBounds(metadata, zooms)
+	return nil
+}
+
+// checkSchema reports missing tables and columns and returns whether the
+// metadata and tiles can be queried.
+func (v *validator) checkSchema() (bool, error) {
+	ok := true
+	for _, t := range []struct {
+		name    string
+		columns []string
+	}{
+		{"metadata", []string{"name", "value"}},
+		{"tiles", []string{"zoom_level", "tile_column", "tile_row", "tile_data"}},
+	} {
+		var kind string
+		err := v.db.QueryRowContext(v.ctx, "SELECT type FROM sqlite_master WHERE name = ? AND type IN ('table', 'view')", t.name).Scan(&kind)
+		if err == sql.ErrNoRows {
+			v.errorf("schema", "missing table %s", t.name)
+			ok = false
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not read schema: %v", err)
+		}
+		columns, err := v.columns(t.name)
+		if err != nil {
+			return false, err
+		}
+		for _, c := range t.columns {
+			if !columns[c] {
+				v.errorf("schema", "missing column %s of %s %s", c, kind, t.name)
+				ok = false
+			}
+		}
+		if kind != "table" {
+			continue
+		}
+		unique, err := v.hasUniqueIndex(t.name, t.columns[:len(t.columns)-1])
+		if err != nil {
+			return false, err
+		}
+		if !unique {
+			v.warnf("schema", "missing unique index on %s (%s)", t.name, strings.Join(t.columns[:len(t.columns)-1], ", "))
+		}
+	}
+	return ok, nil
+}
+
+// columns returns the names of the columns of the table or view name.
+func (v *validator) columns(name string) (map[string]bool, error) {
+	rows, err := v.db.QueryContext(v.ctx, "SELECT name FROM pragma_table_info(?)", name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read columns of %s: %v", name, err)
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, fmt.Errorf("could not read columns of %s: %v", name, err)
+		}
+		columns[c] = true
+	}
+	return columns, rows.Err()
+}
+
+// hasUniqueIndex reports whether the table has a unique index on exactly the
+// given columns.
+func (v *validator) hasUniqueIndex(table string, columns []string) (bool, error) {
+	rows, err := v.db.QueryContext(v.ctx, `SELECT l.name, group_concat(i.name) FROM pragma_index_list(?) l, pragma_index_info(l.name) i
+		WHERE l."unique" GROUP BY l.name`, table)
+	if err != nil {
+		return false, fmt.Errorf("could not read indices of %s: %v", table, err)
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var name, indexed string
+		if err := rows.Scan(&name, &indexed); err != nil {
+			return false, fmt.Errorf("could not read indices of %s: %v", table, err)
+		}
+		if len(strings.Split(indexed, ",")) != len(columns) {
+			continue
+		}
+		found = true
+		for _, c := range columns {
+			if !strings.Contains(","+indexed+",", ","+c+",") {
+				found = false
+			}
+		}
+		if found {
+			break
+		}
+	}
+	return found, rows.Err()
+}
+
+// checkMetadata reports missing, duplicated and malformed metadata items and
+// returns the parsed items.
+func (v *validator) checkMetadata() (map[string]interface{}, error) {
+	rows, err := v.db.QueryContext(v.ctx, "SELECT name, value FROM metadata")
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata: %v", err)
+	}
+	defer rows.Close()
+	metadata := make(map[string]interface{})
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var name, value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("could not read metadata: %v", err)
+		}
+		if seen[name.String] {
+			v.errorf("metadata", "duplicate item %s", name.String)
+		}
+		seen[name.String] = true
+		if name.String == "json" {
+			v.checkJSON(metadata, value.String)
+			continue
+		}
+		if err := parseMetadataItem(metadata, name.String, value.String); err != nil {
+			v.errorf("metadata", "%v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read metadata: %v", err)
+	}
+
+	for _, name := range []string{"name", "format"} {
+		if !seen[name] {
+			v.errorf("metadata", "missing required item %s", name)
+		}
+	}
+	for _, name := range []string{"bounds", "center", "minzoom", "maxzoom"} {
+		if !seen[name] {
+			v.warnf("metadata", "missing recommended item %s", name)
+		}
+	}
+	if t, ok := metadata["type"].(string); ok && t != "overlay" && t != "baselayer" {
+		v.warnf("metadata", "type must be overlay or baselayer, got %q", t)
+	}
+	if version, ok := metadata["version"].(string); ok {
+		if _, err := strconv.ParseFloat(version, 64); err != nil {
+			v.warnf("metadata", "version must be a number, got %q", version)
+		}
+	}
+	if metadata["format"] == "pbf" {
+		if _, ok := metadata["vector_layers"].([]interface{}); !ok {
+			v.errorf("metadata", "missing vector_layers in json item, which is required for pbf tiles")
+		}
+	}
+	return metadata, nil
+}
+
+// checkJSON parses the json metadata item into metadata.
+func (v *validator) checkJSON(metadata map[string]interface{}, value string) {
+	var items map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &items); err != nil {
+		v.errorf("metadata", "json item is not a JSON object: %v", err)
+		return
+	}
+	for k, item := range items {
+		metadata[k] = item
+	}
+}
+
+// tileExtent is the extent of the tiles of a zoom level in TMS rows.
+type tileExtent struct {
+	zoom                   uint8
+	minX, maxX, minY, maxY uint64
+}
+
+// checkTiles reports tiles outside of the tile grid, empty tiles and tiles
+// that do not match the format item, and returns the extents of the zoom
+// levels.
+func (v *validator) checkTiles(metadata map[string]interface{}) ([]tileExtent, error) {
+	var outside, empty int64
+	err := v.db.QueryRowContext(v.ctx, `SELECT
+		coalesce(sum(zoom_level < 0 OR zoom_level > 30 OR tile_column < 0 OR tile_row < 0
+			OR tile_column >= (1 << zoom_level) OR tile_row >= (1 << zoom_level)), 0),
+		coalesce(sum(tile_data IS NULL OR length(tile_data) = 0), 0) FROM tiles`).Scan(&outside, &empty)
+	if err != nil {
+		return nil, fmt.Errorf("could not query tiles: %v", err)
+	}
+	if outside > 0 {
+		v.errorf("tiles", "%d tiles are outside of the tile grid of their zoom level", outside)
+	}
+	if empty > 0 {
+		v.warnf("tiles", "%d tiles have no data", empty)
+	}
+
+	rows, err := v.db.QueryContext(v.ctx, `SELECT zoom_level, min(tile_column), max(tile_column), min(tile_row), max(tile_row)
+		FROM tiles WHERE zoom_level BETWEEN 0 AND 30 GROUP BY zoom_level ORDER BY zoom_level`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query zoom levels: %v", err)
+	}
+	defer rows.Close()
+	var zooms []tileExtent
+	for rows.Next() {
+		var e tileExtent
+		var minX, maxX, minY, maxY int64
+		if err := rows.Scan(&e.zoom, &minX, &maxX, &minY, &maxY); err != nil {
+			return nil, fmt.Errorf("could not read zoom levels: %v", err)
+		}
+		// clamp the extent of tiles outside of the grid, which were reported
+		n := int64(1)<<e.zoom - 1
+		e.minX, e.maxX = uint64(clampInt64(minX, 0, n)), uint64(clampInt64(maxX, 0, n))
+		e.minY, e.maxY = uint64(clampInt64(minY, 0, n)), uint64(clampInt64(maxY, 0, n))
+		zooms = append(zooms, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read zoom levels: %v", err)
+	}
+	if len(zooms) == 0 {
+		v.warnf("tiles", "the tileset has no tiles")
+		return nil, nil
+	}
+
+	name, _ := metadata["format"].(string)
+	for _, e := range zooms {
+		var data []byte
+		err := v.db.QueryRowContext(v.ctx, "SELECT tile_data FROM tiles WHERE zoom_level = ? AND length(tile_data) > 0 LIMIT 1", e.zoom).Scan(&data)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read tile: %v", err)
+		}
+		format := PBF // compressed tiles are expected to be vector tiles
+		if detectTileEncoding(data) == IDENTITY {
+			if format, err = detectTileFormat(&data); err != nil {
+				v.errorf("format", "unknown format of the tiles at zoom level %d", e.zoom)
+				continue
+			}
+		}
+		if name != "" && format.String() != name && format.ContentType() != name {
+			v.errorf("format", "the tiles at zoom level %d are %s, but the format item is %s", e.zoom, format, name)
+		}
+	}
+	return zooms, nil
+}
+
+// checkZooms reports gaps between the zoom levels and zoom items that do not
+// match the tiles.
+func (v *validator) checkZooms(metadata map[string]interface{}, zooms []tileExtent) {
+	minZoom, hasMin := metadata["minzoom"].(int)
+	maxZoom, hasMax := metadata["maxzoom"].(int)
+	if hasMin && hasMax && minZoom > maxZoom {
+		v.errorf("zoom", "minzoom %d is greater than maxzoom %d", minZoom, maxZoom)
+	}
+	if len(zooms) == 0 {
+		return
+	}
+	for i := 1; i < len(zooms); i++ {
+		if zooms[i].zoom != zooms[i-1].zoom+1 {
+			v.warnf("zoom", "there are no tiles between zoom levels %d and %d", zooms[i-1].zoom, zooms[i].zoom)
+		}
+	}
+	if lowest := int(zooms[0].zoom); hasMin && minZoom != lowest {
+		v.warnf("zoom", "minzoom is %d, but the lowest zoom level of the tiles is %d", minZoom, lowest)
+	}
+	if highest := int(zooms[len(zooms)-1].zoom); hasMax && maxZoom != highest {
+		v.warnf("zoom", "maxzoom is %d, but the highest zoom level of the tiles is %d", maxZoom, highest)
+	}
+}
+
+// checkBounds reports bounds and centers outside of the valid ranges or
+// outside of each other, and tiles outside of the bounds.
+func (v *validator) checkBounds(metadata map[string]interface{}, zooms []tileExtent) {
+	bounds, hasBounds := metadata["bounds"].([]float64)
+	if hasBounds {
+		switch {
+		case len(bounds) != 4:
+			v.errorf("bounds", "bounds must consist of 4 values, got %d", len(bounds))
+			hasBounds = false
+		case bounds[0] < -180 || bounds[2] > 180 || bounds[1] < -90 || bounds[3] > 90:
+			v.errorf("bounds", "bounds %v are outside of the valid longitudes and latitudes", bounds)
+		case bounds[0] >= bounds[2] || bounds[1] >= bounds[3]:
+			v.errorf("bounds", "bounds %v are not ordered as west, south, east, north", bounds)
+			hasBounds = false
+		case bounds[1] < -maxLatitude || bounds[3] > maxLatitude:
+			v.warnf("bounds", "bounds %v exceed the latitudes of web mercator tiles", bounds)
+		}
+	}
+	if center, ok := metadata["center"].([]float64); ok {
+		switch {
+		case len(center) != 3:
+			v.errorf("bounds", "center must consist of 3 values, got %d", len(center))
+		case hasBounds && (center[0] < bounds[0] || center[0] > bounds[2] || center[1] < bounds[1] || center[1] > bounds[3]):
+			v.warnf("bounds", "center %v is outside of the bounds %v", center[:2], bounds)
+		}
+		if len(center) == 3 {
+			minZoom, hasMin := metadata["minzoom"].(int)
+			maxZoom, hasMax := metadata["maxzoom"].(int)
+			if z := center[2]; hasMin && z < float64(minZoom) || hasMax && z > float64(maxZoom) {
+				v.warnf("bounds", "center zoom level %v is outside of minzoom and maxzoom", z)
+			}
+		}
+	}
+	if !hasBounds {
+		return
+	}
+	for _, e := range zooms {
+		minX, minY := lonLatToTile(bounds[0], bounds[1], e.zoom)
+		maxX, maxY := lonLatToTile(bounds[2], bounds[3], e.zoom)
+		if e.minX < minX || e.maxX > maxX || e.minY < minY || e.maxY > maxY {
+			v.warnf("bounds", "the tiles at zoom level %d extend beyond the bounds", e.zoom)
+		}
+	}
+}
+
+// clampInt64 returns x limited to the range from min to max.
+func clampInt64(x, min, max int64) int64 {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
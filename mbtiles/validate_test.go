@@ -0,0 +1,56 @@
+package mbtiles
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	filename := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{2, 0, 0}: pngTile,
+	}, map[string]string{
+		"name":    "test",
+		"format":  "png",
+		"bounds":  "-180,-85,180,85",
+		"center":  "0,0,1",
+		"minzoom": "0",
+		"maxzoom": "2",
+	})
+	violations, err := Validate(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Check != "zoom" || violations[0].Severity != SeverityWarning {
+		t.Errorf("expected a warning about the missing zoom level 1, got %v", violations)
+	}
+
+	filename = createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+	}, map[string]string{
+		"format":  "jpg",
+		"bounds":  "10,0,-10,5",
+		"minzoom": "0",
+		"maxzoom": "0",
+	})
+	violations, err = Validate(context.Background(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := make(map[string]Severity)
+	for _, v := range violations {
+		checks[v.Check+" "+v.Message] = v.Severity
+	}
+	for _, expected := range []string{
+		"metadata missing required item name",
+		"format the tiles at zoom level 0 are png, but the format item is jpg",
+		"bounds bounds [10 0 -10 5] are not ordered as west, south, east, north",
+	} {
+		if s, ok := checks[expected]; !ok || s != SeverityError {
+			t.Errorf("expected error %q, got %v", expected, violations)
+		}
+	}
+	if violations[0].Severity != SeverityError || violations[len(violations)-1].Severity != SeverityWarning {
+		t.Errorf("expected errors before warnings, got %v", violations)
+	}
+}
@@ -0,0 +1,285 @@
+package mbtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	RegisterTileStore(".virtual", openVirtual)
+}
+
+// virtualConfig is the content of a .virtual file, which describes a tileset
+// that is composed of other tilesets.
+type virtualConfig struct {
+	// Zooms are the tilesets that provide ranges of zoom levels, e.g. of
+	// the low and high zoom levels of a build pipeline.
+	Zooms []virtualZooms `json:"zooms"`
+	// Fallback are the tilesets in the order in which a tile is looked up
+	// in them, e.g. a regional overlay followed by a global basemap. They are
+	// looked up after those of Zooms.
+	Fallback []string `json:"fallback"`
+}
+
+// virtualZooms is a tileset of a virtual tileset that provides only the
+// zoom levels from MinZoom to MaxZoom.
+type virtualZooms struct {
+	MinZoom int    `json:"minzoom"`
+	MaxZoom int    `json:"maxzoom"`
+	Tileset string `json:"tileset"`
+}
+
+// virtualMember is a tileset of a virtual tileset.
+type virtualMember struct {
+	db               *DB // in the TMS scheme
+	minZoom, maxZoom uint8
+}
+
+// virtualStore is the TileStore of a virtual tileset whose tiles are read
+// from the first of an ordered list of tilesets that has them at their zoom
+// level.
+type virtualStore struct {
+	members []virtualMember
+}
+
+// openVirtual opens the .virtual file filename, which is a JSON object like
+//
+//	{"fallback": ["regional.mbtiles", "world.mbtiles"]}
+//
+// or
+//
+//	{"zooms": [
+//		{"minzoom": 0, "maxzoom": 8, "tileset": "planet-low.mbtiles"},
+//		{"minzoom": 9, "maxzoom": 14, "tileset": "region.mbtiles"}
+//	]}
+//
+// Relative filenames are relative to the directory of filename. All tilesets
+// must have the same tile format and encoding.
+func openVirtual(filename string) (TileStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c virtualConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse virtual tileset %s: %v", filename, err)
+	}
+	zooms := c.Zooms
+	for _, name := range c.Fallback {
+		zooms = append(zooms, virtualZooms{0, 30, name})
+	}
+	if len(zooms) == 0 {
+		return nil, fmt.Errorf("virtual tileset %s has no tilesets", filename)
+	}
+	s := &virtualStore{}
+	for _, z := range zooms {
+		if z.MinZoom < 0 || z.MinZoom > z.MaxZoom || z.MaxZoom > 30 {
+			s.Close()
+			return nil, fmt.Errorf("virtual tileset %s: invalid zoom levels %d to %d of %s", filename, z.MinZoom, z.MaxZoom, z.Tileset)
+		}
+		db, err := openMember(filename, z.Tileset)
+		if err == nil && len(s.members) > 0 {
+			if first := s.members[0].db; db.TileFormat() != first.TileFormat() || db.TileEncoding() != first.TileEncoding() {
+				db.Close()
+				err = fmt.Errorf("tiles of %s differ in format or encoding from %s", z.Tileset, zooms[0].Tileset)
+			}
+		}
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("virtual tileset %s: %v", filename, err)
+		}
+		s.members = append(s.members, virtualMember{db, uint8(z.MinZoom), uint8(z.MaxZoom)})
+	}
+	return s, nil
+}
+
+// openMember opens the tileset name of the virtual tileset filename.
+func openMember(filename, name string) (*DB, error) {
+	if tilesetExt(name) == ".virtual" {
+		return nil, fmt.Errorf("cannot nest virtual tileset %s", name)
+	}
+	if !IsRemote(name) && !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(filename), name)
+	}
+	return NewDB(name, Scheme(TMS))
+}
+
+// has reports whether the member provides the zoom level z.
+func (m virtualMember) has(z uint8) bool {
+	return m.minZoom <= z && z <= m.maxZoom
+}
+
+func (s *virtualStore) ReadTile(ctx context.Context, z uint8, x, y uint64, data *[]byte) error {
+	for _, m := range s.members {
+		if !m.has(z) {
+			continue
+		}
+		err := m.db.ReadTileContext(ctx, z, x, y, data)
+		if err == ErrTileNotFound || (err == nil && len(*data) == 0) {
+			continue
+		}
+		return err
+	}
+	return ErrTileNotFound
+}
+
+// Metadata returns the metadata of the first tileset with the union of the
+// bounds of all tilesets and of the zoom levels that they provide.
+func (s *virtualStore) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	// mergeMetadata keeps the metadata of the last DB
+	dbs := make([]*DB, len(s.members))
+	for i, m := range s.members {
+		dbs[len(dbs)-1-i] = m.db
+	}
+	metadata, err := mergeMetadata(dbs, TileFilter{})
+	if err != nil {
+		return nil, err
+	}
+	minZoom, maxZoom := -1, -1
+	for _, m := range s.members {
+		mm, err := m.db.ReadMetadataContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		min, ok := mm["minzoom"].(int)
+		if !ok || min < int(m.minZoom) {
+			min = int(m.minZoom)
+		}
+		max, ok := mm["maxzoom"].(int)
+		if !ok || max > int(m.maxZoom) {
+			max = int(m.maxZoom)
+		}
+		if min > max {
+			continue
+		}
+		if minZoom < 0 || min < minZoom {
+			minZoom = min
+		}
+		if max > maxZoom {
+			maxZoom = max
+		}
+	}
+	if minZoom >= 0 {
+		metadata["minzoom"] = minZoom
+		metadata["maxzoom"] = maxZoom
+	}
+	return metadata, nil
+}
+
+func (s *virtualStore) Tiles(ctx context.Context, zooms []uint8) TileSource {
+	it := &virtualTiles{s: s, ctx: ctx}
+	it.zooms = zooms
+	if len(zooms) == 0 {
+		metadata, err := s.Metadata(ctx)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		minZoom, _ := metadata["minzoom"].(int)
+		maxZoom, _ := metadata["maxzoom"].(int)
+		it.zooms = ZoomRange(uint8(minZoom), uint8(maxZoom))
+	}
+	return it
+}
+
+func (s *virtualStore) Close() error {
+	var err error
+	for _, m := range s.members {
+		if cerr := m.db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// LastModified returns the last modification time of the tilesets, so that
+// the virtual tileset changes with them.
+func (s *virtualStore) LastModified() time.Time {
+	var t time.Time
+	for _, m := range s.members {
+		if ts := m.db.TimeStamp(); ts.After(t) {
+			t = ts
+		}
+	}
+	return t
+}
+
+// virtualTiles is the TileSource of a virtualStore. It lists the tiles of one
+// zoom level of one tileset at a time and skips those that are hidden by a
+// tileset before it.
+type virtualTiles struct {
+	s     *virtualStore
+	ctx   context.Context
+	zooms []uint8 // the remaining zoom levels
+	i     int     // index of the current tileset
+	it    *TileIterator
+	tile  Tile
+	err   error
+}
+
+func (it *virtualTiles) Next() bool {
+	for it.err == nil {
+		if it.it == nil {
+			if len(it.zooms) == 0 {
+				return false
+			}
+			if m := it.s.members[it.i]; m.has(it.zooms[0]) {
+				it.it, it.err = m.db.Tiles(it.ctx, TileFilter{Zooms: it.zooms[:1]})
+				continue
+			}
+			it.next()
+			continue
+		}
+		if it.it.Next() {
+			t := it.it.Tile()
+			hidden := false
+			for _, m := range it.s.members[:it.i] {
+				if !m.has(t.Z) {
+					continue
+				}
+				if hidden, it.err = m.db.HasTileContext(it.ctx, t.Z, t.X, t.Y); hidden || it.err != nil {
+					break
+				}
+			}
+			if !hidden && it.err == nil {
+				it.tile = t
+				return true
+			}
+			continue
+		}
+		it.err = it.it.Err()
+		it.it.Close()
+		it.it = nil
+		it.next()
+	}
+	return false
+}
+
+// next advances to the next tileset, or to the first tileset at the next zoom
+// level.
+func (it *virtualTiles) next() {
+	if it.i++; it.i == len(it.s.members) {
+		it.i = 0
+		it.zooms = it.zooms[1:]
+	}
+}
+
+func (it *virtualTiles) Tile() Tile {
+	return it.tile
+}
+
+func (it *virtualTiles) Err() error {
+	return it.err
+}
+
+func (it *virtualTiles) Close() error {
+	if it.it != nil {
+		it.it.Close()
+		it.it = nil
+	}
+	return nil
+}
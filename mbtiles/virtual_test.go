@@ -0,0 +1,149 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestVirtualFallback(t *testing.T) {
+	overlay := append(append([]byte{}, pngTile...), "overlay"...)
+	regional := createTestDB(t, map[[3]uint64][]byte{
+		{2, 2, 2}: overlay,
+		{3, 4, 4}: overlay,
+	}, map[string]string{"name": "regional", "bounds": "0,0,45,45"})
+	world := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{2, 2, 2}: pngTile,
+		{2, 1, 1}: pngTile,
+	}, map[string]string{"name": "world", "bounds": "-180,-85,180,85"})
+
+	filename := filepath.Join(filepath.Dir(regional), "chain.virtual")
+	if err := ioutil.WriteFile(filename, []byte(`{"fallback": ["test.mbtiles", "`+world+`"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !IsTileset(filename) {
+		t.Errorf("expected %s to be a tileset", filename)
+	}
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, c := range []struct {
+		z    uint8
+		x, y uint64
+		data []byte
+	}{
+		{2, 2, 2, overlay},
+		{3, 4, 4, overlay},
+		{2, 1, 1, pngTile},
+		{0, 0, 0, pngTile},
+	} {
+		var data []byte
+		if err := db.ReadTile(c.z, c.x, c.y, &data); err != nil || !bytes.Equal(data, c.data) {
+			t.Errorf("tile %d/%d/%d: expected %q, got %q (%v)", c.z, c.x, c.y, c.data, data, err)
+		}
+	}
+	var data []byte
+	if err := db.ReadTile(3, 0, 0, &data); err != ErrTileNotFound {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "regional" || metadata["minzoom"] != 0 || metadata["maxzoom"] != 3 {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	if b, ok := metadata["bounds"].([]float64); !ok || b[0] != -180 || b[3] != 85 {
+		t.Errorf("expected merged bounds, got %v", metadata["bounds"])
+	}
+
+	it, err := db.Tiles(context.Background(), TileFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if tile := it.Tile(); tile.Z == 2 && tile.X == 2 && !bytes.Equal(tile.Data, overlay) {
+			t.Errorf("expected hidden tile to be skipped, got %q", tile.Data)
+		}
+		n++
+	}
+	if err := it.Err(); err != nil || n != 4 {
+		t.Errorf("expected 4 tiles, got %d (%v)", n, err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"fallback": ["chain.virtual"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(filename); err == nil {
+		t.Error("expected error for nested virtual tileset")
+	}
+}
+
+func TestVirtualZooms(t *testing.T) {
+	low := createTestDB(t, map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{1, 0, 0}: pngTile,
+		{2, 0, 0}: pngTile,
+	}, map[string]string{"name": "low"})
+	high := append(append([]byte{}, pngTile...), "high"...)
+	region := createTestDB(t, map[[3]uint64][]byte{
+		{1, 0, 0}: high,
+		{2, 0, 0}: high,
+		{3, 0, 0}: high,
+	}, map[string]string{"name": "region"})
+
+	filename := filepath.Join(filepath.Dir(low), "split.virtual")
+	config := `{"zooms": [
+		{"minzoom": 0, "maxzoom": 1, "tileset": "test.mbtiles"},
+		{"minzoom": 2, "maxzoom": 3, "tileset": "` + region + `"}
+	]}`
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for z, expected := range [][]byte{pngTile, pngTile, high, high} {
+		var data []byte
+		if err := db.ReadTile(uint8(z), 0, 0, &data); err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("zoom level %d: expected %q, got %q (%v)", z, expected, data, err)
+		}
+	}
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "low" || metadata["minzoom"] != 0 || metadata["maxzoom"] != 3 {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	it, err := db.Tiles(context.Background(), TileFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	n := 0
+	for ; it.Next(); n++ {
+	}
+	if err := it.Err(); err != nil || n != 4 {
+		t.Errorf("expected 4 tiles, got %d (%v)", n, err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"zooms": [{"minzoom": 5, "maxzoom": 2, "tileset": "test.mbtiles"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(filename); err == nil {
+		t.Error("expected error for invalid zoom levels")
+	}
+}
@@ -0,0 +1,184 @@
+package mbtiles
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// schema contains the statements that create the tables and indices required
+// by version 1.2 of the mbtiles specification.
+var schema = []string{
+	"CREATE TABLE metadata (name text, value text)",
+	"CREATE UNIQUE INDEX name ON metadata (name)",
+	"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+	"CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)",
+}
+
+// dedupSchema contains the statements that create the normalized schema of
+// a Writer with the Deduplicate option, in which the tiles table is a view.
+var dedupSchema = []string{
+	"CREATE TABLE metadata (name text, value text)",
+	"CREATE UNIQUE INDEX name ON metadata (name)",
+	"CREATE TABLE map (zoom_level integer, tile_column integer, tile_row integer, tile_id text)",
+	"CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row)",
+	"CREATE TABLE images (tile_id text, tile_data blob)",
+	"CREATE UNIQUE INDEX images_id ON images (tile_id)",
+	`CREATE VIEW tiles AS SELECT map.zoom_level AS zoom_level, map.tile_column AS tile_column,
+		map.tile_row AS tile_row, images.tile_data AS tile_data
+		FROM map JOIN images ON images.tile_id = map.tile_id`,
+}
+
+// DefaultBatchSize is the number of tiles a Writer inserts within a single
+// transaction before it is committed.
+const DefaultBatchSize = 1000
+
+// Writer creates a new mbtiles file and populates it with tiles and metadata.
+// Tiles are inserted within transactions that are committed every BatchSize
+// tiles, on Flush and on Close.
+type Writer struct {
+	filename  string
+	db        *sql.DB
+	tx        *sql.Tx
+	tileStmt  *sql.Stmt
+	imageStmt *sql.Stmt // nil unless tiles are deduplicated
+	pending   int
+	scheme    TileScheme
+	dedup     bool
+	BatchSize int
+}
+
+// CreateDB creates a new mbtiles file at filename with an empty schema and
+// returns a Writer for it. It is an error if the file already exists.
+// The caller must call Close on the returned Writer to make sure that all
+// tiles are written to the file. Of the options opts, only Scheme and
+// Deduplicate affect the Writer.
+func CreateDB(filename string, opts ...Option) (*Writer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("file already exists: %s", filename)
+	}
+	db, err := sql.Open(sqliteDriver, filename)
+	if err != nil {
+		return nil, err
+	}
+	stmts := schema
+	if o.dedup {
+		stmts = dedupSchema
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not create mbtiles schema: %v", err)
+		}
+	}
+	return &Writer{
+		filename:  filename,
+		db:        db,
+		scheme:    o.scheme,
+		dedup:     o.dedup,
+		BatchSize: DefaultBatchSize,
+	}, nil
+}
+
+// begin starts a new transaction unless one is already in progress.
+func (w *Writer) begin() error {
+	if w.tx != nil {
+		return nil
+	}
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	if w.dedup {
+		w.imageStmt, err = tx.Prepare("INSERT OR IGNORE INTO images (tile_id, tile_data) VALUES (?, ?)")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		w.tileStmt, err = tx.Prepare("INSERT OR REPLACE INTO map (zoom_level, tile_column, tile_row, tile_id) VALUES (?, ?, ?, ?)")
+	} else {
+		w.tileStmt, err = tx.Prepare("INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	w.tx = tx
+	return nil
+}
+
+// WriteTile inserts the tile data at z, x, y, replacing any existing tile at
+// these coordinates. Like for ReadTile, y is the row in the TileScheme of the
+// Writer, see the Scheme option.
+func (w *Writer) WriteTile(z uint8, x uint64, y uint64, data []byte) error {
+	if err := w.begin(); err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	row := y
+	if w.scheme != TMS {
+		row = flipRow(z, y)
+	}
+	var err error
+	if w.dedup {
+		sum := sha256.Sum256(data)
+		id := hex.EncodeToString(sum[:])
+		if _, err = w.imageStmt.Exec(id, data); err == nil {
+			_, err = w.tileStmt.Exec(z, x, row, id)
+		}
+	} else {
+		_, err = w.tileStmt.Exec(z, x, row, data)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write tile for z=%d, x=%d, y=%d: %v", z, x, y, err)
+	}
+	w.pending++
+	if w.BatchSize > 0 && w.pending >= w.BatchSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// WriteMetadata inserts a single metadata item, replacing an existing item of
+// the same name.
+func (w *Writer) WriteMetadata(name, value string) error {
+	if err := w.begin(); err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	_, err := w.tx.Exec("INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)", name, value)
+	if err != nil {
+		return fmt.Errorf("could not write metadata item %s: %v", name, err)
+	}
+	return nil
+}
+
+// Flush commits the current transaction, if any.
+func (w *Writer) Flush() error {
+	if w.tx == nil {
+		return nil
+	}
+	w.tileStmt.Close()
+	if w.imageStmt != nil {
+		w.imageStmt.Close()
+	}
+	err := w.tx.Commit()
+	w.tx, w.tileStmt, w.imageStmt, w.pending = nil, nil, nil, 0
+	if err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+// Close flushes all pending writes and closes the database connection.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
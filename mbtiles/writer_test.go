@@ -0,0 +1,224 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// pngTile is not a valid image, but it carries the PNG signature which is
+// sufficient for format detection.
+var pngTile = []byte("\x89\x50\x4E\x47\x0D\x0A\x1A\x0Afoo")
+
+// otherTile is a tile in the format of pngTile with other data.
+var otherTile = append(append([]byte{}, pngTile...), "bar"...)
+
+// testFilename returns the path of the file name in a temporary directory,
+// which is removed when the test has finished.
+func testFilename(t *testing.T, name string) string {
+	return filepath.Join(t.TempDir(), name)
+}
+
+// createTestDB creates a new mbtiles file in a temporary directory, populates
+// it with the given tiles and metadata and returns its path.
+func createTestDB(t *testing.T, tiles map[[3]uint64][]byte, metadata map[string]string) string {
+	filename := testFilename(t, "test.mbtiles")
+	w, err := CreateDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.BatchSize = 2
+	for c, data := range tiles {
+		if err := w.WriteTile(uint8(c[0]), c[1], c[2], data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k, v := range metadata {
+		if err := w.WriteMetadata(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestWriter(t *testing.T) {
+	tiles := map[[3]uint64][]byte{
+		{0, 0, 0}: pngTile,
+		{1, 0, 0}: pngTile,
+		{1, 1, 0}: pngTile,
+		{1, 1, 1}: append([]byte{}, pngTile...),
+	}
+	filename := createTestDB(t, tiles, map[string]string{"name": "test", "minzoom": "0"})
+
+	if _, err := CreateDB(filename); err == nil {
+		t.Error("expected error when creating an existing file")
+	}
+
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.TileFormat() != PNG {
+		t.Errorf("expected tile format PNG, got %q", db.TileFormatString())
+	}
+	for c := range tiles {
+		var data []byte
+		if err := db.ReadTile(uint8(c[0]), c[1], c[2], &data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, pngTile) {
+			t.Errorf("unexpected tile data for %v: %q", c, data)
+		}
+	}
+	var data []byte
+	if err := db.ReadTile(2, 0, 0, &data); err != ErrTileNotFound {
+		t.Errorf("expected ErrTileNotFound for missing tile, got %v", err)
+	}
+	if ok, err := db.HasTile(2, 0, 0); ok || err != nil {
+		t.Errorf("expected HasTile to return false, nil for missing tile, got %v, %v", ok, err)
+	}
+	if ok, err := db.HasTile(1, 1, 1); !ok || err != nil {
+		t.Errorf("expected HasTile to return true, nil for existing tile, got %v, %v", ok, err)
+	}
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "test" {
+		t.Errorf("unexpected name metadata: %v", metadata["name"])
+	}
+	if metadata["maxzoom"] != 1 {
+		t.Errorf("expected inferred maxzoom 1, got %v", metadata["maxzoom"])
+	}
+	// tiles at zoom level 1 cover the whole world
+	bounds, ok := metadata["bounds"].([]float64)
+	if !ok || len(bounds) != 4 || bounds[0] != -180 || bounds[2] != 180 || bounds[1] > -85 || bounds[3] < 85 {
+		t.Errorf("unexpected inferred bounds: %v", metadata["bounds"])
+	}
+}
+
+func TestScheme(t *testing.T) {
+	filename := testFilename(t, "test.mbtiles")
+	w, err := CreateDB(filename, Scheme(TMS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the north western tile at zoom level 1, in TMS rows
+	if err := w.WriteTile(1, 0, 1, pngTile); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scheme TileScheme
+		row    uint64
+	}{
+		{XYZ, 0},
+		{TMS, 1},
+	}
+	for _, tc := range tests {
+		db, err := NewDB(filename, Scheme(tc.scheme))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		if err := db.ReadTile(1, 0, tc.row, &data); err != nil {
+			t.Errorf("%v: %v", tc.scheme, err)
+		}
+		if err := db.ReadTile(1, 0, 1-tc.row, &data); err != ErrTileNotFound {
+			t.Errorf("%v: expected ErrTileNotFound for flipped row, got %v", tc.scheme, err)
+		}
+		it, err := db.Tiles(context.Background(), TileFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !it.Next() || it.Tile().Y != tc.row {
+			t.Errorf("%v: expected tile in row %d from iterator, got %+v", tc.scheme, tc.row, it.Tile())
+		}
+		it.Close()
+		tilejson, err := db.TileJSON("http://localhost/services/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tilejson["scheme"] != tc.scheme.String() {
+			t.Errorf("%v: unexpected TileJSON scheme %v", tc.scheme, tilejson["scheme"])
+		}
+		db.Close()
+	}
+
+	if _, err := ParseTileScheme("TMS"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseTileScheme("wmts"); err == nil {
+		t.Error("expected error for unknown tile scheme")
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	dir := createTestTileDir(t, map[string][]byte{
+		"0/0/0.png": otherTile,
+		"1/0/0.png": pngTile,
+		"1/0/1.png": pngTile,
+		"1/1/1.png": pngTile,
+	})
+	filename := filepath.Join(filepath.Dir(dir), "test.mbtiles")
+	if err := Convert(context.Background(), dir, filename, Deduplicate()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var images, tiles int
+	if err := db.db.QueryRow("SELECT (SELECT count(*) FROM images), (SELECT count(*) FROM tiles)").Scan(&images, &tiles); err != nil {
+		t.Fatal(err)
+	}
+	if images != 2 || tiles != 4 {
+		t.Errorf("expected 2 images of 4 tiles, got %d images of %d tiles", images, tiles)
+	}
+	var data []byte
+	if err := db.ReadTile(1, 1, 1, &data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, pngTile) {
+		t.Errorf("expected %q, got %q", pngTile, data)
+	}
+
+	if !db.IsDeduplicated() {
+		t.Error("expected the deduplicated schema to be detected")
+	}
+	var ids []string
+	for _, c := range [][3]uint64{{1, 0, 0}, {1, 0, 1}, {0, 0, 0}} {
+		id, err := db.ReadTileID(uint8(c[0]), c[1], c[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != ids[1] || ids[0] == ids[2] {
+		t.Errorf("expected the same IDs only for the same tiles, got %v", ids)
+	}
+	if _, err := db.ReadTileID(1, 1, 0); err != ErrTileNotFound {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+	if ok, err := db.HasTile(1, 1, 0); ok || err != nil {
+		t.Errorf("expected no tile at 1/1/0, got %v, %v", ok, err)
+	}
+
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["name"] != "tiles" || metadata["format"] != "png" {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var (
+	mergeConflict string
+	mergeMinZoom  int
+	mergeMaxZoom  int
+	mergeBounds   string
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <output.mbtiles> <input>...",
+	Short: "Merge tilesets into a new mbtiles file",
+	Long: `Merge writes the tiles of several tilesets, e.g. of different regions, to a
+new mbtiles file. The tilesets are merged from the oldest to the newest file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			log.Fatalln("An output file and at least one input tileset are required")
+		}
+		var o mbtiles.MergeOptions
+		var err error
+		if o.Conflict, err = mbtiles.ParseConflictPolicy(mergeConflict); err != nil {
+			log.Fatalln(err)
+		}
+		if o.Filter, err = parseTileFilter(mergeMinZoom, mergeMaxZoom, mergeBounds); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mbtiles.Merge(context.Background(), args[0], args[1:], o); err != nil {
+			log.Fatalf("Could not merge tilesets: %v", err)
+		}
+	},
+}
+
+func init() {
+	flags := mergeCmd.Flags()
+	flags.StringVar(&mergeConflict, "conflict", "newer", "Tile that is kept if several tilesets have a tile at the same coordinates: newer (of the newest file), larger or error.")
+	flags.IntVar(&mergeMinZoom, "minzoom", -1, "Lowest zoom level of the merged tiles (-1 for no limit).")
+	flags.IntVar(&mergeMaxZoom, "maxzoom", -1, "Highest zoom level of the merged tiles (-1 for no limit).")
+	flags.StringVar(&mergeBounds, "bounds", "", "Bounding box west,south,east,north in degrees that the merged tiles must intersect.")
+	RootCmd.AddCommand(mergeCmd)
+}
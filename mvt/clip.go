@@ -0,0 +1,223 @@
+package mvt
+
+import "math"
+
+// Overzoom derives a tile that lies dz zoom levels below t from the content of
+// t. The column dx and row dy of the derived tile are relative to the upper
+// left descendant of t at that zoom level, with rows counted from the north.
+// All geometries are scaled up accordingly and clipped to the extent of the
+// derived tile plus buffer units on each side. Features without any remaining
+// geometry are dropped and layers without features are omitted. The properties
+// of the features are shared with t.
+func (t *Tile) Overzoom(dz uint8, dx, dy uint64, buffer int64) *Tile {
+	out := &Tile{}
+	for _, l := range t.Layers {
+		extent := int64(l.Extent)
+		if extent == 0 {
+			extent = DefaultExtent
+		}
+		ox, oy := int64(dx)*extent, int64(dy)*extent
+		b := box{-buffer, -buffer, extent + buffer, extent + buffer}
+		nl := &Layer{Version: l.Version, Name: l.Name, Extent: l.Extent}
+		for _, f := range l.Features {
+			parts := make([][]Coord, len(f.Geometry))
+			for i, p := range f.Geometry {
+				parts[i] = make([]Coord, len(p))
+				for j, c := range p {
+					parts[i][j] = Coord{c.X<<dz - ox, c.Y<<dz - oy}
+				}
+			}
+			parts = clipGeometry(parts, f.Type, b)
+			if len(parts) == 0 {
+				continue
+			}
+			nl.Features = append(nl.Features, &Feature{
+				ID:         f.ID,
+				HasID:      f.HasID,
+				Type:       f.Type,
+				Properties: f.Properties,
+				Geometry:   parts,
+			})
+		}
+		if len(nl.Features) > 0 {
+			out.Layers = append(out.Layers, nl)
+		}
+	}
+	return out
+}
+
+// box is an axis aligned rectangle in tile units.
+type box struct {
+	minX, minY, maxX, maxY int64
+}
+
+func (b box) contains(c Coord) bool {
+	return c.X >= b.minX && c.X <= b.maxX && c.Y >= b.minY && c.Y <= b.maxY
+}
+
+// clipGeometry clips the parts of a geometry of type t to b.
+func clipGeometry(parts [][]Coord, t GeomType, b box) [][]Coord {
+	var out [][]Coord
+	switch t {
+	case Point:
+		var kept []Coord
+		for _, p := range parts {
+			for _, c := range p {
+				if b.contains(c) {
+					kept = append(kept, c)
+				}
+			}
+		}
+		if len(kept) > 0 {
+			out = append(out, kept)
+		}
+	case LineString:
+		for _, p := range parts {
+			out = append(out, clipLine(p, b)...)
+		}
+	case Polygon:
+		for _, p := range parts {
+			if r := clipRing(p, b); len(r) >= 3 {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// clipLine clips a line to b, which may split it into several lines.
+func clipLine(line []Coord, b box) [][]Coord {
+	var out [][]Coord
+	var cur []Coord
+	for i := 1; i < len(line); i++ {
+		a, c, ok := clipSegment(line[i-1], line[i], b)
+		if !ok {
+			continue
+		}
+		if len(cur) == 0 || cur[len(cur)-1] != a {
+			if len(cur) >= 2 {
+				out = append(out, cur)
+			}
+			cur = []Coord{a}
+		}
+		if c != a {
+			cur = append(cur, c)
+		}
+		if c != line[i] {
+			// the line leaves the box
+			if len(cur) >= 2 {
+				out = append(out, cur)
+			}
+			cur = nil
+		}
+	}
+	if len(cur) >= 2 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// clipSegment clips the segment from p to q to b using the Liang-Barsky
+// algorithm. It returns false if the segment lies completely outside of b.
+func clipSegment(p, q Coord, b box) (Coord, Coord, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := float64(q.X-p.X), float64(q.Y-p.Y)
+	edges := [4][2]float64{
+		{-dx, float64(p.X - b.minX)},
+		{dx, float64(b.maxX - p.X)},
+		{-dy, float64(p.Y - b.minY)},
+		{dy, float64(b.maxY - p.Y)},
+	}
+	for _, e := range edges {
+		if e[0] == 0 {
+			if e[1] < 0 {
+				return p, q, false
+			}
+			continue
+		}
+		r := e[1] / e[0]
+		if e[0] < 0 {
+			if r > t1 {
+				return p, q, false
+			}
+			if r > t0 {
+				t0 = r
+			}
+		} else {
+			if r < t0 {
+				return p, q, false
+			}
+			if r < t1 {
+				t1 = r
+			}
+		}
+	}
+	a, c := p, q
+	if t0 > 0 {
+		a = interpolate(p, dx, dy, t0)
+	}
+	if t1 < 1 {
+		c = interpolate(p, dx, dy, t1)
+	}
+	return a, c, true
+}
+
+func interpolate(p Coord, dx, dy, t float64) Coord {
+	return Coord{
+		p.X + int64(math.Floor(dx*t+0.5)),
+		p.Y + int64(math.Floor(dy*t+0.5)),
+	}
+}
+
+// clipRing clips a polygon ring to b using the Sutherland-Hodgman algorithm.
+func clipRing(ring []Coord, b box) []Coord {
+	type edge struct {
+		inside    func(Coord) bool
+		intersect func(p, q Coord) Coord
+	}
+	atX := func(p, q Coord, x int64) Coord {
+		t := float64(x-p.X) / float64(q.X-p.X)
+		return Coord{x, p.Y + int64(math.Floor(float64(q.Y-p.Y)*t+0.5))}
+	}
+	atY := func(p, q Coord, y int64) Coord {
+		t := float64(y-p.Y) / float64(q.Y-p.Y)
+		return Coord{p.X + int64(math.Floor(float64(q.X-p.X)*t+0.5)), y}
+	}
+	edges := []edge{
+		{func(c Coord) bool { return c.X >= b.minX }, func(p, q Coord) Coord { return atX(p, q, b.minX) }},
+		{func(c Coord) bool { return c.X <= b.maxX }, func(p, q Coord) Coord { return atX(p, q, b.maxX) }},
+		{func(c Coord) bool { return c.Y >= b.minY }, func(p, q Coord) Coord { return atY(p, q, b.minY) }},
+		{func(c Coord) bool { return c.Y <= b.maxY }, func(p, q Coord) Coord { return atY(p, q, b.maxY) }},
+	}
+	out := ring
+	for _, e := range edges {
+		if len(out) == 0 {
+			return nil
+		}
+		in := out
+		out = nil
+		prev := in[len(in)-1]
+		for _, c := range in {
+			switch {
+			case e.inside(c) && e.inside(prev):
+				out = append(out, c)
+			case e.inside(c):
+				out = append(out, e.intersect(prev, c), c)
+			case e.inside(prev):
+				out = append(out, e.intersect(prev, c))
+			}
+			prev = c
+		}
+	}
+	// remove the duplicate vertices that clipping creates at the corners of b
+	var dedup []Coord
+	for _, c := range out {
+		if len(dedup) == 0 || dedup[len(dedup)-1] != c {
+			dedup = append(dedup, c)
+		}
+	}
+	for len(dedup) > 1 && dedup[0] == dedup[len(dedup)-1] {
+		dedup = dedup[:len(dedup)-1]
+	}
+	return dedup
+}
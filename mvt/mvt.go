@@ -0,0 +1,518 @@
+// Package mvt decodes and encodes Mapbox Vector Tiles as described by version
+// 2.1 of the vector tile specification, and provides the geometric operations
+// that are needed to derive new tiles from existing ones.
+package mvt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/consbio/mbtileserver/internal/pbf"
+)
+
+// DefaultExtent is the number of units along each side of a tile that is used
+// if a layer does not specify its extent.
+const DefaultExtent = 4096
+
+// GeomType is the type of the geometry of a Feature.
+type GeomType uint8
+
+// Geometry types defined by the vector tile specification.
+const (
+	Unknown GeomType = iota
+	Point
+	LineString
+	Polygon
+)
+
+// String returns a string representation of the geometry type.
+func (t GeomType) String() string {
+	switch t {
+	case Point:
+		return "Point"
+	case LineString:
+		return "LineString"
+	case Polygon:
+		return "Polygon"
+	default:
+		return "Unknown"
+	}
+}
+
+// Coord is a position within a tile, in tile units with the origin at the
+// upper left corner of the tile.
+type Coord struct {
+	X, Y int64
+}
+
+// Feature is a single feature of a Layer. The geometry is stored as a list of
+// parts: a single part containing all points for Point features, one part per
+// line for LineString features and one part per ring for Polygon features.
+// Property values are of type string, float32, float64, int64, uint64 or
+// bool.
+type Feature struct {
+	ID         uint64
+	HasID      bool
+	Type       GeomType
+	Properties map[string]interface{}
+	Geometry   [][]Coord
+}
+
+// Layer is a named layer of a Tile.
+type Layer struct {
+	Version  uint32
+	Name     string
+	Extent   uint32
+	Features []*Feature
+}
+
+// Tile is a decoded vector tile.
+type Tile struct {
+	Layers []*Layer
+}
+
+// Layer returns the layer with the given name or nil, if the tile does not
+// contain such a layer.
+func (t *Tile) Layer(name string) *Layer {
+	for _, l := range t.Layers {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// protobuf field numbers of the vector tile messages
+const (
+	tileLayers = 3
+
+	layerVersion  = 15
+	layerName     = 1
+	layerFeatures = 2
+	layerKeys     = 3
+	layerValues   = 4
+	layerExtent   = 5
+
+	featureID       = 1
+	featureTags     = 2
+	featureType     = 3
+	featureGeometry = 4
+
+	valueString = 1
+	valueFloat  = 2
+	valueDouble = 3
+	valueInt    = 4
+	valueUint   = 5
+	valueSint   = 6
+	valueBool   = 7
+)
+
+// geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// Decode decodes the uncompressed vector tile data.
+func Decode(data []byte) (*Tile, error) {
+	t := &Tile{}
+	r := pbf.NewReader(data)
+	for !r.Done() {
+		field, wire, err := r.Key()
+		if err != nil {
+			return nil, err
+		}
+		if field != tileLayers || wire != pbf.WireBytes {
+			if err := r.Skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		l, err := decodeLayer(b)
+		if err != nil {
+			return nil, err
+		}
+		t.Layers = append(t.Layers, l)
+	}
+	return t, nil
+}
+
+func decodeLayer(data []byte) (*Layer, error) {
+	l := &Layer{Version: 1, Extent: DefaultExtent}
+	var keys []string
+	var values []interface{}
+	var features [][]byte
+	r := pbf.NewReader(data)
+	for !r.Done() {
+		field, wire, err := r.Key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == layerVersion && wire == pbf.WireVarint:
+			v, err := r.Varint()
+			if err != nil {
+				return nil, err
+			}
+			l.Version = uint32(v)
+		case field == layerName && wire == pbf.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			l.Name = string(b)
+		case field == layerFeatures && wire == pbf.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			features = append(features, b)
+		case field == layerKeys && wire == pbf.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, string(b))
+		case field == layerValues && wire == pbf.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(b)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case field == layerExtent && wire == pbf.WireVarint:
+			v, err := r.Varint()
+			if err != nil {
+				return nil, err
+			}
+			l.Extent = uint32(v)
+		default:
+			if err := r.Skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// features are decoded last, as keys and values may follow them
+	for _, b := range features {
+		f, err := decodeFeature(b, keys, values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feature in layer %q: %v", l.Name, err)
+		}
+		l.Features = append(l.Features, f)
+	}
+	return l, nil
+}
+
+func decodeValue(data []byte) (interface{}, error) {
+	var v interface{}
+	r := pbf.NewReader(data)
+	for !r.Done() {
+		field, wire, err := r.Key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == valueString && wire == pbf.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			v = string(b)
+		case field == valueFloat && wire == pbf.WireFixed32:
+			x, err := r.Fixed32()
+			if err != nil {
+				return nil, err
+			}
+			v = math.Float32frombits(x)
+		case field == valueDouble && wire == pbf.WireFixed64:
+			x, err := r.Fixed64()
+			if err != nil {
+				return nil, err
+			}
+			v = math.Float64frombits(x)
+		case (field == valueInt || field == valueUint || field == valueSint || field == valueBool) && wire == pbf.WireVarint:
+			x, err := r.Varint()
+			if err != nil {
+				return nil, err
+			}
+			switch field {
+			case valueInt:
+				v = int64(x)
+			case valueUint:
+				v = x
+			case valueSint:
+				v = unzigzag(x)
+			case valueBool:
+				v = x != 0
+			}
+		default:
+			if err := r.Skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func decodeFeature(data []byte, keys []string, values []interface{}) (*Feature, error) {
+	f := &Feature{Properties: make(map[string]interface{})}
+	var geometry []uint32
+	r := pbf.NewReader(data)
+	for !r.Done() {
+		field, wire, err := r.Key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == featureID && wire == pbf.WireVarint:
+			f.ID, err = r.Varint()
+			if err != nil {
+				return nil, err
+			}
+			f.HasID = true
+		case field == featureTags && wire == pbf.WireBytes:
+			tags, err := r.Packed()
+			if err != nil {
+				return nil, err
+			}
+			if len(tags)%2 != 0 {
+				return nil, fmt.Errorf("odd number of tags")
+			}
+			for i := 0; i < len(tags); i += 2 {
+				k, v := int(tags[i]), int(tags[i+1])
+				if k >= len(keys) || v >= len(values) {
+					return nil, fmt.Errorf("tag index out of range")
+				}
+				f.Properties[keys[k]] = values[v]
+			}
+		case field == featureType && wire == pbf.WireVarint:
+			v, err := r.Varint()
+			if err != nil {
+				return nil, err
+			}
+			f.Type = GeomType(v)
+		case field == featureGeometry && wire == pbf.WireBytes:
+			geometry, err = r.Packed()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if err := r.Skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var err error
+	f.Geometry, err = decodeGeometry(geometry, f.Type)
+	return f, err
+}
+
+// decodeGeometry decodes the command integers of a geometry into parts.
+func decodeGeometry(cmds []uint32, t GeomType) ([][]Coord, error) {
+	var parts [][]Coord
+	var part []Coord
+	var x, y int64
+	for i := 0; i < len(cmds); {
+		cmd, count := cmds[i]&0x7, int(cmds[i]>>3)
+		i++
+		switch cmd {
+		case cmdMoveTo, cmdLineTo:
+			if i+2*count > len(cmds) {
+				return nil, fmt.Errorf("truncated geometry")
+			}
+			for j := 0; j < count; j++ {
+				if cmd == cmdMoveTo && t != Point && len(part) > 0 {
+					parts = append(parts, part)
+					part = nil
+				}
+				x += int64(unzigzag32(cmds[i]))
+				y += int64(unzigzag32(cmds[i+1]))
+				i += 2
+				part = append(part, Coord{x, y})
+			}
+		case cmdClosePath:
+			if len(part) > 0 {
+				parts = append(parts, part)
+				part = nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown geometry command %d", cmd)
+		}
+	}
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// Encode encodes the tile in the vector tile format. The encoded data is not
+// compressed.
+func Encode(t *Tile) ([]byte, error) {
+	w := &pbf.Writer{}
+	for _, l := range t.Layers {
+		b, err := encodeLayer(l)
+		if err != nil {
+			return nil, err
+		}
+		w.Bytes(tileLayers, b)
+	}
+	return w.Data(), nil
+}
+
+func encodeLayer(l *Layer) ([]byte, error) {
+	version, extent := l.Version, l.Extent
+	if version == 0 {
+		version = 2
+	}
+	if extent == 0 {
+		extent = DefaultExtent
+	}
+
+	keyIndex := make(map[string]uint32)
+	valueIndex := make(map[interface{}]uint32)
+	var keys []string
+	var values []interface{}
+
+	w := &pbf.Writer{}
+	w.Varint(layerVersion, uint64(version))
+	w.Bytes(layerName, []byte(l.Name))
+	for _, f := range l.Features {
+		var tags []uint32
+		names := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			v := f.Properties[k]
+			if _, err := encodeValue(v); err != nil {
+				return nil, fmt.Errorf("invalid property %s in layer %q: %v", k, l.Name, err)
+			}
+			ki, ok := keyIndex[k]
+			if !ok {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+			vi, ok := valueIndex[v]
+			if !ok {
+				vi = uint32(len(values))
+				valueIndex[v] = vi
+				values = append(values, v)
+			}
+			tags = append(tags, ki, vi)
+		}
+		fw := &pbf.Writer{}
+		if f.HasID {
+			fw.Varint(featureID, f.ID)
+		}
+		fw.Packed(featureTags, tags)
+		fw.Varint(featureType, uint64(f.Type))
+		fw.Packed(featureGeometry, encodeGeometry(f.Geometry, f.Type))
+		w.Bytes(layerFeatures, fw.Data())
+	}
+	for _, k := range keys {
+		w.Bytes(layerKeys, []byte(k))
+	}
+	for _, v := range values {
+		b, _ := encodeValue(v)
+		w.Bytes(layerValues, b)
+	}
+	w.Varint(layerExtent, uint64(extent))
+	return w.Data(), nil
+}
+
+func encodeValue(v interface{}) ([]byte, error) {
+	w := &pbf.Writer{}
+	switch v := v.(type) {
+	case string:
+		w.Bytes(valueString, []byte(v))
+	case float32:
+		w.Fixed32(valueFloat, math.Float32bits(v))
+	case float64:
+		w.Fixed64(valueDouble, math.Float64bits(v))
+	case int64:
+		w.Varint(valueSint, zigzag(v))
+	case uint64:
+		w.Varint(valueUint, v)
+	case bool:
+		var x uint64
+		if v {
+			x = 1
+		}
+		w.Varint(valueBool, x)
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+	return w.Data(), nil
+}
+
+// encodeGeometry encodes the parts of a geometry into command integers.
+func encodeGeometry(parts [][]Coord, t GeomType) []uint32 {
+	var cmds []uint32
+	var x, y int64
+	delta := func(c Coord) {
+		cmds = append(cmds, uint32(zigzag(c.X-x)), uint32(zigzag(c.Y-y)))
+		x, y = c.X, c.Y
+	}
+	if t == Point {
+		var n int
+		for _, p := range parts {
+			n += len(p)
+		}
+		if n == 0 {
+			return nil
+		}
+		cmds = append(cmds, command(cmdMoveTo, n))
+		for _, p := range parts {
+			for _, c := range p {
+				delta(c)
+			}
+		}
+		return cmds
+	}
+	min := 2
+	if t == Polygon {
+		min = 3
+	}
+	for _, p := range parts {
+		if len(p) < min {
+			continue
+		}
+		cmds = append(cmds, command(cmdMoveTo, 1))
+		delta(p[0])
+		cmds = append(cmds, command(cmdLineTo, len(p)-1))
+		for _, c := range p[1:] {
+			delta(c)
+		}
+		if t == Polygon {
+			cmds = append(cmds, command(cmdClosePath, 1))
+		}
+	}
+	return cmds
+}
+
+func command(id uint32, count int) uint32 {
+	return id&0x7 | uint32(count)<<3
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func unzigzag32(v uint32) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
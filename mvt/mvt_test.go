@@ -0,0 +1,86 @@
+package mvt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testTile() *Tile {
+	return &Tile{Layers: []*Layer{
+		{
+			Version: 2,
+			Name:    "places",
+			Extent:  4096,
+			Features: []*Feature{
+				{ID: 1, HasID: true, Type: Point, Properties: map[string]interface{}{"name": "a", "rank": int64(-3)}, Geometry: [][]Coord{{{100, 100}, {3000, 3000}}}},
+				{ID: 2, HasID: true, Type: LineString, Properties: map[string]interface{}{"oneway": true, "speed": 2.5}, Geometry: [][]Coord{{{0, 0}, {4096, 4096}}}},
+				{Type: Polygon, Properties: map[string]interface{}{"area": uint64(7), "ratio": float32(0.5)}, Geometry: [][]Coord{{{1000, 1000}, {3000, 1000}, {3000, 3000}, {1000, 3000}}}},
+			},
+		},
+	}}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tile := testTile()
+	data, err := Encode(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tile, decoded) {
+		t.Errorf("decoded tile differs from original:\n%+v\n%+v", tile.Layers[0], decoded.Layers[0])
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	data, err := Encode(testTile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(data[:len(data)-3]); err == nil {
+		t.Error("expected error for truncated tile")
+	}
+}
+
+func TestOverzoom(t *testing.T) {
+	// the upper left quadrant of the test tile
+	child := testTile().Overzoom(1, 0, 0, 0)
+	if len(child.Layers) != 1 {
+		t.Fatalf("expected 1 layer, got %d", len(child.Layers))
+	}
+	features := child.Layers[0].Features
+	if len(features) != 3 {
+		t.Fatalf("expected 3 features, got %d", len(features))
+	}
+	expected := [][][]Coord{
+		{{{200, 200}}},
+		{{{0, 0}, {4096, 4096}}},
+		{{{2000, 4096}, {2000, 2000}, {4096, 2000}, {4096, 4096}}},
+	}
+	for i, f := range features {
+		if !reflect.DeepEqual(f.Geometry, expected[i]) {
+			t.Errorf("feature %d: expected geometry %v, got %v", i, expected[i], f.Geometry)
+		}
+	}
+
+	// the upper right quadrant only contains parts of the polygon
+	child = testTile().Overzoom(1, 1, 0, 0)
+	features = child.Layers[0].Features
+	if len(features) != 1 || features[0].Type != Polygon {
+		t.Fatalf("expected only the polygon, got %d features", len(features))
+	}
+	ring := features[0].Geometry[0]
+	for _, c := range ring {
+		if c.X < 0 || c.X > 4096 || c.Y < 0 || c.Y > 4096 {
+			t.Errorf("coordinate %v is outside of the tile", c)
+		}
+	}
+
+	// nothing remains in a distant descendant
+	if child := testTile().Overzoom(8, 255, 0, 0); len(child.Layers) != 0 {
+		t.Errorf("expected empty tile, got %d layers", len(child.Layers))
+	}
+}
@@ -0,0 +1,443 @@
+// Package otlp traces the requests to the handlers of a ServiceSet and
+// exports the spans to an OpenTelemetry collector with OTLP over HTTP, in its
+// JSON encoding. It implements handlers.Tracer without the OpenTelemetry SDK.
+//
+// Traces are propagated in the "traceparent" header of the W3C Trace Context:
+// the span of a request continues the trace of the header of the request, and
+// Transport adds the header to outgoing requests, e.g. to upstream tile
+// servers.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/consbio/mbtileserver/handlers"
+)
+
+const (
+	// batchSize is the number of spans that are exported at once.
+	batchSize = 512
+	// maxQueued is the number of ended spans that are kept while the
+	// collector is unavailable, beyond which further spans are dropped.
+	maxQueued = 4 * batchSize
+	// exportInterval is the maximum time between the end of a span and its
+	// export.
+	exportInterval = 5 * time.Second
+)
+
+// Span kinds of OTLP
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent parses the value of a traceparent header. It reports false
+// if it is invalid.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	// future versions may append fields, version 00 must not
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || n != len(sc.TraceID) || len(parts[1]) != 2*n {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || n != len(sc.SpanID) || len(parts[2]) != 2*n {
+		return sc, false
+	}
+	if sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 != 0
+	return sc, true
+}
+
+// Traceparent returns the value of the traceparent header of sc.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+type spanKey struct{}
+
+// FromContext returns the SpanContext of the span of ctx, if any.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	s, ok := ctx.Value(spanKey{}).(*span)
+	if !ok {
+		return SpanContext{}, false
+	}
+	return s.sc, true
+}
+
+// Inject sets the traceparent header of h to the span of ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := FromContext(ctx); ok {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Transport returns a http.RoundTripper that adds the traceparent header of
+// the span of the context of each request to it and sends it with rt, or
+// http.DefaultTransport if rt is nil.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return transport{rt}
+}
+
+type transport struct {
+	rt http.RoundTripper
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	sc, ok := FromContext(r.Context())
+	if !ok {
+		return t.rt.RoundTrip(r)
+	}
+	// a RoundTripper must not modify the request
+	r = r.Clone(r.Context())
+	r.Header.Set("traceparent", sc.Traceparent())
+	return t.rt.RoundTrip(r)
+}
+
+// Tracer creates the spans of the requests to the handlers and exports them to
+// an OTLP collector. It implements handlers.Tracer.
+type Tracer struct {
+	service  string
+	endpoint string
+	// Client sends the spans to the collector, http.DefaultClient if it is
+	// nil.
+	Client *http.Client
+	// ErrorHandler, if not nil, is called with the errors of exports.
+	ErrorHandler func(error)
+
+	mu      sync.Mutex
+	queue   []*span
+	flush   chan chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewTracer returns a Tracer that exports the spans of the service named
+// service to the OTLP/HTTP collector at the base URL endpoint, like
+// "http://localhost:4318". The spans are exported in batches in the
+// background until the Tracer is closed.
+func NewTracer(endpoint, service string) *Tracer {
+	t := &Tracer{
+		service:  service,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		flush:    make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartRequest starts the server span of the request r, which continues the
+// trace of its traceparent header or starts a new one.
+func (t *Tracer) StartRequest(r *http.Request, name string) (context.Context, handlers.Span) {
+	parent, ok := ParseTraceparent(r.Header.Get("traceparent"))
+	if !ok {
+		parent = SpanContext{Sampled: true}
+	}
+	return t.start(r.Context(), name, parent, kindServer)
+}
+
+// Start starts a span as child of the span of ctx, or of a new trace if ctx
+// has none.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, handlers.Span) {
+	parent, ok := FromContext(ctx)
+	if !ok {
+		parent = SpanContext{Sampled: true}
+	}
+	return t.start(ctx, name, parent, kindInternal)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, parent SpanContext, kind int) (context.Context, handlers.Span) {
+	s := &span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		parent: parent.SpanID,
+		sc:     SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled},
+		start:  time.Now(),
+		attrs:  make(map[string]interface{}),
+	}
+	if s.sc.TraceID == [16]byte{} {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// span is a span of a Tracer, which is queued for the export when it ends.
+type span struct {
+	tracer *Tracer
+	name   string
+	kind   int
+	parent [8]byte
+	sc     SpanContext
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	end   time.Time
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	ended := !s.end.IsZero()
+	if !ended {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+	if !ended && s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// enqueue queues the ended span s for the export and triggers it once a batch
+// is complete.
+func (t *Tracer) enqueue(s *span) {
+	t.mu.Lock()
+	if len(t.queue) < maxQueued {
+		t.queue = append(t.queue, s)
+	}
+	full := len(t.queue) >= batchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- nil:
+		default:
+		}
+	}
+}
+
+// run exports the queued spans every exportInterval, whenever a batch is
+// complete and when the Tracer is flushed or closed.
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	tick := time.NewTicker(exportInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.export()
+		case done := <-t.flush:
+			t.export()
+			if done != nil {
+				close(done)
+			}
+		case <-t.stop:
+			t.export()
+			return
+		}
+	}
+}
+
+// Flush exports all ended spans.
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-t.stopped:
+	}
+}
+
+// Close exports all ended spans and stops the export.
+func (t *Tracer) Close() error {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.stopped
+	return nil
+}
+
+// export sends the queued spans to the collector in batches. Batches that the
+// collector does not accept are dropped.
+func (t *Tracer) export() {
+	for {
+		t.mu.Lock()
+		n := len(t.queue)
+		if n > batchSize {
+			n = batchSize
+		}
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		t.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := t.send(batch); err != nil && t.ErrorHandler != nil {
+			t.ErrorHandler(err)
+		}
+	}
+}
+
+// send posts the spans to the collector.
+func (t *Tracer) send(spans []*span) error {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot export %d spans: %v", len(spans), err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot export %d spans: collector returned %s", len(spans), resp.Status)
+	}
+	return nil
+}
+
+// The JSON encoding of an ExportTraceServiceRequest of OTLP, in which IDs are
+// hex encoded and 64 bit integers are strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []jsonSpan `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	jsonSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            *status    `json:"status,omitempty"`
+	}
+	status struct {
+		Code int `json:"code"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// statusError is the status code of spans that failed.
+const statusError = 2
+
+// request returns the export request of spans.
+func (t *Tracer) request(spans []*span) exportRequest {
+	out := make([]jsonSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		out[i] = jsonSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			out[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for k, v := range s.attrs {
+			out[i].Attributes = append(out[i].Attributes, keyValue{k, value(v)})
+		}
+		// server errors fail the span of the request
+		if code, ok := s.attrs["http.status_code"].(int); ok && code >= 500 {
+			out[i].Status = &status{Code: statusError}
+		}
+		s.mu.Unlock()
+	}
+	service := t.service
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{"service.name", anyValue{StringValue: &service}}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "github.com/consbio/mbtileserver"}, Spans: out}},
+	}}}
+}
+
+// value returns the OTLP value of an attribute.
+func value(v interface{}) anyValue {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &s}
+	case uint8:
+		s := strconv.Itoa(int(v))
+		return anyValue{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return anyValue{StringValue: &s}
+	}
+}
@@ -0,0 +1,143 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01", false, false},
+		{"", false, false},
+	}
+	for _, tc := range tests {
+		sc, ok := ParseTraceparent(tc.header)
+		if ok != tc.valid || sc.Sampled != tc.sampled {
+			t.Errorf("%q: expected %v, sampled %v, got %v, %v", tc.header, tc.valid, tc.sampled, ok, sc.Sampled)
+		}
+		if ok && tc.header[:2] == "00" && sc.Traceparent() != tc.header {
+			t.Errorf("%q: got traceparent %q", tc.header, sc.Traceparent())
+		}
+	}
+}
+
+// testCollector records the spans that are exported to it.
+type testCollector struct {
+	mu    sync.Mutex
+	spans map[string]jsonSpan
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				c.spans[s.Name] = s
+			}
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	c := &testCollector{spans: make(map[string]jsonSpan)}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	tracer := NewTracer(ts.URL, "test")
+	tracer.ErrorHandler = func(err error) { t.Error(err) }
+
+	var upstream string
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("traceparent")
+	}))
+	defer us.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	r := httptest.NewRequest("GET", "/services", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.StartRequest(r, "GET /services")
+	span.SetAttribute("http.status_code", http.StatusInternalServerError)
+	childCtx, child := tracer.Start(ctx, "mbtiles.Read")
+	child.SetAttribute("tile.z", uint8(3))
+	req, _ := http.NewRequest("GET", us.URL, nil)
+	resp, err := client.Do(req.WithContext(childCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	child.End()
+	span.End()
+
+	// unsampled traces are not exported
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, unsampled := tracer.StartRequest(r, "unsampled")
+	unsampled.End()
+
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(c.spans))
+	}
+	s, cs := c.spans["GET /services"], c.spans["mbtiles.Read"]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" || s.Kind != kindServer {
+		t.Errorf("expected server span in the propagated trace, got %+v", s)
+	}
+	if s.Status == nil || s.Status.Code != statusError {
+		t.Errorf("expected failed span for status 500, got %+v", s.Status)
+	}
+	if cs.TraceID != s.TraceID || cs.ParentSpanID != s.SpanID || cs.Kind != kindInternal {
+		t.Errorf("expected child span of %s, got %+v", s.SpanID, cs)
+	}
+	if len(cs.Attributes) != 1 || cs.Attributes[0].Key != "tile.z" || cs.Attributes[0].Value.IntValue == nil || *cs.Attributes[0].Value.IntValue != "3" {
+		t.Errorf("expected attribute tile.z 3, got %+v", cs.Attributes)
+	}
+	if want := "00-" + cs.TraceID + "-" + cs.SpanID + "-01"; upstream != want {
+		t.Errorf("expected traceparent %s upstream, got %q", want, upstream)
+	}
+}
+
+func TestInject(t *testing.T) {
+	h := make(http.Header)
+	Inject(context.Background(), h)
+	if v := h.Get("traceparent"); v != "" {
+		t.Errorf("expected no traceparent without span, got %q", v)
+	}
+	tracer := NewTracer("http://127.0.0.1:0", "test")
+	defer tracer.Close()
+	ctx, _ := tracer.Start(context.Background(), "root")
+	Inject(ctx, h)
+	sc, ok := ParseTraceparent(h.Get("traceparent"))
+	if want, _ := FromContext(ctx); !ok || sc != want {
+		t.Errorf("expected traceparent of %+v, got %q", want, h.Get("traceparent"))
+	}
+}
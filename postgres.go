@@ -0,0 +1,5 @@
+package main
+
+// The PostgreSQL driver is linked into the server, so that .postgis tilesets,
+// which use the driver "postgres" by default, are served by the same process.
+import _ "github.com/lib/pq"
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestPostgresDriver(t *testing.T) {
+	for _, name := range sql.Drivers() {
+		if name == "postgres" {
+			return
+		}
+	}
+	t.Errorf("expected the postgres driver of .postgis tilesets, got %v", sql.Drivers())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// routeRoots are the first path segments of the routes of the server, which
+// cannot be used as URL prefixes.
+var routeRoots = []string{"services", "arcgis", "ogc", "composite", "static", "styles", "fonts", "sprites", "health", "ready", "metrics", "admin"}
+
+// parseURLPrefix returns the URL prefix of the server from the --url-prefix
+// or --path prefix or the path of the --root-url rootURL, without leading and
+// trailing "/".
+func parseURLPrefix(prefix, rootURL string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if len(rootURL) > 0 {
+		u, err := url.Parse(rootURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return "", fmt.Errorf("invalid root URL %q, expected an absolute http or https URL", rootURL)
+		}
+		p := strings.Trim(u.Path, "/")
+		if len(prefix) > 0 && prefix != p {
+			return "", fmt.Errorf("URL prefix %q differs from the path of the root URL %q", prefix, rootURL)
+		}
+		prefix = p
+	}
+	first := strings.SplitN(prefix, "/", 2)[0]
+	for _, r := range routeRoots {
+		if first == r {
+			return "", fmt.Errorf("URL prefix %q conflicts with the routes below /%s", prefix, r)
+		}
+	}
+	return prefix, nil
+}
+
+// stripURLPrefix returns a middleware that removes the URL prefix, without
+// leading "/", from the paths of requests that a reverse proxy has forwarded
+// without removing it.
+func stripURLPrefix(prefix string) echo.MiddlewareFunc {
+	prefix = "/" + prefix
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			if p := r.URL.Path; p == prefix || strings.HasPrefix(p, prefix+"/") {
+				r.URL.Path = "/" + strings.TrimPrefix(p[len(prefix):], "/")
+				r.URL.RawPath = ""
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// remoteObject is an object in an objectStore.
+type remoteObject struct {
+	key      string
+	size     int64
+	modified time.Time
+}
+
+// objectStore is a bucket or container of an object storage service.
+type objectStore interface {
+	// list returns the objects whose keys start with prefix.
+	list(prefix string) ([]remoteObject, error)
+	// objectURL returns the HTTP URL of the object key.
+	objectURL(key string) string
+	// authorize adds the credentials for the object storage to req.
+	authorize(req *http.Request)
+}
+
+// remoteClient is the HTTP client for requests to object storage services.
+var remoteClient = &http.Client{}
+
+// isRemote reports whether the tileset directory dir is the URL of an object
+// storage location.
+func isRemote(dir string) bool {
+	return strings.Contains(dir, "://")
+}
+
+// remoteRoot mirrors the mbtiles files in an object storage location to a
+// local directory, from which they are served.
+type remoteRoot struct {
+	url    string
+	store  objectStore
+	prefix string
+	dir    string
+}
+
+// parseObjectURL returns the object store and the key or prefix of the
+// location rawurl, which has the form s3://bucket/key, gs://bucket/key or
+// az://account/container/key.
+func parseObjectURL(rawurl string) (*url.URL, objectStore, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if u.Host == "" {
+		return nil, nil, "", fmt.Errorf("missing bucket in %s", rawurl)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return u, newS3Store(u.Host), key, nil
+	case "gs":
+		return u, newGCSStore(u.Host), key, nil
+	case "az":
+		i := strings.Index(key, "/")
+		if i < 0 {
+			i = len(key)
+		}
+		if i == 0 {
+			return nil, nil, "", fmt.Errorf("missing container in %s", rawurl)
+		}
+		return u, newAzureStore(u.Host, key[:i]), strings.TrimPrefix(key[i:], "/"), nil
+	}
+	return nil, nil, "", fmt.Errorf("unsupported object storage %s", rawurl)
+}
+
+// newRemoteRoot returns the remoteRoot of the location rawurl, as understood
+// by parseObjectURL. The files are mirrored to a subdirectory of cacheDir.
+func newRemoteRoot(rawurl, cacheDir string) (*remoteRoot, error) {
+	u, store, prefix, err := parseObjectURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	// the prefix is a directory, "tiles" must not match "tiles2/a.mbtiles"
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	dir := filepath.Join(cacheDir, u.Scheme, u.Host, filepath.FromSlash(u.Path))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &remoteRoot{
+		url:    rawurl,
+		store:  store,
+		prefix: prefix,
+		dir:    dir,
+	}, nil
+}
+
+// sync downloads the mbtiles files that have been added or modified since the
+// last sync and deletes the local copies of the ones that have been removed.
+func (r *remoteRoot) sync() error {
+	objects, err := r.store.list(r.prefix)
+	if err != nil {
+		return fmt.Errorf("cannot list mbtiles files in %s: %v", r.url, err)
+	}
+	listed := make(map[string]bool)
+	for _, o := range objects {
+		if !mbtiles.IsTileset(o.key) {
+			continue
+		}
+		filename := filepath.Join(r.dir, filepath.FromSlash(strings.TrimPrefix(o.key, r.prefix)))
+		if !strings.HasPrefix(filename, r.dir+string(filepath.Separator)) {
+			log.Errorf("Skipping object %s in %s outside of the prefix", o.key, r.url)
+			continue
+		}
+		listed[filename] = true
+		if fi, err := os.Stat(filename); err == nil && fi.Size() == o.size && fi.ModTime().Equal(o.modified) {
+			continue
+		}
+		if err := r.download(o, filename); err != nil {
+			log.Errorf("Cannot download %s from %s: %v", o.key, r.url, err)
+			continue
+		}
+		log.Infof("Downloaded %s from %s to %s", o.key, r.url, filename)
+	}
+	filenames, err := findTilesets(r.dir, -1)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if listed[filename] {
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			log.Errorf("%v", err)
+			continue
+		}
+		log.Infof("Removed %s, which is no longer in %s", filename, r.url)
+	}
+	return nil
+}
+
+// download writes the object o to the file filename, replacing it
+// atomically, with the modification time of the object.
+func (r *remoteRoot) download(o remoteObject, filename string) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	body, err := remoteGet(r.store.objectURL(o.key), r.store.authorize)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	tmp, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once it has been renamed
+	n, err := io.Copy(tmp, body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != o.size {
+		return fmt.Errorf("expected %d bytes, got %d", o.size, n)
+	}
+	if err := os.Chtimes(tmp.Name(), o.modified, o.modified); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// remoteGet sends a GET request for u, which is passed to authorize, and
+// returns the response body if the request succeeded.
+func remoteGet(u string, authorize func(*http.Request)) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// s3Store is a bucket of Amazon S3 or of a service with an S3 compatible API.
+// Requests are signed with AWS Signature Version 4 if there is an access key,
+// otherwise the bucket must be public.
+type s3Store struct {
+	base         string // URL of the bucket, ending with "/"
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newS3Store returns the S3 bucket with the credentials and region from the
+// environment variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION. AWS_ENDPOINT_URL selects an S3
+// compatible service, whose buckets are addressed by path.
+func newS3Store(bucket string) *s3Store {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", bucket, region)
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		base = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/"
+	}
+	return &s3Store{
+		base:         base,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// newGCSStore returns the Google Cloud Storage bucket, which is accessed with
+// its S3 compatible XML API and the HMAC key from the environment variables
+// GCS_HMAC_ACCESS_ID and GCS_HMAC_SECRET.
+func newGCSStore(bucket string) *s3Store {
+	return &s3Store{
+		base:      "https://storage.googleapis.com/" + bucket + "/",
+		region:    "auto",
+		accessKey: os.Getenv("GCS_HMAC_ACCESS_ID"),
+		secretKey: os.Getenv("GCS_HMAC_SECRET"),
+	}
+}
+
+func (s *s3Store) list(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	marker := ""
+	for {
+		q := url.Values{"prefix": {prefix}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		body, err := remoteGet(s.base+"?"+awsQuery(q), s.authorize)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated bool
+			Contents    []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+		}
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, remoteObject{c.Key, c.Size, c.LastModified})
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return objects, nil
+		}
+		marker = result.Contents[len(result.Contents)-1].Key
+	}
+}
+
+func (s *s3Store) objectURL(key string) string {
+	return s.base + awsEscape(key, true)
+}
+
+// authorize signs the request req with AWS Signature Version 4.
+func (s *s3Store) authorize(req *http.Request) {
+	if s.accessKey == "" {
+		return
+	}
+	s.sign(req, time.Now())
+}
+
+// sign signs the request req without body with AWS Signature Version 4 at the
+// time now.
+func (s *s3Store) sign(req *http.Request, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	day := date[:8]
+	emptyHash := sha256.Sum256(nil)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(emptyHash[:]))
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var headers bytes.Buffer
+	for _, k := range names {
+		v := req.URL.Host
+		if k != "host" {
+			v = strings.TrimSpace(req.Header.Get(k))
+		}
+		headers.WriteString(k + ":" + v + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, true),
+		awsQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape escapes all characters of s except the unreserved ones of RFC
+// 3986 and, if keepSlash is true, "/", as required by AWS Signature Version 4.
+func awsEscape(s string, keepSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsQuery returns the query q sorted by key and escaped by awsEscape.
+func awsQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// azureStore is a container of Azure Blob Storage. Requests are authorized
+// with the shared access signature in the environment variable
+// AZURE_STORAGE_SAS_TOKEN if it is set, otherwise the container must be
+// public.
+type azureStore struct {
+	base string // URL of the container, ending with "/"
+	sas  url.Values
+}
+
+func newAzureStore(account, container string) *azureStore {
+	sas, _ := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+	return &azureStore{
+		base: fmt.Sprintf("https://%s.blob.core.windows.net/%s/", account, container),
+		sas:  sas,
+	}
+}
+
+func (a *azureStore) objectURL(key string) string {
+	return a.base + awsEscape(key, true)
+}
+
+// authorize adds the shared access signature and the API version to req.
+func (a *azureStore) authorize(req *http.Request) {
+	req.Header.Set("X-Ms-Version", "2020-10-02")
+	if len(a.sas) == 0 {
+		return
+	}
+	q := req.URL.Query()
+	for k, v := range a.sas {
+		q[k] = v
+	}
+	req.URL.RawQuery = q.Encode()
+}
+
+func (a *azureStore) list(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	marker := ""
+	for {
+		q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		body, err := remoteGet(strings.TrimSuffix(a.base, "/")+"?"+q.Encode(), a.authorize)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Blobs []struct {
+				Name       string
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+				}
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range result.Blobs {
+			modified, err := http.ParseTime(b.Properties.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modification time of %s: %v", b.Name, err)
+			}
+			objects = append(objects, remoteObject{b.Name, b.Properties.ContentLength, modified})
+		}
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// authorizingTransport is a http.RoundTripper that adds the credentials of an
+// object store to requests.
+type authorizingTransport struct {
+	store objectStore
+}
+
+func (t authorizingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	r.URL = &u
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	t.store.authorize(r)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// remoteTileset returns the HTTP URL of the mbtiles file at rawurl and the
+// client with which it is read. rawurl is either an HTTP URL or the location
+// of an object, as understood by parseObjectURL, which is read with the
+// credentials of the object store.
+func remoteTileset(rawurl string) (string, *http.Client, error) {
+	if mbtiles.IsRemote(rawurl) {
+		return rawurl, http.DefaultClient, nil
+	}
+	_, store, key, err := parseObjectURL(rawurl)
+	if err != nil {
+		return "", nil, err
+	}
+	if key == "" {
+		return "", nil, fmt.Errorf("missing object in %s", rawurl)
+	}
+	return store.objectURL(key), &http.Client{Transport: authorizingTransport{store}}, nil
+}
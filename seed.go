@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var (
+	seedMinZoom     int
+	seedMaxZoom     int
+	seedBounds      string
+	seedConcurrency int
+	seedProgress    time.Duration
+)
+
+var seedCmd = &cobra.Command{
+	Use:   "seed <service URL or tileset> --maxzoom <z>",
+	Short: "Fetch the tiles of a region to fill caches",
+	Long: `Seed requests the tiles of a region from the service of a running server,
+like http://localhost:8000/services/osm, to fill its response caches and those
+of CDNs in front of it. Given a tileset file instead, like a .proxy file, seed
+reads its tiles, which fills the cache of a proxy tileset from its upstream
+server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Exactly one service URL or tileset is required")
+		}
+		if seedMaxZoom < 0 {
+			log.Fatalln("The highest zoom level is required (--maxzoom)")
+		}
+		if seedConcurrency < 1 {
+			log.Fatalln("The concurrency must be at least 1")
+		}
+		f, err := parseTileFilter(seedMinZoom, seedMaxZoom, seedBounds)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		var fetch seedFunc
+		scheme := mbtiles.XYZ
+		if strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://") {
+			fetch, scheme, err = serviceSeeder(args[0])
+			if err != nil {
+				log.Fatalf("Could not read TileJSON of %s: %v", args[0], err)
+			}
+		} else {
+			db, err := mbtiles.NewDB(args[0])
+			if err != nil {
+				log.Fatalf("Could not open %s: %v", args[0], err)
+			}
+			defer db.Close()
+			fetch = func(ctx context.Context, z uint8, x, y uint64) (bool, error) {
+				var data []byte
+				err := db.ReadTileContext(ctx, z, x, y, &data)
+				if err == mbtiles.ErrTileNotFound {
+					return false, nil
+				}
+				return err == nil, err
+			}
+		}
+
+		p := seed(context.Background(), f, scheme, fetch, seedConcurrency, seedProgress)
+		log.Infof("Seeded %d tiles of %s (%d missing, %d failed)", p.done, args[0], p.missing, p.failed)
+		if p.failed > 0 {
+			log.Fatalf("%d tiles could not be seeded", p.failed)
+		}
+	},
+}
+
+func init() {
+	flags := seedCmd.Flags()
+	flags.IntVar(&seedMinZoom, "minzoom", 0, "Lowest zoom level of the seeded tiles.")
+	flags.IntVar(&seedMaxZoom, "maxzoom", -1, "Highest zoom level of the seeded tiles.")
+	flags.StringVar(&seedBounds, "bounds", "", "Bounding box west,south,east,north in degrees of the seeded tiles (the whole world if empty).")
+	flags.IntVar(&seedConcurrency, "concurrency", 4, "Number of tiles that are requested concurrently.")
+	flags.DurationVar(&seedProgress, "progress", 10*time.Second, "Interval in which the progress is logged (0 disables progress reports).")
+	RootCmd.AddCommand(seedCmd)
+}
+
+// seedFunc fetches the tile at z, x, y and reports whether it exists.
+type seedFunc func(ctx context.Context, z uint8, x, y uint64) (bool, error)
+
+// seedStats counts the tiles that have been seeded.
+type seedStats struct {
+	total, done, missing, failed uint64
+}
+
+// seed calls fetch for the tiles of the filter f, whose rows are in the
+// scheme s, with the given number of concurrent calls, and logs the progress
+// in the interval every.
+func seed(ctx context.Context, f mbtiles.TileFilter, s mbtiles.TileScheme, fetch seedFunc, concurrency int, every time.Duration) seedStats {
+	p := seedStats{total: mbtiles.CountTiles(f)}
+	type coord struct {
+		z    uint8
+		x, y uint64
+	}
+	coords := make(chan coord, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range coords {
+				ok, err := fetch(ctx, c.z, c.x, c.y)
+				switch {
+				case err != nil:
+					atomic.AddUint64(&p.failed, 1)
+					log.Warnf("Could not seed tile %d/%d/%d: %v", c.z, c.x, c.y, err)
+				case !ok:
+					atomic.AddUint64(&p.missing, 1)
+				}
+				atomic.AddUint64(&p.done, 1)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	if every > 0 {
+		go func() {
+			t := time.NewTicker(every)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					done := atomic.LoadUint64(&p.done)
+					log.Infof("Seeded %d of %d tiles (%.1f%%)", done, p.total, 100*float64(done)/float64(p.total))
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	for _, z := range f.Zooms {
+		minX, maxX, minY, maxY := mbtiles.TileRange(f, z, s)
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
+				coords <- coord{z, x, y}
+			}
+		}
+	}
+	close(coords)
+	wg.Wait()
+	close(stop)
+	return p
+}
+
+// serviceSeeder returns the seedFunc that requests the tiles of the service
+// at the URL u from the tile URL template in its TileJSON, and the scheme of
+// their rows.
+func serviceSeeder(u string) (seedFunc, mbtiles.TileScheme, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var tilejson struct {
+		Tiles  []string `json:"tiles"`
+		Scheme string   `json:"scheme"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tilejson); err != nil {
+		return nil, 0, err
+	}
+	if len(tilejson.Tiles) == 0 {
+		return nil, 0, fmt.Errorf("no tile URLs")
+	}
+	scheme, err := mbtiles.ParseTileScheme(tilejson.Scheme)
+	if err != nil {
+		scheme = mbtiles.XYZ
+	}
+	template := tilejson.Tiles[0]
+	return func(ctx context.Context, z uint8, x, y uint64) (bool, error) {
+		u := strings.NewReplacer(
+			"{z}", strconv.Itoa(int(z)),
+			"{x}", strconv.FormatUint(x, 10),
+			"{y}", strconv.FormatUint(y, 10),
+		).Replace(template)
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		// the body is read, so that caches store the whole response
+		io.Copy(ioutil.Discard, resp.Body)
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNoContent, http.StatusNotFound:
+			return false, nil
+		}
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}, scheme, nil
+}
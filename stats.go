@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var statsJSON bool
+
+var statsCmd = &cobra.Command{
+	Use:   "stats <file.mbtiles>...",
+	Short: "Print tile statistics of mbtiles files",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatalln("At least one mbtiles file is required")
+		}
+		for _, filename := range args {
+			if err := printStats(filename); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	},
+}
+
+func init() {
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Print statistics as JSON")
+	RootCmd.AddCommand(statsCmd)
+}
+
+func printStats(filename string) error {
+	db, err := mbtiles.NewDB(filename, mbtiles.ReadOnly())
+	if err != nil {
+		return fmt.Errorf("could not open mbtiles file %q: %v", filename, err)
+	}
+	defer db.Close()
+	s, err := db.Stats()
+	if err != nil {
+		return fmt.Errorf("could not compute statistics for %q: %v", filename, err)
+	}
+
+	if statsJSON {
+		return json.NewEncoder(os.Stdout).Encode(s)
+	}
+
+	fmt.Printf("%s\n\n", filename)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "zoom\ttiles\tbytes\tmin size\tmax size\tavg size\t")
+	for _, z := range s.Zooms {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t\n", z.Zoom, z.Count, z.Bytes, z.MinSize, z.MaxSize, z.Bytes/z.Count)
+	}
+	var avg int64
+	if s.Count > 0 {
+		avg = s.Bytes / s.Count
+	}
+	fmt.Fprintf(w, "all\t%d\t%d\t%d\t%d\t%d\t\n", s.Count, s.Bytes, s.MinSize, s.MaxSize, avg)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/consbio/mbtileserver/handlers"
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+// findTilesets returns the filenames of all tileset files and directories of
+// tiles below dir, in lexical order. Subdirectories are scanned up to
+// maxDepth levels below dir, or all of them if maxDepth is negative.
+func findTilesets(dir string, maxDepth int) ([]string, error) {
+	var filenames []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir && mbtiles.IsTileDir(path) {
+			filenames = append(filenames, path)
+			return filepath.SkipDir
+		}
+		if info.IsDir() && tooDeep(dir, path, maxDepth) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && mbtiles.IsTileset(path) {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	return filenames, err
+}
+
+// tooDeep reports whether the directory path is more than maxDepth levels
+// below dir, unless maxDepth is negative.
+func tooDeep(dir, path string, maxDepth int) bool {
+	if maxDepth < 0 || path == dir {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err != nil || strings.Count(filepath.ToSlash(rel), "/") >= maxDepth
+}
+
+// tileRoot is a directory with mbtiles files, whose tilesets are served
+// below the URL namespace, if any.
+type tileRoot struct {
+	dir       string
+	namespace string
+}
+
+// parseTileRoot parses a tile root of the form "dir" or "dir=namespace".
+func parseTileRoot(s string) tileRoot {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return tileRoot{dir: s}
+	}
+	return tileRoot{
+		dir:       s[:i],
+		namespace: strings.ToLower(strings.Trim(s[i+1:], "/")),
+	}
+}
+
+// tilesetID returns the ID of the tileset in the mbtiles file filename below
+// the directory of root, as derived by strategy and prefixed with the
+// namespace of root.
+func tilesetID(root tileRoot, filename string, strategy handlers.IDStrategy) (string, error) {
+	id, err := strategy(root.dir, filename)
+	if err != nil {
+		return "", err
+	}
+	if root.namespace != "" {
+		id = root.namespace + "/" + id
+	}
+	return id, nil
+}
+
+// ID collision policies
+const (
+	collisionSkip   = "skip"
+	collisionError  = "error"
+	collisionSuffix = "suffix"
+)
+
+// idAssigner assigns unique tileset IDs to mbtiles files. If the ID of a file
+// is already used by another one, the collision policy decides whether it is
+// an error or the ID gets a numeric suffix.
+type idAssigner struct {
+	strategy   handlers.IDStrategy
+	collisions string
+	byFile     map[string]string
+	byID       map[string]string
+}
+
+func newIDAssigner(strategy, collisions string) (*idAssigner, error) {
+	s, ok := handlers.IDStrategies[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown ID strategy: %s", strategy)
+	}
+	switch collisions {
+	case collisionSkip, collisionError, collisionSuffix:
+	default:
+		return nil, fmt.Errorf("unknown ID collision policy: %s", collisions)
+	}
+	return &idAssigner{
+		strategy:   s,
+		collisions: collisions,
+		byFile:     make(map[string]string),
+		byID:       make(map[string]string),
+	}, nil
+}
+
+// assign returns the ID of the file filename below root, which is assigned
+// on the first call for filename.
+func (a *idAssigner) assign(root tileRoot, filename string) (string, error) {
+	if id, ok := a.byFile[filename]; ok {
+		return id, nil
+	}
+	id, err := tilesetID(root, filename, a.strategy)
+	if err != nil {
+		return "", fmt.Errorf("unable to extract ID for file: %s\n%v", filename, err)
+	}
+	return a.claim(filename, id)
+}
+
+// claim assigns the ID id to the file filename, subject to the collision
+// policy.
+func (a *idAssigner) claim(filename, id string) (string, error) {
+	if other, ok := a.byID[id]; ok {
+		if a.collisions != collisionSuffix {
+			return "", fmt.Errorf("ID %q of %s is already used by %s", id, filename, other)
+		}
+		base := id
+		for i := 2; ok; i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+			_, ok = a.byID[id]
+		}
+	}
+	a.byFile[filename] = id
+	a.byID[id] = filename
+	return id, nil
+}
+
+// release frees the ID of the file filename.
+func (a *idAssigner) release(filename string) {
+	delete(a.byID, a.byFile[filename])
+	delete(a.byFile, filename)
+}
+
+// fileState is the state of an mbtiles file as seen by a tilesetWatcher.
+type fileState struct {
+	info os.FileInfo
+}
+
+// equal reports whether the file has not been modified or replaced between
+// the states s and t.
+func (s fileState) equal(t fileState) bool {
+	return os.SameFile(s.info, t.info) && s.info.Size() == t.info.Size() && s.info.ModTime().Equal(t.info.ModTime())
+}
+
+// tilesetWatcher polls tile roots for mbtiles files that have been added,
+// removed, modified or replaced, when notified of changes or periodically,
+// and adds, removes or reopens them in a ServiceSet. Requests in progress
+// continue with the previous DB of a reopened file.
+// Added and modified files are only opened once their size and modification
+// time did not change between two polls, so that files that are still being
+// written are not served. Rescans, which are requested by the operator, open
+// them right away.
+type tilesetWatcher struct {
+	svcSet   *handlers.ServiceSet
+	roots    []tileRoot
+	remotes  []*remoteRoot
+	maxDepth int
+	opts     []mbtiles.Option
+	// mu guards ids, served and pending, as uploads are recorded while
+	// the tile roots are polled. The ServiceSet holds it as its UploadLock.
+	mu  sync.Mutex
+	ids *idAssigner
+	// served are the files in the ServiceSet, pending the ones that have
+	// changed since they have been opened or seen last.
+	served  map[string]fileState
+	pending map[string]fileState
+}
+
+func newTilesetWatcher(svcSet *handlers.ServiceSet, roots []tileRoot, remotes []*remoteRoot, maxDepth int, ids *idAssigner, filenames []string, opts []mbtiles.Option) *tilesetWatcher {
+	w := &tilesetWatcher{
+		svcSet:   svcSet,
+		roots:    roots,
+		remotes:  remotes,
+		maxDepth: maxDepth,
+		ids:      ids,
+		opts:     opts,
+		served:   make(map[string]fileState),
+		pending:  make(map[string]fileState),
+	}
+	for _, filename := range filenames {
+		if fi, err := os.Stat(filename); err == nil {
+			w.served[filename] = fileState{fi}
+		}
+	}
+	return w
+}
+
+// watchSettle is the time for which changes below the tile roots are
+// collected after a change notification, before they are polled.
+var watchSettle = time.Second
+
+// watch synchronizes the remote roots and polls the tile roots every
+// interval, unless it is zero, shortly after fw reports changes, unless it is
+// nil, and whenever a value is received from rescan. It closes fw and returns
+// once rescan is closed.
+func (w *tilesetWatcher) watch(interval time.Duration, fw *fsnotify.Watcher, rescan <-chan struct{}) {
+	var changed <-chan struct{}
+	if fw != nil {
+		defer fw.Close()
+		changed = w.changes(fw)
+	}
+	// time.Tick returns nil for zero intervals, which never delivers
+	tick := time.Tick(interval)
+	var settle <-chan time.Time
+	for {
+		rescanning, syncing := false, true
+		select {
+		case <-tick:
+		case <-changed:
+			if settle == nil {
+				settle = time.After(watchSettle)
+			}
+			continue
+		case <-settle:
+			settle, syncing = nil, false
+		case _, ok := <-rescan:
+			if !ok {
+				return
+			}
+			rescanning = true
+			log.Info("Rescanning tileset directories")
+		}
+		// the remote roots do not send notifications
+		if syncing {
+			for _, r := range w.remotes {
+				if err := r.sync(); err != nil {
+					log.Errorf("%v", err)
+				}
+			}
+		}
+		w.poll(rescanning)
+		// files that are still being written are opened once they are
+		// unchanged, which needs another poll without notifications
+		if changed != nil && settle == nil && len(w.pending) > 0 {
+			settle = time.After(watchSettle)
+		}
+	}
+}
+
+// notify returns a watcher for changes to the tile roots and the
+// directories below them that are scanned for tilesets.
+func (w *tilesetWatcher) notify() (*fsnotify.Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range w.roots {
+		if err := w.watchDirs(fw, root.dir, root.dir); err != nil {
+			fw.Close()
+			return nil, err
+		}
+	}
+	return fw, nil
+}
+
+// watchDirs adds dir and the directories below it, up to the maximum depth
+// below the tile root dir root, to fw. Directories of tiles are not watched,
+// as they are served as a whole.
+func (w *tilesetWatcher) watchDirs(fw *fsnotify.Watcher, root, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && mbtiles.IsTileDir(path) {
+			return filepath.SkipDir
+		}
+		if tooDeep(root, path, w.maxDepth) {
+			return filepath.SkipDir
+		}
+		return fw.Add(path)
+	})
+}
+
+// changes returns a channel that receives a value whenever something below
+// the tile roots watched by fw has changed. Directories that are created
+// below them are watched as well.
+func (w *tilesetWatcher) changes(fw *fsnotify.Watcher) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case ev, ok := <-fw.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Create) {
+					if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+						if root, ok := w.rootOf(ev.Name); ok {
+							if err := w.watchDirs(fw, root.dir, ev.Name); err != nil {
+								log.Errorf("Cannot watch %s for changes: %v", ev.Name, err)
+							}
+						}
+					}
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			case err, ok := <-fw.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Error watching tileset directories: %v", err)
+			}
+		}
+	}()
+	return changed
+}
+
+// rootOf returns the tile root that contains the file or directory path.
+func (w *tilesetWatcher) rootOf(path string) (tileRoot, bool) {
+	for _, root := range w.roots {
+		rel, err := filepath.Rel(root.dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root, true
+		}
+	}
+	return tileRoot{}, false
+}
+
+// poll compares the mbtiles files in the tile roots with the served ones and
+// updates the ServiceSet accordingly. Added and changed files are opened right
+// away if rescan is set, otherwise once they are unchanged since the last
+// poll.
+func (w *tilesetWatcher) poll(rescan bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, root := range w.roots {
+		filenames, err := findTilesets(root.dir, w.maxDepth)
+		if err != nil {
+			log.Errorf("Unable to scan tileset directory %s for mbtiles files: %v", root.dir, err)
+			// do not remove the tilesets of a temporarily unavailable root
+			return
+		}
+		w.pollFiles(root, filenames, seen, rescan)
+	}
+	for filename := range w.served {
+		if !seen[filename] {
+			w.remove(filename)
+		}
+	}
+	for filename := range w.pending {
+		if !seen[filename] {
+			delete(w.pending, filename)
+		}
+	}
+}
+
+// pollFiles opens the files filenames below root that have been added or
+// changed, right away if rescan is set, and records them as seen.
+func (w *tilesetWatcher) pollFiles(root tileRoot, filenames []string, seen map[string]bool, rescan bool) {
+	for _, filename := range filenames {
+		seen[filename] = true
+		fi, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		state := fileState{fi}
+		if served, ok := w.served[filename]; ok && served.equal(state) {
+			delete(w.pending, filename)
+			continue
+		}
+		if pending, ok := w.pending[filename]; !rescan && (!ok || !pending.equal(state)) {
+			w.pending[filename] = state
+			continue
+		}
+		delete(w.pending, filename)
+		w.open(root, filename, state)
+	}
+}
+
+// open adds or reopens the tileset of the file filename below root.
+func (w *tilesetWatcher) open(root tileRoot, filename string, state fileState) {
+	_, reopen := w.served[filename]
+	id, err := w.ids.assign(root, filename)
+	if err != nil {
+		log.Errorf("%v", err)
+		// do not try again until the file changes
+		w.served[filename] = state
+		return
+	}
+	if err := w.svcSet.AddDBOnPath(filename, id, w.opts...); err != nil {
+		log.Errorf("%v", err)
+		if !reopen {
+			w.ids.release(filename)
+		}
+		w.served[filename] = state
+		return
+	}
+	w.served[filename] = state
+	if reopen {
+		log.Infof("reopened tiles from %q as %q", filename, id)
+	} else {
+		log.Infof("providing tiles from %q as %q", filename, id)
+	}
+}
+
+// remove removes the tileset of the file filename.
+func (w *tilesetWatcher) remove(filename string) {
+	delete(w.served, filename)
+	id, ok := w.ids.byFile[filename]
+	if !ok {
+		return
+	}
+	w.ids.release(filename)
+	if err := w.svcSet.RemoveDB(id); err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	log.Infof("stopped providing tiles from %q as %q", filename, id)
+}
+
+// uploaded records the file filename, which has been uploaded and added to
+// the ServiceSet as the tileset id, so that it is not opened again under the
+// ID derived from its filename when it is found below a tile root. It is the
+// Uploaded hook of the ServiceSet, which calls it with mu held.
+func (w *tilesetWatcher) uploaded(id, filename string) {
+	if _, err := w.ids.claim(filename, id); err != nil {
+		log.Errorf("%v", err)
+	}
+	if fi, err := os.Stat(filename); err == nil {
+		w.served[filename] = fileState{fi}
+	}
+	delete(w.pending, filename)
+	log.Infof("providing tiles from uploaded %q as %q", filename, id)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/consbio/mbtileserver/handlers"
+)
+
+// writeFiles creates the files filenames, given by slash separated paths,
+// below dir.
+func writeFiles(t *testing.T, dir string, filenames ...string) {
+	for _, name := range filenames {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindTilesets(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.mbtiles", "notes.txt", "tiles/0/0/0.png", "x/b.mbtiles", "x/y/c.mbtiles", "x/y/z/d.mbtiles")
+
+	tests := []struct {
+		maxDepth int
+		expected []string
+	}{
+		{-1, []string{"a.mbtiles", "tiles", "x/b.mbtiles", "x/y/c.mbtiles", "x/y/z/d.mbtiles"}},
+		{0, []string{"a.mbtiles", "tiles"}},
+		{1, []string{"a.mbtiles", "tiles", "x/b.mbtiles"}},
+		{2, []string{"a.mbtiles", "tiles", "x/b.mbtiles", "x/y/c.mbtiles"}},
+	}
+	for _, tc := range tests {
+		filenames, err := findTilesets(dir, tc.maxDepth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, filename := range filenames {
+			rel, _ := filepath.Rel(dir, filename)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+			t.Errorf("maxdepth %d: expected %v, got %v", tc.maxDepth, tc.expected, got)
+		}
+	}
+}
+
+func TestIDAssigner(t *testing.T) {
+	if _, err := newIDAssigner("unknown", collisionSkip); err == nil {
+		t.Error("expected error for unknown ID strategy")
+	}
+	if _, err := newIDAssigner("path", "unknown"); err == nil {
+		t.Error("expected error for unknown collision policy")
+	}
+
+	dir := filepath.FromSlash("/tiles")
+	root := tileRoot{dir: dir}
+	file := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	// nested IDs
+	ids, _ := newIDAssigner("path", collisionSkip)
+	for name, expected := range map[string]string{"a.mbtiles": "a", "x/y/b.mbtiles": "x/y/b"} {
+		if id, err := ids.assign(root, file(name)); err != nil || id != expected {
+			t.Errorf("%s: expected ID %q, got %q, %v", name, expected, id, err)
+		}
+	}
+
+	tests := []struct {
+		collisions string
+		expected   []string // IDs of b.mbtiles, x/b.mbtiles and y/b.mbtiles, "" for errors
+	}{
+		{collisionSkip, []string{"b", "", ""}},
+		{collisionError, []string{"b", "", ""}},
+		{collisionSuffix, []string{"b", "b-2", "b-3"}},
+	}
+	for _, tc := range tests {
+		ids, _ := newIDAssigner("basename", tc.collisions)
+		for i, name := range []string{"b.mbtiles", "x/b.mbtiles", "y/b.mbtiles"} {
+			id, err := ids.assign(root, file(name))
+			if id != tc.expected[i] || (err != nil) != (tc.expected[i] == "") {
+				t.Errorf("%s, %s: expected ID %q, got %q, %v", tc.collisions, name, tc.expected[i], id, err)
+			}
+		}
+		// the ID of a file is kept, until it is released
+		if id, _ := ids.assign(root, file("b.mbtiles")); id != "b" {
+			t.Errorf("%s: expected ID b to be kept, got %q", tc.collisions, id)
+		}
+		ids.release(file("b.mbtiles"))
+		if id, err := ids.assign(root, file("z/b.mbtiles")); err != nil || id != "b" {
+			t.Errorf("%s: expected released ID b, got %q, %v", tc.collisions, id, err)
+		}
+	}
+}
+
+// copyTileset copies the tileset name from the test data to dir.
+func copyTileset(t *testing.T, name, dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// waitForSize waits until svcSet serves size tilesets.
+func waitForSize(t *testing.T, svcSet *handlers.ServiceSet, size int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for svcSet.Size() != size {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d tilesets, got %d", size, svcSet.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTilesetWatcher(t *testing.T) {
+	defer func(d time.Duration) { watchSettle = d }(watchSettle)
+	watchSettle = 10 * time.Millisecond
+
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyTileset(t, "geography-class-png.mbtiles", dir)
+
+	svcSet := handlers.New()
+	defer svcSet.Close()
+	filenames, err := findTilesets(dir, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := newIDAssigner("path", collisionSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tileRoot{dir: dir}
+	for _, filename := range filenames {
+		id, err := ids.assign(root, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svcSet.AddDBOnPath(filename, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newTilesetWatcher(svcSet, []tileRoot{root}, nil, -1, ids, filenames, nil)
+	fw, err := w.notify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rescan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.watch(0, fw, rescan)
+		close(done)
+	}()
+	defer func() {
+		close(rescan)
+		<-done
+	}()
+
+	added := copyTileset(t, "geography-class-jpg.mbtiles", sub)
+	waitForSize(t, svcSet, 2)
+
+	if err := os.Remove(added); err != nil {
+		t.Fatal(err)
+	}
+	waitForSize(t, svcSet, 1)
+
+	// directories that are created later are watched as well
+	newSub := filepath.Join(dir, "new")
+	if err := os.Mkdir(newSub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	copyTileset(t, "geography-class-jpg.mbtiles", newSub)
+	waitForSize(t, svcSet, 2)
+}
+
+func TestParseTileRoot(t *testing.T) {
+	tests := []struct {
+		s    string
+		root tileRoot
+	}{
+		{"tiles", tileRoot{dir: "tiles"}},
+		{"/data/tiles=basemaps", tileRoot{dir: "/data/tiles", namespace: "basemaps"}},
+		{"tiles=/Base/Maps/", tileRoot{dir: "tiles", namespace: "base/maps"}},
+		{"a=b=c", tileRoot{dir: "a=b", namespace: "c"}},
+		{"s3://bucket/tiles?x=1=remote", tileRoot{dir: "s3://bucket/tiles?x=1", namespace: "remote"}},
+	}
+	for _, tc := range tests {
+		if root := parseTileRoot(tc.s); root != tc.root {
+			t.Errorf("%s: expected %+v, got %+v", tc.s, tc.root, root)
+		}
+	}
+
+	strategy := handlers.IDStrategies["path"]
+	dir := filepath.FromSlash("/tiles")
+	for _, tc := range []struct {
+		namespace, expected string
+	}{
+		{"", "x/a"},
+		{"basemaps", "basemaps/x/a"},
+	} {
+		id, err := tilesetID(tileRoot{dir: dir, namespace: tc.namespace}, filepath.Join(dir, "x", "a.mbtiles"), strategy)
+		if err != nil || id != tc.expected {
+			t.Errorf("namespace %q: expected ID %q, got %q, %v", tc.namespace, tc.expected, id, err)
+		}
+	}
+}
+
+func TestTilesetWatcherRoots(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	roots := []tileRoot{{dir: dirs[0]}, {dir: dirs[1], namespace: "extra"}}
+	for _, dir := range dirs {
+		copyTileset(t, "geography-class-png.mbtiles", dir)
+	}
+	svcSet := handlers.New()
+	defer svcSet.Close()
+	ids, _ := newIDAssigner("path", collisionError)
+	w := newTilesetWatcher(svcSet, roots, nil, -1, ids, nil, nil)
+
+	// expect checks the IDs of the served tilesets
+	expect := func(expected ...string) {
+		t.Helper()
+		var got []string
+		for id := range ids.byID {
+			got = append(got, id)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(expected) || svcSet.Size() != len(expected) {
+			t.Errorf("expected tilesets %v, got %v and %d tilesets", expected, got, svcSet.Size())
+		}
+	}
+
+	w.poll(true)
+	expect("extra/geography-class-png", "geography-class-png")
+
+	if err := os.Remove(filepath.Join(dirs[1], "geography-class-png.mbtiles")); err != nil {
+		t.Fatal(err)
+	}
+	copyTileset(t, "geography-class-jpg.mbtiles", dirs[0])
+	w.poll(true)
+	expect("geography-class-jpg", "geography-class-png")
+
+	// the tilesets are kept while a root is unavailable
+	if err := os.RemoveAll(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dirs[0], "geography-class-jpg.mbtiles")); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(true)
+	expect("geography-class-jpg", "geography-class-png")
+
+	if root, ok := w.rootOf(filepath.Join(dirs[1], "sub", "a.mbtiles")); !ok || root != roots[1] {
+		t.Errorf("expected root %+v, got %+v, %v", roots[1], root, ok)
+	}
+	if _, ok := w.rootOf(filepath.Dir(dirs[1])); ok {
+		t.Error("expected no root above the roots")
+	}
+}
+
+func TestTilesetWatcherRescan(t *testing.T) {
+	defer func(d time.Duration) { watchSettle = d }(watchSettle)
+	watchSettle = time.Hour
+
+	dir := t.TempDir()
+	svcSet := handlers.New()
+	defer svcSet.Close()
+	ids, _ := newIDAssigner("path", collisionSkip)
+	w := newTilesetWatcher(svcSet, []tileRoot{{dir: dir}}, nil, -1, ids, nil, nil)
+
+	// polled files are opened once they are unchanged since the last poll
+	copyTileset(t, "geography-class-png.mbtiles", dir)
+	w.poll(false)
+	if svcSet.Size() != 0 {
+		t.Fatalf("expected pending tileset, got %d tilesets", svcSet.Size())
+	}
+
+	// a rescan, as requested on SIGHUP, opens them right away
+	rescan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.watch(0, nil, rescan)
+		close(done)
+	}()
+	defer func() {
+		close(rescan)
+		<-done
+	}()
+	copyTileset(t, "geography-class-jpg.mbtiles", dir)
+	rescan <- struct{}{}
+	waitForSize(t, svcSet, 2)
+}
+
+func TestTilesetWatcherUpload(t *testing.T) {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDir, "geography-class-png.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svcSet := handlers.New()
+	defer svcSet.Close()
+	ids, _ := newIDAssigner("basename", collisionSuffix)
+	w := newTilesetWatcher(svcSet, []tileRoot{{dir: dir, namespace: "ns"}}, nil, -1, ids, nil, nil)
+	svcSet.AdminKey = "secret"
+	svcSet.UploadDir = dir
+	svcSet.UploadLock = &w.mu
+	svcSet.Uploaded = w.uploaded
+
+	req := httptest.NewRequest("POST", "/admin/tilesets?id=uploads/new", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	svcSet.AdminHandler(nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+
+	// the watcher neither serves the uploaded file under a second ID nor
+	// replaces it
+	for i := 0; i < 2; i++ {
+		w.poll(i == 0)
+		if id := ids.byFile[filepath.Join(dir, "uploads", "new.mbtiles")]; id != "uploads/new" || len(ids.byID) != 1 || svcSet.Size() != 1 {
+			t.Fatalf("expected only the uploaded tileset, got ID %q, %v and %d tilesets", id, ids.byID, svcSet.Size())
+		}
+	}
+
+	// it is removed like the other files
+	if err := os.Remove(filepath.Join(dir, "uploads", "new.mbtiles")); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(true)
+	if svcSet.Size() != 0 {
+		t.Errorf("expected removed upload, got %d tilesets", svcSet.Size())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/consbio/mbtileserver/mbtiles"
+)
+
+var (
+	validateJSON   bool
+	validateStrict bool
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <file.mbtiles>...",
+	Short: "Check mbtiles files against the mbtiles specification",
+	Long: `Validate checks the schema, metadata, tile formats, zoom levels and bounds of
+mbtiles files against version 1.3 of the mbtiles specification and prints the
+problems it finds. It exits with status 1 if any file has errors, or warnings
+with --strict.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatalln("At least one mbtiles file is required")
+		}
+		failed := false
+		for _, filename := range args {
+			violations, err := mbtiles.Validate(context.Background(), filename)
+			if err != nil {
+				log.Fatalf("Could not validate %s: %v", filename, err)
+			}
+			if err := printViolations(filename, violations); err != nil {
+				log.Fatalln(err)
+			}
+			for _, v := range violations {
+				if v.Severity == mbtiles.SeverityError || validateStrict {
+					failed = true
+				}
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	validateCmd.Flags().BoolVar(&validateJSON, "json", false, "Print the problems as JSON")
+	validateCmd.Flags().BoolVar(&validateStrict, "strict", false, "Fail on warnings, too")
+	RootCmd.AddCommand(validateCmd)
+}
+
+func printViolations(filename string, violations []mbtiles.Violation) error {
+	if validateJSON {
+		if violations == nil {
+			violations = []mbtiles.Violation{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Filename   string              `json:"filename"`
+			Violations []mbtiles.Violation `json:"violations"`
+		}{filename, violations})
+	}
+	if len(violations) == 0 {
+		fmt.Printf("%s: ok\n", filename)
+		return nil
+	}
+	fmt.Printf("%s:\n", filename)
+	for _, v := range violations {
+		fmt.Printf("  %s\n", v)
+	}
+	return nil
+}